@@ -0,0 +1,182 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// issuanceStoragePrefix holds a record of every issued token while an audit
+// key is configured. Entries are keyed by zero-padded issue time in
+// nanoseconds followed by the jti, so listing returns them in issue order.
+const issuanceStoragePrefix = "issuance/"
+
+// maxAuditExportRecords bounds the records in one exported bundle
+const maxAuditExportRecords = 10000
+
+// auditReceiptsType is the JWS typ header of exported bundles
+const auditReceiptsType = "audit-receipts+jwt"
+
+// issuanceRecord describes an issued token: who delegated what to which actor
+type issuanceRecord struct {
+	JTI           string    `json:"jti"`
+	IssuedAt      time.Time `json:"issued_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	Role          string    `json:"role"`
+	Subject       string    `json:"subject"`
+	Actor         string    `json:"actor"`
+	ActorEntityID string    `json:"actor_entity_id"`
+	Audience      any       `json:"audience,omitempty"`
+	Scope         string    `json:"scope,omitempty"`
+	KeyID         string    `json:"key_id"`
+}
+
+// issuanceStorageKey returns the storage key of a record
+func issuanceStorageKey(issuedAt time.Time, jti string) string {
+	return fmt.Sprintf("%s%020d-%s", issuanceStoragePrefix, issuedAt.UnixNano(), jti)
+}
+
+// issuanceKeyTime returns the issue time encoded in a listed record key
+func issuanceKeyTime(key string) (time.Time, bool) {
+	nanos, _, ok := strings.Cut(key, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// putIssuanceRecord writes an issuance record to storage
+func putIssuanceRecord(ctx context.Context, storage logical.Storage, record *issuanceRecord) error {
+	entry, err := logical.StorageEntryJSON(issuanceStorageKey(record.IssuedAt, record.JTI), record)
+	if err != nil {
+		return fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write issuance record: %w", err)
+	}
+
+	return nil
+}
+
+// listIssuanceRecords returns the records issued in [start, end), oldest
+// first. It fails once more than limit records match.
+func listIssuanceRecords(ctx context.Context, storage logical.Storage, start, end time.Time, limit int) ([]*issuanceRecord, error) {
+	keys, err := storage.List(ctx, issuanceStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list issuance records: %w", err)
+	}
+	sort.Strings(keys)
+
+	records := []*issuanceRecord{}
+	for _, key := range keys {
+		issuedAt, ok := issuanceKeyTime(key)
+		if !ok || issuedAt.Before(start) {
+			continue
+		}
+		if !issuedAt.Before(end) {
+			break
+		}
+		if len(records) == limit {
+			return nil, fmt.Errorf("more than %d issuance records in range", limit)
+		}
+
+		entry, err := storage.Get(ctx, issuanceStoragePrefix+key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read issuance record: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		record := &issuanceRecord{}
+		if err := entry.DecodeJSON(record); err != nil {
+			return nil, fmt.Errorf("failed to decode issuance record: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// tidyIssuanceRecords deletes issuance records older than the configured
+// retention and returns how many were deleted
+func (b *Backend) tidyIssuanceRecords(ctx context.Context, storage logical.Storage) (int, error) {
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil {
+		return 0, nil
+	}
+
+	keys, err := storage.List(ctx, issuanceStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list issuance records: %w", err)
+	}
+
+	deleted := 0
+	cutoff := time.Now().Add(-config.recordRetention(recordTypeIssuance))
+	for _, key := range keys {
+		issuedAt, ok := issuanceKeyTime(key)
+		if !ok || !issuedAt.Before(cutoff) {
+			continue
+		}
+
+		if err := storage.Delete(ctx, issuanceStoragePrefix+key); err != nil {
+			return deleted, fmt.Errorf("failed to delete issuance record: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// signAuditReceipts signs the records as a JWT with the audit key. The
+// claims identify the bundle and the time range it covers.
+func signAuditReceipts(config *Config, key *Key, signingKey any, start, end time.Time, records []*issuanceRecord) (string, string, error) {
+	algorithm, err := signatureAlgorithm(key.Algorithm)
+	if err != nil {
+		return "", "", err
+	}
+
+	signerOpts := (&jose.SignerOptions{}).WithType(auditReceiptsType).WithHeader("kid", key.KeyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: signingKey}, signerOpts)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	bundleID, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate bundle id: %w", err)
+	}
+
+	claims := map[string]any{
+		"iss":          config.Issuer,
+		"iat":          time.Now().Unix(),
+		"jti":          bundleID,
+		"start":        start.UTC().Format(time.RFC3339Nano),
+		"end":          end.UTC().Format(time.RFC3339Nano),
+		"record_count": len(records),
+		"records":      records,
+	}
+
+	bundle, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to serialize audit receipts: %w", err)
+	}
+
+	return bundle, bundleID, nil
+}
@@ -3,20 +3,154 @@ package tokenexchange
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-// Backend implements the logical.Backend interface for token exchange
+// Backend implements the logical.Backend interface for token exchange.
+//
+// Locking strategy:
+//   - writeLock serializes config and role writes so their check-and-set
+//     versions cannot race. It is taken before lock, never while holding it.
+//   - lock serializes key mutations (create, rotate, delete) so concurrent
+//     writers cannot lose key versions. It may be held while cacheLock is
+//     taken, never the other way round.
+//   - cacheLock guards the caches and cacheGeneration. It is a leaf lock: it
+//     is never held across storage or network I/O and no other lock is taken
+//     while holding it. Cached values are immutable once stored; readers get
+//     copies.
+//   - Caches are filled lazily on first use. A fill records cacheGeneration
+//     before reading storage and is discarded if a reset happened meanwhile,
+//     so a slow read cannot resurrect state that was just invalidated.
+//   - drainLock, replayLock and refreshLock are leaf locks guarding shutdown
+//     state, replay records and refresh handles. stats, rateLimiter and usage use their own internal
+//     leaf locks.
+//
+// All state lives on the Backend, and Factory creates one per mount, so a
+// multiplexed plugin process shares nothing mutable between mounts. Read
+// paths are served from storage and caches kept coherent by invalidate, so
+// they work on performance standbys without forwarding.
 type Backend struct {
 	*framework.Backend
 
-	// lock protects access to backend fields
-	lock sync.RWMutex
+	// writeLock serializes config and role writes
+	writeLock sync.Mutex
+
+	// lock serializes key mutations
+	lock sync.Mutex
+
+	// cacheLock protects the in-memory caches below
+	cacheLock sync.RWMutex
+
+	// cacheGeneration is incremented whenever a cache is reset
+	cacheGeneration uint64
+
+	// directoryCache caches directory enrichment lookups
+	directoryCache map[string]*directoryCacheEntry
+
+	// cachedConfig caches the plugin configuration read from storage
+	cachedConfig *Config
+
+	// keyCache caches named signing keys read from storage
+	keyCache map[string]*Key
+
+	// jwksCache caches subject JWKS documents by URI
+	jwksCache map[string]*jwksCacheEntry
+
+	// encryptionJWKSCache caches audience encryption JWKS documents by URI
+	encryptionJWKSCache map[string]*jwksCacheEntry
+
+	// oidcDiscoveryCache caches the subject jwks_uri discovered by issuer
+	oidcDiscoveryCache map[string]*oidcDiscoveryEntry
+
+	// policyCache caches compiled role policies by role name
+	policyCache map[string]*compiledPolicy
+
+	// jwksClient is the HTTP client for subject JWKS fetches, built from the config
+	jwksClient *http.Client
+
+	// vaultAPIClient is the HTTP client for token lookups through api_addr,
+	// built from the config
+	vaultAPIClient *http.Client
+
+	// authorizationWebhookClient is the HTTP client for authorization webhook
+	// calls, built from the config
+	authorizationWebhookClient *http.Client
+
+	// jwksBreaker stops subject JWKS fetches while the issuer is failing
+	jwksBreaker *circuitBreaker
+
+	// identitySlots bounds concurrent identity store lookups
+	identitySlots chan struct{}
+
+	// identityBreaker stops identity store lookups while they are failing
+	identityBreaker *circuitBreaker
+
+	// jwksStatus records subject JWKS fetch health by URI. It is guarded by
+	// cacheLock but survives cache resets so history is not lost on config writes.
+	jwksStatus map[string]*subjectJWKSStatus
+
+	// drainLock protects closing and additions to inflight
+	drainLock sync.Mutex
+	closing   bool
+
+	// inflight tracks token exchanges that are in progress
+	inflight sync.WaitGroup
+
+	// stopCh is closed on shutdown to stop periodic workers
+	stopCh chan struct{}
+
+	// cleanupFuncs flush buffered state, such as the usage counters, once
+	// in-flight exchanges have drained
+	cleanupFuncs []func(context.Context)
+
+	// stats holds the counters reported by the metrics endpoint
+	stats *mountStats
+
+	// rateLimiter enforces max_exchanges_per_minute
+	rateLimiter *rateLimiter
+
+	// usage counts issued tokens per role and entity, for max_tokens_per_day
+	usage *usageTracker
+
+	// storage is the mount's storage, for persisting buffered state outside
+	// of requests
+	storage logical.Storage
+
+	// replayLock serializes single-use subject token checks on this node
+	replayLock sync.Mutex
+
+	// refreshLock serializes refresh handle use on this node
+	refreshLock sync.Mutex
+
+	// tidyLock stops periodic and requested tidies overlapping on this node
+	tidyLock sync.Mutex
+
+	// subjectValidators verify subject tokens, in the order they are tried
+	subjectValidators []SubjectTokenValidator
+
+	// pipeline holds the ordered token exchange hooks
+	pipeline *exchangePipeline
+
+	// simulationPipeline holds the hooks run for simulated exchanges
+	simulationPipeline *exchangePipeline
+
+	// refreshPipeline holds the hooks run for token refreshes
+	refreshPipeline *exchangePipeline
+
+	// backendUUID identifies this mount to Vault, e.g. for managed key access
+	backendUUID string
 }
 
+// shutdownDrainTimeout bounds how long Clean waits for in-flight exchanges
+const shutdownDrainTimeout = 30 * time.Second
+
 // Factory creates a new Backend instance
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
 	if conf == nil {
@@ -28,13 +162,45 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	b.backendUUID = conf.BackendUUID
+	b.storage = conf.StorageView
+
+	// Usage counters of roles without a quota are only persisted by the
+	// periodic func, so persist them before the plugin goes away
+	b.registerCleanup(func(ctx context.Context) {
+		if b.storage == nil || !b.writesReplicatedStorage() {
+			return
+		}
+		if err := b.flushUsage(ctx, b.storage); err != nil {
+			b.Logger().Warn("failed to persist role usage on shutdown", "error", err)
+		}
+	})
 
 	return b, nil
 }
 
 // NewBackend creates a new Backend with paths and configuration
 func NewBackend() *Backend {
-	b := &Backend{}
+	b := &Backend{
+		directoryCache:      make(map[string]*directoryCacheEntry),
+		keyCache:            make(map[string]*Key),
+		jwksCache:           make(map[string]*jwksCacheEntry),
+		encryptionJWKSCache: make(map[string]*jwksCacheEntry),
+		oidcDiscoveryCache:  make(map[string]*oidcDiscoveryEntry),
+		policyCache:         make(map[string]*compiledPolicy),
+		jwksStatus:          make(map[string]*subjectJWKSStatus),
+		stopCh:              make(chan struct{}),
+		stats:               newMountStats(),
+		rateLimiter:         newRateLimiter(),
+		usage:               newUsageTracker(),
+		jwksBreaker:         newCircuitBreaker(jwksBreakerThreshold, jwksBreakerCooldown),
+		identitySlots:       make(chan struct{}, maxConcurrentIdentityLookups),
+		identityBreaker:     newCircuitBreaker(identityBreakerThreshold, identityBreakerCooldown),
+	}
+	b.subjectValidators = defaultSubjectTokenValidators(b)
+	b.pipeline = b.newExchangePipeline()
+	b.simulationPipeline = b.newSimulationPipeline()
+	b.refreshPipeline = b.newRefreshPipeline()
 
 	b.Backend = &framework.Backend{
 		Help: "The token exchange plugin implements OAuth 2.0 Token Exchange (RFC 8693) " +
@@ -44,31 +210,206 @@ func NewBackend() *Backend {
 		// Register all path handlers
 		Paths: []*framework.Path{
 			pathConfig(b),
+			pathConfigDirectory(b),
 			pathRole(b),
 			pathRoleList(b),
+			pathRolePreview(b),
+			pathRoleStats(b),
+			pathTemplateLibrary(b),
+			pathTemplateLibraryList(b),
+			pathScopes(b),
+			pathScopesList(b),
 			pathToken(b),
-			pathKey(b),     // New: key CRUD
-			pathKeyList(b), // New: key listing
-			pathJWKS(b),    // New: JWKS endpoint
+			pathTokenRefresh(b),
+			pathKey(b),            // New: key CRUD
+			pathKeyRotate(b),      // Key version rotation
+			pathKeyCertificate(b), // Key certificates for x5c
+			pathKeyConfig(b),      // Enabling and disabling keys
+			pathKeyVersionList(b), // Key version history
+			pathKeyVersion(b),     // Key version metadata
+			pathKeyList(b),        // New: key listing
+			pathJWKS(b),           // New: JWKS endpoint
+			pathTenantJWKS(b),     // JWKS of a tenant's keys
+			pathDiscovery(b),      // OIDC discovery of the JWKS
+			pathTenantDiscovery(b),
+			pathSelfTest(b),
+			pathMetrics(b),
+			pathStatus(b),
+			pathIntrospect(b),
+			pathTidy(b),
+			pathSubjectJWKSStatus(b),
+			pathAuditExport(b),
+			pathIssuanceList(b),
+			pathIssuance(b),
+			pathSimulateBatch(b),
+			pathExport(b),
+			pathImport(b),
+			pathImportWrappingKey(b),
 		},
 
 		// Define paths that should be encrypted in storage
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
-				"config",  // Config contains signing keys
-				"roles/*", // Roles may contain sensitive templates
-				"keys/*",  // Named keys contain private keys (NEW)
+				"config",              // Config contains signing keys
+				"config/directory",    // Directory config contains bind credentials
+				"roles/*",             // Roles may contain sensitive templates
+				"keys/*",              // Named keys contain private keys (NEW)
+				"import/wrapping_key", // Unwraps imported private keys
 			},
 			Unauthenticated: []string{
-				"jwks",    // JWKS endpoint must be publicly accessible for JWT verification
+				"jwks",                             // JWKS endpoint must be publicly accessible for JWT verification
+				".well-known/openid-configuration", // Discovery of the JWKS by federating clouds
+				"jwks/*",                           // Tenant JWKS
+				"tenant/+/.well-known/openid-configuration", // Discovery of tenant JWKS
 			},
 		},
 
 		// Secrets: Not used for this plugin (generates tokens, doesn't manage secrets)
-		// InvalidateFunc: Not needed initially
+
+		// Keep in-memory caches coherent when storage changes on another node
+		Invalidate: b.invalidate,
+
+		InitializeFunc: b.initialize,
+
+		// Rotate due keys, expire rate limit windows and tidy expired state
+		PeriodicFunc: b.periodic,
+
+		// Finish or roll back key operations interrupted by a crash
+		WALRollback:       b.walRollback,
+		WALRollbackMinAge: walRollbackMinAge,
+
+		Clean: b.cleanup,
 
 		BackendType: logical.TypeLogical,
 	}
 
 	return b
 }
+
+// invalidate clears cached state derived from the changed storage key. Vault
+// calls it on performance standbys and replicated clusters when storage is
+// written elsewhere.
+func (b *Backend) invalidate(ctx context.Context, key string) {
+	switch {
+	case key == configStoragePath:
+		b.resetConfigCache()
+	case key == directoryConfigStoragePath:
+		b.resetDirectoryCache()
+	case strings.HasPrefix(key, keyStoragePrefix):
+		// The JWKS is built from keys, so this also covers published public keys
+		b.resetKeyCache(strings.TrimPrefix(key, keyStoragePrefix))
+	case strings.HasPrefix(key, roleStoragePrefix):
+		b.resetPolicyCache(strings.TrimPrefix(key, roleStoragePrefix))
+	case strings.HasPrefix(key, jwksCacheStoragePrefix):
+		b.resetSubjectJWKSCache(key)
+	case strings.HasPrefix(key, usageStoragePrefix):
+		// Counters this node has not persisted yet would be lost. Entity
+		// counters are stored under their role's key.
+		role, _, _ := strings.Cut(strings.TrimPrefix(key, usageStoragePrefix), "/")
+		if b.storage != nil && b.writesReplicatedStorage() {
+			if err := b.flushUsage(ctx, b.storage, role); err != nil {
+				b.Logger().Warn("failed to persist role usage", "role", role, "error", err)
+			}
+		}
+		b.usage.drop(role)
+	}
+}
+
+// writesReplicatedStorage reports whether this node may write the mount's
+// storage. Performance standbys and performance secondaries serve reads from
+// storage written by the primary's active node, so background work that
+// writes storage is left to that node.
+func (b *Backend) writesReplicatedStorage() bool {
+	state := b.System().ReplicationState()
+	return !state.HasState(consts.ReplicationPerformanceStandby) && !state.HasState(consts.ReplicationPerformanceSecondary)
+}
+
+// beginExchange registers an in-flight exchange. It returns false once the
+// backend is shutting down and no new exchanges should start.
+func (b *Backend) beginExchange() bool {
+	b.drainLock.Lock()
+	defer b.drainLock.Unlock()
+
+	if b.closing {
+		return false
+	}
+
+	b.inflight.Add(1)
+	return true
+}
+
+// endExchange marks an in-flight exchange as finished
+func (b *Backend) endExchange() {
+	b.inflight.Done()
+}
+
+// registerCleanup adds a function that is run on shutdown after in-flight
+// exchanges have drained, e.g. to persist buffered counters
+func (b *Backend) registerCleanup(f func(context.Context)) {
+	b.drainLock.Lock()
+	defer b.drainLock.Unlock()
+
+	b.cleanupFuncs = append(b.cleanupFuncs, f)
+}
+
+// periodic runs the backend's background maintenance
+func (b *Backend) periodic(ctx context.Context, req *logical.Request) error {
+	b.rateLimiter.prune(time.Now())
+
+	if !b.writesReplicatedStorage() {
+		return nil
+	}
+
+	if err := b.flushUsage(ctx, req.Storage); err != nil {
+		b.Logger().Warn("failed to persist role usage", "error", err)
+	}
+
+	// Tidy lists whole storage prefixes, so it runs every tidy_interval
+	// rather than on every tick
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Warn("failed to read config for tidy", "error", err)
+	} else if due, err := tidyDue(ctx, req.Storage, config.tidyInterval()); err != nil {
+		b.Logger().Warn("failed to read tidy status", "error", err)
+	} else if due {
+		if _, err := b.tidy(ctx, req.Storage, config.tidySafetyBuffer()); err != nil {
+			b.Logger().Warn("failed to tidy expired state", "error", err)
+		}
+	}
+
+	return b.rotateDueKeys(ctx, req)
+}
+
+// cleanup is called by Vault when the plugin is unmounted or reloaded. It
+// stops periodic workers, waits for in-flight exchanges to finish (bounded by
+// shutdownDrainTimeout or the context deadline) and then runs cleanup funcs.
+func (b *Backend) cleanup(ctx context.Context) {
+	b.drainLock.Lock()
+	if b.closing {
+		b.drainLock.Unlock()
+		return
+	}
+	b.closing = true
+	close(b.stopCh)
+	cleanupFuncs := b.cleanupFuncs
+	b.drainLock.Unlock()
+
+	drainCtx, cancel := context.WithTimeout(ctx, shutdownDrainTimeout)
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-drainCtx.Done():
+		b.Logger().Warn("timed out waiting for in-flight token exchanges to finish")
+	}
+
+	for _, f := range cleanupFuncs {
+		f(ctx)
+	}
+}
@@ -2,9 +2,13 @@ package tokenexchange
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
 )
@@ -61,3 +65,341 @@ func TestBackend_Help(t *testing.T) {
 	require.NotEmpty(t, b.Help, "Backend should provide help text")
 	require.Contains(t, b.Help, "token exchange", "Help should mention token exchange")
 }
+
+// TestBackend_CleanupDrainsInflightExchanges tests that cleanup waits for
+// in-flight exchanges before running cleanup funcs
+func TestBackend_CleanupDrainsInflightExchanges(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	flushed := make(chan struct{})
+	b.registerCleanup(func(ctx context.Context) {
+		close(flushed)
+	})
+
+	require.True(t, b.beginExchange(), "Exchanges should start before shutdown")
+
+	done := make(chan struct{})
+	go func() {
+		b.Cleanup(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Cleanup should wait for the in-flight exchange")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	b.endExchange()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cleanup should return once the exchange finishes")
+	}
+
+	select {
+	case <-flushed:
+	default:
+		t.Fatal("Cleanup funcs should run after draining")
+	}
+
+	select {
+	case <-b.stopCh:
+	default:
+		t.Fatal("Periodic workers should be signalled to stop")
+	}
+}
+
+// TestBackend_CleanupPersistsUsage tests that usage counters buffered in
+// memory are persisted on shutdown
+func TestBackend_CleanupPersistsUsage(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	entry, err := storage.Get(context.Background(), usageStoragePrefix+"test-role")
+	require.NoError(t, err)
+	require.Nil(t, entry, "roles without a quota buffer their counters")
+
+	b.Cleanup(context.Background())
+
+	// A new backend on the same storage sees the counters
+	restarted, err := Factory(context.Background(), &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), readRoleStats(t, restarted.(*Backend), storage)["tokens_issued_total"])
+}
+
+// TestBackend_CleanupRejectsNewExchanges tests that no exchanges start after shutdown
+func TestBackend_CleanupRejectsNewExchanges(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	b.Cleanup(context.Background())
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"subject_token": "token",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "shutting down")
+}
+
+// TestBackend_CleanupDrainDeadline tests that cleanup gives up at the context deadline
+func TestBackend_CleanupDrainDeadline(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	require.True(t, b.beginExchange())
+	defer b.endExchange()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		b.Cleanup(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cleanup should stop waiting at the deadline")
+	}
+}
+
+// TestBackend_InvalidateClearsCaches tests that storage invalidations from
+// other nodes reset cached config and keys
+func TestBackend_InvalidateClearsCaches(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	createTestKey(t, b, storage, "test-key")
+	configReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]any{
+			"issuer":           "https://vault.example.com",
+			"subject_jwks_uri": "https://idp.example.com/jwks",
+		},
+	}
+	_, err := b.HandleRequest(ctx, configReq)
+	require.NoError(t, err)
+
+	// Populate the caches
+	_, err = b.getConfig(ctx, storage)
+	require.NoError(t, err)
+	_, err = b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+
+	// Simulate writes replicated from the active node
+	entry, err := logical.StorageEntryJSON(configStoragePath, &Config{Issuer: "https://replicated.example.com"})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+	require.NoError(t, storage.Delete(ctx, keyStoragePrefix+"test-key"))
+
+	config, err := b.getConfig(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, "https://vault.example.com", config.Issuer, "Config should be served from cache")
+
+	b.InvalidateKey(ctx, configStoragePath)
+	b.InvalidateKey(ctx, keyStoragePrefix+"test-key")
+
+	config, err = b.getConfig(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, "https://replicated.example.com", config.Issuer)
+
+	key, err := b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+	require.Nil(t, key, "Deleted key should no longer be served from cache")
+}
+
+// pausingStorage pauses the first read of pauseKey, after the value has been
+// read, until release is closed
+type pausingStorage struct {
+	logical.Storage
+
+	pauseKey string
+	once     sync.Once
+	paused   chan struct{}
+	release  chan struct{}
+}
+
+func (s *pausingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	entry, err := s.Storage.Get(ctx, key)
+	if key == s.pauseKey {
+		s.once.Do(func() {
+			close(s.paused)
+			<-s.release
+		})
+	}
+	return entry, err
+}
+
+// TestBackend_CacheFillDoesNotResurrectStaleKey tests that a cache fill racing
+// with a rotation does not cache the pre-rotation key
+func TestBackend_CacheFillDoesNotResurrectStaleKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	createTestKey(t, b, storage, "test-key")
+	b.resetKeyCache("test-key")
+
+	paused := &pausingStorage{
+		Storage:  storage,
+		pauseKey: keyStoragePrefix + "test-key",
+		paused:   make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+
+	result := make(chan *Key, 1)
+	go func() {
+		key, _ := b.getKey(ctx, paused, "test-key")
+		result <- key
+	}()
+
+	// Rotate while the reader holds version 1 but has not filled the cache
+	<-paused.paused
+	rotated, err := b.rotateKey(ctx, storage, "test-key", rotationTriggerManual, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, rotated.Version)
+
+	close(paused.release)
+	stale := <-result
+	require.Equal(t, 1, stale.Version)
+
+	key, err := b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version, "Stale read must not be cached over the rotation")
+}
+
+// TestBackend_ConcurrentExchangeRotateInvalidate exercises exchanges, key
+// rotation, invalidation and cache readers concurrently. Run with -race.
+func TestBackend_ConcurrentExchangeRotateInvalidate(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	run := func(iterations int, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if err := f(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	request := func(op logical.Operation, path string, data map[string]any) func() error {
+		return func() error {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: op,
+				Path:      path,
+				Storage:   storage,
+				EntityID:  "test-entity",
+				Data:      data,
+			})
+			if err != nil {
+				return err
+			}
+			if resp != nil && resp.IsError() {
+				return resp.Error()
+			}
+			return nil
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		run(10, request(logical.UpdateOperation, "token/test-role", map[string]any{"subject_token": subjectToken}))
+	}
+	run(4, request(logical.UpdateOperation, "key/test-key/rotate", nil))
+	run(10, request(logical.ReadOperation, "jwks", nil))
+	run(10, request(logical.ReadOperation, "metrics", nil))
+	run(20, func() error {
+		b.InvalidateKey(ctx, configStoragePath)
+		b.InvalidateKey(ctx, directoryConfigStoragePath)
+		b.InvalidateKey(ctx, keyStoragePrefix+"test-key")
+		return nil
+	})
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	key, err := b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+	require.Equal(t, 5, key.Version, "Every rotation should be applied")
+}
+
+// TestBackend_PerformanceStandby tests that a performance standby serves the
+// JWKS locally but leaves storage writes to the active node
+func TestBackend_PerformanceStandby(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	active, err := Factory(context.Background(), &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+	})
+	require.NoError(t, err)
+	standby, err := Factory(context.Background(), &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      &logical.StaticSystemView{ReplicationStateVal: consts.ReplicationPerformanceStandby},
+		StorageView: storage,
+	})
+	require.NoError(t, err)
+	b := standby.(*Backend)
+	require.True(t, active.(*Backend).writesReplicatedStorage())
+	require.False(t, b.writesReplicatedStorage())
+
+	ctx := context.Background()
+	_, err = active.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/auto-key",
+		Storage:   storage,
+		Data:      map[string]any{"rotation_period": "1h"},
+	})
+	require.NoError(t, err)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "jwks", Storage: storage})
+	require.NoError(t, err)
+	require.Len(t, extractJWKSFromResponse(t, resp)["keys"], 1)
+
+	// A due rotation is left to the active node
+	key, err := b.getKey(ctx, storage, "auto-key")
+	require.NoError(t, err)
+	key.RotatedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, b.putKey(ctx, storage, key))
+	require.NoError(t, b.periodic(ctx, &logical.Request{Storage: storage}))
+	key, err = b.getKey(ctx, storage, "auto-key")
+	require.NoError(t, err)
+	require.Equal(t, 1, key.Version)
+
+	// Only exchanges are forwarded
+	for _, path := range b.Paths {
+		for op, handler := range path.Operations {
+			forward := handler.Properties().ForwardPerformanceStandby
+			require.Equal(t, strings.HasPrefix(path.Pattern, "^token/") && op == logical.UpdateOperation, forward, "%s %s", op, path.Pattern)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultTokenLookupTimeout bounds a lookup of the caller's token
+const defaultTokenLookupTimeout = 10 * time.Second
+
+// tokenLookupPattern matches the identity.token fields that are only known
+// by looking the caller's token up
+var tokenLookupPattern = regexp.MustCompile(`identity\.token\.(policies|path|type|meta)`)
+
+// callerToken describes the Vault token an exchange was made with, so actor
+// templates can encode how the agent authenticated
+type callerToken struct {
+	DisplayName   string            `json:"display_name"`
+	Accessor      string            `json:"accessor"`
+	MountAccessor string            `json:"mount_accessor"`
+	MountType     string            `json:"mount_type"`
+	Policies      []string          `json:"policies"`
+	Path          string            `json:"path"`
+	Type          string            `json:"type"`
+	Meta          map[string]string `json:"meta"`
+}
+
+// callerTokenContext returns the identity.token data of actor templates
+func callerTokenContext(token *callerToken) map[string]any {
+	if token == nil {
+		token = &callerToken{}
+	}
+
+	// Render unknown policies and meta as empty rather than null
+	policies := token.Policies
+	if policies == nil {
+		policies = []string{}
+	}
+	meta := token.Meta
+	if meta == nil {
+		meta = map[string]string{}
+	}
+
+	return map[string]any{
+		"display_name":   token.DisplayName,
+		"accessor":       token.Accessor,
+		"mount_accessor": token.MountAccessor,
+		"mount_type":     token.MountType,
+		"policies":       policies,
+		"path":           token.Path,
+		"type":           token.Type,
+		"meta":           meta,
+	}
+}
+
+// usesTokenLookup reports whether a template references the identity.token
+// fields that require looking the caller's token up
+func usesTokenLookup(template string) bool {
+	return tokenLookupPattern.MatchString(template)
+}
+
+// enrichCallerToken describes the caller's token for the actor template. The
+// display name, accessor and mount come with the request. Policies, path, type
+// and meta are only resolved when the actor template references them, from the
+// token entry Vault passes to plugins running in its process or the request's
+// auth. Looking the token up through api_addr with token_lookup_token is only
+// done when the config sets token_lookup_enabled.
+func (b *Backend) enrichCallerToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	ex.callerToken = &callerToken{
+		DisplayName:   ex.req.DisplayName,
+		Accessor:      ex.req.ClientTokenAccessor,
+		MountAccessor: ex.req.MountAccessor,
+		MountType:     ex.req.MountType,
+	}
+
+	uses := usesTokenLookup(ex.role.ActorTemplate)
+	for _, fragment := range ex.fragments {
+		uses = uses || usesTokenLookup(fragment.ActorTemplate)
+	}
+	if !uses {
+		return nil, nil
+	}
+
+	if entry := ex.req.TokenEntry(); entry != nil {
+		ex.callerToken.Policies = entry.Policies
+		ex.callerToken.Path = entry.Path
+		ex.callerToken.Type = entry.Type.String()
+		ex.callerToken.Meta = entry.Meta
+		return nil, nil
+	}
+	if auth := ex.req.Auth; auth != nil {
+		ex.callerToken.Policies = auth.Policies
+		ex.callerToken.Path = auth.CreationPath
+		ex.callerToken.Type = auth.TokenType.String()
+		ex.callerToken.Meta = auth.Metadata
+		return nil, nil
+	}
+
+	if !ex.config.TokenLookupEnabled {
+		return exchangeError(ErrCodeServerError, "the actor template uses identity.token, which Vault did not pass with the request; set token_lookup_enabled in the config to look the token up through api_addr"), nil
+	}
+	if ex.req.ClientTokenAccessor == "" {
+		return exchangeError(ErrCodeInvalidRequest, "the actor template uses identity.token, which cannot be looked up for tokens without an accessor such as batch tokens"), nil
+	}
+	client, err := b.getVaultAPIClient(ex.config)
+	if err != nil {
+		return nil, err
+	}
+	looked, err := lookupTokenAccessor(ctx, client, ex.config.APIAddr, ex.config.TokenLookupToken, ex.req.ClientTokenAccessor)
+	if err != nil {
+		return exchangeError(ErrCodeUpstreamError, "failed to look up the calling token: %v", err), nil
+	}
+	ex.callerToken.Policies = looked.Policies
+	ex.callerToken.Path = looked.Path
+	ex.callerToken.Type = looked.Type
+	ex.callerToken.Meta = looked.Meta
+	return nil, nil
+}
+
+// lookupTokenAccessor looks a token up by its accessor through Vault's API
+// at addr. Plugins cannot read other tokens through the SystemView.
+func lookupTokenAccessor(ctx context.Context, client *http.Client, addr, vaultToken, accessor string) (*callerToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTokenLookupTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{"accessor": accessor})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token lookup: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+"/v1/auth/token/lookup-accessor", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token lookup: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", vaultToken)
+	req.Header.Set("X-Vault-Request", "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultAPIResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token lookup response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token lookup failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	envelope := struct {
+		Data callerToken `json:"data"`
+	}{}
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode token lookup response: %w", err)
+	}
+	return &envelope.Data, nil
+}
@@ -0,0 +1,114 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallerTokenTemplate tests that actor templates can use the policies,
+// path, display name and mount of the caller's Vault token
+func TestCallerTokenTemplate(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"actor_template": `{"act": {"sub": "{{identity.token.display_name}}"}, "actor_auth": {"path": "{{identity.token.path}}", "policies": {{identity.token.policies}}, "team": "{{identity.token.meta.team}}"}, "mount_type": "{{identity.token.mount_type}}"}`,
+	})
+
+	exchange := func(entry *logical.TokenEntry, auth *logical.Auth) *logical.Response {
+		req := &logical.Request{
+			Operation:           logical.UpdateOperation,
+			Path:                "token/test-role",
+			Storage:             storage,
+			EntityID:            "test-entity",
+			DisplayName:         "approle-agent",
+			ClientTokenAccessor: "accessor-123",
+			MountType:           "vault-plugin-identity-delegation",
+			Auth:                auth,
+			Data:                map[string]any{"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims())},
+		}
+		req.SetTokenEntry(entry)
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// Vault passes the token entry to plugins running in its process
+	resp := exchange(&logical.TokenEntry{
+		Policies: []string{"default", "agents"},
+		Path:     "auth/approle/login",
+		Meta:     map[string]string{"team": "platform"},
+	}, nil)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "approle-agent", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "vault-plugin-identity-delegation", claims["mount_type"])
+	require.Equal(t, map[string]any{
+		"path":     "auth/approle/login",
+		"policies": []any{"default", "agents"},
+		"team":     "platform",
+	}, claims["actor_auth"])
+
+	// Or with the request's auth
+	resp = exchange(nil, &logical.Auth{
+		Policies:     []string{"default"},
+		CreationPath: "auth/userpass/login/agent",
+		Metadata:     map[string]string{"team": "payments"},
+	})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, map[string]any{
+		"path":     "auth/userpass/login/agent",
+		"policies": []any{"default"},
+		"team":     "payments",
+	}, claims["actor_auth"])
+
+	// Otherwise the token is only looked up through api_addr when enabled
+	resp = exchange(nil, nil)
+	requireExchangeError(t, resp, ErrCodeServerError, false)
+	require.Contains(t, resp.Error().Error(), "set token_lookup_enabled")
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/auth/token/lookup-accessor", r.URL.Path)
+		require.Equal(t, "lookup-token", r.Header.Get("X-Vault-Token"))
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, "accessor-123", body["accessor"])
+
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"policies": []string{"default"},
+			"path":     "auth/kubernetes/login",
+			"type":     "service",
+			"meta":     nil,
+		}})
+	}))
+	t.Cleanup(vault.Close)
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"api_addr": vault.URL, "token_lookup_enabled": true})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "token_lookup_enabled requires api_addr and token_lookup_token")
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"api_addr": vault.URL, "token_lookup_token": "lookup-token", "token_lookup_enabled": true})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	resp = exchange(nil, nil)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, map[string]any{
+		"path":     "auth/kubernetes/login",
+		"policies": []any{"default"},
+		"team":     "",
+	}, claims["actor_auth"])
+
+	configResp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, true, configResp.Data["token_lookup_token_configured"])
+	require.Equal(t, true, configResp.Data["token_lookup_enabled"])
+	require.NotContains(t, configResp.Data, "token_lookup_token")
+}
@@ -0,0 +1,61 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/framework"
+)
+
+// errCASMismatch is returned when a write's cas does not match the current
+// version of the entry
+var errCASMismatch = errors.New("check-and-set parameter did not match the current version")
+
+// casField returns the schema of the cas parameter of a check-and-set write
+func casField(resource string) *framework.FieldSchema {
+	return &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: "Check-and-set: only write if the " + resource + "'s cas_version matches. 0 only writes if the " + resource + " does not exist yet. Omit to write unconditionally",
+	}
+}
+
+// requestCAS returns the cas parameter of a write, or nil when it is not set
+func requestCAS(data *framework.FieldData) *int {
+	raw, ok := data.GetOk("cas")
+	if !ok {
+		return nil
+	}
+	cas := raw.(int)
+	return &cas
+}
+
+// checkCAS verifies cas against the current version of an entry, which is 0
+// when it does not exist. A nil cas always matches.
+func checkCAS(cas *int, current int) error {
+	if cas != nil && *cas != current {
+		return errCASMismatch
+	}
+	return nil
+}
+
+// storedVersion returns the cas_version of a stored entry. Entries written
+// before versions were tracked count as version 1.
+func storedVersion(version int) int {
+	return max(version, 1)
+}
+
+// sameEntry reports whether updated would be stored exactly as existing is,
+// so a write that changes nothing can skip storage and keep the cas_version.
+// Tools such as Terraform rewrite whole entries on every apply.
+func sameEntry(existing, updated any) bool {
+	before, err := json.Marshal(existing)
+	if err != nil {
+		return false
+	}
+	after, err := json.Marshal(updated)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(before, after)
+}
@@ -0,0 +1,108 @@
+package tokenexchange
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// defaultCertificateTTL is the validity of a generated key certificate
+const defaultCertificateTTL = 365 * 24 * time.Hour
+
+// parseCertificatePEM parses a PEM-encoded X.509 certificate
+func parseCertificatePEM(certificatePEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// encodeCertificatePEM encodes a DER certificate to PEM format
+func encodeCertificatePEM(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// checkCertificateKey verifies that the certificate certifies publicKey
+func checkCertificateKey(certificate *x509.Certificate, publicKey *rsa.PublicKey) error {
+	certificateKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok || !certificateKey.Equal(publicKey) {
+		return fmt.Errorf("certificate does not match the key's latest version")
+	}
+	return nil
+}
+
+// generateKeyCertificate returns a self-signed certificate for privateKey,
+// valid from now for ttl
+func generateKeyCertificate(privateKey *rsa.PrivateKey, commonName string, now time.Time, ttl time.Duration) (string, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(ttl),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return encodeCertificatePEM(der), nil
+}
+
+// rotateCertificate replaces the certificate of a key that has just been
+// rotated. A generated certificate is regenerated for the new version with
+// the same subject and validity; an uploaded one cannot certify the new
+// version and is dropped.
+func (k *Key) rotateCertificate(previousPEM string, generated bool, now time.Time) error {
+	k.Certificate = ""
+	k.CertificateGenerated = false
+	if previousPEM == "" || !generated || k.isTransit() || k.isManaged() {
+		return nil
+	}
+
+	previous, err := parseCertificatePEM(previousPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse previous certificate: %w", err)
+	}
+	privateKey, err := parsePrivateKey(k.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	ttl := previous.NotAfter.Sub(previous.NotBefore.Add(time.Minute))
+	certificate, err := generateKeyCertificate(privateKey, previous.Subject.CommonName, now, ttl)
+	if err != nil {
+		return err
+	}
+	k.Certificate = certificate
+	k.CertificateGenerated = true
+	return nil
+}
+
+// addCertificateFields adds the x5c and x5t#S256 members of an RSA JWK when
+// the version has a certificate
+func addCertificateFields(jwk map[string]any, certificatePEM string) error {
+	if certificatePEM == "" {
+		return nil
+	}
+	certificate, err := parseCertificatePEM(certificatePEM)
+	if err != nil {
+		return err
+	}
+	jwk["x5c"] = []string{base64.StdEncoding.EncodeToString(certificate.Raw)}
+	jwk["x5t#S256"] = certificateThumbprint(certificate)
+	return nil
+}
@@ -0,0 +1,71 @@
+package tokenexchange
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker fails fast after consecutive failures of an external
+// dependency. Once threshold failures are recorded it opens for cooldown;
+// after that, calls are allowed again and a single failure reopens it until a
+// call succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// newCircuitBreaker creates a closed circuit breaker
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be attempted
+func (c *circuitBreaker) allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !now.Before(c.openUntil)
+}
+
+// record counts the outcome of a call and reports whether it opened the circuit
+func (c *circuitBreaker) record(now time.Time, err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		c.failures = 0
+		c.openUntil = time.Time{}
+		return false
+	}
+
+	c.failures++
+	if c.failures < c.threshold {
+		return false
+	}
+
+	c.openUntil = now.Add(c.cooldown)
+	return true
+}
+
+// openedUntil returns when the circuit closes again, or zero if it is closed
+func (c *circuitBreaker) openedUntil(now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Before(c.openUntil) {
+		return c.openUntil
+	}
+	return time.Time{}
+}
+
+// reset closes the circuit and clears the failure count
+func (c *circuitBreaker) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = 0
+	c.openUntil = time.Time{}
+}
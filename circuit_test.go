@@ -0,0 +1,39 @@
+package tokenexchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCircuitBreaker tests opening after consecutive failures, closing after
+// the cooldown and reopening on a failed trial call
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(3, time.Minute)
+	now := time.Now()
+	failure := errors.New("unavailable")
+
+	require.False(t, breaker.record(now, failure))
+	require.False(t, breaker.record(now, failure))
+	require.False(t, breaker.record(now, nil), "success resets the failure count")
+	require.False(t, breaker.record(now, failure))
+	require.False(t, breaker.record(now, failure))
+	require.True(t, breaker.allow(now))
+
+	require.True(t, breaker.record(now, failure))
+	require.False(t, breaker.allow(now))
+	require.Equal(t, now.Add(time.Minute), breaker.openedUntil(now))
+
+	later := now.Add(time.Minute)
+	require.True(t, breaker.allow(later))
+	require.True(t, breaker.openedUntil(later).IsZero())
+
+	// A failed trial call reopens the circuit immediately
+	require.True(t, breaker.record(later, failure))
+	require.False(t, breaker.allow(later))
+
+	breaker.reset()
+	require.True(t, breaker.allow(later))
+}
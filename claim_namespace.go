@@ -0,0 +1,60 @@
+package tokenexchange
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// standardClaims are the claims of RFC 7519, RFC 8693, RFC 7800 and RFC 8417
+// that are never namespaced
+var standardClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"act": true, "may_act": true, "scope": true, "client_id": true,
+	"cnf": true, "txn": true,
+}
+
+// validateClaimNamespace checks a claim_namespace, which must be an absolute
+// URI such as https://example.com/claims/
+func validateClaimNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	parsed, err := url.Parse(namespace)
+	if err != nil || parsed.Scheme == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return fmt.Errorf("claim_namespace must be an absolute URI without a query or fragment, e.g. https://example.com/claims/")
+	}
+	if (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host == "" {
+		return fmt.Errorf("claim_namespace must include a host")
+	}
+	return nil
+}
+
+// claimNamespace returns the namespace of the custom claims issued by the
+// role. The role's namespace overrides the config's. Tokens shaped by a
+// token_profile are never namespaced, since the relying party defines
+// their claims.
+func claimNamespace(config *Config, role *Role) string {
+	if role.TokenProfile != TokenProfileDefault {
+		return ""
+	}
+	if role.ClaimNamespace != "" {
+		return role.ClaimNamespace
+	}
+	return config.ClaimNamespace
+}
+
+// namespaceClaims prefixes every non-standard top-level claim with namespace.
+// Claims that already carry the namespace are left unchanged.
+func namespaceClaims(claims map[string]any, namespace string) {
+	if namespace == "" {
+		return
+	}
+	for name, value := range claims {
+		if standardClaims[name] || strings.HasPrefix(name, namespace) {
+			continue
+		}
+		delete(claims, name)
+		claims[namespace+name] = value
+	}
+}
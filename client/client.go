@@ -0,0 +1,443 @@
+// Package client is a Go client for the identity delegation secrets engine.
+// It wraps the Vault API client with typed requests and responses for token
+// exchange, roles, keys and the JWKS.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultMount is the path the plugin is mounted at in the documentation
+const DefaultMount = "identity-delegation"
+
+// Subject token types accepted by ExchangeRequest.SubjectTokenType
+const (
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeSAML2       = "urn:ietf:params:oauth:token-type:saml2"
+	TokenTypeVaultToken  = "vault_token"
+)
+
+// Client calls a mount of the plugin through a Vault API client
+type Client struct {
+	vault *api.Client
+	mount string
+}
+
+// New returns a client for the plugin mounted at mount, or DefaultMount when
+// mount is empty. Requests are authenticated with the Vault client's token.
+func New(vault *api.Client, mount string) *Client {
+	if mount == "" {
+		mount = DefaultMount
+	}
+	return &Client{vault: vault, mount: strings.Trim(mount, "/")}
+}
+
+// ExchangeRequest is a token exchange. Only SubjectToken is required.
+type ExchangeRequest struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Scope              string
+	DelegationCtx      map[string]any
+	NotBefore          time.Time
+	NotAfter           time.Time
+	Nonce              string
+	DPoPProof          string
+	RequestID          string
+	Traceparent        string
+
+	// ActorID names the actor of callers without a Vault entity, for roles
+	// with allow_entityless
+	ActorID string
+}
+
+// Token is an issued token
+type Token struct {
+	Token            string
+	IssuedTokenType  string
+	TokenType        string
+	JTI              string
+	KeyID            string
+	Scope            string
+	ActorEntityID    string
+	IssuedAt         time.Time
+	NotBefore        time.Time
+	ExpiresAt        time.Time
+	IDToken          string
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+
+	// Data is the complete response, including members without a field above
+	Data map[string]any
+}
+
+// ExchangeError is a failed exchange, carrying the plugin's error code
+type ExchangeError struct {
+	StatusCode  int
+	Code        string
+	Description string
+	Retryable   bool
+}
+
+func (e *ExchangeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// ExchangeToken exchanges a subject token for a token issued by role. A
+// rejected exchange returns an *ExchangeError.
+func (c *Client) ExchangeToken(ctx context.Context, role string, req *ExchangeRequest) (*Token, error) {
+	data := map[string]any{"subject_token": req.SubjectToken}
+	setString(data, "subject_token_type", req.SubjectTokenType)
+	setString(data, "requested_token_type", req.RequestedTokenType)
+	setString(data, "scope", req.Scope)
+	setString(data, "nonce", req.Nonce)
+	setString(data, "dpop_proof", req.DPoPProof)
+	setString(data, "request_id", req.RequestID)
+	setString(data, "traceparent", req.Traceparent)
+	setString(data, "actor_id", req.ActorID)
+	if len(req.DelegationCtx) > 0 {
+		data["delegation_ctx"] = req.DelegationCtx
+	}
+	if !req.NotBefore.IsZero() {
+		data["not_before"] = req.NotBefore.Format(time.RFC3339)
+	}
+	if !req.NotAfter.IsZero() {
+		data["not_after"] = req.NotAfter.Format(time.RFC3339)
+	}
+
+	return c.issue(ctx, "token/"+role, data)
+}
+
+// RefreshToken exchanges a refresh token for a new token issued by role and
+// the refresh token that replaces it
+func (c *Client) RefreshToken(ctx context.Context, role, refreshToken string) (*Token, error) {
+	return c.issue(ctx, "token/"+role+"/refresh", map[string]any{"refresh_token": refreshToken})
+}
+
+// issue writes an exchange request and decodes the issued token
+func (c *Client) issue(ctx context.Context, path string, data map[string]any) (*Token, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	resp, err := c.vault.Logical().WriteRawWithContext(ctx, c.path(path), body)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		if exchangeErr := decodeExchangeError(resp); exchangeErr != nil {
+			return nil, exchangeErr
+		}
+		return nil, err
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response")
+	}
+
+	return &Token{
+		Token:            stringValue(secret.Data, "token"),
+		IssuedTokenType:  stringValue(secret.Data, "issued_token_type"),
+		TokenType:        stringValue(secret.Data, "token_type"),
+		JTI:              stringValue(secret.Data, "jti"),
+		KeyID:            stringValue(secret.Data, "key_id"),
+		Scope:            stringValue(secret.Data, "scope"),
+		ActorEntityID:    stringValue(secret.Data, "actor_entity_id"),
+		IssuedAt:         unixValue(secret.Data, "issued_at"),
+		NotBefore:        unixValue(secret.Data, "not_before"),
+		ExpiresAt:        unixValue(secret.Data, "expires_at"),
+		IDToken:          stringValue(secret.Data, "id_token"),
+		RefreshToken:     stringValue(secret.Data, "refresh_token"),
+		RefreshExpiresAt: unixValue(secret.Data, "refresh_expires_at"),
+		Data:             secret.Data,
+	}, nil
+}
+
+// decodeExchangeError reads the error code from a failed exchange response. It
+// returns nil when the response carries none, such as a permission error.
+func decodeExchangeError(resp *api.Response) *ExchangeError {
+	if resp == nil || resp.Body == nil {
+		return nil
+	}
+
+	var body struct {
+		Data struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			Retryable        bool   `json:"retryable"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Data.Error == "" {
+		return nil
+	}
+
+	return &ExchangeError{
+		StatusCode:  resp.StatusCode,
+		Code:        body.Data.Error,
+		Description: body.Data.ErrorDescription,
+		Retryable:   body.Data.Retryable,
+	}
+}
+
+// Role holds the commonly used role parameters. Parameters carries any
+// other role parameter by its API name, and takes precedence on write.
+type Role struct {
+	Key             string
+	TTL             time.Duration
+	RefreshTTL      time.Duration
+	SubjectTemplate string
+	ActorTemplate   string
+	Context         []string
+	BoundIssuer     string
+	BoundAudiences  []string
+	BoundClaims     map[string]any
+	BoundClaimsType string
+
+	// Parameters are the role's remaining parameters. On read it holds the
+	// complete role.
+	Parameters map[string]any
+}
+
+// CreateRole creates or replaces the role name
+func (c *Client) CreateRole(ctx context.Context, name string, role *Role) error {
+	data := map[string]any{
+		"key":              role.Key,
+		"subject_template": role.SubjectTemplate,
+		"actor_template":   role.ActorTemplate,
+	}
+	if role.TTL > 0 {
+		data["ttl"] = int64(role.TTL.Seconds())
+	}
+	if role.RefreshTTL > 0 {
+		data["refresh_ttl"] = int64(role.RefreshTTL.Seconds())
+	}
+	if len(role.Context) > 0 {
+		data["context"] = role.Context
+	}
+	setString(data, "bound_issuer", role.BoundIssuer)
+	if len(role.BoundAudiences) > 0 {
+		data["bound_audiences"] = role.BoundAudiences
+	}
+	if len(role.BoundClaims) > 0 {
+		data["bound_claims"] = role.BoundClaims
+	}
+	setString(data, "bound_claims_type", role.BoundClaimsType)
+	for k, v := range role.Parameters {
+		data[k] = v
+	}
+
+	_, err := c.vault.Logical().WriteWithContext(ctx, c.path("role/"+name), data)
+	return err
+}
+
+// ReadRole reads the role name. It returns nil when the role does not exist.
+func (c *Client) ReadRole(ctx context.Context, name string) (*Role, error) {
+	secret, err := c.vault.Logical().ReadWithContext(ctx, c.path("role/"+name))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	role := &Role{
+		Key:             stringValue(secret.Data, "key"),
+		SubjectTemplate: stringValue(secret.Data, "subject_template"),
+		ActorTemplate:   stringValue(secret.Data, "actor_template"),
+		Context:         stringsValue(secret.Data, "context"),
+		BoundIssuer:     stringValue(secret.Data, "bound_issuer"),
+		BoundAudiences:  stringsValue(secret.Data, "bound_audiences"),
+		BoundClaimsType: stringValue(secret.Data, "bound_claims_type"),
+		Parameters:      secret.Data,
+	}
+	role.BoundClaims, _ = secret.Data["bound_claims"].(map[string]any)
+	if role.TTL, err = durationValue(secret.Data, "ttl"); err != nil {
+		return nil, err
+	}
+	if role.RefreshTTL, err = durationValue(secret.Data, "refresh_ttl"); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// PreviewRequest is sample input for PreviewRole. Set either SubjectToken,
+// whose signature is not verified, or SubjectClaims.
+type PreviewRequest struct {
+	SubjectToken   string
+	SubjectClaims  map[string]any
+	EntityName     string
+	EntityMetadata map[string]string
+}
+
+// Preview is the claims a role would issue for sample input
+type Preview struct {
+	Key      string
+	Claims   map[string]any
+	Warnings []string
+}
+
+// PreviewRole renders the templates of the role name against sample input
+// without signing or recording a token
+func (c *Client) PreviewRole(ctx context.Context, name string, req *PreviewRequest) (*Preview, error) {
+	data := map[string]any{}
+	setString(data, "subject_token", req.SubjectToken)
+	setString(data, "entity_name", req.EntityName)
+	if req.SubjectClaims != nil {
+		data["subject_claims"] = req.SubjectClaims
+	}
+	if len(req.EntityMetadata) > 0 {
+		data["entity_metadata"] = req.EntityMetadata
+	}
+
+	secret, err := c.vault.Logical().WriteWithContext(ctx, c.path("role/"+name+"/preview"), data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response")
+	}
+
+	claims, _ := secret.Data["claims"].(map[string]any)
+	return &Preview{
+		Key:      stringValue(secret.Data, "key"),
+		Claims:   claims,
+		Warnings: secret.Warnings,
+	}, nil
+}
+
+// DeleteRole deletes the role name
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	_, err := c.vault.Logical().DeleteWithContext(ctx, c.path("role/"+name))
+	return err
+}
+
+// KeyVersion identifies the current version of a signing key
+type KeyVersion struct {
+	Name    string
+	KeyID   string
+	Version int
+}
+
+// RotateKey rotates the signing key name and returns its new version
+func (c *Client) RotateKey(ctx context.Context, name string) (*KeyVersion, error) {
+	secret, err := c.vault.Logical().WriteWithContext(ctx, c.path("key/"+name+"/rotate"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response")
+	}
+
+	version, err := intValue(secret.Data, "version")
+	if err != nil {
+		return nil, err
+	}
+	return &KeyVersion{
+		Name:    stringValue(secret.Data, "name"),
+		KeyID:   stringValue(secret.Data, "key_id"),
+		Version: version,
+	}, nil
+}
+
+// FetchJWKS reads the mount's public JWKS, which verifies issued tokens
+func (c *Client) FetchJWKS(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	resp, err := c.vault.Logical().ReadRawWithContext(ctx, c.path("jwks"))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", resp.StatusCode)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+	return &keySet, nil
+}
+
+// path returns the API path of a mount path
+func (c *Client) path(path string) string {
+	return c.mount + "/" + path
+}
+
+// setString sets key in data unless value is empty
+func setString(data map[string]any, key, value string) {
+	if value != "" {
+		data[key] = value
+	}
+}
+
+// stringValue returns a string member of data, or "" when it is missing
+func stringValue(data map[string]any, key string) string {
+	s, _ := data[key].(string)
+	return s
+}
+
+// stringsValue returns a list of strings member of data
+func stringsValue(data map[string]any, key string) []string {
+	values, _ := data[key].([]any)
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// intValue returns a number member of data, decoded by the API client as json.Number
+func intValue(data map[string]any, key string) (int, error) {
+	switch v := data[key].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return int(n), nil
+	case float64:
+		return int(v), nil
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("invalid %s type %T", key, v)
+	}
+}
+
+// unixValue returns a unix seconds member of data as a time, or the zero time
+// when it is missing
+func unixValue(data map[string]any, key string) time.Time {
+	seconds, err := intValue(data, key)
+	if err != nil || seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
+
+// durationValue returns a duration member of data, in seconds
+func durationValue(data map[string]any, key string) (time.Duration, error) {
+	seconds, err := intValue(data, key)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
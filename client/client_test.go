@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+
+	tokenexchange "github.com/nicholasjackson/vault-plugin-identity-delegation"
+)
+
+// newTestVault serves the plugin over HTTP the way Vault does, at DefaultMount,
+// and returns an API client for it. Requests act as entity test-entity.
+func newTestVault(t *testing.T) *api.Client {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend, err := tokenexchange.Factory(ctx, &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      &logical.StaticSystemView{EntityVal: &logical.Entity{ID: "test-entity", Name: "test-entity"}},
+		StorageView: storage,
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &logical.Request{
+			Path:       strings.TrimPrefix(r.URL.Path, "/v1/"+DefaultMount+"/"),
+			Storage:    storage,
+			EntityID:   "test-entity",
+			MountPoint: DefaultMount + "/",
+			Data:       map[string]any{},
+		}
+		switch r.Method {
+		case http.MethodGet:
+			req.Operation = logical.ReadOperation
+		case http.MethodDelete:
+			req.Operation = logical.DeleteOperation
+		default:
+			req.Operation = logical.UpdateOperation
+			if r.ContentLength != 0 {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req.Data))
+			}
+			if found, exists, err := backend.HandleExistenceCheck(ctx, req); err == nil && found && !exists {
+				req.Operation = logical.CreateOperation
+			}
+		}
+
+		resp, err := backend.HandleRequest(ctx, req)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case err != nil && resp == nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{err.Error()}})
+		case resp == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case resp.Data[logical.HTTPRawBody] != nil:
+			w.Write(resp.Data[logical.HTTPRawBody].([]byte))
+		case resp.IsError():
+			// Vault returns the "data" of error responses next to the errors
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{resp.Error().Error()}, "data": resp.Data["data"]})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"data": resp.Data, "warnings": resp.Warnings})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	vault, err := api.NewClient(config)
+	require.NoError(t, err)
+	vault.SetToken("test-token")
+	return vault
+}
+
+// newTestIssuer returns a subject token signer and the JWKS server for its key
+func newTestIssuer(t *testing.T) (func(claims map[string]any) string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "idp-key", Use: "sig"}}})
+	}))
+	t.Cleanup(server.Close)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "idp-key"))
+	require.NoError(t, err)
+	sign := func(claims map[string]any) string {
+		token, err := jwt.Signed(signer).Claims(claims).Serialize()
+		require.NoError(t, err)
+		return token
+	}
+	return sign, server.URL
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	vault := newTestVault(t)
+	sign, jwksURI := newTestIssuer(t)
+	c := New(vault, "")
+
+	_, err := vault.Logical().WriteWithContext(ctx, DefaultMount+"/config", map[string]any{
+		"issuer":           "https://vault.example.com",
+		"subject_jwks_uri": jwksURI,
+	})
+	require.NoError(t, err)
+	_, err = vault.Logical().WriteWithContext(ctx, DefaultMount+"/key/signer", map[string]any{"algorithm": "RS256"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.CreateRole(ctx, "agent", &Role{
+		Key:             "signer",
+		TTL:             30 * time.Minute,
+		RefreshTTL:      time.Hour,
+		SubjectTemplate: `{"email": "{{identity.subject.email}}"}`,
+		ActorTemplate:   `{"act": {"sub": "agent-123"}}`,
+		Context:         []string{"docs:read", "docs:write"},
+		BoundIssuer:     "https://idp.example.com",
+		Parameters:      map[string]any{"include_txn": true},
+	}))
+
+	role, err := c.ReadRole(ctx, "agent")
+	require.NoError(t, err)
+	require.Equal(t, "signer", role.Key)
+	require.Equal(t, 30*time.Minute, role.TTL)
+	require.Equal(t, time.Hour, role.RefreshTTL)
+	require.Equal(t, []string{"docs:read", "docs:write"}, role.Context)
+	require.Equal(t, true, role.Parameters["include_txn"])
+
+	preview, err := c.PreviewRole(ctx, "agent", &PreviewRequest{
+		SubjectClaims: map[string]any{"sub": "user-123", "email": "user@example.com", "iss": "https://other.example.com"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "signer", preview.Key)
+	require.Equal(t, map[string]any{"email": "user@example.com"}, preview.Claims["subject_claims"])
+	require.Len(t, preview.Warnings, 1, "the issuer is not bound_issuer")
+
+	missing, err := c.ReadRole(ctx, "missing")
+	require.NoError(t, err)
+	require.Nil(t, missing)
+
+	subjectToken := sign(map[string]any{
+		"sub":   "user-123",
+		"email": "user@example.com",
+		"iss":   "https://idp.example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	token, err := c.ExchangeToken(ctx, "agent", &ExchangeRequest{SubjectToken: subjectToken, Scope: "docs:read"})
+	require.NoError(t, err)
+	require.NotEmpty(t, token.Token)
+	require.Equal(t, TokenTypeJWT, token.IssuedTokenType)
+	require.Equal(t, "docs:read", token.Scope)
+	require.Equal(t, "test-entity", token.ActorEntityID)
+	require.WithinDuration(t, time.Now().Add(30*time.Minute), token.ExpiresAt, 5*time.Second)
+	require.NotEmpty(t, token.RefreshToken)
+
+	// Issued tokens verify against the JWKS
+	keySet, err := c.FetchJWKS(ctx)
+	require.NoError(t, err)
+	parsed, err := jwt.ParseSigned(token.Token, []jose.SignatureAlgorithm{jose.RS256})
+	require.NoError(t, err)
+	keys := keySet.Key(token.KeyID)
+	require.Len(t, keys, 1)
+	claims := map[string]any{}
+	require.NoError(t, parsed.Claims(keys[0].Key, &claims))
+	require.Equal(t, map[string]any{"email": "user@example.com"}, claims["subject_claims"])
+
+	refreshed, err := c.RefreshToken(ctx, "agent", token.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, token.JTI, refreshed.JTI)
+
+	// Rejected exchanges return the error code
+	_, err = c.ExchangeToken(ctx, "agent", &ExchangeRequest{SubjectToken: subjectToken, Scope: "docs:admin"})
+	var exchangeErr *ExchangeError
+	require.True(t, errors.As(err, &exchangeErr), "unexpected error %v", err)
+	require.Equal(t, "invalid_scope", exchangeErr.Code)
+	require.False(t, exchangeErr.Retryable)
+	require.Equal(t, http.StatusBadRequest, exchangeErr.StatusCode)
+
+	version, err := c.RotateKey(ctx, "signer")
+	require.NoError(t, err)
+	require.Equal(t, 2, version.Version)
+	require.NotEqual(t, token.KeyID, version.KeyID)
+
+	require.NoError(t, c.DeleteRole(ctx, "agent"))
+	role, err = c.ReadRole(ctx, "agent")
+	require.NoError(t, err)
+	require.Nil(t, role)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nicholasjackson/vault-plugin-identity-delegation/client"
+)
+
+// errUsage reports a command line that was not understood, after its usage
+// has been printed
+var errUsage = errors.New("usage")
+
+// command holds the state shared by the subcommands
+type command struct {
+	ctx    context.Context
+	mount  string
+	out    *printer
+	stdin  io.Reader
+	stderr io.Writer
+}
+
+// flagSet returns the flag set of a subcommand
+func (c *command) flagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: identity-delegation %s %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// usageError prints the usage of a subcommand with a reason and returns errUsage
+func (c *command) usageError(flags *flag.FlagSet, format string, args ...any) error {
+	fmt.Fprintf(c.stderr, format+"\n", args...)
+	flags.Usage()
+	return errUsage
+}
+
+// readValue resolves a flag value: "-" reads stdin and "@path" reads a file
+func (c *command) readValue(value string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case value == "-":
+		data, err = io.ReadAll(c.stdin)
+	case strings.HasPrefix(value, "@"):
+		data, err = os.ReadFile(value[1:])
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// exchange exchanges a subject token and prints the issued token
+func (c *command) exchange(args []string) error {
+	flags := c.flagSet("exchange", "-role <role> -subject-token <token|@file|->")
+	role := flags.String("role", "", "Role to exchange with")
+	subjectToken := flags.String("subject-token", "", "Subject token, @file or - for stdin")
+	subjectTokenType := flags.String("subject-token-type", "", "RFC 8693 type of the subject token (default jwt)")
+	requestedTokenType := flags.String("requested-token-type", "", "Type of token to issue")
+	scope := flags.String("scope", "", "Space-separated scopes to request")
+	actorID := flags.String("actor-id", "", "Actor ID, when the Vault token has no entity")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *role == "" || *subjectToken == "" {
+		return c.usageError(flags, "-role and -subject-token are required")
+	}
+
+	token, err := c.readValue(*subjectToken)
+	if err != nil {
+		return fmt.Errorf("failed to read subject token: %w", err)
+	}
+
+	cl, err := newClient(c.mount)
+	if err != nil {
+		return err
+	}
+	issued, err := cl.ExchangeToken(c.ctx, *role, &client.ExchangeRequest{
+		SubjectToken:       token,
+		SubjectTokenType:   *subjectTokenType,
+		RequestedTokenType: *requestedTokenType,
+		Scope:              *scope,
+		ActorID:            *actorID,
+	})
+	var exchangeErr *client.ExchangeError
+	if errors.As(err, &exchangeErr) && exchangeErr.Retryable {
+		return fmt.Errorf("%w (retryable)", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.out.object(issued.Data)
+}
+
+// previewTemplate prints the claims a role would issue for sample input
+func (c *command) previewTemplate(args []string) error {
+	flags := c.flagSet("preview-template", "-role <role> (-subject-token <token|@file|-> | -claims <json|@file|->)")
+	role := flags.String("role", "", "Role whose templates are rendered")
+	subjectToken := flags.String("subject-token", "", "Sample subject token, @file or - for stdin. Its signature is not verified")
+	claims := flags.String("claims", "", "Sample subject claims as a JSON object, @file or - for stdin")
+	entityName := flags.String("entity-name", "", "Name of the sample Vault entity")
+	metadata := keyValues{}
+	flags.Var(metadata, "entity-metadata", "Metadata of the sample Vault entity as key=value, repeatable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *role == "" || (*subjectToken == "") == (*claims == "") {
+		return c.usageError(flags, "-role and one of -subject-token or -claims are required")
+	}
+
+	req := &client.PreviewRequest{EntityName: *entityName, EntityMetadata: metadata}
+	if *subjectToken != "" {
+		token, err := c.readValue(*subjectToken)
+		if err != nil {
+			return fmt.Errorf("failed to read subject token: %w", err)
+		}
+		req.SubjectToken = token
+	} else {
+		raw, err := c.readValue(*claims)
+		if err != nil {
+			return fmt.Errorf("failed to read claims: %w", err)
+		}
+		if err := json.Unmarshal([]byte(raw), &req.SubjectClaims); err != nil {
+			return fmt.Errorf("claims must be a JSON object: %w", err)
+		}
+	}
+
+	cl, err := newClient(c.mount)
+	if err != nil {
+		return err
+	}
+	preview, err := cl.PreviewRole(c.ctx, *role, req)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range preview.Warnings {
+		fmt.Fprintf(c.stderr, "WARNING: %s\n", warning)
+	}
+	if c.out.format == formatJSON {
+		return c.out.object(map[string]any{"key": preview.Key, "claims": preview.Claims, "warnings": preview.Warnings})
+	}
+	c.out.title("Signing key: " + preview.Key)
+	return c.out.object(preview.Claims)
+}
+
+// rotateKey rotates a signing key and prints its new version
+func (c *command) rotateKey(args []string) error {
+	flags := c.flagSet("rotate-key", "<key>")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return c.usageError(flags, "the key name is required")
+	}
+
+	cl, err := newClient(c.mount)
+	if err != nil {
+		return err
+	}
+	version, err := cl.RotateKey(c.ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return c.out.object(map[string]any{"name": version.Name, "key_id": version.KeyID, "version": version.Version})
+}
+
+// decodeToken prints the header and claims of a JWT, or the header of a JWE,
+// without verifying it. Vault is not contacted.
+func (c *command) decodeToken(args []string) error {
+	flags := c.flagSet("decode-token", "<token|@file|->")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return c.usageError(flags, "the token is required")
+	}
+
+	token, err := c.readValue(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read token: %w", err)
+	}
+	header, claims, err := decodeToken(token)
+	if err != nil {
+		return err
+	}
+
+	if c.out.format == formatJSON {
+		decoded := map[string]any{"header": header}
+		if claims != nil {
+			decoded["claims"] = claims
+		}
+		return c.out.object(decoded)
+	}
+
+	c.out.title("Header")
+	if err := c.out.object(header); err != nil {
+		return err
+	}
+	if claims == nil {
+		c.out.title("\nThe payload is encrypted")
+		return nil
+	}
+	c.out.title("\nClaims")
+	return c.out.object(claims)
+}
+
+// decodeToken decodes the header and, unless the token is a JWE, the claims
+// of a compact serialized token
+func decodeToken(token string) (map[string]any, map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 && len(parts) != 5 {
+		return nil, nil, fmt.Errorf("token is not a compact JWS or JWE")
+	}
+
+	header, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %w", err)
+	}
+	if len(parts) == 5 {
+		return header, nil, nil
+	}
+
+	claims, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	return header, claims, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON object
+func decodeSegment(segment string) (map[string]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// keyValues collects repeated key=value flags
+type keyValues map[string]string
+
+func (kv keyValues) String() string { return "" }
+
+func (kv keyValues) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not key=value", value)
+	}
+	kv[key] = val
+	return nil
+}
@@ -0,0 +1,97 @@
+// Command identity-delegation is a developer CLI for the identity delegation
+// secrets engine. It exchanges tokens, previews role templates, rotates keys
+// and decodes tokens, printing tables or JSON. Vault is addressed with the
+// usual VAULT_ADDR and VAULT_TOKEN environment variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/nicholasjackson/vault-plugin-identity-delegation/client"
+)
+
+const usage = `Usage: identity-delegation [options] <command> [args]
+
+Commands:
+  exchange          Exchange a subject token for a delegated token
+  preview-template  Preview the claims a role would issue for sample input
+  rotate-key        Rotate a signing key
+  decode-token      Decode a JWT or JWE without verifying it
+
+Options:
+  -mount string   Path the plugin is mounted at (default "identity-delegation")
+  -format string  Output format, table or json (default "table")
+
+Run "identity-delegation <command> -h" for the options of a command.
+`
+
+// newClient returns the plugin client for mount, configured from the environment
+var newClient = func(mount string) (*client.Client, error) {
+	vault, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault client: %w", err)
+	}
+	return client.New(vault, mount), nil
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line in args and returns the exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("identity-delegation", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	mount := flags.String("mount", client.DefaultMount, "")
+	format := flags.String("format", formatTable, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintf(stderr, "unsupported format %q, use table or json\n", *format)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	cmd := &command{
+		ctx:    ctx,
+		mount:  *mount,
+		out:    &printer{w: stdout, format: *format},
+		stdin:  stdin,
+		stderr: stderr,
+	}
+
+	var err error
+	switch name, cmdArgs := flags.Arg(0), flags.Args()[1:]; name {
+	case "exchange":
+		err = cmd.exchange(cmdArgs)
+	case "preview-template":
+		err = cmd.previewTemplate(cmdArgs)
+	case "rotate-key":
+		err = cmd.rotateKey(cmdArgs)
+	case "decode-token":
+		err = cmd.decodeToken(cmdArgs)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, errUsage) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testToken(t *testing.T, header, claims map[string]any) string {
+	t.Helper()
+
+	encode := func(v map[string]any) string {
+		raw, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return encode(header) + "." + encode(claims) + ".c2lnbmF0dXJl"
+}
+
+func runCLI(args []string, stdin string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestDecodeToken(t *testing.T) {
+	token := testToken(t,
+		map[string]any{"alg": "RS256", "kid": "key-1"},
+		map[string]any{"sub": "user-123", "exp": 1700000000, "scope": []string{"read", "write"}},
+	)
+
+	t.Run("table", func(t *testing.T) {
+		code, stdout, stderr := runCLI([]string{"decode-token", token}, "")
+		require.Equal(t, 0, code, stderr)
+		require.Contains(t, stdout, "Header")
+		require.Regexp(t, `kid\s+key-1`, stdout)
+		require.Regexp(t, `exp\s+1700000000 \(2023-11-14T22:13:20Z\)`, stdout)
+		require.Regexp(t, `scope\s+read, write`, stdout)
+	})
+
+	t.Run("json from stdin", func(t *testing.T) {
+		code, stdout, stderr := runCLI([]string{"-format", "json", "decode-token", "-"}, token+"\n")
+		require.Equal(t, 0, code, stderr)
+
+		var decoded struct {
+			Header map[string]any `json:"header"`
+			Claims map[string]any `json:"claims"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(stdout), &decoded))
+		require.Equal(t, "RS256", decoded.Header["alg"])
+		require.Equal(t, "user-123", decoded.Claims["sub"])
+		require.Equal(t, float64(1700000000), decoded.Claims["exp"])
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		header, err := json.Marshal(map[string]any{"alg": "RSA-OAEP-256", "enc": "A256GCM"})
+		require.NoError(t, err)
+		jwe := base64.RawURLEncoding.EncodeToString(header) + ".a.b.c.d"
+
+		code, stdout, stderr := runCLI([]string{"decode-token", jwe}, "")
+		require.Equal(t, 0, code, stderr)
+		require.Regexp(t, `enc\s+A256GCM`, stdout)
+		require.Contains(t, stdout, "The payload is encrypted")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		code, _, stderr := runCLI([]string{"decode-token", "not-a-token"}, "")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "not a compact JWS or JWE")
+	})
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCLI(nil, "")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "Commands:")
+
+	code, _, stderr = runCLI([]string{"unknown"}, "")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, `unknown command "unknown"`)
+
+	code, _, _ = runCLI([]string{"-format", "yaml", "decode-token", "x"}, "")
+	require.Equal(t, 2, code)
+
+	code, _, stderr = runCLI([]string{"exchange", "-role", "r"}, "")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "-role and -subject-token are required")
+
+	code, _, stderr = runCLI([]string{"preview-template", "-role", "r", "-claims", "{}", "-subject-token", "t"}, "")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "one of -subject-token or -claims")
+}
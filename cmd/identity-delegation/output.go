@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// timeKeys are members holding unix seconds, shown as times in tables
+var timeKeys = map[string]bool{
+	"exp":                true,
+	"iat":                true,
+	"nbf":                true,
+	"auth_time":          true,
+	"issued_at":          true,
+	"expires_at":         true,
+	"not_before":         true,
+	"refresh_expires_at": true,
+}
+
+// printer writes command output as a key/value table or as JSON
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// title writes a line above a table. JSON output has no titles.
+func (p *printer) title(text string) {
+	if p.format == formatTable {
+		fmt.Fprintln(p.w, text)
+	}
+}
+
+// object writes an object, as indented JSON or as a table sorted by key
+func (p *printer) object(object map[string]any) error {
+	if p.format == formatJSON {
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(object)
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	table := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "KEY\tVALUE")
+	fmt.Fprintln(table, "---\t-----")
+	for _, key := range keys {
+		fmt.Fprintf(table, "%s\t%s\n", key, formatValue(key, object[key]))
+	}
+	return table.Flush()
+}
+
+// formatValue renders a table cell. Unix times gain their RFC 3339 form and
+// nested values are shown as compact JSON.
+func formatValue(key string, value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case json.Number:
+		if seconds, err := v.Int64(); err == nil && timeKeys[key] {
+			return fmt.Sprintf("%d (%s)", seconds, time.Unix(seconds, 0).UTC().Format(time.RFC3339))
+		}
+		return v.String()
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, formatValue("", item))
+		}
+		return strings.Join(parts, ", ")
+	case map[string]any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprint(v)
+	}
+}
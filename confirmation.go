@@ -0,0 +1,74 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// certificateThumbprint returns the RFC 8705 x5t#S256 thumbprint of a
+// certificate: the base64url SHA-256 hash of its DER encoding
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// validThumbprint reports whether s is an unpadded base64url SHA-256 hash
+func validThumbprint(s string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// validateCertificateBinding resolves the client certificate the token is
+// bound to: a thumbprint supplied by the caller, or the certificate the
+// caller presented to Vault
+func (b *Backend) validateCertificateBinding(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	thumbprint := ex.data.Get("certificate_thumbprint").(string)
+	bindConnection := ex.data.Get("bind_client_certificate").(bool)
+
+	switch {
+	case thumbprint != "" && bindConnection:
+		return exchangeError(ErrCodeInvalidRequest, "certificate_thumbprint cannot be combined with bind_client_certificate"), nil
+	case thumbprint != "":
+		if !validThumbprint(thumbprint) {
+			return exchangeError(ErrCodeInvalidRequest, "certificate_thumbprint must be the unpadded base64url SHA-256 hash of a DER certificate"), nil
+		}
+		ex.certificateThumbprint = thumbprint
+	case bindConnection:
+		conn := ex.req.Connection
+		if conn == nil || conn.ConnState == nil || len(conn.ConnState.PeerCertificates) == 0 {
+			return exchangeError(ErrCodeInvalidRequest, "bind_client_certificate requires a client certificate on the connection to Vault"), nil
+		}
+		ex.certificateThumbprint = certificateThumbprint(conn.ConnState.PeerCertificates[0])
+	}
+
+	if ex.certificateThumbprint == "" && ex.role.RequireCertificateBinding {
+		return exchangeError(ErrCodeInvalidRequest, "role %q requires certificate_thumbprint or bind_client_certificate", ex.roleName), nil
+	}
+	return nil, nil
+}
+
+// addConfirmation adds the RFC 7800 cnf claim of a sender-constrained token,
+// replacing any cnf from the templates. A token may be bound to both a
+// client certificate and a DPoP key.
+func (b *Backend) addConfirmation(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	cnf := map[string]any{}
+	if ex.certificateThumbprint != "" {
+		cnf["x5t#S256"] = ex.certificateThumbprint
+	}
+	if ex.dpopThumbprint != "" {
+		cnf["jkt"] = ex.dpopThumbprint
+	}
+	if len(cnf) == 0 {
+		return nil, nil
+	}
+
+	if ex.actorClaims == nil {
+		ex.actorClaims = map[string]any{}
+	}
+	ex.actorClaims["cnf"] = cnf
+	return nil, nil
+}
@@ -0,0 +1,116 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Types a delegation_ctx_schema can declare for a delegation_ctx field
+const (
+	delegationCtxTypeString = "string"
+	delegationCtxTypeNumber = "number"
+	delegationCtxTypeBool   = "bool"
+)
+
+// maxDelegationCtxValueLength bounds each string in a delegation_ctx, so
+// callers cannot inflate tokens with arbitrary payloads
+const maxDelegationCtxValueLength = 256
+
+// validateDelegationCtxSchema checks a role's delegation_ctx_schema and
+// delegation_ctx_required
+func validateDelegationCtxSchema(schema map[string]string, required []string) error {
+	for field, fieldType := range schema {
+		if strings.TrimSpace(field) == "" {
+			return fmt.Errorf("delegation_ctx_schema field names must not be empty")
+		}
+		switch fieldType {
+		case delegationCtxTypeString, delegationCtxTypeNumber, delegationCtxTypeBool:
+		default:
+			return fmt.Errorf("delegation_ctx_schema field %q has unsupported type %q, must be %s, %s or %s", field, fieldType, delegationCtxTypeString, delegationCtxTypeNumber, delegationCtxTypeBool)
+		}
+	}
+	for _, field := range required {
+		if _, ok := schema[field]; !ok {
+			return fmt.Errorf("delegation_ctx_required field %q is not in delegation_ctx_schema", field)
+		}
+	}
+	return nil
+}
+
+// checkDelegationCtx validates a caller's delegation_ctx against the role's
+// schema. Only the fields in the schema are accepted, each with its declared
+// type, and every required field must be present.
+func checkDelegationCtx(delegationCtx map[string]any, role *Role) error {
+	if len(delegationCtx) > 0 && len(role.DelegationContextSchema) == 0 {
+		return fmt.Errorf("role %q does not accept delegation_ctx", role.Name)
+	}
+
+	var missing []string
+	for _, field := range role.DelegationContextRequired {
+		if _, ok := delegationCtx[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("delegation_ctx is missing required fields: %s", strings.Join(missing, ", "))
+	}
+
+	for _, field := range slices.Sorted(maps.Keys(delegationCtx)) {
+		fieldType, ok := role.DelegationContextSchema[field]
+		if !ok {
+			return fmt.Errorf("delegation_ctx field %q is not in the role's schema", field)
+		}
+		if !delegationCtxValueHasType(delegationCtx[field], fieldType) {
+			return fmt.Errorf("delegation_ctx field %q must be a %s", field, fieldType)
+		}
+		if s, ok := delegationCtx[field].(string); ok && len(s) > maxDelegationCtxValueLength {
+			return fmt.Errorf("delegation_ctx field %q must be at most %d characters", field, maxDelegationCtxValueLength)
+		}
+	}
+	return nil
+}
+
+// delegationCtxValueHasType reports whether value is of a schema type.
+// Numbers arrive as json.Number from the HTTP API.
+func delegationCtxValueHasType(value any, fieldType string) bool {
+	switch value.(type) {
+	case string:
+		return fieldType == delegationCtxTypeString
+	case json.Number, float64, float32, int, int64, int32, uint, uint64, uint32:
+		return fieldType == delegationCtxTypeNumber
+	case bool:
+		return fieldType == delegationCtxTypeBool
+	default:
+		return false
+	}
+}
+
+// resolveDelegationCtx validates the delegation_ctx supplied with the
+// exchange
+func (b *Backend) resolveDelegationCtx(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if err := checkDelegationCtx(ex.delegationCtx, ex.role); err != nil {
+		return exchangeError(ErrCodeInvalidRequest, "%s", err), nil
+	}
+	return nil, nil
+}
+
+// addDelegationCtx adds the delegation_ctx claim, replacing any produced by
+// the templates, so downstream services can correlate the token with the
+// agent task it was issued for
+func (b *Backend) addDelegationCtx(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if len(ex.delegationCtx) == 0 {
+		return nil, nil
+	}
+
+	if ex.actorClaims == nil {
+		ex.actorClaims = map[string]any{}
+	}
+	ex.actorClaims["delegation_ctx"] = ex.delegationCtx
+	return nil, nil
+}
@@ -0,0 +1,75 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckDelegationCtx tests validation of delegation_ctx against a role's schema
+func TestCheckDelegationCtx(t *testing.T) {
+	role := &Role{
+		Name:                      "agent",
+		DelegationContextSchema:   map[string]string{"task_id": "string", "step": "number", "dry_run": "bool"},
+		DelegationContextRequired: []string{"task_id"},
+	}
+
+	require.NoError(t, checkDelegationCtx(map[string]any{"task_id": "t-1", "step": json.Number("3"), "dry_run": true}, role))
+	require.ErrorContains(t, checkDelegationCtx(nil, role), "missing required fields: task_id")
+	require.ErrorContains(t, checkDelegationCtx(map[string]any{"task_id": "t-1", "tool": "search"}, role), `"tool" is not in the role's schema`)
+	require.ErrorContains(t, checkDelegationCtx(map[string]any{"task_id": 1}, role), `"task_id" must be a string`)
+	require.ErrorContains(t, checkDelegationCtx(map[string]any{"task_id": strings.Repeat("a", maxDelegationCtxValueLength+1)}, role), "at most")
+	require.ErrorContains(t, checkDelegationCtx(map[string]any{"task_id": "t-1"}, &Role{Name: "plain"}), `role "plain" does not accept delegation_ctx`)
+	require.NoError(t, checkDelegationCtx(nil, &Role{Name: "plain"}))
+
+	require.Error(t, validateDelegationCtxSchema(map[string]string{"task_id": "object"}, nil))
+	require.Error(t, validateDelegationCtxSchema(map[string]string{"task_id": "string"}, []string{"tool"}))
+}
+
+// TestTokenExchange_DelegationCtx tests that a valid delegation_ctx is added
+// to the token and an invalid one is rejected
+func TestTokenExchange_DelegationCtx(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"delegation_ctx_schema":   []string{"task_id=string", "conversation_id=string", "tool=string"},
+		"delegation_ctx_required": "task_id",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, []string{"task_id"}, resp.Data["delegation_ctx_required"])
+
+	exchange := func(delegationCtx map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token":  generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+				"delegation_ctx": delegationCtx,
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp = exchange(map[string]any{"task_id": "task-42", "tool": "search"})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, map[string]any{"task_id": "task-42", "tool": "search"}, claims["delegation_ctx"])
+
+	resp = exchange(map[string]any{"tool": "search"})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "missing required fields: task_id")
+
+	resp = exchange(map[string]any{"task_id": "task-42", "secret": "x"})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	resp = writeProfileRole(t, b, storage, "", map[string]any{"delegation_ctx_schema": "task_id=object"})
+	require.True(t, resp.IsError())
+}
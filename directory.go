@@ -0,0 +1,367 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// DirectoryConfig configures an optional directory connector used to enrich
+// subject claims with attributes that the IdP does not put in its tokens
+type DirectoryConfig struct {
+	// Type is the connector type: "ldap" or "scim"
+	Type string `json:"type"`
+
+	// URL is the LDAP server URL (ldap:// or ldaps://) or the SCIM base URL
+	URL string `json:"url"`
+
+	// BindDN and BindPassword are the LDAP service account credentials
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+
+	// UserDN is the LDAP base DN under which users are searched
+	UserDN string `json:"user_dn"`
+
+	// UserAttr is the directory attribute matched against the lookup claim
+	UserAttr string `json:"user_attr"`
+
+	// SCIMToken is the bearer token used to authenticate SCIM requests
+	SCIMToken string `json:"scim_token"`
+
+	// Attributes restricts the directory attributes returned to templates
+	Attributes []string `json:"attributes"`
+
+	// LookupClaim is the subject token claim used to find the directory user
+	LookupClaim string `json:"lookup_claim"`
+
+	// CacheTTL is how long successful lookups are cached
+	CacheTTL time.Duration `json:"cache_ttl"`
+
+	// FailurePolicy controls exchanges when a lookup fails: "deny" or "ignore"
+	FailurePolicy string `json:"failure_policy"`
+
+	// RequestTimeout bounds each directory request
+	RequestTimeout time.Duration `json:"request_timeout"`
+
+	// CAPEM is a PEM bundle of CAs trusted when connecting to the directory
+	CAPEM string `json:"ca_pem,omitempty"`
+}
+
+const (
+	directoryConfigStoragePath = "config/directory"
+
+	// Supported directory connector types
+	DirectoryTypeLDAP = "ldap"
+	DirectoryTypeSCIM = "scim"
+
+	// Supported directory failure policies
+	DirectoryFailureDeny   = "deny"
+	DirectoryFailureIgnore = "ignore"
+
+	defaultDirectoryRequestTimeout = 10 * time.Second
+
+	// maxSCIMResponseSize bounds the SCIM response read for a lookup
+	maxSCIMResponseSize = 1 << 20
+
+	// scimExtensionPrefix identifies SCIM schema extensions whose
+	// attributes are flattened into the top-level attribute map
+	scimExtensionPrefix = "urn:ietf:params:scim:schemas:extension:"
+)
+
+// directoryClient resolves directory attributes for a user
+type directoryClient interface {
+	Lookup(ctx context.Context, value string) (map[string]any, error)
+}
+
+// directoryCacheEntry is a cached directory lookup result
+type directoryCacheEntry struct {
+	attributes map[string]any
+	expiresAt  time.Time
+}
+
+// defaultUserAttr returns the attribute matched against the lookup claim when
+// none is configured
+func defaultUserAttr(directoryType string) string {
+	if directoryType == DirectoryTypeSCIM {
+		return "emails.value"
+	}
+	return "mail"
+}
+
+// tlsConfig returns the TLS settings used to connect to the directory,
+// trusting CAPEM in place of the system roots when it is set
+func (c *DirectoryConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CAPEM)) {
+			return nil, fmt.Errorf("ca_pem contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// newDirectoryClient creates the client for the configured connector type
+func newDirectoryClient(config *DirectoryConfig) (directoryClient, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case DirectoryTypeLDAP:
+		return &ldapDirectoryClient{config: config, tlsConfig: tlsConfig}, nil
+	case DirectoryTypeSCIM:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		return &scimDirectoryClient{
+			config: config,
+			client: &http.Client{Transport: transport, Timeout: config.RequestTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported directory type: %s", config.Type)
+	}
+}
+
+// filterAttributes keeps only the requested attributes, or all when none are requested
+func filterAttributes(attrs map[string]any, requested []string) map[string]any {
+	if len(requested) == 0 {
+		return attrs
+	}
+
+	out := make(map[string]any, len(requested))
+	for _, name := range requested {
+		if v, ok := attrs[name]; ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// ldapDirectoryClient looks up users with an LDAP bind and search
+type ldapDirectoryClient struct {
+	config    *DirectoryConfig
+	tlsConfig *tls.Config
+}
+
+// Lookup searches UserDN for a single entry whose UserAttr equals value. The
+// connection is closed when ctx is done, which aborts a pending bind or search.
+func (c *ldapDirectoryClient) Lookup(ctx context.Context, value string) (map[string]any, error) {
+	dialer := &net.Dialer{Timeout: c.config.RequestTimeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(c.config.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(c.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetTimeout(c.config.RequestTimeout)
+
+	if c.config.BindDN != "" {
+		if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, fmt.Errorf("LDAP bind aborted: %w", ctxErr)
+			}
+			return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
+		}
+	}
+
+	filter := fmt.Sprintf("(%s=%s)", c.config.UserAttr, ldap.EscapeFilter(value))
+	searchReq := ldap.NewSearchRequest(
+		c.config.UserDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // only one match is expected, fetch two to detect ambiguity
+		int(c.config.RequestTimeout.Seconds()),
+		false,
+		filter,
+		c.config.Attributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchReq)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("LDAP search aborted: %w", ctxErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search failed: %w", err)
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("no directory entry found")
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("multiple directory entries found")
+	}
+
+	attrs := map[string]any{}
+	for _, attr := range result.Entries[0].Attributes {
+		if len(attr.Values) == 1 {
+			attrs[attr.Name] = attr.Values[0]
+		} else {
+			attrs[attr.Name] = attr.Values
+		}
+	}
+
+	return filterAttributes(attrs, c.config.Attributes), nil
+}
+
+// scimDirectoryClient looks up users with a SCIM 2.0 (RFC 7644) filter query
+type scimDirectoryClient struct {
+	config *DirectoryConfig
+	client *http.Client
+}
+
+// Lookup queries /Users for a single resource whose UserAttr equals value
+func (c *scimDirectoryClient) Lookup(ctx context.Context, value string) (map[string]any, error) {
+	filter := c.config.UserAttr + " eq " + scimQuote(value)
+	reqURL := strings.TrimRight(c.config.URL, "/") + "/Users?filter=" + url.QueryEscape(filter)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SCIM request: %w", err)
+	}
+	req.Header.Set("Accept", "application/scim+json")
+	if c.config.SCIMToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.SCIMToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("SCIM request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SCIM request failed with status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSCIMResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SCIM response: %w", err)
+	}
+
+	var list struct {
+		TotalResults int              `json:"totalResults"`
+		Resources    []map[string]any `json:"Resources"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode SCIM response: %w", err)
+	}
+
+	if len(list.Resources) == 0 {
+		return nil, fmt.Errorf("no directory entry found")
+	}
+	if len(list.Resources) > 1 {
+		return nil, fmt.Errorf("multiple directory entries found")
+	}
+
+	// Flatten schema extensions (e.g. the enterprise user extension) so that
+	// templates can reference {{identity.directory.department}}
+	attrs := map[string]any{}
+	for k, v := range list.Resources[0] {
+		if ext, ok := v.(map[string]any); ok && strings.HasPrefix(k, scimExtensionPrefix) {
+			for ek, ev := range ext {
+				if _, exists := attrs[ek]; !exists {
+					attrs[ek] = ev
+				}
+			}
+			continue
+		}
+		attrs[k] = v
+	}
+
+	return filterAttributes(attrs, c.config.Attributes), nil
+}
+
+// scimQuote renders value as a SCIM filter string. The filter grammar takes
+// JSON strings, so only quotes and backslashes need escaping and non-ASCII
+// characters are passed through as they are.
+func scimQuote(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		if r == '"' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// lookupDirectory resolves directory attributes for the subject, using the
+// per-mount cache when possible. A nil config disables enrichment.
+func (b *Backend) lookupDirectory(ctx context.Context, config *DirectoryConfig, subjectClaims map[string]any) (map[string]any, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	value, ok := subjectClaims[config.LookupClaim].(string)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("subject token missing %s claim", config.LookupClaim)
+	}
+
+	cacheKey := config.Type + ":" + value
+
+	b.cacheLock.RLock()
+	cached, ok := b.directoryCache[cacheKey]
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+	hit := ok && time.Now().Before(cached.expiresAt)
+	b.recordCacheLookup("directory", hit)
+	if hit {
+		return cached.attributes, nil
+	}
+
+	client, err := newDirectoryClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := client.Lookup(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.CacheTTL > 0 {
+		b.cacheLock.Lock()
+		if b.cacheGeneration == generation {
+			b.directoryCache[cacheKey] = &directoryCacheEntry{
+				attributes: attrs,
+				expiresAt:  time.Now().Add(config.CacheTTL),
+			}
+		}
+		b.cacheLock.Unlock()
+	}
+
+	return attrs, nil
+}
+
+// resetDirectoryCache drops all cached directory lookups
+func (b *Backend) resetDirectoryCache() {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	b.cacheGeneration++
+	b.directoryCache = make(map[string]*directoryCacheEntry)
+}
@@ -0,0 +1,136 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// dpopProofType is the typ header of RFC 9449 DPoP proofs
+const dpopProofType = "dpop+jwt"
+
+// dpopProofMaxAge is how far a proof's iat may be from now. Proofs are
+// remembered for this long on each side of iat to reject replays.
+const dpopProofMaxAge = 5 * time.Minute
+
+// dpopProof is the part of a DPoP proof's claims checked at the exchange
+type dpopProof struct {
+	JTI string           `json:"jti"`
+	HTM string           `json:"htm"`
+	HTU string           `json:"htu"`
+	IAT *jwt.NumericDate `json:"iat"`
+}
+
+// validateDPoPProof verifies a DPoP proof for a request to endpoint and
+// returns the RFC 7638 SHA-256 thumbprint of its public key and its jti.
+// endpoint is compared without query or fragment; when it has no scheme and
+// host only the path is compared.
+func validateDPoPProof(proof string, endpoint *url.URL, now time.Time) (string, string, error) {
+	parsed, err := jwt.ParseSigned(proof, supportedSubjectTokenAlgorithms)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse proof: %w", err)
+	}
+	header := parsed.Headers[0]
+
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != dpopProofType {
+		return "", "", fmt.Errorf("proof typ must be %q", dpopProofType)
+	}
+	jwk := header.JSONWebKey
+	if jwk == nil || !jwk.Valid() || !jwk.IsPublic() {
+		return "", "", fmt.Errorf("proof must carry a public jwk header")
+	}
+
+	claims := &dpopProof{}
+	if err := parsed.Claims(jwk.Key, claims); err != nil {
+		return "", "", fmt.Errorf("failed to verify proof: %w", err)
+	}
+
+	if claims.JTI == "" {
+		return "", "", fmt.Errorf("proof has no jti")
+	}
+	if claims.HTM != http.MethodPost {
+		return "", "", fmt.Errorf("proof htm must be %q", http.MethodPost)
+	}
+	htu, err := url.Parse(claims.HTU)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid proof htu: %w", err)
+	}
+	if htu.Path != endpoint.Path || (endpoint.Host != "" && (htu.Scheme != endpoint.Scheme || htu.Host != endpoint.Host)) {
+		return "", "", fmt.Errorf("proof htu %q does not match the exchange endpoint", claims.HTU)
+	}
+	if claims.IAT == nil {
+		return "", "", fmt.Errorf("proof has no iat")
+	}
+	if age := now.Sub(claims.IAT.Time()); age > dpopProofMaxAge || age < -dpopProofMaxAge {
+		return "", "", fmt.Errorf("proof iat is more than %s from now", dpopProofMaxAge)
+	}
+
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to compute jwk thumbprint: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumbprint), claims.JTI, nil
+}
+
+// dpopProofFingerprint identifies a DPoP proof for replay detection. It is
+// stored with consumed subject tokens, so it is hashed under its own prefix.
+func dpopProofFingerprint(jkt, jti string) string {
+	sum := sha256.Sum256([]byte("dpop\x00" + jkt + "\x00" + jti))
+	return hex.EncodeToString(sum[:])
+}
+
+// dpopEndpoint returns the URL DPoP proofs for the request must be bound to.
+// Without api_addr only the path is known.
+func dpopEndpoint(config *Config, req *logical.Request) *url.URL {
+	path := "/v1/" + req.MountPoint + req.Path
+	if config.APIAddr != "" {
+		if endpoint, err := url.Parse(config.APIAddr + path); err == nil {
+			return endpoint
+		}
+	}
+	return &url.URL{Path: path}
+}
+
+// validateDPoP checks the DPoP proof sent with the exchange, from the
+// dpop_proof field or the DPoP header, and binds the token to its key
+func (b *Backend) validateDPoP(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	proof := ex.data.Get("dpop_proof").(string)
+	if proof == "" && ex.req.Headers != nil {
+		proof = http.Header(ex.req.Headers).Get("DPoP")
+	}
+
+	if proof == "" {
+		if ex.role.RequireDPoP {
+			return exchangeError(ErrCodeInvalidRequest, "role %q requires a DPoP proof", ex.roleName), nil
+		}
+		return nil, nil
+	}
+
+	now := time.Now()
+	jkt, jti, err := validateDPoPProof(proof, dpopEndpoint(ex.config, ex.req), now)
+	if err != nil {
+		return exchangeError(ErrCodeInvalidDPoPProof, "invalid DPoP proof: %v", err), nil
+	}
+
+	fresh, err := b.consumeSubjectToken(ctx, ex.req.Storage, dpopProofFingerprint(jkt, jti), now.Add(2*dpopProofMaxAge))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return exchangeError(ErrCodeInvalidDPoPProof, "DPoP proof has already been used"), nil
+	}
+
+	ex.dpopThumbprint = jkt
+	return nil, nil
+}
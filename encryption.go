@@ -0,0 +1,191 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Issued tokens are encrypted with A256GCM, under a key wrapped with
+// RSA-OAEP-256 or ECDH-ES+A256KW depending on the recipient key
+const tokenContentEncryption = jose.A256GCM
+
+// encryptionJWKSCacheTTL is how long a fetched encryption JWKS is reused
+const encryptionJWKSCacheTTL = 5 * time.Minute
+
+// defaultEncryptionJWKSTimeout bounds an encryption JWKS fetch
+const defaultEncryptionJWKSTimeout = 10 * time.Second
+
+// maxEncryptionJWKSSize bounds the encryption JWKS read into memory
+const maxEncryptionJWKSSize = 1 << 20
+
+// encryptionJWKSHTTPClient fetches encryption JWKS. It does not follow
+// redirects, so the recipient key always comes from encryption_jwks_uri.
+var encryptionJWKSHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// errEncryptionJWKSUnavailable marks encryption failures caused by the
+// recipient's JWKS being unreachable
+var errEncryptionJWKSUnavailable = errors.New("encryption JWKS unavailable")
+
+// parseEncryptionKey parses a role's encryption_key: a PEM RSA or EC public
+// key or certificate, or a JWK
+func parseEncryptionKey(encoded string) (*jose.JSONWebKey, error) {
+	key := &jose.JSONWebKey{}
+	if strings.HasPrefix(strings.TrimSpace(encoded), "{") {
+		if err := key.UnmarshalJSON([]byte(encoded)); err != nil {
+			return nil, fmt.Errorf("failed to parse JWK: %w", err)
+		}
+		if !key.IsPublic() {
+			return nil, fmt.Errorf("JWK is not a public key")
+		}
+	} else {
+		publicKey, err := parseSubjectPublicKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = publicKey
+	}
+
+	if _, err := keyEncryptionAlgorithm(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// keyEncryptionAlgorithm returns the algorithm that wraps content keys for key
+func keyEncryptionAlgorithm(key *jose.JSONWebKey) (jose.KeyAlgorithm, error) {
+	switch key.Key.(type) {
+	case *rsa.PublicKey:
+		return jose.RSA_OAEP_256, nil
+	case *ecdsa.PublicKey:
+		return jose.ECDH_ES_A256KW, nil
+	default:
+		return "", fmt.Errorf("unsupported encryption key type %T: use an RSA or EC key", key.Key)
+	}
+}
+
+// encryptToken wraps a signed token in a JWE for key, producing a nested JWT
+// (RFC 7519 Section 5.2)
+func encryptToken(token string, key *jose.JSONWebKey) (string, error) {
+	algorithm, err := keyEncryptionAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+
+	opts := (&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT")
+	encrypter, err := jose.NewEncrypter(tokenContentEncryption, jose.Recipient{Algorithm: algorithm, Key: key.Key, KeyID: key.KeyID}, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create encrypter: %w", err)
+	}
+
+	encrypted, err := encrypter.Encrypt([]byte(token))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	return encrypted.CompactSerialize()
+}
+
+// encryptionKey returns the key a role's tokens are encrypted to, or nil when
+// the role does not encrypt
+func (b *Backend) encryptionKey(ctx context.Context, role *Role) (*jose.JSONWebKey, error) {
+	switch {
+	case role.EncryptionKey != "":
+		return parseEncryptionKey(role.EncryptionKey)
+	case role.EncryptionJWKSURI != "":
+		keySet, err := b.getEncryptionJWKS(ctx, role.EncryptionJWKSURI)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errEncryptionJWKSUnavailable, err)
+		}
+		for _, key := range keySet.Keys {
+			if key.Use != "" && key.Use != "enc" {
+				continue
+			}
+			if _, err := keyEncryptionAlgorithm(&key); err == nil {
+				return &key, nil
+			}
+		}
+		return nil, fmt.Errorf("encryption_jwks_uri has no RSA or EC encryption key")
+	}
+	return nil, nil
+}
+
+// getEncryptionJWKS returns the JWKS at uri, from the cache when possible
+func (b *Backend) getEncryptionJWKS(ctx context.Context, uri string) (*jose.JSONWebKeySet, error) {
+	b.cacheLock.RLock()
+	entry := b.encryptionJWKSCache[uri]
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+	if entry != nil && time.Now().Before(entry.expiresAt) {
+		return entry.keySet, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultEncryptionJWKSTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := encryptionJWKSHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS fetch failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEncryptionJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS: %w", err)
+	}
+
+	keySet := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal(body, keySet); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.encryptionJWKSCache[uri] = &jwksCacheEntry{keySet: keySet, expiresAt: time.Now().Add(encryptionJWKSCacheTTL)}
+	}
+	b.cacheLock.Unlock()
+
+	return keySet, nil
+}
+
+// encryptIssuedToken replaces the signed token in the response with a JWE
+// encrypted to the role's encryption key
+func (b *Backend) encryptIssuedToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	key, err := b.encryptionKey(ctx, ex.role)
+	if errors.Is(err, errEncryptionJWKSUnavailable) {
+		return exchangeError(ErrCodeTemporarilyUnavailable, "failed to resolve encryption key: %v", err), nil
+	}
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q: failed to resolve encryption key: %v", ex.roleName, err), nil
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	encrypted, err := encryptToken(ex.issued.Token, key)
+	if err != nil {
+		return nil, err
+	}
+	ex.respData["token"] = encrypted
+	return nil, nil
+}
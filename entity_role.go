@@ -0,0 +1,47 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// byEntityRoleName is the role name on the token path that exchanges with the
+// role named by the requesting entity's metadata. Roles cannot use it.
+const byEntityRoleName = "by-entity"
+
+// resolveEntityRole names the role of token/by-entity exchanges and refreshes
+// from the metadata key the config sets in entity_role_metadata_key, so one
+// ACL on token/by-entity covers a whole fleet of entities
+func (b *Backend) resolveEntityRole(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.roleName != byEntityRoleName {
+		return nil, nil
+	}
+
+	config, err := b.getConfig(ctx, ex.req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.EntityRoleMetadataKey == "" {
+		return exchangeError(ErrCodeInvalidRequest, "token/%s is disabled: the config does not set entity_role_metadata_key", byEntityRoleName), nil
+	}
+	key := config.EntityRoleMetadataKey
+
+	if ex.req.EntityID == "" {
+		return exchangeError(ErrCodeAccessDenied, "token/%s requires a request from an entity", byEntityRoleName), nil
+	}
+	entity, err := fetchEntity(ex.req, b.identityView(ctx))
+	if errors.Is(err, errIdentityStoreUnavailable) {
+		return exchangeError(ErrCodeIdentityUnavailable, "failed to look up entity: %v", err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entity == nil || entity.Metadata[key] == "" {
+		return exchangeError(ErrCodeAccessDenied, "entity %q has no %s metadata naming its role", ex.req.EntityID, key), nil
+	}
+
+	ex.roleName = entity.Metadata[key]
+	return nil, nil
+}
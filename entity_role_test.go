@@ -0,0 +1,78 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenByEntity tests that token/by-entity exchanges and refreshes with
+// the role named by the requesting entity's metadata
+func TestTokenByEntity(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	request := func(path string, data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+	subjectToken := func() map[string]any {
+		return map[string]any{"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims())}
+	}
+
+	resp := request("token/by-entity", subjectToken())
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "does not set entity_role_metadata_key")
+
+	// The test entity has team=platform in its metadata
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"entity_role_metadata_key": "team"})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	resp = request("token/by-entity", subjectToken())
+	require.True(t, resp.IsError(), "role platform does not exist yet")
+	require.Contains(t, resp.Error().Error(), `"platform"`)
+
+	resp = writeRole(t, b, storage, "platform", map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{"act": {"sub": "platform-agent"}}`,
+		"subject_template": `{}`,
+		"context":          "urn:documents:read",
+		"refresh_ttl":      "1h",
+	})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+	resp = request("token/by-entity", subjectToken())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "platform-agent", claims["act"].(map[string]any)["sub"])
+
+	resp = request("token/by-entity/refresh", map[string]any{"refresh_token": resp.Data["refresh_token"]})
+	require.False(t, resp.IsError(), "refresh failed: %v", resp.Error())
+
+	t.Run("missing metadata", func(t *testing.T) {
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"entity_role_metadata_key": "delegation_role"})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+		resp = request("token/by-entity", subjectToken())
+		requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+		require.Contains(t, resp.Error().Error(), `entity "test-entity" has no delegation_role metadata naming its role`)
+	})
+
+	t.Run("reserved role name", func(t *testing.T) {
+		resp := writeRole(t, b, storage, "by-entity", map[string]any{"ttl": "1h"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "is reserved")
+	})
+}
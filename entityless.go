@@ -0,0 +1,60 @@
+package tokenexchange
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// resolveActor reads actor_id, which names the actor of callers without a
+// Vault entity. Roles with allow_entityless accept such callers, and the
+// exchange uses an entity named by actor_id in place of the caller's.
+func (b *Backend) resolveActor(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	actorID := ex.data.Get("actor_id").(string)
+	if ex.req.EntityID != "" {
+		if actorID != "" {
+			return exchangeError(ErrCodeInvalidRequest, "actor_id is only accepted from callers without a Vault entity"), nil
+		}
+		return nil, nil
+	}
+
+	// Other roles deny callers without an entity once they are authorized
+	if !ex.role.AllowEntityless {
+		return nil, nil
+	}
+	if actorID == "" {
+		return exchangeError(ErrCodeInvalidRequest, "actor_id is required from callers without a Vault entity"), nil
+	}
+	if !requestIDPattern.MatchString(actorID) {
+		return exchangeError(ErrCodeInvalidRequest, "actor_id must be 1 to 128 letters, digits or ._:/+=- characters"), nil
+	}
+
+	ex.actorID = actorID
+	ex.entity = &logical.Entity{Name: actorID, Metadata: map[string]string{}}
+	ex.groups = []*logical.Group{}
+	return nil, nil
+}
+
+// callerID identifies the caller in rate limits and usage counters: its
+// entity ID, or for callers without an entity the accessor of their token.
+// actor_id is chosen by the caller, so it is never part of the key, and
+// callers whose tokens have no accessor, such as batch tokens, share one
+// entityless bucket.
+func (ex *exchange) callerID() string {
+	if ex.req.EntityID != "" {
+		return ex.req.EntityID
+	}
+	if ex.req.ClientTokenAccessor != "" {
+		return "token:" + ex.req.ClientTokenAccessor
+	}
+	return "entityless"
+}
+
+// defaultActorSubject returns the act.sub of tokens whose actor template
+// sets none
+func (ex *exchange) defaultActorSubject() string {
+	if ex.actorID != "" {
+		return "actor:" + ex.actorID
+	}
+	return "entity:" + ex.req.EntityID
+}
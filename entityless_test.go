@@ -0,0 +1,133 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEntitylessExchange tests that roles with allow_entityless accept
+// callers without a Vault entity, which name the actor with actor_id
+func TestEntitylessExchange(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	exchange := func(entityID string, data map[string]any) *logical.Response {
+		data["subject_token"] = generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	resp := exchange("", map[string]any{"actor_id": "batch-job-7"})
+	requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+	require.Contains(t, resp.Error().Error(), `role "test-role" requires a caller with a Vault entity`)
+
+	resp = writeRole(t, b, storage, "test-role", map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{}`,
+		"subject_template": `{}`,
+		"context":          "urn:documents:read",
+		"allow_entityless": true,
+		"refresh_ttl":      "1h",
+	})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+	require.Equal(t, true, readRole(t, b, storage, "test-role")["allow_entityless"])
+
+	resp = exchange("", map[string]any{})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "actor_id is required")
+
+	resp = exchange("", map[string]any{"actor_id": "batch-job-7"})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Empty(t, resp.Data["refresh_token"], "refreshes are bound to an entity")
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "actor:batch-job-7", claims["act"].(map[string]any)["sub"])
+
+	// Callers with an entity are still the actor themselves
+	resp = exchange("test-entity", map[string]any{"actor_id": "batch-job-7"})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	resp = exchange("test-entity", map[string]any{})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "entity:test-entity", claims["act"].(map[string]any)["sub"])
+
+	t.Run("actor template", func(t *testing.T) {
+		resp := writeRole(t, b, storage, "test-role", map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{"act": {"sub": "{{identity.entity.name}}"}}`,
+			"subject_template": `{}`,
+			"context":          "urn:documents:read",
+			"allow_entityless": true,
+		})
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		resp = exchange("", map[string]any{"actor_id": "batch-job-7"})
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "batch-job-7", claims["act"].(map[string]any)["sub"])
+	})
+
+	t.Run("rate limits", func(t *testing.T) {
+		resp := writeRole(t, b, storage, "limited-role", map[string]any{
+			"ttl":                      "1h",
+			"key":                      "test-key",
+			"actor_template":           `{}`,
+			"subject_template":         `{}`,
+			"context":                  "urn:documents:read",
+			"allow_entityless":         true,
+			"max_exchanges_per_minute": 1,
+		})
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		limited := func(actorID string) (*logical.Response, error) {
+			return b.HandleRequest(ctx, &logical.Request{
+				Operation:           logical.UpdateOperation,
+				Path:                "token/limited-role",
+				Storage:             storage,
+				ClientTokenAccessor: "accessor-123",
+				Data: map[string]any{
+					"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+					"actor_id":      actorID,
+				},
+			})
+		}
+
+		// A new actor_id does not get a new limit
+		resp, err := limited("limited-1")
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		resp, err = limited("limited-2")
+		require.ErrorIs(t, err, logical.ErrRateLimitQuotaExceeded)
+		requireExchangeError(t, resp, ErrCodeRateLimited, true)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := exchange("", map[string]any{"actor_id": "batch job"})
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+		resp = writeRole(t, b, storage, "bound-role", map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{}`,
+			"subject_template": `{}`,
+			"context":          "urn:documents:read",
+			"allow_entityless": true,
+			"bound_entity_ids": "test-entity",
+		})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "allow_entityless cannot be combined with bound_entity_ids")
+	})
+}
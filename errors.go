@@ -0,0 +1,72 @@
+package tokenexchange
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Exchange error codes returned in the "data" of error responses, modeled on
+// OAuth 2.0 error codes (RFC 6749 Section 5.2 and RFC 8693 Section 2.2.2)
+const (
+	ErrCodeInvalidRequest         = "invalid_request"
+	ErrCodeInvalidTarget          = "invalid_target"
+	ErrCodeInvalidScope           = "invalid_scope"
+	ErrCodeInvalidSubjectToken    = "invalid_subject_token"
+	ErrCodeExpiredSubjectToken    = "expired_subject_token"
+	ErrCodeReplayedSubjectToken   = "replayed_subject_token"
+	ErrCodeAccessDenied           = "access_denied"
+	ErrCodeDelegationExpired      = "delegation_expired"
+	ErrCodeRateLimited            = "rate_limited"
+	ErrCodeDirectoryLookupFailed  = "directory_lookup_failed"
+	ErrCodeUpstreamError          = "upstream_error"
+	ErrCodeServerError            = "server_error"
+	ErrCodeTemporarilyUnavailable = "temporarily_unavailable"
+	ErrCodeIdentityUnavailable    = "identity_store_unavailable"
+	ErrCodeInvalidDPoPProof       = "invalid_dpop_proof"
+	ErrCodeInvalidGrant           = "invalid_grant"
+)
+
+// retryableErrorCodes are the codes where repeating the same request later
+// may succeed. All other codes are terminal for the request as sent.
+var retryableErrorCodes = map[string]bool{
+	ErrCodeRateLimited:            true,
+	ErrCodeDirectoryLookupFailed:  true,
+	ErrCodeUpstreamError:          true,
+	ErrCodeTemporarilyUnavailable: true,
+	ErrCodeIdentityUnavailable:    true,
+}
+
+// isExchangeErrorCode reports whether code is one of the exchange error codes
+func isExchangeErrorCode(code string) bool {
+	switch code {
+	case ErrCodeInvalidRequest, ErrCodeInvalidTarget, ErrCodeInvalidScope, ErrCodeInvalidSubjectToken,
+		ErrCodeExpiredSubjectToken, ErrCodeReplayedSubjectToken, ErrCodeAccessDenied,
+		ErrCodeDelegationExpired, ErrCodeRateLimited, ErrCodeDirectoryLookupFailed,
+		ErrCodeUpstreamError, ErrCodeServerError, ErrCodeTemporarilyUnavailable,
+		ErrCodeIdentityUnavailable, ErrCodeInvalidDPoPProof, ErrCodeInvalidGrant:
+		return true
+	}
+	return false
+}
+
+// errJWKSUnavailable marks subject token validation failures caused by the
+// issuer's JWKS being unreachable rather than by the token itself
+var errJWKSUnavailable = errors.New("subject JWKS unavailable")
+
+// errJWKSCircuitOpen is returned instead of fetching while the JWKS circuit
+// breaker is open
+var errJWKSCircuitOpen = errors.New("subject JWKS fetches paused after repeated failures")
+
+// exchangeError returns an error response whose data carries a machine
+// readable error code, a description and whether the request is retryable
+func exchangeError(code, format string, args ...any) *logical.Response {
+	description := fmt.Sprintf(format, args...)
+
+	return logical.ErrorResponseWithData(map[string]any{
+		"error":             code,
+		"error_description": description,
+		"retryable":         retryableErrorCodes[code],
+	}, "%s", description)
+}
@@ -0,0 +1,118 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Event types published to Vault's event bus and the event webhook
+const (
+	eventTokenIssue = "identity-delegation/token-issue"
+	eventKeyRotate  = "identity-delegation/key-rotate"
+	eventKeyDelete  = "identity-delegation/key-delete"
+)
+
+// eventWebhookTimeout bounds the delivery of an event to the event webhook
+const eventWebhookTimeout = 5 * time.Second
+
+// eventWebhookHTTPClient delivers events. It does not follow redirects, which
+// would send event_webhook_token to wherever the webhook points.
+var eventWebhookHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// eventWebhookPayload is the body posted to the event webhook
+type eventWebhookPayload struct {
+	EventType string            `json:"event_type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Data      map[string]string `json:"data"`
+}
+
+// publishEvent sends an event to Vault's event bus and, when configured, the
+// event webhook. Delivery is best effort: failures are logged and never fail
+// the operation that caused the event.
+func (b *Backend) publishEvent(ctx context.Context, config *Config, eventType string, data map[string]string) {
+	metadata := make([]string, 0, 2*len(data))
+	for k, v := range data {
+		metadata = append(metadata, k, v)
+	}
+	if err := logical.SendEvent(ctx, b, eventType, metadata...); err != nil && !errors.Is(err, framework.ErrNoEvents) {
+		b.Logger().Warn("failed to send event", "event_type", eventType, "error", err)
+	}
+
+	if config == nil || config.EventWebhookURL == "" {
+		return
+	}
+	payload := &eventWebhookPayload{EventType: eventType, Timestamp: time.Now().UTC(), Data: data}
+	url, token := config.EventWebhookURL, config.EventWebhookToken
+
+	// The webhook is called in the background so a slow SIEM cannot delay
+	// exchanges or key operations
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventWebhookTimeout)
+		defer cancel()
+
+		if err := postEvent(ctx, url, token, payload); err != nil {
+			b.Logger().Warn("failed to deliver event to webhook", "event_type", eventType, "error", err)
+		}
+	}()
+}
+
+// postEvent posts an event to the event webhook
+func postEvent(ctx context.Context, url, token string, payload *eventWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create event request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := eventWebhookHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("event webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// publishIssuance publishes a token-issue event for every issued token
+func (b *Backend) publishIssuance(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.succeeded() || ex.issued == nil {
+		return nil, nil
+	}
+
+	b.publishEvent(ctx, ex.config, eventTokenIssue, map[string]string{
+		"role":       ex.roleName,
+		"entity_id":  ex.req.EntityID,
+		"subject":    ex.issued.Subject,
+		"actor":      ex.issued.Actor,
+		"jti":        ex.issued.JTI,
+		"scope":      ex.issued.Scope,
+		"key_id":     ex.issued.KeyID,
+		"expires_at": strconv.FormatInt(ex.issued.ExpiresAt.Unix(), 10),
+		"txn":        ex.txn,
+	})
+	return nil, nil
+}
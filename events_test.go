@@ -0,0 +1,124 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// getTestBackendWithEvents returns a test backend that records the events it
+// sends to Vault's event bus
+func getTestBackendWithEvents(t *testing.T) (*Backend, logical.Storage, *logical.MockEventSender) {
+	events := logical.NewMockEventSender()
+	config := &logical.BackendConfig{
+		Logger:       hclog.NewNullLogger(),
+		System:       &logical.StaticSystemView{EntityVal: &logical.Entity{ID: "test-entity", Name: "test-entity-name"}},
+		StorageView:  &logical.InmemStorage{},
+		EventsSender: events,
+	}
+
+	b, err := Factory(context.Background(), config)
+	require.NoError(t, err)
+
+	return b.(*Backend), config.StorageView, events
+}
+
+// eventMetadata returns the type and metadata of a recorded event
+func eventMetadata(event logical.MockEvent) (string, map[string]any) {
+	return string(event.Type), event.Event.Metadata.AsMap()
+}
+
+func TestEvents_VaultEventBus(t *testing.T) {
+	b, storage, events := getTestBackendWithEvents(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/test-key/rotate", Storage: storage})
+	require.NoError(t, err)
+	createTestKey(t, b, storage, "unused-key")
+	_, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.DeleteOperation, Path: "key/unused-key", Storage: storage})
+	require.NoError(t, err)
+
+	require.Len(t, events.Events, 3)
+
+	eventType, metadata := eventMetadata(events.Events[0])
+	require.Equal(t, eventTokenIssue, eventType)
+	require.Equal(t, "test-role", metadata["role"])
+	require.Equal(t, "test-entity", metadata["entity_id"])
+	require.Equal(t, "user-123", metadata["subject"])
+	require.Equal(t, "urn:documents:read", metadata["scope"])
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, claims["jti"], metadata["jti"])
+
+	eventType, metadata = eventMetadata(events.Events[1])
+	require.Equal(t, eventKeyRotate, eventType)
+	require.Equal(t, map[string]any{"key": "test-key", "key_id": metadata["key_id"], "version": "2", "trigger": rotationTriggerManual}, metadata)
+
+	eventType, metadata = eventMetadata(events.Events[2])
+	require.Equal(t, eventKeyDelete, eventType)
+	require.Equal(t, map[string]any{"key": "unused-key"}, metadata)
+}
+
+func TestEvents_Webhook(t *testing.T) {
+	received := make(chan *eventWebhookPayload, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer siem-token", r.Header.Get("Authorization"))
+		payload := &eventWebhookPayload{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(payload))
+		received <- payload
+	}))
+	t.Cleanup(webhook.Close)
+
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"event_webhook_url": webhook.URL, "event_webhook_token": "siem-token"}))
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	select {
+	case payload := <-received:
+		require.Equal(t, eventTokenIssue, payload.EventType)
+		require.Equal(t, "test-role", payload.Data["role"])
+		require.Equal(t, "test-entity", payload.Data["entity_id"])
+		require.Equal(t, "urn:documents:read", payload.Data["scope"])
+		require.NotEmpty(t, payload.Data["jti"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("event webhook was not called")
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, true, resp.Data["event_webhook_token_configured"])
+	require.NotContains(t, resp.Data, "event_webhook_token")
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"event_webhook_url": "ftp://siem.example.com"})
+	require.True(t, resp.IsError())
+}
+
+// TestEvents_WebhookRedirect tests that a redirect from the event webhook is
+// not followed with the bearer token
+func TestEvents_WebhookRedirect(t *testing.T) {
+	followed := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed <- struct{}{}
+	}))
+	t.Cleanup(target.Close)
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	t.Cleanup(redirector.Close)
+
+	err := postEvent(context.Background(), redirector.URL, "siem-token", &eventWebhookPayload{EventType: eventTokenIssue})
+	require.ErrorContains(t, err, "status 307")
+	require.Empty(t, followed)
+}
@@ -0,0 +1,304 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// exportFormatVersion is the version of the export document format
+const exportFormatVersion = 1
+
+// importWrappingKeyStoragePath holds the key that unwraps imported private keys
+const importWrappingKeyStoragePath = "import/wrapping_key"
+
+// importWrappingKeySize is the RSA size of a generated import wrapping key
+const importWrappingKeySize = 4096
+
+// exportDocument is a mount's configuration, roles, template fragments, scope
+// definitions and keys, as exported for import into another mount
+type exportDocument struct {
+	Version         int                          `json:"version"`
+	ExportedAt      time.Time                    `json:"exported_at"`
+	Config          *Config                      `json:"config,omitempty"`
+	Roles           map[string]*Role             `json:"roles,omitempty"`
+	TemplateLibrary map[string]*TemplateFragment `json:"template_library,omitempty"`
+	Scopes          map[string]*ScopeDefinition  `json:"scopes,omitempty"`
+	Keys            map[string]*exportedKey      `json:"keys,omitempty"`
+
+	// OmittedSecrets lists the credentials left out of the document, which
+	// must be written again after import
+	OmittedSecrets []string `json:"omitted_secrets,omitempty"`
+}
+
+// exportedKey is a key without its private key. PublicKey is set for every
+// key type. WrappedPrivateKey is the private key of an internal key encrypted
+// to the importing mount's wrapping key, when private keys were exported.
+type exportedKey struct {
+	*Key
+	WrappedPrivateKey string `json:"wrapped_private_key,omitempty"`
+}
+
+// exportMount builds the export document of the mount. Internal keys' private
+// keys are wrapped to wrappingKey, or left out when it is nil.
+func (b *Backend) exportMount(ctx context.Context, storage logical.Storage, wrappingKey *rsa.PublicKey, now time.Time) (*exportDocument, error) {
+	doc := &exportDocument{
+		Version:         exportFormatVersion,
+		ExportedAt:      now.UTC(),
+		Roles:           make(map[string]*Role),
+		TemplateLibrary: make(map[string]*TemplateFragment),
+		Scopes:          make(map[string]*ScopeDefinition),
+		Keys:            make(map[string]*exportedKey),
+	}
+
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		doc.OmittedSecrets = append(doc.OmittedSecrets, omitConfigSecrets(config)...)
+		doc.Config = config
+	}
+
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		if role.UpstreamSTS != nil && role.UpstreamSTS.ClientSecret != "" {
+			role.UpstreamSTS.ClientSecret = ""
+			delete(role.Parameters, "upstream_client_secret")
+			doc.OmittedSecrets = append(doc.OmittedSecrets, "role/"+name+": upstream_client_secret")
+		}
+		doc.Roles[name] = role
+	}
+
+	fragmentNames, err := storage.List(ctx, templateLibraryStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template fragments: %w", err)
+	}
+	for _, name := range fragmentNames {
+		fragment, err := b.getTemplateFragment(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if fragment != nil {
+			doc.TemplateLibrary[name] = fragment
+		}
+	}
+
+	scopeNames, err := storage.List(ctx, scopeStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scope definitions: %w", err)
+	}
+	for _, name := range scopeNames {
+		definition, err := getScopeDefinition(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if definition != nil {
+			doc.Scopes[name] = definition
+		}
+	}
+
+	keyNames, err := storage.List(ctx, keyStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, name := range keyNames {
+		key, err := b.getKey(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+		exported, err := exportKey(key, wrappingKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export key %q: %w", name, err)
+		}
+		doc.Keys[name] = exported
+	}
+
+	sort.Strings(doc.OmittedSecrets)
+	return doc, nil
+}
+
+// omitConfigSecrets clears the credentials of an exported config and returns
+// the names of those that were set
+func omitConfigSecrets(config *Config) []string {
+	var omitted []string
+	for name, secret := range map[string]*string{
+		"transit_token":               &config.TransitToken,
+		"token_lookup_token":          &config.TokenLookupToken,
+		"introspection_client_secret": &config.IntrospectionClientSecret,
+		"jwks_client_key":             &config.JWKSClientKey,
+		"subject_decryption_key":      &config.SubjectDecryptionKey,
+		"authorization_webhook_token": &config.AuthorizationWebhookToken,
+		"event_webhook_token":         &config.EventWebhookToken,
+	} {
+		if *secret != "" {
+			*secret = ""
+			omitted = append(omitted, "config: "+name)
+		}
+	}
+	return omitted
+}
+
+// exportKey returns key without its private key, which is wrapped to
+// wrappingKey when it is set
+func exportKey(key *Key, wrappingKey *rsa.PublicKey) (*exportedKey, error) {
+	exported := &exportedKey{Key: key}
+	if key.isTransit() || key.isManaged() {
+		return exported, nil
+	}
+
+	publicKey, err := key.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	privateKey := key.PrivateKey
+	key.PrivateKey = ""
+	key.PublicKey = encodePublicKeyPEM(publicKey)
+
+	if wrappingKey != nil {
+		exported.WrappedPrivateKey, err = wrapPrivateKey(privateKey, wrappingKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exported, nil
+}
+
+// wrapPrivateKey encrypts a PEM private key to wrappingKey as a compact JWE
+func wrapPrivateKey(privateKeyPEM string, wrappingKey *rsa.PublicKey) (string, error) {
+	encrypter, err := jose.NewEncrypter(tokenContentEncryption, jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: wrappingKey}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create encrypter: %w", err)
+	}
+	encrypted, err := encrypter.Encrypt([]byte(privateKeyPEM))
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap private key: %w", err)
+	}
+	return encrypted.CompactSerialize()
+}
+
+// unwrapPrivateKey decrypts a private key wrapped by wrapPrivateKey
+func unwrapPrivateKey(wrapped string, wrappingKey *rsa.PrivateKey) (string, error) {
+	encrypted, err := jose.ParseEncrypted(wrapped, []jose.KeyAlgorithm{jose.RSA_OAEP_256}, []jose.ContentEncryption{tokenContentEncryption})
+	if err != nil {
+		return "", fmt.Errorf("failed to parse wrapped private key: %w", err)
+	}
+	privateKeyPEM, err := encrypted.Decrypt(wrappingKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap private key, it must be wrapped to this mount's import wrapping key: %w", err)
+	}
+	return string(privateKeyPEM), nil
+}
+
+// importWrappingKey returns the mount's import wrapping key, generating it
+// on first use
+func (b *Backend) importWrappingKey(ctx context.Context, storage logical.Storage) (*rsa.PrivateKey, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	entry, err := storage.Get(ctx, importWrappingKeyStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import wrapping key: %w", err)
+	}
+	if entry != nil {
+		return parsePrivateKey(string(entry.Value))
+	}
+
+	privateKey, err := generateRSAKey(importWrappingKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate import wrapping key: %w", err)
+	}
+	entry = &logical.StorageEntry{Key: importWrappingKeyStoragePath, Value: []byte(encodePrivateKeyPEM(privateKey)), SealWrap: true}
+	if err := storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write import wrapping key: %w", err)
+	}
+	return privateKey, nil
+}
+
+// importedKey turns an exported key back into a stored key, unwrapping its
+// private key with wrappingKey. An internal key exported without its private
+// key gets a newly generated latest version, so tokens signed before the
+// export can still be verified for the verification TTL of the imported
+// config. It returns whether that happened.
+func importedKey(name string, exported *exportedKey, wrappingKey *rsa.PrivateKey, config *Config, now time.Time) (*Key, bool, error) {
+	if exported == nil || exported.Key == nil {
+		return nil, false, fmt.Errorf("key %q is empty", name)
+	}
+	key := exported.Key
+	key.Name = name
+	if key.isTransit() || key.isManaged() {
+		return key, false, nil
+	}
+
+	publicKey, err := parsePublicKeyPEM(key.PublicKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("key %q: failed to parse public key: %w", name, err)
+	}
+	key.PublicKey = ""
+
+	if exported.WrappedPrivateKey == "" {
+		if err := key.replacePrivateKey(publicKey, now, key.verificationTTL(config)); err != nil {
+			return nil, false, fmt.Errorf("key %q: %w", name, err)
+		}
+		return key, true, nil
+	}
+
+	privateKeyPEM, err := unwrapPrivateKey(exported.WrappedPrivateKey, wrappingKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("key %q: %w", name, err)
+	}
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, false, fmt.Errorf("key %q: failed to parse private key: %w", name, err)
+	}
+	if !privateKey.PublicKey.Equal(publicKey) {
+		return nil, false, fmt.Errorf("key %q: private key does not match public_key", name)
+	}
+	key.PrivateKey = privateKeyPEM
+	return key, false, nil
+}
+
+// replacePrivateKey gives an internal key whose private key is unavailable a
+// new latest version. The version with publicKey is retained for
+// verification like a rotated one.
+func (k *Key) replacePrivateKey(publicKey *rsa.PublicKey, now time.Time, verificationTTL time.Duration) error {
+	privateKey, err := generateRSAKey(publicKey.N.BitLen())
+	if err != nil {
+		return fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	previous := &KeyVersion{
+		Version:     k.Version,
+		KeyID:       k.KeyID,
+		PublicKey:   encodePublicKeyPEM(publicKey),
+		CreatedAt:   k.RotatedAt,
+		ExpiresAt:   now.Add(verificationTTL),
+		Certificate: k.Certificate,
+	}
+
+	previousCertificate, generated := k.Certificate, k.CertificateGenerated
+	k.PreviousVersions = append(k.verificationVersions(now), previous)
+	k.Version++
+	k.KeyID = generateKeyID(k.Name, k.Version)
+	k.PrivateKey = encodePrivateKeyPEM(privateKey)
+	k.RotatedAt = now
+	return k.rotateCertificate(previousCertificate, generated, now)
+}
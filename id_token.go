@@ -0,0 +1,116 @@
+package tokenexchange
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/go-uuid"
+)
+
+// maxNonceLength bounds the nonce echoed into ID tokens
+const maxNonceLength = 512
+
+// generateIDToken signs an OIDC ID token describing the subject and actor of
+// an issued delegated token. It shares the token's lifetime and key, and its
+// at_hash binds it to the token.
+func generateIDToken(config *Config, key *Key, signingKey any, issued *issuedToken, actorClaims, subjectClaims map[string]any, nonce string) (string, error) {
+	algorithm, err := signatureAlgorithm(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	atHash, err := accessTokenHash(issued.Token, algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	signerOpts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", key.KeyID)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: signingKey}, signerOpts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	jti, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate jti: %w", err)
+	}
+
+	// The ID token is meant for the delegated token's audience, or the actor
+	// when the token has none
+	var audience any = issued.Actor
+	if aud, ok := actorClaims["aud"]; ok {
+		audience = aud
+	}
+
+	act := map[string]any{
+		"sub": issued.Actor,
+		"iss": issued.Issuer,
+	}
+	if actClaim, ok := actorClaims["act"].(map[string]any); ok {
+		if name, ok := actClaim["name"].(string); ok {
+			act["name"] = name
+		}
+	}
+
+	claims := map[string]any{
+		"iss":     issued.Issuer,
+		"sub":     issued.Subject,
+		"aud":     audience,
+		"iat":     issued.IssuedAt.Unix(),
+		"exp":     issued.ExpiresAt.Unix(),
+		"jti":     jti,
+		"at_hash": atHash,
+		"act":     act,
+	}
+
+	// auth_time is when the user authenticated, so it comes from the subject
+	// token: its auth_time, or its iat when it has none
+	if authTime, ok := subjectClaims["auth_time"].(float64); ok {
+		claims["auth_time"] = int64(authTime)
+	} else if iat, ok := subjectClaims["iat"].(float64); ok {
+		claims["auth_time"] = int64(iat)
+	}
+
+	// Display claims let downstream apps show who the agent acts for
+	for _, name := range []string{"name", "email"} {
+		if value, ok := subjectClaims[name].(string); ok {
+			claims[name] = value
+		}
+	}
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	idToken, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize ID token: %w", err)
+	}
+
+	return idToken, nil
+}
+
+// accessTokenHash computes the OIDC at_hash of a token: the base64url encoded
+// left half of its hash, using the hash of the signing algorithm
+func accessTokenHash(token string, algorithm jose.SignatureAlgorithm) (string, error) {
+	var sum []byte
+	switch algorithm {
+	case jose.RS256, jose.PS256:
+		digest := sha256.Sum256([]byte(token))
+		sum = digest[:]
+	case jose.RS384, jose.PS384:
+		digest := sha512.Sum384([]byte(token))
+		sum = digest[:]
+	case jose.RS512, jose.PS512:
+		digest := sha512.Sum512([]byte(token))
+		sum = digest[:]
+	default:
+		return "", fmt.Errorf("unsupported algorithm for at_hash: %s", algorithm)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
@@ -0,0 +1,149 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Identity store lookups are bounded so a struggling identity store fails
+// exchanges quickly with identity_store_unavailable instead of piling up:
+//   - at most maxConcurrentIdentityLookups run at once; callers queue for up
+//     to identityQueueTimeout for a slot
+//   - each attempt is abandoned after identityLookupTimeout
+//   - failed attempts are retried identityLookupRetries times, backing off
+//     from identityRetryBaseDelay and doubling each time
+//   - after identityBreakerThreshold consecutive failed lookups, lookups fail
+//     fast for identityBreakerCooldown
+const (
+	maxConcurrentIdentityLookups = 8
+	identityLookupRetries        = 2
+	identityBreakerThreshold     = 5
+	identityBreakerCooldown      = 30 * time.Second
+)
+
+var (
+	identityQueueTimeout   = 2 * time.Second
+	identityLookupTimeout  = 5 * time.Second
+	identityRetryBaseDelay = 50 * time.Millisecond
+)
+
+// errIdentityStoreUnavailable marks failures caused by the identity store
+// rather than by the request
+var errIdentityStoreUnavailable = errors.New("identity store unavailable")
+
+var (
+	errIdentityQueueTimeout  = errors.New("timed out waiting for an identity lookup slot")
+	errIdentityLookupTimeout = errors.New("identity lookup timed out")
+	errIdentityCircuitOpen   = errors.New("identity lookups paused after repeated failures")
+)
+
+// identitySystemView is a SystemView whose entity and group lookups are
+// queued, retried and guarded by the backend's identity circuit breaker
+type identitySystemView struct {
+	logical.SystemView
+	ctx context.Context
+	b   *Backend
+}
+
+// identityView returns the system view to use for identity lookups during ctx
+func (b *Backend) identityView(ctx context.Context) logical.SystemView {
+	return &identitySystemView{SystemView: b.System(), ctx: ctx, b: b}
+}
+
+// EntityInfo looks up an entity through the identity lookup limits
+func (v *identitySystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	return lookupIdentity(v.ctx, v.b, func() (*logical.Entity, error) {
+		return v.SystemView.EntityInfo(entityID)
+	})
+}
+
+// GroupsForEntity looks up an entity's groups through the identity lookup limits
+func (v *identitySystemView) GroupsForEntity(entityID string) ([]*logical.Group, error) {
+	return lookupIdentity(v.ctx, v.b, func() ([]*logical.Group, error) {
+		return v.SystemView.GroupsForEntity(entityID)
+	})
+}
+
+// lookupIdentity runs an identity store call with retries and the circuit
+// breaker. Failures wrap errIdentityStoreUnavailable.
+func lookupIdentity[T any](ctx context.Context, b *Backend, call func() (T, error)) (T, error) {
+	var zero T
+	if !b.identityBreaker.allow(time.Now()) {
+		return zero, fmt.Errorf("%w: %w", errIdentityStoreUnavailable, errIdentityCircuitOpen)
+	}
+
+	var err error
+	delay := identityRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		var value T
+		value, err = identityAttempt(ctx, b, call)
+		if err == nil {
+			b.identityBreaker.record(time.Now(), nil)
+			return value, nil
+		}
+
+		// A full queue is not relieved by queueing again
+		if attempt >= identityLookupRetries || errors.Is(err, errIdentityQueueTimeout) || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	// The caller giving up says nothing about the identity store
+	if ctx.Err() != nil {
+		return zero, err
+	}
+
+	if b.identityBreaker.record(time.Now(), err) {
+		b.Logger().Warn("identity store lookups failing, pausing lookups", "cooldown", identityBreakerCooldown, "error", err)
+	}
+	return zero, fmt.Errorf("%w: %w", errIdentityStoreUnavailable, err)
+}
+
+// identityAttempt makes one identity store call once a slot is free. The slot
+// is held until the call returns, even if the caller stops waiting, so slow
+// calls cannot pile up.
+func identityAttempt[T any](ctx context.Context, b *Backend, call func() (T, error)) (T, error) {
+	var zero T
+
+	queueTimer := time.NewTimer(identityQueueTimeout)
+	defer queueTimer.Stop()
+	select {
+	case b.identitySlots <- struct{}{}:
+	case <-queueTimer.C:
+		return zero, errIdentityQueueTimeout
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-b.identitySlots }()
+		value, err := call()
+		done <- result{value, err}
+	}()
+
+	lookupTimer := time.NewTimer(identityLookupTimeout)
+	defer lookupTimer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-lookupTimer.C:
+		return zero, errIdentityLookupTimeout
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
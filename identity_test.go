@@ -0,0 +1,120 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSystemView is a system view whose entity lookups fail
+type failingSystemView struct {
+	*logical.StaticSystemView
+	calls atomic.Int32
+}
+
+// EntityInfo counts the call and fails
+func (v *failingSystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	v.calls.Add(1)
+	return nil, errors.New("identity store overloaded")
+}
+
+// TestLookupIdentity tests retries, timeouts and queueing of identity lookups
+func TestLookupIdentity(t *testing.T) {
+	identityRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { identityRetryBaseDelay = 50 * time.Millisecond })
+	ctx := context.Background()
+
+	t.Run("recovers after transient errors", func(t *testing.T) {
+		b, _ := getTestBackend(t)
+		var calls int
+		value, err := lookupIdentity(ctx, b, func() (string, error) {
+			calls++
+			if calls <= identityLookupRetries {
+				return "", errors.New("transient")
+			}
+			return "entity", nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "entity", value)
+		require.Equal(t, identityLookupRetries+1, calls)
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		b, _ := getTestBackend(t)
+		var calls int
+		_, err := lookupIdentity(ctx, b, func() (string, error) {
+			calls++
+			return "", errors.New("down")
+		})
+		require.ErrorIs(t, err, errIdentityStoreUnavailable)
+		require.Equal(t, identityLookupRetries+1, calls)
+	})
+
+	t.Run("slow lookups time out", func(t *testing.T) {
+		identityLookupTimeout = 20 * time.Millisecond
+		t.Cleanup(func() { identityLookupTimeout = 5 * time.Second })
+
+		b, _ := getTestBackend(t)
+		_, err := lookupIdentity(ctx, b, func() (string, error) {
+			time.Sleep(100 * time.Millisecond)
+			return "entity", nil
+		})
+		require.ErrorIs(t, err, errIdentityStoreUnavailable)
+		require.ErrorIs(t, err, errIdentityLookupTimeout)
+	})
+
+	t.Run("full queue times out", func(t *testing.T) {
+		identityQueueTimeout = 20 * time.Millisecond
+		t.Cleanup(func() { identityQueueTimeout = 2 * time.Second })
+
+		b, _ := getTestBackend(t)
+		for range maxConcurrentIdentityLookups {
+			b.identitySlots <- struct{}{}
+		}
+		var calls int
+		_, err := lookupIdentity(ctx, b, func() (string, error) {
+			calls++
+			return "entity", nil
+		})
+		require.ErrorIs(t, err, errIdentityQueueTimeout)
+		require.Zero(t, calls)
+	})
+}
+
+// TestTokenExchange_IdentityStoreUnavailable tests that identity store
+// failures surface a distinct error code and open the circuit breaker
+func TestTokenExchange_IdentityStoreUnavailable(t *testing.T) {
+	identityRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { identityRetryBaseDelay = 50 * time.Millisecond })
+
+	system := &failingSystemView{StaticSystemView: &logical.StaticSystemView{}}
+	config := &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      system,
+		StorageView: &logical.InmemStorage{},
+	}
+	raw, err := Factory(context.Background(), config)
+	require.NoError(t, err)
+	b, storage := raw.(*Backend), config.StorageView
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	for range identityBreakerThreshold {
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeIdentityUnavailable, true)
+		require.Contains(t, resp.Error().Error(), "identity store overloaded")
+	}
+	require.Equal(t, int32(identityBreakerThreshold*(identityLookupRetries+1)), system.calls.Load())
+
+	// The circuit is open, so the identity store is not called
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeIdentityUnavailable, true)
+	require.Contains(t, resp.Error().Error(), "paused after repeated failures")
+	require.Equal(t, int32(identityBreakerThreshold*(identityLookupRetries+1)), system.calls.Load())
+}
@@ -0,0 +1,96 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenTypeAccessToken is the RFC 8693 token type of OAuth access tokens.
+// Subject tokens of this type are introspected when the config sets
+// introspection_url.
+const tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// defaultIntrospectionTimeout bounds a token introspection request
+const defaultIntrospectionTimeout = 10 * time.Second
+
+// maxIntrospectionResponseSize bounds the introspection response read into
+// memory
+const maxIntrospectionResponseSize = 1 << 20
+
+// introspectionHTTPClient calls the introspection endpoint. It does not follow
+// redirects, which would send the subject token to wherever the endpoint
+// points.
+var introspectionHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// errIntrospectionUnavailable marks subject token validation failures caused
+// by the introspection endpoint rather than by the token itself
+var errIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// introspectSubjectToken validates an opaque subject token at the configured
+// RFC 7662 introspection endpoint. The members of an active token's response
+// become its claims.
+func introspectSubjectToken(ctx context.Context, config *Config, token string) (map[string]any, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	ctx, cancel := context.WithTimeout(ctx, defaultIntrospectionTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.IntrospectionClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(config.IntrospectionClientID), url.QueryEscape(config.IntrospectionClientSecret))
+	}
+
+	resp, err := introspectionHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errIntrospectionUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read introspection response: %w", errIntrospectionUnavailable, err)
+	}
+
+	// Inactive tokens are reported with a 200, so any other status is a
+	// problem with the endpoint or Vault's client credentials
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: introspection request failed with status %d", errIntrospectionUnavailable, resp.StatusCode)
+	}
+
+	claims := map[string]any{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode introspection response: %w", errIntrospectionUnavailable, err)
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, fmt.Errorf("token is not active")
+	}
+	delete(claims, "active")
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("introspection response has no sub")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("introspection response has no exp")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,217 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// issuanceLogStoragePrefix holds the issuance log while issuance_log is
+// enabled. Entries are keyed like issuance records, by zero-padded issue time
+// in nanoseconds followed by the jti, so listing returns them in issue order.
+const issuanceLogStoragePrefix = "issuance_log/"
+
+// defaultIssuanceLogMaxEntries bounds the issuance log when
+// issuance_log_max_entries is unset
+const defaultIssuanceLogMaxEntries = 10000
+
+// issuanceLogEntry describes an issued token for investigations. The subject
+// is only stored as a hash so the log does not collect user identifiers.
+type issuanceLogEntry struct {
+	JTI         string    `json:"jti"`
+	IssuedAt    time.Time `json:"issued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Role        string    `json:"role"`
+	EntityID    string    `json:"entity_id"`
+	SubjectHash string    `json:"subject_hash"`
+	Scopes      []string  `json:"scopes"`
+	Txn         string    `json:"txn,omitempty"`
+}
+
+// issuanceLogFilter selects the entries returned by the issuances endpoint.
+// Empty fields match every entry.
+type issuanceLogFilter struct {
+	role        string
+	entityID    string
+	subjectHash string
+	start       time.Time
+	end         time.Time
+}
+
+// issuanceLogMaxEntries returns issuance_log_max_entries, or the default when
+// unset
+func (c *Config) issuanceLogMaxEntries() int {
+	if c.IssuanceLogMaxEntries <= 0 {
+		return defaultIssuanceLogMaxEntries
+	}
+	return c.IssuanceLogMaxEntries
+}
+
+// hashSubject returns the hex SHA-256 of a subject token's sub claim
+func hashSubject(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// matches reports whether an entry is selected by the filter
+func (f *issuanceLogFilter) matches(entry *issuanceLogEntry) bool {
+	return (f.role == "" || entry.Role == f.role) &&
+		(f.entityID == "" || entry.EntityID == f.entityID) &&
+		(f.subjectHash == "" || entry.SubjectHash == f.subjectHash)
+}
+
+// putIssuanceLogEntry writes an entry to the issuance log
+func putIssuanceLogEntry(ctx context.Context, storage logical.Storage, entry *issuanceLogEntry) error {
+	storageEntry, err := logical.StorageEntryJSON(fmt.Sprintf("%s%020d-%s", issuanceLogStoragePrefix, entry.IssuedAt.UnixNano(), entry.JTI), entry)
+	if err != nil {
+		return fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := storage.Put(ctx, storageEntry); err != nil {
+		return fmt.Errorf("failed to write issuance log entry: %w", err)
+	}
+
+	return nil
+}
+
+// listIssuanceLogKeys returns the storage keys of the issuance log, oldest first
+func listIssuanceLogKeys(ctx context.Context, storage logical.Storage) ([]string, error) {
+	keys, err := storage.List(ctx, issuanceLogStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list issuance log: %w", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// getIssuanceLogEntry returns the entry stored under key, or nil if it no
+// longer exists
+func getIssuanceLogEntry(ctx context.Context, storage logical.Storage, key string) (*issuanceLogEntry, error) {
+	storageEntry, err := storage.Get(ctx, issuanceLogStoragePrefix+key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuance log entry: %w", err)
+	}
+	if storageEntry == nil {
+		return nil, nil
+	}
+
+	entry := &issuanceLogEntry{}
+	if err := storageEntry.DecodeJSON(entry); err != nil {
+		return nil, fmt.Errorf("failed to decode issuance log entry: %w", err)
+	}
+	return entry, nil
+}
+
+// findIssuanceLogEntry returns the entry of the token with the given jti, or
+// nil if it is not in the log
+func findIssuanceLogEntry(ctx context.Context, storage logical.Storage, jti string) (*issuanceLogEntry, error) {
+	keys, err := listIssuanceLogKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "-"+jti) {
+			return getIssuanceLogEntry(ctx, storage, key)
+		}
+	}
+	return nil, nil
+}
+
+// listIssuanceLog returns the entries selected by the filter, oldest first
+func listIssuanceLog(ctx context.Context, storage logical.Storage, filter *issuanceLogFilter) ([]*issuanceLogEntry, error) {
+	keys, err := listIssuanceLogKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*issuanceLogEntry{}
+	for _, key := range keys {
+		issuedAt, ok := issuanceKeyTime(key)
+		if !ok || issuedAt.Before(filter.start) {
+			continue
+		}
+		if !filter.end.IsZero() && !issuedAt.Before(filter.end) {
+			break
+		}
+
+		entry, err := getIssuanceLogEntry(ctx, storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// recordIssuanceLog adds the issued token to the issuance log while it is
+// enabled. Unlike audit records the log is not evidence, so a failed write
+// is logged rather than failing the exchange.
+func (b *Backend) recordIssuanceLog(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.succeeded() || ex.issued == nil || !ex.config.IssuanceLog {
+		return nil, nil
+	}
+
+	subject, _ := ex.subjectClaims["sub"].(string)
+	entry := &issuanceLogEntry{
+		JTI:         ex.issued.JTI,
+		IssuedAt:    ex.issued.IssuedAt,
+		ExpiresAt:   ex.issued.ExpiresAt,
+		Role:        ex.roleName,
+		EntityID:    ex.req.EntityID,
+		SubjectHash: hashSubject(subject),
+		Scopes:      strings.Fields(ex.issued.Scope),
+		Txn:         ex.txn,
+	}
+
+	if err := putIssuanceLogEntry(ctx, ex.req.Storage, entry); err != nil {
+		b.exchangeLogger(ex).Warn("failed to record issuance log entry", "jti", entry.JTI, "error", err)
+	}
+	return nil, nil
+}
+
+// tidyIssuanceLog deletes issuance log entries older than their retention,
+// then the oldest entries beyond issuance_log_max_entries, and returns how
+// many were deleted
+func (b *Backend) tidyIssuanceLog(ctx context.Context, storage logical.Storage) (int, error) {
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil {
+		return 0, nil
+	}
+
+	keys, err := listIssuanceLogKeys(ctx, storage)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-config.recordRetention(recordTypeIssuanceLog))
+	excess := len(keys) - config.issuanceLogMaxEntries()
+
+	deleted := 0
+	for i, key := range keys {
+		issuedAt, ok := issuanceKeyTime(key)
+		if i >= excess && (!ok || !issuedAt.Before(cutoff)) {
+			// Keys are sorted by issue time, so the rest are kept too
+			break
+		}
+
+		if err := storage.Delete(ctx, issuanceLogStoragePrefix+key); err != nil {
+			return deleted, fmt.Errorf("failed to delete issuance log entry: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
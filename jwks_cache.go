@@ -0,0 +1,163 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// jwksCacheStoragePrefix is the storage prefix of fetched subject JWKS
+// documents, shared by every node of the cluster
+const jwksCacheStoragePrefix = "jwks_cache/"
+
+// storedJWKS is a fetched subject JWKS document persisted to storage
+type storedJWKS struct {
+	URI          string              `json:"uri"`
+	KeySet       *jose.JSONWebKeySet `json:"key_set"`
+	ETag         string              `json:"etag,omitempty"`
+	LastModified string              `json:"last_modified,omitempty"`
+	FetchedAt    time.Time           `json:"fetched_at"`
+	ExpiresAt    time.Time           `json:"expires_at"`
+}
+
+// jwksCacheStorageKey returns the storage key of the document fetched from uri
+func jwksCacheStorageKey(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return jwksCacheStoragePrefix + hex.EncodeToString(sum[:])
+}
+
+// getStoredJWKS reads the persisted JWKS document fetched from uri. It
+// returns nil when none is stored.
+func getStoredJWKS(ctx context.Context, storage logical.Storage, uri string) (*storedJWKS, error) {
+	entry, err := storage.Get(ctx, jwksCacheStorageKey(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored jwks: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var stored storedJWKS
+	if err := entry.DecodeJSON(&stored); err != nil {
+		return nil, fmt.Errorf("failed to decode stored jwks: %w", err)
+	}
+	// Guard against a hash collision serving another issuer's keys
+	if stored.URI != uri || stored.KeySet == nil {
+		return nil, nil
+	}
+
+	return &stored, nil
+}
+
+// loadStoredJWKS returns the persisted JWKS document for the config's
+// subject_jwks_uri when it was fetched after fetchedAfter and has not expired,
+// caching it in memory unless the caches were reset since generation was
+// read. A storage failure is logged and treated as a miss so the document is
+// fetched instead.
+func (b *Backend) loadStoredJWKS(ctx context.Context, storage logical.Storage, config *Config, generation uint64, fetchedAfter time.Time) *storedJWKS {
+	stored, err := getStoredJWKS(ctx, storage, config.SubjectJWKSURI)
+	if err != nil {
+		b.Logger().Warn("failed to read stored subject JWKS", "jwks_uri", config.SubjectJWKSURI, "error", err)
+		return nil
+	}
+	if stored == nil || !stored.FetchedAt.After(fetchedAfter) || !time.Now().Before(stored.ExpiresAt) {
+		return nil
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.jwksCache[config.SubjectJWKSURI] = &jwksCacheEntry{
+			keySet:       stored.KeySet,
+			expiresAt:    stored.ExpiresAt,
+			etag:         stored.ETag,
+			lastModified: stored.LastModified,
+		}
+	}
+	b.cacheLock.Unlock()
+
+	return stored
+}
+
+// storeJWKS persists a fetched JWKS document so other nodes, and this node
+// after a restart, reuse it instead of fetching it again. Only nodes that
+// write the mount's storage persist documents, and a failure is logged
+// rather than failing the exchange.
+func (b *Backend) storeJWKS(ctx context.Context, storage logical.Storage, uri string, document *jwksDocument, fetchedAt time.Time) {
+	if storage == nil || !b.writesReplicatedStorage() {
+		return
+	}
+
+	entry, err := logical.StorageEntryJSON(jwksCacheStorageKey(uri), &storedJWKS{
+		URI:          uri,
+		KeySet:       document.keySet,
+		ETag:         document.etag,
+		LastModified: document.lastModified,
+		FetchedAt:    fetchedAt,
+		ExpiresAt:    fetchedAt.Add(subjectJWKSCacheTTL),
+	})
+	if err == nil {
+		err = storage.Put(ctx, entry)
+	}
+	if err != nil {
+		b.Logger().Warn("failed to store subject JWKS", "jwks_uri", uri, "error", err)
+	}
+}
+
+// previousSubjectJWKS returns the last document fetched from uri, expired or
+// not, whose validators make the next fetch conditional. It is nil when the
+// document has not been fetched or carries no validators.
+func (b *Backend) previousSubjectJWKS(ctx context.Context, storage logical.Storage, uri string) *jwksDocument {
+	b.cacheLock.RLock()
+	cached, ok := b.jwksCache[uri]
+	b.cacheLock.RUnlock()
+
+	var previous *jwksDocument
+	if ok {
+		previous = &jwksDocument{keySet: cached.keySet, etag: cached.etag, lastModified: cached.lastModified}
+	} else if storage != nil {
+		stored, err := getStoredJWKS(ctx, storage, uri)
+		if err == nil && stored != nil {
+			previous = &jwksDocument{keySet: stored.KeySet, etag: stored.ETag, lastModified: stored.LastModified}
+		}
+	}
+
+	if previous == nil || (previous.etag == "" && previous.lastModified == "") {
+		return nil
+	}
+	return previous
+}
+
+// deleteStoredJWKS removes every persisted JWKS document, so a changed
+// config is never served keys fetched under the previous one
+func deleteStoredJWKS(ctx context.Context, storage logical.Storage) error {
+	keys, err := storage.List(ctx, jwksCacheStoragePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list stored jwks: %w", err)
+	}
+
+	for _, key := range keys {
+		if err := storage.Delete(ctx, jwksCacheStoragePrefix+key); err != nil {
+			return fmt.Errorf("failed to delete stored jwks: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resetSubjectJWKSCache drops the in-memory document whose persisted copy
+// changed, so the next exchange loads the copy another node fetched
+func (b *Backend) resetSubjectJWKSCache(storageKey string) {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	for uri := range b.jwksCache {
+		if jwksCacheStorageKey(uri) == storageKey {
+			delete(b.jwksCache, uri)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tokenexchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSubjectJWKS_StorageCache tests that fetched subject JWKS documents are
+// persisted and reused by other backends sharing the storage
+func TestSubjectJWKS_StorageCache(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	var fetches atomic.Int32
+	handler := jwksHandler(t, &privateKey.PublicKey, kid)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 1, fetches.Load())
+
+	stored, err := getStoredJWKS(ctx, storage, server.URL)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	require.Len(t, stored.KeySet.Key(kid), 1)
+	require.WithinDuration(t, stored.FetchedAt.Add(subjectJWKSCacheTTL), stored.ExpiresAt, time.Second)
+
+	// A restarted node, or another node of the cluster, reuses the stored copy
+	restarted, err := Factory(ctx, &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      &logical.StaticSystemView{EntityVal: &logical.Entity{ID: "test-entity"}},
+		StorageView: storage,
+	})
+	require.NoError(t, err)
+	other := restarted.(*Backend)
+
+	resp = exchangeTestToken(t, other, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 1, fetches.Load())
+
+	// An expired copy is fetched again
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	entry, err := logical.StorageEntryJSON(jwksCacheStorageKey(server.URL), stored)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+	other.invalidate(ctx, jwksCacheStorageKey(server.URL))
+
+	resp = exchangeTestToken(t, other, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 2, fetches.Load())
+
+	// Writing the config drops stored copies
+	configureSubjectJWKS(t, b, storage, server.URL)
+	keys, err := storage.List(ctx, jwksCacheStoragePrefix)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 3, fetches.Load())
+}
+
+// TestSubjectJWKS_StoredValidators tests that an expired stored document makes
+// the refetch conditional and is renewed by a 304 response
+func TestSubjectJWKS_StoredValidators(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	var full, notModified atomic.Int32
+	handler := jwksHandler(t, &privateKey.PublicKey, kid)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"keys-1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"keys-1"`)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	stored, err := getStoredJWKS(ctx, storage, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, `"keys-1"`, stored.ETag)
+
+	// Expire the stored copy and drop it from memory, as after a restart
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	entry, err := logical.StorageEntryJSON(jwksCacheStorageKey(server.URL), stored)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+	b.invalidate(ctx, jwksCacheStorageKey(server.URL))
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 1, full.Load())
+	require.EqualValues(t, 1, notModified.Load())
+
+	renewed, err := getStoredJWKS(ctx, storage, server.URL)
+	require.NoError(t, err)
+	require.True(t, renewed.ExpiresAt.After(time.Now()))
+	require.Equal(t, `"keys-1"`, renewed.ETag)
+}
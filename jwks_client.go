@@ -0,0 +1,250 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// defaultJWKSFetchTimeout bounds a subject JWKS fetch attempt when jwks_fetch_timeout is unset
+const defaultJWKSFetchTimeout = 10 * time.Second
+
+// maxJWKSRetries is the largest accepted jwks_max_retries
+const maxJWKSRetries = 10
+
+// maxJWKSSize bounds a subject JWKS read into memory. Issuers publish a
+// handful of keys, far below it.
+const maxJWKSSize = 1 << 20
+
+// Retried JWKS fetches back off exponentially from jwksRetryBaseDelay, capped
+// at jwksRetryMaxDelay
+var (
+	jwksRetryBaseDelay = 200 * time.Millisecond
+	jwksRetryMaxDelay  = 2 * time.Second
+)
+
+// After jwksBreakerThreshold consecutive failed fetches, subject JWKS fetches
+// are skipped for jwksBreakerCooldown so a failing issuer is not hammered
+const (
+	jwksBreakerThreshold = 5
+	jwksBreakerCooldown  = 30 * time.Second
+)
+
+// errJWKSFetchRetryable marks fetch failures worth retrying
+var errJWKSFetchRetryable = errors.New("retryable jwks fetch failure")
+
+// jwksDocument is a fetched JWKS with the ETag and Last-Modified validators
+// the issuer returned, sent back when it is next fetched
+type jwksDocument struct {
+	keySet       *jose.JSONWebKeySet
+	etag         string
+	lastModified string
+}
+
+// jwksHTTPClient builds the HTTP client used to fetch the subject JWKS from
+// the config's CA, client certificate, proxy and timeout settings
+func (c *Config) jwksHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Only intended for development against issuers with self-signed certificates
+		InsecureSkipVerify: c.JWKSTLSSkipVerify,
+	}
+
+	if c.JWKSCAPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.JWKSCAPEM)) {
+			return nil, fmt.Errorf("jwks_ca_pem contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.JWKSClientCert != "" || c.JWKSClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(c.JWKSClientCert), []byte(c.JWKSClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks_client_cert or jwks_client_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if c.JWKSProxyURL != "" {
+		proxyURL, err := url.Parse(c.JWKSProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("jwks_proxy_url must be a URL such as http://proxy.example.com:3128")
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("jwks_proxy_url scheme must be http, https or socks5")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   c.jwksFetchTimeout(),
+	}, nil
+}
+
+// jwksFetchTimeout returns the JWKS fetch timeout, defaulting for configs written
+// before jwks_fetch_timeout existed
+func (c *Config) jwksFetchTimeout() time.Duration {
+	if c.JWKSFetchTimeout == 0 {
+		return defaultJWKSFetchTimeout
+	}
+	return c.JWKSFetchTimeout
+}
+
+// getJWKSClient returns the subject JWKS HTTP client for the config, built
+// once per config version
+func (b *Backend) getJWKSClient(config *Config) (*http.Client, error) {
+	b.cacheLock.RLock()
+	client := b.jwksClient
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := config.jwksHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.jwksClient = client
+	}
+	b.cacheLock.Unlock()
+
+	return client, nil
+}
+
+// jwksClientKeyFingerprint returns the hex SHA-256 fingerprint of the public
+// half of jwks_client_key, identifying the key without exposing it. It is
+// empty when no valid key is configured.
+func (c *Config) jwksClientKeyFingerprint() string {
+	if c.JWKSClientKey == "" {
+		return ""
+	}
+
+	cert, err := tls.X509KeyPair([]byte(c.JWKSClientCert), []byte(c.JWKSClientKey))
+	if err != nil {
+		return ""
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return ""
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// fetchJWKS retrieves a JWKS document over HTTP, retrying network errors,
+// HTTP 429 and 5xx responses up to maxRetries times with exponential backoff.
+// When previous is set the request is conditional on its validators, and a 304
+// response returns its keys without downloading them again.
+func fetchJWKS(ctx context.Context, client *http.Client, url string, maxRetries int, previous *jwksDocument) (*jwksDocument, error) {
+	delay := jwksRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		document, err := fetchJWKSOnce(ctx, client, url, previous)
+		if err == nil || !errors.Is(err, errJWKSFetchRetryable) || attempt >= maxRetries {
+			return document, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (retry abandoned: %w)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, jwksRetryMaxDelay)
+	}
+}
+
+// fetchJWKSOnce makes a single JWKS request
+func fetchJWKSOnce(ctx context.Context, client *http.Client, url string, previous *jwksDocument) (*jwksDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Cancellation and certificate failures will not succeed on retry
+		var certErr *tls.CertificateVerificationError
+		if ctx.Err() != nil || errors.As(err, &certErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", errJWKSFetchRetryable, err)
+	}
+	defer resp.Body.Close()
+
+	// Unchanged since the previous fetch. A validator the issuer omits keeps
+	// its previous value.
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		document := *previous
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			document.etag = etag
+		}
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			document.lastModified = lastModified
+		}
+		return &document, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed to fetch jwks: %s, status %d", url, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: %w", errJWKSFetchRetryable, err)
+		}
+		return nil, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read response from jwks, %w", errJWKSFetchRetryable, err)
+	}
+	if len(body) > maxJWKSSize {
+		return nil, fmt.Errorf("jwks at %s exceeds %d bytes", url, maxJWKSSize)
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, err
+	}
+
+	return &jwksDocument{
+		keySet:       &jwks,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -0,0 +1,370 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// jwksHandler serves a JWKS containing the public key of privateKey
+func jwksHandler(t *testing.T, publicKey any, kid string) http.HandlerFunc {
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: publicKey, KeyID: kid, Use: "sig"}}}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(jwks))
+	}
+}
+
+// generateClientCertificate creates a self-signed client certificate and key as PEM
+func generateClientCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "vault-jwks-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+// certificatePEM PEM-encodes a certificate
+func certificatePEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// writeJWKSConfig writes the config with the given JWKS fetch settings
+func writeJWKSConfig(t *testing.T, b *Backend, storage logical.Storage, data map[string]any) *logical.Response {
+	data["issuer"] = "https://vault.example.com"
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// TestTokenExchange_JWKSClientSettings tests fetching the subject JWKS with
+// custom CA, mTLS, proxy and timeout settings
+func TestTokenExchange_JWKSClientSettings(t *testing.T) {
+	privateKey, _ := generateTestKeyPair(t)
+	kid := "test-key-1"
+
+	clientCert, clientKey := generateClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM([]byte(clientCert)))
+
+	tlsServer := httptest.NewTLSServer(jwksHandler(t, &privateKey.PublicKey, kid))
+	t.Cleanup(tlsServer.Close)
+
+	mtlsServer := httptest.NewUnstartedServer(jwksHandler(t, &privateKey.PublicKey, kid))
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtlsServer.StartTLS()
+	t.Cleanup(mtlsServer.Close)
+
+	// The proxy answers the forwarded request itself and records the target
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		jwksHandler(t, &privateKey.PublicKey, kid)(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		jwksHandler(t, &privateKey.PublicKey, kid)(w, r)
+	}))
+	t.Cleanup(slowServer.Close)
+
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr bool
+	}{
+		{
+			name:    "untrusted ca",
+			config:  map[string]any{"subject_jwks_uri": tlsServer.URL},
+			wantErr: true,
+		},
+		{
+			name:   "custom ca",
+			config: map[string]any{"subject_jwks_uri": tlsServer.URL, "jwks_ca_pem": certificatePEM(tlsServer.Certificate())},
+		},
+		{
+			name:   "skip verify",
+			config: map[string]any{"subject_jwks_uri": tlsServer.URL, "jwks_tls_skip_verify": true},
+		},
+		{
+			name:    "mtls without client certificate",
+			config:  map[string]any{"subject_jwks_uri": mtlsServer.URL, "jwks_ca_pem": certificatePEM(mtlsServer.Certificate())},
+			wantErr: true,
+		},
+		{
+			name: "mtls",
+			config: map[string]any{
+				"subject_jwks_uri": mtlsServer.URL,
+				"jwks_ca_pem":      certificatePEM(mtlsServer.Certificate()),
+				"jwks_client_cert": clientCert,
+				"jwks_client_key":  clientKey,
+			},
+		},
+		{
+			name:   "proxy",
+			config: map[string]any{"subject_jwks_uri": "http://idp.internal.example/jwks", "jwks_proxy_url": proxy.URL},
+		},
+		{
+			name:    "timeout",
+			config:  map[string]any{"subject_jwks_uri": slowServer.URL, "jwks_fetch_timeout": "1s", "jwks_max_retries": 0},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			setupTestExchange(t, b, storage, nil)
+
+			resp := writeJWKSConfig(t, b, storage, tt.config)
+			require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+			resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			if tt.wantErr {
+				requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+				return
+			}
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			if tt.config["jwks_proxy_url"] != nil {
+				require.Equal(t, "http://idp.internal.example/jwks", <-proxied)
+			}
+		})
+	}
+}
+
+// TestPathConfig_JWKSClientValidation tests validation of the JWKS fetch settings
+func TestPathConfig_JWKSClientValidation(t *testing.T) {
+	clientCert, clientKey := generateClientCertificate(t)
+
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr string
+	}{
+		{name: "invalid ca", config: map[string]any{"jwks_ca_pem": "not a certificate"}, wantErr: "jwks_ca_pem contains no valid certificates"},
+		{name: "certificate without key", config: map[string]any{"jwks_client_cert": clientCert}, wantErr: "invalid jwks_client_cert or jwks_client_key"},
+		{name: "invalid proxy scheme", config: map[string]any{"jwks_proxy_url": "ftp://proxy.example.com"}, wantErr: "jwks_proxy_url scheme must be http, https or socks5"},
+		{name: "invalid timeout", config: map[string]any{"jwks_fetch_timeout": "0s"}, wantErr: "jwks_fetch_timeout must be positive"},
+		{name: "negative retries", config: map[string]any{"jwks_max_retries": -1}, wantErr: "jwks_max_retries must be between 0 and 10"},
+		{name: "too many retries", config: map[string]any{"jwks_max_retries": 11}, wantErr: "jwks_max_retries must be between 0 and 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			resp := writeJWKSConfig(t, b, storage, tt.config)
+			require.NotNil(t, resp)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.wantErr)
+		})
+	}
+
+	// The client key is never returned
+	b, storage := getTestBackend(t)
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"jwks_client_cert": clientCert, "jwks_client_key": clientKey})
+	require.Nil(t, resp)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, clientCert, resp.Data["jwks_client_cert"])
+	require.NotContains(t, resp.Data, "jwks_client_key")
+	require.Equal(t, true, resp.Data["jwks_client_key_configured"])
+
+	// The fingerprint identifies the key by its public half
+	block, _ := pem.Decode([]byte(clientCert))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	fingerprint := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	require.Equal(t, hex.EncodeToString(fingerprint[:]), resp.Data["jwks_client_key_fingerprint"])
+	require.EqualValues(t, 10, resp.Data["jwks_fetch_timeout"])
+	require.Equal(t, 2, resp.Data["jwks_max_retries"])
+}
+
+// TestFetchJWKS_Retries tests which JWKS fetch failures are retried
+func TestFetchJWKS_Retries(t *testing.T) {
+	jwksRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { jwksRetryBaseDelay = 200 * time.Millisecond })
+
+	privateKey, _ := generateTestKeyPair(t)
+
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		maxRetries   int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{name: "recovers after server errors", failures: 2, status: http.StatusServiceUnavailable, maxRetries: 2, wantAttempts: 3},
+		{name: "recovers after rate limiting", failures: 1, status: http.StatusTooManyRequests, maxRetries: 2, wantAttempts: 2},
+		{name: "gives up after max retries", failures: 5, status: http.StatusInternalServerError, maxRetries: 2, wantAttempts: 3, wantErr: true},
+		{name: "retries disabled", failures: 1, status: http.StatusBadGateway, maxRetries: 0, wantAttempts: 1, wantErr: true},
+		{name: "client errors are not retried", failures: 5, status: http.StatusNotFound, maxRetries: 2, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(attempts.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				jwksHandler(t, &privateKey.PublicKey, "test-key-1")(w, r)
+			}))
+			t.Cleanup(server.Close)
+
+			document, err := fetchJWKS(context.Background(), server.Client(), server.URL, tt.maxRetries, nil)
+			require.Equal(t, tt.wantAttempts, attempts.Load())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, document.keySet.Key("test-key-1"), 1)
+		})
+	}
+}
+
+// TestFetchJWKS_SizeLimit tests that an oversized JWKS is rejected rather
+// than read into memory
+func TestFetchJWKS_SizeLimit(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"keys": [], "padding": "` + strings.Repeat("a", maxJWKSSize) + `"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := fetchJWKS(context.Background(), server.Client(), server.URL, 2, nil)
+	require.ErrorContains(t, err, "exceeds")
+	require.Equal(t, int32(1), attempts.Load())
+}
+
+// TestTokenExchange_JWKSCircuitBreaker tests that repeated JWKS fetch failures
+// pause fetching until the cooldown passes or the config changes
+func TestTokenExchange_JWKSCircuitBreaker(t *testing.T) {
+	privateKey, _ := generateTestKeyPair(t)
+	kid := "test-key-1"
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": server.URL, "jwks_max_retries": 0})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	for range jwksBreakerThreshold {
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	}
+	require.Equal(t, int32(jwksBreakerThreshold), attempts.Load())
+
+	// The circuit is open, so the issuer is not contacted
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	require.Contains(t, resp.Error().Error(), "paused after repeated failures")
+	require.Equal(t, int32(jwksBreakerThreshold), attempts.Load())
+
+	// Writing the config closes the circuit
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": server.URL, "jwks_max_retries": 0})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	require.Equal(t, int32(jwksBreakerThreshold+1), attempts.Load())
+}
+
+// TestFetchJWKS_Conditional tests that refetches send the previous validators
+// and reuse the previous keys when the issuer responds 304
+func TestFetchJWKS_Conditional(t *testing.T) {
+	privateKey, _ := generateTestKeyPair(t)
+	etag := `"v1"`
+	lastModified := "Mon, 05 Oct 2026 10:00:00 GMT"
+
+	var full, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			require.Equal(t, lastModified, r.Header.Get("If-Modified-Since"))
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		jwksHandler(t, &privateKey.PublicKey, "test-key-1")(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	document, err := fetchJWKS(context.Background(), server.Client(), server.URL, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, etag, document.etag)
+	require.Equal(t, lastModified, document.lastModified)
+
+	refetched, err := fetchJWKS(context.Background(), server.Client(), server.URL, 0, document)
+	require.NoError(t, err)
+	require.Same(t, document.keySet, refetched.keySet)
+	require.Equal(t, etag, refetched.etag)
+	require.EqualValues(t, 1, full.Load())
+	require.EqualValues(t, 1, notModified.Load())
+
+	// A changed document is downloaded in full
+	etag = `"v2"`
+	refetched, err = fetchJWKS(context.Background(), server.Client(), server.URL, 0, document)
+	require.NoError(t, err)
+	require.NotSame(t, document.keySet, refetched.keySet)
+	require.Equal(t, `"v2"`, refetched.etag)
+	require.EqualValues(t, 2, full.Load())
+}
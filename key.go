@@ -1,25 +1,96 @@
 package tokenexchange
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/go-jose/go-jose/v4"
 )
 
 // Key represents a named signing key
 type Key struct {
 	Name       string    `json:"name"`        // Key name (e.g., "prod-key")
 	KeyID      string    `json:"key_id"`      // Unique identifier (kid)
-	Algorithm  string    `json:"algorithm"`   // RS256, RS384, or RS512
+	Algorithm  string    `json:"algorithm"`   // RS256, RS384, RS512, PS256, PS384, or PS512
 	PrivateKey string    `json:"private_key"` // PEM-encoded RSA private key
 	CreatedAt  time.Time `json:"created_at"`  // Creation timestamp
 	RotatedAt  time.Time `json:"rotated_at"`  // Last rotation timestamp
 	Version    int       `json:"version"`     // Key version (increments on rotation)
+
+	// VerificationTTL is how long a rotated version stays in the JWKS. Zero
+	// uses the config's default_verification_ttl.
+	VerificationTTL time.Duration `json:"verification_ttl"`
+
+	// RotationPeriod is how often the key is automatically rotated (0 = manual only)
+	RotationPeriod time.Duration `json:"rotation_period,omitempty"`
+
+	// PreviousVersions holds rotated versions that can still verify tokens.
+	// Only the public key is retained; signing always uses the latest version.
+	PreviousVersions []*KeyVersion `json:"previous_versions,omitempty"`
+
+	// KeyType is where the private key lives: internal keys are stored in
+	// PrivateKey, transit keys in a transit engine and managed keys in a
+	// managed key's KMS. Empty means internal.
+	KeyType string `json:"key_type,omitempty"`
+
+	// TransitMount and TransitKey name the transit key backing a transit key
+	TransitMount string `json:"transit_mount,omitempty"`
+	TransitKey   string `json:"transit_key,omitempty"`
+
+	// ManagedKeyName names the managed key backing a managed key
+	ManagedKeyName string `json:"managed_key_name,omitempty"`
+
+	// PublicKey is the PEM-encoded RSA public key of the latest version of a
+	// transit or managed key, which has no PrivateKey
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Certificate is the PEM-encoded X.509 certificate of the latest version,
+	// published as x5c. CertificateGenerated marks self-signed certificates
+	// generated by the plugin, which are regenerated on rotation.
+	Certificate          string `json:"certificate,omitempty"`
+	CertificateGenerated bool   `json:"certificate_generated,omitempty"`
+
+	// Tenant is the tenant whose roles sign with the key. Empty is shared.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Disabled keys sign nothing. Their latest version stays in the JWKS
+	// until DisabledExpiresAt, like a rotated version.
+	Disabled          bool      `json:"disabled,omitempty"`
+	DisabledExpiresAt time.Time `json:"disabled_expires_at,omitempty"`
+
+	// CASVersion is incremented on every write, for check-and-set writes. It
+	// is unrelated to Version, the signing key version.
+	CASVersion int `json:"cas_version,omitempty"`
 }
 
+// KeyVersion represents a rotated, verification-only version of a key
+type KeyVersion struct {
+	Version   int       `json:"version"`    // Version number
+	KeyID     string    `json:"key_id"`     // kid of this version
+	PublicKey string    `json:"public_key"` // PEM-encoded RSA public key
+	CreatedAt time.Time `json:"created_at"` // When this version was created
+	ExpiresAt time.Time `json:"expires_at"` // When this version leaves the JWKS
+
+	// Certificate is the PEM-encoded X.509 certificate of this version, if any
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// errKeyDisabled is returned when signing or rotating with a disabled key
+var errKeyDisabled = errors.New("key is disabled")
+
+// Statuses of a key version
+const (
+	keyVersionStatusActive           = "active"
+	keyVersionStatusVerificationOnly = "verification-only"
+	keyVersionStatusExpired          = "expired"
+)
+
 const (
 	keyStoragePrefix = "keys/"
 
@@ -27,9 +98,15 @@ const (
 	AlgorithmRS256 = "RS256"
 	AlgorithmRS384 = "RS384"
 	AlgorithmRS512 = "RS512"
+	AlgorithmPS256 = "PS256"
+	AlgorithmPS384 = "PS384"
+	AlgorithmPS512 = "PS512"
 
 	// Default RSA key size
 	DefaultKeySize = 2048
+
+	// Default time a rotated key version remains available for verification
+	DefaultVerificationTTL = 24 * time.Hour
 )
 
 // generateKeyID creates a unique key ID
@@ -37,6 +114,26 @@ func generateKeyID(name string, version int) string {
 	return fmt.Sprintf("%s-v%d", name, version)
 }
 
+// signatureAlgorithm maps a key algorithm name to its jose constant
+func signatureAlgorithm(algorithm string) (jose.SignatureAlgorithm, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return jose.RS256, nil
+	case AlgorithmRS384:
+		return jose.RS384, nil
+	case AlgorithmRS512:
+		return jose.RS512, nil
+	case AlgorithmPS256:
+		return jose.PS256, nil
+	case AlgorithmPS384:
+		return jose.PS384, nil
+	case AlgorithmPS512:
+		return jose.PS512, nil
+	default:
+		return "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
+
 // generateRSAKey generates a new RSA private key
 func generateRSAKey(bits int) (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, bits)
@@ -60,3 +157,210 @@ func publicKeyFromPrivate(privateKeyPEM string) (*rsa.PublicKey, error) {
 	}
 	return &privateKey.PublicKey, nil
 }
+
+// isTransit reports whether the key signs through a transit engine
+func (k *Key) isTransit() bool {
+	return k.KeyType == KeyTypeTransit
+}
+
+// keyTypeOrDefault returns the key_type of a stored key, which is internal
+// for keys created before key types existed
+func keyTypeOrDefault(keyType string) string {
+	if keyType == "" {
+		return KeyTypeInternal
+	}
+	return keyType
+}
+
+// isManaged reports whether the key signs with a managed key
+func (k *Key) isManaged() bool {
+	return k.KeyType == KeyTypeManaged
+}
+
+// publicKey returns the public key of the latest version
+func (k *Key) publicKey() (*rsa.PublicKey, error) {
+	if k.isTransit() || k.isManaged() {
+		return parsePublicKeyPEM(k.PublicKey)
+	}
+	return publicKeyFromPrivate(k.PrivateKey)
+}
+
+// keySigner returns the signing key passed to jose.NewSigner for the latest
+// version of key: the parsed private key, or a jose.OpaqueSigner that signs
+// with transit or a managed key. ctx bounds the calls made while signing.
+func (b *Backend) keySigner(ctx context.Context, config *Config, key *Key) (any, error) {
+	if key.Disabled {
+		return nil, errKeyDisabled
+	}
+	if !key.isTransit() && !key.isManaged() {
+		signingKey, err := parsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key: %w", err)
+		}
+		return signingKey, nil
+	}
+
+	algorithm, err := signatureAlgorithm(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := parsePublicKeyPEM(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	if key.isManaged() {
+		view, err := b.managedKeySystemView()
+		if err != nil {
+			return nil, err
+		}
+		return &managedKeySigner{ctx: ctx, view: view, backendUUID: b.backendUUID, key: key, algorithm: algorithm, publicKey: publicKey}, nil
+	}
+
+	client, err := b.newTransitClient(config, key)
+	if err != nil {
+		return nil, err
+	}
+	return &transitSigner{ctx: ctx, client: client, key: key, algorithm: algorithm, publicKey: publicKey}, nil
+}
+
+// encodePublicKeyPEM encodes RSA public key to PEM format
+func encodePublicKeyPEM(key *rsa.PublicKey) string {
+	block := &pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(key),
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+// parsePublicKeyPEM parses a PEM-encoded RSA public key
+func parsePublicKeyPEM(publicKeyPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// defaultVerificationTTL returns default_verification_ttl, or the default
+// when unset
+func (c *Config) defaultVerificationTTL() time.Duration {
+	if c == nil || c.DefaultVerificationTTL <= 0 {
+		return DefaultVerificationTTL
+	}
+	return c.DefaultVerificationTTL
+}
+
+// maxVerificationTTL returns max_verification_ttl, or zero when verification
+// TTLs are unbounded
+func (c *Config) maxVerificationTTL() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.MaxVerificationTTL
+}
+
+// verificationTTL returns how long a version rotated now stays in the JWKS:
+// the key's verification_ttl, or the config's default_verification_ttl when
+// unset, capped at the config's max_verification_ttl
+func (k *Key) verificationTTL(config *Config) time.Duration {
+	ttl := k.VerificationTTL
+	if ttl == 0 {
+		ttl = config.defaultVerificationTTL()
+	}
+	if maxTTL := config.maxVerificationTTL(); maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+// rotate generates a new latest version of the key. The previous version is
+// retained for verification until now + verificationTTL, and versions whose
+// verification window has passed are pruned.
+func (k *Key) rotate(now time.Time, verificationTTL time.Duration) error {
+	current, err := parsePrivateKey(k.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse current key: %w", err)
+	}
+
+	privateKey, err := generateRSAKey(current.N.BitLen())
+	if err != nil {
+		return fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	previous := &KeyVersion{
+		Version:     k.Version,
+		KeyID:       k.KeyID,
+		PublicKey:   encodePublicKeyPEM(&current.PublicKey),
+		CreatedAt:   k.RotatedAt,
+		ExpiresAt:   now.Add(verificationTTL),
+		Certificate: k.Certificate,
+	}
+
+	k.PreviousVersions = append(k.verificationVersions(now), previous)
+	k.Version++
+	k.KeyID = generateKeyID(k.Name, k.Version)
+	k.PrivateKey = encodePrivateKeyPEM(privateKey)
+	k.RotatedAt = now
+
+	return nil
+}
+
+// nextRotation returns when the key is next due for automatic rotation, or
+// zero if it is only rotated manually or is disabled
+func (k *Key) nextRotation() time.Time {
+	if k.RotationPeriod <= 0 || k.Disabled {
+		return time.Time{}
+	}
+	return k.RotatedAt.Add(k.RotationPeriod)
+}
+
+// versions returns every retained version of the key, oldest first, with the
+// latest version last. Rotated versions whose verification window has passed
+// are kept until tidy deletes them.
+func (k *Key) versions() ([]*KeyVersion, error) {
+	publicKey, err := k.publicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract public key: %w", err)
+	}
+
+	versions := append([]*KeyVersion{}, k.PreviousVersions...)
+	return append(versions, &KeyVersion{
+		Version:     k.Version,
+		KeyID:       k.KeyID,
+		PublicKey:   encodePublicKeyPEM(publicKey),
+		CreatedAt:   k.RotatedAt,
+		ExpiresAt:   k.DisabledExpiresAt,
+		Certificate: k.Certificate,
+	}), nil
+}
+
+// publishesLatestVersion reports whether the latest version is in the JWKS:
+// always while the key is enabled, and until DisabledExpiresAt once disabled
+func (k *Key) publishesLatestVersion(now time.Time) bool {
+	return !k.Disabled || now.Before(k.DisabledExpiresAt)
+}
+
+// status returns whether a version of k signs tokens, only verifies them or
+// has left the JWKS
+func (v *KeyVersion) status(k *Key, now time.Time) string {
+	switch {
+	case v.Version == k.Version && !k.Disabled:
+		return keyVersionStatusActive
+	case now.Before(v.ExpiresAt):
+		return keyVersionStatusVerificationOnly
+	default:
+		return keyVersionStatusExpired
+	}
+}
+
+// verificationVersions returns the rotated versions still valid for verification
+func (k *Key) verificationVersions(now time.Time) []*KeyVersion {
+	var versions []*KeyVersion
+	for _, v := range k.PreviousVersions {
+		if now.Before(v.ExpiresAt) {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
@@ -3,7 +3,10 @@ package tokenexchange
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
 )
@@ -46,7 +49,7 @@ func TestPathKeyWrite_InvalidAlgorithm(t *testing.T) {
 	resp, err := b.HandleRequest(context.Background(), req)
 	require.NoError(t, err)
 	require.True(t, resp.IsError())
-	require.Contains(t, resp.Error().Error(), "must be RS256, RS384, or RS512")
+	require.Contains(t, resp.Error().Error(), "must be RS256, RS384, RS512, PS256, PS384, or PS512")
 }
 
 func TestPathKeyRead(t *testing.T) {
@@ -169,9 +172,307 @@ func TestPathKeyWrite_DuplicateName(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, resp)
 
-	// Second creation should fail
+	// A second creation with other parameters should fail
+	req.Data["algorithm"] = "PS256"
 	resp, err = b.HandleRequest(context.Background(), req)
 	require.NoError(t, err)
 	require.True(t, resp.IsError())
 	require.Contains(t, resp.Error().Error(), "already exists")
 }
+
+func TestPathKeyRotate(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	// Token signed before rotation
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError())
+	oldToken := resp.Data["token"].(string)
+
+	rotateReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/test-key/rotate",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), rotateReq)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, "test-key-v2", resp.Data["key_id"])
+	require.Equal(t, 2, resp.Data["version"])
+
+	// New tokens are signed with the latest version
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError())
+	newToken := resp.Data["token"].(string)
+	parsed, err := jwt.ParseSigned(newToken, []jose.SignatureAlgorithm{jose.RS256})
+	require.NoError(t, err)
+	require.Equal(t, "test-key-v2", parsed.Headers[0].KeyID)
+	parseIssuedToken(t, b, storage, newToken)
+
+	// Tokens signed by the previous version still verify via the JWKS
+	parseIssuedToken(t, b, storage, oldToken)
+
+	jwksReq := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "jwks",
+		Storage:   storage,
+		Data:      map[string]any{"kid": "test-key-v1"},
+	}
+	resp, err = b.HandleRequest(context.Background(), jwksReq)
+	require.NoError(t, err)
+	jwks := extractJWKSFromResponse(t, resp)
+	require.Len(t, jwks["keys"], 1, "kid lookup should resolve the historical version")
+
+	// Key read lists the verification-only versions
+	readReq := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "key/test-key",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), readReq)
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-key-v1"}, resp.Data["verification_key_ids"])
+}
+
+func TestKeyRotate_PrunesExpiredVersions(t *testing.T) {
+	privateKey, err := generateRSAKey(2048)
+	require.NoError(t, err)
+
+	now := time.Now()
+	key := &Key{
+		Name:            "prune",
+		KeyID:           generateKeyID("prune", 1),
+		Algorithm:       AlgorithmRS256,
+		PrivateKey:      encodePrivateKeyPEM(privateKey),
+		RotatedAt:       now,
+		Version:         1,
+		VerificationTTL: time.Hour,
+	}
+
+	require.NoError(t, key.rotate(now, key.VerificationTTL))
+	require.NoError(t, key.rotate(now.Add(2*time.Hour), key.VerificationTTL))
+
+	require.Equal(t, 3, key.Version)
+	require.Len(t, key.PreviousVersions, 1, "v1 should be pruned once its verification window has passed")
+	require.Equal(t, "prune-v2", key.PreviousVersions[0].KeyID)
+}
+
+func TestPathKeyDelete_ReferencedByRole(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	setupTestExchange(t, b, storage, nil)
+
+	deleteReq := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "key/test-key",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), deleteReq)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError(), "Deleting a referenced key should fail")
+	require.Contains(t, resp.Error().Error(), "test-role", "Error should list dependent roles")
+
+	key, err := b.getKey(context.Background(), storage, "test-key")
+	require.NoError(t, err)
+	require.NotNil(t, key, "Key should not have been deleted")
+
+	// Force override
+	deleteReq.Data = map[string]any{"force": true}
+	resp, err = b.HandleRequest(context.Background(), deleteReq)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	key, err = b.getKey(context.Background(), storage, "test-key")
+	require.NoError(t, err)
+	require.Nil(t, key)
+}
+
+func TestRotateDueKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	createTestKey(t, b, storage, "manual-key")
+	keyReq := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/auto-key",
+		Storage:   storage,
+		Data: map[string]any{
+			"rotation_period": "1h",
+		},
+	}
+	_, err := b.HandleRequest(ctx, keyReq)
+	require.NoError(t, err)
+
+	// Nothing is due yet
+	require.NoError(t, b.rotateDueKeys(ctx, &logical.Request{Storage: storage}))
+	key, err := b.getKey(ctx, storage, "auto-key")
+	require.NoError(t, err)
+	require.Equal(t, 1, key.Version)
+
+	// Make the rotation overdue
+	key.RotatedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, b.putKey(ctx, storage, key))
+
+	require.NoError(t, b.rotateDueKeys(ctx, &logical.Request{Storage: storage}))
+
+	key, err = b.getKey(ctx, storage, "auto-key")
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version)
+	require.Equal(t, "auto-key-v2", key.KeyID)
+
+	manual, err := b.getKey(ctx, storage, "manual-key")
+	require.NoError(t, err)
+	require.Equal(t, 1, manual.Version, "Keys without rotation_period are never rotated automatically")
+}
+
+func TestKeyVerificationTTL_ConfigDefaults(t *testing.T) {
+	b, storage := getTestBackend(t)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{
+		"default_verification_ttl": "2h",
+		"max_verification_ttl":     "6h",
+	}))
+
+	createTestKey(t, b, storage, "inherits")
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/override",
+		Storage:   storage,
+		Data:      map[string]any{"verification_ttl": "4h"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/too-long",
+		Storage:   storage,
+		Data:      map[string]any{"verification_ttl": "12h"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "max_verification_ttl")
+
+	// Rotated versions stay published for the key's effective verification TTL
+	for name, want := range map[string]time.Duration{"inherits": 2 * time.Hour, "override": 4 * time.Hour} {
+		before := time.Now()
+		_, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/" + name + "/rotate", Storage: storage})
+		require.NoError(t, err)
+
+		key, err := b.getKey(context.Background(), storage, name)
+		require.NoError(t, err)
+		require.Len(t, key.PreviousVersions, 1)
+		require.WithinDuration(t, before.Add(want), key.PreviousVersions[0].ExpiresAt, time.Minute)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/" + name, Storage: storage})
+		require.NoError(t, err)
+		require.EqualValues(t, want.Seconds(), resp.Data["verification_ttl"])
+	}
+
+	// Lowering the max caps keys created with a longer verification TTL
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"max_verification_ttl": "3h"}))
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/override", Storage: storage})
+	require.NoError(t, err)
+	require.EqualValues(t, 10800, resp.Data["verification_ttl"])
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"default_verification_ttl": "4h"})
+	require.True(t, resp.IsError(), "default_verification_ttl above max_verification_ttl should be rejected")
+}
+
+func TestPathKeyVersions(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "versioned")
+
+	for range 2 {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/versioned/rotate", Storage: storage})
+		require.NoError(t, err)
+	}
+
+	// Expire v1 as if its verification window had passed before tidy ran
+	key, err := b.getKey(context.Background(), storage, "versioned")
+	require.NoError(t, err)
+	key.PreviousVersions[0].ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, b.putKey(context.Background(), storage, key))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ListOperation, Path: "key/versioned/versions/", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3"}, resp.Data["keys"])
+	keyInfo := resp.Data["key_info"].(map[string]any)
+	require.Equal(t, keyVersionStatusExpired, keyInfo["1"].(map[string]any)["status"])
+	require.Equal(t, keyVersionStatusVerificationOnly, keyInfo["2"].(map[string]any)["status"])
+	require.Equal(t, keyVersionStatusActive, keyInfo["3"].(map[string]any)["status"])
+	require.Equal(t, "", keyInfo["3"].(map[string]any)["expires_at"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/versioned/versions/2", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, "versioned-v2", resp.Data["key_id"])
+	require.Equal(t, keyVersionStatusVerificationOnly, resp.Data["status"])
+	require.Equal(t, key.PreviousVersions[1].PublicKey, resp.Data["public_key"])
+	require.NotEmpty(t, resp.Data["expires_at"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/versioned", Storage: storage})
+	require.NoError(t, err)
+	current := resp.Data["public_key"]
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/versioned/versions/3", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, current, resp.Data["public_key"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/versioned/versions/9", Storage: storage})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+func TestPathKeyConfig_Disable(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	token := resp.Data["token"].(string)
+
+	setEnabled := func(enabled bool) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "key/test-key/config",
+			Storage:   storage,
+			Data:      map[string]any{"enabled": enabled},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "key config failed: %v", resp.Error())
+		return resp
+	}
+
+	resp = setEnabled(false)
+	require.Equal(t, false, resp.Data["enabled"])
+	require.NotEmpty(t, resp.Data["disabled_expires_at"])
+
+	// Disabled keys sign nothing and cannot be rotated
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeServerError, false)
+	require.Contains(t, resp.Error().Error(), "disabled")
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/test-key/rotate", Storage: storage})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	// Tokens it signed still verify through the JWKS
+	parseIssuedToken(t, b, storage, token)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/test-key/versions/1", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, keyVersionStatusVerificationOnly, resp.Data["status"])
+
+	// Once its verification window ends the key leaves the JWKS
+	key, err := b.getKey(context.Background(), storage, "test-key")
+	require.NoError(t, err)
+	key.DisabledExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, b.putKey(context.Background(), storage, key))
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "jwks", Storage: storage})
+	require.NoError(t, err)
+	require.Empty(t, extractJWKSFromResponse(t, resp)["keys"])
+
+	resp = setEnabled(true)
+	require.Equal(t, true, resp.Data["enabled"])
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+}
@@ -0,0 +1,132 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// KeyTypeManaged keys sign with a Vault Enterprise managed key
+const KeyTypeManaged = "managed"
+
+// errManagedKeyUnavailable marks signing failures caused by the managed key
+// or its KMS being unavailable
+var errManagedKeyUnavailable = errors.New("managed key unavailable")
+
+// errManagedKeyRotation is returned when rotating a managed key, which is
+// rotated in its KMS rather than through this plugin
+var errManagedKeyRotation = errors.New("managed keys cannot be rotated by this plugin: rotate the key in its KMS, or create a new key and point roles at it")
+
+// managedKeySystemView returns the system view's managed key support, which
+// Vault Enterprise provides
+func (b *Backend) managedKeySystemView() (logical.ManagedKeySystemView, error) {
+	view, ok := b.System().(logical.ManagedKeySystemView)
+	if !ok {
+		return nil, fmt.Errorf("managed keys are not supported by this Vault server")
+	}
+	return view, nil
+}
+
+// readManagedKey returns the RSA public key of a managed signing key
+func (b *Backend) readManagedKey(ctx context.Context, name string) (*rsa.PublicKey, error) {
+	view, err := b.managedKeySystemView()
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey *rsa.PublicKey
+	err = view.WithManagedSigningKeyByName(ctx, name, b.backendUUID, func(ctx context.Context, key logical.ManagedSigningKey) error {
+		if !key.AllowsAll([]logical.KeyUsage{logical.KeyUsageSign}) {
+			return fmt.Errorf("managed key %q does not allow signing", name)
+		}
+		public, err := key.GetPublicKey(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read managed key public key: %w", err)
+		}
+		rsaKey, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("managed key %q is not an RSA key", name)
+		}
+		publicKey = rsaKey
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+// managedKeySigner signs JWS payloads with a managed key. It implements
+// jose.OpaqueSigner so it can be used wherever a private key is.
+type managedKeySigner struct {
+	ctx         context.Context
+	view        logical.ManagedKeySystemView
+	backendUUID string
+	key         *Key
+	algorithm   jose.SignatureAlgorithm
+	publicKey   *rsa.PublicKey
+}
+
+// Public returns the managed key's public key
+func (s *managedKeySigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.publicKey, KeyID: s.key.KeyID, Algorithm: string(s.algorithm), Use: "sig"}
+}
+
+// Algs returns the key's algorithm
+func (s *managedKeySigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{s.algorithm}
+}
+
+// SignPayload hashes the JWS signing input and signs the digest with the
+// managed key
+func (s *managedKeySigner) SignPayload(payload []byte, algorithm jose.SignatureAlgorithm) ([]byte, error) {
+	if algorithm != s.algorithm {
+		return nil, fmt.Errorf("managed key %q signs with %s, not %s", s.key.Name, s.algorithm, algorithm)
+	}
+
+	opts, err := rsaSignerOpts(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	hasher := opts.HashFunc().New()
+	hasher.Write(payload)
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	err = s.view.WithManagedSigningKeyByName(s.ctx, s.key.ManagedKeyName, s.backendUUID, func(ctx context.Context, key logical.ManagedSigningKey) error {
+		var err error
+		signature, err = key.Sign(ctx, digest, rand.Reader, opts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errManagedKeyUnavailable, err)
+	}
+	return signature, nil
+}
+
+// rsaSignerOpts returns the crypto.SignerOpts of a JWS RSA algorithm. The
+// PS algorithms use a salt as long as the hash.
+func rsaSignerOpts(algorithm jose.SignatureAlgorithm) (crypto.SignerOpts, error) {
+	switch algorithm {
+	case jose.RS256:
+		return crypto.SHA256, nil
+	case jose.RS384:
+		return crypto.SHA384, nil
+	case jose.RS512:
+		return crypto.SHA512, nil
+	case jose.PS256:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, nil
+	case jose.PS384:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, nil
+	case jose.PS512:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
@@ -0,0 +1,247 @@
+package tokenexchange
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// metricsPrefix is prepended to every metric emitted by the plugin
+var metricsPrefix = []string{"identity_delegation"}
+
+// Metric names, relative to metricsPrefix
+var (
+	metricExchange        = []string{"exchange"}
+	metricExchangeSuccess = []string{"exchange", "success"}
+	metricExchangeFailure = []string{"exchange", "failure"}
+	metricJWKSFetch       = []string{"jwks", "fetch"}
+	metricJWKSFetchError  = []string{"jwks", "fetch", "error"}
+	metricJWKSKidRemoved  = []string{"jwks", "kid", "removed_early"}
+	metricTokenSign       = []string{"token", "sign"}
+	metricCacheHit        = []string{"cache", "hit"}
+	metricCacheMiss       = []string{"cache", "miss"}
+	metricKeyRotate       = []string{"key", "rotate"}
+)
+
+// Key rotation triggers, used as the trigger label on key rotation events
+const (
+	rotationTriggerManual    = "manual"
+	rotationTriggerAutomatic = "automatic"
+)
+
+// latencySampleSize is the number of recent signing latencies kept for the
+// percentiles reported by the metrics endpoint
+const latencySampleSize = 1024
+
+// mountStats holds in-memory counters for this mount, reported by the metrics
+// endpoint for environments without a Vault telemetry sink. Counters reset
+// when the plugin restarts.
+type mountStats struct {
+	mu sync.Mutex
+
+	since time.Time
+
+	exchangeSuccess map[string]uint64
+	exchangeFailure map[string]uint64
+
+	cacheHits   map[string]uint64
+	cacheMisses map[string]uint64
+
+	jwksFetches          uint64
+	jwksFetchFailures    uint64
+	jwksKidsRemovedEarly uint64
+
+	keyRotations map[string]uint64
+
+	// signLatency is a ring buffer of the most recent signing latencies
+	signLatency []time.Duration
+	signCount   uint64
+}
+
+// newMountStats creates empty mount counters
+func newMountStats() *mountStats {
+	return &mountStats{
+		since:           time.Now(),
+		exchangeSuccess: make(map[string]uint64),
+		exchangeFailure: make(map[string]uint64),
+		cacheHits:       make(map[string]uint64),
+		cacheMisses:     make(map[string]uint64),
+		keyRotations:    make(map[string]uint64),
+		signLatency:     make([]time.Duration, 0, latencySampleSize),
+	}
+}
+
+// metricKey prefixes a metric name
+func metricKey(name []string) []string {
+	key := make([]string, 0, len(metricsPrefix)+len(name))
+	key = append(key, metricsPrefix...)
+	return append(key, name...)
+}
+
+// incrCounter increments a counter through the Vault SDK metrics sink
+func (b *Backend) incrCounter(name []string, labels ...metrics.Label) {
+	metrics.IncrCounterWithLabels(metricKey(name), 1, labels)
+}
+
+// measureSince records the time elapsed since start through the Vault SDK metrics sink
+func (b *Backend) measureSince(name []string, start time.Time, labels ...metrics.Label) {
+	metrics.MeasureSinceWithLabels(metricKey(name), start, labels)
+}
+
+// recordCacheLookup counts a hit or miss for the named cache
+func (b *Backend) recordCacheLookup(cache string, hit bool) {
+	label := metrics.Label{Name: "cache", Value: cache}
+
+	b.stats.mu.Lock()
+	if hit {
+		b.stats.cacheHits[cache]++
+	} else {
+		b.stats.cacheMisses[cache]++
+	}
+	b.stats.mu.Unlock()
+
+	if hit {
+		b.incrCounter(metricCacheHit, label)
+	} else {
+		b.incrCounter(metricCacheMiss, label)
+	}
+}
+
+// recordExchange counts a token exchange outcome and its latency per role
+func (b *Backend) recordExchange(role string, success bool, start time.Time) {
+	label := metrics.Label{Name: "role", Value: role}
+
+	b.stats.mu.Lock()
+	if success {
+		b.stats.exchangeSuccess[role]++
+	} else {
+		b.stats.exchangeFailure[role]++
+	}
+	b.stats.mu.Unlock()
+
+	b.measureSince(metricExchange, start, label)
+	if success {
+		b.incrCounter(metricExchangeSuccess, label)
+	} else {
+		b.incrCounter(metricExchangeFailure, label)
+	}
+}
+
+// recordJWKSFetch counts a subject JWKS fetch and its latency
+func (b *Backend) recordJWKSFetch(start time.Time, err error) {
+	b.stats.mu.Lock()
+	b.stats.jwksFetches++
+	if err != nil {
+		b.stats.jwksFetchFailures++
+	}
+	b.stats.mu.Unlock()
+
+	if err != nil {
+		b.incrCounter(metricJWKSFetchError)
+		return
+	}
+	b.measureSince(metricJWKSFetch, start)
+}
+
+// recordKidRemovedEarly warns that a subject JWKS key ID disappeared while
+// subject tokens it verified were still valid, a sign of issuer misrotation
+func (b *Backend) recordKidRemovedEarly(jwksURI, kid string, verifiedUntil time.Time) {
+	b.stats.mu.Lock()
+	b.stats.jwksKidsRemovedEarly++
+	b.stats.mu.Unlock()
+
+	b.Logger().Warn("subject JWKS key removed before tokens it verified expired",
+		"jwks_uri", jwksURI, "kid", kid, "verified_until", verifiedUntil.UTC().Format(time.RFC3339))
+	b.incrCounter(metricJWKSKidRemoved, metrics.Label{Name: "kid", Value: kid})
+}
+
+// recordTokenSign records the latency of generating and signing a token
+func (b *Backend) recordTokenSign(start time.Time) {
+	elapsed := time.Since(start)
+
+	b.stats.mu.Lock()
+	if len(b.stats.signLatency) < latencySampleSize {
+		b.stats.signLatency = append(b.stats.signLatency, elapsed)
+	} else {
+		b.stats.signLatency[b.stats.signCount%latencySampleSize] = elapsed
+	}
+	b.stats.signCount++
+	b.stats.mu.Unlock()
+
+	b.measureSince(metricTokenSign, start)
+}
+
+// recordKeyRotation counts a key rotation event
+func (b *Backend) recordKeyRotation(key, trigger string) {
+	b.stats.mu.Lock()
+	b.stats.keyRotations[trigger]++
+	b.stats.mu.Unlock()
+
+	b.incrCounter(metricKeyRotate, metrics.Label{Name: "key", Value: key}, metrics.Label{Name: "trigger", Value: trigger})
+}
+
+// snapshot returns a copy of the mount counters suitable for an API response
+func (s *mountStats) snapshot() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exchanges := map[string]any{}
+	for role, count := range s.exchangeSuccess {
+		exchanges[role] = map[string]any{"success": count, "failure": s.exchangeFailure[role]}
+	}
+	for role, count := range s.exchangeFailure {
+		if _, ok := exchanges[role]; !ok {
+			exchanges[role] = map[string]any{"success": uint64(0), "failure": count}
+		}
+	}
+
+	caches := map[string]any{}
+	for _, name := range []string{"config", "key", "jwks", "directory"} {
+		hits, misses := s.cacheHits[name], s.cacheMisses[name]
+		hitRate := 0.0
+		if hits+misses > 0 {
+			hitRate = float64(hits) / float64(hits+misses)
+		}
+		caches[name] = map[string]any{"hits": hits, "misses": misses, "hit_rate": hitRate}
+	}
+
+	rotations := map[string]any{}
+	for _, trigger := range []string{rotationTriggerManual, rotationTriggerAutomatic} {
+		rotations[trigger] = s.keyRotations[trigger]
+	}
+
+	return map[string]any{
+		"since":     s.since.UTC().Format(time.RFC3339),
+		"exchanges": exchanges,
+		"cache":     caches,
+		"jwks_fetch": map[string]any{
+			"total":              s.jwksFetches,
+			"failures":           s.jwksFetchFailures,
+			"kids_removed_early": s.jwksKidsRemovedEarly,
+		},
+		"signing_latency_ms": latencyPercentiles(s.signLatency, s.signCount),
+		"key_rotations":      rotations,
+	}
+}
+
+// latencyPercentiles summarises latency samples in milliseconds
+func latencyPercentiles(samples []time.Duration, count uint64) map[string]any {
+	result := map[string]any{"count": count}
+	if len(samples) == 0 {
+		return result
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for name, p := range map[string]float64{"p50": 0.50, "p90": 0.90, "p99": 0.99} {
+		idx := int(p * float64(len(sorted)-1))
+		result[name] = float64(sorted[idx].Microseconds()) / 1000
+	}
+	result["max"] = float64(sorted[len(sorted)-1].Microseconds()) / 1000
+
+	return result
+}
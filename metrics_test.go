@@ -0,0 +1,127 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestMetrics installs an in-memory global metrics sink for the test
+func setupTestMetrics(t *testing.T) *metrics.InmemSink {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	_, err := metrics.NewGlobal(conf, sink)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+	})
+
+	return sink
+}
+
+// counterValue returns the sum of the named counter in the current interval
+func counterValue(sink *metrics.InmemSink, name string) float64 {
+	data := sink.Data()
+	if len(data) == 0 {
+		return 0
+	}
+
+	current := data[len(data)-1]
+	current.RLock()
+	defer current.RUnlock()
+
+	return current.Counters[name].Sum
+}
+
+// TestMetrics_Exchange tests that exchange outcomes, cache lookups and key
+// rotations are emitted to the metrics sink
+func TestMetrics_Exchange(t *testing.T) {
+	sink := setupTestMetrics(t)
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	expired := defaultSubjectClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, expired)
+	require.True(t, resp.IsError())
+
+	require.Equal(t, float64(1), counterValue(sink, "identity_delegation.exchange.success;role=test-role"))
+	require.Equal(t, float64(1), counterValue(sink, "identity_delegation.exchange.failure;role=test-role"))
+	require.Greater(t, counterValue(sink, "identity_delegation.cache.hit;cache=config"), float64(0))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/test-key/rotate",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	require.Equal(t, float64(1), counterValue(sink, "identity_delegation.key.rotate;key=test-key;trigger=manual"))
+}
+
+// TestMetrics_Snapshot tests the metrics endpoint reports mount counters
+func TestMetrics_Snapshot(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	for i := 0; i < 2; i++ {
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metrics",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	exchanges := resp.Data["exchanges"].(map[string]any)
+	require.Equal(t, map[string]any{"success": uint64(2), "failure": uint64(0)}, exchanges["test-role"])
+
+	// The second exchange reuses the cached subject JWKS
+	jwksFetch := resp.Data["jwks_fetch"].(map[string]any)
+	require.Equal(t, uint64(1), jwksFetch["total"])
+	require.Equal(t, uint64(0), jwksFetch["failures"])
+
+	caches := resp.Data["cache"].(map[string]any)
+	require.Greater(t, caches["key"].(map[string]any)["hit_rate"].(float64), 0.0)
+	require.Equal(t, uint64(1), caches["jwks"].(map[string]any)["hits"])
+
+	latency := resp.Data["signing_latency_ms"].(map[string]any)
+	require.Equal(t, uint64(2), latency["count"])
+	require.Contains(t, latency, "p50")
+	require.Contains(t, latency, "p99")
+}
+
+// TestLatencyPercentiles tests percentile calculation over latency samples
+func TestLatencyPercentiles(t *testing.T) {
+	samples := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	result := latencyPercentiles(samples, 100)
+	require.Equal(t, 50.0, result["p50"])
+	require.Equal(t, 90.0, result["p90"])
+	require.Equal(t, 99.0, result["p99"])
+	require.Equal(t, 100.0, result["max"])
+
+	require.Equal(t, map[string]any{"count": uint64(0)}, latencyPercentiles(nil, 0))
+}
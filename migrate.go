@@ -0,0 +1,229 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// legacySigningKeyName is the named key a config signing_key is imported as
+// when default_key is not set
+const legacySigningKeyName = "default"
+
+// importLegacySigningKey stores the PEM signing key of a pre-key-subsystem
+// config as a named RS256 key and returns its name. An existing key of that
+// name is reused if it holds the same private key. Callers must hold b.lock.
+func (b *Backend) importLegacySigningKey(ctx context.Context, storage logical.Storage, name, signingKeyPEM string) (string, error) {
+	if name == "" {
+		name = legacySigningKeyName
+	}
+
+	privateKey, err := parsePrivateKey(signingKeyPEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid signing_key: %w", err)
+	}
+
+	existing, err := b.getKey(ctx, storage, name)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		existingKey, err := parsePrivateKey(existing.PrivateKey)
+		if err == nil && existingKey.Equal(privateKey) {
+			return name, nil
+		}
+		return "", fmt.Errorf("cannot import signing_key as key %q: a different key with that name exists", name)
+	}
+
+	now := time.Now()
+	key := &Key{
+		Name:       name,
+		KeyID:      generateKeyID(name, 1),
+		Algorithm:  AlgorithmRS256,
+		PrivateKey: encodePrivateKeyPEM(privateKey),
+		CreatedAt:  now,
+		RotatedAt:  now,
+		Version:    1,
+	}
+	if err := b.putKey(ctx, storage, key); err != nil {
+		return "", err
+	}
+	b.resetKeyCache(name)
+
+	return name, nil
+}
+
+// migrateLegacyConfig moves a signing_key stored by an older version of the
+// plugin into a named key referenced by default_key, and renamed fields to
+// their current names
+func (b *Backend) migrateLegacyConfig(ctx context.Context, storage logical.Storage) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Read storage directly: getConfig upgrades renamed fields in memory only
+	entry, err := storage.Get(ctx, configStoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	if entry == nil {
+		return nil
+	}
+	config := &Config{}
+	if err := entry.DecodeJSON(config); err != nil {
+		return fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	config.CASVersion = storedVersion(config.CASVersion)
+
+	upgraded := config.upgrade()
+	if config.LegacySigningKey != "" {
+		name, err := b.importLegacySigningKey(ctx, storage, config.DefaultKey, config.LegacySigningKey)
+		if err != nil {
+			return err
+		}
+		config.DefaultKey = name
+		config.LegacySigningKey = ""
+		upgraded = true
+		b.Logger().Info("migrated config signing_key to a named key", "default_key", name)
+	}
+	if !upgraded {
+		return nil
+	}
+
+	if err := putConfig(ctx, storage, config); err != nil {
+		return err
+	}
+	b.resetConfigCache()
+	return nil
+}
+
+// migrateLegacyRoles rewrites roles stored with fields that have since been
+// renamed. Callers must hold b.writeLock.
+func (b *Backend) migrateLegacyRoles(ctx context.Context, storage logical.Storage) error {
+	names, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	for _, name := range names {
+		entry, err := storage.Get(ctx, roleStoragePrefix+name)
+		if err != nil {
+			return fmt.Errorf("failed to read role %q: %w", name, err)
+		}
+		if entry == nil {
+			continue
+		}
+		role := &Role{}
+		if err := entry.DecodeJSON(role); err != nil {
+			return fmt.Errorf("failed to decode role %q: %w", name, err)
+		}
+		if !role.upgrade() {
+			continue
+		}
+		if err := putStorageJSON(ctx, storage, roleStoragePrefix+name, role); err != nil {
+			return err
+		}
+		b.Logger().Info("migrated role template to actor_template", "role", name)
+	}
+	return nil
+}
+
+// upgrade moves fields of a config written by an older version of the
+// plugin to their current names. It reports whether anything changed.
+func (c *Config) upgrade() bool {
+	if c.LegacyDelegateJWKSURI == "" {
+		return false
+	}
+	if c.SubjectJWKSURI == "" && !c.hasStaticSubjectKeys() {
+		c.SubjectJWKSURI = c.LegacyDelegateJWKSURI
+	}
+	c.LegacyDelegateJWKSURI = ""
+	return true
+}
+
+// upgrade moves fields of a role written by an older version of the plugin
+// to their current names. It reports whether anything changed.
+func (r *Role) upgrade() bool {
+	if r.LegacyTemplate == "" {
+		return false
+	}
+	if r.ActorTemplate == "" {
+		r.ActorTemplate = r.LegacyTemplate
+	}
+	r.LegacyTemplate = ""
+	return true
+}
+
+// storageVersionPath holds the schema version of the mount's storage
+const storageVersionPath = "storage_version"
+
+// storageVersion is the stored schema version marker
+type storageVersion struct {
+	Version    int       `json:"version"`
+	UpgradedAt time.Time `json:"upgraded_at"`
+}
+
+// storageMigrations upgrade storage one schema version at a time:
+// storageMigrations[i] upgrades version i to i+1, so the current version is
+// len(storageMigrations). Mounts without a marker are version 0. A failed
+// migration is retried on the next start, so migrations must be idempotent.
+var storageMigrations = []func(b *Backend, ctx context.Context, storage logical.Storage) error{
+	// 1: named keys replace the config signing_key, subject_jwks_uri replaces
+	// delegate_jwks_uri and actor_template replaces the role template
+	func(b *Backend, ctx context.Context, storage logical.Storage) error {
+		if err := b.migrateLegacyConfig(ctx, storage); err != nil {
+			return err
+		}
+		return b.migrateLegacyRoles(ctx, storage)
+	},
+}
+
+// currentStorageVersion is the schema version this plugin writes
+func currentStorageVersion() int {
+	return len(storageMigrations)
+}
+
+// readStorageVersion returns the schema version of the mount's storage
+func readStorageVersion(ctx context.Context, storage logical.Storage) (int, error) {
+	entry, err := storage.Get(ctx, storageVersionPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read storage version: %w", err)
+	}
+	if entry == nil {
+		return 0, nil
+	}
+	marker := &storageVersion{}
+	if err := entry.DecodeJSON(marker); err != nil {
+		return 0, fmt.Errorf("failed to decode storage version: %w", err)
+	}
+	return marker.Version, nil
+}
+
+// upgradeStorage runs the migrations from the mount's storage version to the
+// current one, recording each version reached
+func (b *Backend) upgradeStorage(ctx context.Context, storage logical.Storage) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	version, err := readStorageVersion(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if version > currentStorageVersion() {
+		b.Logger().Warn("storage was written by a newer version of the plugin", "storage_version", version, "supported_version", currentStorageVersion())
+		return nil
+	}
+
+	for ; version < currentStorageVersion(); version++ {
+		if err := storageMigrations[version](b, ctx, storage); err != nil {
+			return fmt.Errorf("failed to upgrade storage to version %d: %w", version+1, err)
+		}
+		marker := &storageVersion{Version: version + 1, UpgradedAt: time.Now().UTC()}
+		if err := putStorageJSON(ctx, storage, storageVersionPath, marker); err != nil {
+			return err
+		}
+		b.Logger().Info("upgraded storage", "storage_version", version+1)
+	}
+	return nil
+}
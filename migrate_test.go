@@ -0,0 +1,150 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrateLegacyConfig tests that a stored config signing_key is moved to
+// a named key on startup
+func TestMigrateLegacyConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	privateKey, privateKeyPEM := generateTestKeyPair(t)
+	entry, err := logical.StorageEntryJSON(configStoragePath, map[string]any{
+		"issuer":      "https://vault.example.com",
+		"signing_key": privateKeyPEM,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+
+	require.NoError(t, b.initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	config, err := b.getConfig(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, legacySigningKeyName, config.DefaultKey)
+	require.Empty(t, config.LegacySigningKey)
+
+	key, err := b.getKey(ctx, storage, legacySigningKeyName)
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	require.Equal(t, AlgorithmRS256, key.Algorithm)
+	imported, err := parsePrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+	require.True(t, imported.Equal(privateKey))
+
+	// The PEM is no longer held in the config
+	entry, err = storage.Get(ctx, configStoragePath)
+	require.NoError(t, err)
+	require.NotContains(t, string(entry.Value), "signing_key")
+}
+
+// TestConfigWrite_LegacySigningKey tests that writing the deprecated
+// signing_key imports it as the default key
+func TestConfigWrite_LegacySigningKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	_, privateKeyPEM := generateTestKeyPair(t)
+
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"signing_key": privateKeyPEM, "default_key": "imported"})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "signing_key is deprecated")
+
+	config, err := b.getConfig(context.Background(), storage)
+	require.NoError(t, err)
+	require.Equal(t, "imported", config.DefaultKey)
+	require.Empty(t, config.LegacySigningKey)
+
+	// Writing the same key again is idempotent; a different key is refused
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"signing_key": privateKeyPEM})
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+
+	_, otherPEM := generateTestKeyPair(t)
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"signing_key": otherPEM})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "a different key with that name exists")
+}
+
+// TestUpgradeStorage_LegacyFields tests that renamed config and role fields
+// are read under their current names and rewritten on startup
+func TestUpgradeStorage_LegacyFields(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	entry, err := logical.StorageEntryJSON(configStoragePath, map[string]any{
+		"issuer":            "https://vault.example.com",
+		"delegate_jwks_uri": "https://idp.example.com/jwks",
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+	entry, err = logical.StorageEntryJSON(roleStoragePrefix+"legacy", map[string]any{
+		"name":     "legacy",
+		"ttl":      3600000000000,
+		"template": `{"department": "{{identity.entity.metadata.department}}"}`,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+
+	// Reads see the current fields before the upgrade has run
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "role/legacy", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, `{"department": "{{identity.entity.metadata.department}}"}`, resp.Data["actor_template"])
+	config, err := b.getConfig(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, "https://idp.example.com/jwks", config.SubjectJWKSURI)
+
+	require.NoError(t, b.initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	entry, err = storage.Get(ctx, configStoragePath)
+	require.NoError(t, err)
+	require.NotContains(t, string(entry.Value), "delegate_jwks_uri")
+	require.Contains(t, string(entry.Value), `"subject_jwks_uri":"https://idp.example.com/jwks"`)
+
+	entry, err = storage.Get(ctx, roleStoragePrefix+"legacy")
+	require.NoError(t, err)
+	require.NotContains(t, string(entry.Value), `"template"`)
+	require.Contains(t, string(entry.Value), `"actor_template"`)
+
+	version, err := readStorageVersion(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, currentStorageVersion(), version)
+}
+
+// TestUpgradeStorage_Versions tests that migrations run only from the stored
+// storage version and never for storage of a newer plugin
+func TestUpgradeStorage_Versions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("new mount", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		require.NoError(t, b.initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+		version, err := readStorageVersion(ctx, storage)
+		require.NoError(t, err)
+		require.Equal(t, currentStorageVersion(), version)
+	})
+
+	t.Run("newer storage", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		entry, err := logical.StorageEntryJSON(storageVersionPath, map[string]any{"version": currentStorageVersion() + 1})
+		require.NoError(t, err)
+		require.NoError(t, storage.Put(ctx, entry))
+		entry, err = logical.StorageEntryJSON(roleStoragePrefix+"legacy", map[string]any{"name": "legacy", "template": "{}"})
+		require.NoError(t, err)
+		require.NoError(t, storage.Put(ctx, entry))
+
+		require.NoError(t, b.initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+		entry, err = storage.Get(ctx, roleStoragePrefix+"legacy")
+		require.NoError(t, err)
+		require.Contains(t, string(entry.Value), `"template"`)
+		version, err := readStorageVersion(ctx, storage)
+		require.NoError(t, err)
+		require.Equal(t, currentStorageVersion()+1, version)
+	})
+}
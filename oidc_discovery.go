@@ -0,0 +1,152 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxDiscoveryDocumentSize bounds the OpenID Connect discovery document read
+const maxDiscoveryDocumentSize = 1 << 20
+
+// oidcDiscoveryEntry is the subject jwks_uri discovered for an issuer
+type oidcDiscoveryEntry struct {
+	jwksURI   string
+	expiresAt time.Time
+}
+
+// oidcDiscoveryValidator verifies JWTs from subject_oidc_issuer with the JWKS
+// its OpenID Connect discovery document points at
+type oidcDiscoveryValidator struct {
+	b *Backend
+}
+
+func (v *oidcDiscoveryValidator) Name() string { return "oidc_discovery" }
+
+func (v *oidcDiscoveryValidator) TokenTypes() []string { return []string{tokenTypeJWT} }
+
+func (v *oidcDiscoveryValidator) Configured(config *Config) error {
+	if config.SubjectOIDCIssuer == "" {
+		return fmt.Errorf("subject_oidc_issuer")
+	}
+	return nil
+}
+
+func (v *oidcDiscoveryValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	discovered, err := v.b.discoveredJWKSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedToken, err := v.b.parseSubjectJWT(ctx, config, token)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := v.b.verifyWithSubjectJWKS(ctx, storage, discovered, parsedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// The discovered keys are only trusted for the issuer that published them
+	if err := validateBoundIssuer(claims, config.SubjectOIDCIssuer); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// discoveredJWKSConfig returns a copy of the config whose subject_jwks_uri is
+// the one discovered for subject_oidc_issuer, so the JWKS is fetched, cached
+// and reported like a configured one
+func (b *Backend) discoveredJWKSConfig(ctx context.Context, config *Config) (*Config, error) {
+	jwksURI, err := b.discoverSubjectJWKSURI(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errJWKSUnavailable, err)
+	}
+
+	discovered := *config
+	discovered.SubjectJWKSURI = jwksURI
+	return &discovered, nil
+}
+
+// discoverSubjectJWKSURI returns the jwks_uri of subject_oidc_issuer from its
+// discovery document, cached for as long as the JWKS it points at
+func (b *Backend) discoverSubjectJWKSURI(ctx context.Context, config *Config) (string, error) {
+	issuer := config.SubjectOIDCIssuer
+
+	b.cacheLock.RLock()
+	cached, ok := b.oidcDiscoveryCache[issuer]
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.jwksURI, nil
+	}
+
+	client, err := b.getJWKSClient(config)
+	if err != nil {
+		return "", err
+	}
+	jwksURI, err := fetchDiscoveredJWKSURI(ctx, client, issuer)
+	if err != nil {
+		return "", err
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.oidcDiscoveryCache[issuer] = &oidcDiscoveryEntry{
+			jwksURI:   jwksURI,
+			expiresAt: time.Now().Add(subjectJWKSCacheTTL),
+		}
+	}
+	b.cacheLock.Unlock()
+
+	return jwksURI, nil
+}
+
+// fetchDiscoveredJWKSURI reads <issuer>/.well-known/openid-configuration and
+// returns its jwks_uri. The document must name the same issuer.
+func fetchDiscoveredJWKSURI(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch discovery document: %s, status %d", discoveryURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDiscoveryDocumentSize))
+	if err != nil {
+		return "", fmt.Errorf("unable to read discovery document: %w", err)
+	}
+
+	var document struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if document.Issuer != issuer {
+		return "", fmt.Errorf("discovery document issuer %q does not match subject_oidc_issuer %q", document.Issuer, issuer)
+	}
+	if document.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document has no jwks_uri")
+	}
+
+	return document.JWKSURI, nil
+}
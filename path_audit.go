@@ -0,0 +1,35 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathAuditExport returns the path configuration for /audit/export endpoint
+func pathAuditExport(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "audit/export",
+
+		Fields: map[string]*framework.FieldSchema{
+			"start": {
+				Type:        framework.TypeTime,
+				Description: "Start of the time range, inclusive, as RFC 3339 or unix seconds",
+				Required:    true,
+			},
+			"end": {
+				Type:        framework.TypeTime,
+				Description: "End of the time range, exclusive, as RFC 3339 or unix seconds. Defaults to now",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathAuditExport,
+				Summary:  "Export signed issuance records for a time range",
+			},
+		},
+
+		HelpSynopsis:    "Export signed issuance records",
+		HelpDescription: "Returns the records of tokens issued in a time range as a JWT signed by the configured audit_key. Each record names the subject, the actor, the role, the audience and scope delegated, and the token's jti and lifetime. The bundle can be verified against the plugin's JWKS, giving tamper-evident evidence of delegation. Records are kept only while audit_key is configured.",
+	}
+}
@@ -0,0 +1,93 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathAuditExport handles exporting issuance records as a signed bundle
+func (b *Backend) pathAuditExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.AuditKey == "" {
+		return logical.ErrorResponse("audit_key is not configured"), nil
+	}
+
+	startRaw, ok := data.GetOk("start")
+	if !ok {
+		return logical.ErrorResponse("start is required"), nil
+	}
+	start := startRaw.(time.Time)
+
+	end := time.Now()
+	if endRaw, ok := data.GetOk("end"); ok {
+		end = endRaw.(time.Time)
+	}
+	if !start.Before(end) {
+		return logical.ErrorResponse("start must be before end"), nil
+	}
+
+	key, err := b.getKey(ctx, req.Storage, config.AuditKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit key: %w", err)
+	}
+	if key == nil {
+		return logical.ErrorResponse("audit key %q not found", config.AuditKey), nil
+	}
+	if key.Disabled {
+		return logical.ErrorResponse("audit key %q is disabled", config.AuditKey), nil
+	}
+
+	records, err := listIssuanceRecords(ctx, req.Storage, start, end, maxAuditExportRecords)
+	if err != nil {
+		return logical.ErrorResponse("%s, narrow the time range", err), nil
+	}
+
+	signingKey, err := b.keySigner(ctx, config, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit key: %w", err)
+	}
+
+	bundle, bundleID, err := signAuditReceipts(config, key, signingKey, start, end, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"bundle":       bundle,
+			"bundle_id":    bundleID,
+			"key_id":       key.KeyID,
+			"record_count": len(records),
+		},
+	}, nil
+}
+
+// recordIssuance stores a record of the issued token while an audit key is
+// configured. A failure fails the exchange so no token goes unrecorded.
+func (b *Backend) recordIssuance(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.succeeded() || ex.issued == nil || ex.config.AuditKey == "" {
+		return nil, nil
+	}
+
+	record := &issuanceRecord{
+		JTI:           ex.issued.JTI,
+		IssuedAt:      ex.issued.IssuedAt,
+		ExpiresAt:     ex.issued.ExpiresAt,
+		Role:          ex.roleName,
+		Subject:       ex.issued.Subject,
+		Actor:         ex.issued.Actor,
+		ActorEntityID: ex.req.EntityID,
+		Audience:      ex.actorClaims["aud"],
+		Scope:         ex.issued.Scope,
+		KeyID:         ex.issued.KeyID,
+	}
+
+	return nil, putIssuanceRecord(ctx, ex.req.Storage, record)
+}
@@ -0,0 +1,182 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// exportAuditReceipts requests a signed bundle of issuance records
+func exportAuditReceipts(t *testing.T, b *Backend, storage logical.Storage, start, end time.Time) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "audit/export",
+		Storage:   storage,
+		Data: map[string]any{
+			"start": start.Format(time.RFC3339Nano),
+			"end":   end.Format(time.RFC3339Nano),
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// configureAuditKey rewrites the test config with the given audit key
+func configureAuditKey(t *testing.T, b *Backend, storage logical.Storage, auditKey string) *logical.Response {
+	config, err := b.getConfig(context.Background(), storage)
+	require.NoError(t, err)
+	return writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": config.SubjectJWKSURI, "audit_key": auditKey})
+}
+
+// TestAuditExport tests recording issued tokens and exporting them as a
+// bundle signed by the audit key
+func TestAuditExport(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	start := time.Now().Add(-time.Minute)
+
+	// Nothing is recorded or exported without an audit key
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	resp = exportAuditReceipts(t, b, storage, start, time.Now())
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "audit_key is not configured")
+
+	// The audit key must be dedicated
+	resp = configureAuditKey(t, b, storage, "test-key")
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "must not be used by roles: test-role")
+
+	auditKeyID := createTestKey(t, b, storage, "audit-key")
+	resp = configureAuditKey(t, b, storage, "audit-key")
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      map[string]any{"key": "audit-key", "ttl": "1h", "actor_template": "{}", "subject_template": "{}", "context": "urn:documents:read"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "is the audit key")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "key/audit-key",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "Unset audit_key")
+
+	var jtis []string
+	for range 2 {
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		jtis = append(jtis, resp.Data["jti"].(string))
+	}
+
+	resp = exportAuditReceipts(t, b, storage, start, time.Now())
+	require.False(t, resp.IsError(), "export failed: %v", resp.Error())
+	require.Equal(t, 2, resp.Data["record_count"])
+	require.Equal(t, auditKeyID, resp.Data["key_id"])
+
+	// The bundle verifies against the published audit key
+	claims := parseIssuedToken(t, b, storage, resp.Data["bundle"].(string))
+	require.Equal(t, "https://vault.example.com", claims["iss"])
+	require.Equal(t, resp.Data["bundle_id"], claims["jti"])
+	require.EqualValues(t, 2, claims["record_count"])
+
+	records := claims["records"].([]any)
+	require.Len(t, records, 2)
+	for i, raw := range records {
+		record := raw.(map[string]any)
+		require.Equal(t, jtis[i], record["jti"])
+		require.Equal(t, "test-role", record["role"])
+		require.Equal(t, "user-123", record["subject"])
+		require.Equal(t, "agent-123", record["actor"])
+		require.Equal(t, "test-entity", record["actor_entity_id"])
+		require.Equal(t, "urn:documents:read", record["scope"])
+		require.NotEmpty(t, record["key_id"])
+	}
+
+	// Records outside the range are excluded
+	resp = exportAuditReceipts(t, b, storage, start.Add(-time.Hour), start)
+	require.False(t, resp.IsError(), "export failed: %v", resp.Error())
+	require.Equal(t, 0, resp.Data["record_count"])
+
+	resp = exportAuditReceipts(t, b, storage, time.Now(), start)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "start must be before end")
+}
+
+// TestTidyIssuanceRecords tests that records past the retention are deleted
+func TestTidyIssuanceRecords(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	now := time.Now()
+	require.NoError(t, putIssuanceRecord(ctx, storage, &issuanceRecord{JTI: "old", IssuedAt: now.Add(-defaultRecordRetention - time.Hour)}))
+	require.NoError(t, putIssuanceRecord(ctx, storage, &issuanceRecord{JTI: "recent", IssuedAt: now.Add(-time.Hour)}))
+
+	deleted, err := b.tidyIssuanceRecords(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	records, err := listIssuanceRecords(ctx, storage, time.Time{}, now, maxAuditExportRecords)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "recent", records[0].JTI)
+}
+
+// TestRecordRetention tests the default retention, per-type overrides and the
+// deprecated issuance_retention
+func TestRecordRetention(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	readConfig := func() map[string]any {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+		require.NoError(t, err)
+		return resp.Data
+	}
+
+	data := readConfig()
+	require.EqualValues(t, 7776000, data["record_retention"])
+	require.Empty(t, data["record_retention_overrides"])
+
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"record_retention": "720h", "record_retention_overrides": map[string]any{"issuance": "1h"}})
+	require.Nil(t, resp)
+	data = readConfig()
+	require.EqualValues(t, 2592000, data["record_retention"])
+	require.Equal(t, map[string]string{"issuance": "3600"}, data["record_retention_overrides"])
+
+	// The override applies to issuance records
+	now := time.Now()
+	require.NoError(t, putIssuanceRecord(ctx, storage, &issuanceRecord{JTI: "old", IssuedAt: now.Add(-2 * time.Hour)}))
+	require.NoError(t, putIssuanceRecord(ctx, storage, &issuanceRecord{JTI: "recent", IssuedAt: now.Add(-time.Minute)}))
+	_, err := b.tidyIssuanceRecords(ctx, storage)
+	require.NoError(t, err)
+	records, err := listIssuanceRecords(ctx, storage, time.Time{}, now, maxAuditExportRecords)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "recent", records[0].JTI)
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"record_retention_overrides": map[string]any{"sessions": "1h"}})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), `unknown record type "sessions"`)
+
+	// issuance_retention is still accepted and becomes the issuance override
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"issuance_retention": "48h"})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+	require.Contains(t, resp.Warnings[0], "issuance_retention is deprecated")
+	require.Equal(t, map[string]string{"issuance": "172800"}, readConfig()["record_retention_overrides"])
+}
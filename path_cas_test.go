@@ -0,0 +1,209 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// casRequest sends an update with the given data and returns the response
+func casRequest(t *testing.T, b *Backend, storage logical.Storage, path string, data map[string]any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path,
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// readCASVersion reads path and returns its cas_version
+func readCASVersion(t *testing.T, b *Backend, storage logical.Storage, path string) int {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: path, Storage: storage})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp.Data["cas_version"].(int)
+}
+
+func TestCAS_Config(t *testing.T) {
+	b, storage := getTestBackend(t)
+	config := func(cas int) map[string]any {
+		return map[string]any{
+			"issuer":           "https://vault.example.com",
+			"subject_jwks_uri": "https://vault.example.com/.well-known/jwks.json",
+			"cas":              cas,
+		}
+	}
+
+	resp := casRequest(t, b, storage, "config", config(1))
+	require.True(t, resp.IsError(), "cas 1 must fail while config does not exist")
+	require.Contains(t, resp.Error().Error(), "check-and-set")
+
+	require.Nil(t, casRequest(t, b, storage, "config", config(0)))
+	require.Equal(t, 1, readCASVersion(t, b, storage, "config"))
+
+	resp = casRequest(t, b, storage, "config", config(0))
+	require.True(t, resp.IsError(), "cas 0 must fail once config exists")
+
+	updated := config(1)
+	updated["issuer"] = "https://vault2.example.com"
+	require.Nil(t, casRequest(t, b, storage, "config", updated))
+	require.Equal(t, 2, readCASVersion(t, b, storage, "config"))
+
+	resp = casRequest(t, b, storage, "config", config(1))
+	require.True(t, resp.IsError(), "stale cas must fail")
+	require.Equal(t, 2, readCASVersion(t, b, storage, "config"))
+
+	// Writes without cas are unconditional
+	data := config(0)
+	delete(data, "cas")
+	require.Nil(t, casRequest(t, b, storage, "config", data))
+	require.Equal(t, 3, readCASVersion(t, b, storage, "config"))
+}
+
+func TestCAS_Role(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+
+	resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"cas": 0})
+	require.Nil(t, resp)
+	require.Equal(t, 1, readCASVersion(t, b, storage, "role/test-role"))
+
+	resp = writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"cas": 0})
+	require.True(t, resp.IsError(), "cas 0 must fail once the role exists")
+
+	require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"cas": 1, "ttl": "2h"}))
+	require.Equal(t, 2, readCASVersion(t, b, storage, "role/test-role"))
+
+	resp = writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"cas": 1, "ttl": "3h"})
+	require.True(t, resp.IsError(), "stale cas must fail")
+	require.Contains(t, resp.Error().Error(), "check-and-set")
+
+	role, err := b.getRole(context.Background(), storage, "test-role")
+	require.NoError(t, err)
+	require.Equal(t, "2h0m0s", role.TTL.String())
+}
+
+func TestCAS_Key(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp := casRequest(t, b, storage, "key/test-key", map[string]any{"cas": 0})
+	require.False(t, resp.IsError(), "create failed: %v", resp.Error())
+	require.Equal(t, 1, resp.Data["cas_version"])
+	require.Equal(t, 1, readCASVersion(t, b, storage, "key/test-key"))
+
+	t.Run("rotate", func(t *testing.T) {
+		resp := casRequest(t, b, storage, "key/test-key/rotate", map[string]any{"cas": 5})
+		require.True(t, resp.IsError(), "stale cas must fail")
+		require.Contains(t, resp.Error().Error(), "check-and-set")
+
+		resp = casRequest(t, b, storage, "key/test-key/rotate", map[string]any{"cas": 1})
+		require.False(t, resp.IsError(), "rotate failed: %v", resp.Error())
+		require.Equal(t, 2, resp.Data["version"])
+		require.Equal(t, 2, resp.Data["cas_version"])
+	})
+
+	t.Run("certificate", func(t *testing.T) {
+		resp := writeKeyCertificate(t, b, storage, "test-key", map[string]any{"generate": true, "cas": 1})
+		require.True(t, resp.IsError(), "stale cas must fail")
+
+		resp = writeKeyCertificate(t, b, storage, "test-key", map[string]any{"generate": true, "cas": 2})
+		require.False(t, resp != nil && resp.IsError(), "certificate write failed: %v", resp)
+		require.Equal(t, 3, readCASVersion(t, b, storage, "key/test-key"))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "key/test-key/certificate",
+			Storage:   storage,
+			Data:      map[string]any{"cas": 2},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "stale cas must fail")
+
+		key, err := b.getKey(context.Background(), storage, "test-key")
+		require.NoError(t, err)
+		require.NotEmpty(t, key.Certificate)
+	})
+}
+
+func TestCAS_LegacyEntriesAreVersionOne(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+
+	// Entries written before cas versions were tracked have no cas_version
+	key, err := b.getKey(context.Background(), storage, "test-key")
+	require.NoError(t, err)
+	key.CASVersion = 0
+	entry, err := logical.StorageEntryJSON(keyStoragePrefix+key.Name, key)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(context.Background(), entry))
+	b.resetKeyCache(key.Name)
+
+	require.Equal(t, 1, readCASVersion(t, b, storage, "key/test-key"))
+	resp := casRequest(t, b, storage, "key/test-key/rotate", map[string]any{"cas": 1})
+	require.False(t, resp.IsError(), "rotate failed: %v", resp.Error())
+	require.Equal(t, 2, resp.Data["cas_version"])
+}
+
+// TestIdempotentWrites tests that rewriting an entry unchanged keeps its
+// cas_version, and that reads return values that can be written back
+func TestIdempotentWrites(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	t.Run("config", func(t *testing.T) {
+		config := map[string]any{
+			"issuer":                 "https://vault.example.com",
+			"subject_jwks_uri":       "https://idp.example.com/.well-known/jwks.json",
+			"default_ttl":            "2h",
+			"globally_denied_scopes": []string{"urn:b", "urn:a"},
+		}
+		require.Nil(t, casRequest(t, b, storage, "config", config))
+		require.Nil(t, casRequest(t, b, storage, "config", config))
+		require.Equal(t, 1, readCASVersion(t, b, storage, "config"))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+		require.NoError(t, err)
+		require.EqualValues(t, 7200, resp.Data["default_ttl"])
+		require.Equal(t, []string{"urn:a", "urn:b"}, resp.Data["globally_denied_scopes"])
+
+		// The read seconds are accepted as they are
+		config["default_ttl"] = resp.Data["default_ttl"]
+		require.Nil(t, casRequest(t, b, storage, "config", config))
+		require.Equal(t, 1, readCASVersion(t, b, storage, "config"))
+	})
+
+	t.Run("key", func(t *testing.T) {
+		data := map[string]any{"algorithm": "RS256", "rotation_period": "24h"}
+		resp := casRequest(t, b, storage, "key/test-key", data)
+		require.False(t, resp.IsError(), "create failed: %v", resp.Error())
+		keyID := resp.Data["key_id"]
+
+		resp = casRequest(t, b, storage, "key/test-key", data)
+		require.False(t, resp.IsError(), "repeated create failed: %v", resp.Error())
+		require.Equal(t, keyID, resp.Data["key_id"])
+		require.Equal(t, 1, readCASVersion(t, b, storage, "key/test-key"))
+
+		resp = casRequest(t, b, storage, "key/test-key", map[string]any{"algorithm": "PS256"})
+		require.True(t, resp.IsError(), "a create with other parameters must fail")
+		require.Contains(t, resp.Error().Error(), "already exists")
+	})
+
+	t.Run("role", func(t *testing.T) {
+		extra := map[string]any{"bound_audiences": []string{"svc-b", "svc-a"}}
+		require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, extra))
+		require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, extra))
+		require.Equal(t, 1, readCASVersion(t, b, storage, "role/test-role"))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+		require.NoError(t, err)
+		require.EqualValues(t, 3600, resp.Data["ttl"])
+		require.Equal(t, []string{"svc-a", "svc-b"}, resp.Data["bound_audiences"])
+
+		extra["ttl"] = "2h"
+		require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, extra))
+		require.Equal(t, 2, readCASVersion(t, b, storage, "role/test-role"))
+	})
+}
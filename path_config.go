@@ -12,11 +12,173 @@ type Config struct {
 	// Issuer is the JWT issuer claim (iss) for generated tokens
 	Issuer string `json:"issuer"`
 
+	// APIAddr is the externally reachable address of Vault, used to build
+	// this mount's JWKS URL for verification hints
+	APIAddr string `json:"api_addr,omitempty"`
+
+	// APICAPEM is a PEM bundle of CAs trusted when calling Vault's API
+	// through APIAddr
+	APICAPEM string `json:"api_ca_pem,omitempty"`
+
 	// DefaultTTL is the default time-to-live for generated tokens
 	DefaultTTL time.Duration `json:"default_ttl"`
 
 	// SubjectJWKSURI is the URI for the JWKS used to validate subject tokens
 	SubjectJWKSURI string `json:"subject_jwks_uri"`
+
+	// SubjectJWKS is an inline JWKS document used instead of SubjectJWKSURI
+	SubjectJWKS string `json:"subject_jwks,omitempty"`
+
+	// SubjectPublicKeys are PEM public keys used instead of SubjectJWKSURI
+	SubjectPublicKeys []string `json:"subject_public_keys,omitempty"`
+
+	// SubjectOIDCIssuer is an issuer whose discovery document supplies the
+	// subject JWKS URI, used instead of SubjectJWKSURI
+	SubjectOIDCIssuer string `json:"subject_oidc_issuer,omitempty"`
+
+	// JWKSCAPEM is a PEM bundle of CAs trusted when fetching SubjectJWKSURI
+	JWKSCAPEM string `json:"jwks_ca_pem,omitempty"`
+
+	// JWKSClientCert and JWKSClientKey authenticate JWKS fetches with mTLS
+	JWKSClientCert string `json:"jwks_client_cert,omitempty"`
+	JWKSClientKey  string `json:"jwks_client_key,omitempty"`
+
+	// JWKSProxyURL routes JWKS fetches through a proxy
+	JWKSProxyURL string `json:"jwks_proxy_url,omitempty"`
+
+	// JWKSFetchTimeout bounds a JWKS fetch. Zero uses the default.
+	JWKSFetchTimeout time.Duration `json:"jwks_fetch_timeout,omitempty"`
+
+	// JWKSMaxRetries is how many times a failed JWKS fetch is retried
+	JWKSMaxRetries int `json:"jwks_max_retries"`
+
+	// JWKSTLSSkipVerify disables TLS verification of the JWKS endpoint
+	JWKSTLSSkipVerify bool `json:"jwks_tls_skip_verify,omitempty"`
+
+	// AllowedSubjectTokenAlgorithms lists the JWS algorithms accepted on subject
+	// tokens. Empty means RS256 only.
+	AllowedSubjectTokenAlgorithms []string `json:"allowed_subject_token_algorithms,omitempty"`
+
+	// IntrospectionURL is the RFC 7662 endpoint that validates opaque access
+	// token subject tokens
+	IntrospectionURL string `json:"introspection_url,omitempty"`
+
+	// IntrospectionClientID and IntrospectionClientSecret authenticate Vault
+	// to the introspection endpoint using HTTP Basic authentication
+	IntrospectionClientID     string `json:"introspection_client_id,omitempty"`
+	IntrospectionClientSecret string `json:"introspection_client_secret,omitempty"`
+
+	// SAMLIDPMetadata is the SAML metadata of the IdP whose assertions may be
+	// exchanged. Its signing certificates and entity ID are trusted.
+	SAMLIDPMetadata string `json:"saml_idp_metadata,omitempty"`
+
+	// SAMLIDPCertificates are PEM certificates trusted to sign SAML assertions
+	SAMLIDPCertificates []string `json:"saml_idp_certificates,omitempty"`
+
+	// SubjectDecryptionKey is the PEM private key that decrypts JWE subject
+	// tokens
+	SubjectDecryptionKey string `json:"subject_decryption_key,omitempty"`
+
+	// SubjectDecryptionTransitKey is the <mount>/<name> of a transit key that
+	// decrypts JWE subject tokens instead of SubjectDecryptionKey
+	SubjectDecryptionTransitKey string `json:"subject_decryption_transit_key,omitempty"`
+
+	// TransitToken is the Vault token used to sign with and rotate transit
+	// keys through api_addr
+	TransitToken string `json:"transit_token,omitempty"`
+
+	// TokenLookupToken is the Vault token used to look up the tokens of
+	// callers through api_addr, for actor templates using identity.token
+	TokenLookupToken string `json:"token_lookup_token,omitempty"`
+
+	// TokenLookupEnabled opts in to looking up callers' tokens through
+	// api_addr when Vault does not pass them with the request
+	TokenLookupEnabled bool `json:"token_lookup_enabled,omitempty"`
+
+	// DefaultKey names the key used by roles that do not set one
+	DefaultKey string `json:"default_key,omitempty"`
+
+	// RoleDefaults are used by roles that omit the corresponding parameters
+	RoleDefaults RoleDefaults `json:"role_defaults,omitzero"`
+
+	// EntityRoleMetadataKey is the entity metadata key naming the role of
+	// token/by-entity exchanges. token/by-entity is disabled when it is empty.
+	EntityRoleMetadataKey string `json:"entity_role_metadata_key,omitempty"`
+
+	// LegacySigningKey is the PEM signing key of configs written before named
+	// keys existed. It is migrated to a named key on startup and never written.
+	LegacySigningKey string `json:"signing_key,omitempty"`
+
+	// LegacyDelegateJWKSURI is the subject_jwks_uri of configs written before
+	// it was renamed. It is moved to SubjectJWKSURI when read and never written.
+	LegacyDelegateJWKSURI string `json:"delegate_jwks_uri,omitempty"`
+
+	// AuditKey names the key that signs exported issuance records. Issuance is
+	// recorded only while it is set.
+	AuditKey string `json:"audit_key,omitempty"`
+
+	// RecordRetention is how long stored records are kept. Zero uses the default.
+	RecordRetention time.Duration `json:"record_retention,omitempty"`
+
+	// RecordRetentionOverrides sets the retention of individual record types
+	RecordRetentionOverrides map[string]time.Duration `json:"record_retention_overrides,omitempty"`
+
+	// IssuanceRetention is the deprecated retention of issuance records. Config
+	// writes move it to RecordRetentionOverrides.
+	IssuanceRetention time.Duration `json:"issuance_retention,omitempty"`
+
+	// IssuanceLog enables the queryable issuance log
+	IssuanceLog bool `json:"issuance_log,omitempty"`
+
+	// IssuanceLogMaxEntries bounds the issuance log. Zero uses the default.
+	IssuanceLogMaxEntries int `json:"issuance_log_max_entries,omitempty"`
+
+	// GloballyDeniedScopes are scope patterns no role may issue
+	GloballyDeniedScopes []string `json:"globally_denied_scopes,omitempty"`
+
+	// DefaultVerificationTTL is how long rotated versions of keys without a
+	// verification_ttl stay in the JWKS. Zero uses the default.
+	DefaultVerificationTTL time.Duration `json:"default_verification_ttl,omitempty"`
+
+	// MaxVerificationTTL caps the verification TTL of every key. Zero is
+	// unbounded.
+	MaxVerificationTTL time.Duration `json:"max_verification_ttl,omitempty"`
+
+	// TidySafetyBuffer is how long expired replay records and key versions are
+	// kept before tidy deletes them. Zero uses the default.
+	TidySafetyBuffer time.Duration `json:"tidy_safety_buffer,omitempty"`
+
+	// TidyInterval is how often the periodic function tidies. Zero uses the
+	// default.
+	TidyInterval time.Duration `json:"tidy_interval,omitempty"`
+
+	// AuthorizationWebhookURL is called to authorize every exchange
+	AuthorizationWebhookURL string `json:"authorization_webhook_url,omitempty"`
+
+	// AuthorizationWebhookToken is sent to the webhook as a bearer token
+	AuthorizationWebhookToken string `json:"authorization_webhook_token,omitempty"`
+
+	// AuthorizationWebhookTimeout bounds a webhook call. Zero uses the default.
+	AuthorizationWebhookTimeout time.Duration `json:"authorization_webhook_timeout,omitempty"`
+
+	// AuthorizationWebhookCAPEM is a PEM bundle of CAs trusted when calling
+	// AuthorizationWebhookURL
+	AuthorizationWebhookCAPEM string `json:"authorization_webhook_ca_pem,omitempty"`
+
+	// EventWebhookURL receives token issuance and key events
+	EventWebhookURL string `json:"event_webhook_url,omitempty"`
+
+	// EventWebhookToken is sent to the event webhook as a bearer token
+	EventWebhookToken string `json:"event_webhook_token,omitempty"`
+
+	// ClaimNamespace is prefixed to the custom claims of issued tokens
+	ClaimNamespace string `json:"claim_namespace,omitempty"`
+
+	// MaxExchangesPerMinute limits exchanges per entity across all roles. Zero is unlimited.
+	MaxExchangesPerMinute int `json:"max_exchanges_per_minute,omitempty"`
+
+	// CASVersion is incremented on every write, for check-and-set writes
+	CASVersion int `json:"cas_version,omitempty"`
 }
 
 // Storage key for configuration
@@ -35,6 +197,49 @@ func pathConfig(b *Backend) *framework.Path {
 				Description: "The issuer (iss) claim for generated tokens",
 				Required:    true,
 			},
+			"api_addr": {
+				Type:        framework.TypeString,
+				Description: "Externally reachable address of Vault (e.g. https://vault.example.com:8200). Roles with a verification_hint point consumers at this mount's JWKS under it. vault_token subject tokens are looked up through it rather than through the SystemView, which cannot look up tokens",
+			},
+			"api_ca_pem": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA certificates trusted when looking up Vault tokens and calling transit through api_addr. Defaults to the system roots",
+			},
+			"authorization_webhook_url": {
+				Type:        framework.TypeString,
+				Description: "HTTP endpoint, such as an OPA policy, called with the subject claims, entity, role and scopes before every exchange. The exchange is denied unless it responds 200 with allow or result true",
+			},
+			"authorization_webhook_token": {
+				Type:        framework.TypeString,
+				Description: "Bearer token sent to authorization_webhook_url. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"authorization_webhook_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout for each authorization webhook call",
+				Default:     "5s",
+			},
+			"authorization_webhook_ca_pem": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA certificates trusted when calling authorization_webhook_url. Defaults to the system roots",
+			},
+			"event_webhook_url": {
+				Type:        framework.TypeString,
+				Description: "HTTP endpoint, such as a SIEM collector, that receives an event for every issued token, key rotation and key deletion",
+			},
+			"event_webhook_token": {
+				Type:        framework.TypeString,
+				Description: "Bearer token sent to event_webhook_url. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"claim_namespace": {
+				Type:        framework.TypeString,
+				Description: "URI prefixed to the custom claims of issued tokens, such as actor_metadata and subject_claims, e.g. https://example.com/claims/. Roles can override it. Empty leaves claims unchanged",
+			},
 			"default_ttl": {
 				Type:        framework.TypeDurationSecond,
 				Description: "Default TTL for generated tokens (e.g., '24h', '1h')",
@@ -42,8 +247,194 @@ func pathConfig(b *Backend) *framework.Path {
 			},
 			"subject_jwks_uri": {
 				Type:        framework.TypeString,
-				Description: "The URI for the JWKS used to validate subject tokens",
-				Required:    true,
+				Description: "The URI for the JWKS used to validate subject tokens. Not needed when subject_jwks or subject_public_keys is set",
+			},
+			"subject_jwks": {
+				Type:        framework.TypeString,
+				Description: "Inline JWKS document used to validate subject tokens without a network fetch, for environments that cannot reach the issuer. Cannot be combined with subject_jwks_uri",
+			},
+			"subject_public_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "PEM-encoded RSA, ECDSA or Ed25519 public keys or certificates used to validate subject tokens without a network fetch. Cannot be combined with subject_jwks_uri",
+			},
+			"subject_oidc_issuer": {
+				Type:        framework.TypeString,
+				Description: "OpenID Connect issuer whose discovery document at <issuer>/.well-known/openid-configuration supplies the JWKS used to validate subject tokens, which must carry it as iss. Cannot be combined with subject_jwks_uri, subject_jwks or subject_public_keys",
+			},
+			"jwks_ca_pem": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA certificates trusted when fetching subject_jwks_uri. Defaults to the system roots",
+			},
+			"jwks_client_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded client certificate presented to the JWKS endpoint for mTLS",
+			},
+			"jwks_client_key": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded private key for jwks_client_cert",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"jwks_proxy_url": {
+				Type:        framework.TypeString,
+				Description: "Proxy URL (http, https or socks5) for JWKS fetches. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables",
+			},
+			"jwks_fetch_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout for each JWKS fetch attempt",
+				Default:     "10s",
+			},
+			"jwks_max_retries": {
+				Type:        framework.TypeInt,
+				Description: "How many times a JWKS fetch is retried, with exponential backoff, after a network error, HTTP 429 or 5xx response",
+				Default:     2,
+			},
+			"jwks_tls_skip_verify": {
+				Type:        framework.TypeBool,
+				Description: "Skip TLS verification of the JWKS endpoint. For development only",
+				Default:     false,
+			},
+			"allowed_subject_token_algorithms": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated JWS algorithms accepted on subject tokens: RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA. Defaults to RS256",
+			},
+			"introspection_url": {
+				Type:        framework.TypeString,
+				Description: "RFC 7662 token introspection endpoint used to validate subject tokens exchanged with subject_token_type urn:ietf:params:oauth:token-type:access_token",
+			},
+			"introspection_client_id": {
+				Type:        framework.TypeString,
+				Description: "Client ID Vault authenticates to the introspection endpoint with",
+			},
+			"introspection_client_secret": {
+				Type:        framework.TypeString,
+				Description: "Client secret for introspection_client_id. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"saml_idp_metadata": {
+				Type:        framework.TypeString,
+				Description: "SAML 2.0 metadata (EntityDescriptor XML) of the IdP whose assertions are exchanged with subject_token_type urn:ietf:params:oauth:token-type:saml2. Its signing certificates are trusted and its entityID must issue the assertions",
+			},
+			"saml_idp_certificates": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "PEM-encoded certificates trusted to sign SAML assertions, in addition to those in saml_idp_metadata",
+			},
+			"subject_decryption_key": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded RSA or EC private key that decrypts JWE subject tokens encrypted for this mount. Never returned on read; its public key is",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"subject_decryption_transit_key": {
+				Type:        framework.TypeString,
+				Description: "Transit key, as <transit mount>/<key name>, that decrypts JWE subject tokens encrypted with RSA-OAEP-256 instead of subject_decryption_key. Requests go to api_addr with transit_token",
+			},
+			"transit_token": {
+				Type:        framework.TypeString,
+				Description: "Vault token used to read, sign with and rotate the transit keys backing keys with key_type transit. Requests go to api_addr. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"token_lookup_token": {
+				Type:        framework.TypeString,
+				Description: "Vault token, permitted to update auth/token/lookup-accessor, used to look up the policies, path, type and meta of callers' tokens for actor templates that use them when token_lookup_enabled is set. Requests go to api_addr. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"token_lookup_enabled": {
+				Type:        framework.TypeBool,
+				Description: "Look up callers' tokens through api_addr with token_lookup_token when Vault does not pass the token entry with the request. Requires api_addr and token_lookup_token",
+			},
+			"default_key": {
+				Type:        framework.TypeString,
+				Description: "Name of the key used by roles that do not set key",
+			},
+			"default_actor_template": {
+				Type:        framework.TypeString,
+				Description: "Actor template of roles that do not set actor_template",
+			},
+			"default_context": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated scopes permitted to roles that do not set context",
+			},
+			"default_bound_issuer": {
+				Type:        framework.TypeString,
+				Description: "Subject token issuer required by roles that do not set bound_issuer",
+			},
+			"entity_role_metadata_key": {
+				Type:        framework.TypeString,
+				Description: "Entity metadata key, such as delegation_role, naming the role that token/by-entity exchanges with for the requesting entity. token/by-entity is disabled when unset",
+			},
+			"signing_key": {
+				Type:        framework.TypeString,
+				Description: "Deprecated: use default_key. A PEM-encoded RSA private key, imported as the RS256 key named by default_key (or \"default\") and set as default_key",
+				Deprecated:  true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"audit_key": {
+				Type:        framework.TypeString,
+				Description: "Name of a key, used by no role, that signs exported issuance records. Issued tokens are recorded only while it is set",
+			},
+			"record_retention": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long stored records, such as issuance records, are kept before the periodic tidy deletes them",
+				Default:     "2160h",
+			},
+			"record_retention_overrides": {
+				Type:        framework.TypeKVPairs,
+				Description: "Retention of individual record types, overriding record_retention, e.g. issuance=720h. Supported types: issuance, issuance_log",
+			},
+			"issuance_retention": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Deprecated: use record_retention_overrides with issuance=<duration>",
+				Deprecated:  true,
+			},
+			"issuance_log": {
+				Type:        framework.TypeBool,
+				Description: "Record every issued token in the issuance log, which can be queried with LIST issuances/ and read with issuance/<jti>",
+			},
+			"issuance_log_max_entries": {
+				Type:        framework.TypeInt,
+				Description: "Most entries kept in the issuance log. The periodic tidy deletes the oldest entries beyond it",
+				Default:     defaultIssuanceLogMaxEntries,
+			},
+			"cas": casField("config"),
+			"globally_denied_scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated scopes that are never issued, whatever the role's context. * matches any characters, e.g. admin:*. Roles issuing a denied scope cannot be written, and their exchanges are denied",
+			},
+			"default_verification_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long rotated versions of keys without their own verification_ttl remain in the JWKS",
+				Default:     "24h",
+			},
+			"max_verification_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Upper bound on the verification_ttl of every key, including keys created before it was set. 0 is unbounded",
+				Default:     0,
+			},
+			"tidy_safety_buffer": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long after expiry replay records, reference and refresh tokens and rotated key versions are kept before tidy deletes them, to allow for clock skew between nodes",
+				Default:     "72h",
+			},
+			"tidy_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the periodic function tidies expired state. The time of the last tidy is stored, so the interval holds across nodes and restarts",
+				Default:     "12h",
+			},
+			"max_exchanges_per_minute": {
+				Type:        framework.TypeInt,
+				Description: "Maximum exchanges per minute for each Vault entity across all roles. 0 is unlimited",
+				Default:     0,
 			},
 		},
 
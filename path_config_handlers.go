@@ -2,9 +2,14 @@ package tokenexchange
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -32,60 +37,617 @@ func (b *Backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 
 	return &logical.Response{
 		Data: map[string]any{
-			"issuer":           config.Issuer,
-			"default_ttl":      config.DefaultTTL.String(),
-			"subject_jwks_uri": config.SubjectJWKSURI,
+			"issuer":                                 config.Issuer,
+			"api_addr":                               config.APIAddr,
+			"api_ca_pem":                             config.APICAPEM,
+			"claim_namespace":                        config.ClaimNamespace,
+			"authorization_webhook_url":              config.AuthorizationWebhookURL,
+			"authorization_webhook_token_configured": config.AuthorizationWebhookToken != "",
+			"authorization_webhook_timeout":          durationSeconds(config.authorizationWebhookTimeout()),
+			"authorization_webhook_timeout_human":    config.authorizationWebhookTimeout().String(),
+			"authorization_webhook_ca_pem":           config.AuthorizationWebhookCAPEM,
+			"event_webhook_url":                      config.EventWebhookURL,
+			"event_webhook_token_configured":         config.EventWebhookToken != "",
+			"default_ttl":                            durationSeconds(config.DefaultTTL),
+			"default_ttl_human":                      config.DefaultTTL.String(),
+			"subject_jwks_uri":                       config.SubjectJWKSURI,
+			"subject_jwks":                           config.SubjectJWKS,
+			"subject_public_keys":                    config.SubjectPublicKeys,
+			"subject_oidc_issuer":                    config.SubjectOIDCIssuer,
+			"max_exchanges_per_minute":               config.MaxExchangesPerMinute,
+			"allowed_subject_token_algorithms":       config.subjectTokenAlgorithmNames(),
+			"jwks_ca_pem":                            config.JWKSCAPEM,
+			"jwks_client_cert":                       config.JWKSClientCert,
+			"jwks_client_key_configured":             config.JWKSClientKey != "",
+			"jwks_client_key_fingerprint":            config.jwksClientKeyFingerprint(),
+			"jwks_proxy_url":                         config.JWKSProxyURL,
+			"jwks_max_retries":                       config.JWKSMaxRetries,
+			"jwks_fetch_timeout":                     durationSeconds(config.jwksFetchTimeout()),
+			"jwks_fetch_timeout_human":               config.jwksFetchTimeout().String(),
+			"jwks_tls_skip_verify":                   config.JWKSTLSSkipVerify,
+			"introspection_url":                      config.IntrospectionURL,
+			"introspection_client_id":                config.IntrospectionClientID,
+			"introspection_client_secret_configured": config.IntrospectionClientSecret != "",
+			"saml_idp_metadata":                      config.SAMLIDPMetadata,
+			"saml_idp_certificates":                  config.SAMLIDPCertificates,
+			"subject_decryption_key_configured":      config.SubjectDecryptionKey != "",
+			"subject_decryption_public_key":          config.subjectDecryptionPublicKey(),
+			"subject_decryption_transit_key":         config.SubjectDecryptionTransitKey,
+			"default_key":                            config.DefaultKey,
+			"default_actor_template":                 config.RoleDefaults.ActorTemplate,
+			"default_context":                        sortedStrings(config.RoleDefaults.Context),
+			"default_bound_issuer":                   config.RoleDefaults.BoundIssuer,
+			"entity_role_metadata_key":               config.EntityRoleMetadataKey,
+			"audit_key":                              config.AuditKey,
+			"issuance_log":                           config.IssuanceLog,
+			"issuance_log_max_entries":               config.issuanceLogMaxEntries(),
+			"record_retention":                       durationSeconds(config.baseRecordRetention()),
+			"record_retention_human":                 config.baseRecordRetention().String(),
+			"record_retention_overrides":             recordRetentionOverrideStrings(config.RecordRetentionOverrides),
+			"globally_denied_scopes":                 sortedStrings(config.GloballyDeniedScopes),
+			"default_verification_ttl":               durationSeconds(config.defaultVerificationTTL()),
+			"default_verification_ttl_human":         config.defaultVerificationTTL().String(),
+			"max_verification_ttl":                   durationSeconds(config.MaxVerificationTTL),
+			"max_verification_ttl_human":             config.MaxVerificationTTL.String(),
+			"tidy_safety_buffer":                     durationSeconds(config.tidySafetyBuffer()),
+			"tidy_safety_buffer_human":               config.tidySafetyBuffer().String(),
+			"tidy_interval":                          durationSeconds(config.tidyInterval()),
+			"tidy_interval_human":                    config.tidyInterval().String(),
+			"transit_token_configured":               config.TransitToken != "",
+			"token_lookup_token_configured":          config.TokenLookupToken != "",
+			"token_lookup_enabled":                   config.TokenLookupEnabled,
+			"cas_version":                            config.CASVersion,
+			// Note: jwks_client_key is NEVER returned, only its public key fingerprint,
+			// subject_decryption_key only its public key,
+			// and introspection_client_secret, transit_token, token_lookup_token,
+			// authorization_webhook_token and event_webhook_token are NEVER returned
 		},
 	}, nil
 }
 
-// pathConfigWrite handles writing the configuration
+// pathConfigWrite handles writing the configuration. Updating an existing
+// configuration only changes the fields in the request, so secrets such as
+// jwks_client_key, introspection_client_secret and transit_token that are never
+// returned survive a rewrite.
 func (b *Backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	existing, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	currentVersion := 0
+	if existing != nil {
+		currentVersion = existing.CASVersion
+	}
+	if err := checkCAS(requestCAS(data), currentVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The update below changes existing in place, so compare against a copy
 	config := &Config{}
+	var before json.RawMessage
+	if existing != nil {
+		config = existing
+		if before, err = json.Marshal(existing); err != nil {
+			return nil, fmt.Errorf("failed to encode configuration: %w", err)
+		}
+	}
+
+	// isSet reports whether a field should be written: it is in the request,
+	// or the config is new and the field's default applies
+	isSet := func(name string) bool {
+		_, ok := data.GetOk(name)
+		return ok || existing == nil
+	}
 
 	// Get issuer (required)
-	issuer, ok := data.GetOk("issuer")
-	if !ok {
+	if issuer, ok := data.GetOk("issuer"); ok {
+		config.Issuer = issuer.(string)
+	}
+	if config.Issuer == "" {
 		return logical.ErrorResponse("issuer is required"), nil
 	}
-	config.Issuer = issuer.(string)
+
+	// Get the Vault address (optional)
+	if isSet("api_addr") {
+		config.APIAddr = strings.TrimSuffix(data.Get("api_addr").(string), "/")
+		if config.APIAddr != "" {
+			parsed, err := url.Parse(config.APIAddr)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+				return logical.ErrorResponse("api_addr must be an http or https URL without a query or fragment"), nil
+			}
+		}
+	}
+	if isSet("api_ca_pem") {
+		config.APICAPEM = data.Get("api_ca_pem").(string)
+	}
+	if _, err := config.vaultAPIHTTPClient(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get the custom claim namespace (optional)
+	if isSet("claim_namespace") {
+		config.ClaimNamespace = data.Get("claim_namespace").(string)
+		if err := validateClaimNamespace(config.ClaimNamespace); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
 
 	// Get default TTL (optional, has default)
-	if ttl, ok := data.GetOk("default_ttl"); ok {
-		config.DefaultTTL = time.Duration(ttl.(int)) * time.Second
-	} else {
-		config.DefaultTTL = 24 * time.Hour // Default
+	if isSet("default_ttl") {
+		config.DefaultTTL = time.Duration(data.Get("default_ttl").(int)) * time.Second
+	}
+
+	// Get the subject key source. Setting one source replaces the others.
+	_, uriOk := data.GetOk("subject_jwks_uri")
+	_, jwksOk := data.GetOk("subject_jwks")
+	_, publicKeysOk := data.GetOk("subject_public_keys")
+	_, oidcIssuerOk := data.GetOk("subject_oidc_issuer")
+	if uriOk || jwksOk || publicKeysOk || oidcIssuerOk {
+		config.SubjectJWKSURI = data.Get("subject_jwks_uri").(string)
+		config.SubjectJWKS = data.Get("subject_jwks").(string)
+		config.SubjectPublicKeys = data.Get("subject_public_keys").([]string)
+		config.SubjectOIDCIssuer = data.Get("subject_oidc_issuer").(string)
+	}
+	if config.hasStaticSubjectKeys() {
+		if config.SubjectJWKSURI != "" {
+			return logical.ErrorResponse("subject_jwks_uri cannot be combined with subject_jwks or subject_public_keys"), nil
+		}
+		if _, err := config.staticSubjectKeySet(); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if config.SubjectOIDCIssuer != "" {
+		if config.SubjectJWKSURI != "" || config.hasStaticSubjectKeys() {
+			return logical.ErrorResponse("subject_oidc_issuer cannot be combined with subject_jwks_uri, subject_jwks or subject_public_keys"), nil
+		}
+		if issuerURL, err := url.Parse(config.SubjectOIDCIssuer); err != nil || (issuerURL.Scheme != "https" && issuerURL.Scheme != "http") || issuerURL.Host == "" {
+			return logical.ErrorResponse("subject_oidc_issuer must be an http or https URL"), nil
+		}
+	}
+
+	// Get JWKS fetch settings (optional)
+	if isSet("jwks_ca_pem") {
+		config.JWKSCAPEM = data.Get("jwks_ca_pem").(string)
+	}
+	if isSet("jwks_client_cert") {
+		config.JWKSClientCert = data.Get("jwks_client_cert").(string)
+	}
+	if isSet("jwks_client_key") {
+		config.JWKSClientKey = data.Get("jwks_client_key").(string)
+	}
+	if isSet("jwks_proxy_url") {
+		config.JWKSProxyURL = data.Get("jwks_proxy_url").(string)
+	}
+	if isSet("jwks_fetch_timeout") {
+		config.JWKSFetchTimeout = time.Duration(data.Get("jwks_fetch_timeout").(int)) * time.Second
+		if config.JWKSFetchTimeout <= 0 {
+			return logical.ErrorResponse("jwks_fetch_timeout must be positive"), nil
+		}
+	}
+	if isSet("jwks_max_retries") {
+		config.JWKSMaxRetries = data.Get("jwks_max_retries").(int)
+		if config.JWKSMaxRetries < 0 || config.JWKSMaxRetries > maxJWKSRetries {
+			return logical.ErrorResponse("jwks_max_retries must be between 0 and %d", maxJWKSRetries), nil
+		}
+	}
+	if isSet("jwks_tls_skip_verify") {
+		config.JWKSTLSSkipVerify = data.Get("jwks_tls_skip_verify").(bool)
+	}
+	if _, err := config.jwksHTTPClient(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get allowed subject token algorithms (optional)
+	if isSet("allowed_subject_token_algorithms") {
+		algorithms := data.Get("allowed_subject_token_algorithms").([]string)
+		for _, algorithm := range algorithms {
+			if !slices.Contains(supportedSubjectTokenAlgorithms, jose.SignatureAlgorithm(algorithm)) {
+				return logical.ErrorResponse("unsupported subject token algorithm %q", algorithm), nil
+			}
+		}
+		config.AllowedSubjectTokenAlgorithms = algorithms
+	}
+
+	// Get token introspection settings (optional)
+	if isSet("introspection_url") {
+		config.IntrospectionURL = data.Get("introspection_url").(string)
+		if config.IntrospectionURL != "" {
+			parsed, err := url.Parse(config.IntrospectionURL)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return logical.ErrorResponse("introspection_url must be an http or https URL"), nil
+			}
+		}
+	}
+	if isSet("introspection_client_id") {
+		config.IntrospectionClientID = data.Get("introspection_client_id").(string)
+	}
+	if isSet("introspection_client_secret") {
+		config.IntrospectionClientSecret = data.Get("introspection_client_secret").(string)
+	}
+	if config.IntrospectionClientSecret != "" && config.IntrospectionClientID == "" {
+		return logical.ErrorResponse("introspection_client_secret requires introspection_client_id"), nil
+	}
+
+	// Get the authorization webhook (optional)
+	if isSet("authorization_webhook_url") {
+		config.AuthorizationWebhookURL = data.Get("authorization_webhook_url").(string)
+		if config.AuthorizationWebhookURL != "" {
+			parsed, err := url.Parse(config.AuthorizationWebhookURL)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return logical.ErrorResponse("authorization_webhook_url must be an http or https URL"), nil
+			}
+		}
+	}
+	if isSet("authorization_webhook_token") {
+		config.AuthorizationWebhookToken = data.Get("authorization_webhook_token").(string)
+	}
+	if isSet("authorization_webhook_timeout") {
+		config.AuthorizationWebhookTimeout = time.Duration(data.Get("authorization_webhook_timeout").(int)) * time.Second
+		if config.AuthorizationWebhookTimeout <= 0 {
+			return logical.ErrorResponse("authorization_webhook_timeout must be positive"), nil
+		}
+	}
+	if isSet("authorization_webhook_ca_pem") {
+		config.AuthorizationWebhookCAPEM = data.Get("authorization_webhook_ca_pem").(string)
+	}
+	if _, err := config.authorizationWebhookHTTPClient(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get the event webhook (optional)
+	if isSet("event_webhook_url") {
+		config.EventWebhookURL = data.Get("event_webhook_url").(string)
+		if config.EventWebhookURL != "" {
+			parsed, err := url.Parse(config.EventWebhookURL)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return logical.ErrorResponse("event_webhook_url must be an http or https URL"), nil
+			}
+		}
+	}
+	if isSet("event_webhook_token") {
+		config.EventWebhookToken = data.Get("event_webhook_token").(string)
+	}
+
+	// Get the subject token decryption key (optional)
+	if isSet("subject_decryption_key") {
+		config.SubjectDecryptionKey = data.Get("subject_decryption_key").(string)
+		if config.SubjectDecryptionKey != "" {
+			if _, err := parseSubjectDecryptionKey(config.SubjectDecryptionKey); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+	if isSet("subject_decryption_transit_key") {
+		config.SubjectDecryptionTransitKey = data.Get("subject_decryption_transit_key").(string)
+		if config.SubjectDecryptionTransitKey != "" {
+			if _, _, err := parseTransitKeyRef(config.SubjectDecryptionTransitKey); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+	if config.SubjectDecryptionKey != "" && config.SubjectDecryptionTransitKey != "" {
+		return logical.ErrorResponse("subject_decryption_key cannot be combined with subject_decryption_transit_key"), nil
+	}
+
+	// Get the transit token (optional)
+	if isSet("transit_token") {
+		config.TransitToken = data.Get("transit_token").(string)
+	}
+
+	// Get the token used to look up callers' tokens (optional)
+	if isSet("token_lookup_token") {
+		config.TokenLookupToken = data.Get("token_lookup_token").(string)
+	}
+	if isSet("token_lookup_enabled") {
+		config.TokenLookupEnabled = data.Get("token_lookup_enabled").(bool)
+	}
+	if config.TokenLookupEnabled && (config.APIAddr == "" || config.TokenLookupToken == "") {
+		return logical.ErrorResponse("token_lookup_enabled requires api_addr and token_lookup_token"), nil
+	}
+
+	// Get the SAML IdP (optional)
+	if isSet("saml_idp_metadata") {
+		config.SAMLIDPMetadata = data.Get("saml_idp_metadata").(string)
+	}
+	if isSet("saml_idp_certificates") {
+		config.SAMLIDPCertificates = data.Get("saml_idp_certificates").([]string)
+	}
+	if _, _, err := config.samlIDP(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get the default key (optional). A deprecated signing_key is imported
+	// as a named key and becomes the default.
+	var warnings []string
+	if isSet("default_key") {
+		config.DefaultKey = data.Get("default_key").(string)
+	}
+	if signingKey, ok := data.GetOk("signing_key"); ok && signingKey.(string) != "" {
+		config.LegacySigningKey = signingKey.(string)
+	}
+	if config.LegacySigningKey != "" {
+		b.lock.Lock()
+		name, err := b.importLegacySigningKey(ctx, req.Storage, config.DefaultKey, config.LegacySigningKey)
+		b.lock.Unlock()
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		config.DefaultKey = name
+		config.LegacySigningKey = ""
+		warnings = append(warnings, fmt.Sprintf("signing_key is deprecated: it was imported as key %q and set as default_key. Manage it under key/%s", name, name))
+	}
+	if config.DefaultKey != "" {
+		key, err := b.getKey(ctx, req.Storage, config.DefaultKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate default key: %w", err)
+		}
+		if key == nil {
+			return logical.ErrorResponse("default key %q not found", config.DefaultKey), nil
+		}
+		if _, err := signatureAlgorithm(key.Algorithm); err != nil {
+			return logical.ErrorResponse("default key %q uses an unsupported algorithm %q", config.DefaultKey, key.Algorithm), nil
+		}
+		if key.Tenant != "" {
+			return logical.ErrorResponse("default key %q belongs to tenant %q and cannot be shared", config.DefaultKey, key.Tenant), nil
+		}
+	}
+
+	// Get audit settings (optional)
+	if isSet("audit_key") {
+		config.AuditKey = data.Get("audit_key").(string)
+		if config.AuditKey != "" {
+			if resp, err := b.validateAuditKey(ctx, req.Storage, config.AuditKey); resp != nil || err != nil {
+				return resp, err
+			}
+		}
+	}
+	if config.AuditKey != "" && config.AuditKey == config.DefaultKey {
+		return logical.ErrorResponse("audit_key cannot also be the default_key"), nil
+	}
+
+	// Get record retention (optional, has default)
+	if isSet("record_retention") {
+		config.RecordRetention = time.Duration(data.Get("record_retention").(int)) * time.Second
+		if config.RecordRetention <= 0 {
+			return logical.ErrorResponse("record_retention must be positive"), nil
+		}
+	}
+	if isSet("record_retention_overrides") {
+		overrides, err := parseRecordRetentionOverrides(data.Get("record_retention_overrides").(map[string]string))
+		if err != nil {
+			return logical.ErrorResponse("invalid record_retention_overrides: %v", err), nil
+		}
+		config.RecordRetentionOverrides = overrides
+	}
+
+	// Get the issuance log (optional)
+	if isSet("issuance_log") {
+		config.IssuanceLog = data.Get("issuance_log").(bool)
+	}
+	if isSet("issuance_log_max_entries") {
+		config.IssuanceLogMaxEntries = data.Get("issuance_log_max_entries").(int)
+		if config.IssuanceLogMaxEntries <= 0 {
+			return logical.ErrorResponse("issuance_log_max_entries must be positive"), nil
+		}
+	}
+
+	// Get globally denied scopes (optional). Existing roles that issue a
+	// denied scope are reported, as their exchanges will now be denied.
+	if deniedScopes, ok := data.GetOk("globally_denied_scopes"); ok {
+		config.GloballyDeniedScopes = deniedScopes.([]string)
+		roles, err := b.rolesWithDeniedScopes(ctx, req.Storage, config.GloballyDeniedScopes)
+		if err != nil {
+			return nil, err
+		}
+		if len(roles) > 0 {
+			warnings = append(warnings, fmt.Sprintf("roles %s issue globally denied scopes: their exchanges will be denied until their context is changed", strings.Join(roles, ", ")))
+		}
+	}
+
+	// Get the role defaults (optional). They cannot be cleared while roles
+	// rely on them.
+	previousDefaults := config.RoleDefaults
+	if isSet("default_actor_template") {
+		config.RoleDefaults.ActorTemplate = data.Get("default_actor_template").(string)
+		if config.RoleDefaults.ActorTemplate != "" {
+			if err := validateActorTemplate(config.RoleDefaults.ActorTemplate); err != nil {
+				return logical.ErrorResponse("invalid default_actor_template: %v", err), nil
+			}
+		}
+	}
+	if isSet("default_context") {
+		config.RoleDefaults.Context = data.Get("default_context").([]string)
+	}
+	if isSet("default_bound_issuer") {
+		config.RoleDefaults.BoundIssuer = data.Get("default_bound_issuer").(string)
+	}
+	if len(config.RoleDefaults.Context) > 0 && len(config.GloballyDeniedScopes) > 0 {
+		scopes, err := expandScopes(ctx, req.Storage, config.RoleDefaults.Context)
+		if err != nil {
+			return nil, err
+		}
+		if denied := deniedScopes(scopes, config.GloballyDeniedScopes); len(denied) > 0 {
+			return logical.ErrorResponse("default_context includes globally denied scopes: %s", strings.Join(denied, ", ")), nil
+		}
+	}
+	if resp, err := b.checkRoleDefaultsUnused(ctx, req.Storage, previousDefaults, config.RoleDefaults); resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Get the entity metadata key naming roles for token/by-entity (optional)
+	if isSet("entity_role_metadata_key") {
+		config.EntityRoleMetadataKey = data.Get("entity_role_metadata_key").(string)
+	}
+
+	// Get key verification TTLs (optional, have defaults)
+	if isSet("default_verification_ttl") {
+		config.DefaultVerificationTTL = time.Duration(data.Get("default_verification_ttl").(int)) * time.Second
+		if config.DefaultVerificationTTL <= 0 {
+			return logical.ErrorResponse("default_verification_ttl must be positive"), nil
+		}
+	}
+	if isSet("max_verification_ttl") {
+		config.MaxVerificationTTL = time.Duration(data.Get("max_verification_ttl").(int)) * time.Second
+		if config.MaxVerificationTTL < 0 {
+			return logical.ErrorResponse("max_verification_ttl must not be negative"), nil
+		}
+	}
+	if config.MaxVerificationTTL > 0 && config.defaultVerificationTTL() > config.MaxVerificationTTL {
+		return logical.ErrorResponse("default_verification_ttl must not exceed max_verification_ttl"), nil
+	}
+
+	// Get tidy safety buffer (optional, has default)
+	if isSet("tidy_safety_buffer") {
+		config.TidySafetyBuffer = time.Duration(data.Get("tidy_safety_buffer").(int)) * time.Second
+		if config.TidySafetyBuffer <= 0 {
+			return logical.ErrorResponse("tidy_safety_buffer must be positive"), nil
+		}
+	}
+
+	// Get tidy interval (optional, has default)
+	if isSet("tidy_interval") {
+		config.TidyInterval = time.Duration(data.Get("tidy_interval").(int)) * time.Second
+		if config.TidyInterval <= 0 {
+			return logical.ErrorResponse("tidy_interval must be positive"), nil
+		}
 	}
 
-	// Get subject_jwks_uri (required)
-	if subjectJWKSURI, ok := data.GetOk("subject_jwks_uri"); ok {
-		config.SubjectJWKSURI = subjectJWKSURI.(string)
+	// A deprecated issuance_retention, from the request or stored by an older
+	// version, becomes the issuance override
+	legacyRetention := config.IssuanceRetention
+	if issuanceRetention, ok := data.GetOk("issuance_retention"); ok {
+		legacyRetention = time.Duration(issuanceRetention.(int)) * time.Second
+		if legacyRetention <= 0 {
+			return logical.ErrorResponse("issuance_retention must be positive"), nil
+		}
+		warnings = append(warnings, "issuance_retention is deprecated: it was set as the issuance entry of record_retention_overrides")
+		delete(config.RecordRetentionOverrides, recordTypeIssuance)
 	}
+	if _, ok := config.RecordRetentionOverrides[recordTypeIssuance]; legacyRetention > 0 && !ok {
+		if config.RecordRetentionOverrides == nil {
+			config.RecordRetentionOverrides = map[string]time.Duration{}
+		}
+		config.RecordRetentionOverrides[recordTypeIssuance] = legacyRetention
+	}
+	config.IssuanceRetention = 0
+
+	if isSet("max_exchanges_per_minute") {
+		config.MaxExchangesPerMinute = data.Get("max_exchanges_per_minute").(int)
+		if config.MaxExchangesPerMinute < 0 {
+			return logical.ErrorResponse("max_exchanges_per_minute must not be negative"), nil
+		}
+	}
+
+	// A configuration rewritten without changes keeps its cas_version. The
+	// caches below are still reset, so a rewrite retries the subject JWKS.
+	if before == nil || !sameEntry(before, config) {
+		if err := putConfig(ctx, req.Storage, config); err != nil {
+			return nil, err
+		}
+	}
+	if err := deleteStoredJWKS(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
+	b.resetConfigCache()
 
-	// Store configuration
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+	return nil, nil
+}
+
+// putConfig writes the configuration to storage as its next cas_version
+func putConfig(ctx context.Context, storage logical.Storage, config *Config) error {
+	config.CASVersion++
 	entry, err := logical.StorageEntryJSON(configStoragePath, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+		return fmt.Errorf("failed to create storage entry: %w", err)
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, fmt.Errorf("failed to write configuration: %w", err)
+	if err := storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+
+	return nil
+}
+
+// validateAuditKey checks that the audit key exists and is dedicated to
+// signing issuance records
+func (b *Backend) validateAuditKey(ctx context.Context, storage logical.Storage, name string) (*logical.Response, error) {
+	key, err := b.getKey(ctx, storage, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate audit key: %w", err)
+	}
+	if key == nil {
+		return logical.ErrorResponse("audit key %q not found", name), nil
+	}
+	if _, err := signatureAlgorithm(key.Algorithm); err != nil {
+		return logical.ErrorResponse("audit key %q uses an unsupported algorithm %q", name, key.Algorithm), nil
+	}
+	if key.Tenant != "" {
+		return logical.ErrorResponse("audit key %q belongs to tenant %q and cannot be shared", name, key.Tenant), nil
+	}
+
+	roles, err := b.rolesReferencingKey(ctx, storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		return logical.ErrorResponse("audit key %q must not be used by roles: %s", name, strings.Join(roles, ", ")), nil
 	}
 
 	return nil, nil
 }
 
+// subjectTokenAlgorithmNames returns the algorithms accepted on subject tokens
+func (c *Config) subjectTokenAlgorithmNames() []string {
+	if len(c.AllowedSubjectTokenAlgorithms) == 0 {
+		return []string{string(jose.RS256)}
+	}
+	return c.AllowedSubjectTokenAlgorithms
+}
+
+// subjectTokenAlgorithms returns the accepted subject token algorithms as jose constants
+func (c *Config) subjectTokenAlgorithms() []jose.SignatureAlgorithm {
+	names := c.subjectTokenAlgorithmNames()
+	algorithms := make([]jose.SignatureAlgorithm, 0, len(names))
+	for _, name := range names {
+		algorithms = append(algorithms, jose.SignatureAlgorithm(name))
+	}
+	return algorithms
+}
+
 // pathConfigDelete handles deleting the configuration
 func (b *Backend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	if err := req.Storage.Delete(ctx, configStoragePath); err != nil {
 		return nil, fmt.Errorf("failed to delete configuration: %w", err)
 	}
+	if err := deleteStoredJWKS(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
+	b.resetConfigCache()
 
 	return nil, nil
 }
 
-// getConfig retrieves the configuration from storage
+// getConfig retrieves the configuration, from the cache when possible
 func (b *Backend) getConfig(ctx context.Context, storage logical.Storage) (*Config, error) {
+	b.cacheLock.RLock()
+	cached := b.cachedConfig
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+	b.recordCacheLookup("config", cached != nil)
+	if cached != nil {
+		config := *cached
+		return &config, nil
+	}
+
 	entry, err := storage.Get(ctx, configStoragePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configuration: %w", err)
@@ -99,6 +661,31 @@ func (b *Backend) getConfig(ctx context.Context, storage logical.Storage) (*Conf
 	if err := entry.DecodeJSON(config); err != nil {
 		return nil, fmt.Errorf("failed to decode configuration: %w", err)
 	}
+	config.CASVersion = storedVersion(config.CASVersion)
+	config.upgrade()
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		cachedConfig := *config
+		b.cachedConfig = &cachedConfig
+	}
+	b.cacheLock.Unlock()
 
 	return config, nil
 }
+
+// resetConfigCache drops the cached configuration and the subject JWKS it points at
+func (b *Backend) resetConfigCache() {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	b.cacheGeneration++
+	b.cachedConfig = nil
+	b.jwksCache = make(map[string]*jwksCacheEntry)
+	b.encryptionJWKSCache = make(map[string]*jwksCacheEntry)
+	b.oidcDiscoveryCache = make(map[string]*oidcDiscoveryEntry)
+	b.jwksClient = nil
+	b.vaultAPIClient = nil
+	b.authorizationWebhookClient = nil
+	b.jwksBreaker.reset()
+}
@@ -158,7 +158,8 @@ func TestConfigRead_AfterWrite(t *testing.T) {
 	require.NotNil(t, resp, "Should return response")
 	require.NotNil(t, resp.Data, "Response should have data")
 	require.Equal(t, "https://vault.example.com", resp.Data["issuer"])
-	require.Equal(t, "24h0m0s", resp.Data["default_ttl"])
+	require.EqualValues(t, 86400, resp.Data["default_ttl"])
+	require.Equal(t, "24h0m0s", resp.Data["default_ttl_human"])
 	require.Equal(t, "https://vault.example.com/.well-known/jwks.json", resp.Data["subject_jwks_uri"])
 }
 
@@ -196,3 +197,80 @@ func TestConfigDelete(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, entry, "Config should be deleted")
 }
+
+// TestConfigWrite_PartialUpdate tests that updating the config only changes
+// the fields in the request
+func TestConfigWrite_PartialUpdate(t *testing.T) {
+	b, storage := getTestBackend(t)
+	clientCert, clientKey := generateClientCertificate(t)
+
+	resp := writeJWKSConfig(t, b, storage, map[string]any{
+		"subject_jwks_uri":         "https://idp.example.com/jwks",
+		"default_ttl":              "2h",
+		"jwks_client_cert":         clientCert,
+		"jwks_client_key":          clientKey,
+		"max_exchanges_per_minute": 10,
+	})
+	require.Nil(t, resp)
+
+	readConfig := func() map[string]any {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   storage,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp.Data
+	}
+	before := readConfig()
+
+	// Rewriting without the client key keeps it and the other settings
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"jwks_max_retries": 4},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	after := readConfig()
+	require.Equal(t, 4, after["jwks_max_retries"])
+	require.Equal(t, true, after["jwks_client_key_configured"])
+	require.Equal(t, before["jwks_client_key_fingerprint"], after["jwks_client_key_fingerprint"])
+	require.Equal(t, "https://vault.example.com", after["issuer"])
+	require.EqualValues(t, 7200, after["default_ttl"])
+	require.Equal(t, 10, after["max_exchanges_per_minute"])
+	require.Equal(t, "https://idp.example.com/jwks", after["subject_jwks_uri"])
+
+	// Setting a static key source replaces the JWKS URI
+	privateKey, _ := generateTestKeyPair(t)
+	publicKeyPEM := encodePKIXPublicKeyPEM(t, &privateKey.PublicKey)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"subject_public_keys": []string{publicKeyPEM}},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	after = readConfig()
+	require.Empty(t, after["subject_jwks_uri"])
+	require.Len(t, after["subject_public_keys"], 1)
+
+	// Clearing the client key is explicit
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"jwks_client_cert": "", "jwks_client_key": ""},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	after = readConfig()
+	require.Equal(t, false, after["jwks_client_key_configured"])
+	require.Empty(t, after["jwks_client_key_fingerprint"])
+}
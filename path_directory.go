@@ -0,0 +1,104 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigDirectory returns the path configuration for /config/directory endpoint
+func pathConfigDirectory(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/directory",
+
+		ExistenceCheck: b.pathConfigDirectoryExistenceCheck,
+
+		Fields: map[string]*framework.FieldSchema{
+			"type": {
+				Type:        framework.TypeString,
+				Description: "Directory connector type: ldap or scim",
+				Required:    true,
+			},
+			"url": {
+				Type:        framework.TypeString,
+				Description: "LDAP server URL (ldap:// or ldaps://) or SCIM 2.0 base URL",
+				Required:    true,
+			},
+			"bind_dn": {
+				Type:        framework.TypeString,
+				Description: "LDAP only: DN of the service account used to search the directory",
+			},
+			"bind_password": {
+				Type:        framework.TypeString,
+				Description: "LDAP only: password for bind_dn",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"user_dn": {
+				Type:        framework.TypeString,
+				Description: "LDAP only: base DN under which to search for users",
+			},
+			"user_attr": {
+				Type:        framework.TypeString,
+				Description: "Directory attribute matched against lookup_claim. Defaults to 'mail' for LDAP and 'emails.value' for SCIM",
+			},
+			"scim_token": {
+				Type:        framework.TypeString,
+				Description: "SCIM only: bearer token used to authenticate to the SCIM endpoint",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"attributes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Directory attributes exposed to templates as identity.directory.<attr>. All attributes are returned when empty",
+			},
+			"lookup_claim": {
+				Type:        framework.TypeString,
+				Description: "Subject token claim (e.g. email or upn) used to find the directory user",
+				Default:     "email",
+			},
+			"cache_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long successful lookups are cached. Set to 0 to disable caching",
+				Default:     "5m",
+			},
+			"failure_policy": {
+				Type:        framework.TypeString,
+				Description: "Behaviour when a lookup fails: 'deny' rejects the exchange, 'ignore' continues without directory attributes",
+				Default:     DirectoryFailureDeny,
+			},
+			"request_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Timeout for each directory request",
+				Default:     "10s",
+			},
+			"ca_pem": {
+				Type:        framework.TypeString,
+				Description: "PEM bundle of CAs trusted when connecting to ldaps:// or https:// directories. The system roots are used when empty",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigDirectoryRead,
+				Summary:  "Read the directory enrichment configuration",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigDirectoryWrite,
+				Summary:  "Configure directory enrichment",
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathConfigDirectoryWrite,
+				Summary:  "Configure directory enrichment",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathConfigDirectoryDelete,
+				Summary:  "Delete the directory enrichment configuration",
+			},
+		},
+
+		HelpSynopsis:    "Configure LDAP or SCIM directory enrichment",
+		HelpDescription: "Configures an optional directory connector that resolves additional subject attributes by email or UPN. Resolved attributes are available to subject_template as {{identity.directory.<attr>}}. Lookups are cached for cache_ttl and failure_policy controls whether exchanges proceed when the directory is unavailable.",
+	}
+}
@@ -0,0 +1,152 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigDirectoryExistenceCheck checks if the directory config exists
+func (b *Backend) pathConfigDirectoryExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	config, err := b.getDirectoryConfig(ctx, req.Storage)
+	if err != nil {
+		return false, err
+	}
+
+	return config != nil, nil
+}
+
+// pathConfigDirectoryRead handles reading the directory configuration
+func (b *Backend) pathConfigDirectoryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getDirectoryConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"type":            config.Type,
+			"url":             config.URL,
+			"bind_dn":         config.BindDN,
+			"user_dn":         config.UserDN,
+			"user_attr":       config.UserAttr,
+			"attributes":      config.Attributes,
+			"lookup_claim":    config.LookupClaim,
+			"cache_ttl":       durationSeconds(config.CacheTTL),
+			"failure_policy":  config.FailurePolicy,
+			"request_timeout": durationSeconds(config.RequestTimeout),
+			"ca_pem":          config.CAPEM,
+			// Note: bind_password and scim_token are NEVER returned
+		},
+	}, nil
+}
+
+// pathConfigDirectoryWrite handles writing the directory configuration
+func (b *Backend) pathConfigDirectoryWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config := &DirectoryConfig{}
+
+	// Get type (required)
+	directoryType, ok := data.GetOk("type")
+	if !ok {
+		return logical.ErrorResponse("type is required"), nil
+	}
+	config.Type = directoryType.(string)
+	if config.Type != DirectoryTypeLDAP && config.Type != DirectoryTypeSCIM {
+		return logical.ErrorResponse("type must be ldap or scim"), nil
+	}
+
+	// Get url (required)
+	directoryURL, ok := data.GetOk("url")
+	if !ok {
+		return logical.ErrorResponse("url is required"), nil
+	}
+	config.URL = directoryURL.(string)
+
+	config.BindDN = data.Get("bind_dn").(string)
+	config.BindPassword = data.Get("bind_password").(string)
+	config.UserDN = data.Get("user_dn").(string)
+	config.SCIMToken = data.Get("scim_token").(string)
+	config.Attributes = data.Get("attributes").([]string)
+	config.LookupClaim = data.Get("lookup_claim").(string)
+
+	if config.Type == DirectoryTypeLDAP && config.UserDN == "" {
+		return logical.ErrorResponse("user_dn is required for ldap directories"), nil
+	}
+
+	config.UserAttr = data.Get("user_attr").(string)
+	if config.UserAttr == "" {
+		config.UserAttr = defaultUserAttr(config.Type)
+	}
+
+	config.FailurePolicy = data.Get("failure_policy").(string)
+	if config.FailurePolicy != DirectoryFailureDeny && config.FailurePolicy != DirectoryFailureIgnore {
+		return logical.ErrorResponse("failure_policy must be deny or ignore"), nil
+	}
+
+	config.CacheTTL = time.Duration(data.Get("cache_ttl").(int)) * time.Second
+	if config.CacheTTL < 0 {
+		return logical.ErrorResponse("cache_ttl must not be negative"), nil
+	}
+
+	config.RequestTimeout = time.Duration(data.Get("request_timeout").(int)) * time.Second
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultDirectoryRequestTimeout
+	}
+
+	config.CAPEM = data.Get("ca_pem").(string)
+	if _, err := config.tlsConfig(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Store configuration
+	entry, err := logical.StorageEntryJSON(directoryConfigStoragePath, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write directory configuration: %w", err)
+	}
+
+	// Cached lookups may have been resolved with the previous settings
+	b.resetDirectoryCache()
+
+	return nil, nil
+}
+
+// pathConfigDirectoryDelete handles deleting the directory configuration
+func (b *Backend) pathConfigDirectoryDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, directoryConfigStoragePath); err != nil {
+		return nil, fmt.Errorf("failed to delete directory configuration: %w", err)
+	}
+
+	b.resetDirectoryCache()
+
+	return nil, nil
+}
+
+// getDirectoryConfig retrieves the directory configuration from storage
+func (b *Backend) getDirectoryConfig(ctx context.Context, storage logical.Storage) (*DirectoryConfig, error) {
+	entry, err := storage.Get(ctx, directoryConfigStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory configuration: %w", err)
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	config := &DirectoryConfig{}
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, fmt.Errorf("failed to decode directory configuration: %w", err)
+	}
+
+	return config, nil
+}
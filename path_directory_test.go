@@ -0,0 +1,272 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// createMockSCIMServer creates a test SCIM server that returns the given user
+// for any filter containing user@example.com, and counts requests
+func createMockSCIMServer(t *testing.T, user map[string]any, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		require.Equal(t, "/Users", r.URL.Path)
+		require.Equal(t, "Bearer scim-secret", r.Header.Get("Authorization"))
+
+		resources := []map[string]any{}
+		if r.URL.Query().Get("filter") == `emails.value eq "user@example.com"` {
+			resources = append(resources, user)
+		}
+
+		w.Header().Set("Content-Type", "application/scim+json")
+		err := json.NewEncoder(w).Encode(map[string]any{
+			"totalResults": len(resources),
+			"Resources":    resources,
+		})
+		require.NoError(t, err)
+	}))
+}
+
+// writeDirectoryConfig writes the directory configuration
+func writeDirectoryConfig(t *testing.T, b *Backend, storage logical.Storage, data map[string]any) *logical.Response {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/directory",
+		Storage:   storage,
+		Data:      data,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	return resp
+}
+
+// TestDirectoryConfig_WriteRead tests writing and reading directory config
+func TestDirectoryConfig_WriteRead(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp := writeDirectoryConfig(t, b, storage, map[string]any{
+		"type":          "ldap",
+		"url":           "ldaps://ldap.example.com",
+		"bind_dn":       "cn=vault,dc=example,dc=com",
+		"bind_password": "secret",
+		"user_dn":       "ou=users,dc=example,dc=com",
+		"attributes":    "department,manager",
+	})
+	require.Nil(t, resp)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/directory",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	require.Equal(t, "ldap", resp.Data["type"])
+	require.Equal(t, "mail", resp.Data["user_attr"], "LDAP should default to matching mail")
+	require.Equal(t, "email", resp.Data["lookup_claim"])
+	require.Equal(t, "deny", resp.Data["failure_policy"])
+	require.EqualValues(t, 300, resp.Data["cache_ttl"])
+	require.Equal(t, []string{"department", "manager"}, resp.Data["attributes"])
+	require.NotContains(t, resp.Data, "bind_password", "Bind password must never be returned")
+}
+
+// TestDirectoryConfig_Validation tests directory config validation
+func TestDirectoryConfig_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   map[string]any
+		errMsg string
+	}{
+		{
+			name:   "invalid type",
+			data:   map[string]any{"type": "ad", "url": "ldap://x"},
+			errMsg: "type must be ldap or scim",
+		},
+		{
+			name:   "ldap without user_dn",
+			data:   map[string]any{"type": "ldap", "url": "ldap://x"},
+			errMsg: "user_dn is required",
+		},
+		{
+			name:   "invalid ca_pem",
+			data:   map[string]any{"type": "scim", "url": "https://x", "ca_pem": "not a certificate"},
+			errMsg: "ca_pem contains no valid certificates",
+		},
+		{
+			name:   "invalid failure policy",
+			data:   map[string]any{"type": "scim", "url": "https://x", "failure_policy": "retry"},
+			errMsg: "failure_policy must be deny or ignore",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			resp := writeDirectoryConfig(t, b, storage, tt.data)
+			require.NotNil(t, resp)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.errMsg)
+		})
+	}
+}
+
+// TestSCIMDirectory_FilterEscaping tests that SCIM lookups escape only quotes
+// and backslashes in the filter value, and trust the configured ca_pem
+func TestSCIMDirectory_FilterEscaping(t *testing.T) {
+	var filter string
+	scimServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		err := json.NewEncoder(w).Encode(map[string]any{
+			"totalResults": 1,
+			"Resources":    []map[string]any{{"title": "Engineer"}},
+		})
+		require.NoError(t, err)
+	}))
+	defer scimServer.Close()
+
+	config := &DirectoryConfig{
+		Type:           DirectoryTypeSCIM,
+		URL:            scimServer.URL,
+		UserAttr:       "userName",
+		RequestTimeout: defaultDirectoryRequestTimeout,
+	}
+
+	// The test server's certificate is not in the system roots
+	client, err := newDirectoryClient(config)
+	require.NoError(t, err)
+	_, err = client.Lookup(context.Background(), "user")
+	require.Error(t, err)
+
+	config.CAPEM = certificatePEM(scimServer.Certificate())
+	client, err = newDirectoryClient(config)
+	require.NoError(t, err)
+
+	attrs, err := client.Lookup(context.Background(), `José "Pepe" O\Brien`)
+	require.NoError(t, err)
+	require.Equal(t, "Engineer", attrs["title"])
+	require.Equal(t, `userName eq "José \"Pepe\" O\\Brien"`, filter)
+}
+
+// TestLDAPDirectory_ContextCancel tests that LDAP lookups are abandoned when
+// the exchange's context is done, even when the server never answers
+func TestLDAPDirectory_ContextCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept the connection and never reply to the bind
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	client, err := newDirectoryClient(&DirectoryConfig{
+		Type:           DirectoryTypeLDAP,
+		URL:            "ldap://" + listener.Addr().String(),
+		BindDN:         "cn=vault,dc=example,dc=com",
+		BindPassword:   "secret",
+		UserDN:         "ou=users,dc=example,dc=com",
+		UserAttr:       "mail",
+		RequestTimeout: time.Minute,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = client.Lookup(ctx, "user@example.com")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestTokenExchange_DirectoryEnrichment tests that SCIM attributes are available
+// to the subject template and that lookups are cached
+func TestTokenExchange_DirectoryEnrichment(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	var requests int32
+	scimServer := createMockSCIMServer(t, map[string]any{
+		"userName": "user",
+		"title":    "Engineer",
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]any{
+			"department": "payments",
+		},
+	}, &requests)
+	defer scimServer.Close()
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"subject_template": `{"department": "{{identity.directory.department}}", "title": "{{identity.directory.title}}"}`,
+	})
+
+	resp := writeDirectoryConfig(t, b, storage, map[string]any{
+		"type":       "scim",
+		"url":        scimServer.URL,
+		"scim_token": "scim-secret",
+	})
+	require.Nil(t, resp)
+
+	for i := 0; i < 2; i++ {
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		subjectClaims := claims["subject_claims"].(map[string]any)
+		require.Equal(t, "payments", subjectClaims["department"])
+		require.Equal(t, "Engineer", subjectClaims["title"])
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests), "Second exchange should use the cache")
+}
+
+// TestTokenExchange_DirectoryFailurePolicy tests deny and ignore failure policies
+func TestTokenExchange_DirectoryFailurePolicy(t *testing.T) {
+	for _, policy := range []string{DirectoryFailureDeny, DirectoryFailureIgnore} {
+		t.Run(policy, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			var requests int32
+			scimServer := createMockSCIMServer(t, map[string]any{"title": "Engineer"}, &requests)
+			defer scimServer.Close()
+
+			privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+			resp := writeDirectoryConfig(t, b, storage, map[string]any{
+				"type":           "scim",
+				"url":            scimServer.URL,
+				"scim_token":     "scim-secret",
+				"failure_policy": policy,
+			})
+			require.Nil(t, resp)
+
+			// Unknown user, lookup returns no resources
+			claims := defaultSubjectClaims()
+			claims["email"] = "unknown@example.com"
+
+			resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+			if policy == DirectoryFailureDeny {
+				require.True(t, resp.IsError())
+				require.Contains(t, resp.Error().Error(), "directory")
+			} else {
+				require.False(t, resp.IsError(), "exchange should continue: %v", resp.Error())
+				require.NotEmpty(t, resp.Data["token"])
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathDiscovery returns the path configuration for the
+// /.well-known/openid-configuration endpoint
+func pathDiscovery(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `\.well-known/openid-configuration$`,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathDiscoveryRead,
+				Summary:  "Get the OpenID Connect discovery document of the issuer",
+			},
+		},
+
+		HelpSynopsis:    "Retrieve the issuer's OpenID Connect discovery document",
+		HelpDescription: "Returns the OpenID Connect discovery document pointing at this mount's JWKS. Cloud providers that federate with the issuer, such as Azure AD, fetch it from <issuer>/.well-known/openid-configuration, so set the config's issuer to this mount's URL. This endpoint is publicly accessible (unauthenticated).",
+	}
+}
+
+// pathTenantDiscovery returns the path configuration for the
+// /tenant/:tenant/.well-known/openid-configuration endpoint
+func pathTenantDiscovery(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tenant/" + framework.GenericNameRegex("tenant") + `/\.well-known/openid-configuration$`,
+
+		Fields: map[string]*framework.FieldSchema{
+			"tenant": {
+				Type:        framework.TypeString,
+				Description: "Tenant whose issuer is described",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathTenantDiscoveryRead,
+				Summary:  "Get the OpenID Connect discovery document of a tenant issuer",
+			},
+		},
+
+		HelpSynopsis:    "Retrieve a tenant issuer's OpenID Connect discovery document",
+		HelpDescription: "Returns the OpenID Connect discovery document of the tenant issuer <issuer>/tenant/<tenant>, pointing at the tenant's JWKS. This endpoint is publicly accessible (unauthenticated).",
+	}
+}
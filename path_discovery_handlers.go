@@ -0,0 +1,76 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathDiscoveryRead handles reading the OpenID Connect discovery document
+func (b *Backend) pathDiscoveryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.readDiscovery(ctx, req, "")
+}
+
+// pathTenantDiscoveryRead handles reading the discovery document of a tenant issuer
+func (b *Backend) pathTenantDiscoveryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.readDiscovery(ctx, req, data.Get("tenant").(string))
+}
+
+// readDiscovery returns the discovery document of the issuer of tenant
+func (b *Backend) readDiscovery(ctx context.Context, req *logical.Request, tenant string) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("plugin not configured"), nil
+	}
+	if config.APIAddr == "" {
+		return logical.ErrorResponse("discovery requires api_addr in the config"), nil
+	}
+	jwksURI, err := jwksURL(config, tenant, req.MountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Advertise the algorithms of the published keys
+	keyNames, err := req.Storage.List(ctx, keyStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	algorithms := []string{}
+	for _, keyName := range keyNames {
+		key, err := b.getKey(ctx, req.Storage, keyName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %q: %w", keyName, err)
+		}
+		if key != nil && key.Tenant == tenant && !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	slices.Sort(algorithms)
+
+	document, err := json.Marshal(map[string]any{
+		"issuer":                                tenantIssuer(config, tenant),
+		"jwks_uri":                              jwksURI,
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algorithms,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal discovery document: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			logical.HTTPContentType:        "application/json",
+			logical.HTTPRawBody:            document,
+			logical.HTTPStatusCode:         200,
+			logical.HTTPCacheControlHeader: fmt.Sprintf("max-age=%d", int(defaultJWKSMaxAge.Seconds())),
+		},
+	}, nil
+}
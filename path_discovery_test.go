@@ -0,0 +1,48 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathDiscoveryRead(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+
+	request := &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       ".well-known/openid-configuration",
+		Storage:    storage,
+		MountPoint: "identity-delegation/",
+	}
+
+	// The JWKS URL is built from api_addr
+	resp, err := b.HandleRequest(context.Background(), request)
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "api_addr")
+
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"api_addr": "https://vault.example.com"}))
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/pss-key",
+		Storage:   storage,
+		Data:      map[string]any{"algorithm": AlgorithmPS256},
+	})
+	require.NoError(t, err)
+
+	resp, err = b.HandleRequest(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, "application/json", resp.Data[logical.HTTPContentType])
+
+	document := map[string]any{}
+	require.NoError(t, json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &document))
+	require.Equal(t, "https://vault.example.com", document["issuer"])
+	require.Equal(t, "https://vault.example.com/v1/identity-delegation/jwks", document["jwks_uri"])
+	require.Equal(t, []any{AlgorithmPS256, AlgorithmRS256}, document["id_token_signing_alg_values_supported"])
+	require.Equal(t, []any{"public"}, document["subject_types_supported"])
+}
@@ -0,0 +1,84 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathExport returns the path configuration for /export endpoint
+func pathExport(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "export",
+
+		Fields: map[string]*framework.FieldSchema{
+			"include_private_keys": {
+				Type:        framework.TypeBool,
+				Description: "Include the private keys of internal keys, wrapped to wrapping_key. Without them, imported internal keys get a new latest version",
+			},
+			"wrapping_key": {
+				Type:        framework.TypeString,
+				Description: "PEM RSA public key from the importing mount's import/wrapping_key endpoint. Required with include_private_keys",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathExport,
+				Summary:  "Export the mount's config, roles, template fragments and keys",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathExport,
+				Summary:  "Export the mount's config, roles, template fragments and keys",
+			},
+		},
+
+		HelpSynopsis:    "Export the mount for disaster recovery or migration",
+		HelpDescription: "Returns the config, including the trusted subject token issuers, roles, template fragments and key metadata as a single JSON document for the import endpoint of another mount. Credentials such as transit_token and upstream client secrets are left out and listed in omitted_secrets. Private keys are only included wrapped to the importing mount's wrapping key.",
+	}
+}
+
+// pathImport returns the path configuration for /import endpoint
+func pathImport(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "import",
+
+		Fields: map[string]*framework.FieldSchema{
+			"document": {
+				Type:        framework.TypeString,
+				Description: "JSON document returned by the export endpoint",
+				Required:    true,
+			},
+			"overwrite": {
+				Type:        framework.TypeBool,
+				Description: "Replace a config, roles, template fragments and keys that already exist. By default the import fails if any of them exists",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathImport,
+				Summary:  "Import a document exported from another mount",
+			},
+		},
+
+		HelpSynopsis:    "Import an exported mount",
+		HelpDescription: "Writes the config, roles, template fragments and keys of an export document. Nothing is written if any entry is invalid. Wrapped private keys are unwrapped with this mount's import wrapping key.",
+	}
+}
+
+// pathImportWrappingKey returns the path configuration for /import/wrapping_key endpoint
+func pathImportWrappingKey(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "import/wrapping_key",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathImportWrappingKeyRead,
+				Summary:  "Read the public key that exported private keys are wrapped to",
+			},
+		},
+
+		HelpSynopsis:    "Read the import wrapping key",
+		HelpDescription: "Returns the RSA public key of this mount that another mount's export wraps private keys to. The key is generated on first read and its private key never leaves this mount.",
+	}
+}
@@ -0,0 +1,243 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathExport handles exporting the mount
+func (b *Backend) pathExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	includePrivateKeys := data.Get("include_private_keys").(bool)
+	encodedWrappingKey := data.Get("wrapping_key").(string)
+
+	var wrappingKey *rsa.PublicKey
+	switch {
+	case includePrivateKeys && encodedWrappingKey == "":
+		return logical.ErrorResponse("include_private_keys requires the importing mount's wrapping_key"), nil
+	case !includePrivateKeys && encodedWrappingKey != "":
+		return logical.ErrorResponse("wrapping_key is only used with include_private_keys"), nil
+	case includePrivateKeys:
+		parsed, err := parseSubjectPublicKey(encodedWrappingKey)
+		if err != nil {
+			return logical.ErrorResponse("failed to parse wrapping_key: %v", err), nil
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return logical.ErrorResponse("wrapping_key must be an RSA public key"), nil
+		}
+		wrappingKey = rsaKey
+	}
+
+	doc, err := b.exportMount(ctx, req.Storage, wrappingKey, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"document":        string(encoded),
+			"omitted_secrets": doc.OmittedSecrets,
+		},
+	}, nil
+}
+
+// pathImport handles importing an exported mount
+func (b *Backend) pathImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	overwrite := data.Get("overwrite").(bool)
+
+	doc := &exportDocument{}
+	if err := json.Unmarshal([]byte(data.Get("document").(string)), doc); err != nil {
+		return logical.ErrorResponse("failed to parse document: %v", err), nil
+	}
+	if doc.Version != exportFormatVersion {
+		return logical.ErrorResponse("unsupported document version %d, expected %d", doc.Version, exportFormatVersion), nil
+	}
+
+	// Fetch the wrapping key before taking lock, which importWrappingKey takes
+	var wrappingKey *rsa.PrivateKey
+	for _, exported := range doc.Keys {
+		if exported != nil && exported.WrappedPrivateKey != "" {
+			var err error
+			if wrappingKey, err = b.importWrappingKey(ctx, req.Storage); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	var existing []string
+	var warnings []string
+
+	// Build every entry before writing so an invalid document writes nothing
+	keys := make(map[string]*Key, len(doc.Keys))
+	for _, name := range slices.Sorted(maps.Keys(doc.Keys)) {
+		key, replaced, err := importedKey(name, doc.Keys[name], wrappingKey, doc.Config, now)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if replaced {
+			warnings = append(warnings, fmt.Sprintf("key %q was exported without its private key and has a new latest version %d. Earlier versions only verify tokens", name, key.Version))
+		}
+		current, err := b.getKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		key.CASVersion = 0
+		if current != nil {
+			existing = append(existing, "key/"+name)
+			key.CASVersion = current.CASVersion
+		}
+		keys[name] = key
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(doc.Roles)) {
+		role := doc.Roles[name]
+		if role == nil {
+			return logical.ErrorResponse("role %q is empty", name), nil
+		}
+		role.Name = name
+		current, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		role.CASVersion = 1
+		if current != nil {
+			existing = append(existing, "role/"+name)
+			role.CASVersion = current.CASVersion + 1
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(doc.TemplateLibrary)) {
+		fragment := doc.TemplateLibrary[name]
+		if fragment == nil {
+			return logical.ErrorResponse("template fragment %q is empty", name), nil
+		}
+		fragment.Name = name
+		current, err := b.getTemplateFragment(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			existing = append(existing, "template_library/"+name)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(doc.Scopes)) {
+		definition := doc.Scopes[name]
+		if definition == nil {
+			return logical.ErrorResponse("scope definition %q is empty", name), nil
+		}
+		definition.Name = name
+		current, err := getScopeDefinition(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			existing = append(existing, "scopes/"+name)
+		}
+	}
+
+	if doc.Config != nil {
+		current, err := b.getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		doc.Config.LegacySigningKey = ""
+		doc.Config.CASVersion = 0
+		if current != nil {
+			existing = append(existing, "config")
+			doc.Config.CASVersion = current.CASVersion
+		}
+	}
+
+	if len(existing) > 0 && !overwrite {
+		return logical.ErrorResponse("the mount already has %s. Set overwrite to replace them", strings.Join(existing, ", ")), nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(doc.TemplateLibrary)) {
+		if err := putStorageJSON(ctx, req.Storage, templateLibraryStoragePrefix+name, doc.TemplateLibrary[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(doc.Scopes)) {
+		if err := putStorageJSON(ctx, req.Storage, scopeStoragePrefix+name, doc.Scopes[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(keys)) {
+		if err := b.putKey(ctx, req.Storage, keys[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(doc.Roles)) {
+		if err := putStorageJSON(ctx, req.Storage, roleStoragePrefix+name, doc.Roles[name]); err != nil {
+			return nil, err
+		}
+	}
+	if doc.Config != nil {
+		if err := putConfig(ctx, req.Storage, doc.Config); err != nil {
+			return nil, err
+		}
+		b.resetConfigCache()
+	}
+
+	if len(doc.OmittedSecrets) > 0 {
+		warnings = append(warnings, "write these credentials again, they are not exported: "+strings.Join(doc.OmittedSecrets, ", "))
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"config":           doc.Config != nil,
+			"roles":            slices.Sorted(maps.Keys(doc.Roles)),
+			"template_library": slices.Sorted(maps.Keys(doc.TemplateLibrary)),
+			"scopes":           slices.Sorted(maps.Keys(doc.Scopes)),
+			"keys":             slices.Sorted(maps.Keys(keys)),
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// pathImportWrappingKeyRead handles reading the import wrapping key
+func (b *Backend) pathImportWrappingKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	wrappingKey, err := b.importWrappingKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"public_key": encodePublicKeyPEM(&wrappingKey.PublicKey),
+		},
+	}, nil
+}
+
+// putStorageJSON writes value as JSON to the storage path
+func putStorageJSON(ctx context.Context, storage logical.Storage, path string, value any) error {
+	entry, err := logical.StorageEntryJSON(path, value)
+	if err != nil {
+		return fmt.Errorf("failed to create storage entry: %w", err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// exportMountDocument exports the mount with the given parameters
+func exportMountDocument(t *testing.T, b *Backend, storage logical.Storage, data map[string]any) string {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "export",
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "export failed: %v", resp.Error())
+	return resp.Data["document"].(string)
+}
+
+// importMountDocument imports a document and returns the response
+func importMountDocument(t *testing.T, b *Backend, storage logical.Storage, document string, overwrite bool) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "import",
+		Storage:   storage,
+		Data:      map[string]any{"document": document, "overwrite": overwrite},
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// setupExportSource configures a mount with a config, key, role, template
+// fragment and scope definition
+func setupExportSource(t *testing.T) (*Backend, logical.Storage) {
+	b, storage := getTestBackend(t)
+	require.Nil(t, casRequest(t, b, storage, "config", map[string]any{
+		"issuer":                      "https://vault.example.com",
+		"subject_jwks_uri":            "https://idp.example.com/jwks",
+		"introspection_url":           "https://idp.example.com/introspect",
+		"introspection_client_id":     "vault",
+		"introspection_client_secret": "s3cret",
+	}))
+	createTestKey(t, b, storage, "test-key")
+	resp := casRequest(t, b, storage, "template_library/compliance", map[string]any{"actor_template": `{"tier": "gold"}`})
+	require.False(t, resp != nil && resp.IsError(), "fragment write failed: %v", resp)
+	resp = casRequest(t, b, storage, "scopes/urn:documents:admin", map[string]any{"implies": "urn:documents:read"})
+	require.False(t, resp != nil && resp.IsError(), "scope write failed: %v", resp)
+	require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"template_library": "compliance"}))
+	return b, storage
+}
+
+func TestExportImport_WithWrappedPrivateKeys(t *testing.T) {
+	source, sourceStorage := setupExportSource(t)
+	target, targetStorage := getTestBackend(t)
+
+	resp, err := target.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "import/wrapping_key", Storage: targetStorage})
+	require.NoError(t, err)
+	wrappingKey := resp.Data["public_key"].(string)
+
+	document := exportMountDocument(t, source, sourceStorage, map[string]any{"include_private_keys": true, "wrapping_key": wrappingKey})
+	require.NotContains(t, document, "s3cret")
+	require.NotContains(t, document, "PRIVATE KEY")
+
+	resp = importMountDocument(t, target, targetStorage, document, false)
+	require.False(t, resp.IsError(), "import failed: %v", resp.Error())
+	require.Equal(t, []string{"test-key"}, resp.Data["keys"])
+	require.Equal(t, []string{"test-role"}, resp.Data["roles"])
+	require.Equal(t, []string{"urn:documents:admin"}, resp.Data["scopes"])
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "introspection_client_secret")
+
+	sourceKey, err := source.getKey(context.Background(), sourceStorage, "test-key")
+	require.NoError(t, err)
+	targetKey, err := target.getKey(context.Background(), targetStorage, "test-key")
+	require.NoError(t, err)
+	require.Equal(t, sourceKey.PrivateKey, targetKey.PrivateKey)
+	require.Equal(t, sourceKey.KeyID, targetKey.KeyID)
+	require.Empty(t, targetKey.PublicKey)
+	require.Equal(t, 1, targetKey.CASVersion)
+
+	role, err := target.getRole(context.Background(), targetStorage, "test-role")
+	require.NoError(t, err)
+	require.Equal(t, []string{"compliance"}, role.TemplateLibrary)
+	fragment, err := target.getTemplateFragment(context.Background(), targetStorage, "compliance")
+	require.NoError(t, err)
+	require.NotNil(t, fragment)
+
+	config, err := target.getConfig(context.Background(), targetStorage)
+	require.NoError(t, err)
+	require.Equal(t, "https://idp.example.com/jwks", config.SubjectJWKSURI)
+	require.Equal(t, "vault", config.IntrospectionClientID)
+	require.Empty(t, config.IntrospectionClientSecret)
+	require.Equal(t, 1, config.CASVersion)
+}
+
+func TestExportImport_WithoutPrivateKeys(t *testing.T) {
+	source, sourceStorage := setupExportSource(t)
+	target, targetStorage := getTestBackend(t)
+
+	document := exportMountDocument(t, source, sourceStorage, nil)
+	resp := importMountDocument(t, target, targetStorage, document, false)
+	require.False(t, resp.IsError(), "import failed: %v", resp.Error())
+	require.Contains(t, resp.Warnings[0], "without its private key")
+
+	sourceKey, err := source.getKey(context.Background(), sourceStorage, "test-key")
+	require.NoError(t, err)
+	targetKey, err := target.getKey(context.Background(), targetStorage, "test-key")
+	require.NoError(t, err)
+	require.NotEqual(t, sourceKey.PrivateKey, targetKey.PrivateKey)
+	require.Equal(t, 2, targetKey.Version)
+
+	// Tokens signed by the source key still verify against the target's JWKS
+	sourcePublicKey, err := sourceKey.publicKey()
+	require.NoError(t, err)
+	jwk := jwksEntry(t, target, targetStorage, sourceKey.KeyID)
+	n, err := base64.RawURLEncoding.DecodeString(jwk["n"].(string))
+	require.NoError(t, err)
+	require.Equal(t, sourcePublicKey.N.Bytes(), n)
+}
+
+func TestExportImport_Validation(t *testing.T) {
+	source, sourceStorage := setupExportSource(t)
+
+	t.Run("existing entries need overwrite", func(t *testing.T) {
+		document := exportMountDocument(t, source, sourceStorage, nil)
+		resp := importMountDocument(t, source, sourceStorage, document, false)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "overwrite")
+
+		resp = importMountDocument(t, source, sourceStorage, document, true)
+		require.False(t, resp.IsError(), "import failed: %v", resp.Error())
+		require.Equal(t, 2, readCASVersion(t, source, sourceStorage, "role/test-role"))
+	})
+
+	t.Run("private keys need a wrapping key", func(t *testing.T) {
+		resp, err := source.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "export",
+			Storage:   sourceStorage,
+			Data:      map[string]any{"include_private_keys": true},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("wrapped for another mount", func(t *testing.T) {
+		other, otherStorage := getTestBackend(t)
+		resp, err := other.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "import/wrapping_key", Storage: otherStorage})
+		require.NoError(t, err)
+		document := exportMountDocument(t, source, sourceStorage, map[string]any{"include_private_keys": true, "wrapping_key": resp.Data["public_key"]})
+
+		target, targetStorage := getTestBackend(t)
+		resp = importMountDocument(t, target, targetStorage, document, false)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "wrapping key")
+
+		// Nothing was written
+		config, err := target.getConfig(context.Background(), targetStorage)
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		encoded, err := json.Marshal(map[string]any{"version": 99})
+		require.NoError(t, err)
+		resp := importMountDocument(t, source, sourceStorage, string(encoded), true)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "version")
+	})
+}
@@ -0,0 +1,31 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathIntrospect returns the path configuration for /introspect endpoint
+func pathIntrospect(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "introspect",
+
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "The reference token to introspect",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathIntrospect,
+				Summary:  "Introspect a reference token",
+			},
+		},
+
+		HelpSynopsis:    "Resolve reference tokens to their claims",
+		HelpDescription: "Returns an RFC 7662 introspection response for a reference token issued with requested_token_type urn:ietf:params:oauth:token-type:access_token: active set to true with the token's claims and token_type, or only active set to false for unknown or expired tokens. Grant resource servers access to this path with a Vault policy.",
+	}
+}
@@ -0,0 +1,30 @@
+package tokenexchange
+
+import (
+	"context"
+	"maps"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathIntrospect handles introspection of reference tokens
+func (b *Backend) pathIntrospect(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	token := data.Get("token").(string)
+	if token == "" {
+		return logical.ErrorResponse("token is required"), nil
+	}
+
+	ref, err := b.getReferenceToken(ctx, req.Storage, token)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return &logical.Response{Data: map[string]any{"active": false}}, nil
+	}
+
+	respData := maps.Clone(ref.Claims)
+	respData["active"] = true
+	respData["token_type"] = ref.TokenType
+	return &logical.Response{Data: respData}, nil
+}
@@ -0,0 +1,76 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathIssuanceList returns the path configuration for /issuances endpoint
+func pathIssuanceList(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "issuances/?$",
+
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Only list tokens issued by this role",
+				Query:       true,
+			},
+			"entity_id": {
+				Type:        framework.TypeString,
+				Description: "Only list tokens issued to this actor entity",
+				Query:       true,
+			},
+			"subject": {
+				Type:        framework.TypeString,
+				Description: "Only list tokens issued on behalf of this subject, matched against the stored hash of the subject token's sub claim",
+				Query:       true,
+			},
+			"start": {
+				Type:        framework.TypeTime,
+				Description: "Only list tokens issued at or after this time, as RFC 3339 or unix seconds",
+				Query:       true,
+			},
+			"end": {
+				Type:        framework.TypeTime,
+				Description: "Only list tokens issued before this time, as RFC 3339 or unix seconds",
+				Query:       true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathIssuanceList,
+				Summary:  "List issued tokens from the issuance log",
+			},
+		},
+
+		HelpSynopsis:    "List issued tokens",
+		HelpDescription: "Lists the jti of tokens in the issuance log, oldest first, with their role, actor entity, subject hash, scopes and lifetime. Filters narrow the list, e.g. to the tokens an entity obtained for a subject on a given day. Tokens are only logged while issuance_log is enabled.",
+	}
+}
+
+// pathIssuance returns the path configuration for /issuance/:jti endpoint
+func pathIssuance(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "issuance/" + framework.GenericNameRegex("jti"),
+
+		Fields: map[string]*framework.FieldSchema{
+			"jti": {
+				Type:        framework.TypeString,
+				Description: "The jti of the issued token",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathIssuanceRead,
+				Summary:  "Read an issued token from the issuance log",
+			},
+		},
+
+		HelpSynopsis:    "Read an issued token",
+		HelpDescription: "Returns the issuance log entry of the token with the given jti: its role, actor entity, subject hash, scopes and lifetime.",
+	}
+}
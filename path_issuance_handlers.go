@@ -0,0 +1,77 @@
+package tokenexchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// responseData formats an issuance log entry for the issuance endpoints
+func (e *issuanceLogEntry) responseData() map[string]any {
+	return map[string]any{
+		"jti":          e.JTI,
+		"issued_at":    e.IssuedAt.UTC().Format(time.RFC3339),
+		"expires_at":   e.ExpiresAt.UTC().Format(time.RFC3339),
+		"role":         e.Role,
+		"entity_id":    e.EntityID,
+		"subject_hash": e.SubjectHash,
+		"scopes":       e.Scopes,
+		"txn":          e.Txn,
+	}
+}
+
+// pathIssuanceList handles listing the issuance log
+func (b *Backend) pathIssuanceList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	filter := &issuanceLogFilter{
+		role:     data.Get("role").(string),
+		entityID: data.Get("entity_id").(string),
+	}
+	if subject := data.Get("subject").(string); subject != "" {
+		filter.subjectHash = hashSubject(subject)
+	}
+	if start, ok := data.GetOk("start"); ok {
+		filter.start = start.(time.Time)
+	}
+	if end, ok := data.GetOk("end"); ok {
+		filter.end = end.(time.Time)
+	}
+	if !filter.end.IsZero() && !filter.start.Before(filter.end) {
+		return logical.ErrorResponse("start must be before end"), nil
+	}
+
+	entries, err := listIssuanceLog(ctx, req.Storage, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(entries))
+	keyInfo := make(map[string]any, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.JTI)
+		keyInfo[entry.JTI] = entry.responseData()
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathIssuanceRead handles reading an issuance log entry by jti
+func (b *Backend) pathIssuanceRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := findIssuanceLogEntry(ctx, req.Storage, data.Get("jti").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: entry.responseData(),
+	}, nil
+}
@@ -0,0 +1,97 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// listIssuances lists the issuance log with the given filters
+func listIssuances(t *testing.T, b *Backend, storage logical.Storage, filters map[string]any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "issuances/",
+		Storage:   storage,
+		Data:      filters,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestIssuanceLog(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	// Tokens are not logged until the log is enabled
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Nil(t, listIssuances(t, b, storage, nil))
+
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"issuance_log": true}))
+	start := time.Now()
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	jti := parseIssuedToken(t, b, storage, resp.Data["token"].(string))["jti"].(string)
+
+	claims := defaultSubjectClaims()
+	claims["sub"] = "alice"
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	aliceJTI := parseIssuedToken(t, b, storage, resp.Data["token"].(string))["jti"].(string)
+
+	resp = listIssuances(t, b, storage, nil)
+	require.Equal(t, []string{jti, aliceJTI}, resp.Data["keys"])
+
+	resp = listIssuances(t, b, storage, map[string]any{"subject": "alice", "entity_id": "test-entity", "start": start.Format(time.RFC3339Nano)})
+	require.Equal(t, []string{aliceJTI}, resp.Data["keys"])
+	info := resp.Data["key_info"].(map[string]any)[aliceJTI].(map[string]any)
+	require.Equal(t, hashSubject("alice"), info["subject_hash"])
+	require.Equal(t, []string{"urn:documents:read"}, info["scopes"])
+
+	require.Nil(t, listIssuances(t, b, storage, map[string]any{"role": "other-role"}))
+	require.Nil(t, listIssuances(t, b, storage, map[string]any{"end": start.Format(time.RFC3339Nano)}))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "issuance/" + jti, Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, jti, resp.Data["jti"])
+	require.Equal(t, "test-role", resp.Data["role"])
+	require.Equal(t, "test-entity", resp.Data["entity_id"])
+	require.Equal(t, hashSubject("user-123"), resp.Data["subject_hash"])
+	require.NotContains(t, resp.Data, "subject")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "issuance/unknown", Storage: storage})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+// TestTidyIssuanceLog tests that tidy deletes entries past the retention and
+// the oldest entries beyond the bound
+func TestTidyIssuanceLog(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"issuance_log": true, "issuance_log_max_entries": 2}))
+
+	now := time.Now()
+	for i, jti := range []string{"expired", "oldest", "older", "recent"} {
+		issuedAt := now.Add(time.Duration(i-4) * time.Minute)
+		if jti == "expired" {
+			issuedAt = now.Add(-defaultRecordRetention - time.Hour)
+		}
+		require.NoError(t, putIssuanceLogEntry(ctx, storage, &issuanceLogEntry{JTI: jti, IssuedAt: issuedAt}))
+	}
+
+	deleted, err := b.tidyIssuanceLog(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	entries, err := listIssuanceLog(ctx, storage, &issuanceLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "older", entries[0].JTI)
+	require.Equal(t, "recent", entries[1].JTI)
+}
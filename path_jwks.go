@@ -20,14 +20,42 @@ func pathJWKS(b *Backend) *framework.Path {
 
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback:                    b.pathJWKSRead,
-				Summary:                     "Get JSON Web Key Set (JWKS) for token verification",
-				ForwardPerformanceStandby:   true,
-				ForwardPerformanceSecondary: true,
+				Callback: b.pathJWKSRead,
+				Summary:  "Get JSON Web Key Set (JWKS) for token verification",
 			},
 		},
 
 		HelpSynopsis:    "Retrieve public keys for JWT verification",
-		HelpDescription: "Returns a JWKS (JSON Web Key Set) containing public keys for verifying tokens generated by this plugin. This endpoint is publicly accessible (unauthenticated) to allow external services to verify JWT signatures. Supports optional 'kid' query parameter to filter by key ID.",
+		HelpDescription: "Returns a JWKS (JSON Web Key Set) containing public keys for verifying tokens generated by this plugin. Keys that belong to a tenant are published at jwks/<tenant> instead. This endpoint is publicly accessible (unauthenticated) to allow external services to verify JWT signatures. Supports optional 'kid' query parameter to filter by key ID.",
+	}
+}
+
+// pathTenantJWKS returns the path configuration for /jwks/:tenant endpoint
+func pathTenantJWKS(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "jwks/" + framework.GenericNameRegex("tenant"),
+
+		Fields: map[string]*framework.FieldSchema{
+			"tenant": {
+				Type:        framework.TypeString,
+				Description: "Tenant whose keys are returned",
+				Required:    true,
+			},
+			"kid": {
+				Type:        framework.TypeString,
+				Description: "Optional: Filter by specific key ID",
+				Query:       true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathTenantJWKSRead,
+				Summary:  "Get the JSON Web Key Set (JWKS) of a tenant",
+			},
+		},
+
+		HelpSynopsis:    "Retrieve a tenant's public keys for JWT verification",
+		HelpDescription: "Returns a JWKS containing only the public keys of the tenant's keys, which verify the tokens of the tenant's roles. This endpoint is publicly accessible (unauthenticated).",
 	}
 }
@@ -2,23 +2,62 @@ package tokenexchange
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+// defaultJWKSMaxAge is how long clients may cache the JWKS when no key is
+// scheduled for automatic rotation
+const defaultJWKSMaxAge = time.Hour
+
+// verificationURL returns the URL of this mount's JWKS for the role's
+// verification_hint, or "" when the role has none. It is built only from the
+// operator's api_addr and the mount path, never from request data.
+func verificationURL(config *Config, role *Role, mountPoint string) (string, error) {
+	if role.VerificationHint == "" {
+		return "", nil
+	}
+	if config.APIAddr == "" {
+		return "", fmt.Errorf("verification_hint requires api_addr in the config")
+	}
+	return jwksURL(config, role.Tenant, mountPoint)
+}
+
+// jwksURL returns the URL of the JWKS of tenant, the mount's shared JWKS when
+// tenant is empty
+func jwksURL(config *Config, tenant, mountPoint string) (string, error) {
+	mountPath := strings.Trim(mountPoint, "/")
+	if mountPath == "" {
+		return "", fmt.Errorf("mount path is unknown")
+	}
+	uri := config.APIAddr + "/v1/" + mountPath + "/jwks"
+	if tenant != "" {
+		uri += "/" + tenant
+	}
+	return uri, nil
+}
+
 // pathJWKSRead handles reading the JWKS endpoint
 func (b *Backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	// Get optional kid filter from query params
-	kidFilter, _ := data.GetOk("kid")
-	var kidFilterStr string
-	if kidFilter != nil {
-		kidFilterStr = kidFilter.(string)
-	}
+	return b.readJWKS(ctx, req, data.Get("kid").(string), "")
+}
+
+// pathTenantJWKSRead handles reading the JWKS of a tenant
+func (b *Backend) pathTenantJWKSRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.readJWKS(ctx, req, data.Get("kid").(string), data.Get("tenant").(string))
+}
+
+// readJWKS returns the JWKS of the keys of tenant, optionally filtered to one kid
+func (b *Backend) readJWKS(ctx context.Context, req *logical.Request, kidFilterStr, tenant string) (*logical.Response, error) {
 
 	// List all keys
 	keyNames, err := req.Storage.List(ctx, keyStoragePrefix)
@@ -32,6 +71,10 @@ func (b *Backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *
 	}
 
 	keys := jwks["keys"].([]map[string]any)
+	now := time.Now()
+
+	// Clients may cache the JWKS until the earliest scheduled rotation
+	maxAge := defaultJWKSMaxAge
 
 	for _, keyName := range keyNames {
 		key, err := b.getKey(ctx, req.Storage, keyName)
@@ -39,32 +82,45 @@ func (b *Backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *
 			return nil, fmt.Errorf("failed to load key %q: %w", keyName, err)
 		}
 
-		if key == nil {
+		if key == nil || key.Tenant != tenant {
 			continue
 		}
 
-		// Apply kid filter if specified
-		if kidFilterStr != "" && key.KeyID != kidFilterStr {
-			continue
+		if next := key.nextRotation(); !next.IsZero() {
+			maxAge = min(maxAge, max(next.Sub(now), 0))
 		}
 
-		// Extract public key
-		publicKey, err := publicKeyFromPrivate(key.PrivateKey)
+		// Extract public key of the latest version
+		publicKey, err := key.publicKey()
 		if err != nil {
 			return nil, fmt.Errorf("failed to extract public key from %q: %w", keyName, err)
 		}
 
-		// Convert to JWK format (RFC 7517)
-		jwk := map[string]any{
-			"kty": "RSA",
-			"use": "sig",
-			"alg": key.Algorithm,
-			"kid": key.KeyID,
-			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
-			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		if (kidFilterStr == "" || key.KeyID == kidFilterStr) && key.publishesLatestVersion(now) {
+			jwk := rsaJWK(key.KeyID, key.Algorithm, publicKey)
+			if err := addCertificateFields(jwk, key.Certificate); err != nil {
+				return nil, fmt.Errorf("failed to parse certificate of %q: %w", keyName, err)
+			}
+			keys = append(keys, jwk)
 		}
 
-		keys = append(keys, jwk)
+		// Rotated versions remain published until their verification window ends
+		for _, version := range key.verificationVersions(now) {
+			if kidFilterStr != "" && version.KeyID != kidFilterStr {
+				continue
+			}
+
+			publicKey, err := parsePublicKeyPEM(version.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key %q: %w", version.KeyID, err)
+			}
+
+			jwk := rsaJWK(version.KeyID, key.Algorithm, publicKey)
+			if err := addCertificateFields(jwk, version.Certificate); err != nil {
+				return nil, fmt.Errorf("failed to parse certificate %q: %w", version.KeyID, err)
+			}
+			keys = append(keys, jwk)
+		}
 	}
 
 	jwks["keys"] = keys
@@ -77,11 +133,29 @@ func (b *Backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *
 		return nil, fmt.Errorf("failed to marshal JWKS: %w", err)
 	}
 
+	maxAgeSeconds := int(maxAge.Seconds())
+
 	return &logical.Response{
 		Data: map[string]any{
-			logical.HTTPContentType: "application/json",
-			logical.HTTPRawBody:     jwksJSON,
-			logical.HTTPStatusCode:  200,
+			logical.HTTPContentType:        "application/json",
+			logical.HTTPRawBody:            jwksJSON,
+			logical.HTTPStatusCode:         200,
+			logical.HTTPCacheControlHeader: fmt.Sprintf("max-age=%d", maxAgeSeconds),
+		},
+		Headers: map[string][]string{
+			"Expires": {now.Add(time.Duration(maxAgeSeconds) * time.Second).UTC().Format(http.TimeFormat)},
 		},
 	}, nil
 }
+
+// rsaJWK converts an RSA public key to JWK format (RFC 7517)
+func rsaJWK(kid, algorithm string, publicKey *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": algorithm,
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
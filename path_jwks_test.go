@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/require"
@@ -213,3 +216,44 @@ func TestPathJWKSRead_ValidFormat(t *testing.T) {
 	// Note: We can't verify exact values match since Vault auto-generates keys
 	// But we verified the format is correct and values are present
 }
+
+func TestPathJWKSRead_CacheHeaders(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	createTestKey(t, b, storage, "manual-key")
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "jwks",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, "max-age=3600", resp.Data[logical.HTTPCacheControlHeader], "Manually rotated keys use the default max-age")
+	require.Len(t, resp.Headers["Expires"], 1)
+
+	// A key rotating every 10 minutes lowers the max-age to its next rotation
+	keyReq := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/auto-key",
+		Storage:   storage,
+		Data: map[string]any{
+			"rotation_period": "10m",
+		},
+	}
+	_, err = b.HandleRequest(context.Background(), keyReq)
+	require.NoError(t, err)
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+
+	var maxAge int
+	_, err = fmt.Sscanf(resp.Data[logical.HTTPCacheControlHeader].(string), "max-age=%d", &maxAge)
+	require.NoError(t, err)
+	require.LessOrEqual(t, maxAge, 600)
+	require.Greater(t, maxAge, 500)
+
+	expires, err := http.ParseTime(resp.Headers["Expires"][0])
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Duration(maxAge)*time.Second), expires, 2*time.Second)
+}
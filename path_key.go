@@ -20,14 +20,47 @@ func pathKey(b *Backend) *framework.Path {
 			},
 			"algorithm": {
 				Type:        framework.TypeString,
-				Description: "Signing algorithm: RS256, RS384, or RS512",
+				Description: "Signing algorithm: RS256, RS384, RS512, PS256, PS384, or PS512. The PS algorithms use RSA-PSS",
 				Default:     AlgorithmRS256,
 			},
 			"key_size": {
 				Type:        framework.TypeInt,
-				Description: "RSA key size in bits (2048, 3072, or 4096)",
+				Description: "RSA key size in bits (2048, 3072, or 4096). Ignored for transit keys",
 				Default:     DefaultKeySize,
 			},
+			"key_type": {
+				Type:        framework.TypeString,
+				Description: "Where the private key lives: 'internal' generates and stores it in this plugin, 'transit' signs with an RSA key in a transit secrets engine and 'managed' with a Vault Enterprise managed key, so the private key never leaves them",
+				Default:     KeyTypeInternal,
+			},
+			"transit_mount": {
+				Type:        framework.TypeString,
+				Description: "Mount path of the transit secrets engine holding transit_key. Required for key_type transit",
+			},
+			"transit_key": {
+				Type:        framework.TypeString,
+				Description: "Name of the RSA transit key that signs tokens. Required for key_type transit",
+			},
+			"managed_key_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the RSA managed key that signs tokens. The managed key must be allowed on this mount. Required for key_type managed",
+			},
+			"verification_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long a rotated key version remains in the JWKS so that tokens it signed can still be verified. Defaults to the config's default_verification_ttl",
+			},
+			"rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the key is automatically rotated. 0 disables automatic rotation",
+				Default:     0,
+			},
+			"tenant": tenantField("key"),
+			"cas":    casField("key"),
+			"force": {
+				Type:        framework.TypeBool,
+				Description: "Delete the key even if roles still reference it",
+				Query:       true,
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -50,7 +83,169 @@ func pathKey(b *Backend) *framework.Path {
 		},
 
 		HelpSynopsis:    "Manage named signing keys for token generation",
-		HelpDescription: "Create, read, and delete RSA signing keys. Keys are automatically generated and securely stored. Private keys are never exposed via the API.",
+		HelpDescription: "Create, read, and delete RSA signing keys. Internal keys are automatically generated and securely stored; transit and managed keys sign with a key in a transit secrets engine or an HSM or KMS. Private keys are never exposed via the API.",
+	}
+}
+
+// pathKeyRotate returns path configuration for /key/:name/rotate endpoint
+func pathKeyRotate(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("name") + "/rotate",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the signing key",
+				Required:    true,
+			},
+			"cas": casField("key"),
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKeyRotate,
+				Summary:  "Rotate a signing key to a new version",
+			},
+		},
+
+		HelpSynopsis:    "Rotate a signing key",
+		HelpDescription: "Generates a new version of the key which is used for all new tokens. Transit keys are rotated in the transit engine; managed keys cannot be rotated here. Previous versions remain in the JWKS for the key's verification_ttl so in-flight tokens can still be verified.",
+	}
+}
+
+// pathKeyCertificate returns path configuration for /key/:name/certificate endpoint
+func pathKeyCertificate(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("name") + "/certificate",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the signing key",
+				Required:    true,
+			},
+			"certificate": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded X.509 certificate for the key's latest version. Its public key must match the key",
+			},
+			"generate": {
+				Type:        framework.TypeBool,
+				Description: "Generate a self-signed certificate instead of uploading one. Only internal keys can generate certificates; they are regenerated on rotation",
+			},
+			"common_name": {
+				Type:        framework.TypeString,
+				Description: "Subject common name of a generated certificate. Defaults to the key name",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Validity of a generated certificate",
+				Default:     "8760h",
+			},
+			"cas": casField("key"),
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKeyCertificateRead,
+				Summary:  "Read the certificate of a signing key's latest version",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKeyCertificateWrite,
+				Summary:  "Upload or generate a certificate for a signing key",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathKeyCertificateDelete,
+				Summary:  "Remove the certificate of a signing key",
+			},
+		},
+
+		HelpSynopsis:    "Manage the X.509 certificate of a signing key",
+		HelpDescription: "Attaches an X.509 certificate to the key's latest version. The certificate is published as x5c and x5t#S256 in the JWKS, and roles with include_x5c add them to the token header. Uploaded certificates are dropped when the key rotates.",
+	}
+}
+
+// pathKeyConfig returns path configuration for /key/:name/config endpoint
+func pathKeyConfig(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("name") + "/config",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the signing key",
+				Required:    true,
+			},
+			"enabled": {
+				Type:        framework.TypeBool,
+				Description: "Whether the key signs tokens. A disabled key's latest version stays in the JWKS for its verification_ttl",
+			},
+			"cas": casField("key"),
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathKeyConfigWrite,
+				Summary:  "Enable or disable a signing key",
+			},
+		},
+
+		HelpSynopsis:    "Enable or disable a signing key",
+		HelpDescription: "Disabling a key is an emergency brake short of deletion: exchanges and audit exports that sign with it fail and it is neither rotated nor automatically rotated, while tokens it already signed keep verifying until its verification_ttl ends. Enabling it restores signing.",
+	}
+}
+
+// pathKeyVersionList returns path configuration for /key/:name/versions endpoint
+func pathKeyVersionList(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("name") + "/versions/?$",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the signing key",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathKeyVersionList,
+				Summary:  "List the versions of a signing key",
+			},
+		},
+
+		HelpSynopsis:    "List the versions of a signing key",
+		HelpDescription: "Lists the retained versions of a key, oldest first, with their kid, status and lifetime. The latest version is active and signs new tokens; rotated versions are verification-only while they are in the JWKS and expired afterwards, until tidy deletes them.",
+	}
+}
+
+// pathKeyVersion returns path configuration for /key/:name/versions/:version endpoint
+func pathKeyVersion(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("name") + "/versions/(?P<version>\\d+)",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the signing key",
+				Required:    true,
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "Version of the signing key",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathKeyVersionRead,
+				Summary:  "Read a version of a signing key",
+			},
+		},
+
+		HelpSynopsis:    "Read a version of a signing key",
+		HelpDescription: "Returns a version's kid, status, creation and expiry times, public key and certificate, e.g. to check which key a token's kid refers to.",
 	}
 }
 
@@ -59,6 +254,14 @@ func pathKeyList(b *Backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "key/?$",
 
+		Fields: map[string]*framework.FieldSchema{
+			"tenant": {
+				Type:        framework.TypeString,
+				Description: "Only list the keys of this tenant. Empty lists the shared keys",
+				Query:       true,
+			},
+		},
+
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.pathKeyList,
@@ -67,6 +270,6 @@ func pathKeyList(b *Backend) *framework.Path {
 		},
 
 		HelpSynopsis:    "List signing keys",
-		HelpDescription: "List all configured signing keys with metadata.",
+		HelpDescription: "List all configured signing keys, or those of one tenant.",
 	}
 }
@@ -0,0 +1,212 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyCertificate writes to the certificate endpoint of a key
+func writeKeyCertificate(t *testing.T, b *Backend, storage logical.Storage, name string, data map[string]any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/" + name + "/certificate",
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// certificateForKey returns a PEM certificate for the latest version of the
+// stored key, issued by a throwaway CA
+func certificateForKey(t *testing.T, b *Backend, storage logical.Storage, name string) string {
+	key, err := b.getKey(context.Background(), storage, name)
+	require.NoError(t, err)
+	publicKey, err := key.publicKey()
+	require.NoError(t, err)
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "delegation.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, publicKey, caKey)
+	require.NoError(t, err)
+	return encodeCertificatePEM(der)
+}
+
+// jwksEntry returns the JWKS entry with the given kid
+func jwksEntry(t *testing.T, b *Backend, storage logical.Storage, kid string) map[string]any {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "jwks", Storage: storage})
+	require.NoError(t, err)
+	for _, jwk := range extractJWKSFromResponse(t, resp)["keys"].([]map[string]any) {
+		if jwk["kid"] == kid {
+			return jwk
+		}
+	}
+	t.Fatalf("key with kid %s not found in JWKS", kid)
+	return nil
+}
+
+// requireCertificateFields checks the x5c and x5t#S256 members of a JWK
+// against a PEM certificate
+func requireCertificateFields(t *testing.T, jwk map[string]any, certificatePEM string) {
+	certificate, err := parseCertificatePEM(certificatePEM)
+	require.NoError(t, err)
+	require.Equal(t, []any{base64.StdEncoding.EncodeToString(certificate.Raw)}, jwk["x5c"])
+	require.Equal(t, certificateThumbprint(certificate), jwk["x5t#S256"])
+}
+
+func TestKeyCertificate(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		createTestKey(t, b, storage, "test-key")
+
+		resp := writeKeyCertificate(t, b, storage, "test-key", map[string]any{"generate": true, "common_name": "delegation.example.com", "ttl": "720h"})
+		require.False(t, resp.IsError(), "certificate write failed: %v", resp.Error())
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/test-key/certificate", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, "CN=delegation.example.com", resp.Data["subject"])
+		require.Equal(t, true, resp.Data["generated"])
+		certificatePEM := resp.Data["certificate"].(string)
+
+		certificate, err := parseCertificatePEM(certificatePEM)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(720*time.Hour), certificate.NotAfter, time.Minute)
+		require.NoError(t, certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature))
+
+		requireCertificateFields(t, jwksEntry(t, b, storage, "test-key-v1"), certificatePEM)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		createTestKey(t, b, storage, "test-key")
+		createTestKey(t, b, storage, "other-key")
+
+		certificatePEM := certificateForKey(t, b, storage, "test-key")
+		resp := writeKeyCertificate(t, b, storage, "test-key", map[string]any{"certificate": certificatePEM})
+		require.False(t, resp.IsError(), "certificate write failed: %v", resp.Error())
+		requireCertificateFields(t, jwksEntry(t, b, storage, "test-key-v1"), certificatePEM)
+
+		// A certificate for another key is rejected
+		resp = writeKeyCertificate(t, b, storage, "test-key", map[string]any{"certificate": certificateForKey(t, b, storage, "other-key")})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "does not match")
+
+		resp = writeKeyCertificate(t, b, storage, "test-key", map[string]any{"certificate": "not a certificate"})
+		require.True(t, resp.IsError())
+
+		resp = writeKeyCertificate(t, b, storage, "test-key", map[string]any{"certificate": certificatePEM, "generate": true})
+		require.True(t, resp.IsError())
+
+		resp = writeKeyCertificate(t, b, storage, "missing-key", map[string]any{"generate": true})
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		createTestKey(t, b, storage, "test-key")
+		resp := writeKeyCertificate(t, b, storage, "test-key", map[string]any{"generate": true})
+		require.False(t, resp.IsError())
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.DeleteOperation, Path: "key/test-key/certificate", Storage: storage})
+		require.NoError(t, err)
+
+		jwk := jwksEntry(t, b, storage, "test-key-v1")
+		require.NotContains(t, jwk, "x5c")
+		require.NotContains(t, jwk, "x5t#S256")
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		createTestKey(t, b, storage, "generated-key")
+		createTestKey(t, b, storage, "uploaded-key")
+
+		resp := writeKeyCertificate(t, b, storage, "generated-key", map[string]any{"generate": true, "common_name": "generated"})
+		require.False(t, resp.IsError())
+		generatedPEM := resp.Data["certificate"].(string)
+		uploadedPEM := certificateForKey(t, b, storage, "uploaded-key")
+		resp = writeKeyCertificate(t, b, storage, "uploaded-key", map[string]any{"certificate": uploadedPEM})
+		require.False(t, resp.IsError())
+
+		for _, name := range []string{"generated-key", "uploaded-key"} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/" + name + "/rotate", Storage: storage})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+
+		// Previous versions keep their certificates while they are published
+		requireCertificateFields(t, jwksEntry(t, b, storage, "generated-key-v1"), generatedPEM)
+		requireCertificateFields(t, jwksEntry(t, b, storage, "uploaded-key-v1"), uploadedPEM)
+
+		// A generated certificate is regenerated for the new version
+		key, err := b.getKey(context.Background(), storage, "generated-key")
+		require.NoError(t, err)
+		require.NotEmpty(t, key.Certificate)
+		certificate, err := parseCertificatePEM(key.Certificate)
+		require.NoError(t, err)
+		require.Equal(t, "generated", certificate.Subject.CommonName)
+		publicKey, err := key.publicKey()
+		require.NoError(t, err)
+		require.NoError(t, checkCertificateKey(certificate, publicKey))
+		requireCertificateFields(t, jwksEntry(t, b, storage, "generated-key-v2"), key.Certificate)
+
+		// An uploaded certificate cannot certify the new version
+		require.NotContains(t, jwksEntry(t, b, storage, "uploaded-key-v2"), "x5c")
+	})
+
+	t.Run("include x5c", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"include_x5c": true})
+
+		// The key has no certificate yet
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeServerError, false)
+
+		resp = writeKeyCertificate(t, b, storage, "test-key", map[string]any{"generate": true})
+		require.False(t, resp.IsError())
+		certificate, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		require.NoError(t, err)
+
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		// Validators can verify the token with the certificate from the header
+		parsed, err := jose.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		header := parsed.Signatures[0].Header
+		roots := x509.NewCertPool()
+		roots.AddCert(certificate)
+		chains, err := header.Certificates(x509.VerifyOptions{Roots: roots})
+		require.NoError(t, err)
+		require.True(t, chains[0][0].Equal(certificate))
+		require.Equal(t, certificateThumbprint(certificate), header.ExtraHeaders["x5t#S256"])
+		_, err = parsed.Verify(chains[0][0].PublicKey)
+		require.NoError(t, err)
+	})
+}
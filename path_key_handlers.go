@@ -2,9 +2,11 @@ package tokenexchange
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -35,27 +37,44 @@ func (b *Backend) pathKeyRead(ctx context.Context, req *logical.Request, data *f
 	}
 
 	// Extract public key for response
-	publicKey, err := publicKeyFromPrivate(key.PrivateKey)
+	publicKey, err := key.publicKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract public key: %w", err)
 	}
 
-	// Encode public key to PEM
-	pubKeyBytes := x509.MarshalPKCS1PublicKey(publicKey)
-	pubKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: pubKeyBytes,
-	})
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Key IDs of rotated versions still published in the JWKS
+	verificationKeyIDs := []string{}
+	for _, v := range key.verificationVersions(time.Now()) {
+		verificationKeyIDs = append(verificationKeyIDs, v.KeyID)
+	}
 
 	return &logical.Response{
 		Data: map[string]any{
-			"name":       key.Name,
-			"key_id":     key.KeyID,
-			"algorithm":  key.Algorithm,
-			"public_key": string(pubKeyPEM),
-			"created_at": key.CreatedAt.Format(time.RFC3339),
-			"rotated_at": key.RotatedAt.Format(time.RFC3339),
-			"version":    key.Version,
+			"name":                 key.Name,
+			"key_id":               key.KeyID,
+			"algorithm":            key.Algorithm,
+			"public_key":           encodePublicKeyPEM(publicKey),
+			"created_at":           key.CreatedAt.Format(time.RFC3339),
+			"rotated_at":           key.RotatedAt.Format(time.RFC3339),
+			"version":              key.Version,
+			"verification_ttl":     durationSeconds(key.verificationTTL(config)),
+			"rotation_period":      durationSeconds(key.RotationPeriod),
+			"next_rotation":        formatOptionalTime(key.nextRotation()),
+			"verification_key_ids": verificationKeyIDs,
+			"key_type":             keyTypeOrDefault(key.KeyType),
+			"transit_mount":        key.TransitMount,
+			"transit_key":          key.TransitKey,
+			"managed_key_name":     key.ManagedKeyName,
+			"certificate":          key.Certificate,
+			"tenant":               key.Tenant,
+			"enabled":              !key.Disabled,
+			"disabled_expires_at":  formatOptionalTime(key.DisabledExpiresAt),
+			"cas_version":          key.CASVersion,
 			// Note: private_key is NEVER returned
 		},
 	}, nil
@@ -65,77 +84,596 @@ func (b *Backend) pathKeyRead(ctx context.Context, req *logical.Request, data *f
 func (b *Backend) pathKeyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	// Check if key already exists
 	existingKey, err := b.getKey(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := checkCAS(requestCAS(data), 0); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if existingKey != nil {
+		// Repeating the create that made the key changes nothing
+		if keyMatchesRequest(existingKey, data) {
+			return keyWriteResponse(existingKey), nil
+		}
 		return logical.ErrorResponse("key %q already exists. To rotate, use POST /key/%s/rotate", name, name), nil
 	}
 
 	// Get algorithm
 	algorithm := data.Get("algorithm").(string)
-	if algorithm != AlgorithmRS256 && algorithm != AlgorithmRS384 && algorithm != AlgorithmRS512 {
-		return logical.ErrorResponse("algorithm must be RS256, RS384, or RS512"), nil
+	if _, err := signatureAlgorithm(algorithm); err != nil {
+		return logical.ErrorResponse("algorithm must be RS256, RS384, RS512, PS256, PS384, or PS512"), nil
 	}
 
-	// Generate new key
-	keySize := data.Get("key_size").(int)
-	if keySize != 2048 && keySize != 3072 && keySize != 4096 {
-		return logical.ErrorResponse("key_size must be 2048, 3072, or 4096"), nil
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
 	}
 
-	privateKey, err := generateRSAKey(keySize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	// Keys without a verification_ttl follow the config's default
+	var verificationTTL time.Duration
+	if ttl, ok := data.GetOk("verification_ttl"); ok {
+		verificationTTL = time.Duration(ttl.(int)) * time.Second
+		if verificationTTL <= 0 {
+			return logical.ErrorResponse("verification_ttl must be greater than zero"), nil
+		}
+		if maxTTL := config.maxVerificationTTL(); maxTTL > 0 && verificationTTL > maxTTL {
+			return logical.ErrorResponse("verification_ttl must not exceed the config's max_verification_ttl of %s", maxTTL), nil
+		}
+	}
+
+	rotationPeriod := time.Duration(data.Get("rotation_period").(int)) * time.Second
+	if rotationPeriod < 0 {
+		return logical.ErrorResponse("rotation_period must not be negative"), nil
 	}
 
-	privateKeyPEM := encodePrivateKeyPEM(privateKey)
+	tenant := data.Get("tenant").(string)
+	if err := validateTenant(tenant); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	// Create key object
 	now := time.Now()
 	key := &Key{
-		Name:       name,
-		KeyID:      generateKeyID(name, 1), // Version 1
-		Algorithm:  algorithm,
-		PrivateKey: privateKeyPEM,
-		CreatedAt:  now,
-		RotatedAt:  now,
-		Version:    1,
+		Name:            name,
+		Tenant:          tenant,
+		Algorithm:       algorithm,
+		CreatedAt:       now,
+		RotatedAt:       now,
+		VerificationTTL: verificationTTL,
+		RotationPeriod:  rotationPeriod,
+	}
+
+	switch keyType := data.Get("key_type").(string); keyType {
+	case KeyTypeInternal:
+		// Generate new key
+		keySize := data.Get("key_size").(int)
+		if keySize != 2048 && keySize != 3072 && keySize != 4096 {
+			return logical.ErrorResponse("key_size must be 2048, 3072, or 4096"), nil
+		}
+
+		privateKey, err := generateRSAKey(keySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+
+		key.PrivateKey = encodePrivateKeyPEM(privateKey)
+		key.Version = 1
+	case KeyTypeTransit:
+		key.KeyType = KeyTypeTransit
+		key.TransitMount = strings.Trim(data.Get("transit_mount").(string), "/")
+		key.TransitKey = data.Get("transit_key").(string)
+		if key.TransitMount == "" || key.TransitKey == "" {
+			return logical.ErrorResponse("transit keys require transit_mount and transit_key"), nil
+		}
+
+		// The transit key's latest version becomes the key's first version
+		client, err := b.newTransitClient(config, key)
+		if err != nil {
+			return logical.ErrorResponse("%v", err), nil
+		}
+		version, publicKey, err := client.readKey(ctx)
+		if errors.Is(err, errTransitUnavailable) {
+			return nil, err
+		}
+		if err != nil {
+			return logical.ErrorResponse("failed to read transit key: %v", err), nil
+		}
+
+		key.PublicKey = encodePublicKeyPEM(publicKey)
+		key.Version = version
+	case KeyTypeManaged:
+		key.KeyType = KeyTypeManaged
+		key.ManagedKeyName = data.Get("managed_key_name").(string)
+		if key.ManagedKeyName == "" {
+			return logical.ErrorResponse("managed keys require managed_key_name"), nil
+		}
+		// Managed keys are rotated in their KMS, not on a schedule here
+		if rotationPeriod > 0 {
+			return logical.ErrorResponse("rotation_period cannot be set for managed keys"), nil
+		}
+
+		publicKey, err := b.readManagedKey(ctx, key.ManagedKeyName)
+		if err != nil {
+			return logical.ErrorResponse("failed to read managed key: %v", err), nil
+		}
+
+		key.PublicKey = encodePublicKeyPEM(publicKey)
+		key.Version = 1
+	default:
+		return logical.ErrorResponse("key_type must be %q, %q or %q", KeyTypeInternal, KeyTypeTransit, KeyTypeManaged), nil
 	}
+	key.KeyID = generateKeyID(name, key.Version)
 
 	// Store key
-	entry, err := logical.StorageEntryJSON(keyStoragePrefix+name, key)
+	if err := b.putKey(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+
+	return keyWriteResponse(key), nil
+}
+
+// keyWriteResponse returns the response to a key create
+func keyWriteResponse(key *Key) *logical.Response {
+	return &logical.Response{
+		Data: map[string]any{
+			"name":        key.Name,
+			"key_id":      key.KeyID,
+			"version":     key.Version,
+			"cas_version": key.CASVersion,
+		},
+	}
+}
+
+// keyMatchesRequest reports whether an existing key was created with the
+// parameters of a create request. A key_size that is not set matches any size.
+func keyMatchesRequest(key *Key, data *framework.FieldData) bool {
+	var verificationTTL time.Duration
+	if ttl, ok := data.GetOk("verification_ttl"); ok {
+		verificationTTL = time.Duration(ttl.(int)) * time.Second
+	}
+	if key.Algorithm != data.Get("algorithm").(string) ||
+		key.VerificationTTL != verificationTTL ||
+		key.RotationPeriod != time.Duration(data.Get("rotation_period").(int))*time.Second ||
+		key.Tenant != data.Get("tenant").(string) ||
+		keyTypeOrDefault(key.KeyType) != data.Get("key_type").(string) {
+		return false
+	}
+
+	switch {
+	case key.isTransit():
+		return key.TransitMount == strings.Trim(data.Get("transit_mount").(string), "/") &&
+			key.TransitKey == data.Get("transit_key").(string)
+	case key.isManaged():
+		return key.ManagedKeyName == data.Get("managed_key_name").(string)
+	}
+	if keySize, ok := data.GetOk("key_size"); ok {
+		publicKey, err := key.publicKey()
+		if err != nil || publicKey.N.BitLen() != keySize.(int) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathKeyRotate handles rotating a key to a new version
+func (b *Backend) pathKeyRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	key, err := b.rotateKey(ctx, req.Storage, name, rotationTriggerManual, requestCAS(data))
+	if errors.Is(err, errManagedKeyRotation) || errors.Is(err, errKeyDisabled) || errors.Is(err, errCASMismatch) {
+		return logical.ErrorResponse("%v", err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return logical.ErrorResponse("key %q not found", name), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"name":        key.Name,
+			"key_id":      key.KeyID,
+			"version":     key.Version,
+			"cas_version": key.CASVersion,
+		},
+	}, nil
+}
+
+// pathKeyCertificateRead handles reading the certificate of a key's latest version
+func (b *Backend) pathKeyCertificateRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	key, err := b.getKey(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Certificate == "" {
+		return nil, nil
+	}
+
+	certificate, err := parseCertificatePEM(key.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"key_id":      key.KeyID,
+			"certificate": key.Certificate,
+			"x5t#S256":    certificateThumbprint(certificate),
+			"subject":     certificate.Subject.String(),
+			"not_after":   certificate.NotAfter.Format(time.RFC3339),
+			"generated":   key.CertificateGenerated,
+		},
+	}, nil
+}
+
+// pathKeyCertificateWrite handles uploading or generating a key certificate
+func (b *Backend) pathKeyCertificateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	certificatePEM := data.Get("certificate").(string)
+	generate := data.Get("generate").(bool)
+
+	if generate == (certificatePEM != "") {
+		return logical.ErrorResponse("exactly one of certificate or generate must be set"), nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, err := b.getKey(ctx, req.Storage, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("key %q not found", name), nil
+	}
+	if err := checkCAS(requestCAS(data), key.CASVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if generate {
+		if key.isTransit() || key.isManaged() {
+			return logical.ErrorResponse("certificates can only be generated for internal keys: upload one instead"), nil
+		}
+		ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+		if ttl <= 0 {
+			return logical.ErrorResponse("ttl must be greater than zero"), nil
+		}
+		commonName := data.Get("common_name").(string)
+		if commonName == "" {
+			commonName = key.Name
+		}
+
+		privateKey, err := parsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key: %w", err)
+		}
+		certificatePEM, err = generateKeyCertificate(privateKey, commonName, time.Now(), ttl)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		certificate, err := parseCertificatePEM(certificatePEM)
+		if err != nil {
+			return logical.ErrorResponse("invalid certificate: %v", err), nil
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract public key: %w", err)
+		}
+		if err := checkCertificateKey(certificate, publicKey); err != nil {
+			return logical.ErrorResponse("%v", err), nil
+		}
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, fmt.Errorf("failed to write key: %w", err)
+	key.Certificate = certificatePEM
+	key.CertificateGenerated = generate
+	if err := b.putKey(ctx, req.Storage, key); err != nil {
+		return nil, err
 	}
 
 	return &logical.Response{
 		Data: map[string]any{
-			"name":    key.Name,
-			"key_id":  key.KeyID,
-			"version": key.Version,
+			"key_id":      key.KeyID,
+			"certificate": key.Certificate,
 		},
 	}, nil
 }
 
+// pathKeyCertificateDelete handles removing a key's certificate
+func (b *Backend) pathKeyCertificateDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, err := b.getKey(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Certificate == "" {
+		return nil, nil
+	}
+	if err := checkCAS(requestCAS(data), key.CASVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	key.Certificate = ""
+	key.CertificateGenerated = false
+	if err := b.putKey(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// rotateKey rotates the named key and persists it. Returns nil if the key does
+// not exist. A non-nil cas must match the key's cas_version.
+func (b *Backend) rotateKey(ctx context.Context, storage logical.Storage, name, trigger string, cas *int) (*Key, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, err := b.getKey(ctx, storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, nil
+	}
+	if err := checkCAS(cas, key.CASVersion); err != nil {
+		return nil, err
+	}
+
+	if key.isManaged() {
+		return nil, errManagedKeyRotation
+	}
+	if key.Disabled {
+		return nil, errKeyDisabled
+	}
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	walID, err := beginKeyWAL(ctx, storage, walKindKeyRotate, &keyWAL{Name: name, Version: key.Version})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	certificate, generated := key.Certificate, key.CertificateGenerated
+	if key.isTransit() {
+		if err := b.rotateTransitKey(ctx, config, key, now); err != nil {
+			return nil, err
+		}
+	} else if err := key.rotate(now, key.verificationTTL(config)); err != nil {
+		return nil, err
+	}
+	if err := key.rotateCertificate(certificate, generated, now); err != nil {
+		return nil, err
+	}
+
+	if err := b.putKey(ctx, storage, key); err != nil {
+		return nil, err
+	}
+	b.endKeyWAL(ctx, storage, walID)
+
+	b.recordKeyRotation(name, trigger)
+
+	b.publishEvent(ctx, config, eventKeyRotate, map[string]string{
+		"key":     name,
+		"key_id":  key.KeyID,
+		"version": strconv.Itoa(key.Version),
+		"trigger": trigger,
+	})
+
+	return key, nil
+}
+
+// rotateDueKeys rotates keys whose rotation_period has elapsed. It runs from the
+// backend's periodic function.
+func (b *Backend) rotateDueKeys(ctx context.Context, req *logical.Request) error {
+	select {
+	case <-b.stopCh:
+		return nil
+	default:
+	}
+
+	keyNames, err := req.Storage.List(ctx, keyStoragePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	now := time.Now()
+	for _, name := range keyNames {
+		key, err := b.getKey(ctx, req.Storage, name)
+		if err != nil {
+			return err
+		}
+
+		if key == nil {
+			continue
+		}
+
+		next := key.nextRotation()
+		if next.IsZero() || now.Before(next) {
+			continue
+		}
+
+		rotated, err := b.rotateKey(ctx, req.Storage, name, rotationTriggerAutomatic, nil)
+		if err != nil {
+			b.Logger().Error("automatic key rotation failed", "key", name, "error", err)
+			continue
+		}
+		if rotated != nil {
+			b.Logger().Info("automatically rotated key", "key", name, "key_id", rotated.KeyID)
+		}
+	}
+
+	return nil
+}
+
 // pathKeyDelete handles deleting a key
 func (b *Backend) pathKeyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
-	// Check if any roles use this key (Phase 2 addition)
-	// For now, just delete
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.AuditKey == name {
+		return logical.ErrorResponse("key %q is the audit key. Unset audit_key before deleting it", name), nil
+	}
+	if config != nil && config.DefaultKey == name && !data.Get("force").(bool) {
+		return logical.ErrorResponse("key %q is the default_key. Set another default_key or set force=true to delete anyway", name), nil
+	}
+
+	// Refuse to delete a key that roles still sign with, unless forced
+	roles, err := b.rolesReferencingKey(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) > 0 {
+		if !data.Get("force").(bool) {
+			return logical.ErrorResponse("key %q is in use by roles: %s. Update the roles or set force=true to delete anyway", name, strings.Join(roles, ", ")), nil
+		}
+		b.Logger().Warn("force deleting key referenced by roles", "key", name, "roles", roles)
+	}
 
 	if err := req.Storage.Delete(ctx, keyStoragePrefix+name); err != nil {
 		return nil, fmt.Errorf("failed to delete key: %w", err)
 	}
 
+	b.resetKeyCache(name)
+
+	b.publishEvent(ctx, config, eventKeyDelete, map[string]string{"key": name})
+
+	return nil, nil
+}
+
+// keyVersionResponseData formats a key version for the versions endpoints
+func keyVersionResponseData(key *Key, version *KeyVersion, now time.Time) map[string]any {
+	return map[string]any{
+		"version":    version.Version,
+		"key_id":     version.KeyID,
+		"status":     version.status(key, now),
+		"created_at": version.CreatedAt.Format(time.RFC3339),
+		"expires_at": formatOptionalTime(version.ExpiresAt),
+	}
+}
+
+// pathKeyConfigWrite handles enabling and disabling a key. A disabled key's
+// latest version stays in the JWKS for its verification TTL, so tokens it
+// signed before being disabled still verify.
+func (b *Backend) pathKeyConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, err := b.getKey(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return logical.ErrorResponse("key %q not found", name), nil
+	}
+	if err := checkCAS(requestCAS(data), key.CASVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if enabled, ok := data.GetOk("enabled"); ok && enabled.(bool) == key.Disabled {
+		key.Disabled = !enabled.(bool)
+		key.DisabledExpiresAt = time.Time{}
+		if key.Disabled {
+			config, err := b.getConfig(ctx, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			key.DisabledExpiresAt = time.Now().Add(key.verificationTTL(config))
+		}
+		if err := b.putKey(ctx, req.Storage, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"name":                key.Name,
+			"enabled":             !key.Disabled,
+			"disabled_expires_at": formatOptionalTime(key.DisabledExpiresAt),
+			"cas_version":         key.CASVersion,
+		},
+	}, nil
+}
+
+// pathKeyVersionList handles listing the versions of a key
+func (b *Backend) pathKeyVersionList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := b.getKey(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	versions, err := key.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(versions))
+	keyInfo := make(map[string]any, len(versions))
+	for _, version := range versions {
+		id := strconv.Itoa(version.Version)
+		keys = append(keys, id)
+		keyInfo[id] = keyVersionResponseData(key, version, now)
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathKeyVersionRead handles reading a version of a key
+func (b *Backend) pathKeyVersionRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := b.getKey(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	versions, err := key.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	number := data.Get("version").(int)
+	for _, version := range versions {
+		if version.Version != number {
+			continue
+		}
+
+		respData := keyVersionResponseData(key, version, time.Now())
+		respData["public_key"] = version.PublicKey
+		respData["certificate"] = version.Certificate
+		return &logical.Response{Data: respData}, nil
+	}
+
 	return nil, nil
 }
 
@@ -146,6 +684,19 @@ func (b *Backend) pathKeyList(ctx context.Context, req *logical.Request, data *f
 		return nil, fmt.Errorf("failed to list keys: %w", err)
 	}
 
+	if tenant, ok := data.GetOk("tenant"); ok {
+		keys, err = filterByTenant(keys, tenant.(string), func(name string) (string, bool, error) {
+			key, err := b.getKey(ctx, req.Storage, name)
+			if err != nil || key == nil {
+				return "", false, err
+			}
+			return key.Tenant, true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(keys) == 0 {
 		return nil, nil
 	}
@@ -153,8 +704,66 @@ func (b *Backend) pathKeyList(ctx context.Context, req *logical.Request, data *f
 	return logical.ListResponse(keys), nil
 }
 
-// getKey retrieves a key from storage (helper)
+// rolesReferencingKey returns the names of roles that sign with the named
+// key, including roles that use it as the default key
+func (b *Backend) rolesReferencingKey(ctx context.Context, storage logical.Storage, keyName string) ([]string, error) {
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	defaultKey := ""
+	if config != nil {
+		defaultKey = config.DefaultKey
+	}
+
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	var referencing []string
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && slices.Contains(roleKeyNames(role, defaultKey), keyName) {
+			referencing = append(referencing, roleName)
+		}
+	}
+
+	return referencing, nil
+}
+
+// putKey writes a key to storage as its next cas_version (helper)
+func (b *Backend) putKey(ctx context.Context, storage logical.Storage, key *Key) error {
+	key.CASVersion++
+	entry, err := logical.StorageEntryJSON(keyStoragePrefix+key.Name, key)
+	if err != nil {
+		return fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+
+	b.resetKeyCache(key.Name)
+
+	return nil
+}
+
+// getKey retrieves a key, from the cache when possible (helper)
 func (b *Backend) getKey(ctx context.Context, storage logical.Storage, name string) (*Key, error) {
+	b.cacheLock.RLock()
+	cached, ok := b.keyCache[name]
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+	b.recordCacheLookup("key", ok)
+	if ok {
+		key := *cached
+		return &key, nil
+	}
+
 	entry, err := storage.Get(ctx, keyStoragePrefix+name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read key: %w", err)
@@ -168,6 +777,23 @@ func (b *Backend) getKey(ctx context.Context, storage logical.Storage, name stri
 	if err := entry.DecodeJSON(key); err != nil {
 		return nil, fmt.Errorf("failed to decode key: %w", err)
 	}
+	key.CASVersion = storedVersion(key.CASVersion)
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		cachedKey := *key
+		b.keyCache[name] = &cachedKey
+	}
+	b.cacheLock.Unlock()
 
 	return key, nil
 }
+
+// resetKeyCache drops the cached copy of the named key
+func (b *Backend) resetKeyCache(name string) {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	b.cacheGeneration++
+	delete(b.keyCache, name)
+}
@@ -0,0 +1,241 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackendUUID is the mount UUID the fake managed key view expects
+const testBackendUUID = "test-backend-uuid"
+
+// managedKeyView is a system view that provides one RSA managed signing key
+// named "hsm-key" to the mount testBackendUUID
+type managedKeyView struct {
+	*logical.StaticSystemView
+	key     *rsa.PrivateKey
+	failing atomic.Bool
+}
+
+func (v *managedKeyView) WithManagedSigningKeyByName(ctx context.Context, keyName, backendUUID string, f logical.ManagedSigningKeyConsumer) error {
+	if keyName != "hsm-key" || backendUUID != testBackendUUID {
+		return errors.New("no such managed key")
+	}
+	if v.failing.Load() {
+		return errors.New("kms unreachable")
+	}
+	return f(ctx, &fakeManagedKey{key: v.key})
+}
+
+func (v *managedKeyView) WithManagedKeyByName(context.Context, string, string, logical.ManagedKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedKeyByUUID(context.Context, string, string, logical.ManagedKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedSigningKeyByUUID(context.Context, string, string, logical.ManagedSigningKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedEncryptingKeyByName(context.Context, string, string, logical.ManagedEncryptingKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedEncryptingKeyByUUID(context.Context, string, string, logical.ManagedEncryptingKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedMACKeyByName(context.Context, string, string, logical.ManagedMACKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+func (v *managedKeyView) WithManagedMACKeyByUUID(context.Context, string, string, logical.ManagedMACKeyConsumer) error {
+	return errors.New("not implemented")
+}
+
+// fakeManagedKey is a managed signing key backed by an in-memory RSA key
+type fakeManagedKey struct {
+	key *rsa.PrivateKey
+}
+
+func (k *fakeManagedKey) Name() string                          { return "hsm-key" }
+func (k *fakeManagedKey) UUID() string                          { return "hsm-key-uuid" }
+func (k *fakeManagedKey) Present(context.Context) (bool, error) { return true, nil }
+
+func (k *fakeManagedKey) AllowsAll(usages []logical.KeyUsage) bool {
+	for _, usage := range usages {
+		if usage != logical.KeyUsageSign {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *fakeManagedKey) GetPublicKey(context.Context) (crypto.PublicKey, error) {
+	return &k.key.PublicKey, nil
+}
+
+func (k *fakeManagedKey) Sign(_ context.Context, value []byte, randomSource io.Reader, opts crypto.SignerOpts) ([]byte, error) {
+	return k.key.Sign(randomSource, value, opts)
+}
+
+func (k *fakeManagedKey) Verify(context.Context, []byte, []byte, crypto.SignerOpts) (bool, error) {
+	return false, errors.New("not implemented")
+}
+
+func (k *fakeManagedKey) GetSigner(context.Context) (crypto.Signer, error) {
+	return k.key, nil
+}
+
+// getManagedKeyTestBackend returns a backend whose system view provides the
+// managed key "hsm-key"
+func getManagedKeyTestBackend(t *testing.T) (*Backend, logical.Storage, *managedKeyView) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	view := &managedKeyView{
+		StaticSystemView: &logical.StaticSystemView{
+			EntityVal: &logical.Entity{ID: "test-entity", Name: "test-entity-name"},
+		},
+		key: key,
+	}
+	config := &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      view,
+		StorageView: &logical.InmemStorage{},
+		BackendUUID: testBackendUUID,
+	}
+
+	b, err := Factory(context.Background(), config)
+	require.NoError(t, err)
+	return b.(*Backend), config.StorageView, view
+}
+
+// createManagedKey creates a managed signing key
+func createManagedKey(t *testing.T, b *Backend, storage logical.Storage, name string, data map[string]any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/" + name,
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestManagedKeys(t *testing.T) {
+	setup := func(t *testing.T, algorithm string) (*Backend, logical.Storage, *rsa.PrivateKey, string, *managedKeyView) {
+		b, storage, view := getManagedKeyTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+		resp := createManagedKey(t, b, storage, "managed-key", map[string]any{
+			"key_type":         KeyTypeManaged,
+			"managed_key_name": "hsm-key",
+			"algorithm":        algorithm,
+		})
+		require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+		require.Equal(t, "managed-key-v1", resp.Data["key_id"])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+			Data: map[string]any{
+				"ttl":              "1h",
+				"key":              "managed-key",
+				"actor_template":   `{"act": {"sub": "agent-123"}}`,
+				"subject_template": `{}`,
+				"context":          "urn:documents:read",
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		return b, storage, privateKey, kid, view
+	}
+
+	t.Run("sign and verify", func(t *testing.T) {
+		for _, algorithm := range []string{AlgorithmRS256, AlgorithmPS384} {
+			t.Run(algorithm, func(t *testing.T) {
+				b, storage, privateKey, kid, _ := setup(t, algorithm)
+
+				resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				require.Equal(t, "managed-key-v1", resp.Data["key_id"])
+
+				claims := parseTransitIssuedToken(t, b, storage, resp.Data["token"].(string))
+				require.Equal(t, "user-123", claims["sub"])
+			})
+		}
+	})
+
+	t.Run("key read", func(t *testing.T) {
+		b, storage, _, _, _ := setup(t, AlgorithmRS256)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/managed-key", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, KeyTypeManaged, resp.Data["key_type"])
+		require.Equal(t, "hsm-key", resp.Data["managed_key_name"])
+		require.NotEmpty(t, resp.Data["public_key"])
+
+		key, err := b.getKey(context.Background(), storage, "managed-key")
+		require.NoError(t, err)
+		require.Empty(t, key.PrivateKey)
+	})
+
+	t.Run("rotation rejected", func(t *testing.T) {
+		b, storage, _, _, _ := setup(t, AlgorithmRS256)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/managed-key/rotate", Storage: storage})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "cannot be rotated")
+
+		resp = createManagedKey(t, b, storage, "scheduled-key", map[string]any{
+			"key_type":         KeyTypeManaged,
+			"managed_key_name": "hsm-key",
+			"rotation_period":  "24h",
+		})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "rotation_period")
+	})
+
+	t.Run("managed key unavailable", func(t *testing.T) {
+		b, storage, privateKey, kid, view := setup(t, AlgorithmRS256)
+		view.failing.Store(true)
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	})
+
+	t.Run("invalid key options", func(t *testing.T) {
+		b, storage, _ := getManagedKeyTestBackend(t)
+
+		resp := createManagedKey(t, b, storage, "managed-key", map[string]any{"key_type": KeyTypeManaged})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "managed_key_name")
+
+		resp = createManagedKey(t, b, storage, "managed-key", map[string]any{"key_type": KeyTypeManaged, "managed_key_name": "missing"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "no such managed key")
+	})
+
+	t.Run("unsupported system view", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+
+		resp := createManagedKey(t, b, storage, "managed-key", map[string]any{"key_type": KeyTypeManaged, "managed_key_name": "hsm-key"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "not supported by this Vault server")
+	})
+}
@@ -0,0 +1,380 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testTransitToken is the token the mock transit engine accepts
+const testTransitToken = "transit-token"
+
+// mockTransit emulates the key read, rotate, sign and decrypt endpoints of a
+// transit engine mounted at "transit" holding RSA key "signer"
+type mockTransit struct {
+	t        *testing.T
+	mu       sync.Mutex
+	versions []*rsa.PrivateKey
+	failing  atomic.Bool
+}
+
+// createMockTransitServer starts a mock transit engine with one key version
+func createMockTransitServer(t *testing.T) (*httptest.Server, *mockTransit) {
+	m := &mockTransit{t: t}
+	m.addVersion()
+
+	server := httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(server.Close)
+	return server, m
+}
+
+// addVersion generates a new latest version of the key
+func (m *mockTransit) addVersion() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(m.t, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.versions = append(m.versions, key)
+}
+
+func (m *mockTransit) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != testTransitToken {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if m.failing.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/signer":
+		m.mu.Lock()
+		keys := map[string]any{}
+		for i, key := range m.versions {
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			require.NoError(m.t, err)
+			keys[strconv.Itoa(i+1)] = map[string]any{
+				"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			}
+		}
+		latest := len(m.versions)
+		m.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"type":           "rsa-2048",
+			"latest_version": latest,
+			"keys":           keys,
+		}})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/keys/signer/rotate":
+		m.addVersion()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/transit/sign/signer/"):
+		m.sign(w, r, strings.TrimPrefix(r.URL.Path, "/v1/transit/sign/signer/"))
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/decrypt/signer":
+		m.decrypt(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// sign signs the request input with the requested key version
+func (m *mockTransit) sign(w http.ResponseWriter, r *http.Request, hashAlgorithm string) {
+	body := struct {
+		Input              string `json:"input"`
+		KeyVersion         int    `json:"key_version"`
+		SignatureAlgorithm string `json:"signature_algorithm"`
+		SaltLength         string `json:"salt_length"`
+	}{}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&body))
+
+	hash := map[string]crypto.Hash{"sha2-256": crypto.SHA256, "sha2-384": crypto.SHA384, "sha2-512": crypto.SHA512}[hashAlgorithm]
+	require.NotZero(m.t, hash, "unexpected hash algorithm %q", hashAlgorithm)
+
+	m.mu.Lock()
+	require.True(m.t, body.KeyVersion >= 1 && body.KeyVersion <= len(m.versions), "unknown key version %d", body.KeyVersion)
+	key := m.versions[body.KeyVersion-1]
+	m.mu.Unlock()
+
+	input, err := base64.StdEncoding.DecodeString(body.Input)
+	require.NoError(m.t, err)
+	hasher := hash.New()
+	hasher.Write(input)
+	digest := hasher.Sum(nil)
+
+	var signature []byte
+	switch body.SignatureAlgorithm {
+	case "pkcs1v15":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	case "pss":
+		require.Equal(m.t, "hash", body.SaltLength)
+		signature, err = rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default:
+		m.t.Errorf("unexpected signature algorithm %q", body.SignatureAlgorithm)
+	}
+	require.NoError(m.t, err)
+
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+		"signature": fmt.Sprintf("vault:v%d:%s", body.KeyVersion, base64.StdEncoding.EncodeToString(signature)),
+	}})
+}
+
+// decrypt decrypts the request ciphertext with RSA-OAEP using SHA-256, as
+// transit does for RSA keys
+func (m *mockTransit) decrypt(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Ciphertext string `json:"ciphertext"`
+	}{}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&body))
+
+	parts := strings.SplitN(body.Ciphertext, ":", 3)
+	require.Len(m.t, parts, 3)
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	require.NoError(m.t, err)
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	require.NoError(m.t, err)
+
+	m.mu.Lock()
+	require.True(m.t, version >= 1 && version <= len(m.versions), "unknown key version %d", version)
+	key := m.versions[version-1]
+	m.mu.Unlock()
+
+	plaintext, err := rsa.DecryptOAEP(crypto.SHA256.New(), rand.Reader, key, ciphertext, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}})
+}
+
+// createTransitKey creates a transit-backed key named name
+func createTransitKey(t *testing.T, b *Backend, storage logical.Storage, name, algorithm string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/" + name,
+		Storage:   storage,
+		Data: map[string]any{
+			"key_type":      KeyTypeTransit,
+			"transit_mount": "transit",
+			"transit_key":   "signer",
+			"algorithm":     algorithm,
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestTransitKeys tests signing tokens with keys held in a transit engine
+func TestTransitKeys(t *testing.T) {
+	setup := func(t *testing.T, algorithm string) (*Backend, logical.Storage, *rsa.PrivateKey, string, *mockTransit) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+		server, transit := createMockTransitServer(t)
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": server.URL, "transit_token": testTransitToken})
+		require.Nil(t, resp)
+
+		resp = createTransitKey(t, b, storage, "transit-key", algorithm)
+		require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+		require.Equal(t, "transit-key-v1", resp.Data["key_id"])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+			Data: map[string]any{
+				"ttl":              "1h",
+				"key":              "transit-key",
+				"actor_template":   `{"act": {"sub": "agent-123"}}`,
+				"subject_template": `{}`,
+				"context":          "urn:documents:read",
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		return b, storage, privateKey, kid, transit
+	}
+
+	t.Run("sign and verify", func(t *testing.T) {
+		for _, algorithm := range []string{AlgorithmRS256, AlgorithmPS384} {
+			t.Run(algorithm, func(t *testing.T) {
+				b, storage, privateKey, kid, _ := setup(t, algorithm)
+
+				resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				require.Equal(t, "transit-key-v1", resp.Data["key_id"])
+
+				claims := parseTransitIssuedToken(t, b, storage, resp.Data["token"].(string))
+				require.Equal(t, "user-123", claims["sub"])
+			})
+		}
+	})
+
+	t.Run("key read", func(t *testing.T) {
+		b, storage, _, _, _ := setup(t, AlgorithmRS256)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "key/transit-key", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, KeyTypeTransit, resp.Data["key_type"])
+		require.Equal(t, "transit", resp.Data["transit_mount"])
+		require.Equal(t, "signer", resp.Data["transit_key"])
+		require.NotEmpty(t, resp.Data["public_key"])
+
+		key, err := b.getKey(context.Background(), storage, "transit-key")
+		require.NoError(t, err)
+		require.Empty(t, key.PrivateKey)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		b, storage, privateKey, kid, _ := setup(t, AlgorithmRS256)
+
+		before := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, before.IsError(), "exchange failed: %v", before.Error())
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.UpdateOperation, Path: "key/transit-key/rotate", Storage: storage})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "rotate failed: %v", resp.Error())
+		require.Equal(t, "transit-key-v2", resp.Data["key_id"])
+
+		after := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, after.IsError(), "exchange failed: %v", after.Error())
+		require.Equal(t, "transit-key-v2", after.Data["key_id"])
+
+		// Tokens from both versions verify against the JWKS
+		parseTransitIssuedToken(t, b, storage, before.Data["token"].(string))
+		parseTransitIssuedToken(t, b, storage, after.Data["token"].(string))
+	})
+
+	t.Run("transit unavailable", func(t *testing.T) {
+		b, storage, privateKey, kid, transit := setup(t, AlgorithmRS256)
+		transit.failing.Store(true)
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	})
+
+	t.Run("private CA", func(t *testing.T) {
+		b, storage, privateKey, kid, transit := setup(t, AlgorithmRS256)
+		server := httptest.NewTLSServer(http.HandlerFunc(transit.serveHTTP))
+		defer server.Close()
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": server.URL})
+		require.Nil(t, resp)
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"api_ca_pem": certificatePEM(server.Certificate())})
+		require.Nil(t, resp)
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		b, storage, _, _, transit := setup(t, AlgorithmRS256)
+		target := httptest.NewServer(http.HandlerFunc(transit.serveHTTP))
+		defer target.Close()
+		redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL+r.URL.Path, http.StatusTemporaryRedirect)
+		}))
+		defer redirector.Close()
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": redirector.URL})
+		require.Nil(t, resp)
+		config, err := b.getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		key, err := b.getKey(context.Background(), storage, "transit-key")
+		require.NoError(t, err)
+
+		client, err := b.newTransitClient(config, key)
+		require.NoError(t, err)
+		_, _, err = client.readKey(context.Background())
+		require.ErrorContains(t, err, "status 307")
+	})
+
+	t.Run("invalid key options", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+		server, _ := createMockTransitServer(t)
+
+		// No transit_token configured
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": server.URL})
+		require.Nil(t, resp)
+		resp = createTransitKey(t, b, storage, "transit-key", AlgorithmRS256)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "transit_token")
+
+		// Wrong transit token
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"transit_token": "wrong"})
+		require.Nil(t, resp)
+		resp = createTransitKey(t, b, storage, "transit-key", AlgorithmRS256)
+		require.True(t, resp.IsError())
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "key/transit-key",
+			Storage:   storage,
+			Data:      map[string]any{"key_type": KeyTypeTransit},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "transit_mount and transit_key")
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "key/other-key",
+			Storage:   storage,
+			Data:      map[string]any{"key_type": "hsm"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+}
+
+// parseTransitIssuedToken verifies a token against the JWKS, accepting the
+// PS algorithms as well as RS
+func parseTransitIssuedToken(t *testing.T, b *Backend, storage logical.Storage, token string) map[string]any {
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+	if !strings.Contains(string(header), `"alg":"PS`) {
+		return parseIssuedToken(t, b, storage, token)
+	}
+
+	headerClaims := map[string]any{}
+	require.NoError(t, json.Unmarshal(header, &headerClaims))
+	publicKey := getPublicKeyFromJWKS(t, b, storage, headerClaims["kid"].(string))
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := crypto.SHA384.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPSS(publicKey, crypto.SHA384, digest.Sum(nil), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	claims := map[string]any{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
@@ -0,0 +1,23 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathMetrics returns the path configuration for /metrics endpoint
+func pathMetrics(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "metrics",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathMetricsRead,
+				Summary:  "Read a snapshot of the mount's internal counters",
+			},
+		},
+
+		HelpSynopsis:    "Read mount metrics",
+		HelpDescription: "Returns a JSON snapshot of exchanges per role, cache hit rates, subject JWKS fetch failures, key rotations and signing latency percentiles for this mount. Counters are held in memory on the node serving the request and reset when the plugin restarts. The same values are emitted to Vault's telemetry sink when one is configured.",
+	}
+}
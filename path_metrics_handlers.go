@@ -0,0 +1,15 @@
+package tokenexchange
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathMetricsRead handles reading the metrics snapshot
+func (b *Backend) pathMetricsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: b.stats.snapshot(),
+	}, nil
+}
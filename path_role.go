@@ -9,18 +9,93 @@ import (
 
 // Role represents a token exchange role configuration
 type Role struct {
-	Name            string        `json:"name"`
-	TTL             time.Duration `json:"ttl"`
-	BoundAudiences  []string      `json:"bound_audiences"`
-	BoundIssuer     string        `json:"bound_issuer"`
-	ActorTemplate   string        `json:"actor_template"`
-	SubjectTemplate string        `json:"subject_template"`
-	Context         []string      `json:"context"`
-	Key             string        `json:"key"` // NEW: reference to named key (optional)
+	Name                      string              `json:"name"`
+	TTL                       time.Duration       `json:"ttl"`
+	BoundAudiences            []string            `json:"bound_audiences"`
+	BoundAudiencesType        string              `json:"bound_audiences_type,omitempty"`
+	BoundIssuer               string              `json:"bound_issuer"`
+	BoundClaims               map[string][]string `json:"bound_claims,omitempty"`
+	BoundClaimsType           string              `json:"bound_claims_type,omitempty"`
+	BoundEntityIDs            []string            `json:"bound_entity_ids,omitempty"`
+	BoundGroupIDs             []string            `json:"bound_group_ids,omitempty"`
+	ActorTemplate             string              `json:"actor_template"`
+	SubjectTemplate           string              `json:"subject_template"`
+	TemplateLibrary           []string            `json:"template_library,omitempty"`
+	Context                   []string            `json:"context"`
+	Key                       string              `json:"key"` // NEW: reference to named key (optional)
+	AudienceKeys              map[string]string   `json:"audience_keys,omitempty"`
+	NotAfter                  time.Time           `json:"not_after,omitempty"`
+	DetachedPayload           bool                `json:"detached_payload"`
+	UpstreamSTS               *UpstreamSTS        `json:"upstream_sts,omitempty"`
+	RequiredEntityMetadata    []string            `json:"required_entity_metadata,omitempty"`
+	AllowEntityless           bool                `json:"allow_entityless,omitempty"`
+	RequiredSubjectClaims     []string            `json:"required_subject_claims,omitempty"`
+	Disabled                  bool                `json:"disabled,omitempty"`
+	RequireNonEmptyClaims     bool                `json:"require_non_empty_claims,omitempty"`
+	DelegationContextSchema   map[string]string   `json:"delegation_ctx_schema,omitempty"`
+	DelegationContextRequired []string            `json:"delegation_ctx_required,omitempty"`
+	SingleUseSubjectToken     bool                `json:"single_use_subject_token"`
+	IncludeVaultMeta          bool                `json:"include_vault_meta"`
+	IncludeTxn                bool                `json:"include_txn,omitempty"`
+	IncludeSubjectFingerprint bool                `json:"include_subject_fingerprint,omitempty"`
+	MaxExchangesPerMinute     int                 `json:"max_exchanges_per_minute,omitempty"`
+	MaxTokensPerDay           int                 `json:"max_tokens_per_day,omitempty"`
+	VerificationHint          string              `json:"verification_hint,omitempty"`
+	IssueIDToken              bool                `json:"issue_id_token,omitempty"`
+	RequireCertificateBinding bool                `json:"require_certificate_binding,omitempty"`
+	RequireDPoP               bool                `json:"require_dpop,omitempty"`
+	EncryptionKey             string              `json:"encryption_key,omitempty"`
+	EncryptionJWKSURI         string              `json:"encryption_jwks_uri,omitempty"`
+	WrapTTL                   time.Duration       `json:"wrap_ttl,omitempty"`
+	NotBeforeLeeway           time.Duration       `json:"not_before_leeway,omitempty"`
+	IssuanceWindow            time.Duration       `json:"issuance_window,omitempty"`
+	RefreshTTL                time.Duration       `json:"refresh_ttl,omitempty"`
+	IncludeX5C                bool                `json:"include_x5c,omitempty"`
+	TokenProfile              string              `json:"token_profile,omitempty"`
+	TokenProfileAudience      string              `json:"token_profile_audience,omitempty"`
+	Tenant                    string              `json:"tenant,omitempty"`
+	SubjectClaimSource        string              `json:"subject_claim_source,omitempty"`
+	SubjectClaimTemplate      string              `json:"subject_claim_template,omitempty"`
+	ClaimNamespace            string              `json:"claim_namespace,omitempty"`
+	Policy                    string              `json:"policy,omitempty"`
+	CASVersion                int                 `json:"cas_version,omitempty"`
+
+	// Extends names the role this role inherits parameters from. Parameters
+	// are the ones written to this role itself, kept so that it and the roles
+	// extending it can be rebuilt when a role they inherit from changes. The
+	// fields above hold the resolved values.
+	Extends    string         `json:"extends,omitempty"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+
+	// LegacyTemplate is the entity claims template of roles written before
+	// actor and subject templates were split. It is moved to ActorTemplate
+	// when read and never written.
+	LegacyTemplate string `json:"template,omitempty"`
 }
 
 const roleStoragePrefix = "roles/"
 
+// Supported bound_claims_type values
+const (
+	BoundClaimsTypeString = "string"
+	BoundClaimsTypeGlob   = "glob"
+)
+
+// Supported bound_audiences_type values
+const (
+	BoundAudiencesTypeString = "string"
+	BoundAudiencesTypeGlob   = "glob"
+	BoundAudiencesTypePrefix = "prefix"
+)
+
+// Supported verification_hint values. Both point at this mount's JWKS.
+const (
+	// VerificationHintJKU sets the jku header of issued tokens
+	VerificationHintJKU = "jku"
+	// VerificationHintClaim adds a verification_url claim to issued tokens
+	VerificationHintClaim = "verification_url"
+)
+
 // pathRole returns the path configuration for /role/:name endpoint
 func pathRole(b *Backend) *framework.Path {
 	return &framework.Path{
@@ -39,14 +114,48 @@ func pathRole(b *Backend) *framework.Path {
 				Description: "TTL for tokens generated with this role",
 				Required:    true,
 			},
+			"not_before_leeway": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Add an nbf claim this far before the token's start, at most 10m, so relying parties with slow clocks accept it. 0 omits nbf unless the token is future-dated",
+			},
+			"issuance_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How far in the future an exchange's not_before may start the token's validity, for scheduled jobs. The token is valid for ttl from not_before. 0 rejects future-dated tokens",
+			},
 			"bound_audiences": {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "Comma-separated list of valid audiences for the subject token",
 			},
+			"bound_audiences_type": {
+				Type:        framework.TypeString,
+				Description: "How bound_audiences are matched: 'string' for exact matches, 'glob' to allow '*' wildcards such as https://api.example.com/*, or 'prefix' to match audiences under a URI at a path segment boundary",
+				Default:     BoundAudiencesTypeString,
+			},
 			"bound_issuer": {
 				Type:        framework.TypeString,
 				Description: "Required issuer for the subject token",
 			},
+			"bound_claims": {
+				Type:        framework.TypeMap,
+				Description: "Map of subject token claims to allowed values. Each value is a string or list of strings, and the claim must match at least one. Nested claims are addressed with JSON pointer keys such as '/org/team'",
+			},
+			"bound_claims_type": {
+				Type:        framework.TypeString,
+				Description: "How bound_claims values are matched: 'string' for exact matches or 'glob' to allow '*' wildcards",
+				Default:     BoundClaimsTypeString,
+			},
+			"bound_entity_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of Vault entity IDs allowed to exchange with this role. When bound_entity_ids or bound_group_ids is set, the exchanging entity must be listed or be a member of a listed group",
+			},
+			"bound_group_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated list of Vault identity group IDs whose members are allowed to exchange with this role, including members through subgroups",
+			},
+			"template_library": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated template_library fragments this role inherits. They are rendered in order and merged, and the role's own templates override them",
+			},
 			"actor_template": {
 				Type:        framework.TypeString,
 				Description: "JSON template for actor-related claims (RFC 8693). Should include 'act' claim with actor identity. Optional 'actor_metadata' for additional actor context. Example: {\"act\": {\"sub\": \"{{identity.entity.id}}\"}, \"actor_metadata\": {\"department\": \"IT\"}}",
@@ -64,8 +173,175 @@ func pathRole(b *Backend) *framework.Path {
 			},
 			"key": {
 				Type:        framework.TypeString,
-				Description: "Name of the signing key to use for this role.",
-				Required:    true,
+				Description: "Name of the signing key to use for this role. Defaults to the config's default_key",
+			},
+			"audience_keys": {
+				Type:        framework.TypeKVPairs,
+				Description: "Optional map of token audience to signing key name, e.g. 'legacy-service=rsa-key'. The exchange signs with the key mapped to the first audience in the actor template's 'aud' claim, or with 'key' when none is mapped",
+			},
+			"not_after": {
+				Type:        framework.TypeString,
+				Description: "Optional absolute deadline (RFC 3339) after which tokens must not be valid. Caps exp regardless of ttl and rejects exchanges once passed.",
+			},
+			"detached_payload": {
+				Type:        framework.TypeBool,
+				Description: "Return the token as a detached JWS (RFC 7515 Appendix F): 'token' is header..signature and the base64url payload is returned separately in 'payload'",
+				Default:     false,
+			},
+			"allow_entityless": {
+				Type:        framework.TypeBool,
+				Description: "Accept exchanges from callers without a Vault entity, such as batch tokens, which must pass actor_id to name the actor. Cannot be combined with bound_entity_ids, bound_group_ids or required_entity_metadata",
+				Default:     false,
+			},
+			"single_use_subject_token": {
+				Type:        framework.TypeBool,
+				Description: "Reject a subject token that has already been exchanged with this mount until it expires, so stolen subject tokens cannot be replayed by other agents",
+				Default:     false,
+			},
+			"include_vault_meta": {
+				Type:        framework.TypeBool,
+				Description: "Add a 'vault_meta' claim with the mount accessor, a SHA-256 hash of the Vault request ID, the entity ID and the auth mounts of the entity's aliases, so the token can be traced back to the agent's Vault auth path",
+				Default:     false,
+			},
+			"include_txn": {
+				Type:        framework.TypeBool,
+				Description: "Add a 'txn' claim with the exchange's transaction ID: the trace ID of its traceparent, or else its request_id",
+				Default:     false,
+			},
+			"include_subject_fingerprint": {
+				Type:        framework.TypeBool,
+				Description: "Add an 'orig_jti' claim with the subject token's jti and a 'sub_tkn#S256' claim with the base64url SHA-256 hash of the subject token, tying the token to the exact credential it was derived from",
+				Default:     false,
+			},
+			"required_entity_metadata": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Entity metadata keys (e.g. owner,cost_center) that the exchanging entity must have set. Exchanges by entities missing any of them are denied",
+			},
+			"enabled": {
+				Type:        framework.TypeBool,
+				Description: "Whether the role accepts exchanges. Disabling a role is an emergency brake short of deleting it",
+				Default:     true,
+			},
+			"required_subject_claims": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Subject token claims (e.g. email,tid) that must be present. Nested claims are addressed with JSON pointer keys such as '/org/team'. Exchanges with subject tokens missing any of them are rejected",
+			},
+			"require_non_empty_claims": {
+				Type:        framework.TypeBool,
+				Description: "Also reject subject tokens whose required_subject_claims are null, empty strings or empty lists",
+				Default:     false,
+			},
+			"delegation_ctx_schema": {
+				Type:        framework.TypeKVPairs,
+				Description: "Fields callers may supply in an exchange's delegation_ctx, mapped to their type: string, number or bool, e.g. 'task_id=string'. The validated delegation_ctx is added to the token as a delegation_ctx claim. Empty rejects any delegation_ctx",
+			},
+			"delegation_ctx_required": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Fields of delegation_ctx_schema every exchange must supply",
+			},
+			"max_exchanges_per_minute": {
+				Type:        framework.TypeInt,
+				Description: "Maximum exchanges per minute for each Vault entity using this role. 0 is unlimited",
+				Default:     0,
+			},
+			"max_tokens_per_day": {
+				Type:        framework.TypeInt,
+				Description: "Maximum tokens this role issues per UTC day, across all entities. 0 is unlimited",
+				Default:     0,
+			},
+			"issue_id_token": {
+				Type:        framework.TypeBool,
+				Description: "Also return an OIDC ID token describing the subject and actor, with nonce, auth_time and an at_hash binding it to the delegated token. Cannot be combined with detached_payload or upstream_sts_url",
+				Default:     false,
+			},
+			"require_certificate_binding": {
+				Type:        framework.TypeBool,
+				Description: "Reject exchanges that do not bind the token to a client certificate with certificate_thumbprint or bind_client_certificate",
+				Default:     false,
+			},
+			"require_dpop": {
+				Type:        framework.TypeBool,
+				Description: "Reject exchanges without a DPoP proof, so every token is bound to the caller's DPoP key",
+				Default:     false,
+			},
+			"encryption_key": {
+				Type:        framework.TypeString,
+				Description: "Optional RSA or EC public key of the token's audience, as PEM or a JWK. The signed token is returned encrypted to this key as a nested JWT (JWE with cty JWT). Cannot be combined with encryption_jwks_uri",
+			},
+			"encryption_jwks_uri": {
+				Type:        framework.TypeString,
+				Description: "Optional JWKS URL of the token's audience. The token is encrypted to its first RSA or EC key with use 'enc' or no use. Cannot be combined with encryption_key",
+			},
+			"wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Always return exchange responses wrapped in a single-use Vault wrapping token with this TTL, so the delegated token only reaches whoever unwraps it. 0 wraps only when the caller asks",
+			},
+			"refresh_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Issue a single-use refresh_token with each token, valid for this long but never past the subject token's expiry, that token/:name/refresh exchanges for a new token without the subject token. 0 disables refresh",
+			},
+			"cas":    casField("role"),
+			"tenant": tenantField("role"),
+			"token_profile": {
+				Type:        framework.TypeString,
+				Description: "Shape issued tokens for a relying party, with the actor as sub: 'azure' issues Azure AD federated credential assertions, 'aws' web identity tokens for AWS STS AssumeRoleWithWebIdentity and 'gcp' tokens for GCP workload identity pools. Empty issues plain delegation tokens",
+			},
+			"token_profile_audience": {
+				Type:        framework.TypeString,
+				Description: "Audience of tokens issued under token_profile. Defaults to api://AzureADTokenExchange for azure and sts.amazonaws.com for aws. Required for gcp, where it is the workload identity pool provider's audience",
+			},
+			"include_x5c": {
+				Type:        framework.TypeBool,
+				Description: "Add the signing key's certificate to the token header as x5c and x5t#S256. The key must have a certificate",
+				Default:     false,
+			},
+			"subject_claim_source": {
+				Type:        framework.TypeString,
+				Description: "What issued tokens carry as sub: 'subject' for the subject token's sub, 'email' for its email, 'template' to render subject_claim_template, or 'entity' for the exchanging Vault entity's ID",
+				Default:     SubjectClaimSourceSubject,
+			},
+			"subject_claim_template": {
+				Type:        framework.TypeString,
+				Description: "Template of sub for subject_claim_source 'template'. It renders to a string with the variables of both templates, e.g. 'user:{{identity.subject.email}}' or '{{identity.entity.name}}'",
+			},
+			"extends": {
+				Type:        framework.TypeString,
+				Description: "Name of a role to inherit parameters from, such as its templates, scopes and bindings. Parameters set on this role override the inherited ones, and changes to the extended role apply to this one",
+			},
+			"policy": {
+				Type:        framework.TypeString,
+				Description: "CEL expression that must evaluate to true for an exchange to be allowed. It can use subject (the subject token claims), entity (id, name, namespace_id, metadata, groups and group_ids of the exchanging entity) and request (role, subject_token_type, nonce, not_after, certificate_bound, dpop_bound and remote_addr). Example: entity.metadata.team in subject.groups",
+			},
+			"claim_namespace": {
+				Type:        framework.TypeString,
+				Description: "URI prefixed to the custom claims of issued tokens, such as actor_metadata and subject_claims, e.g. https://example.com/claims/. Overrides the config's claim_namespace. Cannot be combined with token_profile",
+			},
+			"verification_hint": {
+				Type:        framework.TypeString,
+				Description: "Tell consumers where to fetch this mount's JWKS: 'jku' sets the jku header and 'verification_url' adds a verification_url claim. The URL is built from the config's api_addr and the mount path. Empty adds no hint",
+			},
+			"upstream_sts_url": {
+				Type:        framework.TypeString,
+				Description: "Optional token endpoint of an external RFC 8693 STS. When set, the delegated token is exchanged at this endpoint and the downstream token is returned instead",
+			},
+			"upstream_client_id": {
+				Type:        framework.TypeString,
+				Description: "Client ID used to authenticate to the upstream STS",
+			},
+			"upstream_client_secret": {
+				Type:        framework.TypeString,
+				Description: "Client secret used to authenticate to the upstream STS",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"upstream_audience": {
+				Type:        framework.TypeString,
+				Description: "Optional audience requested from the upstream STS",
+			},
+			"upstream_scope": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional scopes requested from the upstream STS, sent as a space-delimited scope parameter",
 			},
 		},
 
@@ -93,11 +369,86 @@ func pathRole(b *Backend) *framework.Path {
 	}
 }
 
+// pathRoleStats returns the path configuration for /role/:name/stats endpoint
+func pathRoleStats(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/stats",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleStats,
+				Summary:  "Read the tokens a role has issued, per day and in total",
+			},
+		},
+
+		HelpSynopsis:    "Read a role's issuance counters",
+		HelpDescription: "Returns how many tokens the role issued today (UTC) and in total, the same counts for each entity that used it, and the remaining max_tokens_per_day quota. Counters are persisted periodically, and on every exchange for roles with a quota.",
+	}
+}
+
+// pathRolePreview returns the path configuration for /role/:name/preview endpoint
+func pathRolePreview(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("name") + "/preview",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				Required:    true,
+			},
+			"subject_token": {
+				Type:        framework.TypeString,
+				Description: "Sample subject token (JWT). Its signature is not verified. Cannot be combined with subject_claims",
+			},
+			"subject_claims": {
+				Type:        framework.TypeMap,
+				Description: "Sample subject token claims, instead of a subject_token",
+			},
+			"entity_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the sample Vault entity rendered into actor_template",
+				Default:     "preview",
+			},
+			"entity_metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Metadata of the sample Vault entity rendered into actor_template",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRolePreview,
+				Summary:  "Preview the claims a role would issue for sample input",
+			},
+		},
+
+		HelpSynopsis:    "Preview a role's token claims",
+		HelpDescription: "Renders the role's templates against a sample subject token or claims and a sample Vault entity, and returns the claims the issued token would carry. Nothing is signed or recorded, and no Vault entity or directory is consulted.",
+	}
+}
+
 // pathRoleList returns the path configuration for /role endpoint (list)
 func pathRoleList(b *Backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "role/?$",
 
+		Fields: map[string]*framework.FieldSchema{
+			"tenant": {
+				Type:        framework.TypeString,
+				Description: "Only list the roles of this tenant. Empty lists the roles without a tenant",
+				Query:       true,
+			},
+		},
+
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.pathRoleList,
@@ -106,6 +457,6 @@ func pathRoleList(b *Backend) *framework.Path {
 		},
 
 		HelpSynopsis:    "List token exchange roles",
-		HelpDescription: "List all configured token exchange roles.",
+		HelpDescription: "List all configured token exchange roles, or those of one tenant.",
 	}
 }
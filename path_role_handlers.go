@@ -1,14 +1,27 @@
 package tokenexchange
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hoisie/mustache"
 )
 
+// templateVariablePattern matches mustache variable tags, but not sections,
+// comments, partials or delimiter changes
+var templateVariablePattern = regexp.MustCompile(`\{\{\{[^}]*\}\}\}|\{\{&?\s*[^#^/!>=&{}\s][^}]*\}\}`)
+
 // pathRoleExistenceCheck checks if a role exists
 func (b *Backend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
 	name := data.Get("name").(string)
@@ -33,104 +46,766 @@ func (b *Backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 		return nil, nil
 	}
 
+	boundClaims := make(map[string][]string, len(role.BoundClaims))
+	for claim, values := range role.BoundClaims {
+		boundClaims[claim] = sortedStrings(values)
+	}
+
+	respData := map[string]any{
+		"name":                        role.Name,
+		"ttl":                         durationSeconds(role.TTL),
+		"ttl_human":                   role.TTL.String(),
+		"not_before_leeway":           durationSeconds(role.NotBeforeLeeway),
+		"not_before_leeway_human":     role.NotBeforeLeeway.String(),
+		"issuance_window":             durationSeconds(role.IssuanceWindow),
+		"issuance_window_human":       role.IssuanceWindow.String(),
+		"bound_audiences":             sortedStrings(role.BoundAudiences),
+		"bound_issuer":                role.BoundIssuer,
+		"bound_claims":                boundClaims,
+		"bound_claims_type":           role.BoundClaimsType,
+		"bound_audiences_type":        cmp.Or(role.BoundAudiencesType, BoundAudiencesTypeString),
+		"bound_entity_ids":            sortedStrings(role.BoundEntityIDs),
+		"bound_group_ids":             sortedStrings(role.BoundGroupIDs),
+		"actor_template":              role.ActorTemplate,
+		"subject_template":            role.SubjectTemplate,
+		"template_library":            role.TemplateLibrary,
+		"context":                     sortedStrings(role.Context),
+		"key":                         role.Key, // NEW: include key reference
+		"audience_keys":               role.AudienceKeys,
+		"not_after":                   formatOptionalTime(role.NotAfter),
+		"detached_payload":            role.DetachedPayload,
+		"max_exchanges_per_minute":    role.MaxExchangesPerMinute,
+		"required_subject_claims":     sortedStrings(role.RequiredSubjectClaims),
+		"enabled":                     !role.Disabled,
+		"require_non_empty_claims":    role.RequireNonEmptyClaims,
+		"delegation_ctx_schema":       role.DelegationContextSchema,
+		"delegation_ctx_required":     sortedStrings(role.DelegationContextRequired),
+		"max_tokens_per_day":          role.MaxTokensPerDay,
+		"verification_hint":           role.VerificationHint,
+		"issue_id_token":              role.IssueIDToken,
+		"require_certificate_binding": role.RequireCertificateBinding,
+		"require_dpop":                role.RequireDPoP,
+		"encryption_key":              role.EncryptionKey,
+		"encryption_jwks_uri":         role.EncryptionJWKSURI,
+		"wrap_ttl":                    durationSeconds(role.WrapTTL),
+		"wrap_ttl_human":              role.WrapTTL.String(),
+		"refresh_ttl":                 durationSeconds(role.RefreshTTL),
+		"refresh_ttl_human":           role.RefreshTTL.String(),
+		"include_x5c":                 role.IncludeX5C,
+		"token_profile":               role.TokenProfile,
+		"token_profile_audience":      role.TokenProfileAudience,
+		"tenant":                      role.Tenant,
+		"subject_claim_source":        cmp.Or(role.SubjectClaimSource, SubjectClaimSourceSubject),
+		"subject_claim_template":      role.SubjectClaimTemplate,
+		"claim_namespace":             role.ClaimNamespace,
+		"policy":                      role.Policy,
+		"extends":                     role.Extends,
+		"cas_version":                 role.CASVersion,
+		"required_entity_metadata":    sortedStrings(role.RequiredEntityMetadata),
+		"allow_entityless":            role.AllowEntityless,
+		"single_use_subject_token":    role.SingleUseSubjectToken,
+		"include_vault_meta":          role.IncludeVaultMeta,
+		"include_txn":                 role.IncludeTxn,
+		"include_subject_fingerprint": role.IncludeSubjectFingerprint,
+	}
+
+	// The parameters a role sets itself, on top of those it inherits
+	if role.Extends != "" {
+		respData["overrides"] = roleOverrides(role)
+	}
+
+	if role.UpstreamSTS != nil {
+		respData["upstream_sts_url"] = role.UpstreamSTS.URL
+		respData["upstream_client_id"] = role.UpstreamSTS.ClientID
+		respData["upstream_audience"] = role.UpstreamSTS.Audience
+		respData["upstream_scope"] = role.UpstreamSTS.Scope
+		// Note: upstream_client_secret is NEVER returned
+	}
+
+	// Show how to trust tokens issued under the cloud profiles
+	if role.TokenProfile == TokenProfileGCP {
+		respData["gcp_attribute_mapping"] = gcpAttributeMapping()
+	}
+	if role.TokenProfile == TokenProfileAWS {
+		config, err := b.getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			respData["aws_trust_policy"] = awsTrustPolicy(role, config)
+		}
+	}
+
 	return &logical.Response{
-		Data: map[string]any{
-			"name":             role.Name,
-			"ttl":              role.TTL.String(),
-			"bound_audiences":  role.BoundAudiences,
-			"bound_issuer":     role.BoundIssuer,
-			"actor_template":   role.ActorTemplate,
-			"subject_template": role.SubjectTemplate,
-			"context":          role.Context,
-			"key":              role.Key, // NEW: include key reference
-		},
+		Data: respData,
 	}, nil
 }
 
 // pathRoleWrite handles creating or updating a role
 func (b *Backend) pathRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
+	if name == byEntityRoleName {
+		return logical.ErrorResponse("role name %q is reserved for token/%s exchanges", name, name), nil
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	existing, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	currentVersion := 0
+	if existing != nil {
+		currentVersion = existing.CASVersion
+	}
+	if err := checkCAS(requestCAS(data), currentVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// A role that extends another is built from the parameters it inherits
+	// with its own parameters on top
+	params := roleParameters(data)
+	fields := data
+	extends := data.Get("extends").(string)
+	if extends != "" {
+		inherited, resp, err := b.inheritedRoleParameters(ctx, req.Storage, name, extends)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+		fields = &framework.FieldData{Raw: mergeRoleParameters(inherited, params), Schema: data.Schema}
+	}
+
+	role, resp, err := b.roleFromFields(ctx, req.Storage, name, fields)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	role.Extends = extends
+	role.Parameters = params
+	role.CASVersion = currentVersion + 1
+
+	// Roles that extend this one are rebuilt on top of it, and the write
+	// fails if any of them would become invalid
+	extending, resp, err := b.rebuildExtendingRoles(ctx, req.Storage, role, fields.Raw, data.Schema)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// A role rewritten without changes keeps its cas_version
+	if existing != nil {
+		unchanged := *role
+		unchanged.CASVersion = existing.CASVersion
+		if sameEntry(existing, &unchanged) {
+			return nil, nil
+		}
+	}
+
+	// Store role
+	entry, err := logical.StorageEntryJSON(roleStoragePrefix+name, role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write role: %w", err)
+	}
+
+	for _, extendingRole := range extending {
+		if err := putStorageJSON(ctx, req.Storage, roleStoragePrefix+extendingRole.Name, extendingRole); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// roleFromFields builds a role from the parameters of a role write, returning
+// an error response when they are invalid
+func (b *Backend) roleFromFields(ctx context.Context, storage logical.Storage, name string, data *framework.FieldData) (*Role, *logical.Response, error) {
+	role := &Role{Name: name}
 
-	role := &Role{
-		Name: name,
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Get TTL (required)
 	ttl, ok := data.GetOk("ttl")
 	if !ok {
-		return logical.ErrorResponse("ttl is required"), nil
+		return nil, logical.ErrorResponse("ttl is required"), nil
 	}
 	role.TTL = time.Duration(ttl.(int)) * time.Second
+	if role.TTL <= 0 {
+		return nil, logical.ErrorResponse("ttl must be greater than zero"), nil
+	}
+
+	// Get validity window (optional)
+	role.NotBeforeLeeway = time.Duration(data.Get("not_before_leeway").(int)) * time.Second
+	role.IssuanceWindow = time.Duration(data.Get("issuance_window").(int)) * time.Second
+	if err := validateValidityWindow(role.NotBeforeLeeway, role.IssuanceWindow); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
 
 	// Get template (required)
 	stemplate, ok := data.GetOk("subject_template")
 	if !ok {
-		return logical.ErrorResponse("subject_template is required"), nil
+		return nil, logical.ErrorResponse("subject_template is required"), nil
 	}
 	role.SubjectTemplate = stemplate.(string)
 
-	atemplate, ok := data.GetOk("actor_template")
-	if !ok {
-		return logical.ErrorResponse("actor_template is required"), nil
+	// Roles that omit actor_template, context or bound_issuer use the
+	// config's role defaults, which are validated when the config is written
+	role.ActorTemplate = data.Get("actor_template").(string)
+	if role.ActorTemplate == "" && config.roleDefaults().ActorTemplate == "" {
+		return nil, logical.ErrorResponse("actor_template is required unless the config sets default_actor_template"), nil
+	}
+
+	if role.ActorTemplate != "" {
+		if err := validateActorTemplate(role.ActorTemplate); err != nil {
+			return nil, logical.ErrorResponse("invalid actor_template: %v", err), nil
+		}
+	}
+	if err := validateTemplate(role.SubjectTemplate, subjectTemplateContext(selfTestSubjectClaims(), map[string]any{})); err != nil {
+		return nil, logical.ErrorResponse("invalid subject_template: %v", err), nil
+	}
+
+	// Get inherited template fragments (optional)
+	if library, ok := data.GetOk("template_library"); ok {
+		role.TemplateLibrary = library.([]string)
+		for _, fragmentName := range role.TemplateLibrary {
+			fragment, err := b.getTemplateFragment(ctx, storage, fragmentName)
+			if err != nil {
+				return nil, nil, err
+			}
+			if fragment == nil {
+				return nil, logical.ErrorResponse("template fragment %q not found", fragmentName), nil
+			}
+		}
 	}
-	role.ActorTemplate = atemplate.(string)
 
 	// get the context (required)
-	contextVal, ok := data.GetOk("context")
-	if !ok {
-		return logical.ErrorResponse("context is required"), nil
+	role.Context = data.Get("context").([]string)
+	if len(role.Context) == 0 && len(config.roleDefaults().Context) == 0 {
+		return nil, logical.ErrorResponse("context is required unless the config sets default_context"), nil
 	}
-	role.Context = contextVal.([]string)
 
 	// Get bound audiences (optional)
 	if audiences, ok := data.GetOk("bound_audiences"); ok {
 		role.BoundAudiences = audiences.([]string)
 	}
 
+	role.BoundAudiencesType = data.Get("bound_audiences_type").(string)
+	switch role.BoundAudiencesType {
+	case BoundAudiencesTypeString, BoundAudiencesTypeGlob, BoundAudiencesTypePrefix:
+	default:
+		return nil, logical.ErrorResponse("bound_audiences_type must be string, glob or prefix"), nil
+	}
+
 	// Get bound issuer (optional)
 	if issuer, ok := data.GetOk("bound_issuer"); ok {
 		role.BoundIssuer = issuer.(string)
 	}
 
-	// Get key reference (required) - NEW
-	keyName, ok := data.GetOk("key")
-	if !ok {
-		return logical.ErrorResponse("key is required"), nil
+	// Get bound claims (optional)
+	if boundClaims, ok := data.GetOk("bound_claims"); ok {
+		parsed, err := parseBoundClaims(boundClaims.(map[string]any))
+		if err != nil {
+			return nil, logical.ErrorResponse("invalid bound_claims: %v", err), nil
+		}
+		role.BoundClaims = parsed
 	}
-	keyNameStr := keyName.(string)
 
-	// Validate key exists
-	key, err := b.getKey(ctx, req.Storage, keyNameStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to validate key: %w", err)
+	role.BoundClaimsType = data.Get("bound_claims_type").(string)
+	if role.BoundClaimsType != BoundClaimsTypeString && role.BoundClaimsType != BoundClaimsTypeGlob {
+		return nil, logical.ErrorResponse("bound_claims_type must be string or glob"), nil
 	}
-	if key == nil {
-		return logical.ErrorResponse("key %q not found", keyNameStr), nil
+
+	// Get bound entities and groups (optional)
+	if entityIDs, ok := data.GetOk("bound_entity_ids"); ok {
+		role.BoundEntityIDs = entityIDs.([]string)
+	}
+	if groupIDs, ok := data.GetOk("bound_group_ids"); ok {
+		role.BoundGroupIDs = groupIDs.([]string)
+	}
+
+	if config != nil && len(config.GloballyDeniedScopes) > 0 {
+		scopes, err := expandScopes(ctx, storage, role.Context)
+		if err != nil {
+			return nil, nil, err
+		}
+		if denied := deniedScopes(scopes, config.GloballyDeniedScopes); len(denied) > 0 {
+			return nil, logical.ErrorResponse("context includes globally denied scopes: %s", strings.Join(denied, ", ")), nil
+		}
+	}
+
+	// Get key reference (optional when the config has a default_key)
+	keyNameStr := data.Get("key").(string)
+	if keyNameStr == "" {
+		if config == nil || config.DefaultKey == "" {
+			return nil, logical.ErrorResponse("key is required unless default_key is configured"), nil
+		}
+	} else {
+		// Validate key exists
+		key, err := b.getKey(ctx, storage, keyNameStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to validate key: %w", err)
+		}
+		if key == nil {
+			return nil, logical.ErrorResponse("key %q not found", keyNameStr), nil
+		}
+		if _, err := signatureAlgorithm(key.Algorithm); err != nil {
+			return nil, logical.ErrorResponse("key %q uses an unsupported algorithm %q", keyNameStr, key.Algorithm), nil
+		}
 	}
 
 	role.Key = keyNameStr
 
-	// Store role
-	entry, err := logical.StorageEntryJSON(roleStoragePrefix+name, role)
+	// Get per-audience signing keys (optional)
+	if audienceKeys, ok := data.GetOk("audience_keys"); ok {
+		role.AudienceKeys = audienceKeys.(map[string]string)
+		for audience, audienceKeyName := range role.AudienceKeys {
+			audienceKey, err := b.getKey(ctx, storage, audienceKeyName)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to validate key: %w", err)
+			}
+			if audienceKey == nil {
+				return nil, logical.ErrorResponse("key %q for audience %q not found", audienceKeyName, audience), nil
+			}
+			if _, err := signatureAlgorithm(audienceKey.Algorithm); err != nil {
+				return nil, logical.ErrorResponse("key %q for audience %q uses an unsupported algorithm %q", audienceKeyName, audience, audienceKey.Algorithm), nil
+			}
+		}
+	}
+
+	// The audit key only signs issuance records
+	if config != nil && config.AuditKey != "" && slices.Contains(roleKeyNames(role, config.DefaultKey), config.AuditKey) {
+		return nil, logical.ErrorResponse("key %q is the audit key and cannot sign tokens", config.AuditKey), nil
+	}
+
+	// Get tenant (optional)
+	role.Tenant = data.Get("tenant").(string)
+	if err := validateTenant(role.Tenant); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+	defaultKey := ""
+	if config != nil {
+		defaultKey = config.DefaultKey
+	}
+	if resp, err := b.checkRoleTenant(ctx, storage, role, defaultKey); resp != nil || err != nil {
+		return nil, resp, err
+	}
+
+	// Get detached payload option (optional)
+	role.DetachedPayload = data.Get("detached_payload").(bool)
+
+	// Get replay protection option (optional)
+	role.SingleUseSubjectToken = data.Get("single_use_subject_token").(bool)
+
+	// Get vault_meta claim option (optional)
+	role.IncludeVaultMeta = data.Get("include_vault_meta").(bool)
+
+	// Get txn claim option (optional)
+	role.IncludeTxn = data.Get("include_txn").(bool)
+
+	// Get subject token fingerprint option (optional)
+	role.IncludeSubjectFingerprint = data.Get("include_subject_fingerprint").(bool)
+
+	// Get required entity metadata keys (optional)
+	if required, ok := data.GetOk("required_entity_metadata"); ok {
+		role.RequiredEntityMetadata = required.([]string)
+	}
+
+	// Get entityless option (optional). Entityless callers have no entity
+	// for the entity bindings to check.
+	role.AllowEntityless = data.Get("allow_entityless").(bool)
+	if role.AllowEntityless && (len(role.BoundEntityIDs) > 0 || len(role.BoundGroupIDs) > 0 || len(role.RequiredEntityMetadata) > 0) {
+		return nil, logical.ErrorResponse("allow_entityless cannot be combined with bound_entity_ids, bound_group_ids or required_entity_metadata"), nil
+	}
+
+	// Get required subject claims (optional)
+	if required, ok := data.GetOk("required_subject_claims"); ok {
+		role.RequiredSubjectClaims = required.([]string)
+	}
+	role.RequireNonEmptyClaims = data.Get("require_non_empty_claims").(bool)
+
+	// Get delegation context schema (optional)
+	if schema, ok := data.GetOk("delegation_ctx_schema"); ok {
+		role.DelegationContextSchema = schema.(map[string]string)
+	}
+	if required, ok := data.GetOk("delegation_ctx_required"); ok {
+		role.DelegationContextRequired = required.([]string)
+	}
+	if err := validateDelegationCtxSchema(role.DelegationContextSchema, role.DelegationContextRequired); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get enabled flag (optional, has default)
+	role.Disabled = !data.Get("enabled").(bool)
+
+	// Get rate limit (optional)
+	role.MaxExchangesPerMinute = data.Get("max_exchanges_per_minute").(int)
+	if role.MaxExchangesPerMinute < 0 {
+		return nil, logical.ErrorResponse("max_exchanges_per_minute must not be negative"), nil
+	}
+
+	// Get daily quota (optional)
+	role.MaxTokensPerDay = data.Get("max_tokens_per_day").(int)
+	if role.MaxTokensPerDay < 0 {
+		return nil, logical.ErrorResponse("max_tokens_per_day must not be negative"), nil
+	}
+
+	// Get verification hint (optional)
+	role.VerificationHint = data.Get("verification_hint").(string)
+	switch role.VerificationHint {
+	case "", VerificationHintJKU, VerificationHintClaim:
+	default:
+		return nil, logical.ErrorResponse("verification_hint must be %q or %q", VerificationHintJKU, VerificationHintClaim), nil
+	}
+	if role.VerificationHint != "" && (config == nil || config.APIAddr == "") {
+		return nil, logical.ErrorResponse("verification_hint requires api_addr in the config"), nil
+	}
+
+	// Get the source of the sub claim (optional, has default)
+	role.SubjectClaimSource = data.Get("subject_claim_source").(string)
+	role.SubjectClaimTemplate = data.Get("subject_claim_template").(string)
+	if err := validateSubjectClaimSource(role.SubjectClaimSource, role.SubjectClaimTemplate); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Get certificate header option (optional)
+	role.IncludeX5C = data.Get("include_x5c").(bool)
+
+	// Get paired ID token option (optional)
+	role.IssueIDToken = data.Get("issue_id_token").(bool)
+	if role.IssueIDToken && role.DetachedPayload {
+		return nil, logical.ErrorResponse("issue_id_token cannot be combined with detached_payload"), nil
+	}
+
+	// Get certificate binding requirement (optional)
+	role.RequireCertificateBinding = data.Get("require_certificate_binding").(bool)
+
+	// Get DPoP requirement (optional)
+	role.RequireDPoP = data.Get("require_dpop").(bool)
+
+	// Get token encryption (optional)
+	role.EncryptionKey = data.Get("encryption_key").(string)
+	role.EncryptionJWKSURI = data.Get("encryption_jwks_uri").(string)
+	if role.EncryptionKey != "" && role.EncryptionJWKSURI != "" {
+		return nil, logical.ErrorResponse("encryption_key cannot be combined with encryption_jwks_uri"), nil
+	}
+	if role.EncryptionKey != "" {
+		if _, err := parseEncryptionKey(role.EncryptionKey); err != nil {
+			return nil, logical.ErrorResponse("invalid encryption_key: %v", err), nil
+		}
+	}
+	if role.EncryptionJWKSURI != "" {
+		parsed, err := url.Parse(role.EncryptionJWKSURI)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, logical.ErrorResponse("encryption_jwks_uri must be an http or https URL"), nil
+		}
+	}
+	encrypted := role.EncryptionKey != "" || role.EncryptionJWKSURI != ""
+	if encrypted && role.DetachedPayload {
+		return nil, logical.ErrorResponse("detached_payload cannot be combined with token encryption"), nil
+	}
+	// The at_hash would bind the ID token to a token the caller never sees
+	if encrypted && role.IssueIDToken {
+		return nil, logical.ErrorResponse("issue_id_token cannot be combined with token encryption"), nil
+	}
+
+	// Get response wrapping TTL (optional)
+	role.WrapTTL = time.Duration(data.Get("wrap_ttl").(int)) * time.Second
+	if role.WrapTTL < 0 {
+		return nil, logical.ErrorResponse("wrap_ttl must not be negative"), nil
+	}
+
+	// Get refresh handle TTL (optional)
+	role.RefreshTTL = time.Duration(data.Get("refresh_ttl").(int)) * time.Second
+	if role.RefreshTTL < 0 {
+		return nil, logical.ErrorResponse("refresh_ttl must not be negative"), nil
+	}
+
+	// Get upstream STS chaining (optional)
+	if stsURL := data.Get("upstream_sts_url").(string); stsURL != "" {
+		parsed, err := url.Parse(stsURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, logical.ErrorResponse("upstream_sts_url must be an http or https URL"), nil
+		}
+		if role.DetachedPayload {
+			return nil, logical.ErrorResponse("detached_payload cannot be combined with upstream_sts_url"), nil
+		}
+		// The at_hash would bind the ID token to a token the caller never sees
+		if role.IssueIDToken {
+			return nil, logical.ErrorResponse("issue_id_token cannot be combined with upstream_sts_url"), nil
+		}
+		if encrypted {
+			return nil, logical.ErrorResponse("token encryption cannot be combined with upstream_sts_url"), nil
+		}
+
+		role.UpstreamSTS = &UpstreamSTS{
+			URL:          stsURL,
+			ClientID:     data.Get("upstream_client_id").(string),
+			ClientSecret: data.Get("upstream_client_secret").(string),
+			Audience:     data.Get("upstream_audience").(string),
+			Scope:        data.Get("upstream_scope").([]string),
+		}
+	}
+
+	// Get token profile (optional)
+	role.TokenProfile = data.Get("token_profile").(string)
+	role.TokenProfileAudience = data.Get("token_profile_audience").(string)
+	if resp, err := b.validateTokenProfile(ctx, storage, role, config); resp != nil || err != nil {
+		return nil, resp, err
+	}
+
+	// Get the authorization policy (optional)
+	role.Policy = data.Get("policy").(string)
+	if role.Policy != "" {
+		if _, err := compilePolicy(role.Policy); err != nil {
+			return nil, logical.ErrorResponse("invalid policy: %v", err), nil
+		}
+	}
+
+	// Get the custom claim namespace (optional)
+	role.ClaimNamespace = data.Get("claim_namespace").(string)
+	if err := validateClaimNamespace(role.ClaimNamespace); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+	if role.ClaimNamespace != "" && role.TokenProfile != TokenProfileDefault {
+		return nil, logical.ErrorResponse("claim_namespace cannot be combined with token_profile"), nil
+	}
+
+	// Get absolute deadline (optional)
+	if notAfter, ok := data.GetOk("not_after"); ok && notAfter.(string) != "" {
+		deadline, err := time.Parse(time.RFC3339, notAfter.(string))
+		if err != nil {
+			return nil, logical.ErrorResponse("not_after must be an RFC 3339 timestamp: %v", err), nil
+		}
+		role.NotAfter = deadline.UTC()
+	}
+
+	return role, nil, nil
+}
+
+// validateTemplate dry-runs a role template against placeholder data. Each
+// variable renders as 0, which is valid JSON both inside and outside a
+// string, so templates are only rejected for syntax that no claims could fix.
+func validateTemplate(template string, placeholders map[string]any) error {
+	if _, err := mustache.ParseString(template); err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	_, err := processTemplate(templateVariablePattern.ReplaceAllString(template, "0"), placeholders)
+	return err
+}
+
+// validateActorTemplate validates an actor template like validateTemplate and
+// rejects a top-level scope, which only the role's context may set
+func validateActorTemplate(template string) error {
+	if _, err := mustache.ParseString(template); err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	claims, err := processTemplate(templateVariablePattern.ReplaceAllString(template, "0"), actorTemplateContext(selfTestEntity(), nil, nil))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+		return err
+	}
+	if _, ok := claims["scope"]; ok {
+		return fmt.Errorf("scope is set from the role's context and cannot be set by the template")
 	}
+	return nil
+}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
-		return nil, fmt.Errorf("failed to write role: %w", err)
+// pathRoleStats returns the role's issuance counters and remaining quota
+func (b *Backend) pathRoleStats(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	if role == nil {
+		return nil, nil
+	}
+
+	if err := b.loadRoleUsage(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
+	usage := b.usage.snapshot(name)
+	if usage == nil {
+		usage = &roleUsage{}
+	}
+
+	day := usageDay(time.Now())
+	entities := make(map[string]any, len(usage.Entities))
+	for id, count := range usage.Entities {
+		entities[id] = map[string]any{
+			"tokens_issued_today": count.today(day),
+			"tokens_issued_total": count.Total,
+		}
+	}
+
+	respData := map[string]any{
+		"day":                 day,
+		"tokens_issued_today": usage.Role.today(day),
+		"tokens_issued_total": usage.Role.Total,
+		"max_tokens_per_day":  role.MaxTokensPerDay,
+		"entities":            entities,
+	}
+	if role.MaxTokensPerDay > 0 {
+		respData["quota_remaining"] = uint64(role.MaxTokensPerDay) - min(usage.Role.today(day), uint64(role.MaxTokensPerDay))
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// pathRolePreview renders a role's templates against sample input and returns
+// the claims that would be issued, without signing anything
+func (b *Backend) pathRolePreview(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role %q not found", name), nil
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("plugin not configured"), nil
+	}
+	config.applyRoleDefaults(role)
+
+	subjectClaims, err := previewSubjectClaims(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if _, ok := subjectClaims["sub"].(string); !ok {
+		return logical.ErrorResponse("subject claims must include sub"), nil
+	}
+
+	entity := &logical.Entity{
+		ID:          cmp.Or(req.EntityID, "preview-entity"),
+		Name:        data.Get("entity_name").(string),
+		NamespaceID: "root",
+		Metadata:    data.Get("entity_metadata").(map[string]string),
+	}
+	if entity.Metadata == nil {
+		entity.Metadata = map[string]string{}
+	}
+
+	fragments, err := b.getTemplateFragments(ctx, req.Storage, role.TemplateLibrary)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	actorClaims, err := renderInherited(fragments, actorFragment, role.ActorTemplate, actorTemplateContext(entity, nil, nil))
+	if err != nil {
+		return logical.ErrorResponse("failed to process actor template: %v", err), nil
+	}
+	templateClaims, err := renderInherited(fragments, subjectFragment, role.SubjectTemplate, subjectTemplateContext(subjectClaims, map[string]any{}))
+	if err != nil {
+		return logical.ErrorResponse("failed to process subject template: %v", err), nil
+	}
+	if role.IncludeVaultMeta {
+		actorClaims["vault_meta"] = vaultMetaClaim(req, entity)
+	}
+	subjectID, err := resolveSubjectClaim(role, entity, nil, subjectClaims, map[string]any{})
+	if err != nil {
+		return logical.ErrorResponse("failed to resolve sub: %v", err), nil
+	}
+
+	// The claims are previewed even when a real exchange would be refused
+	var warnings []string
+	checked := maps.Clone(subjectClaims)
+	if _, ok := checked["exp"]; !ok {
+		checked["exp"] = float64(time.Now().Add(time.Hour).Unix())
+	}
+	if resp := checkSubjectClaims(checked, role); resp != nil {
+		warnings = append(warnings, fmt.Sprintf("an exchange with these subject claims would be rejected: %v", resp.Error()))
+	}
+
+	// Without api_addr the preview simply omits the verification hint
+	jwksURL, _ := verificationURL(config, role, req.MountPoint)
+
+	scopes, err := expandScopes(ctx, req.Storage, role.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims, _ := buildTokenClaims(config, role, scopes, subjectID, actorClaims, templateClaims, "entity:"+entity.ID, now, time.Time{}, tokenExpiry(now, role.TTL, role.NotAfter), jwksURL)
+
+	return &logical.Response{
+		Data: map[string]any{
+			"key":    signingKeyName(role, config.DefaultKey, actorClaims["aud"]),
+			"claims": claims,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// previewSubjectClaims reads the sample subject claims from subject_claims or
+// from the unverified payload of subject_token
+func previewSubjectClaims(data *framework.FieldData) (map[string]any, error) {
+	rawToken, tokenOk := data.GetOk("subject_token")
+	rawClaims, claimsOk := data.GetOk("subject_claims")
+	if tokenOk == claimsOk {
+		return nil, fmt.Errorf("exactly one of subject_token or subject_claims is required")
+	}
+
+	claims := map[string]any{}
+	if tokenOk {
+		parsed, err := jwt.ParseSigned(rawToken.(string), supportedSubjectTokenAlgorithms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject_token: %w", err)
+		}
+		if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return nil, fmt.Errorf("invalid subject_token: %w", err)
+		}
+		return claims, nil
+	}
+
+	// Round trip the claims so numbers decode as they would from a token
+	encoded, err := json.Marshal(rawClaims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject_claims: %w", err)
+	}
+	if err := json.Unmarshal(encoded, &claims); err != nil {
+		return nil, fmt.Errorf("invalid subject_claims: %w", err)
+	}
+	return claims, nil
 }
 
 // pathRoleDelete handles deleting a role
 func (b *Backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	if resp, err := b.checkRoleNotExtended(ctx, req.Storage, name); resp != nil || err != nil {
+		return resp, err
+	}
+
 	if err := req.Storage.Delete(ctx, roleStoragePrefix+name); err != nil {
 		return nil, fmt.Errorf("failed to delete role: %w", err)
 	}
 
+	// A role created later with the same name starts counting afresh
+	b.usage.drop(name)
+	if err := deleteRoleUsage(ctx, req.Storage, name); err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
@@ -141,6 +816,19 @@ func (b *Backend) pathRoleList(ctx context.Context, req *logical.Request, data *
 		return nil, fmt.Errorf("failed to list roles: %w", err)
 	}
 
+	if tenant, ok := data.GetOk("tenant"); ok {
+		roles, err = filterByTenant(roles, tenant.(string), func(name string) (string, bool, error) {
+			role, err := b.getRole(ctx, req.Storage, name)
+			if err != nil || role == nil {
+				return "", false, err
+			}
+			return role.Tenant, true, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(roles) == 0 {
 		return nil, nil
 	}
@@ -148,6 +836,72 @@ func (b *Backend) pathRoleList(ctx context.Context, req *logical.Request, data *
 	return logical.ListResponse(roles), nil
 }
 
+// parseBoundClaims normalizes bound_claims values to lists of strings
+func parseBoundClaims(raw map[string]any) (map[string][]string, error) {
+	boundClaims := make(map[string][]string, len(raw))
+	for claim, value := range raw {
+		switch v := value.(type) {
+		case string:
+			boundClaims[claim] = []string{v}
+		case []any:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("claim %q values must be strings", claim)
+				}
+				values = append(values, s)
+			}
+			if len(values) == 0 {
+				return nil, fmt.Errorf("claim %q must list at least one value", claim)
+			}
+			boundClaims[claim] = values
+		default:
+			return nil, fmt.Errorf("claim %q must be a string or list of strings", claim)
+		}
+	}
+	return boundClaims, nil
+}
+
+// roleKeyNames returns the names of every key a role can sign with. Roles
+// without a key use defaultKey, the config's default_key.
+func roleKeyNames(role *Role, defaultKey string) []string {
+	var names []string
+	if key := cmp.Or(role.Key, defaultKey); key != "" {
+		names = append(names, key)
+	}
+	primary := len(names)
+	for _, keyName := range role.AudienceKeys {
+		if !slices.Contains(names, keyName) {
+			names = append(names, keyName)
+		}
+	}
+	slices.Sort(names[primary:])
+	return names
+}
+
+// durationSeconds formats a duration as whole seconds for API responses, the
+// form the duration parameters accept, so reads can be written back unchanged
+func durationSeconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// sortedStrings returns a sorted copy of values for API responses, so reads of
+// set-like lists are stable
+func sortedStrings(values []string) []string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// formatOptionalTime formats an optional timestamp for API responses
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
 // getRole retrieves a role from storage
 func (b *Backend) getRole(ctx context.Context, storage logical.Storage, name string) (*Role, error) {
 	entry, err := storage.Get(ctx, roleStoragePrefix+name)
@@ -163,6 +917,8 @@ func (b *Backend) getRole(ctx context.Context, storage logical.Storage, name str
 	if err := entry.DecodeJSON(role); err != nil {
 		return nil, fmt.Errorf("failed to decode role: %w", err)
 	}
+	role.CASVersion = storedVersion(role.CASVersion)
+	role.upgrade()
 
 	return role, nil
 }
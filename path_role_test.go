@@ -2,6 +2,8 @@ package tokenexchange
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -67,6 +69,40 @@ func TestRoleWrite_MissingTTL(t *testing.T) {
 	require.Contains(t, resp.Error().Error(), "ttl", "Error should mention missing ttl")
 }
 
+// TestRoleWrite_InvalidTTL tests that a role cannot issue already expired tokens
+func TestRoleWrite_InvalidTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+
+	resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"ttl": "0"})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError(), "ttl 0 should be rejected")
+	require.Contains(t, resp.Error().Error(), "ttl must be greater than zero")
+}
+
+// TestRoleRead_AllFields tests that a role read returns every stored field
+func TestRoleRead_AllFields(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+	require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, nil))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+
+	// upstream_sts is returned as the upstream_* fields when it is set,
+	// disabled is returned as enabled, parameters only as the overrides of
+	// roles that extend another, and template is only read from legacy storage
+	skip := map[string]bool{"upstream_sts": true, "disabled": true, "parameters": true, "template": true}
+	roleType := reflect.TypeOf(Role{})
+	for i := 0; i < roleType.NumField(); i++ {
+		name, _, _ := strings.Cut(roleType.Field(i).Tag.Get("json"), ",")
+		if skip[name] {
+			continue
+		}
+		require.Contains(t, resp.Data, name, "role read does not return %s", name)
+	}
+}
+
 // TestRoleWrite_MissingTemplate tests validation of required template fields
 func TestRoleWrite_MissingTemplate(t *testing.T) {
 	b, storage := getTestBackend(t)
@@ -93,6 +129,50 @@ func TestRoleWrite_MissingTemplate(t *testing.T) {
 	require.Contains(t, resp.Error().Error(), "template", "Error should mention missing template")
 }
 
+// TestRoleWrite_InvalidTemplate tests that templates are dry-run when the role is written
+func TestRoleWrite_InvalidTemplate(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+
+	tests := []struct {
+		name            string
+		actorTemplate   string
+		subjectTemplate string
+		wantErr         string
+	}{
+		{name: "valid with placeholders", actorTemplate: `{"act": {"sub": "agent:{{identity.entity.id}}"}}`, subjectTemplate: `{"email": "{{identity.subject.email}}", "permissions": {{identity.subject.permissions}}}`},
+		{name: "valid with section", actorTemplate: `{"act": {"sub": "agent"}}`, subjectTemplate: `{ {{#identity.subject.email}}"email": "{{.}}"{{/identity.subject.email}} }`},
+		{name: "invalid actor JSON", actorTemplate: `{"act": {"sub": "agent"}`, subjectTemplate: `{}`, wantErr: "invalid actor_template"},
+		{name: "invalid subject JSON", actorTemplate: `{}`, subjectTemplate: `{"email": {{identity.subject.email}}`, wantErr: "invalid subject_template"},
+		{name: "unclosed section", actorTemplate: `{}`, subjectTemplate: `{ {{#identity.subject.email}} }`, wantErr: "failed to parse template"},
+		{name: "unclosed tag", actorTemplate: `{"act": {"sub": "{{identity.entity.id"}}`, subjectTemplate: `{}`, wantErr: "invalid actor_template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/test-role",
+				Storage:   storage,
+				Data: map[string]any{
+					"ttl":              "1h",
+					"key":              "test-key",
+					"context":          []string{"urn:documents:read"},
+					"actor_template":   tt.actorTemplate,
+					"subject_template": tt.subjectTemplate,
+				},
+			})
+			require.NoError(t, err)
+			if tt.wantErr == "" {
+				require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+				return
+			}
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.wantErr)
+		})
+	}
+}
+
 // TestRoleRead_Success tests reading an existing role
 func TestRoleRead_Success(t *testing.T) {
 	b, storage := getTestBackend(t)
@@ -131,7 +211,8 @@ func TestRoleRead_Success(t *testing.T) {
 	require.NotNil(t, resp, "Should return response")
 	require.NotNil(t, resp.Data, "Response should have data")
 	require.Equal(t, "test-role", resp.Data["name"])
-	require.Equal(t, "1h0m0s", resp.Data["ttl"])
+	require.EqualValues(t, 3600, resp.Data["ttl"])
+	require.Equal(t, "1h0m0s", resp.Data["ttl_human"])
 	require.Equal(t, `{"act": {"sub": "agent-123"}}`, resp.Data["actor_template"])
 	require.Equal(t, `{"department": "{{.identity.subject.department}}"}`, resp.Data["subject_template"])
 	require.Equal(t, []string{"urn:documents:read"}, resp.Data["context"])
@@ -206,7 +287,7 @@ func TestRoleUpdate_Success(t *testing.T) {
 	}
 	resp, err = b.HandleRequest(context.Background(), readReq)
 	require.NoError(t, err)
-	require.Equal(t, "2h0m0s", resp.Data["ttl"])
+	require.EqualValues(t, 7200, resp.Data["ttl"])
 	require.Equal(t, `{"act": {"sub": "agent-456", "name": "Updated Agent"}}`, resp.Data["actor_template"])
 	require.Equal(t, `{"department": "{{.identity.subject.department}}", "role": "{{.identity.subject.role}}"}`, resp.Data["subject_template"])
 	require.Equal(t, []string{"urn:documents:read", "urn:documents:write"}, resp.Data["context"])
@@ -411,3 +492,121 @@ func TestPathRoleWrite_MissingKey(t *testing.T) {
 	require.True(t, resp.IsError())
 	require.Contains(t, resp.Error().Error(), "key is required")
 }
+
+// TestPathRoleWrite_UnsupportedKeyAlgorithm tests that a role cannot reference
+// a key whose algorithm cannot be used for signing
+func TestPathRoleWrite_UnsupportedKeyAlgorithm(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	createTestKey(t, b, storage, "legacy-key")
+
+	// Simulate a stored key with an algorithm this version cannot sign with
+	key, err := b.getKey(context.Background(), storage, "legacy-key")
+	require.NoError(t, err)
+	key.Algorithm = "HS256"
+	require.NoError(t, b.putKey(context.Background(), storage, key))
+
+	roleReq := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/bad-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"ttl":              "1h",
+			"key":              "legacy-key",
+			"actor_template":   `{}`,
+			"subject_template": `{}`,
+			"context":          []string{"scope1"},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), roleReq)
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "unsupported algorithm")
+}
+
+// TestRolePreview tests previewing the claims a role would issue
+func TestRolePreview(t *testing.T) {
+	b, storage := getTestBackend(t)
+	_, kid := setupTestExchange(t, b, storage, map[string]any{
+		"bound_issuer":     "https://idp.example.com",
+		"actor_template":   `{"act": {"sub": "agent:{{identity.entity.name}}"}, "owner": "{{identity.entity.metadata.owner}}"}`,
+		"subject_template": `{"email": "{{identity.subject.email}}", "groups": {{identity.subject.groups}}}`,
+	})
+
+	preview := func(data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/test-role/preview",
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	resp := preview(map[string]any{
+		"subject_claims":  map[string]any{"sub": "user-123", "iss": "https://idp.example.com", "email": "alice@example.com", "groups": []any{"eng", "ops"}},
+		"entity_name":     "billing-agent",
+		"entity_metadata": map[string]any{"owner": "team-billing"},
+	})
+	require.False(t, resp.IsError(), "preview failed: %v", resp.Error())
+	require.Empty(t, resp.Warnings)
+	require.Equal(t, "test-key", resp.Data["key"])
+
+	claims := resp.Data["claims"].(map[string]any)
+	require.Equal(t, "user-123", claims["sub"])
+	require.Equal(t, "agent:billing-agent", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "team-billing", claims["owner"])
+	require.Equal(t, map[string]any{"email": "alice@example.com", "groups": []any{"eng", "ops"}}, claims["subject_claims"])
+	require.NotContains(t, claims, "jti")
+
+	// A sample token is decoded without verifying its signature, and claims
+	// a real exchange would reject are reported as warnings
+	otherKey, _ := generateTestKeyPair(t)
+	resp = preview(map[string]any{
+		"subject_token": generateTestJWT(t, otherKey, kid, map[string]any{"sub": "user-456", "iss": "https://evil.example.com", "groups": []any{}}),
+	})
+	require.False(t, resp.IsError(), "preview failed: %v", resp.Error())
+	require.Equal(t, "user-456", resp.Data["claims"].(map[string]any)["sub"])
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "failed to validate issuer")
+
+	for name, data := range map[string]map[string]any{
+		"exactly one of subject_token or subject_claims": {},
+		"subject claims must include sub":                {"subject_claims": map[string]any{"email": "alice@example.com"}},
+		"invalid subject_token":                          {"subject_token": "not-a-jwt"},
+	} {
+		resp := preview(data)
+		require.True(t, resp.IsError(), name)
+		require.Contains(t, resp.Error().Error(), name)
+	}
+}
+
+// TestTokenExchange_DisabledRole tests that a disabled role rejects exchanges
+// until it is enabled again
+func TestTokenExchange_DisabledRole(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"enabled": false})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+	require.Contains(t, resp.Error().Error(), "disabled")
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, false, resp.Data["enabled"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      map[string]any{"ttl": "1h", "key": "test-key", "context": "urn:documents:read", "actor_template": `{"act": {"sub": "agent"}}`, "subject_template": `{}`},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "role write failed: %v", resp.Error())
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+}
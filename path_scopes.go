@@ -0,0 +1,81 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// ScopeDefinition is a high-level scope that implies other scopes. Tokens
+// carrying it also carry every scope it implies, directly or transitively.
+type ScopeDefinition struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Implies     []string `json:"implies"`
+}
+
+const scopeStoragePrefix = "scopes/"
+
+// pathScopes returns the path configuration for /scopes/:name endpoint
+func pathScopes(b *Backend) *framework.Path {
+	return &framework.Path{
+		// Scopes are often URNs, so names may contain colons
+		Pattern: "scopes/(?P<name>[^/]+)",
+
+		ExistenceCheck: b.pathScopeExistenceCheck,
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The scope, e.g. urn:docs:admin. It cannot contain slashes",
+				Required:    true,
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "What the scope grants",
+			},
+			"implies": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated scopes implied by this scope, e.g. urn:docs:read,urn:docs:write. Implied scopes may imply further scopes",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathScopeRead,
+				Summary:  "Read a scope definition",
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathScopeWrite,
+				Summary:  "Create a scope definition",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathScopeWrite,
+				Summary:  "Update a scope definition",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathScopeDelete,
+				Summary:  "Delete a scope definition",
+			},
+		},
+
+		HelpSynopsis:    "Manage scope hierarchies",
+		HelpDescription: "A scope definition lists the scopes a high-level scope implies. Role contexts and requested scopes are expanded with the definitions when a token is issued, so roles can use compact scopes such as urn:docs:admin while tokens carry urn:docs:admin, urn:docs:read and urn:docs:write.",
+	}
+}
+
+// pathScopesList returns the path configuration for /scopes endpoint (list)
+func pathScopesList(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "scopes/?$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathScopesList,
+				Summary:  "List scope definitions",
+			},
+		},
+
+		HelpSynopsis:    "List scope definitions",
+		HelpDescription: "List all scopes that imply other scopes.",
+	}
+}
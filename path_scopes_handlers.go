@@ -0,0 +1,115 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathScopeExistenceCheck checks if a scope definition exists
+func (b *Backend) pathScopeExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	definition, err := getScopeDefinition(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return definition != nil, nil
+}
+
+// pathScopeRead handles reading a scope definition
+func (b *Backend) pathScopeRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	definition, err := getScopeDefinition(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil {
+		return nil, nil
+	}
+
+	expanded, err := expandScopes(ctx, req.Storage, []string{definition.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"name":        definition.Name,
+			"description": definition.Description,
+			"implies":     definition.Implies,
+			"expanded":    expanded,
+		},
+	}, nil
+}
+
+// pathScopeWrite handles creating or updating a scope definition
+func (b *Backend) pathScopeWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	definition := &ScopeDefinition{
+		Name:        data.Get("name").(string),
+		Description: data.Get("description").(string),
+		Implies:     data.Get("implies").([]string),
+	}
+
+	if len(definition.Implies) == 0 {
+		return logical.ErrorResponse("implies is required"), nil
+	}
+	for _, scope := range definition.Implies {
+		if strings.ContainsAny(scope, " \t\n") {
+			return logical.ErrorResponse("implied scope %q must not contain whitespace", scope), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(scopeStoragePrefix+definition.Name, definition)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write scope definition: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathScopeDelete handles deleting a scope definition. Tokens issued
+// afterwards carry the scope without the scopes it implied.
+func (b *Backend) pathScopeDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, scopeStoragePrefix+data.Get("name").(string)); err != nil {
+		return nil, fmt.Errorf("failed to delete scope definition: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathScopesList handles listing scope definitions
+func (b *Backend) pathScopesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, scopeStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scope definitions: %w", err)
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+// getScopeDefinition retrieves a scope definition from storage
+func getScopeDefinition(ctx context.Context, storage logical.Storage, name string) (*ScopeDefinition, error) {
+	entry, err := storage.Get(ctx, scopeStoragePrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scope definition: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	definition := &ScopeDefinition{}
+	if err := entry.DecodeJSON(definition); err != nil {
+		return nil, fmt.Errorf("failed to decode scope definition: %w", err)
+	}
+
+	return definition, nil
+}
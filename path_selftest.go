@@ -0,0 +1,23 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathSelfTest returns the path configuration for /selftest endpoint
+func pathSelfTest(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "selftest",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSelfTest,
+				Summary:  "Run the plugin self-test",
+			},
+		},
+
+		HelpSynopsis:    "Run the plugin self-test",
+		HelpDescription: "Generates an ephemeral key, signs and verifies a token, and renders each role's templates against synthetic claims. Returns the result of every check so broken roles can be found immediately after an upgrade. A lightweight version of the role checks also runs when the plugin initializes.",
+	}
+}
@@ -0,0 +1,37 @@
+package tokenexchange
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathSelfTest handles running the self-test
+func (b *Backend) pathSelfTest(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	checks, err := b.runSelfTest(ctx, req.Storage, true)
+	if err != nil {
+		return nil, err
+	}
+
+	passed := true
+	results := make([]map[string]any, 0, len(checks))
+	for _, check := range checks {
+		result := map[string]any{
+			"name":   check.Name,
+			"passed": check.Passed,
+		}
+		if !check.Passed {
+			passed = false
+			result["error"] = check.Error
+		}
+		results = append(results, result)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"passed": passed,
+			"checks": results,
+		},
+	}, nil
+}
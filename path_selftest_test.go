@@ -0,0 +1,74 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSelfTest_Passes tests the self-test with a healthy role
+func TestSelfTest_Passes(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	setupTestExchange(t, b, storage, map[string]any{
+		"subject_template": `{"email": "{{identity.subject.email}}"}`,
+	})
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "selftest",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, true, resp.Data["passed"])
+
+	checks := resp.Data["checks"].([]map[string]any)
+	require.Len(t, checks, 2)
+	require.Equal(t, "sign_and_verify", checks[0]["name"])
+	require.Equal(t, "role/test-role", checks[1]["name"])
+}
+
+// TestSelfTest_ReportsBrokenRole tests that a role with an unrenderable
+// template is reported without failing the request
+func TestSelfTest_ReportsBrokenRole(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	setupTestExchange(t, b, storage, nil)
+
+	// Store a role whose template no longer renders to valid JSON
+	entry, err := logical.StorageEntryJSON(roleStoragePrefix+"broken", &Role{
+		Name:            "broken",
+		Key:             "test-key",
+		ActorTemplate:   `{}`,
+		SubjectTemplate: `{"email": {{identity.subject.email}}}`,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "selftest",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, false, resp.Data["passed"])
+
+	var broken map[string]any
+	for _, check := range resp.Data["checks"].([]map[string]any) {
+		if check["name"] == "role/broken" {
+			broken = check
+		}
+	}
+	require.NotNil(t, broken)
+	require.Equal(t, false, broken["passed"])
+	require.Contains(t, broken["error"], "subject_template")
+
+	// Startup self-test only logs failures
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+}
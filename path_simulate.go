@@ -0,0 +1,31 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathSimulateBatch returns the path configuration for /simulate/batch endpoint
+func pathSimulateBatch(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "simulate/batch",
+
+		Fields: map[string]*framework.FieldSchema{
+			"entries": {
+				Type:        framework.TypeSlice,
+				Description: "Scenarios to simulate. Each has a role, subject_claims, expect (\"success\" or an exchange error code) and optionally a name, entity_id and not_after",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSimulateBatch,
+				Summary:  "Simulate token exchanges and compare them with expected outcomes",
+			},
+		},
+
+		HelpSynopsis:    "Replay a corpus of token exchange scenarios",
+		HelpDescription: "Runs each scenario through the role, entity, directory and template checks of a real exchange, using the given subject claims in place of a signed subject token, and reports whether the outcome matched the expectation. No token is signed and nothing is recorded: rate limits, single-use tracking, upstream STS chaining, issuance records and metrics are skipped. Use it as a regression suite after changing roles or config.",
+	}
+}
@@ -0,0 +1,245 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxSimulationEntries bounds the scenarios in one simulate/batch request
+const maxSimulationEntries = 1000
+
+// simulationSuccess is the expected outcome of a scenario that issues a token
+const simulationSuccess = "success"
+
+// simulationEntry is one scenario of a simulation corpus
+type simulationEntry struct {
+	Name          string         `json:"name"`
+	Role          string         `json:"role"`
+	SubjectClaims map[string]any `json:"subject_claims"`
+	EntityID      string         `json:"entity_id"`
+	NotAfter      string         `json:"not_after"`
+	Scope         string         `json:"scope"`
+	DelegationCtx map[string]any `json:"delegation_ctx"`
+	Expect        string         `json:"expect"`
+}
+
+// newSimulationPipeline builds the pipeline for simulated exchanges. It runs
+// the read-only hooks of a real exchange and previews the token claims
+// instead of signing.
+func (b *Backend) newSimulationPipeline() *exchangePipeline {
+	p := &exchangePipeline{}
+
+	p.register(stageValidate, "simulated_request", b.validateSimulatedRequest)
+	p.register(stageValidate, "scopes", b.resolveScopes)
+	p.register(stageValidate, "delegation_ctx", b.resolveDelegationCtx)
+
+	p.register(stageAuthorize, "denied_scopes", b.authorizeScopes)
+	p.register(stageAuthorize, "bound_entity", b.authorizeBoundEntity)
+	p.register(stageAuthorize, "entity_metadata", b.authorizeEntityMetadata)
+	p.register(stageAuthorize, "policy", b.authorizePolicy)
+	p.register(stageAuthorize, "authorization_webhook", b.authorizeWebhook)
+
+	p.register(stageEnrich, "directory", b.enrichDirectory)
+	p.register(stageEnrich, "groups", b.enrichGroups)
+
+	p.register(stageTemplate, "templates", b.renderTemplates)
+	p.register(stageTemplate, "subject", b.resolveSubject)
+	p.register(stageTemplate, "vault_meta", b.addVaultMeta)
+	p.register(stageTemplate, "delegation_ctx", b.addDelegationCtx)
+	p.register(stageTemplate, "subject_fingerprint", b.addSubjectFingerprint)
+
+	p.register(stageSign, "preview", b.previewToken)
+
+	return p
+}
+
+// pathSimulateBatch handles replaying a corpus of exchange scenarios
+func (b *Backend) pathSimulateBatch(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawEntries := data.Get("entries").([]any)
+	if len(rawEntries) == 0 {
+		return logical.ErrorResponse("entries is required"), nil
+	}
+	if len(rawEntries) > maxSimulationEntries {
+		return logical.ErrorResponse("at most %d entries may be simulated at once", maxSimulationEntries), nil
+	}
+
+	entries := make([]*simulationEntry, 0, len(rawEntries))
+	for i, raw := range rawEntries {
+		entry, err := parseSimulationEntry(raw)
+		if err != nil {
+			return logical.ErrorResponse("invalid entry %d: %s", i, err), nil
+		}
+		entries = append(entries, entry)
+	}
+
+	passed := 0
+	results := make([]map[string]any, 0, len(entries))
+	for _, entry := range entries {
+		result := b.simulateExchange(ctx, req, entry)
+		if result["passed"].(bool) {
+			passed++
+		}
+		results = append(results, result)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"passed":  passed == len(entries),
+			"total":   len(entries),
+			"failed":  len(entries) - passed,
+			"results": results,
+		},
+	}, nil
+}
+
+// parseSimulationEntry decodes and checks a scenario from the request
+func parseSimulationEntry(raw any) (*simulationEntry, error) {
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &simulationEntry{}
+	if err := json.Unmarshal(encoded, entry); err != nil {
+		return nil, err
+	}
+
+	if entry.Role == "" {
+		return nil, fmt.Errorf("role is required")
+	}
+	if entry.SubjectClaims == nil {
+		return nil, fmt.Errorf("subject_claims is required")
+	}
+	if entry.Expect == "" {
+		return nil, fmt.Errorf("expect is required")
+	}
+	if entry.Expect != simulationSuccess && !isExchangeErrorCode(entry.Expect) {
+		return nil, fmt.Errorf("expect must be %q or an exchange error code, got %q", simulationSuccess, entry.Expect)
+	}
+
+	return entry, nil
+}
+
+// simulateExchange runs one scenario and compares its outcome with the expectation
+func (b *Backend) simulateExchange(ctx context.Context, req *logical.Request, entry *simulationEntry) map[string]any {
+	// Scenarios may run as another entity; the copy keeps the caller's request intact
+	simReq := *req
+	if entry.EntityID != "" {
+		simReq.EntityID = entry.EntityID
+	}
+
+	ex := &exchange{req: &simReq, roleName: entry.Role, start: time.Now(), simulation: entry}
+	resp, err := b.simulationPipeline.run(ctx, ex)
+
+	outcome := simulationSuccess
+	description := ""
+	switch {
+	case err != nil:
+		outcome = ErrCodeServerError
+		description = err.Error()
+	case resp.IsError():
+		outcome = ErrCodeServerError
+		if errData, ok := resp.Data["data"].(map[string]any); ok {
+			outcome, _ = errData["error"].(string)
+		}
+		description = resp.Error().Error()
+	}
+
+	result := map[string]any{
+		"name":    entry.Name,
+		"role":    entry.Role,
+		"expect":  entry.Expect,
+		"outcome": outcome,
+		"passed":  outcome == entry.Expect,
+	}
+	if description != "" {
+		result["error"] = description
+	}
+	if outcome == simulationSuccess {
+		result["key"] = ex.respData["key"]
+		result["claims"] = ex.respData["claims"]
+	}
+	return result
+}
+
+// validateSimulatedRequest loads the role and checks the scenario's subject
+// claims as if they came from a verified subject token
+func (b *Backend) validateSimulatedRequest(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	var requestNotAfter time.Time
+	if ex.simulation.NotAfter != "" {
+		var err error
+		requestNotAfter, err = time.Parse(time.RFC3339, ex.simulation.NotAfter)
+		if err != nil {
+			return exchangeError(ErrCodeInvalidRequest, "not_after must be an RFC 3339 timestamp: %v", err), nil
+		}
+	}
+
+	if resp, err := b.loadExchangeRole(ctx, ex, requestNotAfter); resp != nil || err != nil {
+		return resp, err
+	}
+	ex.requestedScope = ex.simulation.Scope
+	ex.delegationCtx = ex.simulation.DelegationCtx
+
+	// Round trip the claims so numbers decode as they would from a token
+	encoded, err := json.Marshal(ex.simulation.SubjectClaims)
+	if err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "invalid subject claims: %v", err), nil
+	}
+	claims := map[string]any{}
+	if err := json.Unmarshal(encoded, &claims); err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "invalid subject claims: %v", err), nil
+	}
+
+	// Scenarios usually describe a current token, so exp is optional
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = float64(time.Now().Add(time.Hour).Unix())
+	}
+	if _, ok := claims["sub"].(string); !ok {
+		return exchangeError(ErrCodeInvalidSubjectToken, "subject claims must include sub"), nil
+	}
+
+	if resp := checkSubjectClaims(claims, ex.role); resp != nil {
+		return resp, nil
+	}
+
+	ex.subjectClaims = claims
+	return nil, nil
+}
+
+// previewToken resolves the signing key and the claims the token would carry
+// without signing it
+func (b *Backend) previewToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	keyName := signingKeyName(ex.role, ex.config.DefaultKey, ex.actorClaims["aud"])
+	if keyName == "" {
+		return exchangeError(ErrCodeServerError, "role %q has no key and no default_key is configured", ex.roleName), nil
+	}
+	key, err := b.getKey(ctx, ex.req.Storage, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key %q: %w", keyName, err)
+	}
+	if key == nil {
+		return exchangeError(ErrCodeServerError, "key %q not found", keyName), nil
+	}
+	if _, err := signatureAlgorithm(key.Algorithm); err != nil {
+		return exchangeError(ErrCodeServerError, "key %q uses an unsupported algorithm %q", keyName, key.Algorithm), nil
+	}
+
+	jwksURL, err := verificationURL(ex.config, ex.role, ex.req.MountPoint)
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q: %v", ex.roleName, err), nil
+	}
+
+	now := time.Now()
+	claims, _ := buildTokenClaims(ex.config, ex.role, ex.scopes, ex.subject, ex.actorClaims, ex.templateClaims, ex.defaultActorSubject(), now, time.Time{}, tokenExpiry(now, ex.role.TTL, ex.notAfter), jwksURL)
+
+	ex.respData = map[string]any{
+		"key":    keyName,
+		"claims": claims,
+	}
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// simulateBatch replays the entries through simulate/batch
+func simulateBatch(t *testing.T, b *Backend, storage logical.Storage, entries []any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "simulate/batch",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data:      map[string]any{"entries": entries},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestSimulateBatch tests replaying scenarios and comparing their outcomes
+func TestSimulateBatch(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, map[string]any{
+		"bound_issuer":             "https://idp.example.com",
+		"bound_entity_ids":         "test-entity,other-entity",
+		"single_use_subject_token": true,
+	})
+
+	claims := map[string]any{"sub": "user-123", "iss": "https://idp.example.com", "jti": "token-1"}
+	entries := []any{
+		map[string]any{"name": "allowed", "role": "test-role", "subject_claims": claims, "expect": "success"},
+		// Single-use tracking is skipped, so the same claims succeed again
+		map[string]any{"name": "repeat", "role": "test-role", "subject_claims": claims, "expect": "success"},
+		map[string]any{"name": "other entity", "role": "test-role", "subject_claims": claims, "entity_id": "other-entity", "expect": "success"},
+		map[string]any{"name": "unbound entity", "role": "test-role", "subject_claims": claims, "entity_id": "rogue-entity", "expect": "access_denied"},
+		map[string]any{"name": "wrong issuer", "role": "test-role", "subject_claims": map[string]any{"sub": "user-123", "iss": "https://evil.example.com"}, "expect": "invalid_subject_token"},
+		map[string]any{"name": "expired", "role": "test-role", "subject_claims": map[string]any{"sub": "user-123", "iss": "https://idp.example.com", "exp": time.Now().Add(-time.Minute).Unix()}, "expect": "expired_subject_token"},
+		map[string]any{"name": "unknown role", "role": "missing", "subject_claims": claims, "expect": "invalid_target"},
+		map[string]any{"name": "regression", "role": "test-role", "subject_claims": map[string]any{"sub": "user-123", "iss": "https://evil.example.com"}, "expect": "success"},
+	}
+
+	resp := simulateBatch(t, b, storage, entries)
+	require.False(t, resp.IsError(), "simulation failed: %v", resp.Error())
+	require.Equal(t, false, resp.Data["passed"])
+	require.Equal(t, len(entries), resp.Data["total"])
+	require.Equal(t, 1, resp.Data["failed"])
+
+	results := resp.Data["results"].([]map[string]any)
+	for _, result := range results[:len(results)-1] {
+		require.True(t, result["passed"].(bool), "scenario %q: got %v (%v)", result["name"], result["outcome"], result["error"])
+	}
+
+	regression := results[len(results)-1]
+	require.False(t, regression["passed"].(bool))
+	require.Equal(t, "invalid_subject_token", regression["outcome"])
+	require.Contains(t, regression["error"], "failed to validate issuer")
+
+	// Successful scenarios preview the claims instead of returning a token
+	allowed := results[0]
+	require.NotContains(t, allowed, "token")
+	require.Equal(t, "test-key", allowed["key"])
+	previewClaims := allowed["claims"].(map[string]any)
+	require.Equal(t, "user-123", previewClaims["sub"])
+	require.Equal(t, "https://vault.example.com", previewClaims["iss"])
+	require.Equal(t, "urn:documents:read", previewClaims["scope"])
+	require.Equal(t, "agent-123", previewClaims["act"].(map[string]any)["sub"])
+	require.NotContains(t, previewClaims, "jti")
+
+	// Nothing was recorded against the mount
+	replayed, err := storage.List(context.Background(), replayStoragePrefix)
+	require.NoError(t, err)
+	require.Empty(t, replayed)
+}
+
+// TestSimulateBatch_InvalidEntries tests validation of the corpus
+func TestSimulateBatch_InvalidEntries(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+
+	tests := []struct {
+		name    string
+		entries []any
+		wantErr string
+	}{
+		{name: "empty", entries: []any{}, wantErr: "entries is required"},
+		{name: "missing role", entries: []any{map[string]any{"subject_claims": map[string]any{}, "expect": "success"}}, wantErr: "invalid entry 0: role is required"},
+		{name: "missing claims", entries: []any{map[string]any{"role": "test-role", "expect": "success"}}, wantErr: "subject_claims is required"},
+		{name: "missing expect", entries: []any{map[string]any{"role": "test-role", "subject_claims": map[string]any{}}}, wantErr: "expect is required"},
+		{name: "unknown expect", entries: []any{map[string]any{"role": "test-role", "subject_claims": map[string]any{}, "expect": "denied"}}, wantErr: "expect must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := simulateBatch(t, b, storage, tt.entries)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.wantErr)
+		})
+	}
+}
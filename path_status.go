@@ -0,0 +1,23 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathStatus returns the path configuration for /status endpoint
+func pathStatus(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "status",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStatusRead,
+				Summary:  "Report whether the mount is ready to exchange tokens",
+			},
+		},
+
+		HelpSynopsis:    "Report mount health",
+		HelpDescription: "Returns whether the config exists, the number of signing keys with their versions and last rotation times, the last fetch of each subject JWKS, and cache statistics, in a single read for readiness probes and dashboards. 'ready' is true once the plugin is configured and has a signing key that is enabled. Fetch history and cache statistics are held in memory on the node serving the request.",
+	}
+}
@@ -0,0 +1,81 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathStatusRead handles reading the mount health report
+func (b *Backend) pathStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNames, err := req.Storage.List(ctx, keyStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	enabledKeys := 0
+	keys := make(map[string]any, len(keyNames))
+	for _, keyName := range keyNames {
+		key, err := b.getKey(ctx, req.Storage, keyName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %q: %w", keyName, err)
+		}
+		if key == nil {
+			continue
+		}
+		if !key.Disabled {
+			enabledKeys++
+		}
+		keys[keyName] = map[string]any{
+			"version":       key.Version,
+			"enabled":       !key.Disabled,
+			"last_rotation": formatStatusTime(key.RotatedAt),
+			"next_rotation": formatStatusTime(key.nextRotation()),
+		}
+	}
+
+	stats := b.stats.snapshot()
+
+	b.cacheLock.RLock()
+	subjectJWKS := make(map[string]any, len(b.jwksStatus))
+	for uri, status := range b.jwksStatus {
+		subjectJWKS[uri] = map[string]any{
+			"last_fetch_attempt":    formatStatusTime(status.lastAttempt),
+			"last_successful_fetch": formatStatusTime(status.lastSuccess),
+			"last_error":            status.lastError,
+			"key_count":             len(status.kids),
+		}
+	}
+	cacheEntries := map[string]int{
+		"key":       len(b.keyCache),
+		"jwks":      len(b.jwksCache),
+		"directory": len(b.directoryCache),
+	}
+	b.cacheLock.RUnlock()
+
+	caches := stats["cache"].(map[string]any)
+	for name, entries := range cacheEntries {
+		caches[name].(map[string]any)["entries"] = entries
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"ready":                config != nil && enabledKeys > 0,
+			"configured":           config != nil,
+			"key_count":            len(keys),
+			"keys":                 keys,
+			"subject_jwks":         subjectJWKS,
+			"fetches_paused_until": formatStatusTime(b.jwksBreaker.openedUntil(time.Now())),
+			"cache":                caches,
+			"since":                stats["since"],
+		},
+	}, nil
+}
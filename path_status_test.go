@@ -0,0 +1,65 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// readStatus reads the mount health report
+func readStatus(t *testing.T, b *Backend, storage logical.Storage) map[string]any {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "status",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "status read failed: %v", resp.Error())
+	return resp.Data
+}
+
+func TestPathStatus(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	status := readStatus(t, b, storage)
+	require.Equal(t, false, status["ready"])
+	require.Equal(t, false, status["configured"])
+	require.Equal(t, 0, status["key_count"])
+
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	status = readStatus(t, b, storage)
+	require.Equal(t, true, status["ready"])
+	require.Equal(t, true, status["configured"])
+	require.Equal(t, 1, status["key_count"])
+
+	key := status["keys"].(map[string]any)["test-key"].(map[string]any)
+	require.Equal(t, 1, key["version"])
+	require.Equal(t, true, key["enabled"])
+	require.NotEmpty(t, key["last_rotation"])
+
+	subjectJWKS := status["subject_jwks"].(map[string]any)
+	require.Len(t, subjectJWKS, 1)
+	for _, fetch := range subjectJWKS {
+		require.NotEmpty(t, fetch.(map[string]any)["last_successful_fetch"])
+		require.Equal(t, "", fetch.(map[string]any)["last_error"])
+		require.Equal(t, 1, fetch.(map[string]any)["key_count"])
+	}
+
+	caches := status["cache"].(map[string]any)
+	require.Equal(t, 1, caches["jwks"].(map[string]any)["entries"])
+	require.Contains(t, caches["key"].(map[string]any), "hit_rate")
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/test-key/config",
+		Storage:   storage,
+		Data:      map[string]any{"enabled": false},
+	})
+	require.NoError(t, err)
+	require.Equal(t, false, readStatus(t, b, storage)["ready"])
+}
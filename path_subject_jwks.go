@@ -0,0 +1,23 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathSubjectJWKSStatus returns the path configuration for /subject_jwks/status endpoint
+func pathSubjectJWKSStatus(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "subject_jwks/status",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathSubjectJWKSStatusRead,
+				Summary:  "Report the health of the subject token issuer's JWKS",
+			},
+		},
+
+		HelpSynopsis:    "Report subject JWKS health and key age",
+		HelpDescription: "Returns the last successful fetch of the configured subject_jwks_uri, the last fetch error, the key count and key IDs, certificate expiry for keys that carry an x5c chain, and the time since a change in the key set was last observed. Use it to detect stale upstream key material before subject token validation starts failing. Status is held in memory on the node serving the request.",
+	}
+}
@@ -0,0 +1,225 @@
+package tokenexchange
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// subjectJWKSStatus is the observed fetch history of a subject JWKS
+type subjectJWKSStatus struct {
+	lastAttempt       time.Time
+	lastSuccess       time.Time
+	lastError         string
+	kids              []string
+	certificateExpiry map[string]time.Time
+	keysChangedAt     time.Time
+	seenKids          map[string]*seenKid
+
+	// unknownKids records when an unknown key ID last forced a refresh
+	unknownKids map[string]time.Time
+}
+
+// seenKid is the history of a key ID observed in a subject JWKS
+type seenKid struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+
+	// verifiedUntil is the latest expiry of a subject token verified with the key
+	verifiedUntil time.Time
+
+	removedAt    time.Time
+	removedEarly bool
+}
+
+// seenKidRetention is how long a removed key ID stays in the status report
+// after it was removed and every token it verified has expired
+const seenKidRetention = 24 * time.Hour
+
+// recordSubjectJWKSStatus updates the fetch history for a subject JWKS URI and
+// warns when a key ID disappears while tokens it verified are still valid
+func (b *Backend) recordSubjectJWKSStatus(jwksURI string, keySet *jose.JSONWebKeySet, fetchErr error, at time.Time) {
+	for kid, verifiedUntil := range b.updateSubjectJWKSStatus(jwksURI, keySet, fetchErr, at) {
+		b.recordKidRemovedEarly(jwksURI, kid, verifiedUntil)
+	}
+}
+
+// updateSubjectJWKSStatus applies a fetch result to the status and returns the
+// key IDs that were removed before tokens they verified had expired
+func (b *Backend) updateSubjectJWKSStatus(jwksURI string, keySet *jose.JSONWebKeySet, fetchErr error, at time.Time) map[string]time.Time {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	status, ok := b.jwksStatus[jwksURI]
+	if !ok {
+		status = &subjectJWKSStatus{seenKids: make(map[string]*seenKid), unknownKids: make(map[string]time.Time)}
+		b.jwksStatus[jwksURI] = status
+	}
+
+	status.lastAttempt = at
+	if fetchErr != nil {
+		status.lastError = fetchErr.Error()
+		return nil
+	}
+
+	kids := make([]string, 0, len(keySet.Keys))
+	expiry := make(map[string]time.Time)
+	for _, key := range keySet.Keys {
+		kids = append(kids, key.KeyID)
+		if len(key.Certificates) > 0 {
+			expiry[key.KeyID] = key.Certificates[0].NotAfter
+		}
+	}
+	slices.Sort(kids)
+
+	// The first successful fetch counts as an observed change
+	if status.lastSuccess.IsZero() || !slices.Equal(status.kids, kids) {
+		status.keysChangedAt = at
+	}
+
+	status.lastSuccess = at
+	status.lastError = ""
+	status.kids = kids
+	status.certificateExpiry = expiry
+
+	for _, kid := range kids {
+		seen, ok := status.seenKids[kid]
+		if !ok || !seen.removedAt.IsZero() {
+			seen = &seenKid{firstSeen: at}
+			status.seenKids[kid] = seen
+		}
+		seen.lastSeen = at
+	}
+
+	removedEarly := make(map[string]time.Time)
+	for kid, seen := range status.seenKids {
+		if slices.Contains(kids, kid) {
+			continue
+		}
+		if seen.removedAt.IsZero() {
+			seen.removedAt = at
+			if at.Before(seen.verifiedUntil) {
+				seen.removedEarly = true
+				removedEarly[kid] = seen.verifiedUntil
+			}
+			continue
+		}
+		if at.Sub(seen.removedAt) > seenKidRetention && at.Sub(seen.verifiedUntil) > seenKidRetention {
+			delete(status.seenKids, kid)
+		}
+	}
+
+	return removedEarly
+}
+
+// recordSubjectKidUse records the expiry of a subject token verified with kid
+func (b *Backend) recordSubjectKidUse(jwksURI, kid string, expiresAt time.Time) {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	status, ok := b.jwksStatus[jwksURI]
+	if !ok {
+		return
+	}
+	if seen, ok := status.seenKids[kid]; ok && expiresAt.After(seen.verifiedUntil) {
+		seen.verifiedUntil = expiresAt
+	}
+}
+
+// pathSubjectJWKSStatusRead handles reading the subject JWKS health report
+func (b *Backend) pathSubjectJWKSStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.hasStaticSubjectKeys() {
+		return logical.ErrorResponse("subject tokens are validated with static keys from the config, there is no subject JWKS to report"), nil
+	}
+	if config != nil && config.SubjectOIDCIssuer != "" {
+		discovered, err := b.discoveredJWKSConfig(ctx, config)
+		if err != nil {
+			return logical.ErrorResponse("failed to discover the subject JWKS: %v", err), nil
+		}
+		config = discovered
+	}
+	if config == nil || config.SubjectJWKSURI == "" {
+		return logical.ErrorResponse("subject_jwks_uri is not configured"), nil
+	}
+
+	// Refresh through the cache so the report is current. A fetch failure is
+	// recorded in the status rather than failing the read.
+	_, _ = b.getSubjectJWKS(ctx, req.Storage, config)
+
+	b.cacheLock.RLock()
+	defer b.cacheLock.RUnlock()
+
+	respData := map[string]any{
+		"jwks_uri":              config.SubjectJWKSURI,
+		"last_fetch_attempt":    "",
+		"last_successful_fetch": "",
+		"last_error":            "",
+		"key_count":             0,
+		"kids":                  []string{},
+		"certificate_expiry":    map[string]string{},
+		"fetches_paused_until":  formatStatusTime(b.jwksBreaker.openedUntil(time.Now())),
+	}
+
+	status, ok := b.jwksStatus[config.SubjectJWKSURI]
+	if !ok {
+		return &logical.Response{Data: respData}, nil
+	}
+
+	now := time.Now()
+	respData["last_fetch_attempt"] = formatStatusTime(status.lastAttempt)
+	respData["last_successful_fetch"] = formatStatusTime(status.lastSuccess)
+	respData["last_error"] = status.lastError
+
+	if status.lastSuccess.IsZero() {
+		return &logical.Response{Data: respData}, nil
+	}
+
+	respData["key_count"] = len(status.kids)
+	respData["kids"] = slices.Clone(status.kids)
+	respData["seconds_since_successful_fetch"] = int64(now.Sub(status.lastSuccess).Seconds())
+	respData["last_key_change"] = formatStatusTime(status.keysChangedAt)
+	respData["seconds_since_key_change"] = int64(now.Sub(status.keysChangedAt).Seconds())
+
+	certExpiry := make(map[string]string, len(status.certificateExpiry))
+	var earliest time.Time
+	for kid, notAfter := range status.certificateExpiry {
+		certExpiry[kid] = formatStatusTime(notAfter)
+		if earliest.IsZero() || notAfter.Before(earliest) {
+			earliest = notAfter
+		}
+	}
+	respData["certificate_expiry"] = certExpiry
+	if !earliest.IsZero() {
+		respData["earliest_certificate_expiry"] = formatStatusTime(earliest)
+	}
+
+	seenKids := make(map[string]any, len(status.seenKids))
+	for kid, seen := range status.seenKids {
+		seenKids[kid] = map[string]any{
+			"first_seen":     formatStatusTime(seen.firstSeen),
+			"last_seen":      formatStatusTime(seen.lastSeen),
+			"verified_until": formatStatusTime(seen.verifiedUntil),
+			"removed_at":     formatStatusTime(seen.removedAt),
+			"removed_early":  seen.removedEarly,
+		}
+	}
+	respData["seen_kids"] = seenKids
+
+	return &logical.Response{Data: respData}, nil
+}
+
+// formatStatusTime formats a status timestamp, using an empty string for never
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
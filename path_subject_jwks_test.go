@@ -0,0 +1,305 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// configureSubjectJWKS points the plugin config at the given JWKS URI
+func configureSubjectJWKS(t *testing.T, b *Backend, storage logical.Storage, jwksURI string) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]any{
+			"issuer":           "https://vault.example.com",
+			"subject_jwks_uri": jwksURI,
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+}
+
+// readSubjectJWKSStatus reads subject_jwks/status
+func readSubjectJWKSStatus(t *testing.T, b *Backend, storage logical.Storage) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "subject_jwks/status",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestSubjectJWKSStatus_NotConfigured tests the status read without a subject JWKS
+func TestSubjectJWKSStatus_NotConfigured(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp := readSubjectJWKSStatus(t, b, storage)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "subject_jwks_uri is not configured")
+}
+
+// TestSubjectJWKSStatus_Healthy tests the report for a reachable JWKS with an x5c chain
+func TestSubjectJWKSStatus_Healthy(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, _ := generateTestKeyPair(t)
+
+	notAfter := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "subject-issuer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:          &privateKey.PublicKey,
+		KeyID:        "cert-key",
+		Algorithm:    string(jose.RS256),
+		Use:          "sig",
+		Certificates: []*x509.Certificate{cert},
+	}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(jwks))
+	}))
+	t.Cleanup(server.Close)
+
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	resp := readSubjectJWKSStatus(t, b, storage)
+	require.False(t, resp.IsError(), "status read failed: %v", resp)
+	require.Equal(t, server.URL, resp.Data["jwks_uri"])
+	require.NotEmpty(t, resp.Data["last_successful_fetch"])
+	require.Empty(t, resp.Data["last_error"])
+	require.Equal(t, 1, resp.Data["key_count"])
+	require.Equal(t, []string{"cert-key"}, resp.Data["kids"])
+	require.Equal(t, map[string]string{"cert-key": notAfter.Format(time.RFC3339)}, resp.Data["certificate_expiry"])
+	require.Equal(t, notAfter.Format(time.RFC3339), resp.Data["earliest_certificate_expiry"])
+	require.NotEmpty(t, resp.Data["last_key_change"])
+}
+
+// TestSubjectJWKSStatus_FetchFailure tests that fetch errors are reported
+func TestSubjectJWKSStatus_FetchFailure(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	resp := readSubjectJWKSStatus(t, b, storage)
+	require.False(t, resp.IsError(), "status read failed: %v", resp)
+	require.NotEmpty(t, resp.Data["last_fetch_attempt"])
+	require.Empty(t, resp.Data["last_successful_fetch"])
+	require.Contains(t, resp.Data["last_error"], "status 500")
+	require.Equal(t, 0, resp.Data["key_count"])
+}
+
+// TestRecordSubjectJWKSStatus_KeyChange tests when a key set change is observed
+func TestRecordSubjectJWKSStatus_KeyChange(t *testing.T) {
+	b := NewBackend()
+	uri := "https://issuer.example.com/jwks"
+	keySet := func(kids ...string) *jose.JSONWebKeySet {
+		set := &jose.JSONWebKeySet{}
+		for _, kid := range kids {
+			set.Keys = append(set.Keys, jose.JSONWebKey{KeyID: kid})
+		}
+		return set
+	}
+
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.recordSubjectJWKSStatus(uri, keySet("a"), nil, first)
+	require.Equal(t, first, b.jwksStatus[uri].keysChangedAt)
+
+	// The same key set is not a change
+	b.recordSubjectJWKSStatus(uri, keySet("a"), nil, first.Add(time.Hour))
+	require.Equal(t, first, b.jwksStatus[uri].keysChangedAt)
+
+	// Failed fetches keep the last known keys
+	b.recordSubjectJWKSStatus(uri, nil, context.DeadlineExceeded, first.Add(2*time.Hour))
+	require.Equal(t, first, b.jwksStatus[uri].keysChangedAt)
+	require.Equal(t, first.Add(time.Hour), b.jwksStatus[uri].lastSuccess)
+	require.Equal(t, []string{"a"}, b.jwksStatus[uri].kids)
+
+	rotated := first.Add(3 * time.Hour)
+	b.recordSubjectJWKSStatus(uri, keySet("b", "a"), nil, rotated)
+	require.Equal(t, rotated, b.jwksStatus[uri].keysChangedAt)
+	require.Equal(t, []string{"a", "b"}, b.jwksStatus[uri].kids)
+	require.Empty(t, b.jwksStatus[uri].lastError)
+}
+
+// TestSubjectJWKS_KidRollover tests that an unknown kid triggers a JWKS refresh
+// and that removing a kid that verified unexpired tokens is reported
+func TestSubjectJWKS_KidRollover(t *testing.T) {
+	b, storage := getTestBackend(t)
+	oldKey, oldKID := setupTestExchange(t, b, storage, nil)
+	newKey, _ := generateTestKeyPair(t)
+	newKID := "test-key-2"
+
+	var mu sync.Mutex
+	published := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &oldKey.PublicKey, KeyID: oldKID, Use: "sig"}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(published))
+	}))
+	t.Cleanup(server.Close)
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	resp := exchangeTestToken(t, b, storage, oldKey, oldKID, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	// The issuer rotates to a new key and drops the old one
+	mu.Lock()
+	published = jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &newKey.PublicKey, KeyID: newKID, Use: "sig"}}}
+	mu.Unlock()
+
+	// Unknown kids only trigger a refresh once the refresh interval has passed
+	resp = exchangeTestToken(t, b, storage, newKey, newKID, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	b.cacheLock.Lock()
+	b.jwksStatus[server.URL].lastAttempt = time.Now().Add(-subjectJWKSRefreshInterval)
+	b.cacheLock.Unlock()
+
+	resp = exchangeTestToken(t, b, storage, newKey, newKID, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange after rollover failed: %v", resp.Error())
+
+	resp = readSubjectJWKSStatus(t, b, storage)
+	require.False(t, resp.IsError(), "status read failed: %v", resp)
+	require.Equal(t, []string{newKID}, resp.Data["kids"])
+
+	seenKids := resp.Data["seen_kids"].(map[string]any)
+	require.Len(t, seenKids, 2)
+	old := seenKids[oldKID].(map[string]any)
+	require.NotEmpty(t, old["removed_at"])
+	require.Equal(t, true, old["removed_early"])
+	require.Equal(t, false, seenKids[newKID].(map[string]any)["removed_early"])
+
+	jwksFetch := b.stats.snapshot()["jwks_fetch"].(map[string]any)
+	require.Equal(t, uint64(1), jwksFetch["kids_removed_early"])
+}
+
+// TestSubjectJWKS_UnknownKidRefresh tests that each unknown kid forces one
+// refresh shortly after the last fetch, and is not refetched again while the
+// refresh interval lasts
+func TestSubjectJWKS_UnknownKidRefresh(t *testing.T) {
+	b, storage := getTestBackend(t)
+	oldKey, oldKID := setupTestExchange(t, b, storage, nil)
+	newKey, _ := generateTestKeyPair(t)
+	newKID := "test-key-2"
+
+	var mu sync.Mutex
+	var fetches atomic.Int32
+	published := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &oldKey.PublicKey, KeyID: oldKID, Use: "sig"}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(published))
+	}))
+	t.Cleanup(server.Close)
+	configureSubjectJWKS(t, b, storage, server.URL)
+
+	// backdate moves the last fetch past the minimum refresh interval
+	backdate := func() {
+		b.cacheLock.Lock()
+		b.jwksStatus[server.URL].lastAttempt = time.Now().Add(-subjectJWKSMinRefreshInterval)
+		b.cacheLock.Unlock()
+	}
+
+	resp := exchangeTestToken(t, b, storage, oldKey, oldKID, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.EqualValues(t, 1, fetches.Load())
+
+	// The issuer adds a key a few seconds after the JWKS was cached
+	mu.Lock()
+	published.Keys = append(published.Keys, jose.JSONWebKey{Key: &newKey.PublicKey, KeyID: newKID, Use: "sig"})
+	mu.Unlock()
+	backdate()
+
+	resp = exchangeTestToken(t, b, storage, newKey, newKID, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange after rollover failed: %v", resp.Error())
+	require.EqualValues(t, 2, fetches.Load())
+
+	// A kid the issuer does not publish refreshes once per interval
+	backdate()
+	resp = exchangeTestToken(t, b, storage, newKey, "unknown-kid", defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.EqualValues(t, 3, fetches.Load())
+
+	// Other kids are limited by the minimum interval
+	resp = exchangeTestToken(t, b, storage, newKey, "another-kid", defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.EqualValues(t, 3, fetches.Load())
+
+	backdate()
+	resp = exchangeTestToken(t, b, storage, newKey, "unknown-kid", defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.EqualValues(t, 3, fetches.Load())
+
+	resp = exchangeTestToken(t, b, storage, newKey, "another-kid", defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.EqualValues(t, 4, fetches.Load())
+}
+
+// TestUpdateSubjectJWKSStatus_SeenKids tests kid history across fetches
+func TestUpdateSubjectJWKSStatus_SeenKids(t *testing.T) {
+	b := NewBackend()
+	uri := "https://issuer.example.com/jwks"
+	keySet := func(kids ...string) *jose.JSONWebKeySet {
+		set := &jose.JSONWebKeySet{}
+		for _, kid := range kids {
+			set.Keys = append(set.Keys, jose.JSONWebKey{KeyID: kid})
+		}
+		return set
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Empty(t, b.updateSubjectJWKSStatus(uri, keySet("a", "b"), nil, start))
+	b.recordSubjectKidUse(uri, "a", start.Add(time.Hour))
+
+	// b verified nothing, so its removal is expected
+	require.Empty(t, b.updateSubjectJWKSStatus(uri, keySet("a"), nil, start.Add(time.Minute)))
+	require.False(t, b.jwksStatus[uri].seenKids["b"].removedEarly)
+
+	// a verified a token that is still valid
+	removed := b.updateSubjectJWKSStatus(uri, keySet("c"), nil, start.Add(2*time.Minute))
+	require.Equal(t, map[string]time.Time{"a": start.Add(time.Hour)}, removed)
+	require.True(t, b.jwksStatus[uri].seenKids["a"].removedEarly)
+
+	// A kid that reappears starts a new history
+	b.updateSubjectJWKSStatus(uri, keySet("b", "c"), nil, start.Add(3*time.Minute))
+	require.True(t, b.jwksStatus[uri].seenKids["b"].removedAt.IsZero())
+	require.Equal(t, start.Add(3*time.Minute), b.jwksStatus[uri].seenKids["b"].firstSeen)
+
+	// Removed kids are forgotten after the retention period
+	b.updateSubjectJWKSStatus(uri, keySet("b", "c"), nil, start.Add(time.Hour+seenKidRetention+time.Minute))
+	require.NotContains(t, b.jwksStatus[uri].seenKids, "a")
+	require.Contains(t, b.jwksStatus[uri].seenKids, "c")
+}
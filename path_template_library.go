@@ -0,0 +1,85 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// TemplateFragment is a reusable pair of actor and subject template fragments
+// that roles inherit through template_library
+type TemplateFragment struct {
+	Name            string `json:"name"`
+	Description     string `json:"description,omitempty"`
+	ActorTemplate   string `json:"actor_template,omitempty"`
+	SubjectTemplate string `json:"subject_template,omitempty"`
+}
+
+const templateLibraryStoragePrefix = "template_library/"
+
+// pathTemplateLibrary returns the path configuration for /template_library/:name endpoint
+func pathTemplateLibrary(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "template_library/" + framework.GenericNameRegex("name"),
+
+		ExistenceCheck: b.pathTemplateFragmentExistenceCheck,
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the template fragment",
+				Required:    true,
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "What the fragment provides, e.g. compliance tags for regulated data",
+			},
+			"actor_template": {
+				Type:        framework.TypeString,
+				Description: "JSON template fragment merged into the actor claims of roles that reference it",
+			},
+			"subject_template": {
+				Type:        framework.TypeString,
+				Description: "JSON template fragment merged into the subject claims of roles that reference it",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathTemplateFragmentRead,
+				Summary:  "Read a template fragment",
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathTemplateFragmentWrite,
+				Summary:  "Create a template fragment",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTemplateFragmentWrite,
+				Summary:  "Update a template fragment",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathTemplateFragmentDelete,
+				Summary:  "Delete a template fragment",
+			},
+		},
+
+		HelpSynopsis:    "Manage reusable template fragments",
+		HelpDescription: "Template fragments define claims shared by many roles, such as department, environment or compliance tags. Roles list fragments in template_library. The fragments are rendered in order and merged, and the role's own templates override them.",
+	}
+}
+
+// pathTemplateLibraryList returns the path configuration for /template_library endpoint (list)
+func pathTemplateLibraryList(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "template_library/?$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathTemplateLibraryList,
+				Summary:  "List template fragments",
+			},
+		},
+
+		HelpSynopsis:    "List template fragments",
+		HelpDescription: "List all template fragments in the library.",
+	}
+}
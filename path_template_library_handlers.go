@@ -0,0 +1,202 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathTemplateFragmentExistenceCheck checks if a template fragment exists
+func (b *Backend) pathTemplateFragmentExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	fragment, err := b.getTemplateFragment(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return fragment != nil, nil
+}
+
+// pathTemplateFragmentRead handles reading a template fragment
+func (b *Backend) pathTemplateFragmentRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	fragment, err := b.getTemplateFragment(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if fragment == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"name":             fragment.Name,
+			"description":      fragment.Description,
+			"actor_template":   fragment.ActorTemplate,
+			"subject_template": fragment.SubjectTemplate,
+		},
+	}, nil
+}
+
+// pathTemplateFragmentWrite handles creating or updating a template fragment
+func (b *Backend) pathTemplateFragmentWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	fragment := &TemplateFragment{
+		Name:            data.Get("name").(string),
+		Description:     data.Get("description").(string),
+		ActorTemplate:   data.Get("actor_template").(string),
+		SubjectTemplate: data.Get("subject_template").(string),
+	}
+
+	if fragment.ActorTemplate == "" && fragment.SubjectTemplate == "" {
+		return logical.ErrorResponse("at least one of actor_template or subject_template is required"), nil
+	}
+	if fragment.ActorTemplate != "" {
+		if err := validateActorTemplate(fragment.ActorTemplate); err != nil {
+			return logical.ErrorResponse("invalid actor_template: %v", err), nil
+		}
+	}
+	if fragment.SubjectTemplate != "" {
+		if err := validateTemplate(fragment.SubjectTemplate, subjectTemplateContext(selfTestSubjectClaims(), map[string]any{})); err != nil {
+			return logical.ErrorResponse("invalid subject_template: %v", err), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(templateLibraryStoragePrefix+fragment.Name, fragment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write template fragment: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathTemplateFragmentDelete handles deleting a template fragment. Fragments
+// that roles still inherit cannot be deleted.
+func (b *Backend) pathTemplateFragmentDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	roleNames, err := req.Storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	var referencing []string
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && slices.Contains(role.TemplateLibrary, name) {
+			referencing = append(referencing, roleName)
+		}
+	}
+	if len(referencing) > 0 {
+		return logical.ErrorResponse("template fragment %q is in use by roles: %s", name, strings.Join(referencing, ", ")), nil
+	}
+
+	if err := req.Storage.Delete(ctx, templateLibraryStoragePrefix+name); err != nil {
+		return nil, fmt.Errorf("failed to delete template fragment: %w", err)
+	}
+
+	return nil, nil
+}
+
+// pathTemplateLibraryList handles listing template fragments
+func (b *Backend) pathTemplateLibraryList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, templateLibraryStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template fragments: %w", err)
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+// getTemplateFragment retrieves a template fragment from storage
+func (b *Backend) getTemplateFragment(ctx context.Context, storage logical.Storage, name string) (*TemplateFragment, error) {
+	entry, err := storage.Get(ctx, templateLibraryStoragePrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template fragment: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	fragment := &TemplateFragment{}
+	if err := entry.DecodeJSON(fragment); err != nil {
+		return nil, fmt.Errorf("failed to decode template fragment: %w", err)
+	}
+
+	return fragment, nil
+}
+
+// getTemplateFragments loads the named fragments in order. A missing fragment
+// is an error.
+func (b *Backend) getTemplateFragments(ctx context.Context, storage logical.Storage, names []string) ([]*TemplateFragment, error) {
+	fragments := make([]*TemplateFragment, 0, len(names))
+	for _, name := range names {
+		fragment, err := b.getTemplateFragment(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if fragment == nil {
+			return nil, fmt.Errorf("template fragment %q not found", name)
+		}
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// renderInherited renders the fragments' templates selected by pick, then the
+// role's template, deep merging the claims so later templates override
+// earlier ones
+func renderInherited(fragments []*TemplateFragment, pick func(*TemplateFragment) string, roleTemplate string, data map[string]any) (map[string]any, error) {
+	claims := map[string]any{}
+	for _, fragment := range fragments {
+		template := pick(fragment)
+		if template == "" {
+			continue
+		}
+		fragmentClaims, err := processTemplate(template, data)
+		if err != nil {
+			return nil, fmt.Errorf("template fragment %q: %w", fragment.Name, err)
+		}
+		mergeClaims(claims, fragmentClaims)
+	}
+
+	roleClaims, err := processTemplate(roleTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	mergeClaims(claims, roleClaims)
+
+	return claims, nil
+}
+
+// mergeClaims merges src into dst. Nested objects are merged key by key and
+// any other value in src replaces the one in dst.
+func mergeClaims(dst, src map[string]any) {
+	for key, value := range src {
+		srcMap, srcOk := value.(map[string]any)
+		dstMap, dstOk := dst[key].(map[string]any)
+		if srcOk && dstOk {
+			merged := maps.Clone(dstMap)
+			mergeClaims(merged, srcMap)
+			dst[key] = merged
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// actorFragment and subjectFragment select a fragment's templates
+func actorFragment(f *TemplateFragment) string   { return f.ActorTemplate }
+func subjectFragment(f *TemplateFragment) string { return f.SubjectTemplate }
@@ -0,0 +1,97 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplateFragment writes a template_library entry
+func writeTemplateFragment(t *testing.T, b *Backend, storage logical.Storage, name string, data map[string]any) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "template_library/" + name,
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// TestTemplateLibrary tests roles inheriting and overriding template fragments
+func TestTemplateLibrary(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	require.Nil(t, writeTemplateFragment(t, b, storage, "org", map[string]any{
+		"description":      "Org-wide claims",
+		"actor_template":   `{"act": {"sub": "agent-default", "org": "example"}, "environment": "production", "department": "{{identity.entity.metadata.department}}"}`,
+		"subject_template": `{"email": "{{identity.subject.email}}", "compliance": ["sox"]}`,
+	}))
+	require.Nil(t, writeTemplateFragment(t, b, storage, "staging", map[string]any{
+		"actor_template": `{"environment": "staging"}`,
+	}))
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "template_library/org", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, "Org-wide claims", resp.Data["description"])
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.ListOperation, Path: "template_library/", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, []string{"org", "staging"}, resp.Data["keys"])
+
+	// Later fragments override earlier ones and the role overrides both
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"template_library": "org,staging",
+		"actor_template":   `{"act": {"sub": "agent-123"}}`,
+		"subject_template": `{"compliance": ["sox", "pci"]}`,
+	})
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "agent-123", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "staging", claims["environment"])
+	require.Equal(t, "engineering", claims["department"])
+	require.Equal(t, map[string]any{"email": "user@example.com", "compliance": []any{"sox", "pci"}}, claims["subject_claims"])
+
+	// Fragments in use cannot be deleted
+	resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.DeleteOperation, Path: "template_library/org", Storage: storage})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "in use by roles: test-role")
+}
+
+// TestTemplateLibrary_Validation tests fragment and reference validation
+func TestTemplateLibrary_Validation(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp := writeTemplateFragment(t, b, storage, "empty", map[string]any{"description": "nothing"})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "at least one of actor_template or subject_template is required")
+
+	resp = writeTemplateFragment(t, b, storage, "broken", map[string]any{"actor_template": `{"environment": `})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "invalid actor_template")
+
+	createTestKey(t, b, storage, "test-key")
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   "{}",
+			"subject_template": "{}",
+			"context":          "urn:documents:read",
+			"template_library": "missing",
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), `template fragment "missing" not found`)
+}
@@ -0,0 +1,150 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// createTenantKey creates an RS256 key belonging to tenant
+func createTenantKey(t *testing.T, b *Backend, storage logical.Storage, name, tenant string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "key/" + name,
+		Storage:   storage,
+		Data:      map[string]any{"algorithm": AlgorithmRS256, "tenant": tenant},
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// readJWKSPath reads the JWKS served at path
+func readJWKSPath(t *testing.T, b *Backend, storage logical.Storage, path string) *jose.JSONWebKeySet {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: path, Storage: storage})
+	require.NoError(t, err)
+	jwks := &jose.JSONWebKeySet{}
+	require.NoError(t, json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), jwks))
+	return jwks
+}
+
+func TestTenant_Exchange(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	resp := createTenantKey(t, b, storage, "team-a-key", "team-a")
+	require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+	tenantKID := resp.Data["key_id"].(string)
+
+	require.Nil(t, writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"key": "team-a-key", "tenant": "team-a"}))
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	// The token verifies against the tenant JWKS, which the mount JWKS leaves out
+	jwks := readJWKSPath(t, b, storage, "jwks/team-a")
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, tenantKID, jwks.Keys[0].KeyID)
+	require.Empty(t, readJWKSPath(t, b, storage, "jwks").Key(tenantKID))
+
+	token, err := jwt.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{jose.RS256})
+	require.NoError(t, err)
+	claims := map[string]any{}
+	require.NoError(t, token.Claims(jwks.Keys[0].Key, &claims))
+	require.Equal(t, "https://vault.example.com/tenant/team-a", claims["iss"])
+	require.Equal(t, "https://vault.example.com/tenant/team-a", claims["act"].(map[string]any)["iss"])
+
+	require.Empty(t, readJWKSPath(t, b, storage, "jwks/team-b").Keys)
+}
+
+func TestTenant_KeyIsolation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+	require.False(t, createTenantKey(t, b, storage, "team-a-key", "team-a").IsError())
+
+	t.Run("tenant role with a shared key", func(t *testing.T) {
+		resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"tenant": "team-a"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `does not belong to tenant "team-a"`)
+	})
+
+	t.Run("shared role with a tenant key", func(t *testing.T) {
+		resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"key": "team-a-key"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "cannot sign for a role without a tenant")
+	})
+
+	t.Run("another tenant's key", func(t *testing.T) {
+		resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"key": "team-a-key", "tenant": "team-b"})
+		require.True(t, resp.IsError())
+	})
+
+	t.Run("tenant default key", func(t *testing.T) {
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"default_key": "team-a-key"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "cannot be shared")
+	})
+
+	t.Run("tenant audit key", func(t *testing.T) {
+		resp := configureAuditKey(t, b, storage, "team-a-key")
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "cannot be shared")
+	})
+
+	t.Run("invalid tenant", func(t *testing.T) {
+		resp := createTenantKey(t, b, storage, "bad-key", "team a")
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "tenant must be")
+	})
+}
+
+func TestTenant_ListFiltering(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+	require.False(t, createTenantKey(t, b, storage, "team-a-key", "team-a").IsError())
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/team-a-role",
+		Storage:   storage,
+		Data:      map[string]any{"ttl": "1h", "key": "team-a-key", "tenant": "team-a", "actor_template": `{}`, "subject_template": `{}`, "context": "urn:documents:read"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+	list := func(path string, data map[string]any) any {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ListOperation, Path: path, Storage: storage, Data: data})
+		require.NoError(t, err)
+		if resp == nil {
+			return nil
+		}
+		return resp.Data["keys"]
+	}
+	require.Equal(t, []string{"team-a-key", "test-key"}, list("key/", nil))
+	require.Equal(t, []string{"team-a-key"}, list("key/", map[string]any{"tenant": "team-a"}))
+	require.Equal(t, []string{"team-a-role"}, list("role/", map[string]any{"tenant": "team-a"}))
+	require.Nil(t, list("role/", map[string]any{"tenant": "team-b"}))
+}
+
+func TestTenant_Discovery(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"api_addr": "https://vault.example.com"}))
+	resp := createTenantKey(t, b, storage, "team-a-key", "team-a")
+	require.False(t, resp.IsError())
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:  logical.ReadOperation,
+		Path:       "tenant/team-a/.well-known/openid-configuration",
+		Storage:    storage,
+		MountPoint: "identity-delegation/",
+	})
+	require.NoError(t, err)
+
+	document := map[string]any{}
+	require.NoError(t, json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &document))
+	require.Equal(t, "https://vault.example.com/tenant/team-a", document["issuer"])
+	require.Equal(t, "https://vault.example.com/v1/identity-delegation/jwks/team-a", document["jwks_uri"])
+}
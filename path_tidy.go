@@ -0,0 +1,30 @@
+package tokenexchange
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathTidy returns the path configuration for /tidy endpoint
+func pathTidy(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy",
+
+		Fields: map[string]*framework.FieldSchema{
+			"safety_buffer": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long after expiry replay records, reference and refresh tokens and rotated key versions are kept. Defaults to the config's tidy_safety_buffer",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTidy,
+				Summary:  "Delete expired replay records, reference and refresh tokens, key versions, records and cache entries",
+			},
+		},
+
+		HelpSynopsis:    "Delete expired state",
+		HelpDescription: "Deletes replay records of single-use subject tokens and DPoP proofs, reference and refresh tokens, and rotated key versions no longer published in the JWKS, once they expired more than the safety buffer ago. Also deletes issuance records and issuance log entries past their retention, the oldest issuance log entries beyond issuance_log_max_entries, and expired JWKS and directory cache entries on the node serving the request. The same tidy runs periodically.",
+	}
+}
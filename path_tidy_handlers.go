@@ -0,0 +1,37 @@
+package tokenexchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathTidy handles running a tidy on demand
+func (b *Backend) pathTidy(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	safetyBuffer := config.tidySafetyBuffer()
+	if raw, ok := data.GetOk("safety_buffer"); ok {
+		safetyBuffer = time.Duration(raw.(int)) * time.Second
+		if safetyBuffer < 0 {
+			return logical.ErrorResponse("safety_buffer must not be negative"), nil
+		}
+	}
+
+	result, err := b.tidy(ctx, req.Storage, safetyBuffer)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return logical.ErrorResponse("a tidy is already running"), nil
+	}
+
+	return &logical.Response{
+		Data: result.responseData(safetyBuffer),
+	}, nil
+}
@@ -0,0 +1,142 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTidy tests deleting expired replay records, key versions and cache
+// entries through the tidy endpoint
+func TestTidy(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	now := time.Now()
+
+	// Replay records that expired 2 hours and 10 minutes ago, and one still active
+	for fingerprint, expiresAt := range map[string]time.Time{
+		"old":    now.Add(-2 * time.Hour),
+		"recent": now.Add(-10 * time.Minute),
+		"active": now.Add(time.Hour),
+	} {
+		_, err := b.consumeSubjectToken(ctx, storage, fingerprint, expiresAt)
+		require.NoError(t, err)
+	}
+
+	// Two rotated key versions, one expired 2 hours ago and one still published
+	for range 2 {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "key/test-key/rotate", Storage: storage})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "rotate failed: %v", resp)
+	}
+	key, err := b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+	require.Len(t, key.PreviousVersions, 2)
+	key.PreviousVersions[0].ExpiresAt = now.Add(-2 * time.Hour)
+	require.NoError(t, b.putKey(ctx, storage, key))
+
+	// An expired cache entry
+	b.cacheLock.Lock()
+	b.jwksCache["https://stale.example.com/jwks"] = &jwksCacheEntry{expiresAt: now.Add(-time.Minute)}
+	b.cacheLock.Unlock()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+		Data:      map[string]any{"safety_buffer": "1h"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "tidy failed: %v", resp.Error())
+	require.Equal(t, "1h0m0s", resp.Data["safety_buffer"])
+	require.Equal(t, 1, resp.Data["replay_records_deleted"])
+	require.Equal(t, 1, resp.Data["key_versions_deleted"])
+	require.Equal(t, 1, resp.Data["cache_entries_deleted"])
+
+	remaining, err := storage.List(ctx, replayStoragePrefix)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"recent", "active"}, remaining)
+
+	key, err = b.getKey(ctx, storage, "test-key")
+	require.NoError(t, err)
+	require.Len(t, key.PreviousVersions, 1)
+
+	b.cacheLock.RLock()
+	require.NotContains(t, b.jwksCache, "https://stale.example.com/jwks")
+	b.cacheLock.RUnlock()
+
+	// Without a safety buffer the remaining expired record is deleted too
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+		Data:      map[string]any{"safety_buffer": "0s"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Data["replay_records_deleted"])
+	require.Equal(t, 0, resp.Data["key_versions_deleted"])
+}
+
+// TestTidy_SafetyBufferConfig tests the configured default safety buffer
+func TestTidy_SafetyBufferConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+	require.NoError(t, err)
+	require.EqualValues(t, 259200, resp.Data["tidy_safety_buffer"])
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"tidy_safety_buffer": "0s"})
+	require.True(t, resp.IsError())
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"tidy_safety_buffer": "30m"})
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "tidy", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, "30m0s", resp.Data["safety_buffer"])
+}
+
+// TestTidy_Interval tests that the periodic function only tidies once
+// tidy_interval has passed since the last tidy
+func TestTidy_Interval(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"tidy_interval": "0s"})
+	require.True(t, resp.IsError())
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"tidy_interval": "1h", "tidy_safety_buffer": "1m"})
+	require.Nil(t, resp)
+
+	expired := func(fingerprint string) {
+		_, err := b.consumeSubjectToken(ctx, storage, fingerprint, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+	}
+	remaining := func() []string {
+		keys, err := storage.List(ctx, replayStoragePrefix)
+		require.NoError(t, err)
+		return keys
+	}
+
+	// Without a recorded tidy the first tick tidies
+	expired("first")
+	require.NoError(t, b.periodic(ctx, &logical.Request{Storage: storage}))
+	require.Empty(t, remaining())
+
+	// Later ticks wait for the interval
+	expired("second")
+	require.NoError(t, b.periodic(ctx, &logical.Request{Storage: storage}))
+	require.Equal(t, []string{"second"}, remaining())
+
+	require.NoError(t, putTidyStatus(ctx, storage, time.Now().Add(-2*time.Hour)))
+	require.NoError(t, b.periodic(ctx, &logical.Request{Storage: storage}))
+	require.Empty(t, remaining())
+}
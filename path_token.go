@@ -13,24 +13,139 @@ func pathToken(b *Backend) *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"name": {
 				Type:        framework.TypeString,
-				Description: "Name of the role to use for token exchange",
+				Description: "Name of the role to use for token exchange. by-entity uses the role named by the requesting entity's metadata key set in entity_role_metadata_key",
 				Required:    true,
 			},
 			"subject_token": {
 				Type:        framework.TypeString,
-				Description: "The subject token (JWT) to exchange",
+				Description: "The subject token to exchange",
 				Required:    true,
 			},
+			"subject_token_type": {
+				Type:        framework.TypeString,
+				Description: "RFC 8693 type of the subject token. urn:ietf:params:oauth:token-type:jwt is verified against the subject keys. urn:ietf:params:oauth:token-type:access_token is introspected when the config sets introspection_url, and verified as a JWT otherwise. urn:ietf:params:oauth:token-type:saml2 is a base64url-encoded SAML 2.0 assertion verified against the configured SAML IdP. vault_token is a Vault token whose entity is the subject. Other types are accepted when a registered subject token validator supports them",
+				Default:     tokenTypeJWT,
+			},
+			"requested_token_type": {
+				Type:        framework.TypeString,
+				Description: "RFC 8693 type of the token to issue. urn:ietf:params:oauth:token-type:jwt issues a signed JWT. urn:ietf:params:oauth:token-type:access_token issues an opaque reference token whose claims are stored by the mount and resolved with the introspect endpoint, for environments where intermediaries must not see the claims",
+				Default:     tokenTypeJWT,
+			},
+			"not_after": {
+				Type:        framework.TypeString,
+				Description: "Optional absolute deadline (RFC 3339) for this exchange. Can only shorten the token lifetime, never extend it beyond the role's ttl or not_after.",
+			},
+			"not_before": {
+				Type:        framework.TypeString,
+				Description: "Optional future time (RFC 3339) at which the token becomes valid, for scheduled jobs. Must be within the role's issuance_window. The token is valid for the role's ttl from then",
+			},
+			"certificate_thumbprint": {
+				Type:        framework.TypeString,
+				Description: "Optional x5t#S256 thumbprint (unpadded base64url SHA-256 of the DER certificate) of the client certificate the token is bound to. Adds an RFC 8705 cnf claim so resource servers can require proof of possession over mTLS",
+			},
+			"bind_client_certificate": {
+				Type:        framework.TypeBool,
+				Description: "Bind the token to the client certificate presented on the TLS connection to Vault, as certificate_thumbprint does",
+				Default:     false,
+			},
+			"dpop_proof": {
+				Type:        framework.TypeString,
+				Description: "Optional RFC 9449 DPoP proof JWT for a POST to this endpoint. Binds the token to the proof's key with a cnf jkt claim. May also be sent in the DPoP header if the mount passes it through",
+			},
+			"wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional TTL to return the response wrapped in a single-use Vault wrapping token, so the token can be handed to an agent without exposing it. Capped by the role's wrap_ttl",
+			},
+			"scope": {
+				Type:        framework.TypeString,
+				Description: "Optional space-delimited scopes to narrow the token to (RFC 8693). Each scope, and every scope it implies, must be in the role's expanded context. Defaults to the role's whole context",
+			},
+			"delegation_ctx": {
+				Type:        framework.TypeMap,
+				Description: "Optional object of exchange-time context, such as a task ID, conversation ID or tool name, validated against the role's delegation_ctx_schema and added to the token as the delegation_ctx claim",
+			},
+			"request_id": {
+				Type:        framework.TypeString,
+				Description: "Optional caller request ID, up to 128 letters, digits or ._:/+=- characters. Logged with the exchange and used as the txn claim when no traceparent is sent",
+			},
+			"traceparent": {
+				Type:        framework.TypeString,
+				Description: "Optional W3C Trace Context traceparent of the caller's trace. May also be sent in the traceparent header if the mount passes it through. Its trace ID is logged with the exchange and used as the txn claim",
+			},
+			"actor_id": {
+				Type:        framework.TypeString,
+				Description: "Identity of the actor, up to 128 letters, digits or ._:/+=- characters, for callers without a Vault entity such as batch tokens. Required from them, and only accepted by roles with allow_entityless. Actor templates see it as identity.entity.name, and the act.sub default is actor:<actor_id>",
+			},
+			"nonce": {
+				Type:        framework.TypeString,
+				Description: "Optional value copied into the nonce claim of the ID token. Only accepted by roles with issue_id_token set.",
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.pathTokenExchange,
 				Summary:  "Exchange a subject token for a new token with delegated claims",
+				// Exchanges update replicated usage, replay and audit state
+				// and enforce rate limits held in memory, so they run on the
+				// active node of the primary cluster
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
 
 		HelpSynopsis:    "Exchange tokens using a configured role",
-		HelpDescription: "Accepts a subject token (JWT) and generates a new token with claims from the role template.",
+		HelpDescription: "Accepts a subject token (a JWT, an access token validated by introspection, a SAML 2.0 assertion or a Vault token) and generates a new token with claims from the role template.",
+	}
+}
+
+// pathTokenRefresh returns the path configuration for /token/:name/refresh endpoint
+func pathTokenRefresh(b *Backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "token/" + framework.GenericNameRegex("name") + "/refresh",
+
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role the refresh token was issued for, or by-entity for tokens issued through token/by-entity",
+				Required:    true,
+			},
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Description: "The refresh_token returned with a token issued by the role. Each refresh token can be used once",
+				Required:    true,
+			},
+			"requested_token_type": {
+				Type:        framework.TypeString,
+				Description: "RFC 8693 type of the token to issue, as for token exchanges",
+				Default:     tokenTypeJWT,
+			},
+			"wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional TTL to return the response wrapped in a single-use Vault wrapping token. Capped by the role's wrap_ttl",
+			},
+			"request_id": {
+				Type:        framework.TypeString,
+				Description: "Optional caller request ID, logged with the refresh and used as the txn claim when no traceparent is sent",
+			},
+			"traceparent": {
+				Type:        framework.TypeString,
+				Description: "Optional W3C Trace Context traceparent of the caller's trace",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathTokenRefresh,
+				Summary:  "Re-issue a delegated token from a refresh token",
+				// Refreshes consume replicated refresh handles, so they run
+				// on the active node of the primary cluster like exchanges
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+
+		HelpSynopsis:    "Refresh a delegated token",
+		HelpDescription: "Exchanges a single-use refresh token for a new token and refresh token. The subject claims recorded by the original exchange are checked against the role again, and the token is authorized and built under the role as it is now. The calling entity must be the one the refresh token was issued to.",
 	}
 }
@@ -0,0 +1,137 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_AllowedSubjectTokenAlgorithms tests validating subject
+// tokens signed with non-RS256 algorithms
+func TestTokenExchange_AllowedSubjectTokenAlgorithms(t *testing.T) {
+	rsaKey, _ := generateTestKeyPair(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		algorithm jose.SignatureAlgorithm
+		key       crypto.Signer
+		allowed   string
+		wantErr   bool
+	}{
+		{name: "RS256 by default", algorithm: jose.RS256, key: rsaKey},
+		{name: "ES256 rejected by default", algorithm: jose.ES256, key: ecKey, wantErr: true},
+		{name: "ES256 allowed", algorithm: jose.ES256, key: ecKey, allowed: "RS256,ES256"},
+		{name: "PS256 allowed", algorithm: jose.PS256, key: rsaKey, allowed: "PS256"},
+		{name: "EdDSA allowed", algorithm: jose.EdDSA, key: edKey, allowed: "EdDSA"},
+		{name: "RS256 not allowed", algorithm: jose.RS256, key: rsaKey, allowed: "ES256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			setupTestExchange(t, b, storage, nil)
+
+			kid := "subject-key"
+			jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+				Key:   tt.key.Public(),
+				KeyID: kid,
+				Use:   "sig",
+			}}}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				require.NoError(t, json.NewEncoder(w).Encode(jwks))
+			}))
+			t.Cleanup(server.Close)
+
+			configData := map[string]any{
+				"issuer":           "https://vault.example.com",
+				"subject_jwks_uri": server.URL,
+			}
+			if tt.allowed != "" {
+				configData["allowed_subject_token_algorithms"] = tt.allowed
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "config",
+				Storage:   storage,
+				Data:      configData,
+			})
+			require.NoError(t, err)
+			require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+			signer, err := jose.NewSigner(
+				jose.SigningKey{Algorithm: tt.algorithm, Key: tt.key},
+				(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid),
+			)
+			require.NoError(t, err)
+			subjectToken, err := jwt.Signed(signer).Claims(defaultSubjectClaims()).Serialize()
+			require.NoError(t, err)
+
+			resp = exchangeSubjectToken(t, b, storage, subjectToken)
+			if tt.wantErr {
+				requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+				return
+			}
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		})
+	}
+}
+
+// TestPathConfig_AllowedSubjectTokenAlgorithms tests reading and validating
+// allowed_subject_token_algorithms
+func TestPathConfig_AllowedSubjectTokenAlgorithms(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	writeConfig := func(algorithms string) *logical.Response {
+		data := map[string]any{
+			"issuer":           "https://vault.example.com",
+			"subject_jwks_uri": "https://idp.example.com/jwks",
+		}
+		if algorithms != "" {
+			data["allowed_subject_token_algorithms"] = algorithms
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	readAlgorithms := func() any {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   storage,
+		})
+		require.NoError(t, err)
+		return resp.Data["allowed_subject_token_algorithms"]
+	}
+
+	require.Nil(t, writeConfig(""))
+	require.Equal(t, []string{"RS256"}, readAlgorithms())
+
+	require.Nil(t, writeConfig("ES256,PS256,EdDSA"))
+	require.Equal(t, []string{"ES256", "PS256", "EdDSA"}, readAlgorithms())
+
+	resp := writeConfig("HS256")
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), `unsupported subject token algorithm "HS256"`)
+}
@@ -0,0 +1,65 @@
+package tokenexchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateBoundAudiences tests exact, glob and URI prefix audience matching
+func TestValidateBoundAudiences(t *testing.T) {
+	tests := []struct {
+		name      string
+		aud       any
+		bound     []string
+		matchType string
+		wantErr   bool
+	}{
+		{name: "exact", aud: "service-a", bound: []string{"service-b", "service-a"}, matchType: BoundAudiencesTypeString},
+		{name: "exact list", aud: []any{"other", "service-a"}, bound: []string{"service-a"}, matchType: BoundAudiencesTypeString},
+		{name: "glob not used for string type", aud: "https://api.example.com/orders", bound: []string{"https://api.example.com/*"}, matchType: BoundAudiencesTypeString, wantErr: true},
+		{name: "glob", aud: "https://api.example.com/orders", bound: []string{"https://api.example.com/*"}, matchType: BoundAudiencesTypeGlob},
+		{name: "glob inner wildcard", aud: "https://orders.svc.example.com", bound: []string{"https://*.svc.example.com"}, matchType: BoundAudiencesTypeGlob},
+		{name: "glob mismatch", aud: "https://api.example.org/orders", bound: []string{"https://api.example.com/*"}, matchType: BoundAudiencesTypeGlob, wantErr: true},
+		{name: "prefix equal", aud: "https://api.example.com/orders", bound: []string{"https://api.example.com/orders"}, matchType: BoundAudiencesTypePrefix},
+		{name: "prefix child path", aud: "https://api.example.com/orders/v2", bound: []string{"https://api.example.com/orders"}, matchType: BoundAudiencesTypePrefix},
+		{name: "prefix with trailing slash", aud: "https://api.example.com/orders", bound: []string{"https://api.example.com/"}, matchType: BoundAudiencesTypePrefix},
+		{name: "prefix not at segment boundary", aud: "https://api.example.com/orders-admin", bound: []string{"https://api.example.com/orders"}, matchType: BoundAudiencesTypePrefix, wantErr: true},
+		{name: "prefix host confusion", aud: "https://api.example.com.evil.com/orders", bound: []string{"https://api.example.com"}, matchType: BoundAudiencesTypePrefix, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBoundAudiences(map[string]any{"aud": tt.aud}, tt.bound, tt.matchType)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestTokenExchange_BoundAudiencesGlob tests that a glob bound audience
+// admits subject tokens for any matching audience
+func TestTokenExchange_BoundAudiencesGlob(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"bound_audiences":      "https://api.example.com/*",
+		"bound_audiences_type": "glob",
+	})
+
+	claims := defaultSubjectClaims()
+	claims["aud"] = []string{"https://api.example.com/orders"}
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims["aud"] = []string{"https://billing.example.com/invoices"}
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "audience")
+
+	resp = writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"bound_audiences_type": "regex"})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "bound_audiences_type must be string, glob or prefix")
+}
@@ -0,0 +1,125 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateBoundClaims tests matching of bound claims against subject claims
+func TestValidateBoundClaims(t *testing.T) {
+	claims := map[string]any{
+		"tid":    "my-tenant",
+		"groups": []any{"engineers", "on-call"},
+		"email":  "user@example.com",
+		"level":  float64(3),
+		"org":    map[string]any{"team": "platform"},
+	}
+
+	tests := []struct {
+		name      string
+		bound     map[string][]string
+		claimType string
+		wantErr   string
+	}{
+		{name: "no bound claims", bound: nil, claimType: BoundClaimsTypeString},
+		{name: "exact match", bound: map[string][]string{"tid": {"my-tenant"}}, claimType: BoundClaimsTypeString},
+		{name: "exact mismatch", bound: map[string][]string{"tid": {"other"}}, claimType: BoundClaimsTypeString, wantErr: `claim "tid" does not match`},
+		{name: "list claim contains", bound: map[string][]string{"groups": {"admins", "engineers"}}, claimType: BoundClaimsTypeString},
+		{name: "list claim missing", bound: map[string][]string{"groups": {"admins"}}, claimType: BoundClaimsTypeString, wantErr: `claim "groups" does not match`},
+		{name: "number claim", bound: map[string][]string{"level": {"3"}}, claimType: BoundClaimsTypeString},
+		{name: "nested claim", bound: map[string][]string{"/org/team": {"platform"}}, claimType: BoundClaimsTypeString},
+		{name: "missing claim", bound: map[string][]string{"department": {"x"}}, claimType: BoundClaimsTypeString, wantErr: `missing bound claim "department"`},
+		{name: "glob match", bound: map[string][]string{"email": {"*@example.com"}}, claimType: BoundClaimsTypeGlob},
+		{name: "glob not used for string type", bound: map[string][]string{"email": {"*@example.com"}}, claimType: BoundClaimsTypeString, wantErr: `claim "email" does not match`},
+		{name: "glob mismatch", bound: map[string][]string{"tid": {"other-*"}}, claimType: BoundClaimsTypeGlob, wantErr: `claim "tid" does not match`},
+		{name: "all claims must match", bound: map[string][]string{"tid": {"my-tenant"}, "groups": {"admins"}}, claimType: BoundClaimsTypeString, wantErr: `claim "groups" does not match`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBoundClaims(claims, tt.bound, tt.claimType)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestTokenExchange_BoundClaims tests that bound_claims restrict which subject
+// tokens a role accepts
+func TestTokenExchange_BoundClaims(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"bound_claims":      map[string]any{"tid": "my-tenant", "groups": []any{"engineers"}},
+		"bound_claims_type": "glob",
+	})
+
+	claims := defaultSubjectClaims()
+	claims["tid"] = "my-tenant"
+	claims["groups"] = []string{"engineers", "on-call"}
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims["tid"] = "other-tenant"
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "bound claims")
+}
+
+// TestRole_BoundClaimsValidation tests bound_claims are validated on write and returned on read
+func TestRole_BoundClaimsValidation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, map[string]any{
+		"bound_claims": map[string]any{"tid": "my-tenant"},
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"tid": {"my-tenant"}}, resp.Data["bound_claims"])
+	require.Equal(t, BoundClaimsTypeString, resp.Data["bound_claims_type"])
+
+	tests := []struct {
+		name   string
+		data   map[string]any
+		errMsg string
+	}{
+		{name: "non-string value", data: map[string]any{"bound_claims": map[string]any{"level": 3}}, errMsg: "must be a string or list of strings"},
+		{name: "empty list", data: map[string]any{"bound_claims": map[string]any{"groups": []any{}}}, errMsg: "at least one value"},
+		{name: "invalid type", data: map[string]any{"bound_claims_type": "regex"}, errMsg: "bound_claims_type must be string or glob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]any{
+				"ttl":              "1h",
+				"key":              "test-key",
+				"actor_template":   `{}`,
+				"subject_template": `{}`,
+				"context":          "urn:documents:read",
+			}
+			for k, v := range tt.data {
+				data[k] = v
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/test-role",
+				Storage:   storage,
+				Data:      data,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.errMsg)
+		})
+	}
+}
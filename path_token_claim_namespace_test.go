@@ -0,0 +1,62 @@
+package tokenexchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExchange_ClaimNamespace(t *testing.T) {
+	roleData := map[string]any{
+		"actor_template":   `{"act": {"sub": "agent-123"}, "actor_metadata": {"team": "billing"}, "aud": "service-a"}`,
+		"subject_template": `{"email": "{{identity.subject.email}}"}`,
+	}
+
+	t.Run("config", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, roleData)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"claim_namespace": "https://example.com/claims/"}))
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, map[string]any{"team": "billing"}, claims["https://example.com/claims/actor_metadata"])
+		require.Equal(t, map[string]any{"email": "user@example.com"}, claims["https://example.com/claims/subject_claims"])
+		require.NotContains(t, claims, "actor_metadata")
+		require.NotContains(t, claims, "subject_claims")
+		for _, name := range []string{"iss", "sub", "aud", "exp", "iat", "jti", "act", "scope"} {
+			require.Contains(t, claims, name)
+		}
+	})
+
+	t.Run("role overrides config", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		data := map[string]any{"claim_namespace": "urn:example:claims:"}
+		for k, v := range roleData {
+			data[k] = v
+		}
+		privateKey, kid := setupTestExchange(t, b, storage, data)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"claim_namespace": "https://example.com/claims/"}))
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Contains(t, claims, "urn:example:claims:actor_metadata")
+		require.NotContains(t, claims, "https://example.com/claims/actor_metadata")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"claim_namespace": "claims/"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "absolute URI")
+
+		resp = writeProfileRole(t, b, storage, TokenProfileAzure, map[string]any{"claim_namespace": "https://example.com/claims/"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "token_profile")
+	})
+}
@@ -0,0 +1,124 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestClientCertificate creates a self-signed client certificate
+func generateTestClientCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+// TestTokenExchange_CertificateBinding tests binding issued tokens to a
+// client certificate with an x5t#S256 cnf claim
+func TestTokenExchange_CertificateBinding(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	cert := generateTestClientCertificate(t)
+	sum := sha256.Sum256(cert.Raw)
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	exchange := func(data map[string]any, conn *logical.Connection) *logical.Response {
+		data["subject_token"] = generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "token/test-role",
+			Storage:    storage,
+			EntityID:   "test-entity",
+			Connection: conn,
+			Data:       data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	t.Run("caller supplied thumbprint", func(t *testing.T) {
+		resp := exchange(map[string]any{"certificate_thumbprint": thumbprint}, nil)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, map[string]any{"x5t#S256": thumbprint}, claims["cnf"])
+	})
+
+	t.Run("connection certificate", func(t *testing.T) {
+		conn := &logical.Connection{ConnState: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+		resp := exchange(map[string]any{"bind_client_certificate": true}, conn)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, map[string]any{"x5t#S256": thumbprint}, claims["cnf"])
+	})
+
+	t.Run("no connection certificate", func(t *testing.T) {
+		resp := exchange(map[string]any{"bind_client_certificate": true}, &logical.Connection{})
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	})
+
+	t.Run("invalid thumbprint", func(t *testing.T) {
+		resp := exchange(map[string]any{"certificate_thumbprint": "not-a-thumbprint"}, nil)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	})
+
+	t.Run("unbound token", func(t *testing.T) {
+		resp := exchange(map[string]any{}, nil)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.NotContains(t, claims, "cnf")
+	})
+
+	t.Run("role requires binding", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+			Data: map[string]any{
+				"ttl":                         "1h",
+				"key":                         "test-key",
+				"actor_template":              `{"act": {"sub": "agent-123"}}`,
+				"subject_template":            `{}`,
+				"context":                     "urn:documents:read",
+				"require_certificate_binding": true,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		resp = exchange(map[string]any{}, nil)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+		require.Contains(t, resp.Error().Error(), "requires certificate_thumbprint")
+
+		resp = exchange(map[string]any{"certificate_thumbprint": thumbprint}, nil)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	})
+}
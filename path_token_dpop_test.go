@@ -0,0 +1,140 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testDPoPEndpoint is the exchange endpoint DPoP proofs in tests are bound to
+const testDPoPEndpoint = "https://vault.example.com:8200/v1/identity-delegation/token/test-role"
+
+// generateTestDPoPProof signs a DPoP proof for htu with key, embedding its public key
+func generateTestDPoPProof(t *testing.T, key *ecdsa.PrivateKey, htm, htu string, iat time.Time) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(dpopProofType),
+	)
+	require.NoError(t, err)
+
+	jti, err := uuid.GenerateUUID()
+	require.NoError(t, err)
+
+	proof, err := jwt.Signed(signer).Claims(map[string]any{
+		"jti": jti,
+		"htm": htm,
+		"htu": htu,
+		"iat": iat.Unix(),
+	}).Serialize()
+	require.NoError(t, err)
+	return proof
+}
+
+// TestTokenExchange_DPoP tests binding issued tokens to a DPoP key
+func TestTokenExchange_DPoP(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"api_addr": "https://vault.example.com:8200"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	dpopKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwk := jose.JSONWebKey{Key: &dpopKey.PublicKey}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	exchange := func(data map[string]any, headers map[string][]string) *logical.Response {
+		data["subject_token"] = generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "token/test-role",
+			MountPoint: "identity-delegation/",
+			Storage:    storage,
+			EntityID:   "test-entity",
+			Headers:    headers,
+			Data:       data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	t.Run("valid proof", func(t *testing.T) {
+		proof := generateTestDPoPProof(t, dpopKey, "POST", testDPoPEndpoint, time.Now())
+		resp := exchange(map[string]any{"dpop_proof": proof}, nil)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		require.Equal(t, "DPoP", resp.Data["token_type"])
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, map[string]any{"jkt": jkt}, claims["cnf"])
+
+		// The same proof cannot be used twice
+		resp = exchange(map[string]any{"dpop_proof": proof}, nil)
+		requireExchangeError(t, resp, ErrCodeInvalidDPoPProof, false)
+		require.Contains(t, resp.Error().Error(), "already been used")
+	})
+
+	t.Run("proof in header", func(t *testing.T) {
+		proof := generateTestDPoPProof(t, dpopKey, "POST", testDPoPEndpoint, time.Now())
+		resp := exchange(map[string]any{}, map[string][]string{"Dpop": {proof}})
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, map[string]any{"jkt": jkt}, claims["cnf"])
+	})
+
+	t.Run("invalid proofs", func(t *testing.T) {
+		for name, proof := range map[string]string{
+			"wrong method":   generateTestDPoPProof(t, dpopKey, "GET", testDPoPEndpoint, time.Now()),
+			"wrong endpoint": generateTestDPoPProof(t, dpopKey, "POST", "https://vault.example.com:8200/v1/identity-delegation/token/other-role", time.Now()),
+			"stale":          generateTestDPoPProof(t, dpopKey, "POST", testDPoPEndpoint, time.Now().Add(-10*time.Minute)),
+			"not a jwt":      "not-a-proof",
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := exchange(map[string]any{"dpop_proof": proof}, nil)
+				requireExchangeError(t, resp, ErrCodeInvalidDPoPProof, false)
+			})
+		}
+	})
+
+	t.Run("role requires DPoP", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+			Data: map[string]any{
+				"ttl":              "1h",
+				"key":              "test-key",
+				"actor_template":   `{"act": {"sub": "agent-123"}}`,
+				"subject_template": `{}`,
+				"context":          "urn:documents:read",
+				"require_dpop":     true,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		resp = exchange(map[string]any{}, nil)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+		require.Contains(t, resp.Error().Error(), "requires a DPoP proof")
+	})
+}
@@ -0,0 +1,165 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// decryptIssuedToken decrypts a nested JWT and returns the signed token inside
+func decryptIssuedToken(t *testing.T, token string, key any) string {
+	encrypted, err := jose.ParseEncrypted(token,
+		[]jose.KeyAlgorithm{jose.RSA_OAEP_256, jose.ECDH_ES_A256KW},
+		[]jose.ContentEncryption{jose.A256GCM},
+	)
+	require.NoError(t, err)
+	require.Equal(t, "JWT", encrypted.Header.ExtraHeaders["cty"])
+
+	signed, err := encrypted.Decrypt(key)
+	require.NoError(t, err)
+	return string(signed)
+}
+
+// TestTokenExchange_Encryption tests returning issued tokens as nested JWTs
+// encrypted to the audience
+func TestTokenExchange_Encryption(t *testing.T) {
+	t.Run("PEM encryption key", func(t *testing.T) {
+		recipient, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(&recipient.PublicKey)
+		require.NoError(t, err)
+		publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"encryption_key": publicPEM})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		token := resp.Data["token"].(string)
+		require.Len(t, strings.Split(token, "."), 5, "token should be a compact JWE")
+
+		claims := parseIssuedToken(t, b, storage, decryptIssuedToken(t, token, recipient))
+		require.Equal(t, "user-123", claims["sub"])
+	})
+
+	t.Run("encryption JWKS", func(t *testing.T) {
+		recipient, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		signingOnly, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+				{Key: &signingOnly.PublicKey, KeyID: "sig", Use: "sig", Algorithm: string(jose.ES256)},
+				{Key: &recipient.PublicKey, KeyID: "enc", Use: "enc"},
+			}})
+		}))
+		defer server.Close()
+
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"encryption_jwks_uri": server.URL})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		token := resp.Data["token"].(string)
+		encrypted, err := jose.ParseEncrypted(token, []jose.KeyAlgorithm{jose.ECDH_ES_A256KW}, []jose.ContentEncryption{jose.A256GCM})
+		require.NoError(t, err)
+		require.Equal(t, "enc", encrypted.Header.KeyID)
+
+		claims := parseIssuedToken(t, b, storage, decryptIssuedToken(t, token, recipient))
+		require.Equal(t, "user-123", claims["sub"])
+	})
+
+	t.Run("encryption JWKS unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"encryption_jwks_uri": server.URL})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	})
+
+	t.Run("encryption JWKS redirect", func(t *testing.T) {
+		recipient, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &recipient.PublicKey, Use: "enc"}}})
+		}))
+		defer target.Close()
+		redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL, http.StatusFound)
+		}))
+		defer redirector.Close()
+
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"encryption_jwks_uri": redirector.URL})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+		require.Contains(t, resp.Error().Error(), "status 302")
+	})
+
+	t.Run("invalid role options", func(t *testing.T) {
+		recipient, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		jwk, err := json.Marshal(jose.JSONWebKey{Key: &recipient.PublicKey})
+		require.NoError(t, err)
+
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		for name, data := range map[string]map[string]any{
+			"both sources":     {"encryption_key": string(jwk), "encryption_jwks_uri": "https://audience.example.com/jwks"},
+			"invalid key":      {"encryption_key": "not-a-key"},
+			"private JWK":      {"encryption_key": mustMarshalJWK(t, jose.JSONWebKey{Key: recipient})},
+			"invalid JWKS URI": {"encryption_jwks_uri": "ftp://audience.example.com/jwks"},
+			"detached payload": {"encryption_key": string(jwk), "detached_payload": true},
+			"ID token":         {"encryption_key": string(jwk), "issue_id_token": true},
+			"upstream STS":     {"encryption_key": string(jwk), "upstream_sts_url": "https://sts.example.com/token"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				data["key"] = "test-key"
+				data["actor_template"] = `{"act": {"sub": "agent-123"}}`
+				data["subject_template"] = `{}`
+				data["context"] = "urn:documents:read"
+
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "role/test-role",
+					Storage:   storage,
+					Data:      data,
+				})
+				require.NoError(t, err)
+				require.True(t, resp != nil && resp.IsError(), "role write should fail")
+			})
+		}
+	})
+}
+
+// mustMarshalJWK encodes a JWK as JSON
+func mustMarshalJWK(t *testing.T, key jose.JSONWebKey) string {
+	encoded, err := json.Marshal(key)
+	require.NoError(t, err)
+	return string(encoded)
+}
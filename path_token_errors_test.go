@@ -0,0 +1,105 @@
+package tokenexchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// requireExchangeError asserts that resp is an error carrying the given code
+func requireExchangeError(t *testing.T, resp *logical.Response, code string, retryable bool) {
+	t.Helper()
+
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	data, ok := resp.Data["data"].(map[string]any)
+	require.True(t, ok, "error response should carry structured data")
+	require.Equal(t, code, data["error"])
+	require.Equal(t, retryable, data["retryable"])
+	require.Equal(t, resp.Error().Error(), data["error_description"])
+}
+
+// TestTokenExchange_ErrorCodes tests that exchange failures return typed error codes
+func TestTokenExchange_ErrorCodes(t *testing.T) {
+	t.Run("missing subject token", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{},
+		})
+		require.NoError(t, err)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/missing-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": "x"},
+		})
+		require.NoError(t, err)
+		requireExchangeError(t, resp, ErrCodeInvalidTarget, false)
+	})
+
+	t.Run("expired subject token", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+		claims := defaultSubjectClaims()
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+		requireExchangeError(t, resp, ErrCodeExpiredSubjectToken, false)
+	})
+
+	t.Run("audience mismatch", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"bound_audiences": "service-b",
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	})
+
+	t.Run("jwks unavailable", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer unavailable.Close()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data: map[string]any{
+				"issuer":           "https://vault.example.com",
+				"subject_jwks_uri": unavailable.URL,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError())
+
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+	})
+}
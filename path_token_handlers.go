@@ -1,152 +1,605 @@
 package tokenexchange
 
 import (
+	"cmp"
 	"context"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"html"
-	"io"
-	"net/http"
+	"math"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/hoisie/mustache"
+	"github.com/ryanuber/go-glob"
 )
 
 // pathTokenExchange handles the token exchange request
 func (b *Backend) pathTokenExchange(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	// Get role name
-	roleName := data.Get("name").(string)
+	// Track the exchange so shutdown can drain it
+	if !b.beginExchange() {
+		return exchangeError(ErrCodeTemporarilyUnavailable, "token exchange unavailable: backend is shutting down"), nil
+	}
+	defer b.endExchange()
+
+	return b.pipeline.run(ctx, &exchange{
+		req:      req,
+		data:     data,
+		roleName: data.Get("name").(string),
+		start:    time.Now(),
+	})
+}
+
+// newExchangePipeline registers the built-in token exchange hooks
+func (b *Backend) newExchangePipeline() *exchangePipeline {
+	p := &exchangePipeline{}
+
+	// token/by-entity names its role before anything loads it
+	p.register(stageValidate, "entity_role", b.resolveEntityRole)
+	p.register(stageValidate, "request", b.validateRequest)
+	p.register(stageValidate, "actor", b.resolveActor)
+	p.register(stageValidate, "requested_token_type", b.resolveRequestedTokenType)
+	p.register(stageValidate, "not_before", b.resolveNotBefore)
+	p.register(stageValidate, "trace", b.resolveTrace)
+	p.register(stageValidate, "scopes", b.resolveScopes)
+	p.register(stageValidate, "delegation_ctx", b.resolveDelegationCtx)
+	p.register(stageValidate, "response_wrapping", b.resolveWrapTTL)
+	p.register(stageValidate, "certificate_binding", b.validateCertificateBinding)
+	// Rate limits are enforced before any expensive work
+	p.register(stageValidate, "rate_limit", b.enforceRateLimit)
+	p.register(stageValidate, "subject_token", b.validateSubjectToken)
+	p.register(stageValidate, "dpop", b.validateDPoP)
+
+	p.register(stageAuthorize, "denied_scopes", b.authorizeScopes)
+	p.register(stageAuthorize, "bound_entity", b.authorizeBoundEntity)
+	p.register(stageAuthorize, "entity_metadata", b.authorizeEntityMetadata)
+	p.register(stageAuthorize, "policy", b.authorizePolicy)
+	p.register(stageAuthorize, "authorization_webhook", b.authorizeWebhook)
+	// Quotas are only used up by authorized exchanges, and before a
+	// single-use token is consumed
+	p.register(stageAuthorize, "quota", b.enforceQuota)
+	// Single-use tokens are only consumed once the exchange is authorized
+	p.register(stageAuthorize, "single_use", b.consumeSingleUseToken)
+
+	p.register(stageEnrich, "directory", b.enrichDirectory)
+	p.register(stageEnrich, "groups", b.enrichGroups)
+	p.register(stageEnrich, "caller_token", b.enrichCallerToken)
+
+	p.register(stageTemplate, "templates", b.renderTemplates)
+	p.register(stageTemplate, "subject", b.resolveSubject)
+	p.register(stageTemplate, "vault_meta", b.addVaultMeta)
+	p.register(stageTemplate, "delegation_ctx", b.addDelegationCtx)
+	p.register(stageTemplate, "txn", b.addTxn)
+	p.register(stageTemplate, "subject_fingerprint", b.addSubjectFingerprint)
+	p.register(stageTemplate, "confirmation", b.addConfirmation)
+
+	p.register(stageSign, "sign", b.signToken)
+	p.register(stageSign, "id_token", b.issueIDToken)
+	p.register(stageSign, "encrypt", b.encryptIssuedToken)
+	p.register(stageSign, "detached_payload", b.detachTokenPayload)
+	p.register(stageSign, "upstream_sts", b.chainUpstream)
+	p.register(stageSign, "reference_token", b.issueReferenceToken)
+	p.register(stageSign, "refresh_handle", b.issueRefreshHandle)
+
+	// Issuance is recorded before metrics so a failed write counts as a failure
+	p.register(stageRecord, "issuance", b.recordIssuance)
+	p.register(stageRecord, "issuance_log", b.recordIssuanceLog)
+	p.register(stageRecord, "events", b.publishIssuance)
+	// Usage is recorded after issuance so a failed write uncounts the exchange
+	p.register(stageRecord, "usage", b.recordUsage)
+	p.register(stageRecord, "metrics", b.recordExchangeResult)
+	p.register(stageRecord, "log", b.logExchange)
+
+	return p
+}
 
+// validateRequest reads the request fields, role, deadline and config
+func (b *Backend) validateRequest(ctx context.Context, ex *exchange) (*logical.Response, error) {
 	// Get subject token
-	subjectToken, ok := data.GetOk("subject_token")
+	subjectToken, ok := ex.data.GetOk("subject_token")
 	if !ok {
-		return logical.ErrorResponse("subject_token is required"), nil
+		return exchangeError(ErrCodeInvalidRequest, "subject_token is required"), nil
+	}
+	ex.subjectToken = subjectToken.(string)
+
+	// Get per-exchange deadline (optional)
+	var requestNotAfter time.Time
+	if notAfter, ok := ex.data.GetOk("not_after"); ok && notAfter.(string) != "" {
+		var err error
+		requestNotAfter, err = time.Parse(time.RFC3339, notAfter.(string))
+		if err != nil {
+			return exchangeError(ErrCodeInvalidRequest, "not_after must be an RFC 3339 timestamp: %v", err), nil
+		}
+	}
+
+	if resp, err := b.loadExchangeRole(ctx, ex, requestNotAfter); resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Get subject token type (optional, has default). Access tokens are only
+	// introspected when an endpoint is configured, so JWT access tokens keep
+	// working without one.
+	ex.subjectTokenType = ex.data.Get("subject_token_type").(string)
+	if ex.subjectTokenType == tokenTypeAccessToken && ex.config.IntrospectionURL == "" {
+		ex.subjectTokenType = tokenTypeJWT
+	}
+	validator, err := b.subjectTokenValidator(ex.config, ex.subjectTokenType)
+	if err != nil {
+		return exchangeError(ErrCodeInvalidRequest, "%v", err), nil
 	}
-	subjectTokenStr := subjectToken.(string)
+	ex.subjectValidator = validator
+
+	// Get the requested scope (optional)
+	ex.requestedScope = ex.data.Get("scope").(string)
 
+	// Get the delegation context (optional)
+	ex.delegationCtx = ex.data.Get("delegation_ctx").(map[string]any)
+
+	// Get ID token nonce (optional)
+	ex.nonce = ex.data.Get("nonce").(string)
+	if ex.nonce != "" && !ex.role.IssueIDToken {
+		return exchangeError(ErrCodeInvalidRequest, "nonce requires a role with issue_id_token"), nil
+	}
+	if len(ex.nonce) > maxNonceLength {
+		return exchangeError(ErrCodeInvalidRequest, "nonce must be at most %d characters", maxNonceLength), nil
+	}
+
+	return nil, nil
+}
+
+// loadExchangeRole loads the role, with the config's role defaults applied,
+// and the config, and checks the delegation deadline, the earlier of the
+// role's not_after and requestNotAfter
+func (b *Backend) loadExchangeRole(ctx context.Context, ex *exchange, requestNotAfter time.Time) (*logical.Response, error) {
 	// Load role
-	role, err := b.getRole(ctx, req.Storage, roleName)
+	role, err := b.getRole(ctx, ex.req.Storage, ex.roleName)
 	if err != nil {
 		return nil, err
 	}
 	if role == nil {
-		return logical.ErrorResponse("role %q not found", roleName), nil
+		return exchangeError(ErrCodeInvalidTarget, "role %q not found", ex.roleName), nil
+	}
+	if role.Disabled {
+		return exchangeError(ErrCodeAccessDenied, "role %q is disabled", ex.roleName), nil
+	}
+	ex.role = role
+
+	// Inherited template fragments are loaded with the role
+	fragments, err := b.getTemplateFragments(ctx, ex.req.Storage, role.TemplateLibrary)
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q: %v", ex.roleName, err), nil
+	}
+	ex.fragments = fragments
+
+	// The earliest of the role and exchange deadlines caps the token lifetime
+	ex.notAfter = earliestDeadline(role.NotAfter, requestNotAfter)
+	if !ex.notAfter.IsZero() && !time.Now().Before(ex.notAfter) {
+		return exchangeError(ErrCodeDelegationExpired, "delegation deadline %s has passed", ex.notAfter.Format(time.RFC3339)), nil
 	}
 
 	// Load config (needed for issuer and subject_jwks_uri)
-	config, err := b.getConfig(ctx, req.Storage)
+	config, err := b.getConfig(ctx, ex.req.Storage)
 	if err != nil {
 		return nil, err
 	}
 	if config == nil {
-		return logical.ErrorResponse("plugin not configured"), nil
+		return exchangeError(ErrCodeServerError, "plugin not configured"), nil
 	}
+	ex.config = config
+	config.applyRoleDefaults(role)
+
+	return nil, nil
+}
+
+// enforceRateLimit applies per-entity rate limits. The quota error makes
+// Vault respond with HTTP 429.
+func (b *Backend) enforceRateLimit(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	allowed, retryAfter := b.rateLimiter.allow(time.Now(), exchangeRateLimits(ex.config, ex.role, ex.callerID())...)
+	if allowed {
+		return nil, nil
+	}
+
+	retrySeconds := int64(math.Ceil(retryAfter.Seconds()))
+	resp := exchangeError(ErrCodeRateLimited, "rate limit exceeded for entity %q, retry in %ds", ex.callerID(), retrySeconds)
+	resp.Data["data"].(map[string]any)["retry_after"] = retrySeconds
+	return resp, logical.ErrRateLimitQuotaExceeded
+}
 
-	// Load role-specified key (required)
-	key, err := b.getKey(ctx, req.Storage, role.Key)
+// validateSubjectToken verifies the subject token and checks it against the role's bounds
+func (b *Backend) validateSubjectToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	claims, err := ex.subjectValidator.Validate(ctx, ex.req.Storage, ex.config, ex.subjectToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load key %q: %w", role.Key, err)
+		if errors.Is(err, errIdentityStoreUnavailable) {
+			return exchangeError(ErrCodeIdentityUnavailable, "failed to validate subject token: %v", err), nil
+		}
+		if errors.Is(err, errJWKSUnavailable) || errors.Is(err, errIntrospectionUnavailable) || errors.Is(err, errVaultTokenLookupUnavailable) || errors.Is(err, errTransitUnavailable) {
+			return exchangeError(ErrCodeTemporarilyUnavailable, "failed to validate subject token: %v", err), nil
+		}
+		return exchangeError(ErrCodeInvalidSubjectToken, "failed to validate subject token: %v", err), nil
 	}
-	if key == nil {
-		return logical.ErrorResponse("key %q not found", role.Key), nil
+
+	if resp := checkSubjectClaims(claims, ex.role); resp != nil {
+		return resp, nil
 	}
 
-	// Parse private key
-	signingKey, err := parsePrivateKey(key.PrivateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	ex.subjectClaims = claims
+	return nil, nil
+}
+
+// checkSubjectClaims checks verified subject token claims for expiry and
+// against the role's bound issuer, audiences and claims
+func checkSubjectClaims(claims map[string]any, role *Role) *logical.Response {
+	// Check expiration
+	if err := checkExpiration(claims); err != nil {
+		return exchangeError(ErrCodeExpiredSubjectToken, "subject token expired: %v", err)
 	}
 
-	keyID := key.KeyID
+	// Validate bound issuer
+	if err := validateBoundIssuer(claims, role.BoundIssuer); err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "failed to validate issuer: %v", err)
+	}
 
-	// Map algorithm string to jose constant
-	var algorithm jose.SignatureAlgorithm
-	switch key.Algorithm {
-	case AlgorithmRS256:
-		algorithm = jose.RS256
-	case AlgorithmRS384:
-		algorithm = jose.RS384
-	case AlgorithmRS512:
-		algorithm = jose.RS512
-	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", key.Algorithm)
+	// Validate bound audiences
+	if err := validateBoundAudiences(claims, role.BoundAudiences, role.BoundAudiencesType); err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "failed to validate audience: %v", err)
 	}
 
-	// Validate and parse subject token
-	originalSubjectClaims, err := validateAndParseClaims(subjectTokenStr, config.SubjectJWKSURI)
+	// Validate bound claims
+	if err := validateBoundClaims(claims, role.BoundClaims, role.BoundClaimsType); err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "failed to validate bound claims: %v", err)
+	}
+
+	// Check required claims
+	if missing := missingSubjectClaims(claims, role.RequiredSubjectClaims, role.RequireNonEmptyClaims); len(missing) > 0 {
+		return exchangeError(ErrCodeInvalidSubjectToken, "subject token is missing required claims: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// authorizeBoundEntity allows only bound entities and group members to use the role
+func (b *Backend) authorizeBoundEntity(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	allowed, err := entityBound(b.identityView(ctx), ex.req.EntityID, ex.role)
+	if errors.Is(err, errIdentityStoreUnavailable) {
+		return exchangeError(ErrCodeIdentityUnavailable, "failed to look up entity groups: %v", err), nil
+	}
 	if err != nil {
-		return logical.ErrorResponse("failed to validate subject token: %v", err), nil
+		return nil, err
+	}
+	if !allowed {
+		return exchangeError(ErrCodeAccessDenied, "entity %q is not permitted to exchange with role %q", ex.req.EntityID, ex.roleName), nil
 	}
+	return nil, nil
+}
 
-	// Check expiration
-	if err := checkExpiration(originalSubjectClaims); err != nil {
-		return logical.ErrorResponse("subject token expired: %v", err), nil
+// authorizeEntityMetadata fetches the entity and requires the role's metadata
+// keys, so only entities that can be attributed to an owner may exchange
+func (b *Backend) authorizeEntityMetadata(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	// Callers without an entity use the one resolveActor made for them
+	if ex.actorID != "" {
+		return nil, nil
+	}
+	if ex.req.EntityID == "" {
+		return exchangeError(ErrCodeAccessDenied, "role %q requires a caller with a Vault entity", ex.roleName), nil
 	}
 
-	// Validate bound issuer
-	if err := validateBoundIssuer(originalSubjectClaims, role.BoundIssuer); err != nil {
-		return logical.ErrorResponse("failed to validate issuer: %v", err), nil
+	entity, err := fetchEntity(ex.req, b.identityView(ctx))
+	if errors.Is(err, errIdentityStoreUnavailable) {
+		return exchangeError(ErrCodeIdentityUnavailable, "failed to look up entity: %v", err), nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Validate bound audiences
-	if err := validateBoundAudiences(originalSubjectClaims, role.BoundAudiences); err != nil {
-		return logical.ErrorResponse("failed to validate audience: %v", err), nil
+	if missing := missingEntityMetadata(entity, ex.role.RequiredEntityMetadata); len(missing) > 0 {
+		return exchangeError(ErrCodeAccessDenied, "entity %q is missing required metadata: %s", entity.ID, strings.Join(missing, ", ")), nil
+	}
+
+	ex.entity = entity
+	return nil, nil
+}
+
+// consumeSingleUseToken rejects subject tokens that have already been exchanged
+func (b *Backend) consumeSingleUseToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.SingleUseSubjectToken {
+		return nil, nil
 	}
 
-	// Fetch entity
-	b.Logger().Info("Get EntityID", "entity_id", req.EntityID)
-	entity, err := fetchEntity(req, b.System())
+	exp, err := numericDateClaim(ex.subjectClaims, "exp")
+	if err != nil {
+		return exchangeError(ErrCodeInvalidSubjectToken, "failed to read subject token expiry: %v", err), nil
+	}
+
+	fingerprint := subjectTokenFingerprint(ex.subjectToken, ex.subjectClaims)
+	fresh, err := b.consumeSubjectToken(ctx, ex.req.Storage, fingerprint, time.Unix(exp, 0))
 	if err != nil {
 		return nil, err
 	}
+	if !fresh {
+		return exchangeError(ErrCodeReplayedSubjectToken, "subject token has already been exchanged"), nil
+	}
+	return nil, nil
+}
 
-	// Process template to create additional claims
-	im := map[string]any{
-		"identity": map[string]map[string]any{
-			"entity": {
-				"id":           entity.ID,
-				"name":         entity.Name,
-				"namespace_id": entity.NamespaceID,
-				"metadata":     entity.Metadata,
-			},
-		},
+// enrichDirectory resolves directory attributes for the subject template
+func (b *Backend) enrichDirectory(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	directoryConfig, err := b.getDirectoryConfig(ctx, ex.req.Storage)
+	if err != nil {
+		return nil, err
 	}
 
-	actorClaims, err := processTemplate(role.ActorTemplate, im)
+	attrs, err := b.lookupDirectory(ctx, directoryConfig, ex.subjectClaims)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process template: %w", err)
+		if directoryConfig.FailurePolicy != DirectoryFailureIgnore {
+			return exchangeError(ErrCodeDirectoryLookupFailed, "failed to resolve directory attributes: %v", err), nil
+		}
+		b.exchangeLogger(ex).Warn("directory lookup failed, continuing without directory attributes", "error", err)
+	}
+	if attrs == nil {
+		attrs = map[string]any{}
 	}
 
-	sm := map[string]any{
-		"identity": map[string]map[string]any{
-			"subject": originalSubjectClaims,
-		},
+	ex.directoryAttrs = attrs
+	return nil, nil
+}
+
+// enrichGroups looks up the entity's groups when the actor template or
+// subject_claim_template uses them
+func (b *Backend) enrichGroups(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	uses := usesGroups(ex.role.ActorTemplate) || usesGroups(ex.role.SubjectClaimTemplate)
+	for _, fragment := range ex.fragments {
+		uses = uses || usesGroups(fragment.ActorTemplate)
+	}
+	if !uses {
+		return nil, nil
+	}
+	return b.loadGroups(ctx, ex)
+}
+
+// loadGroups looks up the entity's groups, unless an earlier hook of the
+// exchange already has
+func (b *Backend) loadGroups(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.groups != nil {
+		return nil, nil
+	}
+
+	groups, err := b.identityView(ctx).GroupsForEntity(ex.entity.ID)
+	if errors.Is(err, errIdentityStoreUnavailable) {
+		return exchangeError(ErrCodeIdentityUnavailable, "failed to look up entity groups: %v", err), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups for entity: %w", err)
+	}
+
+	if groups == nil {
+		groups = []*logical.Group{}
+	}
+	ex.groups = groups
+	return nil, nil
+}
+
+// renderTemplates processes the role's actor and subject templates
+func (b *Backend) renderTemplates(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	actorClaims, err := renderInherited(ex.fragments, actorFragment, ex.role.ActorTemplate, actorTemplateContext(ex.entity, ex.groups, ex.callerToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to process template: %w", err)
 	}
 
-	subjectClaims, err := processTemplate(role.SubjectTemplate, sm)
+	templateClaims, err := renderInherited(ex.fragments, subjectFragment, ex.role.SubjectTemplate, subjectTemplateContext(ex.subjectClaims, ex.directoryAttrs))
 	if err != nil {
 		return nil, fmt.Errorf("failed to process template: %w", err)
 	}
 
-	// Generate new token with keyID
-	newToken, err := generateToken(config, role, originalSubjectClaims["sub"].(string), actorClaims, subjectClaims, signingKey, keyID, algorithm, req.EntityID)
+	ex.actorClaims = actorClaims
+	ex.templateClaims = templateClaims
+	return nil, nil
+}
+
+// addVaultMeta adds the vault_meta claim when the role requests it. It
+// replaces any vault_meta produced by the actor template.
+func (b *Backend) addVaultMeta(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.IncludeVaultMeta {
+		return nil, nil
+	}
+
+	if ex.actorClaims == nil {
+		ex.actorClaims = map[string]any{}
+	}
+	ex.actorClaims["vault_meta"] = vaultMetaClaim(ex.req, ex.entity)
+	return nil, nil
+}
+
+// vaultMetaClaim describes the Vault request and auth mounts behind an
+// exchange so a delegated token can be traced back to how the agent logged in
+func vaultMetaClaim(req *logical.Request, entity *logical.Entity) map[string]any {
+	requestIDHash := sha256.Sum256([]byte(req.ID))
+
+	authMounts := make([]map[string]any, 0, len(entity.Aliases))
+	for _, alias := range entity.Aliases {
+		authMounts = append(authMounts, map[string]any{
+			"mount_type":     alias.MountType,
+			"mount_accessor": alias.MountAccessor,
+		})
+	}
+
+	return map[string]any{
+		"mount_accessor":  req.MountAccessor,
+		"request_id_hash": hex.EncodeToString(requestIDHash[:]),
+		"entity_id":       entity.ID,
+		"auth_mounts":     authMounts,
+	}
+}
+
+// signToken signs the delegated token with the key for the resolved audience
+func (b *Backend) signToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	// Load the key for the resolved audience, falling back to the role's key
+	keyName := signingKeyName(ex.role, ex.config.DefaultKey, ex.actorClaims["aud"])
+	if keyName == "" {
+		return exchangeError(ErrCodeServerError, "role %q has no key and no default_key is configured", ex.roleName), nil
+	}
+	key, err := b.getKey(ctx, ex.req.Storage, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key %q: %w", keyName, err)
+	}
+	if key == nil {
+		return exchangeError(ErrCodeServerError, "key %q not found", keyName), nil
+	}
+	if key.Tenant != ex.role.Tenant {
+		return exchangeError(ErrCodeServerError, "key %q does not belong to the tenant of role %q", keyName, ex.roleName), nil
+	}
+
+	signingKey, err := b.keySigner(ctx, ex.config, key)
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "key %q: %v", keyName, err), nil
+	}
+
+	// Map algorithm string to jose constant
+	algorithm, err := signatureAlgorithm(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProfileAlgorithm(ex.role, key.Algorithm); err != nil {
+		return exchangeError(ErrCodeServerError, "key %q: %v", keyName, err), nil
+	}
+
+	jwksURL, err := verificationURL(ex.config, ex.role, ex.req.MountPoint)
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q: %v", ex.roleName, err), nil
+	}
+
+	var certificate *x509.Certificate
+	if ex.role.IncludeX5C {
+		if key.Certificate == "" {
+			return exchangeError(ErrCodeServerError, "role %q includes x5c but key %q has no certificate", ex.roleName, keyName), nil
+		}
+		certificate, err = parseCertificatePEM(key.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate of key %q: %w", keyName, err)
+		}
+	}
+
+	signStart := time.Now()
+	issued, err := generateToken(ex.config, ex.role, ex.scopes, ex.subject, ex.actorClaims, ex.templateClaims, signingKey, key.KeyID, algorithm, ex.defaultActorSubject(), ex.notBefore, ex.notAfter, jwksURL, certificate)
+	if errors.Is(err, errTransitUnavailable) || errors.Is(err, errManagedKeyUnavailable) {
+		return exchangeError(ErrCodeTemporarilyUnavailable, "failed to sign token: %v", err), nil
+	}
+	if errors.Is(err, errTokenProfileClaims) {
+		return exchangeError(ErrCodeServerError, "role %q: %v", ex.roleName, err), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
+	b.recordTokenSign(signStart)
+
+	// Non-sensitive metadata lets audit logs record what was issued
+	// without relying on the token itself
+	ex.issued = issued
+	ex.key = key
+	ex.signingKey = signingKey
+	ex.respData = map[string]any{
+		"token":           issued.Token,
+		"jti":             issued.JTI,
+		"key_id":          issued.KeyID,
+		"issued_at":       issued.IssuedAt.Unix(),
+		"expires_at":      issued.ExpiresAt.Unix(),
+		"scope":           issued.Scope,
+		"actor_entity_id": ex.req.EntityID,
+	}
+	if !issued.NotBefore.IsZero() {
+		ex.respData["not_before"] = issued.NotBefore.Unix()
+	}
+	// RFC 8693 responses identify the type of the issued token
+	ex.respData["issued_token_type"] = tokenTypeJWT
+	// RFC 9449 marks DPoP-bound tokens with the DPoP token type
+	if ex.dpopThumbprint != "" {
+		ex.respData["token_type"] = "DPoP"
+	}
+	return nil, nil
+}
 
-	return &logical.Response{
-		Data: map[string]any{
-			"token": newToken,
-		},
-	}, nil
+// issueIDToken adds an ID token describing the subject and actor when the
+// role requests one
+func (b *Backend) issueIDToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.IssueIDToken {
+		return nil, nil
+	}
+
+	idToken, err := generateIDToken(ex.config, ex.key, ex.signingKey, ex.issued, ex.actorClaims, ex.subjectClaims, ex.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ID token: %w", err)
+	}
+	ex.respData["id_token"] = idToken
+	return nil, nil
+}
+
+// detachTokenPayload splits the payload out of the JWS for out-of-band transmission
+func (b *Backend) detachTokenPayload(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.DetachedPayload {
+		return nil, nil
+	}
+
+	detached, payload, err := detachPayload(ex.issued.Token)
+	if err != nil {
+		return nil, err
+	}
+	ex.respData["token"] = detached
+	ex.respData["payload"] = payload
+	return nil, nil
+}
+
+// chainUpstream exchanges the delegated token at the external STS and returns
+// its token in place of ours. The jti and key_id still identify the delegated
+// token for auditing.
+func (b *Backend) chainUpstream(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.role.UpstreamSTS == nil {
+		return nil, nil
+	}
+
+	downstream, err := exchangeUpstream(ctx, ex.role.UpstreamSTS, ex.issued.Token)
+	if err != nil {
+		return exchangeError(ErrCodeUpstreamError, "upstream token exchange failed: %v", err), nil
+	}
+
+	ex.respData["token"] = downstream.AccessToken
+	ex.respData["issued_token_type"] = downstream.IssuedTokenType
+	ex.respData["token_type"] = downstream.TokenType
+	if downstream.ExpiresIn > 0 {
+		ex.respData["expires_at"] = time.Now().Add(time.Duration(downstream.ExpiresIn) * time.Second).Unix()
+	}
+	if downstream.Scope != "" {
+		ex.respData["scope"] = downstream.Scope
+	}
+	return nil, nil
+}
+
+// recordExchangeResult counts the exchange outcome and latency
+func (b *Backend) recordExchangeResult(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	b.recordExchange(ex.roleName, ex.succeeded(), ex.start)
+	return nil, nil
+}
+
+// detachPayload converts a compact JWS into its detached form (RFC 7515
+// Appendix F), returning header..signature and the base64url-encoded payload
+func detachPayload(token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("invalid compact JWS")
+	}
+
+	return parts[0] + ".." + parts[2], parts[1], nil
 }
 
 // parsePrivateKey parses a PEM-encoded RSA private key
@@ -174,89 +627,283 @@ func parsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
 	}
 }
 
-// validateAndParseClaims validates the JWT signature and parses claims
-func validateAndParseClaims(tokenStr string, jwksURI string) (map[string]any, error) {
-	// fetch JWKS
-	// TODO: Cache JWKS for performance
-	jwks, err := fetchJWKS(jwksURI)
+// supportedSubjectTokenAlgorithms are the JWS algorithms that can be allowed on subject tokens
+var supportedSubjectTokenAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// parseSubjectJWT decrypts an encrypted subject token and parses the JWT,
+// leaving its signature to be verified
+func (b *Backend) parseSubjectJWT(ctx context.Context, config *Config, tokenStr string) (*jwt.JSONWebToken, error) {
+	// The raw token is bounded before it is decrypted, and the JWT it
+	// carries again once it is
+	if err := checkSubjectTokenSize(tokenStr); err != nil {
+		return nil, err
+	}
+
+	// Encrypted subject tokens are verified as the JWT they carry
+	tokenStr, err := b.decryptSubjectToken(ctx, config, tokenStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+		return nil, err
+	}
+	if err := checkSubjectTokenHeader(tokenStr); err != nil {
+		return nil, err
 	}
 
-	// Parse the JWT
-	parsedToken, err := jwt.ParseSigned(tokenStr, []jose.SignatureAlgorithm{jose.RS256})
+	parsedToken, err := jwt.ParseSigned(tokenStr, config.subjectTokenAlgorithms())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JWT: %w", err)
 	}
+	return parsedToken, nil
+}
+
+// verifyWithStaticKeys verifies a subject JWT with the keys held in the config
+func verifyWithStaticKeys(config *Config, parsedToken *jwt.JSONWebToken) (map[string]any, error) {
+	keySet, err := config.staticSubjectKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	kid := parsedToken.Headers[0].KeyID
+	keys := keySet.Key(kid)
+	if len(keys) == 0 {
+		keys = keySet.Key("")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key not found in static subject keys, kid: %s", kid)
+	}
+	return verifySubjectToken(parsedToken, keys)
+}
 
-	// Find the key id from the token header
+// verifyWithSubjectJWKS verifies a subject JWT with the key named by its kid
+// in the JWKS at the config's subject_jwks_uri
+func (b *Backend) verifyWithSubjectJWKS(ctx context.Context, storage logical.Storage, config *Config, parsedToken *jwt.JSONWebToken) (map[string]any, error) {
 	kid := parsedToken.Headers[0].KeyID
+
+	// fetch JWKS
+	jwksURI := config.SubjectJWKSURI
+	jwks, err := b.getSubjectJWKS(ctx, storage, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errJWKSUnavailable, err)
+	}
+
 	key := jwks.Key(kid)
+	if len(key) == 0 {
+		// The issuer may have rolled over to a new key since the JWKS was cached
+		if refreshed, ok := b.refreshSubjectJWKS(ctx, storage, config, kid); ok {
+			key = refreshed.Key(kid)
+		}
+	}
 	if len(key) == 0 {
 		return nil, fmt.Errorf("key not found in JWKS, kid: %s, jwks: %s", kid, jwksURI)
 	}
 
-	// Verify signature and extract claims
-	claims := make(map[string]any)
-	if err := parsedToken.Claims(key[0], &claims); err != nil {
-		return nil, fmt.Errorf("failed to verify signature: %w", err)
+	claims, err := verifySubjectToken(parsedToken, key[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember how long the key is relied on, to detect early removal
+	if exp, err := numericDateClaim(claims, "exp"); err == nil {
+		b.recordSubjectKidUse(jwksURI, kid, time.Unix(exp, 0))
 	}
 
 	return claims, nil
 }
 
-func fetchJWKS(url string) (*jose.JSONWebKeySet, error) {
-	resp, err := http.Get(url)
+// verifySubjectToken verifies the token signature with the first key that
+// matches and extracts its claims. Keys that do not allow the token's alg are
+// skipped, so a key is never used with an algorithm it was not meant for.
+func verifySubjectToken(parsedToken *jwt.JSONWebToken, keys []jose.JSONWebKey) (map[string]any, error) {
+	var err error
+	for _, key := range keys {
+		if algErr := keyAllowsAlgorithm(key, parsedToken.Headers[0].Algorithm); algErr != nil {
+			if err == nil {
+				err = algErr
+			}
+			continue
+		}
+
+		claims := make(map[string]any)
+		if err = parsedToken.Claims(key, &claims); err == nil {
+			return claims, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to verify signature: %w", err)
+}
+
+// jwksCacheEntry is a cached subject JWKS document
+type jwksCacheEntry struct {
+	keySet       *jose.JSONWebKeySet
+	expiresAt    time.Time
+	etag         string
+	lastModified string
+}
+
+// subjectJWKSCacheTTL is how long a fetched subject JWKS is reused
+const subjectJWKSCacheTTL = 5 * time.Minute
+
+// subjectJWKSRefreshInterval limits the refetches each unknown key ID triggers
+const subjectJWKSRefreshInterval = 30 * time.Second
+
+// subjectJWKSMinRefreshInterval is the least time between a fetch and a
+// refetch triggered by an unknown key ID, whatever the key ID
+const subjectJWKSMinRefreshInterval = 5 * time.Second
+
+// getSubjectJWKS returns the subject JWKS, from the cache or the copy
+// persisted in storage when possible
+func (b *Backend) getSubjectJWKS(ctx context.Context, storage logical.Storage, config *Config) (*jose.JSONWebKeySet, error) {
+	jwksURI := config.SubjectJWKSURI
+
+	b.cacheLock.RLock()
+	cached, ok := b.jwksCache[jwksURI]
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+
+	hit := ok && time.Now().Before(cached.expiresAt)
+	b.recordCacheLookup("jwks", hit)
+	if hit {
+		return cached.keySet, nil
+	}
+
+	if stored := b.loadStoredJWKS(ctx, storage, config, generation, time.Time{}); stored != nil {
+		return stored.KeySet, nil
+	}
+
+	return b.fetchSubjectJWKS(ctx, storage, config, generation)
+}
+
+// refreshSubjectJWKS refetches the subject JWKS after the unknown key ID kid
+// so an issuer key rollover is picked up before the cache expires, and reports
+// whether it did. Each key ID forces a refetch at most once per
+// subjectJWKSRefreshInterval, and none is made within
+// subjectJWKSMinRefreshInterval of the last fetch, so tokens with made-up key
+// IDs cannot flood the issuer. A newer copy stored by another node is used
+// instead of fetching.
+func (b *Backend) refreshSubjectJWKS(ctx context.Context, storage logical.Storage, config *Config, kid string) (*jose.JSONWebKeySet, bool) {
+	now := time.Now()
+
+	b.cacheLock.Lock()
+	generation := b.cacheGeneration
+	status, ok := b.jwksStatus[config.SubjectJWKSURI]
+	recent := ok && (now.Sub(status.lastAttempt) < subjectJWKSMinRefreshInterval || now.Sub(status.unknownKids[kid]) < subjectJWKSRefreshInterval)
+	if ok && !recent {
+		for unknown, at := range status.unknownKids {
+			if now.Sub(at) >= subjectJWKSRefreshInterval {
+				delete(status.unknownKids, unknown)
+			}
+		}
+		status.unknownKids[kid] = now
+	}
+	var fetchedAfter time.Time
+	if cached, ok := b.jwksCache[config.SubjectJWKSURI]; ok {
+		fetchedAfter = cached.expiresAt.Add(-subjectJWKSCacheTTL)
+	}
+	b.cacheLock.Unlock()
+
+	if recent {
+		return nil, false
+	}
+	b.Logger().Debug("subject token key ID not in the cached JWKS, refreshing", "jwks_uri", config.SubjectJWKSURI, "kid", kid)
+
+	if stored := b.loadStoredJWKS(ctx, storage, config, generation, fetchedAfter); stored != nil {
+		return stored.KeySet, true
+	}
+
+	keySet, err := b.fetchSubjectJWKS(ctx, storage, config, generation)
+	if err != nil {
+		return nil, false
+	}
+	return keySet, true
+}
+
+// fetchSubjectJWKS fetches the subject JWKS, persists it and caches it unless
+// the caches were reset since generation was read. Fetches fail fast while the
+// circuit breaker is open.
+func (b *Backend) fetchSubjectJWKS(ctx context.Context, storage logical.Storage, config *Config, generation uint64) (*jose.JSONWebKeySet, error) {
+	jwksURI := config.SubjectJWKSURI
+	client, err := b.getJWKSClient(config)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch jwks: %s, status %d", url, resp.StatusCode)
+	if !b.jwksBreaker.allow(time.Now()) {
+		return nil, errJWKSCircuitOpen
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	previous := b.previousSubjectJWKS(ctx, storage, jwksURI)
+	fetchStart := time.Now()
+	document, err := fetchJWKS(ctx, client, jwksURI, config.JWKSMaxRetries, previous)
+	var keySet *jose.JSONWebKeySet
+	if err == nil {
+		keySet = document.keySet
+	}
+	if b.jwksBreaker.record(time.Now(), err) {
+		b.Logger().Warn("subject JWKS fetches failing, pausing fetches", "jwks_uri", jwksURI, "cooldown", jwksBreakerCooldown, "error", err)
+	}
+	b.recordJWKSFetch(fetchStart, err)
+	b.recordSubjectJWKSStatus(jwksURI, keySet, err, fetchStart)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response from jwks, %s", err)
+		return nil, err
 	}
 
-	var jwks jose.JSONWebKeySet
-	if err := json.Unmarshal(body, &jwks); err != nil {
-		return nil, err
+	b.cacheLock.Lock()
+	current := b.cacheGeneration == generation
+	if current {
+		b.jwksCache[jwksURI] = &jwksCacheEntry{
+			keySet:       keySet,
+			expiresAt:    fetchStart.Add(subjectJWKSCacheTTL),
+			etag:         document.etag,
+			lastModified: document.lastModified,
+		}
+	}
+	b.cacheLock.Unlock()
+
+	if current {
+		b.storeJWKS(ctx, storage, jwksURI, document, fetchStart)
 	}
 
-	return &jwks, nil
+	return keySet, nil
 }
 
 // checkExpiration checks if the token is expired
 func checkExpiration(claims map[string]any) error {
-	exp, ok := claims["exp"]
+	expTime, err := numericDateClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+
+	if time.Now().Unix() > expTime {
+		return fmt.Errorf("token expired at %v", time.Unix(expTime, 0))
+	}
+
+	return nil
+}
+
+// numericDateClaim returns a NumericDate claim such as exp as unix seconds
+func numericDateClaim(claims map[string]any, name string) (int64, error) {
+	value, ok := claims[name]
 	if !ok {
-		return fmt.Errorf("token missing exp claim")
+		return 0, fmt.Errorf("token missing %s claim", name)
 	}
 
-	var expTime int64
-	switch v := exp.(type) {
+	switch v := value.(type) {
 	case float64:
-		expTime = int64(v)
+		return int64(v), nil
 	case int64:
-		expTime = v
+		return v, nil
 	case json.Number:
-		expInt, err := v.Int64()
+		n, err := v.Int64()
 		if err != nil {
-			return fmt.Errorf("invalid exp claim format")
+			return 0, fmt.Errorf("invalid %s claim format", name)
 		}
-		expTime = expInt
+		return n, nil
 	default:
-		return fmt.Errorf("invalid exp claim type")
+		return 0, fmt.Errorf("invalid %s claim type", name)
 	}
-
-	if time.Now().Unix() > expTime {
-		return fmt.Errorf("token expired at %v", time.Unix(expTime, 0))
-	}
-
-	return nil
 }
 
 // validateBoundIssuer checks if the token issuer matches the role's bound issuer
@@ -282,8 +929,9 @@ func validateBoundIssuer(claims map[string]any, boundIssuer string) error {
 	return nil
 }
 
-// validateBoundAudiences checks if the token audience matches any of the role's bound audiences
-func validateBoundAudiences(claims map[string]any, boundAudiences []string) error {
+// validateBoundAudiences checks if the token audience matches any of the
+// role's bound audiences, compared as boundAudiencesType
+func validateBoundAudiences(claims map[string]any, boundAudiences []string, boundAudiencesType string) error {
 	if len(boundAudiences) == 0 {
 		return nil // No bound audiences configured, skip validation
 	}
@@ -313,7 +961,7 @@ func validateBoundAudiences(claims map[string]any, boundAudiences []string) erro
 	// Check if any token audience matches any bound audience
 	for _, tokenAud := range tokenAudiences {
 		for _, boundAud := range boundAudiences {
-			if tokenAud == boundAud {
+			if audienceMatches(tokenAud, boundAud, boundAudiencesType) {
 				return nil // Match found
 			}
 		}
@@ -322,6 +970,96 @@ func validateBoundAudiences(claims map[string]any, boundAudiences []string) erro
 	return fmt.Errorf("token audience does not match any bound_audiences")
 }
 
+// audienceMatches reports whether a token audience matches a bound audience.
+// Prefix matches must end at a path segment boundary, so
+// https://api.example.com/orders does not match https://api.example.com/orders-admin.
+func audienceMatches(tokenAud, boundAud, boundAudiencesType string) bool {
+	switch boundAudiencesType {
+	case BoundAudiencesTypeGlob:
+		return glob.Glob(boundAud, tokenAud)
+	case BoundAudiencesTypePrefix:
+		rest, ok := strings.CutPrefix(tokenAud, boundAud)
+		return ok && (rest == "" || strings.HasSuffix(boundAud, "/") || strings.HasPrefix(rest, "/"))
+	default:
+		return tokenAud == boundAud
+	}
+}
+
+// validateBoundClaims checks that every bound claim in the subject token
+// matches at least one allowed value. List-valued claims match if any
+// element matches.
+func validateBoundClaims(claims map[string]any, boundClaims map[string][]string, boundClaimsType string) error {
+	for name, allowed := range boundClaims {
+		value, ok := lookupClaim(claims, name)
+		if !ok {
+			return fmt.Errorf("token missing bound claim %q", name)
+		}
+
+		var actual []any
+		if list, ok := value.([]any); ok {
+			actual = list
+		} else {
+			actual = []any{value}
+		}
+
+		if !claimValuesMatch(actual, allowed, boundClaimsType == BoundClaimsTypeGlob) {
+			return fmt.Errorf("claim %q does not match any allowed value", name)
+		}
+	}
+
+	return nil
+}
+
+// lookupClaim returns a claim by name, or by JSON pointer (e.g. /org/team) for nested claims
+func lookupClaim(claims map[string]any, name string) (any, bool) {
+	if !strings.HasPrefix(name, "/") {
+		value, ok := claims[name]
+		return value, ok
+	}
+
+	var current any = claims
+	for _, segment := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[segment]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// claimValuesMatch reports whether any scalar claim value matches any allowed
+// value. With useGlob, '*' in an allowed value matches any sequence.
+func claimValuesMatch(actual []any, allowed []string, useGlob bool) bool {
+	for _, a := range actual {
+		var s string
+		switch v := a.(type) {
+		case string:
+			s = v
+		case float64, bool, json.Number:
+			s = fmt.Sprint(v)
+		default:
+			continue
+		}
+
+		for _, want := range allowed {
+			if useGlob && glob.Glob(want, s) {
+				return true
+			}
+			if !useGlob && want == s {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // fetchEntity retrieves the entity associated with the request
 func fetchEntity(req *logical.Request, system logical.SystemView) (*logical.Entity, error) {
 	entityID := req.EntityID
@@ -337,6 +1075,160 @@ func fetchEntity(req *logical.Request, system logical.SystemView) (*logical.Enti
 	return entity, nil
 }
 
+// signingKeyName returns the key mapped to the first audience in aud that has
+// an audience_keys entry, or the role's key when none is mapped. Roles without
+// a key use defaultKey.
+func signingKeyName(role *Role, defaultKey string, aud any) string {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	for _, audience := range audiences {
+		if keyName, ok := role.AudienceKeys[audience]; ok {
+			return keyName
+		}
+	}
+
+	return cmp.Or(role.Key, defaultKey)
+}
+
+// entityBound reports whether the entity satisfies the role's bound_entity_ids
+// and bound_group_ids. Roles without either list allow every entity.
+func entityBound(system logical.SystemView, entityID string, role *Role) (bool, error) {
+	if len(role.BoundEntityIDs) == 0 && len(role.BoundGroupIDs) == 0 {
+		return true, nil
+	}
+	if entityID == "" {
+		return false, nil
+	}
+
+	if slices.Contains(role.BoundEntityIDs, entityID) {
+		return true, nil
+	}
+	if len(role.BoundGroupIDs) == 0 {
+		return false, nil
+	}
+
+	groups, err := system.GroupsForEntity(entityID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get groups for entity: %w", err)
+	}
+	for _, group := range groups {
+		if slices.Contains(role.BoundGroupIDs, group.ID) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// missingEntityMetadata returns the required metadata keys that are unset or empty on the entity
+func missingEntityMetadata(entity *logical.Entity, required []string) []string {
+	var missing []string
+	for _, key := range required {
+		if entity.Metadata[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// missingSubjectClaims returns the required claims absent from the subject
+// token. With nonEmpty, claims that are null, empty strings or empty lists or
+// objects are missing too.
+func missingSubjectClaims(claims map[string]any, required []string, nonEmpty bool) []string {
+	var missing []string
+	for _, name := range required {
+		value, ok := lookupClaim(claims, name)
+		if !ok || (nonEmpty && isEmptyClaim(value)) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// isEmptyClaim reports whether a claim value carries no information
+func isEmptyClaim(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// actorTemplateContext builds the data available to actor_template. Aliases
+// are keyed by auth mount accessor, as in Vault's identity templating.
+func actorTemplateContext(entity *logical.Entity, groups []*logical.Group, token *callerToken) map[string]any {
+	groupNames := make([]string, 0, len(groups))
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupNames = append(groupNames, group.Name)
+		groupIDs = append(groupIDs, group.ID)
+	}
+
+	return map[string]any{
+		"identity": map[string]map[string]any{
+			"entity": {
+				"id":           entity.ID,
+				"name":         entity.Name,
+				"namespace_id": entity.NamespaceID,
+				"metadata":     entity.Metadata,
+				"aliases":      entityAliasesContext(entity),
+			},
+			"groups": {
+				"names": groupNames,
+				"ids":   groupIDs,
+			},
+			"token": callerTokenContext(token),
+		},
+	}
+}
+
+// entityAliasesContext returns an entity's aliases keyed by mount accessor
+func entityAliasesContext(entity *logical.Entity) map[string]any {
+	aliases := make(map[string]any, len(entity.Aliases))
+	for _, alias := range entity.Aliases {
+		aliases[alias.MountAccessor] = map[string]any{
+			"name":            alias.Name,
+			"mount_type":      alias.MountType,
+			"metadata":        alias.Metadata,
+			"custom_metadata": alias.CustomMetadata,
+		}
+	}
+	return aliases
+}
+
+// usesGroups reports whether a template references identity.groups, so group
+// lookups are only made for roles that need them
+func usesGroups(template string) bool {
+	return strings.Contains(template, "identity.groups")
+}
+
+// subjectTemplateContext builds the data available to subject_template
+func subjectTemplateContext(subjectClaims, directoryAttrs map[string]any) map[string]any {
+	return map[string]any{
+		"identity": map[string]map[string]any{
+			"subject":   subjectClaims,
+			"directory": directoryAttrs,
+		},
+	}
+}
+
 // jsonifyClaimsMap recursively walks a claims map and converts any slice or
 // nested map values into their JSON string representation. This ensures that
 // when mustache renders {{some.array.claim}}, it produces valid JSON (e.g.
@@ -402,32 +1294,53 @@ func processTemplate(template string, claims map[string]any) (map[string]any, er
 	return ret, nil
 }
 
-// generateToken generates a new JWT with the merged claims
-func generateToken(config *Config, role *Role, subjectID string, actorClaims, subjectClaims map[string]any, signingKey *rsa.PrivateKey, keyID string, algorithm jose.SignatureAlgorithm, entityID string) (string, error) {
-	// Create signer with kid in header
-	signerOpts := (&jose.SignerOptions{}).WithType("JWT")
-
-	if keyID != "" {
-		signerOpts = signerOpts.WithHeader("kid", keyID) // NEW: include kid
+// earliestDeadline returns the earliest non-zero deadline, or zero if none are set
+func earliestDeadline(deadlines ...time.Time) time.Time {
+	var earliest time.Time
+	for _, d := range deadlines {
+		if d.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || d.Before(earliest) {
+			earliest = d
+		}
 	}
+	return earliest
+}
 
-	signer, err := jose.NewSigner(
-		jose.SigningKey{Algorithm: algorithm, Key: signingKey}, // Use role's algorithm
-		signerOpts,
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to create signer: %w", err)
+// tokenExpiry returns now + ttl, capped at notAfter when set
+func tokenExpiry(now time.Time, ttl time.Duration, notAfter time.Time) time.Time {
+	exp := now.Add(ttl)
+	if !notAfter.IsZero() && notAfter.Before(exp) {
+		return notAfter
 	}
+	return exp
+}
 
-	// Build claims
-	now := time.Now()
+// issuedToken is a signed token along with non-sensitive metadata about it
+type issuedToken struct {
+	Token     string
+	JTI       string
+	KeyID     string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+	Scope     string
+	Subject   string
+	Actor     string
+	Issuer    string
+}
+
+// buildTokenClaims assembles the claims of a delegated token, except jti.
+// It also returns the actor subject placed in the act claim.
+func buildTokenClaims(config *Config, role *Role, scopes []string, subjectID string, actorClaims, subjectClaims map[string]any, defaultActorSubject string, now, notBefore, expiresAt time.Time, jwksURL string) (map[string]any, string) {
 	claims := make(map[string]any)
 
 	// Standard claims
-	claims["iss"] = config.Issuer
-	claims["sub"] = subjectID // Subject from the original user token
+	claims["iss"] = roleIssuer(config, role)
+	claims["sub"] = subjectID // Subject chosen by the role's subject_claim_source
 	claims["iat"] = now.Unix()
-	claims["exp"] = now.Add(role.TTL).Unix()
+	claims["exp"] = expiresAt.Unix()
 
 	// Add audience if present
 	if aud, ok := actorClaims["aud"]; ok {
@@ -447,19 +1360,19 @@ func generateToken(config *Config, role *Role, subjectID string, actorClaims, su
 		}
 	}
 
-	// If no actor subject in template, construct from entity ID
+	// If no actor subject in template, use the caller's entity or actor ID
 	if actorSubject == "" {
-		actorSubject = fmt.Sprintf("entity:%s", entityID)
+		actorSubject = defaultActorSubject
 	}
 
 	claims["act"] = map[string]any{
 		"sub": actorSubject,
-		"iss": config.Issuer, // Optional: issuer of actor identity
+		"iss": roleIssuer(config, role), // Optional: issuer of actor identity
 	}
 
 	// Add RFC 8693 scope claim (space-delimited)
-	if len(role.Context) > 0 {
-		claims["scope"] = strings.Join(role.Context, " ")
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
 	}
 
 	// Add subject claims under "subject_claims" key (optional extension)
@@ -470,18 +1383,88 @@ func generateToken(config *Config, role *Role, subjectID string, actorClaims, su
 	// Merge actor claims for optional extensions (e.g., actor_metadata)
 	// This allows templates to add custom actor metadata outside the act claim
 	for key, value := range actorClaims {
-		// Don't allow overriding reserved claims or act claim
-		if key != "iss" && key != "sub" && key != "iat" && key != "exp" && key != "aud" && key != "act" {
+		// Don't allow overriding reserved claims, the act claim or the
+		// authorized scopes
+		if key != "iss" && key != "sub" && key != "iat" && key != "exp" && key != "aud" && key != "act" && key != "jti" && key != "scope" {
 			claims[key] = value
 		}
 	}
 
+	// The role's validity window replaces any nbf from the templates
+	if nbf, ok := tokenNotBefore(role, now, notBefore); ok {
+		claims["nbf"] = nbf.Unix()
+	}
+
+	// The verification hint replaces any verification_url from the templates
+	if jwksURL != "" && role.VerificationHint == VerificationHintClaim {
+		claims["verification_url"] = jwksURL
+	}
+
+	applyTokenProfile(role, claims, actorSubject)
+	namespaceClaims(claims, claimNamespace(config, role))
+
+	return claims, actorSubject
+}
+
+// generateToken generates a new JWT with the merged claims. A non-nil
+// certificate is included in the header as x5c and x5t#S256.
+func generateToken(config *Config, role *Role, scopes []string, subjectID string, actorClaims, subjectClaims map[string]any, signingKey any, keyID string, algorithm jose.SignatureAlgorithm, defaultActorSubject string, notBefore, notAfter time.Time, jwksURL string, certificate *x509.Certificate) (*issuedToken, error) {
+	// Create signer with kid in header
+	signerOpts := (&jose.SignerOptions{}).WithType("JWT")
+
+	if keyID != "" {
+		signerOpts = signerOpts.WithHeader("kid", keyID) // NEW: include kid
+	}
+	if jwksURL != "" && role.VerificationHint == VerificationHintJKU {
+		signerOpts = signerOpts.WithHeader("jku", jwksURL)
+	}
+	if certificate != nil {
+		signerOpts = signerOpts.
+			WithHeader("x5c", []string{base64.StdEncoding.EncodeToString(certificate.Raw)}).
+			WithHeader("x5t#S256", certificateThumbprint(certificate))
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: signingKey}, // Use role's algorithm
+		signerOpts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	jti, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate jti: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := tokenExpiry(tokenValidFrom(now, notBefore), role.TTL, notAfter)
+	claims, actorSubject := buildTokenClaims(config, role, scopes, subjectID, actorClaims, subjectClaims, defaultActorSubject, now, notBefore, expiresAt, jwksURL)
+	if err := checkProfileClaims(role, claims); err != nil {
+		return nil, err
+	}
+	claims["jti"] = jti // Unique token ID (RFC 7519) for audit correlation
+
 	// Build and sign token
 	builder := jwt.Signed(signer).Claims(claims)
 	token, err := builder.Serialize()
 	if err != nil {
-		return "", fmt.Errorf("failed to serialize token: %w", err)
+		return nil, fmt.Errorf("failed to serialize token: %w", err)
 	}
 
-	return token, nil
+	scope, _ := claims["scope"].(string)
+	nbf, _ := tokenNotBefore(role, now, notBefore)
+
+	return &issuedToken{
+		Token:     token,
+		JTI:       jti,
+		KeyID:     keyID,
+		IssuedAt:  now,
+		NotBefore: nbf,
+		ExpiresAt: expiresAt,
+		Scope:     scope,
+		Subject:   subjectID,
+		Actor:     actorSubject,
+		Issuer:    roleIssuer(config, role),
+	}, nil
 }
@@ -0,0 +1,123 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_IDToken tests issuing an ID token alongside the delegated token
+func TestTokenExchange_IDToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"issue_id_token": true,
+		"actor_template": `{"act": {"sub": "agent-123", "name": "Agent X"}, "aud": "dashboard"}`,
+	})
+
+	authTime := time.Now().Add(-10 * time.Minute).Unix()
+	subjectClaims := defaultSubjectClaims()
+	subjectClaims["name"] = "Alice"
+	subjectClaims["auth_time"] = authTime
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data: map[string]any{
+			"subject_token": generateTestJWT(t, privateKey, kid, subjectClaims),
+			"nonce":         "n-0S6_WzA2Mj",
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	accessToken := resp.Data["token"].(string)
+	accessClaims := parseIssuedToken(t, b, storage, accessToken)
+	idClaims := parseIssuedToken(t, b, storage, resp.Data["id_token"].(string))
+
+	require.Equal(t, "https://vault.example.com", idClaims["iss"])
+	require.Equal(t, "user-123", idClaims["sub"])
+	require.Equal(t, "dashboard", idClaims["aud"])
+	require.Equal(t, "n-0S6_WzA2Mj", idClaims["nonce"])
+	require.Equal(t, float64(authTime), idClaims["auth_time"])
+	require.Equal(t, "Alice", idClaims["name"])
+	require.Equal(t, accessClaims["exp"], idClaims["exp"])
+	require.NotEqual(t, accessClaims["jti"], idClaims["jti"])
+	require.Equal(t, map[string]any{"sub": "agent-123", "iss": "https://vault.example.com", "name": "Agent X"}, idClaims["act"])
+
+	digest := sha256.Sum256([]byte(accessToken))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(digest[:16]), idClaims["at_hash"])
+}
+
+// TestTokenExchange_IDTokenValidation tests the ID token role and request checks
+func TestTokenExchange_IDTokenValidation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	// Roles without issue_id_token return no ID token and refuse a nonce
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.NotContains(t, resp.Data, "id_token")
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data: map[string]any{
+			"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+			"nonce":         "abc",
+		},
+	})
+	require.NoError(t, err)
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	for name, roleData := range map[string]map[string]any{
+		"detached_payload": {"detached_payload": true},
+		"upstream_sts_url": {"upstream_sts_url": "https://sts.example.com/token"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := map[string]any{
+				"ttl":              "1h",
+				"key":              "test-key",
+				"actor_template":   "{}",
+				"subject_template": "{}",
+				"context":          "urn:documents:read",
+				"issue_id_token":   true,
+			}
+			for k, v := range roleData {
+				data[k] = v
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/id-role",
+				Storage:   storage,
+				Data:      data,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), "issue_id_token cannot be combined with "+name)
+		})
+	}
+}
+
+// TestAccessTokenHash tests at_hash uses the hash of the signing algorithm
+func TestAccessTokenHash(t *testing.T) {
+	for algorithm, size := range map[jose.SignatureAlgorithm]int{jose.RS256: 16, jose.PS384: 24, jose.RS512: 32} {
+		hash, err := accessTokenHash("token", algorithm)
+		require.NoError(t, err)
+		decoded, err := base64.RawURLEncoding.DecodeString(hash)
+		require.NoError(t, err)
+		require.Len(t, decoded, size)
+	}
+
+	_, err := accessTokenHash("token", jose.HS256)
+	require.Error(t, err)
+}
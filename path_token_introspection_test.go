@@ -0,0 +1,177 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// createMockIntrospectionServer creates a test RFC 7662 introspection
+// endpoint. Only the token "opaque-token" is active.
+func createMockIntrospectionServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "vault" || clientSecret != "introspection-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "access_token", r.PostForm.Get("token_type_hint"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("token") != "opaque-token" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": false})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"active":     true,
+			"sub":        "user-123",
+			"iss":        "https://idp.example.com",
+			"exp":        time.Now().Add(time.Hour).Unix(),
+			"scope":      "read write",
+			"client_id":  "web-app",
+			"department": "engineering",
+		})
+	}))
+}
+
+// exchangeAccessToken exchanges subjectToken as an access token with the test role
+func exchangeAccessToken(t *testing.T, b *Backend, storage logical.Storage, subjectToken string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data: map[string]any{
+			"subject_token":      subjectToken,
+			"subject_token_type": tokenTypeAccessToken,
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestTokenExchange_Introspection tests exchanging opaque access tokens
+// validated at an introspection endpoint
+func TestTokenExchange_Introspection(t *testing.T) {
+	b, storage := getTestBackend(t)
+	server := createMockIntrospectionServer(t)
+	defer server.Close()
+
+	setupTestExchange(t, b, storage, map[string]any{
+		"subject_template": `{"department": "{{identity.subject.department}}", "client": "{{identity.subject.client_id}}"}`,
+		"bound_issuer":     "https://idp.example.com",
+	})
+
+	writeConfig := func(data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := writeConfig(map[string]any{
+		"introspection_url":           server.URL,
+		"introspection_client_id":     "vault",
+		"introspection_client_secret": "introspection-secret",
+	})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	t.Run("config read hides the client secret", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   storage,
+		})
+		require.NoError(t, err)
+		require.Equal(t, server.URL, resp.Data["introspection_url"])
+		require.Equal(t, "vault", resp.Data["introspection_client_id"])
+		require.Equal(t, true, resp.Data["introspection_client_secret_configured"])
+		require.NotContains(t, resp.Data, "introspection_client_secret")
+	})
+
+	t.Run("active token", func(t *testing.T) {
+		resp := exchangeAccessToken(t, b, storage, "opaque-token")
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "user-123", claims["sub"])
+		require.Equal(t, map[string]any{"department": "engineering", "client": "web-app"}, claims["subject_claims"])
+	})
+
+	t.Run("inactive token", func(t *testing.T) {
+		resp := exchangeAccessToken(t, b, storage, "revoked-token")
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "not active")
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token":      "opaque-token",
+				"subject_token_type": "urn:ietf:params:oauth:token-type:refresh_token",
+			},
+		})
+		require.NoError(t, err)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, server.URL, http.StatusTemporaryRedirect)
+		}))
+		defer redirector.Close()
+
+		resp := writeConfig(map[string]any{"introspection_url": redirector.URL})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+		defer writeConfig(map[string]any{"introspection_url": server.URL})
+
+		resp = exchangeAccessToken(t, b, storage, "opaque-token")
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+		require.Contains(t, resp.Error().Error(), "status 307")
+	})
+
+	t.Run("rejected client credentials", func(t *testing.T) {
+		resp := writeConfig(map[string]any{"introspection_client_secret": "wrong"})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+		resp = exchangeAccessToken(t, b, storage, "opaque-token")
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+		require.Contains(t, resp.Error().Error(), "status 401")
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		resp := writeConfig(map[string]any{"introspection_url": "ftp://idp.example.com"})
+		require.True(t, resp.IsError())
+	})
+}
+
+// TestTokenExchange_AccessTokenWithoutIntrospection tests that access tokens
+// are verified as JWTs when no introspection endpoint is configured
+func TestTokenExchange_AccessTokenWithoutIntrospection(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	resp := exchangeAccessToken(t, b, storage, subjectToken)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	resp = exchangeAccessToken(t, b, storage, "opaque-token")
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+}
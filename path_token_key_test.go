@@ -179,3 +179,178 @@ func TestPathTokenExchange_RS384(t *testing.T) {
 	// 2. Roles can reference RS384 keys
 	// 3. The algorithm mapping code in token generation handles RS384
 }
+
+// TestTokenExchange_AudienceKeys tests that the signing key is selected by the
+// audience resolved from the actor template
+func TestTokenExchange_AudienceKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		aud       string
+		algorithm jose.SignatureAlgorithm
+		key       string
+	}{
+		{name: "mapped audience", aud: `"legacy-service"`, algorithm: jose.RS384, key: "legacy-key"},
+		{name: "mapped audience in list", aud: `["mesh", "legacy-service"]`, algorithm: jose.RS384, key: "legacy-key"},
+		{name: "unmapped audience", aud: `"mesh"`, algorithm: jose.RS256, key: "test-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "key/legacy-key",
+				Storage:   storage,
+				Data:      map[string]any{"algorithm": "RS384"},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+				"actor_template": `{"act": {"sub": "agent-123"}, "aud": ` + tt.aud + `}`,
+				"audience_keys":  map[string]any{"legacy-service": "legacy-key"},
+			})
+
+			resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+			require.Equal(t, tt.key+"-v1", resp.Data["key_id"])
+
+			parsed, err := jwt.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{jose.RS256, jose.RS384})
+			require.NoError(t, err)
+			require.Equal(t, string(tt.algorithm), parsed.Headers[0].Algorithm)
+			require.Equal(t, tt.key+"-v1", parsed.Headers[0].KeyID)
+		})
+	}
+}
+
+// TestPathRoleWrite_AudienceKeys tests audience_keys validation and key deletion protection
+func TestPathRoleWrite_AudienceKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+	createTestKey(t, b, storage, "test-key")
+
+	roleData := func(audienceKey string) map[string]any {
+		return map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{"act": {"sub": "agent-123"}}`,
+			"subject_template": `{}`,
+			"context":          "urn:documents:read",
+			"audience_keys":    "legacy-service=" + audienceKey,
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      roleData("missing-key"),
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), `key "missing-key" for audience "legacy-service" not found`)
+
+	createTestKey(t, b, storage, "legacy-key")
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      roleData("legacy-key"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"legacy-service": "legacy-key"}, resp.Data["audience_keys"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "key/legacy-key",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError(), "deleting a key mapped to an audience should fail")
+}
+
+// TestTokenExchange_RSAPSS tests issuing tokens signed with RSA-PSS keys
+func TestTokenExchange_RSAPSS(t *testing.T) {
+	for _, algorithm := range []jose.SignatureAlgorithm{jose.PS256, jose.PS384, jose.PS512} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "key/pss-key",
+				Storage:   storage,
+				Data:      map[string]any{"algorithm": string(algorithm)},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"key": "pss-key"})
+
+			resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			parsed, err := jwt.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{algorithm})
+			require.NoError(t, err)
+			require.Equal(t, string(algorithm), parsed.Headers[0].Algorithm)
+
+			claims := map[string]any{}
+			publicKey := getPublicKeyFromJWKS(t, b, storage, "pss-key-v1")
+			require.NoError(t, parsed.Claims(publicKey, &claims))
+			require.Equal(t, "user-123", claims["sub"])
+		})
+	}
+}
+
+// TestTokenExchange_DefaultKey tests roles without a key signing with the
+// config's default_key
+func TestTokenExchange_DefaultKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	// Roles need a key while no default is configured
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": "https://idp.example.com/jwks"})
+	require.Nil(t, resp)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/no-key",
+		Storage:   storage,
+		Data:      map[string]any{"ttl": "1h", "actor_template": "{}", "subject_template": "{}", "context": "urn:documents:read"},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "key is required unless default_key is configured")
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"default_key": "missing"})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), `default key "missing" not found`)
+
+	sharedKeyID := createTestKey(t, b, storage, "shared-key")
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"default_key": "shared-key"})
+	require.Nil(t, resp)
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"audit_key": "shared-key"})
+	require.True(t, resp.IsError())
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"key": ""})
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, sharedKeyID, resp.Data["key_id"])
+
+	// The default key is protected while roles rely on it
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "key/shared-key",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "default_key")
+}
@@ -0,0 +1,119 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_RoleNotAfterCapsExpiry tests that a role deadline caps exp
+func TestTokenExchange_RoleNotAfterCapsExpiry(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	deadline := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"ttl":       "1h",
+		"not_after": deadline.Format(time.RFC3339),
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, float64(deadline.Unix()), claims["exp"], "exp should be capped at not_after")
+
+	readReq := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), readReq)
+	require.NoError(t, err)
+	require.Equal(t, deadline.Format(time.RFC3339), resp.Data["not_after"])
+}
+
+// TestTokenExchange_RequestNotAfter tests that an exchange can only shorten the lifetime
+func TestTokenExchange_RequestNotAfter(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"ttl": "1h"})
+
+	tests := []struct {
+		name     string
+		notAfter time.Time
+	}{
+		{
+			name:     "shortens lifetime",
+			notAfter: time.Now().Add(5 * time.Minute).UTC().Truncate(time.Second),
+		},
+		{
+			name:     "cannot extend ttl",
+			notAfter: time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenReq := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "token/test-role",
+				Storage:   storage,
+				EntityID:  "test-entity",
+				Data: map[string]any{
+					"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+					"not_after":     tt.notAfter.Format(time.RFC3339),
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), tokenReq)
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+			exp := int64(claims["exp"].(float64))
+			iat := int64(claims["iat"].(float64))
+			require.LessOrEqual(t, exp, iat+int64(time.Hour.Seconds()))
+			require.LessOrEqual(t, exp, tt.notAfter.Unix())
+		})
+	}
+}
+
+// TestTokenExchange_NotAfterPassed tests that exchanges are rejected after the deadline
+func TestTokenExchange_NotAfterPassed(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"not_after": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "deadline")
+}
+
+// TestPathRoleWrite_InvalidNotAfter tests not_after validation
+func TestPathRoleWrite_InvalidNotAfter(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	createTestKey(t, b, storage, "test-key")
+
+	roleReq := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{}`,
+			"subject_template": `{}`,
+			"context":          []string{"scope1"},
+			"not_after":        "next tuesday",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), roleReq)
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "RFC 3339")
+}
@@ -0,0 +1,92 @@
+package tokenexchange
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExchange_Policy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		claims  map[string]any
+		allowed bool
+		error   string
+	}{
+		{
+			name:    "subject groups contain the agent's team",
+			policy:  `entity.metadata.team in subject.groups`,
+			claims:  map[string]any{"groups": []string{"platform", "billing"}},
+			allowed: true,
+		},
+		{
+			name:   "subject groups without the agent's team",
+			policy: `entity.metadata.team in subject.groups`,
+			claims: map[string]any{"groups": []string{"billing"}},
+			error:  "denied by the role policy",
+		},
+		{
+			name:   "missing claim",
+			policy: `entity.metadata.team in subject.groups`,
+			error:  "failed to evaluate policy",
+		},
+		{
+			name:    "has guards missing claims",
+			policy:  `!has(subject.groups) || entity.metadata.team in subject.groups`,
+			allowed: true,
+		},
+		{
+			name:    "entity groups and request",
+			policy:  `"agents" in entity.groups && request.role == "test-role" && request.subject_token_type.endsWith(":jwt")`,
+			allowed: true,
+		},
+		{
+			name:    "set extension",
+			policy:  `sets.intersects(subject.groups, entity.groups) && subject.email.endsWith("@example.com")`,
+			claims:  map[string]any{"groups": []string{"agents"}},
+			allowed: true,
+		},
+		{
+			name:   "not a bool at runtime",
+			policy: `subject.email`,
+			error:  "not a bool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			b.System().(*logical.StaticSystemView).GroupsVal = []*logical.Group{{ID: "group-1", Name: "agents"}}
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"policy": tt.policy})
+
+			claims := defaultSubjectClaims()
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+			if tt.allowed {
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				return
+			}
+			requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+			require.Contains(t, resp.Error().Error(), tt.error)
+		})
+	}
+}
+
+func TestRoleWrite_InvalidPolicy(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+
+	for policy, message := range map[string]string{
+		`subject.groups.contains(`: "Syntax error",
+		`unknown.claim == "x"`:     "undeclared reference",
+		`"not a bool"`:             "must evaluate to a bool",
+	} {
+		resp := writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"policy": policy})
+		require.True(t, resp.IsError(), policy)
+		require.Contains(t, resp.Error().Error(), message)
+	}
+}
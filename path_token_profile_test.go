@@ -0,0 +1,252 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// writeProfileRole writes test-role with the given token profile and extra fields
+func writeProfileRole(t *testing.T, b *Backend, storage logical.Storage, profile string, extra map[string]any) *logical.Response {
+	data := map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{"act": {"sub": "agent-123"}}`,
+		"subject_template": `{}`,
+		"context":          "urn:documents:read",
+		"token_profile":    profile,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestTokenExchange_AzureProfile(t *testing.T) {
+	t.Run("claims", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"token_profile":  TokenProfileAzure,
+			"actor_template": `{"act": {"sub": "agent-123"}, "aud": "service-a"}`,
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "agent-123", claims["sub"])
+		require.Equal(t, "user-123", claims["original_sub"])
+		require.Equal(t, "api://AzureADTokenExchange", claims["aud"])
+		require.Equal(t, "https://vault.example.com", claims["iss"])
+		require.Equal(t, map[string]any{"sub": "agent-123", "iss": "https://vault.example.com"}, claims["act"])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, TokenProfileAzure, resp.Data["token_profile"])
+	})
+
+	t.Run("invalid roles", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		resp := writeProfileRole(t, b, storage, "gcp-ish", nil)
+		require.True(t, resp.IsError())
+
+		resp = writeProfileRole(t, b, storage, TokenProfileAzure, map[string]any{"detached_payload": true})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "detached_payload")
+
+		resp = writeProfileRole(t, b, storage, TokenProfileAzure, map[string]any{"audience_keys": map[string]any{"service-a": "test-key"}})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "audience_keys")
+
+		// Azure AD only verifies RS256 assertions
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "key/pss-key",
+			Storage:   storage,
+			Data:      map[string]any{"algorithm": AlgorithmPS256},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		resp = writeProfileRole(t, b, storage, TokenProfileAzure, map[string]any{"key": "pss-key"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "RS256")
+
+		// Azure AD discovers keys from an https issuer
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      map[string]any{"issuer": "http://vault.example.com"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError())
+		resp = writeProfileRole(t, b, storage, TokenProfileAzure, nil)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "https")
+	})
+}
+
+func TestTokenExchange_AWSProfile(t *testing.T) {
+	t.Run("claims", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"token_profile":      TokenProfileAWS,
+			"subject_template":   `{"email": "{{email}}"}`,
+			"include_vault_meta": true,
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "agent-123", claims["sub"])
+		require.Equal(t, "user-123", claims["original_sub"])
+		require.Equal(t, "sts.amazonaws.com", claims["aud"])
+		require.Equal(t, "urn:documents:read", claims["scope"])
+
+		// Object-valued claims are dropped
+		require.NotContains(t, claims, "act")
+		require.NotContains(t, claims, "subject_claims")
+		require.NotContains(t, claims, "vault_meta")
+	})
+
+	t.Run("custom audience", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"token_profile":          TokenProfileAWS,
+			"token_profile_audience": "vault-agents",
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "vault-agents", claims["aud"])
+	})
+
+	t.Run("trust policy", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, map[string]any{"token_profile": TokenProfileAWS})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+		require.NoError(t, err)
+		policy := resp.Data["aws_trust_policy"].(map[string]any)
+		statement := policy["Statement"].([]map[string]any)[0]
+		require.Equal(t, "sts:AssumeRoleWithWebIdentity", statement["Action"])
+		require.Equal(t, map[string]any{"Federated": "arn:aws:iam::<ACCOUNT_ID>:oidc-provider/vault.example.com"}, statement["Principal"])
+		require.Equal(t, map[string]any{
+			"StringEquals": map[string]any{
+				"vault.example.com:aud": "sts.amazonaws.com",
+				"vault.example.com:sub": "<ACTOR_SUBJECT>",
+			},
+		}, statement["Condition"])
+	})
+
+	t.Run("invalid roles", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		// AWS STS does not verify RSA-PSS signatures
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "key/pss-key",
+			Storage:   storage,
+			Data:      map[string]any{"algorithm": AlgorithmPS256},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		resp = writeProfileRole(t, b, storage, TokenProfileAWS, map[string]any{"key": "pss-key"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "RS256, RS384, RS512")
+
+		resp = writeProfileRole(t, b, storage, TokenProfileDefault, map[string]any{"token_profile_audience": "sts.amazonaws.com"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "requires a token_profile")
+	})
+}
+
+// stubIssuerLookup resolves every issuer host to ip for the test
+func stubIssuerLookup(t *testing.T, ip string, err error) {
+	lookupIssuerHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+	}
+	t.Cleanup(func() { lookupIssuerHost = net.DefaultResolver.LookupIPAddr })
+}
+
+func TestTokenExchange_GCPProfile(t *testing.T) {
+	const audience = "https://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/agents/providers/vault"
+
+	t.Run("claims", func(t *testing.T) {
+		stubIssuerLookup(t, "203.0.113.10", nil)
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"token_profile":          TokenProfileGCP,
+			"token_profile_audience": audience,
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "agent-123", claims["sub"])
+		require.Equal(t, "user-123", claims["original_sub"])
+		require.Equal(t, audience, claims["aud"])
+		require.Contains(t, claims, "act")
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, "assertion.sub", resp.Data["gcp_attribute_mapping"].(map[string]string)["google.subject"])
+	})
+
+	t.Run("subject length", func(t *testing.T) {
+		stubIssuerLookup(t, "203.0.113.10", nil)
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"token_profile":          TokenProfileGCP,
+			"token_profile_audience": audience,
+			"actor_template":         `{"act": {"sub": "` + strings.Repeat("a", 128) + `"}}`,
+		})
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeServerError, false)
+		require.Contains(t, resp.Error().Error(), "127 bytes")
+	})
+
+	t.Run("invalid roles", func(t *testing.T) {
+		stubIssuerLookup(t, "203.0.113.10", nil)
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, nil)
+
+		// The pool provider's audience has no default
+		resp := writeProfileRole(t, b, storage, TokenProfileGCP, nil)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "token_profile_audience")
+
+		// GCP must be able to reach the issuer
+		stubIssuerLookup(t, "10.0.0.8", nil)
+		resp = writeProfileRole(t, b, storage, TokenProfileGCP, map[string]any{"token_profile_audience": audience})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "non-public address 10.0.0.8")
+
+		stubIssuerLookup(t, "", errors.New("no such host"))
+		resp = writeProfileRole(t, b, storage, TokenProfileGCP, map[string]any{"token_profile_audience": audience})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "no such host")
+	})
+}
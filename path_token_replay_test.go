@@ -0,0 +1,102 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// exchangeSubjectToken exchanges a pre-signed subject token against test-role
+func exchangeSubjectToken(t *testing.T, b *Backend, storage logical.Storage, subjectToken string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data:      map[string]any{"subject_token": subjectToken},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestTokenExchange_SingleUseSubjectToken tests that a subject token can only
+// be exchanged once when single_use_subject_token is set
+func TestTokenExchange_SingleUseSubjectToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+	}{
+		{name: "with jti", claims: map[string]any{"jti": "subject-jti-1"}},
+		{name: "without jti", claims: map[string]any{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+				"single_use_subject_token": true,
+			})
+
+			claims := defaultSubjectClaims()
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			subjectToken := generateTestJWT(t, privateKey, kid, claims)
+
+			resp := exchangeSubjectToken(t, b, storage, subjectToken)
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			resp = exchangeSubjectToken(t, b, storage, subjectToken)
+			requireExchangeError(t, resp, ErrCodeReplayedSubjectToken, false)
+
+			// A different subject token is unaffected
+			claims["jti"] = "subject-jti-2"
+			claims["iat"] = time.Now().Unix() + 1
+			resp = exchangeSubjectToken(t, b, storage, generateTestJWT(t, privateKey, kid, claims))
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		})
+	}
+}
+
+// TestTokenExchange_ReusableSubjectToken tests that subject tokens may be
+// reused when single_use_subject_token is not set
+func TestTokenExchange_ReusableSubjectToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	for i := 0; i < 2; i++ {
+		resp := exchangeSubjectToken(t, b, storage, subjectToken)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	}
+}
+
+// TestTidyReplayEntries tests that expired replay records are removed
+func TestTidyReplayEntries(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	fresh, err := b.consumeSubjectToken(ctx, storage, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, fresh)
+	fresh, err = b.consumeSubjectToken(ctx, storage, "active", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	// An expired record does not block reuse
+	fresh, err = b.consumeSubjectToken(ctx, storage, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	deleted, err := b.tidyReplayEntries(ctx, storage, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	remaining, err := storage.List(ctx, replayStoragePrefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{"active"}, remaining)
+}
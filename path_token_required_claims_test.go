@@ -0,0 +1,49 @@
+package tokenexchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMissingSubjectClaims tests presence and non-empty checks of required claims
+func TestMissingSubjectClaims(t *testing.T) {
+	claims := map[string]any{
+		"email":  "user@example.com",
+		"tid":    "",
+		"groups": []any{},
+		"org":    map[string]any{"team": "payments"},
+		"nil":    nil,
+	}
+
+	require.Empty(t, missingSubjectClaims(claims, []string{"email", "tid", "groups", "nil"}, false))
+	require.Equal(t, []string{"tenant"}, missingSubjectClaims(claims, []string{"email", "tenant"}, false))
+	require.Equal(t, []string{"tid", "groups", "nil"}, missingSubjectClaims(claims, []string{"email", "tid", "groups", "nil", "/org/team"}, true))
+	require.Equal(t, []string{"/org/unit"}, missingSubjectClaims(claims, []string{"/org/team", "/org/unit"}, false))
+}
+
+// TestTokenExchange_RequiredSubjectClaims tests that subject tokens missing
+// required claims are rejected with the claims they lack
+func TestTokenExchange_RequiredSubjectClaims(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"required_subject_claims":  "email,tid",
+		"require_non_empty_claims": true,
+	})
+
+	claims := defaultSubjectClaims()
+	claims["tid"] = "tenant-1"
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	delete(claims, "tid")
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "missing required claims: tid")
+
+	claims["tid"] = ""
+	claims["email"] = ""
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "missing required claims: email, tid")
+}
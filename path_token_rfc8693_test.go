@@ -2,6 +2,7 @@ package tokenexchange
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -361,3 +362,27 @@ func TestRFC8693_ScopeFormat(t *testing.T) {
 		})
 	}
 }
+
+// TestTokenExchange_DetachedPayload tests returning the token as a detached JWS
+func TestTokenExchange_DetachedPayload(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"detached_payload": true,
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	detached := resp.Data["token"].(string)
+	payload := resp.Data["payload"].(string)
+	require.NotEmpty(t, payload)
+
+	parts := strings.Split(detached, ".")
+	require.Len(t, parts, 3)
+	require.Empty(t, parts[1], "Detached JWS must have an empty payload segment")
+
+	// Reattaching the payload yields a verifiable token
+	claims := parseIssuedToken(t, b, storage, parts[0]+"."+payload+"."+parts[2])
+	require.Equal(t, "user-123", claims["sub"])
+}
@@ -0,0 +1,202 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/hashicorp/vault/sdk/logical"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/require"
+)
+
+// testSAMLIssuer is the entity ID of the test SAML IdP
+const testSAMLIssuer = "https://idp.example.com/saml"
+
+// newTestSAMLIdP returns a signing key store and its certificate as PEM
+func newTestSAMLIdP(t *testing.T) (dsig.X509KeyStore, string) {
+	keyStore := dsig.RandomKeyStoreForTest()
+	_, der, err := keyStore.GetKeyPair()
+	require.NoError(t, err)
+	return keyStore, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// buildTestAssertion returns a signed, base64url-encoded SAML assertion for
+// nameID that expires at notOnOrAfter. tamper edits the assertion after it is signed.
+func buildTestAssertion(t *testing.T, keyStore dsig.X509KeyStore, issuer, nameID string, notOnOrAfter time.Time, tamper func(*etree.Element)) string {
+	now := time.Now().UTC()
+	assertion := etree.NewElement("saml:Assertion")
+	assertion.CreateAttr("xmlns:saml", samlAssertionNamespace)
+	assertion.CreateAttr("ID", "_assertion-1")
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	assertion.CreateElement("saml:Issuer").SetText(issuer)
+
+	subject := assertion.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText(nameID)
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", notOnOrAfter.UTC().Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText("https://vault.example.com")
+
+	authn := assertion.CreateElement("saml:AuthnStatement")
+	authn.CreateAttr("AuthnInstant", now.Add(-5*time.Minute).Format(time.RFC3339))
+
+	attributes := assertion.CreateElement("saml:AttributeStatement")
+	addAttribute := func(name, friendlyName string, values ...string) {
+		attribute := attributes.CreateElement("saml:Attribute")
+		attribute.CreateAttr("Name", name)
+		if friendlyName != "" {
+			attribute.CreateAttr("FriendlyName", friendlyName)
+		}
+		for _, value := range values {
+			attribute.CreateElement("saml:AttributeValue").SetText(value)
+		}
+	}
+	addAttribute("urn:oid:0.9.2342.19200300.100.1.3", "email", "user@example.com")
+	addAttribute("department", "", "engineering")
+	addAttribute("groups", "", "admins", "developers")
+	addAttribute("sub", "", "attacker")
+
+	signed, err := dsig.NewDefaultSigningContext(keyStore).SignEnveloped(assertion)
+	require.NoError(t, err)
+	if tamper != nil {
+		tamper(signed)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(signed)
+	raw, err := doc.WriteToBytes()
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// exchangeSAMLAssertion exchanges an assertion with the test role
+func exchangeSAMLAssertion(t *testing.T, b *Backend, storage logical.Storage, assertion string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data: map[string]any{
+			"subject_token":      assertion,
+			"subject_token_type": tokenTypeSAML2,
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp
+}
+
+// TestTokenExchange_SAML tests exchanging signed SAML 2.0 assertions
+func TestTokenExchange_SAML(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, map[string]any{
+		"subject_template": `{"email": "{{identity.subject.email}}", "department": "{{identity.subject.department}}", "groups": {{identity.subject.groups}}}`,
+		"bound_audiences":  "https://vault.example.com",
+	})
+	keyStore, certPEM := newTestSAMLIdP(t)
+
+	writeConfig := func(data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("requires a configured IdP", func(t *testing.T) {
+		assertion := buildTestAssertion(t, keyStore, testSAMLIssuer, "user-123", time.Now().Add(time.Hour), nil)
+		resp := exchangeSAMLAssertion(t, b, storage, assertion)
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	})
+
+	resp := writeConfig(map[string]any{"saml_idp_certificates": certPEM})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	t.Run("valid assertion", func(t *testing.T) {
+		assertion := buildTestAssertion(t, keyStore, testSAMLIssuer, "user-123", time.Now().Add(time.Hour), nil)
+		resp := exchangeSAMLAssertion(t, b, storage, assertion)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "user-123", claims["sub"])
+		require.Equal(t, map[string]any{
+			"email":      "user@example.com",
+			"department": "engineering",
+			"groups":     []any{"admins", "developers"},
+		}, claims["subject_claims"])
+	})
+
+	t.Run("tampered assertion", func(t *testing.T) {
+		assertion := buildTestAssertion(t, keyStore, testSAMLIssuer, "user-123", time.Now().Add(time.Hour), func(el *etree.Element) {
+			el.FindElement("./Subject/NameID").SetText("admin")
+		})
+		resp := exchangeSAMLAssertion(t, b, storage, assertion)
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "signature")
+	})
+
+	t.Run("untrusted signer", func(t *testing.T) {
+		otherKeyStore, _ := newTestSAMLIdP(t)
+		assertion := buildTestAssertion(t, otherKeyStore, testSAMLIssuer, "user-123", time.Now().Add(time.Hour), nil)
+		resp := exchangeSAMLAssertion(t, b, storage, assertion)
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	})
+
+	t.Run("expired assertion", func(t *testing.T) {
+		assertion := buildTestAssertion(t, keyStore, testSAMLIssuer, "user-123", time.Now().Add(-time.Minute), nil)
+		resp := exchangeSAMLAssertion(t, b, storage, assertion)
+		requireExchangeError(t, resp, ErrCodeExpiredSubjectToken, false)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		resp := exchangeSAMLAssertion(t, b, storage, strings.Repeat("a", maxSubjectTokenSize+1))
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "byte limit")
+	})
+
+	t.Run("not an assertion", func(t *testing.T) {
+		resp := exchangeSAMLAssertion(t, b, storage, base64.RawURLEncoding.EncodeToString([]byte("<Response/>")))
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	})
+
+	t.Run("metadata entity ID must issue the assertion", func(t *testing.T) {
+		der, _ := pem.Decode([]byte(certPEM))
+		metadata := fmt.Sprintf(`<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <KeyDescriptor use="signing">
+      <KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#">
+        <X509Data><X509Certificate>%s</X509Certificate></X509Data>
+      </KeyInfo>
+    </KeyDescriptor>
+  </IDPSSODescriptor>
+</EntityDescriptor>`, testSAMLIssuer, base64.StdEncoding.EncodeToString(der.Bytes))
+
+		resp := writeConfig(map[string]any{"saml_idp_metadata": metadata, "saml_idp_certificates": ""})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+		assertion := buildTestAssertion(t, keyStore, testSAMLIssuer, "user-123", time.Now().Add(time.Hour), nil)
+		resp = exchangeSAMLAssertion(t, b, storage, assertion)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		assertion = buildTestAssertion(t, keyStore, "https://other.example.com", "user-123", time.Now().Add(time.Hour), nil)
+		resp = exchangeSAMLAssertion(t, b, storage, assertion)
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "entity ID")
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		resp := writeConfig(map[string]any{"saml_idp_metadata": strings.Repeat("<", 3)})
+		require.True(t, resp.IsError())
+	})
+}
@@ -0,0 +1,78 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExchange_SubjectClaimSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		roleData map[string]any
+		sub      string
+	}{
+		{name: "default", roleData: nil, sub: "user-123"},
+		{name: "subject", roleData: map[string]any{"subject_claim_source": SubjectClaimSourceSubject}, sub: "user-123"},
+		{name: "email", roleData: map[string]any{"subject_claim_source": SubjectClaimSourceEmail}, sub: "user@example.com"},
+		{name: "entity", roleData: map[string]any{"subject_claim_source": SubjectClaimSourceEntity}, sub: "test-entity"},
+		{
+			name: "template",
+			roleData: map[string]any{
+				"subject_claim_source":   SubjectClaimSourceTemplate,
+				"subject_claim_template": "{{identity.subject.email}} via {{identity.entity.id}}",
+			},
+			sub: "user@example.com via test-entity",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			privateKey, kid := setupTestExchange(t, b, storage, tt.roleData)
+
+			resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+			require.Equal(t, tt.sub, claims["sub"])
+			require.Equal(t, map[string]any{"sub": "agent-123", "iss": "https://vault.example.com"}, claims["act"])
+		})
+	}
+}
+
+func TestTokenExchange_SubjectClaimMissing(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"subject_claim_source": SubjectClaimSourceEmail})
+
+	claims := defaultSubjectClaims()
+	delete(claims, "email")
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "no email")
+}
+
+func TestRoleWrite_SubjectClaimSource(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, SubjectClaimSourceSubject, resp.Data["subject_claim_source"])
+
+	for _, tt := range []struct {
+		data  map[string]any
+		error string
+	}{
+		{data: map[string]any{"subject_claim_source": "name"}, error: "subject_claim_source must be"},
+		{data: map[string]any{"subject_claim_source": SubjectClaimSourceTemplate}, error: "requires subject_claim_template"},
+		{data: map[string]any{"subject_claim_template": "{{identity.entity.name}}"}, error: "requires subject_claim_source"},
+		{data: map[string]any{"subject_claim_source": SubjectClaimSourceTemplate, "subject_claim_template": "{{#identity}}"}, error: "failed to parse"},
+	} {
+		resp := writeProfileRole(t, b, storage, TokenProfileDefault, tt.data)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), tt.error)
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"fmt"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
@@ -97,6 +100,101 @@ func createTestKey(t *testing.T, b *Backend, storage logical.Storage, keyName st
 	return keyID
 }
 
+// setupTestExchange configures the plugin with a mock subject JWKS server, a
+// "test-key" signing key and a "test-role" role. roleData overrides the default
+// role fields. Returns the private key and kid used to sign subject tokens.
+func setupTestExchange(t *testing.T, b *Backend, storage logical.Storage, roleData map[string]any) (*rsa.PrivateKey, string) {
+	privateKey, _ := generateTestKeyPair(t)
+	createTestKey(t, b, storage, "test-key")
+
+	testKID := "test-key-1"
+	jwksServer := createMockJWKSServer(t, &privateKey.PublicKey, testKID)
+	t.Cleanup(jwksServer.Close)
+
+	configReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]any{
+			"issuer":           "https://vault.example.com",
+			"subject_jwks_uri": jwksServer.URL,
+			"default_ttl":      "1h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), configReq)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	data := map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{"act": {"sub": "agent-123"}}`,
+		"subject_template": `{}`,
+		"context":          []string{"urn:documents:read"},
+	}
+	for k, v := range roleData {
+		data[k] = v
+	}
+
+	roleReq := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data:      data,
+	}
+	resp, err = b.HandleRequest(context.Background(), roleReq)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+	return privateKey, testKID
+}
+
+// exchangeTestToken signs a subject token with the given claims and exchanges
+// it using "test-role"
+func exchangeTestToken(t *testing.T, b *Backend, storage logical.Storage, privateKey *rsa.PrivateKey, kid string, claims map[string]any) *logical.Response {
+	subjectToken := generateTestJWT(t, privateKey, kid, claims)
+
+	tokenReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data: map[string]any{
+			"subject_token": subjectToken,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), tokenReq)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	return resp
+}
+
+// parseIssuedToken verifies an issued token against the JWKS endpoint and returns its claims
+func parseIssuedToken(t *testing.T, b *Backend, storage logical.Storage, token string) map[string]any {
+	parsedToken, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.RS256, jose.RS384, jose.RS512})
+	require.NoError(t, err)
+
+	publicKey := getPublicKeyFromJWKS(t, b, storage, parsedToken.Headers[0].KeyID)
+
+	claims := make(map[string]any)
+	require.NoError(t, parsedToken.Claims(publicKey, &claims))
+
+	return claims
+}
+
+// defaultSubjectClaims returns valid subject token claims for tests
+func defaultSubjectClaims() map[string]any {
+	return map[string]any{
+		"sub":   "user-123",
+		"email": "user@example.com",
+		"iss":   "https://idp.example.com",
+		"aud":   []string{"service-a"},
+		"exp":   time.Now().Add(1 * time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+}
+
 // getPublicKeyFromJWKS retrieves the public key for a given kid from Vault's JWKS endpoint
 // This simulates how real consumers would verify tokens
 func getPublicKeyFromJWKS(t *testing.T, b *Backend, storage logical.Storage, kid string) *rsa.PublicKey {
@@ -1163,3 +1261,173 @@ func TestProcessTemplate_StringClaims(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "John Doe", result["name"])
 }
+
+// TestTokenExchange_AuditMetadata tests that non-sensitive metadata describing
+// the issued token is returned alongside it
+func TestTokenExchange_AuditMetadata(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"context": []string{"urn:documents:read", "urn:documents:write"},
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+
+	require.NotEmpty(t, resp.Data["jti"])
+	require.Equal(t, claims["jti"], resp.Data["jti"], "jti should match the token")
+	require.Equal(t, "test-key-v1", resp.Data["key_id"])
+	require.Equal(t, int64(claims["exp"].(float64)), resp.Data["expires_at"])
+	require.Equal(t, int64(claims["iat"].(float64)), resp.Data["issued_at"])
+	require.Equal(t, "urn:documents:read urn:documents:write", resp.Data["scope"])
+	require.Equal(t, "test-entity", resp.Data["actor_entity_id"])
+
+	// Each token gets a unique jti
+	resp2 := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.NotEqual(t, resp.Data["jti"], resp2.Data["jti"])
+}
+
+// TestTokenExchange_RequiredEntityMetadata tests that entities missing required
+// metadata keys cannot exchange
+func TestTokenExchange_RequiredEntityMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		missing  string
+	}{
+		{name: "present", required: "department,team"},
+		{name: "missing", required: "department,owner,cost_center", missing: "owner, cost_center"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+				"required_entity_metadata": tt.required,
+			})
+
+			resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			if tt.missing == "" {
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				return
+			}
+
+			requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+			require.Contains(t, resp.Error().Error(), tt.missing)
+		})
+	}
+}
+
+// TestTokenExchange_BoundEntityAndGroupIDs tests that only bound entities or
+// members of bound groups can exchange with a role
+func TestTokenExchange_BoundEntityAndGroupIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    map[string]any
+		allowed bool
+	}{
+		{name: "unbound", role: map[string]any{}, allowed: true},
+		{name: "entity listed", role: map[string]any{"bound_entity_ids": "other-entity,test-entity"}, allowed: true},
+		{name: "entity not listed", role: map[string]any{"bound_entity_ids": "other-entity"}},
+		{name: "group member", role: map[string]any{"bound_group_ids": "group-agents"}, allowed: true},
+		{name: "not a group member", role: map[string]any{"bound_group_ids": "group-admins"}},
+		{name: "entity or group", role: map[string]any{"bound_entity_ids": "other-entity", "bound_group_ids": "group-agents"}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			b.System().(*logical.StaticSystemView).GroupsVal = []*logical.Group{
+				{ID: "group-agents", Name: "agents"},
+			}
+			privateKey, kid := setupTestExchange(t, b, storage, tt.role)
+
+			resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			if tt.allowed {
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				return
+			}
+
+			requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+			require.Contains(t, resp.Error().Error(), "not permitted")
+		})
+	}
+}
+
+// TestTokenExchange_AliasAndGroupTemplates tests entity alias metadata and
+// group names in actor templates
+func TestTokenExchange_AliasAndGroupTemplates(t *testing.T) {
+	b, storage := getTestBackend(t)
+	system := b.System().(*logical.StaticSystemView)
+	system.EntityVal.Aliases = []*logical.Alias{
+		{
+			MountAccessor: "auth_kubernetes_1234",
+			MountType:     "kubernetes",
+			Name:          "5f2f6a3c-uid",
+			Metadata:      map[string]string{"service_account_name": "billing-agent", "service_account_namespace": "payments"},
+		},
+	}
+	system.GroupsVal = []*logical.Group{
+		{ID: "group-agents", Name: "agents"},
+		{ID: "group-billing", Name: "billing"},
+	}
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"actor_template": `{"act": {"sub": "{{identity.entity.name}}"}, "service_account": "{{identity.entity.aliases.auth_kubernetes_1234.metadata.service_account_namespace}}/{{identity.entity.aliases.auth_kubernetes_1234.metadata.service_account_name}}", "groups": {{identity.groups.names}}}`,
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "test-entity-name", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "payments/billing-agent", claims["service_account"])
+	require.Equal(t, []any{"agents", "billing"}, claims["groups"])
+}
+
+// TestTokenExchange_VaultMeta tests the vault_meta claim
+func TestTokenExchange_VaultMeta(t *testing.T) {
+	for _, include := range []bool{true, false} {
+		t.Run(fmt.Sprintf("include=%t", include), func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			b.System().(*logical.StaticSystemView).EntityVal.Aliases = []*logical.Alias{
+				{MountType: "kubernetes", MountAccessor: "auth_kubernetes_1234", Name: "agent"},
+			}
+			privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+				"include_vault_meta": include,
+			})
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				ID:            "request-1",
+				MountAccessor: "identity-delegation_5678",
+				Operation:     logical.UpdateOperation,
+				Path:          "token/test-role",
+				Storage:       storage,
+				EntityID:      "test-entity",
+				Data: map[string]any{
+					"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+				},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+			if !include {
+				require.NotContains(t, claims, "vault_meta")
+				return
+			}
+
+			requestIDHash := sha256.Sum256([]byte("request-1"))
+			require.Equal(t, map[string]any{
+				"mount_accessor":  "identity-delegation_5678",
+				"request_id_hash": hex.EncodeToString(requestIDHash[:]),
+				"entity_id":       "test-entity",
+				"auth_mounts": []any{
+					map[string]any{"mount_type": "kubernetes", "mount_accessor": "auth_kubernetes_1234"},
+				},
+			}, claims["vault_meta"])
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// createMockSTSServer creates a test RFC 8693 STS that records the subject
+// token it receives and returns a fixed downstream token
+func createMockSTSServer(t *testing.T, subjectToken *string, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		w.Header().Set("Content-Type", "application/json")
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "vault" || clientSecret != "sts-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error":             "invalid_client",
+				"error_description": "bad client credentials",
+			})
+			return
+		}
+
+		require.Equal(t, tokenExchangeGrantType, r.PostForm.Get("grant_type"))
+		require.Equal(t, tokenTypeJWT, r.PostForm.Get("subject_token_type"))
+		require.Equal(t, "https://api.example.com", r.PostForm.Get("audience"))
+		require.Equal(t, "read write", r.PostForm.Get("scope"))
+
+		mu.Lock()
+		*subjectToken = r.PostForm.Get("subject_token")
+		mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "downstream-token",
+			"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+			"token_type":        "Bearer",
+			"expires_in":        300,
+		})
+	}))
+}
+
+// TestTokenExchange_UpstreamSTS tests that the delegated token is exchanged at
+// the upstream STS and the downstream token is returned
+func TestTokenExchange_UpstreamSTS(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	var mu sync.Mutex
+	var received string
+	stsServer := createMockSTSServer(t, &received, &mu)
+	defer stsServer.Close()
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"upstream_sts_url":       stsServer.URL,
+		"upstream_client_id":     "vault",
+		"upstream_client_secret": "sts-secret",
+		"upstream_audience":      "https://api.example.com",
+		"upstream_scope":         "read,write",
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	require.Equal(t, "downstream-token", resp.Data["token"])
+	require.Equal(t, "Bearer", resp.Data["token_type"])
+	require.Equal(t, "urn:ietf:params:oauth:token-type:access_token", resp.Data["issued_token_type"])
+	require.NotEmpty(t, resp.Data["jti"])
+
+	// The STS received the delegated token signed by this mount
+	mu.Lock()
+	defer mu.Unlock()
+	claims := parseIssuedToken(t, b, storage, received)
+	require.Equal(t, "user-123", claims["sub"])
+	require.Equal(t, resp.Data["jti"], claims["jti"])
+}
+
+// TestTokenExchange_UpstreamSTSError tests that upstream errors are returned
+func TestTokenExchange_UpstreamSTSError(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	var mu sync.Mutex
+	var received string
+	stsServer := createMockSTSServer(t, &received, &mu)
+	defer stsServer.Close()
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"upstream_sts_url":       stsServer.URL,
+		"upstream_client_id":     "vault",
+		"upstream_client_secret": "wrong",
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "invalid_client")
+}
+
+// TestTokenExchange_UpstreamSTSRedirect tests that a redirect from the STS is
+// not followed with the subject token
+func TestTokenExchange_UpstreamSTSRedirect(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	var mu sync.Mutex
+	var received string
+	target := createMockSTSServer(t, &received, &mu)
+	defer target.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirector.Close()
+
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"upstream_sts_url":       redirector.URL,
+		"upstream_client_id":     "vault",
+		"upstream_client_secret": "sts-secret",
+	})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "status 307")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Empty(t, received)
+}
+
+// TestRole_UpstreamSTS tests upstream settings are validated and the client
+// secret is never returned
+func TestRole_UpstreamSTS(t *testing.T) {
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, map[string]any{
+		"upstream_sts_url":       "https://sts.example.com/token",
+		"upstream_client_id":     "vault",
+		"upstream_client_secret": "sts-secret",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "https://sts.example.com/token", resp.Data["upstream_sts_url"])
+	require.Equal(t, "vault", resp.Data["upstream_client_id"])
+	require.NotContains(t, resp.Data, "upstream_client_secret")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/test-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{}`,
+			"subject_template": `{}`,
+			"context":          "urn:documents:read",
+			"upstream_sts_url": "https://sts.example.com/token",
+			"detached_payload": true,
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "detached_payload cannot be combined")
+}
@@ -0,0 +1,184 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// entitySystemView is a system view that looks entities up by ID
+type entitySystemView struct {
+	*logical.StaticSystemView
+	entities map[string]*logical.Entity
+}
+
+// EntityInfo returns the entity with the ID, or nil
+func (v *entitySystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	return v.entities[entityID], nil
+}
+
+// createMockVaultServer creates a test Vault API that answers token
+// lookup-self for the tokens in tokens, keyed by token, with their entity ID
+func createMockVaultServer(t *testing.T, tokens map[string]string) *httptest.Server {
+	return httptest.NewServer(mockVaultHandler(t, tokens))
+}
+
+// mockVaultHandler answers token lookup-self for the tokens in tokens
+func mockVaultHandler(t *testing.T, tokens map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/auth/token/lookup-self", r.URL.Path)
+
+		entityID, ok := tokens[r.Header.Get("X-Vault-Token")]
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+
+		now := time.Now().UTC()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"accessor":    "accessor-" + entityID,
+				"entity_id":   entityID,
+				"issue_time":  now.Add(-time.Minute).Format(time.RFC3339Nano),
+				"expire_time": now.Add(time.Hour).Format(time.RFC3339Nano),
+				"policies":    []string{"default"},
+			},
+		})
+	})
+}
+
+// TestTokenExchange_VaultToken tests exchanging a user's Vault token
+func TestTokenExchange_VaultToken(t *testing.T) {
+	system := &entitySystemView{
+		StaticSystemView: &logical.StaticSystemView{},
+		entities: map[string]*logical.Entity{
+			"test-entity": {ID: "test-entity", Name: "agent"},
+			"user-entity": {
+				ID:       "user-entity",
+				Name:     "alice",
+				Metadata: map[string]string{"department": "finance"},
+				Aliases: []*logical.Alias{
+					{MountAccessor: "auth_userpass_1234", MountType: "userpass", Name: "alice"},
+				},
+			},
+			"disabled-entity": {ID: "disabled-entity", Name: "bob", Disabled: true},
+		},
+	}
+	config := &logical.BackendConfig{
+		Logger:      hclog.NewNullLogger(),
+		System:      system,
+		StorageView: &logical.InmemStorage{},
+	}
+	raw, err := Factory(context.Background(), config)
+	require.NoError(t, err)
+	b, storage := raw.(*Backend), config.StorageView
+
+	vaultServer := createMockVaultServer(t, map[string]string{
+		"hvs.user":     "user-entity",
+		"hvs.disabled": "disabled-entity",
+		"hvs.orphan":   "",
+	})
+	defer vaultServer.Close()
+
+	setupTestExchange(t, b, storage, map[string]any{
+		"subject_template": `{"department": "{{identity.subject.metadata.department}}", "login": "{{identity.subject.aliases.auth_userpass_1234.name}}"}`,
+	})
+
+	exchange := func(token string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token":      token,
+				"subject_token_type": tokenTypeVaultToken,
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	t.Run("requires api_addr", func(t *testing.T) {
+		resp := exchange("hvs.user")
+		requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+		require.Contains(t, resp.Error().Error(), "api_addr")
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"api_addr": vaultServer.URL},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	t.Run("valid token", func(t *testing.T) {
+		resp := exchange("hvs.user")
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, "user-entity", claims["sub"])
+		require.Equal(t, map[string]any{"department": "finance", "login": "alice"}, claims["subject_claims"])
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		resp := exchange("hvs.revoked")
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	})
+
+	t.Run("token without entity", func(t *testing.T) {
+		resp := exchange("hvs.orphan")
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "no entity")
+	})
+
+	t.Run("disabled entity", func(t *testing.T) {
+		resp := exchange("hvs.disabled")
+		requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+		require.Contains(t, resp.Error().Error(), "disabled")
+	})
+	t.Run("private CA", func(t *testing.T) {
+		tlsServer := httptest.NewTLSServer(mockVaultHandler(t, map[string]string{"hvs.user": "user-entity"}))
+		defer tlsServer.Close()
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": tlsServer.URL})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+		resp = exchange("hvs.user")
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"api_ca_pem": certificatePEM(tlsServer.Certificate())})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+		resp = exchange("hvs.user")
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		var forwarded string
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Header.Get("X-Vault-Token")
+		}))
+		defer target.Close()
+		redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL+r.URL.Path, http.StatusTemporaryRedirect)
+		}))
+		defer redirector.Close()
+
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"api_addr": redirector.URL, "api_ca_pem": ""})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+		resp = exchange("hvs.user")
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+		require.Empty(t, forwarded, "the token must not follow redirects")
+	})
+}
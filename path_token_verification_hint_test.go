@@ -0,0 +1,95 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// exchangeOnMount exchanges a subject token through a request that carries
+// the mount path, as Vault sets it
+func exchangeOnMount(t *testing.T, b *Backend, storage logical.Storage, privateKey *rsa.PrivateKey, kid string) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "token/test-role",
+		Storage:    storage,
+		EntityID:   "test-entity",
+		MountPoint: "identity-delegation/",
+		Data: map[string]any{
+			"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	return resp
+}
+
+// TestTokenExchange_VerificationHint tests pointing consumers at the mount's JWKS
+func TestTokenExchange_VerificationHint(t *testing.T) {
+	const wantURL = "https://vault.example.com:8200/v1/identity-delegation/jwks"
+
+	t.Run("jku header", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"api_addr": "https://vault.example.com:8200/"}))
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"verification_hint": "jku"})
+
+		resp := exchangeOnMount(t, b, storage, privateKey, kid)
+		parsed, err := jwt.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		require.Equal(t, wantURL, parsed.Headers[0].ExtraHeaders["jku"])
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.NotContains(t, claims, "verification_url")
+	})
+
+	t.Run("verification_url claim", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"api_addr": "https://vault.example.com:8200"}))
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+			"verification_hint": "verification_url",
+			// Templates cannot choose where consumers fetch keys from
+			"actor_template": `{"act": {"sub": "agent-123"}, "verification_url": "https://attacker.example.com/jwks"}`,
+		})
+
+		resp := exchangeOnMount(t, b, storage, privateKey, kid)
+		parsed, err := jwt.ParseSigned(resp.Data["token"].(string), []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		require.NotContains(t, parsed.Headers[0].ExtraHeaders, jose.HeaderKey("jku"))
+
+		claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+		require.Equal(t, wantURL, claims["verification_url"])
+	})
+
+	t.Run("requires api_addr", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		createTestKey(t, b, storage, "test-key")
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": "https://idp.example.com/jwks"}))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+			Data: map[string]any{
+				"ttl":               "1h",
+				"key":               "test-key",
+				"actor_template":    "{}",
+				"subject_template":  "{}",
+				"context":           "urn:documents:read",
+				"verification_hint": "jku",
+			},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "verification_hint requires api_addr")
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"api_addr": "vault.example.com"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "api_addr must be an http or https URL")
+	})
+}
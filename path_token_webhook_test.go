@@ -0,0 +1,127 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// createMockWebhook returns a webhook that responds with status and body and
+// records the last request it received
+func createMockWebhook(t *testing.T, status int, body string, received *authorizationWebhookRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer webhook-token", r.Header.Get("Authorization"))
+		if received != nil {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(received))
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTokenExchange_AuthorizationWebhook(t *testing.T) {
+	t.Run("request", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+		received := &authorizationWebhookRequest{}
+		webhook := createMockWebhook(t, http.StatusOK, `{"allow": true}`, received)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_url": webhook.URL, "authorization_webhook_token": "webhook-token"}))
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		require.Equal(t, "test-role", received.Input.Role)
+		require.Equal(t, []string{"urn:documents:read"}, received.Input.Scopes)
+		require.Equal(t, "user-123", received.Input.Subject["sub"])
+		require.Equal(t, "test-entity", received.Input.Entity["id"])
+		require.Equal(t, map[string]any{"department": "engineering", "team": "platform", "email": "myemail@company.com"}, received.Input.Entity["metadata"])
+		require.Equal(t, "test-role", received.Input.Request["role"])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+		require.NoError(t, err)
+		require.Equal(t, true, resp.Data["authorization_webhook_token_configured"])
+		require.NotContains(t, resp.Data, "authorization_webhook_token")
+	})
+
+	t.Run("private CA", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+		webhook := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"allow": true}`))
+		}))
+		t.Cleanup(webhook.Close)
+
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_url": webhook.URL}))
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_ca_pem": certificatePEM(webhook.Certificate())}))
+		resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_ca_pem": "not a certificate"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "authorization_webhook_ca_pem contains no valid certificates")
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+		var forwarded string
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Header.Get("Authorization")
+			_, _ = w.Write([]byte(`{"allow": true}`))
+		}))
+		t.Cleanup(target.Close)
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL+"/allow", http.StatusTemporaryRedirect)
+		}))
+		t.Cleanup(webhook.Close)
+		require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_url": webhook.URL, "authorization_webhook_token": "webhook-token"}))
+
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+		require.Contains(t, resp.Error().Error(), "status 307")
+		require.Empty(t, forwarded, "the webhook token must not follow redirects")
+	})
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   string
+		error  string
+	}{
+		{name: "opa result", status: http.StatusOK, body: `{"result": true}`},
+		{name: "opa allow", status: http.StatusOK, body: `{"result": {"allow": true}}`},
+		{name: "deny", status: http.StatusOK, body: `{"allow": false, "reason": "outside business hours"}`, code: ErrCodeAccessDenied, error: "outside business hours"},
+		{name: "opa deny", status: http.StatusOK, body: `{"result": {"allow": false, "reason": "not on call"}}`, code: ErrCodeAccessDenied, error: "not on call"},
+		{name: "forbidden", status: http.StatusForbidden, body: ``, code: ErrCodeAccessDenied, error: "status 403"},
+		{name: "server error", status: http.StatusBadGateway, body: ``, code: ErrCodeTemporarilyUnavailable, error: "status 502"},
+		{name: "no decision", status: http.StatusOK, body: `{}`, code: ErrCodeServerError, error: "no allow or result"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			privateKey, kid := setupTestExchange(t, b, storage, nil)
+			webhook := createMockWebhook(t, tt.status, tt.body, nil)
+			require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"authorization_webhook_url": webhook.URL, "authorization_webhook_token": "webhook-token"}))
+
+			resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+			if tt.code == "" {
+				require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+				return
+			}
+			requireExchangeError(t, resp, tt.code, tt.code == ErrCodeTemporarilyUnavailable)
+			require.Contains(t, resp.Error().Error(), tt.error)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_ResponseWrapping tests returning exchange responses
+// wrapped in Vault wrapping tokens
+func TestTokenExchange_ResponseWrapping(t *testing.T) {
+	exchange := func(t *testing.T, b *Backend, storage logical.Storage, privateKey *rsa.PrivateKey, kid string, data map[string]any) *logical.Response {
+		data["subject_token"] = generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	t.Run("not wrapped by default", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+		resp := exchange(t, b, storage, privateKey, kid, map[string]any{})
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		require.Nil(t, resp.WrapInfo)
+	})
+
+	t.Run("caller requested", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+		resp := exchange(t, b, storage, privateKey, kid, map[string]any{"wrap_ttl": "2m"})
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		require.NotNil(t, resp.WrapInfo)
+		require.Equal(t, 2*time.Minute, resp.WrapInfo.TTL)
+		require.NotEmpty(t, resp.Data["token"])
+	})
+
+	t.Run("role forces wrapping", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"wrap_ttl": "5m"})
+
+		resp := exchange(t, b, storage, privateKey, kid, map[string]any{})
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		require.NotNil(t, resp.WrapInfo)
+		require.Equal(t, 5*time.Minute, resp.WrapInfo.TTL)
+
+		// Callers may shorten the role's wrap TTL but not extend it
+		resp = exchange(t, b, storage, privateKey, kid, map[string]any{"wrap_ttl": "1m"})
+		require.Equal(t, time.Minute, resp.WrapInfo.TTL)
+
+		resp = exchange(t, b, storage, privateKey, kid, map[string]any{"wrap_ttl": "1h"})
+		require.Equal(t, 5*time.Minute, resp.WrapInfo.TTL)
+	})
+
+	t.Run("errors are not wrapped", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, map[string]any{"wrap_ttl": "5m"})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": "not-a-token"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Nil(t, resp.WrapInfo)
+	})
+
+	t.Run("role read", func(t *testing.T) {
+		b, storage := getTestBackend(t)
+		setupTestExchange(t, b, storage, map[string]any{"wrap_ttl": "5m"})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "role/test-role",
+			Storage:   storage,
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 300, resp.Data["wrap_ttl"])
+	})
+}
@@ -0,0 +1,181 @@
+package tokenexchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// exchangeStage identifies a step of the token exchange pipeline. Stages run
+// in declaration order.
+type exchangeStage int
+
+const (
+	stageValidate exchangeStage = iota
+	stageAuthorize
+	stageEnrich
+	stageTemplate
+	stageSign
+	stageRecord
+
+	numExchangeStages
+)
+
+// String returns the stage name
+func (s exchangeStage) String() string {
+	switch s {
+	case stageValidate:
+		return "validate"
+	case stageAuthorize:
+		return "authorize"
+	case stageEnrich:
+		return "enrich"
+	case stageTemplate:
+		return "template"
+	case stageSign:
+		return "sign"
+	case stageRecord:
+		return "record"
+	default:
+		return "unknown"
+	}
+}
+
+// exchange carries the state of a single token exchange through the pipeline.
+// Each stage reads what earlier stages resolved and fills in its own fields.
+type exchange struct {
+	req      *logical.Request
+	data     *framework.FieldData
+	roleName string
+	start    time.Time
+
+	// simulation is the scenario being replayed; nil for real exchanges
+	simulation *simulationEntry
+
+	// Resolved by validate
+	subjectToken          string
+	subjectTokenType      string
+	subjectValidator      SubjectTokenValidator
+	requestedTokenType    string
+	subjectTokenHash      string
+	nonce                 string
+	certificateThumbprint string
+	dpopThumbprint        string
+	role                  *Role
+	fragments             []*TemplateFragment
+	config                *Config
+	notAfter              time.Time
+	notBefore             time.Time
+	wrapTTL               time.Duration
+	subjectClaims         map[string]any
+	requestedScope        string
+	delegationCtx         map[string]any
+	requestID             string
+	traceID               string
+
+	// refresh is the refresh handle being used; nil for exchanges
+	refresh *refreshEntry
+
+	// txn is the transaction ID, the trace ID or else the request ID
+	txn string
+
+	// scopes are the expanded scopes the token is issued with
+	scopes []string
+
+	// actorID is the actor_id of a caller without a Vault entity; empty for
+	// callers with one
+	actorID string
+
+	// Resolved by authorize
+	entity *logical.Entity
+
+	// usageDay is the day the exchange was counted in the role's usage, or
+	// empty if it was not counted
+	usageDay string
+
+	// Resolved by enrich
+	directoryAttrs map[string]any
+	groups         []*logical.Group
+	callerToken    *callerToken
+
+	// Resolved by template
+	actorClaims    map[string]any
+	templateClaims map[string]any
+	subject        string
+
+	// Resolved by sign
+	issued     *issuedToken
+	key        *Key
+	signingKey any
+	respData   map[string]any
+
+	// Outcome, available to record hooks
+	resp *logical.Response
+	err  error
+}
+
+// succeeded reports whether the exchange issued a token
+func (ex *exchange) succeeded() bool {
+	return ex.err == nil && ex.resp != nil && !ex.resp.IsError()
+}
+
+// exchangeHookFunc runs one step of a stage. Returning a response or an error
+// ends the exchange with that result; later hooks and stages are skipped,
+// except record hooks which always run.
+type exchangeHookFunc func(ctx context.Context, ex *exchange) (*logical.Response, error)
+
+// exchangeHook is a named step registered on a stage
+type exchangeHook struct {
+	name string
+	run  exchangeHookFunc
+}
+
+// exchangePipeline is the ordered set of hooks that performs a token exchange.
+// Hooks must be registered before the backend serves requests.
+type exchangePipeline struct {
+	hooks [numExchangeStages][]exchangeHook
+}
+
+// register appends a hook to a stage. Hooks within a stage run in
+// registration order.
+func (p *exchangePipeline) register(stage exchangeStage, name string, run exchangeHookFunc) {
+	p.hooks[stage] = append(p.hooks[stage], exchangeHook{name: name, run: run})
+}
+
+// run executes the stages in order and returns the exchange result. Record
+// hooks see the final result. A record hook error fails an exchange that had
+// otherwise succeeded, so its token is withheld and later record hooks see
+// the failure; the outcome of an exchange that had already failed stands.
+func (p *exchangePipeline) run(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	ex.resp, ex.err = p.runStages(ctx, ex)
+	if ex.resp == nil && ex.err == nil {
+		ex.resp = &logical.Response{Data: ex.respData}
+		if ex.wrapTTL > 0 {
+			ex.resp.WrapInfo = &wrapping.ResponseWrapInfo{TTL: ex.wrapTTL}
+		}
+	}
+
+	for _, hook := range p.hooks[stageRecord] {
+		if _, err := hook.run(ctx, ex); err != nil && ex.succeeded() {
+			ex.resp, ex.err = nil, err
+		}
+	}
+
+	return ex.resp, ex.err
+}
+
+// runStages executes the hooks of every stage before record, stopping at the
+// first hook that returns a response or error
+func (p *exchangePipeline) runStages(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	for stage := stageValidate; stage < stageRecord; stage++ {
+		for _, hook := range p.hooks[stage] {
+			if resp, err := hook.run(ctx, ex); resp != nil || err != nil {
+				return resp, err
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,126 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExchangePipeline_Order tests that stages run in order and hooks within
+// a stage run in registration order
+func TestExchangePipeline_Order(t *testing.T) {
+	var calls []string
+	hook := func(name string) exchangeHookFunc {
+		return func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+			calls = append(calls, name)
+			return nil, nil
+		}
+	}
+
+	p := &exchangePipeline{}
+	p.register(stageRecord, "record", hook("record"))
+	p.register(stageSign, "sign", hook("sign"))
+	p.register(stageValidate, "validate-1", hook("validate-1"))
+	p.register(stageValidate, "validate-2", hook("validate-2"))
+	p.register(stageEnrich, "enrich", hook("enrich"))
+
+	ex := &exchange{}
+	resp, err := p.run(context.Background(), ex)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, ex.succeeded())
+	require.Equal(t, []string{"validate-1", "validate-2", "enrich", "sign", "record"}, calls)
+}
+
+// TestExchangePipeline_ShortCircuit tests that a hook result ends the exchange
+// while record hooks still observe the outcome
+func TestExchangePipeline_ShortCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *logical.Response
+		err  error
+	}{
+		{name: "error response", resp: exchangeError(ErrCodeAccessDenied, "denied")},
+		{name: "error", err: errors.New("boom")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var signed bool
+			var recorded *exchange
+
+			p := &exchangePipeline{}
+			p.register(stageAuthorize, "deny", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+				return tt.resp, tt.err
+			})
+			p.register(stageSign, "sign", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+				signed = true
+				return nil, nil
+			})
+			p.register(stageRecord, "record", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+				recorded = ex
+				return nil, nil
+			})
+
+			resp, err := p.run(context.Background(), &exchange{})
+			require.Equal(t, tt.resp, resp)
+			require.Equal(t, tt.err, err)
+			require.False(t, signed)
+			require.NotNil(t, recorded)
+			require.False(t, recorded.succeeded())
+		})
+	}
+}
+
+// TestExchangePipeline_RecordError tests that a failing record hook withholds
+// the token of a successful exchange, and that later record hooks see the
+// failure
+func TestExchangePipeline_RecordError(t *testing.T) {
+	recordErr := errors.New("failed to store audit record")
+	var recorded *exchange
+
+	p := &exchangePipeline{}
+	p.register(stageRecord, "audit", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+		return nil, recordErr
+	})
+	p.register(stageRecord, "metrics", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+		recorded = ex
+		return nil, nil
+	})
+
+	resp, err := p.run(context.Background(), &exchange{respData: map[string]any{"token": "issued"}})
+	require.Nil(t, resp)
+	require.Equal(t, recordErr, err)
+	require.False(t, recorded.succeeded())
+
+	// A failed exchange keeps its own outcome
+	denied := exchangeError(ErrCodeAccessDenied, "denied")
+	p.register(stageAuthorize, "deny", func(ctx context.Context, ex *exchange) (*logical.Response, error) {
+		return denied, nil
+	})
+	resp, err = p.run(context.Background(), &exchange{})
+	require.Equal(t, denied, resp)
+	require.NoError(t, err)
+}
+
+// TestAuthorizeBoundEntity tests the bound entity hook in isolation
+func TestAuthorizeBoundEntity(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	ex := &exchange{
+		req:      &logical.Request{EntityID: "test-entity"},
+		roleName: "test-role",
+		role:     &Role{BoundEntityIDs: []string{"other-entity"}},
+	}
+	resp, err := b.authorizeBoundEntity(context.Background(), ex)
+	require.NoError(t, err)
+	requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+
+	ex.role.BoundEntityIDs = append(ex.role.BoundEntityIDs, "test-entity")
+	resp, err = b.authorizeBoundEntity(context.Background(), ex)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
@@ -0,0 +1,187 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// policyCostLimit bounds the evaluation cost of a role policy, so an
+// expensive expression cannot stall exchanges
+const policyCostLimit = 100000
+
+// policyEnv is the CEL environment role policies are compiled in. subject
+// holds the subject token claims, entity the exchanging entity and request
+// the exchange parameters.
+var policyEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("subject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("entity", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+		ext.Sets(),
+	)
+})
+
+// compiledPolicy is a role policy compiled for evaluation
+type compiledPolicy struct {
+	expression string
+	program    cel.Program
+}
+
+// errPolicyDenied is returned when a policy evaluates to false
+var errPolicyDenied = errors.New("denied by the role policy")
+
+// compilePolicy compiles a role policy, which must evaluate to a bool
+func compilePolicy(expression string) (cel.Program, error) {
+	env, err := policyEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if outputType := ast.OutputType(); outputType != cel.BoolType && outputType != cel.DynType {
+		return nil, fmt.Errorf("policy must evaluate to a bool, not %s", outputType)
+	}
+
+	return env.Program(ast, cel.CostLimit(policyCostLimit), cel.InterruptCheckFrequency(100))
+}
+
+// rolePolicy returns the compiled policy of the role, compiling it on first
+// use after each change
+func (b *Backend) rolePolicy(role *Role) (cel.Program, error) {
+	b.cacheLock.RLock()
+	cached, ok := b.policyCache[role.Name]
+	b.cacheLock.RUnlock()
+	if ok && cached.expression == role.Policy {
+		return cached.program, nil
+	}
+
+	program, err := compilePolicy(role.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheLock.Lock()
+	b.policyCache[role.Name] = &compiledPolicy{expression: role.Policy, program: program}
+	b.cacheLock.Unlock()
+	return program, nil
+}
+
+// resetPolicyCache drops the compiled policy of a role
+func (b *Backend) resetPolicyCache(name string) {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	delete(b.policyCache, name)
+}
+
+// usesPolicyGroups reports whether a policy references the entity's groups,
+// so group lookups are only made for policies that need them
+func usesPolicyGroups(policy string) bool {
+	return strings.Contains(policy, "entity.group")
+}
+
+// policyEntity describes the exchanging entity to a policy
+func policyEntity(entity *logical.Entity, groups []*logical.Group) map[string]any {
+	groupNames := make([]string, 0, len(groups))
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupNames = append(groupNames, group.Name)
+		groupIDs = append(groupIDs, group.ID)
+	}
+
+	metadata := entity.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	return map[string]any{
+		"id":           entity.ID,
+		"name":         entity.Name,
+		"namespace_id": entity.NamespaceID,
+		"metadata":     metadata,
+		"groups":       groupNames,
+		"group_ids":    groupIDs,
+	}
+}
+
+// policyRequest describes the exchange parameters to a policy
+func policyRequest(ex *exchange) map[string]any {
+	remoteAddr := ""
+	if ex.req.Connection != nil {
+		remoteAddr = ex.req.Connection.RemoteAddr
+	}
+	notAfter := ""
+	if !ex.notAfter.IsZero() {
+		notAfter = ex.notAfter.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]any{
+		"role":               ex.roleName,
+		"subject_token_type": ex.subjectTokenType,
+		"nonce":              ex.nonce,
+		"not_after":          notAfter,
+		"certificate_bound":  ex.certificateThumbprint != "",
+		"dpop_bound":         ex.dpopThumbprint != "",
+		"remote_addr":        remoteAddr,
+	}
+}
+
+// evaluatePolicy runs a compiled policy. It returns errPolicyDenied when the
+// policy evaluates to false.
+func evaluatePolicy(ctx context.Context, program cel.Program, subjectClaims, entity, request map[string]any) error {
+	out, _, err := program.ContextEval(ctx, map[string]any{
+		"subject": subjectClaims,
+		"entity":  entity,
+		"request": request,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+
+	allowed, ok := out.(types.Bool)
+	if !ok {
+		return fmt.Errorf("policy evaluated to %s, not a bool", out.Type().TypeName())
+	}
+	if !allowed {
+		return errPolicyDenied
+	}
+	return nil
+}
+
+// authorizePolicy denies exchanges for which the role's policy does not
+// evaluate to true
+func (b *Backend) authorizePolicy(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.role.Policy == "" {
+		return nil, nil
+	}
+
+	program, err := b.rolePolicy(ex.role)
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q has an invalid policy: %v", ex.roleName, err), nil
+	}
+
+	if usesPolicyGroups(ex.role.Policy) {
+		if resp, err := b.loadGroups(ctx, ex); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	err = evaluatePolicy(ctx, program, ex.subjectClaims, policyEntity(ex.entity, ex.groups), policyRequest(ex))
+	if err != nil {
+		return exchangeError(ErrCodeAccessDenied, "entity %q is not permitted to exchange with role %q: %v", ex.entity.ID, ex.roleName, err), nil
+	}
+	return nil, nil
+}
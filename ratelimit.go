@@ -0,0 +1,104 @@
+package tokenexchange
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitWindow is the sliding window used by max_exchanges_per_minute
+const rateLimitWindow = time.Minute
+
+// rateLimit is a limit applied to a single rate limiter key
+type rateLimit struct {
+	key   string
+	limit int
+}
+
+// rateLimiter enforces sliding-window exchange limits. Limits are held in
+// memory and apply per node serving the exchange.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	// events holds the times of recent exchanges per key, oldest first
+	events map[string][]time.Time
+}
+
+// newRateLimiter creates an empty rate limiter
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		events: make(map[string][]time.Time),
+	}
+}
+
+// allow records an exchange against every limit if none of them is exceeded.
+// A limit of zero or less is unlimited. When denied it returns how long until
+// the earliest exceeded limit frees a slot.
+func (r *rateLimiter) allow(now time.Time, limits ...rateLimit) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := now.Add(-rateLimitWindow)
+
+	var retryAfter time.Duration
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+
+		events := pruneEvents(r.events[l.key], cutoff)
+		r.events[l.key] = events
+
+		if len(events) >= l.limit {
+			wait := events[len(events)-l.limit].Sub(cutoff)
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, l := range limits {
+		if l.limit > 0 {
+			r.events[l.key] = append(r.events[l.key], now)
+		}
+	}
+
+	return true, 0
+}
+
+// prune drops events that have left the window and keys with no events
+func (r *rateLimiter) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := now.Add(-rateLimitWindow)
+	for key, events := range r.events {
+		events = pruneEvents(events, cutoff)
+		if len(events) == 0 {
+			delete(r.events, key)
+			continue
+		}
+		r.events[key] = events
+	}
+}
+
+// pruneEvents drops events at or before cutoff from an ordered slice
+func pruneEvents(events []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	return events[i:]
+}
+
+// exchangeRateLimits returns the config and role limits for an entity. The
+// config limit spans every role on the mount; the role limit is per role.
+func exchangeRateLimits(config *Config, role *Role, entityID string) []rateLimit {
+	return []rateLimit{
+		{key: "entity/" + entityID, limit: config.MaxExchangesPerMinute},
+		{key: "role/" + role.Name + "/entity/" + entityID, limit: role.MaxExchangesPerMinute},
+	}
+}
@@ -0,0 +1,99 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRateLimiter_SlidingWindow tests that slots free up as events leave the window
+func TestRateLimiter_SlidingWindow(t *testing.T) {
+	r := newRateLimiter()
+	now := time.Now()
+	limit := rateLimit{key: "entity/a", limit: 2}
+
+	allowed, _ := r.allow(now, limit)
+	require.True(t, allowed)
+	allowed, _ = r.allow(now.Add(10*time.Second), limit)
+	require.True(t, allowed)
+
+	allowed, retryAfter := r.allow(now.Add(20*time.Second), limit)
+	require.False(t, allowed)
+	require.Equal(t, 40*time.Second, retryAfter)
+
+	// Other keys are unaffected
+	allowed, _ = r.allow(now.Add(20*time.Second), rateLimit{key: "entity/b", limit: 2})
+	require.True(t, allowed)
+
+	// The first event has left the window
+	allowed, _ = r.allow(now.Add(61*time.Second), limit)
+	require.True(t, allowed)
+
+	r.prune(now.Add(10 * time.Minute))
+	require.Empty(t, r.events)
+}
+
+// TestRateLimiter_DeniedDoesNotConsume tests that a denial by one limit does
+// not use a slot in the others
+func TestRateLimiter_DeniedDoesNotConsume(t *testing.T) {
+	r := newRateLimiter()
+	now := time.Now()
+
+	mount := rateLimit{key: "entity/a", limit: 1}
+	role := rateLimit{key: "role/r/entity/a", limit: 5}
+
+	allowed, _ := r.allow(now, mount, role)
+	require.True(t, allowed)
+
+	allowed, _ = r.allow(now, mount, role)
+	require.False(t, allowed)
+	require.Len(t, r.events["role/r/entity/a"], 1)
+
+	// Unlimited keys are never tracked
+	allowed, _ = r.allow(now, rateLimit{key: "entity/c", limit: 0})
+	require.True(t, allowed)
+	require.NotContains(t, r.events, "entity/c")
+}
+
+// TestTokenExchange_RateLimited tests that exchanges beyond the role limit are
+// rejected with a rate limit error
+func TestTokenExchange_RateLimited(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"max_exchanges_per_minute": 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	}
+
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "test-entity",
+		Data:      map[string]any{"subject_token": subjectToken},
+	})
+	require.ErrorIs(t, err, logical.ErrRateLimitQuotaExceeded)
+	requireExchangeError(t, resp, ErrCodeRateLimited, true)
+	require.Greater(t, resp.Data["data"].(map[string]any)["retry_after"], int64(0))
+
+	status, _ := logical.RespondErrorCommon(&logical.Request{Operation: logical.UpdateOperation}, resp, err)
+	require.Equal(t, 429, status)
+
+	// A different entity has its own allowance
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "other-entity",
+		Data:      map[string]any{"subject_token": subjectToken},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+}
@@ -0,0 +1,172 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// referenceTokenStoragePrefix holds the claims of issued reference tokens,
+// keyed by the hash of the token
+const referenceTokenStoragePrefix = "reference/"
+
+// referenceTokenBytes is the number of random bytes in a reference token
+const referenceTokenBytes = 32
+
+// referenceTokenEntry holds what introspection returns for a reference token
+type referenceTokenEntry struct {
+	JTI       string         `json:"jti"`
+	Role      string         `json:"role"`
+	TokenType string         `json:"token_type"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Claims    map[string]any `json:"claims"`
+}
+
+// referenceTokenStorageKey returns the storage key of a reference token. The
+// token is hashed so storage never holds usable token material.
+func referenceTokenStorageKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return referenceTokenStoragePrefix + hex.EncodeToString(sum[:])
+}
+
+// resolveRequestedTokenType reads requested_token_type. Reference tokens
+// replace the JWT in the response, so they cannot be combined with role
+// options that transform or forward it.
+func (b *Backend) resolveRequestedTokenType(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	ex.requestedTokenType = ex.data.Get("requested_token_type").(string)
+	switch ex.requestedTokenType {
+	case "":
+		ex.requestedTokenType = tokenTypeJWT
+	case tokenTypeJWT:
+	case tokenTypeAccessToken:
+		switch {
+		case ex.role.DetachedPayload:
+			return exchangeError(ErrCodeInvalidRequest, "role %q detaches the token payload and cannot issue reference tokens", ex.roleName), nil
+		case ex.role.UpstreamSTS != nil:
+			return exchangeError(ErrCodeInvalidRequest, "role %q chains to an upstream STS and cannot issue reference tokens", ex.roleName), nil
+		case ex.role.EncryptionKey != "" || ex.role.EncryptionJWKSURI != "":
+			return exchangeError(ErrCodeInvalidRequest, "role %q encrypts its tokens and cannot issue reference tokens", ex.roleName), nil
+		}
+	default:
+		return exchangeError(ErrCodeInvalidRequest, "unsupported requested_token_type %q, must be %s or %s", ex.requestedTokenType, tokenTypeJWT, tokenTypeAccessToken), nil
+	}
+	return nil, nil
+}
+
+// issueReferenceToken replaces the signed token in the response with an
+// opaque reference token when one was requested. The claims are stored until
+// the token expires and are only disclosed by the introspect endpoint.
+func (b *Backend) issueReferenceToken(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.requestedTokenType != tokenTypeAccessToken {
+		return nil, nil
+	}
+
+	_, payload, err := detachPayload(ex.issued.Token)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(decoded)))
+	decoder.UseNumber()
+	claims := map[string]any{}
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	}
+
+	raw := make([]byte, referenceTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate reference token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tokenType := "Bearer"
+	if ex.dpopThumbprint != "" {
+		tokenType = "DPoP"
+	}
+
+	entry, err := logical.StorageEntryJSON(referenceTokenStorageKey(token), &referenceTokenEntry{
+		JTI:       ex.issued.JTI,
+		Role:      ex.roleName,
+		TokenType: tokenType,
+		ExpiresAt: ex.issued.ExpiresAt,
+		Claims:    claims,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+	if err := ex.req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write reference token: %w", err)
+	}
+
+	ex.respData["token"] = token
+	ex.respData["issued_token_type"] = tokenTypeAccessToken
+	return nil, nil
+}
+
+// getReferenceToken returns the entry of a reference token, or nil if the
+// token is unknown or expired
+func (b *Backend) getReferenceToken(ctx context.Context, storage logical.Storage, token string) (*referenceTokenEntry, error) {
+	entry, err := storage.Get(ctx, referenceTokenStorageKey(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference token: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	ref := &referenceTokenEntry{}
+	if err := entry.DecodeJSON(ref); err != nil {
+		return nil, fmt.Errorf("failed to decode reference token: %w", err)
+	}
+	if !time.Now().Before(ref.ExpiresAt) {
+		return nil, nil
+	}
+	return ref, nil
+}
+
+// tidyReferenceTokens deletes reference tokens that expired before cutoff and
+// returns how many were deleted
+func (b *Backend) tidyReferenceTokens(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	hashes, err := storage.List(ctx, referenceTokenStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list reference tokens: %w", err)
+	}
+
+	deleted := 0
+	for _, hash := range hashes {
+		entry, err := storage.Get(ctx, referenceTokenStoragePrefix+hash)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to read reference token: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		ref := &referenceTokenEntry{}
+		if err := entry.DecodeJSON(ref); err != nil {
+			return deleted, fmt.Errorf("failed to decode reference token: %w", err)
+		}
+
+		if cutoff.Before(ref.ExpiresAt) {
+			continue
+		}
+
+		if err := storage.Delete(ctx, referenceTokenStoragePrefix+hash); err != nil {
+			return deleted, fmt.Errorf("failed to delete reference token: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_ReferenceToken tests that a reference token is issued on
+// request and resolved to its claims by the introspect endpoint
+func TestTokenExchange_ReferenceToken(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	exchange := func(requestedTokenType string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token":        generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+				"requested_token_type": requestedTokenType,
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	introspect := func(token string) map[string]any {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "introspect",
+			Storage:   storage,
+			Data:      map[string]any{"token": token},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "introspection failed: %v", resp.Error())
+		return resp.Data
+	}
+
+	resp := exchange(tokenTypeJWT)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, tokenTypeJWT, resp.Data["issued_token_type"])
+	require.Equal(t, false, introspect(resp.Data["token"].(string))["active"], "JWTs are not introspected")
+
+	resp = exchange(tokenTypeAccessToken)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, tokenTypeAccessToken, resp.Data["issued_token_type"])
+	token := resp.Data["token"].(string)
+	require.NotContains(t, token, ".", "reference tokens are opaque")
+
+	data := introspect(token)
+	require.Equal(t, true, data["active"])
+	require.Equal(t, "Bearer", data["token_type"])
+	require.Equal(t, "user-123", data["sub"])
+	require.Equal(t, resp.Data["jti"], data["jti"])
+	require.Contains(t, data, "act")
+
+	require.Equal(t, map[string]any{"active": false}, introspect("unknown"))
+
+	// Expired reference tokens are inactive and deleted by tidy
+	entry, err := storage.Get(ctx, referenceTokenStorageKey(token))
+	require.NoError(t, err)
+	ref := &referenceTokenEntry{}
+	require.NoError(t, entry.DecodeJSON(ref))
+	ref.ExpiresAt = time.Now().Add(-time.Minute)
+	entry, err = logical.StorageEntryJSON(referenceTokenStorageKey(token), ref)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+	require.Equal(t, false, introspect(token)["active"])
+
+	deleted, err := b.tidyReferenceTokens(ctx, storage, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	resp = exchange("urn:ietf:params:oauth:token-type:saml2")
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	resp = writeProfileRole(t, b, storage, "", map[string]any{"detached_payload": true})
+	require.False(t, resp.IsError())
+	resp = exchange(tokenTypeAccessToken)
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+}
@@ -0,0 +1,288 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// refreshStoragePrefix holds the refresh handles issued with delegated
+// tokens, keyed by the hash of the handle
+const refreshStoragePrefix = "refresh/"
+
+// refreshHandleBytes is the number of random bytes in a refresh handle
+const refreshHandleBytes = 32
+
+// refreshEntry records what an exchange resolved from its subject token, so a
+// token can be re-issued without the subject token being sent again
+type refreshEntry struct {
+	Role                  string         `json:"role"`
+	EntityID              string         `json:"entity_id"`
+	SubjectClaims         map[string]any `json:"subject_claims"`
+	SubjectTokenType      string         `json:"subject_token_type"`
+	SubjectTokenHash      string         `json:"subject_token_hash"`
+	Scope                 string         `json:"scope,omitempty"`
+	DelegationCtx         map[string]any `json:"delegation_ctx,omitempty"`
+	NotAfter              time.Time      `json:"not_after,omitempty"`
+	CertificateThumbprint string         `json:"certificate_thumbprint,omitempty"`
+	DPoPThumbprint        string         `json:"dpop_thumbprint,omitempty"`
+	CreatedAt             time.Time      `json:"created_at"`
+	ExpiresAt             time.Time      `json:"expires_at"`
+}
+
+// refreshStorageKey returns the storage key of a refresh handle. The handle
+// is hashed so storage never holds usable token material.
+func refreshStorageKey(handle string) string {
+	sum := sha256.Sum256([]byte(handle))
+	return refreshStoragePrefix + hex.EncodeToString(sum[:])
+}
+
+// pathTokenRefresh handles re-issuing a token from a refresh handle
+func (b *Backend) pathTokenRefresh(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if !b.beginExchange() {
+		return exchangeError(ErrCodeTemporarilyUnavailable, "token refresh unavailable: backend is shutting down"), nil
+	}
+	defer b.endExchange()
+
+	return b.refreshPipeline.run(ctx, &exchange{
+		req:      req,
+		data:     data,
+		roleName: data.Get("name").(string),
+		start:    time.Now(),
+	})
+}
+
+// newRefreshPipeline builds the pipeline for refreshes. It replaces reading
+// and verifying the subject token with consuming a refresh handle, and
+// otherwise runs the hooks of an exchange, so the refreshed token is
+// authorized and built under the role as it is now.
+func (b *Backend) newRefreshPipeline() *exchangePipeline {
+	p := &exchangePipeline{}
+
+	p.register(stageValidate, "entity_role", b.resolveEntityRole)
+	p.register(stageValidate, "refresh", b.loadRefreshHandle)
+	p.register(stageValidate, "requested_token_type", b.resolveRequestedTokenType)
+	p.register(stageValidate, "trace", b.resolveTrace)
+	p.register(stageValidate, "scopes", b.resolveScopes)
+	p.register(stageValidate, "delegation_ctx", b.resolveDelegationCtx)
+	p.register(stageValidate, "response_wrapping", b.resolveWrapTTL)
+	p.register(stageValidate, "rate_limit", b.enforceRateLimit)
+
+	p.register(stageAuthorize, "denied_scopes", b.authorizeScopes)
+	p.register(stageAuthorize, "bound_entity", b.authorizeBoundEntity)
+	p.register(stageAuthorize, "entity_metadata", b.authorizeEntityMetadata)
+	p.register(stageAuthorize, "policy", b.authorizePolicy)
+	p.register(stageAuthorize, "authorization_webhook", b.authorizeWebhook)
+	p.register(stageAuthorize, "quota", b.enforceQuota)
+	// The handle is only used up once the refresh is authorized, so denials
+	// and transient failures leave it usable
+	p.register(stageAuthorize, "consume_refresh", b.consumeRefreshHandle)
+
+	p.register(stageEnrich, "directory", b.enrichDirectory)
+	p.register(stageEnrich, "groups", b.enrichGroups)
+	p.register(stageEnrich, "caller_token", b.enrichCallerToken)
+
+	p.register(stageTemplate, "templates", b.renderTemplates)
+	p.register(stageTemplate, "subject", b.resolveSubject)
+	p.register(stageTemplate, "vault_meta", b.addVaultMeta)
+	p.register(stageTemplate, "delegation_ctx", b.addDelegationCtx)
+	p.register(stageTemplate, "txn", b.addTxn)
+	p.register(stageTemplate, "subject_fingerprint", b.addSubjectFingerprint)
+	p.register(stageTemplate, "confirmation", b.addConfirmation)
+
+	p.register(stageSign, "sign", b.signToken)
+	p.register(stageSign, "id_token", b.issueIDToken)
+	p.register(stageSign, "encrypt", b.encryptIssuedToken)
+	p.register(stageSign, "detached_payload", b.detachTokenPayload)
+	p.register(stageSign, "upstream_sts", b.chainUpstream)
+	p.register(stageSign, "reference_token", b.issueReferenceToken)
+	p.register(stageSign, "refresh_handle", b.issueRefreshHandle)
+
+	p.register(stageRecord, "issuance", b.recordIssuance)
+	p.register(stageRecord, "issuance_log", b.recordIssuanceLog)
+	p.register(stageRecord, "events", b.publishIssuance)
+	p.register(stageRecord, "usage", b.recordUsage)
+	p.register(stageRecord, "metrics", b.recordExchangeResult)
+	p.register(stageRecord, "log", b.logExchange)
+
+	return p
+}
+
+// loadRefreshHandle reads the entry of the refresh handle, restores what the
+// original exchange resolved and checks the recorded subject claims against
+// the role as it is now. The handle is used up by consumeRefreshHandle.
+func (b *Backend) loadRefreshHandle(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	handle := ex.data.Get("refresh_token").(string)
+	if handle == "" {
+		return exchangeError(ErrCodeInvalidRequest, "refresh_token is required"), nil
+	}
+
+	refresh, err := readRefreshEntry(ctx, ex.req.Storage, handle)
+	if err != nil {
+		return nil, err
+	}
+	if refresh == nil || !time.Now().Before(refresh.ExpiresAt) {
+		return exchangeError(ErrCodeInvalidGrant, "refresh_token is invalid, expired or already used"), nil
+	}
+	if refresh.Role != ex.roleName || refresh.EntityID != ex.req.EntityID {
+		return exchangeError(ErrCodeInvalidGrant, "refresh_token was not issued to this entity for role %q", ex.roleName), nil
+	}
+
+	if resp, err := b.loadExchangeRole(ctx, ex, refresh.NotAfter); resp != nil || err != nil {
+		return resp, err
+	}
+	if ex.role.RefreshTTL == 0 {
+		return exchangeError(ErrCodeInvalidGrant, "role %q no longer issues refresh tokens", ex.roleName), nil
+	}
+	if resp := checkSubjectClaims(refresh.SubjectClaims, ex.role); resp != nil {
+		return resp, nil
+	}
+
+	ex.refresh = refresh
+	ex.subjectClaims = refresh.SubjectClaims
+	ex.subjectTokenType = refresh.SubjectTokenType
+	ex.subjectTokenHash = refresh.SubjectTokenHash
+	ex.requestedScope = refresh.Scope
+	ex.delegationCtx = refresh.DelegationCtx
+	ex.certificateThumbprint = refresh.CertificateThumbprint
+	ex.dpopThumbprint = refresh.DPoPThumbprint
+	return nil, nil
+}
+
+// consumeRefreshHandle deletes the refresh handle of an authorized refresh so
+// it can only be used once. A concurrent refresh that used it first wins.
+func (b *Backend) consumeRefreshHandle(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	b.refreshLock.Lock()
+	defer b.refreshLock.Unlock()
+
+	handle := ex.data.Get("refresh_token").(string)
+	refresh, err := readRefreshEntry(ctx, ex.req.Storage, handle)
+	if err != nil {
+		return nil, err
+	}
+	if refresh == nil {
+		return exchangeError(ErrCodeInvalidGrant, "refresh_token is invalid, expired or already used"), nil
+	}
+	if err := ex.req.Storage.Delete(ctx, refreshStorageKey(handle)); err != nil {
+		return nil, fmt.Errorf("failed to delete refresh token: %w", err)
+	}
+	return nil, nil
+}
+
+// readRefreshEntry reads the entry of a refresh handle. It returns nil if the
+// handle is unknown.
+func readRefreshEntry(ctx context.Context, storage logical.Storage, handle string) (*refreshEntry, error) {
+	entry, err := storage.Get(ctx, refreshStorageKey(handle))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refresh token: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	refresh := &refreshEntry{}
+	if err := entry.DecodeJSON(refresh); err != nil {
+		return nil, fmt.Errorf("failed to decode refresh token: %w", err)
+	}
+	return refresh, nil
+}
+
+// issueRefreshHandle adds a refresh handle to the response when the role has
+// a refresh_ttl. A refresh replaces the handle it used with a new one that
+// expires no later than the first, so refreshes never extend the delegation
+// past the role's refresh_ttl, the subject token's expiry or the deadline.
+func (b *Backend) issueRefreshHandle(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	// Refreshes are bound to the caller's entity, so callers without one
+	// exchange again instead
+	if ex.role.RefreshTTL == 0 || ex.actorID != "" {
+		return nil, nil
+	}
+
+	now := time.Now()
+	refresh := ex.refresh
+	if refresh == nil {
+		exp, err := numericDateClaim(ex.subjectClaims, "exp")
+		if err != nil {
+			return exchangeError(ErrCodeInvalidSubjectToken, "failed to read subject token expiry: %v", err), nil
+		}
+		refresh = &refreshEntry{
+			Role:                  ex.roleName,
+			EntityID:              ex.req.EntityID,
+			SubjectClaims:         ex.subjectClaims,
+			SubjectTokenType:      ex.subjectTokenType,
+			SubjectTokenHash:      subjectTokenHash(ex.subjectToken),
+			Scope:                 ex.requestedScope,
+			DelegationCtx:         ex.delegationCtx,
+			NotAfter:              ex.notAfter,
+			CertificateThumbprint: ex.certificateThumbprint,
+			DPoPThumbprint:        ex.dpopThumbprint,
+			CreatedAt:             now,
+			ExpiresAt:             time.Unix(exp, 0),
+		}
+	}
+	refresh.ExpiresAt = earliestDeadline(refresh.ExpiresAt, refresh.CreatedAt.Add(ex.role.RefreshTTL), ex.notAfter)
+	if !now.Before(refresh.ExpiresAt) {
+		return nil, nil
+	}
+
+	raw := make([]byte, refreshHandleBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	handle := base64.RawURLEncoding.EncodeToString(raw)
+
+	entry, err := logical.StorageEntryJSON(refreshStorageKey(handle), refresh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+	if err := ex.req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write refresh token: %w", err)
+	}
+
+	ex.respData["refresh_token"] = handle
+	ex.respData["refresh_expires_at"] = refresh.ExpiresAt.Unix()
+	return nil, nil
+}
+
+// tidyRefreshEntries deletes refresh handles that expired before cutoff and
+// returns how many were deleted
+func (b *Backend) tidyRefreshEntries(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	hashes, err := storage.List(ctx, refreshStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list refresh tokens: %w", err)
+	}
+
+	deleted := 0
+	for _, hash := range hashes {
+		entry, err := storage.Get(ctx, refreshStoragePrefix+hash)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to read refresh token: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		refresh := &refreshEntry{}
+		if err := entry.DecodeJSON(refresh); err != nil {
+			return deleted, fmt.Errorf("failed to decode refresh token: %w", err)
+		}
+
+		if cutoff.Before(refresh.ExpiresAt) {
+			continue
+		}
+
+		if err := storage.Delete(ctx, refreshStoragePrefix+hash); err != nil {
+			return deleted, fmt.Errorf("failed to delete refresh token: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,141 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenRefresh tests that a refresh token re-issues a token once, for the
+// same entity, while the recorded subject claims remain acceptable
+func TestTokenRefresh(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"refresh_ttl":                 "1h",
+		"include_subject_fingerprint": true,
+		"bound_claims":                map[string]any{"email": "*@example.com"},
+		"bound_claims_type":           "glob",
+	})
+
+	refresh := func(entityID, handle string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role/refresh",
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      map[string]any{"refresh_token": handle},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	exchange := func(subjectToken string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": subjectToken},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	resp := exchange(subjectToken)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	handle := resp.Data["refresh_token"].(string)
+	require.NotEmpty(t, handle)
+	firstExpiry := resp.Data["refresh_expires_at"].(int64)
+	require.LessOrEqual(t, firstExpiry, time.Now().Add(time.Hour).Unix())
+
+	resp = refresh("test-entity", handle)
+	require.False(t, resp.IsError(), "refresh failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "user-123", claims["sub"])
+	require.Equal(t, subjectTokenHash(subjectToken), claims["sub_tkn#S256"])
+	require.NotEqual(t, handle, resp.Data["refresh_token"], "refresh tokens are rotated")
+	require.Equal(t, firstExpiry, resp.Data["refresh_expires_at"], "refreshes never extend the delegation")
+	rotated := resp.Data["refresh_token"].(string)
+
+	// Refresh tokens are single-use and bound to the entity
+	resp = refresh("test-entity", handle)
+	requireExchangeError(t, resp, ErrCodeInvalidGrant, false)
+	resp = refresh("other-entity", rotated)
+	requireExchangeError(t, resp, ErrCodeInvalidGrant, false)
+
+	// The recorded subject claims are checked against the role as it is now
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	handle = resp.Data["refresh_token"].(string)
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	resp = writeProfileRole(t, b, storage, "", map[string]any{"refresh_ttl": "1h", "bound_claims": map[string]any{"email": "*@other.example.com"}, "bound_claims_type": "glob"})
+	require.False(t, resp.IsError())
+	resp = refresh("test-entity", handle)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	// Without a refresh_ttl no refresh token is issued
+	resp = writeProfileRole(t, b, storage, "", nil)
+	require.False(t, resp.IsError())
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.NotContains(t, resp.Data, "refresh_token")
+
+	// Expired refresh tokens are deleted by tidy, including those whose
+	// refreshes were rejected
+	deleted, err := b.tidyRefreshEntries(ctx, storage, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+	deleted, err = b.tidyRefreshEntries(ctx, storage, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+}
+
+// TestTokenRefresh_DeniedKeepsHandle tests that a refresh denied once the
+// handle is validated, here by the role's quota, leaves the handle usable
+func TestTokenRefresh_DeniedKeepsHandle(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"refresh_ttl": "1h", "max_tokens_per_day": 1})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	handle := resp.Data["refresh_token"].(string)
+
+	refresh := func() (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role/refresh",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"refresh_token": handle},
+		})
+	}
+
+	resp, err := refresh()
+	require.ErrorIs(t, err, logical.ErrRateLimitQuotaExceeded)
+	requireExchangeError(t, resp, ErrCodeRateLimited, true)
+
+	resp = writeRole(t, b, storage, "test-role", map[string]any{
+		"ttl":                "1h",
+		"key":                "test-key",
+		"actor_template":     `{"act": {"sub": "agent-123"}}`,
+		"subject_template":   `{}`,
+		"context":            "urn:documents:read",
+		"refresh_ttl":        "1h",
+		"max_tokens_per_day": 2,
+	})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+	resp, err = refresh()
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "refresh failed: %v", resp.Error())
+
+	resp, err = refresh()
+	require.NoError(t, err)
+	requireExchangeError(t, resp, ErrCodeInvalidGrant, false)
+}
@@ -0,0 +1,102 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// replayStoragePrefix holds records of consumed single-use subject tokens
+const replayStoragePrefix = "replay/"
+
+// replayEntry records a consumed subject token until it expires
+type replayEntry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// subjectTokenFingerprint identifies a subject token for replay detection.
+// The issuer-scoped jti is used when present, otherwise the token is hashed,
+// so no usable token material is written to storage.
+func subjectTokenFingerprint(tokenStr string, claims map[string]any) string {
+	h := sha256.New()
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		iss, _ := claims["iss"].(string)
+		h.Write([]byte("jti\x00" + iss + "\x00" + jti))
+	} else {
+		h.Write([]byte("token\x00" + tokenStr))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// consumeSubjectToken records a subject token as used until expiresAt. It
+// returns false if the token was already used and has not yet expired.
+func (b *Backend) consumeSubjectToken(ctx context.Context, storage logical.Storage, fingerprint string, expiresAt time.Time) (bool, error) {
+	b.replayLock.Lock()
+	defer b.replayLock.Unlock()
+
+	entry, err := storage.Get(ctx, replayStoragePrefix+fingerprint)
+	if err != nil {
+		return false, fmt.Errorf("failed to read replay record: %w", err)
+	}
+
+	if entry != nil {
+		existing := &replayEntry{}
+		if err := entry.DecodeJSON(existing); err != nil {
+			return false, fmt.Errorf("failed to decode replay record: %w", err)
+		}
+		if time.Now().Before(existing.ExpiresAt) {
+			return false, nil
+		}
+	}
+
+	entry, err = logical.StorageEntryJSON(replayStoragePrefix+fingerprint, &replayEntry{ExpiresAt: expiresAt})
+	if err != nil {
+		return false, fmt.Errorf("failed to create storage entry: %w", err)
+	}
+
+	if err := storage.Put(ctx, entry); err != nil {
+		return false, fmt.Errorf("failed to write replay record: %w", err)
+	}
+
+	return true, nil
+}
+
+// tidyReplayEntries deletes replay records that expired before cutoff and
+// returns how many were deleted
+func (b *Backend) tidyReplayEntries(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	fingerprints, err := storage.List(ctx, replayStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list replay records: %w", err)
+	}
+
+	deleted := 0
+	for _, fingerprint := range fingerprints {
+		entry, err := storage.Get(ctx, replayStoragePrefix+fingerprint)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to read replay record: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		record := &replayEntry{}
+		if err := entry.DecodeJSON(record); err != nil {
+			return deleted, fmt.Errorf("failed to decode replay record: %w", err)
+		}
+
+		if cutoff.Before(record.ExpiresAt) {
+			continue
+		}
+
+		if err := storage.Delete(ctx, replayStoragePrefix+fingerprint); err != nil {
+			return deleted, fmt.Errorf("failed to delete replay record: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,77 @@
+package tokenexchange
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+)
+
+// Stored record types whose retention can be configured. Each is deleted by
+// the periodic tidy once older than its retention.
+const (
+	recordTypeIssuance    = "issuance"
+	recordTypeIssuanceLog = "issuance_log"
+	recordTypeUsage       = "usage"
+)
+
+// recordTypes lists the record types accepted in record_retention_overrides
+var recordTypes = []string{recordTypeIssuance, recordTypeIssuanceLog, recordTypeUsage}
+
+// defaultRecordRetention is how long records are kept when record_retention
+// is unset
+const defaultRecordRetention = 90 * 24 * time.Hour
+
+// recordRetention returns how long records of the given type are kept: the
+// type's override, then the deprecated issuance_retention for issuance
+// records, then record_retention
+func (c *Config) recordRetention(recordType string) time.Duration {
+	if retention, ok := c.RecordRetentionOverrides[recordType]; ok {
+		return retention
+	}
+	if recordType == recordTypeIssuance && c.IssuanceRetention > 0 {
+		return c.IssuanceRetention
+	}
+	return c.baseRecordRetention()
+}
+
+// baseRecordRetention returns record_retention, or the default when unset
+func (c *Config) baseRecordRetention() time.Duration {
+	if c.RecordRetention <= 0 {
+		return defaultRecordRetention
+	}
+	return c.RecordRetention
+}
+
+// parseRecordRetentionOverrides parses record type to duration pairs such as
+// issuance=720h
+func parseRecordRetentionOverrides(raw map[string]string) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration, len(raw))
+	for recordType, value := range raw {
+		if !slices.Contains(recordTypes, recordType) {
+			return nil, fmt.Errorf("unknown record type %q, expected one of: %s", recordType, strings.Join(recordTypes, ", "))
+		}
+		retention, err := parseutil.ParseDurationSecond(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention for %s: %w", recordType, err)
+		}
+		if retention <= 0 {
+			return nil, fmt.Errorf("retention for %s must be positive", recordType)
+		}
+		overrides[recordType] = retention
+	}
+	return overrides, nil
+}
+
+// recordRetentionOverrideStrings formats the overrides for a config read, in
+// seconds like the other durations
+func recordRetentionOverrideStrings(overrides map[string]time.Duration) map[string]string {
+	out := make(map[string]string, len(overrides))
+	for recordType, retention := range overrides {
+		out[recordType] = strconv.FormatInt(durationSeconds(retention), 10)
+	}
+	return out
+}
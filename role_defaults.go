@@ -0,0 +1,78 @@
+package tokenexchange
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// RoleDefaults are config-wide values of role parameters, used by the roles
+// that omit them. Roles store only what they set, so changing a default
+// applies to every role using it.
+type RoleDefaults struct {
+	ActorTemplate string   `json:"actor_template,omitempty"`
+	Context       []string `json:"context,omitempty"`
+	BoundIssuer   string   `json:"bound_issuer,omitempty"`
+}
+
+// roleDefaults returns the role defaults of the config, which are empty when
+// the plugin is not configured
+func (c *Config) roleDefaults() RoleDefaults {
+	if c == nil {
+		return RoleDefaults{}
+	}
+	return c.RoleDefaults
+}
+
+// applyRoleDefaults fills the parameters role omits with the config's role
+// defaults. It is applied to roles loaded for use, never to stored roles.
+func (c *Config) applyRoleDefaults(role *Role) {
+	defaults := c.roleDefaults()
+	role.ActorTemplate = cmp.Or(role.ActorTemplate, defaults.ActorTemplate)
+	if len(role.Context) == 0 {
+		role.Context = defaults.Context
+	}
+	role.BoundIssuer = cmp.Or(role.BoundIssuer, defaults.BoundIssuer)
+}
+
+// checkRoleDefaultsUnused returns an error response when a config write clears
+// a role default that roles rely on, naming them
+func (b *Backend) checkRoleDefaultsUnused(ctx context.Context, storage logical.Storage, previous, updated RoleDefaults) (*logical.Response, error) {
+	checks := []struct {
+		parameter string
+		cleared   bool
+		omits     func(*Role) bool
+	}{
+		{"actor_template", previous.ActorTemplate != "" && updated.ActorTemplate == "", func(role *Role) bool { return role.ActorTemplate == "" }},
+		{"context", len(previous.Context) > 0 && len(updated.Context) == 0, func(role *Role) bool { return len(role.Context) == 0 }},
+		{"bound_issuer", previous.BoundIssuer != "" && updated.BoundIssuer == "", func(role *Role) bool { return role.BoundIssuer == "" }},
+	}
+
+	for _, check := range checks {
+		if !check.cleared {
+			continue
+		}
+
+		roleNames, err := storage.List(ctx, roleStoragePrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list roles: %w", err)
+		}
+		var using []string
+		for _, name := range roleNames {
+			role, err := b.getRole(ctx, storage, name)
+			if err != nil {
+				return nil, err
+			}
+			if role != nil && check.omits(role) {
+				using = append(using, name)
+			}
+		}
+		if len(using) > 0 {
+			return logical.ErrorResponse("default_%s cannot be cleared: roles %s do not set %s", check.parameter, strings.Join(using, ", "), check.parameter), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRoleDefaults tests that roles omitting actor_template, context or
+// bound_issuer use the config's role defaults
+func TestRoleDefaults(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := writeRole(t, b, storage, "thin-role", map[string]any{"ttl": "1h", "key": "test-key", "subject_template": `{}`})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "actor_template is required unless the config sets default_actor_template")
+
+	resp = writeJWKSConfig(t, b, storage, map[string]any{
+		"default_actor_template": `{"act": {"sub": "{{identity.entity.name}}"}}`,
+		"default_context":        "urn:documents:read,urn:documents:list",
+		"default_bound_issuer":   "https://idp.example.com",
+	})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	configResp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, []string{"urn:documents:list", "urn:documents:read"}, configResp.Data["default_context"])
+	require.Equal(t, "https://idp.example.com", configResp.Data["default_bound_issuer"])
+
+	resp = writeRole(t, b, storage, "test-role", map[string]any{"ttl": "1h", "key": "test-key", "subject_template": `{}`})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+	// Reads return what the role sets itself
+	role := readRole(t, b, storage, "test-role")
+	require.Empty(t, role["actor_template"])
+	require.Empty(t, role["context"])
+	require.Empty(t, role["bound_issuer"])
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "test-entity-name", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "urn:documents:read urn:documents:list", claims["scope"])
+
+	// Changing a default applies to the roles using it
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"default_bound_issuer": "https://other.example.com"})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.True(t, resp.IsError(), "the default bound_issuer must be enforced")
+
+	// A role's own values take precedence
+	resp = writeRole(t, b, storage, "test-role", map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"subject_template": `{}`,
+		"bound_issuer":     "https://idp.example.com",
+		"context":          "urn:documents:write",
+	})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "urn:documents:write", claims["scope"])
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := writeJWKSConfig(t, b, storage, map[string]any{"default_actor_template": `{"act": `})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "invalid default_actor_template")
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"globally_denied_scopes": "urn:documents:l*"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "default_context includes globally denied scopes: urn:documents:list")
+
+		// test-role relies on the default actor_template
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"default_actor_template": ""})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "default_actor_template cannot be cleared: roles test-role do not set actor_template")
+
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"default_context": ""})
+		require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+		// Clearing the default bound_issuer would let unbound-role accept
+		// subject tokens from any issuer
+		resp = writeRole(t, b, storage, "unbound-role", map[string]any{"ttl": "1h", "key": "test-key", "subject_template": `{}`, "context": "urn:documents:read"})
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+		resp = writeJWKSConfig(t, b, storage, map[string]any{"default_bound_issuer": ""})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "default_bound_issuer cannot be cleared: roles unbound-role do not set bound_issuer")
+	})
+}
@@ -0,0 +1,164 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxRoleInheritanceDepth bounds how many roles a chain of extends can hold
+const maxRoleInheritanceDepth = 8
+
+// roleParameters returns the role parameters set in a write. The role name,
+// cas and extends describe the write rather than the role, so they are not
+// inherited.
+func roleParameters(data *framework.FieldData) map[string]any {
+	params := make(map[string]any, len(data.Raw))
+	for name, value := range data.Raw {
+		if _, ok := data.Schema[name]; !ok {
+			continue
+		}
+		switch name {
+		case "name", "cas", "extends":
+			continue
+		}
+		params[name] = value
+	}
+	return params
+}
+
+// mergeRoleParameters returns inherited with params on top
+func mergeRoleParameters(inherited, params map[string]any) map[string]any {
+	merged := maps.Clone(inherited)
+	if merged == nil {
+		merged = make(map[string]any, len(params))
+	}
+	maps.Copy(merged, params)
+	return merged
+}
+
+// inheritedRoleParameters returns the parameters role name inherits by
+// extending parent, or an error response when parent cannot be extended
+func (b *Backend) inheritedRoleParameters(ctx context.Context, storage logical.Storage, name, parent string) (map[string]any, *logical.Response, error) {
+	var chain []*Role
+	for next := parent; next != ""; {
+		if next == name {
+			return nil, logical.ErrorResponse("extends %q would make role %q inherit from itself", parent, name), nil
+		}
+		if len(chain) == maxRoleInheritanceDepth {
+			return nil, logical.ErrorResponse("extends chains are limited to %d roles", maxRoleInheritanceDepth), nil
+		}
+
+		role, err := b.getRole(ctx, storage, next)
+		if err != nil {
+			return nil, nil, err
+		}
+		if role == nil {
+			return nil, logical.ErrorResponse("role %q to extend not found", next), nil
+		}
+		if role.Parameters == nil && role.Extends == "" {
+			return nil, logical.ErrorResponse("role %q was written by an older version of the plugin: write it again before extending it", next), nil
+		}
+		chain = append(chain, role)
+		next = role.Extends
+	}
+
+	// Apply the chain from its root, so nearer roles override further ones
+	inherited := map[string]any{}
+	for _, role := range slices.Backward(chain) {
+		inherited = mergeRoleParameters(inherited, role.Parameters)
+	}
+	return inherited, nil, nil
+}
+
+// rebuildExtendingRoles rebuilds the roles that directly or indirectly extend
+// parent on top of its resolved parameters. It returns the rebuilt roles that
+// changed, or an error response naming a role that would become invalid.
+func (b *Backend) rebuildExtendingRoles(ctx context.Context, storage logical.Storage, parent *Role, parentParams map[string]any, schema map[string]*framework.FieldSchema) ([]*Role, *logical.Response, error) {
+	children, err := b.extendingRoles(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rebuilt []*Role
+	resolved := map[string]map[string]any{parent.Name: parentParams}
+	queue := []string{parent.Name}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, child := range children[name] {
+			params := mergeRoleParameters(resolved[name], child.Parameters)
+			role, resp, err := b.roleFromFields(ctx, storage, child.Name, &framework.FieldData{Raw: params, Schema: schema})
+			if err != nil {
+				return nil, nil, err
+			}
+			if resp != nil {
+				return nil, logical.ErrorResponse("role %q extends %q and would become invalid: %v", child.Name, name, resp.Error()), nil
+			}
+			role.Extends = child.Extends
+			role.Parameters = child.Parameters
+			role.CASVersion = child.CASVersion
+			if !sameEntry(child, role) {
+				role.CASVersion++
+				rebuilt = append(rebuilt, role)
+			}
+
+			resolved[child.Name] = params
+			queue = append(queue, child.Name)
+		}
+	}
+	return rebuilt, nil, nil
+}
+
+// extendingRoles returns the roles that extend another, by the name of the
+// role they extend
+func (b *Backend) extendingRoles(ctx context.Context, storage logical.Storage) (map[string][]*Role, error) {
+	names, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	children := map[string][]*Role{}
+	for _, name := range names {
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Extends != "" {
+			children[role.Extends] = append(children[role.Extends], role)
+		}
+	}
+	return children, nil
+}
+
+// checkRoleNotExtended returns an error response when other roles extend
+// role name, so it cannot be deleted
+func (b *Backend) checkRoleNotExtended(ctx context.Context, storage logical.Storage, name string) (*logical.Response, error) {
+	children, err := b.extendingRoles(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(children[name]) == 0 {
+		return nil, nil
+	}
+
+	extending := make([]string, 0, len(children[name]))
+	for _, child := range children[name] {
+		extending = append(extending, child.Name)
+	}
+	slices.Sort(extending)
+	return logical.ErrorResponse("role %q is extended by: %s", name, strings.Join(extending, ", ")), nil
+}
+
+// roleOverrides returns the names of the parameters set on a role that
+// extends another, which override the inherited ones
+func roleOverrides(role *Role) []string {
+	overrides := slices.Collect(maps.Keys(role.Parameters))
+	slices.Sort(overrides)
+	return overrides
+}
@@ -0,0 +1,121 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRole writes role name with data, replacing it if it exists
+func writeRole(t *testing.T, b *Backend, storage logical.Storage, name string, data map[string]any) *logical.Response {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/" + name,
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// readRole reads role name
+func readRole(t *testing.T, b *Backend, storage logical.Storage, name string) map[string]any {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/" + name, Storage: storage})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp.Data
+}
+
+// TestRoleInheritance tests that a role extending another inherits its
+// parameters, overrides the ones it sets and follows changes to the parent
+func TestRoleInheritance(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	baseline := map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{"act": {"sub": "agent-123"}}`,
+		"subject_template": `{"team": "platform"}`,
+		"context":          []string{"urn:documents:read"},
+		"bound_issuer":     "https://idp.example.com",
+	}
+	resp := writeRole(t, b, storage, "baseline", baseline)
+	require.False(t, resp != nil && resp.IsError(), "baseline write failed: %v", resp)
+
+	// The role sets only what differs from the baseline
+	resp = writeRole(t, b, storage, "test-role", map[string]any{
+		"extends":        "baseline",
+		"ttl":            "30m",
+		"actor_template": `{"act": {"sub": "agent-456"}}`,
+	})
+	require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+	role := readRole(t, b, storage, "test-role")
+	require.Equal(t, "baseline", role["extends"])
+	require.Equal(t, []string{"actor_template", "ttl"}, role["overrides"])
+	require.EqualValues(t, 1800, role["ttl"])
+	require.Equal(t, `{"team": "platform"}`, role["subject_template"])
+	require.Equal(t, "https://idp.example.com", role["bound_issuer"])
+	require.Empty(t, readRole(t, b, storage, "baseline")["extends"])
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "agent-456", claims["act"].(map[string]any)["sub"])
+	require.Equal(t, "platform", claims["subject_claims"].(map[string]any)["team"])
+	require.Equal(t, "urn:documents:read", claims["scope"])
+	require.InDelta(t, 1800, claims["exp"].(float64)-claims["iat"].(float64), 1)
+
+	t.Run("parent changes apply", func(t *testing.T) {
+		version := readRole(t, b, storage, "test-role")["cas_version"]
+
+		baseline["context"] = []string{"urn:documents:write"}
+		resp := writeRole(t, b, storage, "baseline", baseline)
+		require.False(t, resp != nil && resp.IsError(), "baseline write failed: %v", resp)
+
+		role := readRole(t, b, storage, "test-role")
+		require.Equal(t, []string{"urn:documents:write"}, role["context"])
+		require.EqualValues(t, 1800, role["ttl"])
+		require.Equal(t, version.(int)+1, role["cas_version"])
+	})
+
+	t.Run("chains", func(t *testing.T) {
+		resp := writeRole(t, b, storage, "agent", map[string]any{"extends": "test-role", "bound_issuer": "https://other.example.com"})
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+
+		role := readRole(t, b, storage, "agent")
+		require.EqualValues(t, 1800, role["ttl"])
+		require.Equal(t, "https://other.example.com", role["bound_issuer"])
+		require.Equal(t, []string{"urn:documents:write"}, role["context"])
+
+		resp = writeRole(t, b, storage, "baseline", mergeRoleParameters(baseline, map[string]any{"extends": "agent"}))
+		require.True(t, resp.IsError(), "a cycle must be rejected")
+		require.Contains(t, resp.Error().Error(), "inherit from itself")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := writeRole(t, b, storage, "missing-parent", map[string]any{"extends": "nope"})
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `role "nope" to extend not found`)
+
+		// A parent change that breaks a role extending it is rejected
+		resp = writeRole(t, b, storage, "test-role", map[string]any{"extends": "baseline", "ttl": "30m", "issue_id_token": true})
+		require.False(t, resp != nil && resp.IsError(), "role write failed: %v", resp)
+		resp = writeRole(t, b, storage, "baseline", mergeRoleParameters(baseline, map[string]any{"detached_payload": true}))
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), `role "test-role" extends "baseline" and would become invalid`)
+		require.Equal(t, false, readRole(t, b, storage, "baseline")["detached_payload"])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.DeleteOperation, Path: "role/baseline", Storage: storage})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		require.Contains(t, resp.Error().Error(), "is extended by: test-role")
+	})
+}
@@ -0,0 +1,236 @@
+package tokenexchange
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// tokenTypeSAML2 is the RFC 8693 token type of base64url-encoded SAML 2.0
+// assertions
+const tokenTypeSAML2 = "urn:ietf:params:oauth:token-type:saml2"
+
+// samlAssertionNamespace is the XML namespace of SAML 2.0 assertions
+const samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+// samlReservedClaims are the claims derived from the assertion itself, which
+// attributes of the same name do not override
+var samlReservedClaims = map[string]bool{
+	"sub": true, "iss": true, "aud": true, "exp": true, "nbf": true,
+	"iat": true, "jti": true, "auth_time": true,
+}
+
+// hasSAMLIDP reports whether the config can validate SAML assertions
+func (c *Config) hasSAMLIDP() bool {
+	return c.SAMLIDPMetadata != "" || len(c.SAMLIDPCertificates) > 0
+}
+
+// samlIDP returns the certificates trusted to sign SAML assertions and, when
+// metadata is configured, the IdP entity ID assertions must be issued by
+func (c *Config) samlIDP() ([]*x509.Certificate, string, error) {
+	var certs []*x509.Certificate
+	entityID := ""
+
+	if c.SAMLIDPMetadata != "" {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromString(c.SAMLIDPMetadata); err != nil {
+			return nil, "", fmt.Errorf("failed to parse saml_idp_metadata: %w", err)
+		}
+		root := doc.Root()
+		if root == nil || root.Tag != "EntityDescriptor" {
+			return nil, "", fmt.Errorf("saml_idp_metadata must be an EntityDescriptor")
+		}
+		entityID = root.SelectAttrValue("entityID", "")
+
+		for _, descriptor := range root.FindElements("./IDPSSODescriptor/KeyDescriptor") {
+			if use := descriptor.SelectAttrValue("use", "signing"); use != "signing" {
+				continue
+			}
+			for _, certElement := range descriptor.FindElements("./KeyInfo/X509Data/X509Certificate") {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certElement.Text()), ""))
+				if err != nil {
+					return nil, "", fmt.Errorf("failed to decode saml_idp_metadata certificate: %w", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, "", fmt.Errorf("failed to parse saml_idp_metadata certificate: %w", err)
+				}
+				certs = append(certs, cert)
+			}
+		}
+		if len(certs) == 0 {
+			return nil, "", fmt.Errorf("saml_idp_metadata has no IdP signing certificates")
+		}
+	}
+
+	for i, certPEM := range c.SAMLIDPCertificates {
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, "", fmt.Errorf("saml_idp_certificates[%d] is not a PEM certificate", i)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse saml_idp_certificates[%d]: %w", i, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, entityID, nil
+}
+
+// validateSAMLAssertion verifies the signature of a base64url-encoded SAML
+// 2.0 assertion and maps it to subject claims: the NameID is sub, the
+// assertion's issuer, audiences, validity and ID are the registered claims,
+// and each attribute is a claim named by its FriendlyName or Name
+func validateSAMLAssertion(config *Config, token string) (map[string]any, error) {
+	// The assertion is bounded before it is decoded and parsed as XML
+	if err := checkSubjectTokenSize(token); err != nil {
+		return nil, err
+	}
+
+	encoded := strings.TrimRight(strings.TrimSpace(token), "=")
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		raw, err = base64.RawStdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("assertion is not base64 encoded: %w", err)
+		}
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, fmt.Errorf("failed to parse assertion: %w", err)
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Assertion" || root.NamespaceURI() != samlAssertionNamespace {
+		return nil, fmt.Errorf("token is not a SAML 2.0 assertion")
+	}
+
+	certs, entityID, err := config.samlIDP()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the element returned by Validate is covered by the signature, so
+	// claims are read from it rather than from the parsed document
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	assertion, err := validator.Validate(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify assertion signature: %w", err)
+	}
+
+	claims := map[string]any{}
+
+	issuer := elementText(assertion, "./Issuer")
+	if issuer == "" {
+		return nil, fmt.Errorf("assertion has no Issuer")
+	}
+	if entityID != "" && issuer != entityID {
+		return nil, fmt.Errorf("assertion issuer %q does not match the IdP entity ID %q", issuer, entityID)
+	}
+	claims["iss"] = issuer
+
+	nameID := elementText(assertion, "./Subject/NameID")
+	if nameID == "" {
+		return nil, fmt.Errorf("assertion has no Subject NameID")
+	}
+	claims["sub"] = nameID
+
+	if id := assertion.SelectAttrValue("ID", ""); id != "" {
+		claims["jti"] = id
+	}
+	if err := setSAMLTime(claims, "iat", assertion.SelectAttrValue("IssueInstant", "")); err != nil {
+		return nil, err
+	}
+
+	// The assertion expires with its conditions, or with its bearer
+	// confirmation when it has no conditions
+	notOnOrAfter := ""
+	if conditions := assertion.FindElement("./Conditions"); conditions != nil {
+		notOnOrAfter = conditions.SelectAttrValue("NotOnOrAfter", "")
+		if err := setSAMLTime(claims, "nbf", conditions.SelectAttrValue("NotBefore", "")); err != nil {
+			return nil, err
+		}
+
+		var audiences []any
+		for _, audience := range conditions.FindElements("./AudienceRestriction/Audience") {
+			audiences = append(audiences, strings.TrimSpace(audience.Text()))
+		}
+		if len(audiences) > 0 {
+			claims["aud"] = audiences
+		}
+	}
+	if notOnOrAfter == "" {
+		if confirmation := assertion.FindElement("./Subject/SubjectConfirmation/SubjectConfirmationData"); confirmation != nil {
+			notOnOrAfter = confirmation.SelectAttrValue("NotOnOrAfter", "")
+		}
+	}
+	if notOnOrAfter == "" {
+		return nil, fmt.Errorf("assertion has no NotOnOrAfter")
+	}
+	if err := setSAMLTime(claims, "exp", notOnOrAfter); err != nil {
+		return nil, err
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && time.Now().Unix() < int64(nbf) {
+		return nil, fmt.Errorf("assertion is not valid before %v", time.Unix(int64(nbf), 0))
+	}
+
+	if authn := assertion.FindElement("./AuthnStatement"); authn != nil {
+		if err := setSAMLTime(claims, "auth_time", authn.SelectAttrValue("AuthnInstant", "")); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, attribute := range assertion.FindElements("./AttributeStatement/Attribute") {
+		name := attribute.SelectAttrValue("FriendlyName", "")
+		if name == "" {
+			name = attribute.SelectAttrValue("Name", "")
+		}
+		if name == "" || samlReservedClaims[name] {
+			continue
+		}
+
+		var values []any
+		for _, value := range attribute.FindElements("./AttributeValue") {
+			values = append(values, strings.TrimSpace(value.Text()))
+		}
+		switch len(values) {
+		case 0:
+		case 1:
+			claims[name] = values[0]
+		default:
+			claims[name] = values
+		}
+	}
+
+	return claims, nil
+}
+
+// elementText returns the trimmed text of the element at path, or "" if there is none
+func elementText(el *etree.Element, path string) string {
+	found := el.FindElement(path)
+	if found == nil {
+		return ""
+	}
+	return strings.TrimSpace(found.Text())
+}
+
+// setSAMLTime sets claim to an xs:dateTime value as unix seconds, like the
+// NumericDate claims of a JWT. Empty values are skipped.
+func setSAMLTime(claims map[string]any, claim, value string) error {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return fmt.Errorf("invalid assertion time %q: %w", value, err)
+	}
+	claims[claim] = float64(t.Unix())
+	return nil
+}
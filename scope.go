@@ -0,0 +1,124 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/ryanuber/go-glob"
+)
+
+// expandScopes returns scopes followed by every scope they imply through the
+// scope definitions, without duplicates. Cycles between definitions are
+// harmless: each scope is expanded once.
+func expandScopes(ctx context.Context, storage logical.Storage, scopes []string) ([]string, error) {
+	expanded := []string{}
+	seen := map[string]bool{}
+	queue := slices.Clone(scopes)
+	for len(queue) > 0 {
+		scope := queue[0]
+		queue = queue[1:]
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		expanded = append(expanded, scope)
+
+		definition, err := getScopeDefinition(ctx, storage, scope)
+		if err != nil {
+			return nil, err
+		}
+		if definition != nil {
+			queue = append(queue, definition.Implies...)
+		}
+	}
+	return expanded, nil
+}
+
+// resolveScopes expands the role's context into the scopes it permits, and
+// narrows them to the requested scope when the request has one (RFC 8693
+// Section 2.1). Requested scopes are expanded too, and each must be permitted.
+func (b *Backend) resolveScopes(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	permitted, err := expandScopes(ctx, ex.req.Storage, ex.role.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := strings.Fields(ex.requestedScope)
+	if len(requested) == 0 {
+		ex.scopes = permitted
+		return nil, nil
+	}
+
+	scopes, err := expandScopes(ctx, ex.req.Storage, requested)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(permitted, scope) {
+			return exchangeError(ErrCodeInvalidScope, "scope %q is not permitted by role %q", scope, ex.roleName), nil
+		}
+	}
+	ex.scopes = scopes
+	return nil, nil
+}
+
+// deniedScopes returns the scopes matching any of the config's
+// globally_denied_scopes patterns. Patterns may use * as a wildcard, e.g.
+// admin:*.
+func deniedScopes(scopes, patterns []string) []string {
+	var denied []string
+	for _, scope := range scopes {
+		for _, pattern := range patterns {
+			if glob.Glob(pattern, scope) {
+				denied = append(denied, scope)
+				break
+			}
+		}
+	}
+	return denied
+}
+
+// authorizeScopes denies exchanges that would issue a globally denied scope,
+// whatever the role's context. Roles are checked when written too, but the
+// deny list or the scope definitions may have changed since.
+func (b *Backend) authorizeScopes(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if denied := deniedScopes(ex.scopes, ex.config.GloballyDeniedScopes); len(denied) > 0 {
+		return exchangeError(ErrCodeAccessDenied, "role %q issues globally denied scopes: %s", ex.roleName, strings.Join(denied, ", ")), nil
+	}
+	return nil, nil
+}
+
+// rolesWithDeniedScopes returns the names of roles whose expanded context
+// includes a scope matching patterns
+func (b *Backend) rolesWithDeniedScopes(ctx context.Context, storage logical.Storage, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	var matching []string
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		scopes, err := expandScopes(ctx, storage, role.Context)
+		if err != nil {
+			return nil, err
+		}
+		if len(deniedScopes(scopes, patterns)) > 0 {
+			matching = append(matching, name)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,190 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeniedScopes tests exact and wildcard scope deny patterns
+func TestDeniedScopes(t *testing.T) {
+	scopes := []string{"documents:read", "admin:users", "admin:keys", "billing:admin"}
+
+	require.Empty(t, deniedScopes(scopes, nil))
+	require.Equal(t, []string{"admin:users", "admin:keys"}, deniedScopes(scopes, []string{"admin:*"}))
+	require.Equal(t, []string{"admin:keys", "billing:admin"}, deniedScopes(scopes, []string{"admin:keys", "*:admin"}))
+	require.Empty(t, deniedScopes(scopes, []string{"documents:write"}))
+}
+
+// TestTokenExchange_GloballyDeniedScopes tests that denied scopes block role
+// writes and the exchanges of roles written before they were denied
+func TestTokenExchange_GloballyDeniedScopes(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"context": "urn:documents:read,admin:users"})
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"globally_denied_scopes": "admin:*"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "test-role")
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+	require.Contains(t, resp.Error().Error(), "admin:users")
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/admin-role",
+		Storage:   storage,
+		Data: map[string]any{
+			"ttl":              "1h",
+			"key":              "test-key",
+			"actor_template":   `{"act": {"sub": "agent"}}`,
+			"subject_template": `{}`,
+			"context":          "admin:keys",
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "globally denied scopes: admin:keys")
+}
+
+// TestTokenExchange_TemplateCannotSetScope tests that actor templates cannot
+// replace the authorized scopes, which would bypass globally denied scopes
+func TestTokenExchange_TemplateCannotSetScope(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := writeJWKSConfig(t, b, storage, map[string]any{"globally_denied_scopes": "admin:*"})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	resp = writeRole(t, b, storage, "test-role", map[string]any{
+		"ttl":              "1h",
+		"key":              "test-key",
+		"actor_template":   `{"act": {"sub": "agent-123"}, "scope": "admin:users"}`,
+		"subject_template": `{}`,
+		"context":          "urn:documents:read",
+	})
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "scope is set from the role's context")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "template_library/with-scope",
+		Storage:   storage,
+		Data:      map[string]any{"actor_template": `{"scope": "admin:users"}`},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	require.Contains(t, resp.Error().Error(), "scope is set from the role's context")
+
+	// Roles written before the check still issue only their context's scopes
+	role, err := b.getRole(ctx, storage, "test-role")
+	require.NoError(t, err)
+	role.ActorTemplate = `{"act": {"sub": "agent-123"}, "scope": "admin:users"}`
+	entry, err := logical.StorageEntryJSON(roleStoragePrefix+"test-role", role)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, "urn:documents:read", resp.Data["scope"])
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "urn:documents:read", claims["scope"])
+}
+
+// writeScope writes a scope definition
+func writeScope(t *testing.T, b *Backend, storage logical.Storage, name, implies string) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "scopes/" + name,
+		Storage:   storage,
+		Data:      map[string]any{"implies": implies},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+// TestExpandScopes tests transitive expansion of scope definitions
+func TestExpandScopes(t *testing.T) {
+	b, storage := getTestBackend(t)
+	writeScope(t, b, storage, "urn:docs:admin", "urn:docs:read,urn:docs:write")
+	writeScope(t, b, storage, "urn:docs:write", "urn:docs:read,urn:docs:comment")
+	writeScope(t, b, storage, "urn:cycle:a", "urn:cycle:b")
+	writeScope(t, b, storage, "urn:cycle:b", "urn:cycle:a")
+
+	expanded, err := expandScopes(context.Background(), storage, []string{"urn:docs:admin", "urn:other"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"urn:docs:admin", "urn:other", "urn:docs:read", "urn:docs:write", "urn:docs:comment"}, expanded)
+
+	expanded, err = expandScopes(context.Background(), storage, []string{"urn:cycle:a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"urn:cycle:a", "urn:cycle:b"}, expanded)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "scopes/urn:docs:admin", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, []string{"urn:docs:read", "urn:docs:write"}, resp.Data["implies"])
+	require.Equal(t, []string{"urn:docs:admin", "urn:docs:read", "urn:docs:write", "urn:docs:comment"}, resp.Data["expanded"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ListOperation, Path: "scopes/", Storage: storage})
+	require.NoError(t, err)
+	require.Len(t, resp.Data["keys"], 4)
+}
+
+// TestTokenExchange_ScopeHierarchy tests that tokens carry the expanded role
+// context and that requested scopes narrow it
+func TestTokenExchange_ScopeHierarchy(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"context": "urn:docs:admin"})
+	writeScope(t, b, storage, "urn:docs:admin", "urn:docs:read,urn:docs:write")
+	writeScope(t, b, storage, "urn:docs:write", "urn:docs:comment")
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, "urn:docs:admin urn:docs:read urn:docs:write urn:docs:comment", resp.Data["scope"])
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, resp.Data["scope"], claims["scope"])
+
+	exchange := func(scope string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+				"scope":         scope,
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp = exchange("urn:docs:write")
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, "urn:docs:write urn:docs:comment", resp.Data["scope"])
+
+	resp = exchange("urn:docs:read urn:billing:read")
+	requireExchangeError(t, resp, ErrCodeInvalidScope, false)
+	require.Contains(t, resp.Error().Error(), "urn:billing:read")
+
+	// Denied scopes are matched against the expanded scopes
+	resp = writeJWKSConfig(t, b, storage, map[string]any{"globally_denied_scopes": "urn:docs:comment"})
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+	require.Len(t, resp.Warnings, 1, "the role's expanded context includes the denied scope")
+	resp = exchange("urn:docs:read")
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	resp = exchange("urn:docs:write")
+	requireExchangeError(t, resp, ErrCodeAccessDenied, false)
+}
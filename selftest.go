@@ -0,0 +1,192 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// selfTestCheck is the outcome of a single self-test check
+type selfTestCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// selfTestKeyID is the kid used for the ephemeral self-test signing key
+const selfTestKeyID = "selftest"
+
+// selfTestSubjectClaims returns synthetic subject token claims used to render role templates
+func selfTestSubjectClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"sub":   "selftest-subject",
+		"email": "selftest@example.com",
+		"name":  "Self Test",
+		"iss":   "https://selftest.invalid",
+		"aud":   []any{"selftest"},
+		"iat":   float64(now.Unix()),
+		"exp":   float64(now.Add(time.Minute).Unix()),
+	}
+}
+
+// selfTestEntity returns a synthetic Vault entity used to render actor templates
+func selfTestEntity() *logical.Entity {
+	return &logical.Entity{
+		ID:          "selftest-entity",
+		Name:        "selftest",
+		NamespaceID: "root",
+		Metadata:    map[string]string{},
+	}
+}
+
+// runSelfTest checks that roles can render their templates against synthetic
+// claims and reference a usable key. When full is set it also generates an
+// ephemeral key and signs and verifies a token end to end.
+func (b *Backend) runSelfTest(ctx context.Context, storage logical.Storage, full bool) ([]selfTestCheck, error) {
+	var checks []selfTestCheck
+
+	if full {
+		check := selfTestCheck{Name: "sign_and_verify", Passed: true}
+		if err := selfTestSignAndVerify(); err != nil {
+			check.Passed = false
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	for _, roleName := range roleNames {
+		check := selfTestCheck{Name: "role/" + roleName, Passed: true}
+		if err := b.selfTestRole(ctx, storage, roleName); err != nil {
+			check.Passed = false
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// selfTestRole renders a role's templates and validates its key reference
+func (b *Backend) selfTestRole(ctx context.Context, storage logical.Storage, roleName string) error {
+	role, err := b.getRole(ctx, storage, roleName)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return fmt.Errorf("role not found")
+	}
+
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	defaultKey := ""
+	if config != nil {
+		defaultKey = config.DefaultKey
+	}
+	config.applyRoleDefaults(role)
+
+	if role.Key == "" && defaultKey == "" {
+		return fmt.Errorf("role has no key and no default_key is configured")
+	}
+	for _, keyName := range roleKeyNames(role, defaultKey) {
+		key, err := b.getKey(ctx, storage, keyName)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("key %q not found", keyName)
+		}
+		if _, err := signatureAlgorithm(key.Algorithm); err != nil {
+			return fmt.Errorf("key %q: %w", keyName, err)
+		}
+		if _, err := b.keySigner(ctx, config, key); err != nil {
+			return fmt.Errorf("key %q: %w", keyName, err)
+		}
+	}
+
+	fragments, err := b.getTemplateFragments(ctx, storage, role.TemplateLibrary)
+	if err != nil {
+		return err
+	}
+
+	if _, err := renderInherited(fragments, actorFragment, role.ActorTemplate, actorTemplateContext(selfTestEntity(), nil, nil)); err != nil {
+		return fmt.Errorf("actor_template: %w", err)
+	}
+
+	if _, err := renderInherited(fragments, subjectFragment, role.SubjectTemplate, subjectTemplateContext(selfTestSubjectClaims(), map[string]any{})); err != nil {
+		return fmt.Errorf("subject_template: %w", err)
+	}
+
+	return nil
+}
+
+// selfTestSignAndVerify signs a token with an ephemeral key and verifies it
+func selfTestSignAndVerify() error {
+	privateKey, err := generateRSAKey(DefaultKeySize)
+	if err != nil {
+		return fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	config := &Config{Issuer: "https://selftest.invalid"}
+	role := &Role{Name: "selftest", TTL: time.Minute}
+	issued, err := generateToken(config, role, nil, "selftest-subject", map[string]any{}, map[string]any{}, privateKey, selfTestKeyID, jose.RS256, "entity:selftest-entity", time.Time{}, time.Time{}, "", nil)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := jwt.ParseSigned(issued.Token, []jose.SignatureAlgorithm{jose.RS256})
+	if err != nil {
+		return fmt.Errorf("failed to parse signed token: %w", err)
+	}
+
+	claims := map[string]any{}
+	if err := parsed.Claims(&privateKey.PublicKey, &claims); err != nil {
+		return fmt.Errorf("failed to verify signed token: %w", err)
+	}
+
+	if claims["sub"] != "selftest-subject" || claims["iss"] != config.Issuer {
+		return fmt.Errorf("signed token has unexpected claims")
+	}
+
+	return nil
+}
+
+// initialize runs the lightweight self-test when the plugin starts so broken
+// roles are reported immediately after an upgrade. Failures are logged and
+// never block initialization.
+func (b *Backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	// Storage is upgraded and the WAL replayed by the primary's active node
+	if b.writesReplicatedStorage() {
+		if err := b.upgradeStorage(ctx, req.Storage); err != nil {
+			b.Logger().Warn("failed to upgrade storage, it is retried on the next start", "error", err)
+		}
+		if err := b.replayWAL(ctx, req.Storage); err != nil {
+			b.Logger().Warn("failed to replay WAL, it is retried periodically", "error", err)
+		}
+	}
+
+	checks, err := b.runSelfTest(ctx, req.Storage, false)
+	if err != nil {
+		b.Logger().Warn("startup self-test could not run", "error", err)
+		return nil
+	}
+
+	for _, check := range checks {
+		if !check.Passed {
+			b.Logger().Warn("startup self-test failed", "check", check.Name, "error", check.Error)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hoisie/mustache"
+)
+
+// Supported subject_claim_source values
+const (
+	// SubjectClaimSourceSubject copies the sub of the subject token
+	SubjectClaimSourceSubject = "subject"
+	// SubjectClaimSourceEmail copies the email of the subject token
+	SubjectClaimSourceEmail = "email"
+	// SubjectClaimSourceTemplate renders subject_claim_template
+	SubjectClaimSourceTemplate = "template"
+	// SubjectClaimSourceEntity uses the ID of the exchanging Vault entity
+	SubjectClaimSourceEntity = "entity"
+)
+
+// errSubjectClaimMissing is returned when the source of the sub claim has no
+// value for an exchange
+var errSubjectClaimMissing = errors.New("no value for the sub claim")
+
+// validateSubjectClaimSource checks a role's subject_claim_source and
+// subject_claim_template
+func validateSubjectClaimSource(source, template string) error {
+	switch source {
+	case SubjectClaimSourceSubject, SubjectClaimSourceEmail, SubjectClaimSourceEntity:
+		if template != "" {
+			return fmt.Errorf("subject_claim_template requires subject_claim_source %q", SubjectClaimSourceTemplate)
+		}
+	case SubjectClaimSourceTemplate:
+		if template == "" {
+			return fmt.Errorf("subject_claim_source %q requires subject_claim_template", SubjectClaimSourceTemplate)
+		}
+		if _, err := mustache.ParseString(template); err != nil {
+			return fmt.Errorf("failed to parse subject_claim_template: %w", err)
+		}
+	default:
+		return fmt.Errorf("subject_claim_source must be %q, %q, %q or %q", SubjectClaimSourceSubject, SubjectClaimSourceEmail, SubjectClaimSourceTemplate, SubjectClaimSourceEntity)
+	}
+	return nil
+}
+
+// subjectClaimContext builds the data available to subject_claim_template:
+// the entity and groups of the actor template and the subject token claims
+// and directory attributes of the subject template
+func subjectClaimContext(entity *logical.Entity, groups []*logical.Group, subjectClaims, directoryAttrs map[string]any) map[string]any {
+	identity := actorTemplateContext(entity, groups, nil)["identity"].(map[string]map[string]any)
+	for name, values := range subjectTemplateContext(subjectClaims, directoryAttrs)["identity"].(map[string]map[string]any) {
+		identity[name] = values
+	}
+	return map[string]any{"identity": identity}
+}
+
+// resolveSubjectClaim returns the sub claim of a token issued by the role.
+// It returns an error wrapping errSubjectClaimMissing when the source has no
+// value.
+func resolveSubjectClaim(role *Role, entity *logical.Entity, groups []*logical.Group, subjectClaims, directoryAttrs map[string]any) (string, error) {
+	var subject string
+	switch role.SubjectClaimSource {
+	case "", SubjectClaimSourceSubject:
+		subject, _ = subjectClaims["sub"].(string)
+	case SubjectClaimSourceEmail:
+		subject, _ = subjectClaims["email"].(string)
+		if subject == "" {
+			return "", fmt.Errorf("%w: the subject token has no email", errSubjectClaimMissing)
+		}
+	case SubjectClaimSourceEntity:
+		subject = entity.ID
+	case SubjectClaimSourceTemplate:
+		tmpl, err := mustache.ParseString(role.SubjectClaimTemplate)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse subject_claim_template: %w", err)
+		}
+		// Mustache HTML-escapes output, but sub is not HTML
+		values := jsonifyClaimsMap(subjectClaimContext(entity, groups, subjectClaims, directoryAttrs))
+		subject = strings.TrimSpace(html.UnescapeString(tmpl.Render(values)))
+		if subject == "" {
+			return "", fmt.Errorf("%w: subject_claim_template rendered an empty value", errSubjectClaimMissing)
+		}
+	default:
+		return "", fmt.Errorf("unsupported subject_claim_source %q", role.SubjectClaimSource)
+	}
+	return subject, nil
+}
+
+// resolveSubject sets the sub claim of the exchange from the role's
+// subject_claim_source
+func (b *Backend) resolveSubject(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	subject, err := resolveSubjectClaim(ex.role, ex.entity, ex.groups, ex.subjectClaims, ex.directoryAttrs)
+	if errors.Is(err, errSubjectClaimMissing) {
+		return exchangeError(ErrCodeInvalidSubjectToken, "role %q: %v", ex.roleName, err), nil
+	}
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "role %q: %v", ex.roleName, err), nil
+	}
+
+	ex.subject = subject
+	return nil, nil
+}
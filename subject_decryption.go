@@ -0,0 +1,190 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// subjectKeyAlgorithms are the JWE key management algorithms accepted on
+// encrypted subject tokens for each kind of decryption key. RSA1_5 is never
+// accepted.
+var (
+	subjectRSAKeyAlgorithms     = []jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256}
+	subjectECKeyAlgorithms      = []jose.KeyAlgorithm{jose.ECDH_ES, jose.ECDH_ES_A128KW, jose.ECDH_ES_A192KW, jose.ECDH_ES_A256KW}
+	subjectTransitKeyAlgorithms = []jose.KeyAlgorithm{jose.RSA_OAEP_256}
+)
+
+// subjectContentEncryptions are the JWE content encryptions accepted on
+// encrypted subject tokens
+var subjectContentEncryptions = []jose.ContentEncryption{
+	jose.A128GCM, jose.A192GCM, jose.A256GCM,
+	jose.A128CBC_HS256, jose.A192CBC_HS384, jose.A256CBC_HS512,
+}
+
+// parseSubjectDecryptionKey parses subject_decryption_key, a PEM RSA or EC
+// private key
+func parseSubjectDecryptionKey(keyPEM string) (any, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(keyPEM)))
+	if block == nil {
+		return nil, fmt.Errorf("subject_decryption_key: failed to decode PEM block")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("subject_decryption_key: unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("subject_decryption_key: %w", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("subject_decryption_key must be an RSA or EC private key, got %T", key)
+	}
+}
+
+// parseTransitKeyRef splits subject_decryption_transit_key, of the form
+// <transit mount>/<key name>
+func parseTransitKeyRef(ref string) (string, string, error) {
+	ref = strings.Trim(ref, "/")
+	i := strings.LastIndex(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", fmt.Errorf("subject_decryption_transit_key must have the form <transit mount>/<key name>")
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// subjectDecryptionPublicKey returns the PEM public key of
+// subject_decryption_key, for IdPs to encrypt subject tokens to. It is empty
+// when no key is configured.
+func (c *Config) subjectDecryptionPublicKey() string {
+	if c.SubjectDecryptionKey == "" {
+		return ""
+	}
+	key, err := parseSubjectDecryptionKey(c.SubjectDecryptionKey)
+	if err != nil {
+		return ""
+	}
+
+	var public any
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		public = &k.PublicKey
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// isCompactJWE reports whether token has the five parts of a compact JWE
+func isCompactJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// decryptSubjectToken returns the nested JWT of a JWE subject token,
+// decrypted with subject_decryption_key or subject_decryption_transit_key.
+// Tokens that are not JWEs are returned unchanged.
+func (b *Backend) decryptSubjectToken(ctx context.Context, config *Config, token string) (string, error) {
+	if !isCompactJWE(token) {
+		return token, nil
+	}
+	if err := checkSubjectTokenSize(token); err != nil {
+		return "", err
+	}
+
+	var keyAlgorithms []jose.KeyAlgorithm
+	var decryptionKey any
+	var transitDecrypter *transitKeyDecrypter
+	switch {
+	case config.SubjectDecryptionKey != "":
+		key, err := parseSubjectDecryptionKey(config.SubjectDecryptionKey)
+		if err != nil {
+			return "", err
+		}
+		keyAlgorithms = subjectRSAKeyAlgorithms
+		if _, ok := key.(*ecdsa.PrivateKey); ok {
+			keyAlgorithms = subjectECKeyAlgorithms
+		}
+		decryptionKey = key
+	case config.SubjectDecryptionTransitKey != "":
+		mount, name, err := parseTransitKeyRef(config.SubjectDecryptionTransitKey)
+		if err != nil {
+			return "", err
+		}
+		client, err := b.newTransitClient(config, &Key{TransitMount: mount, TransitKey: name})
+		if err != nil {
+			return "", err
+		}
+		keyAlgorithms = subjectTransitKeyAlgorithms
+		transitDecrypter = &transitKeyDecrypter{ctx: ctx, client: client}
+		decryptionKey = transitDecrypter
+	default:
+		return "", fmt.Errorf("encrypted subject tokens require subject_decryption_key or subject_decryption_transit_key in the config")
+	}
+
+	encrypted, err := jose.ParseEncryptedCompact(token, keyAlgorithms, subjectContentEncryptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse encrypted subject token: %w", err)
+	}
+	if _, ok := encrypted.Header.ExtraHeaders["crit"]; ok {
+		return "", fmt.Errorf("encrypted subject token has critical header parameters, which are not supported")
+	}
+	plaintext, err := encrypted.Decrypt(decryptionKey)
+	// go-jose hides why a content key could not be unwrapped
+	if err != nil && transitDecrypter != nil && errors.Is(transitDecrypter.err, errTransitUnavailable) {
+		return "", fmt.Errorf("failed to decrypt subject token: %w", transitDecrypter.err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt subject token: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// transitKeyDecrypter unwraps JWE content keys with a transit key. It
+// implements jose.OpaqueKeyDecrypter.
+type transitKeyDecrypter struct {
+	ctx    context.Context
+	client *transitClient
+
+	// err is the last transit failure
+	err error
+}
+
+// DecryptKey decrypts the JWE encrypted key. A numeric kid selects the
+// transit key version; otherwise the latest version is used.
+func (d *transitKeyDecrypter) DecryptKey(encryptedKey []byte, header jose.Header) ([]byte, error) {
+	version, err := strconv.Atoi(header.KeyID)
+	if err != nil || version <= 0 {
+		version, _, err = d.client.readKey(d.ctx)
+		if err != nil {
+			d.err = err
+			return nil, err
+		}
+	}
+	key, err := d.client.decrypt(d.ctx, version, encryptedKey)
+	d.err = err
+	return key, err
+}
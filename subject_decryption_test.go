@@ -0,0 +1,167 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptTestToken wraps a subject JWT in a JWE for recipient
+func encryptTestToken(t *testing.T, token string, alg jose.KeyAlgorithm, recipient any, kid string) string {
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: alg, Key: recipient, KeyID: kid}, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+	require.NoError(t, err)
+	encrypted, err := encrypter.Encrypt([]byte(token))
+	require.NoError(t, err)
+	serialized, err := encrypted.CompactSerialize()
+	require.NoError(t, err)
+	return serialized
+}
+
+// TestTokenExchange_EncryptedSubjectToken tests that JWE subject tokens are
+// decrypted with the configured key and verified as the JWT they carry
+func TestTokenExchange_EncryptedSubjectToken(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+
+	exchange := func(token string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": token},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	writeConfig := func(data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "config", Storage: storage, Data: data})
+		require.NoError(t, err)
+		return resp
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	// Without a decryption key encrypted tokens are rejected
+	resp := exchange(encryptTestToken(t, subjectToken, jose.RSA_OAEP_256, &rsaKey.PublicKey, ""))
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	resp = writeConfig(map[string]any{"subject_decryption_key": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))})
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, true, resp.Data["subject_decryption_key_configured"])
+	require.Contains(t, resp.Data["subject_decryption_public_key"], "BEGIN PUBLIC KEY")
+	require.NotContains(t, resp.Data, "subject_decryption_key")
+
+	resp = exchange(encryptTestToken(t, subjectToken, jose.RSA_OAEP_256, &rsaKey.PublicKey, ""))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, "user-123", parseIssuedToken(t, b, storage, resp.Data["token"].(string))["sub"])
+
+	// Plain JWTs are still accepted, and RSA1_5 never is
+	resp = exchange(subjectToken)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	resp = exchange(encryptTestToken(t, subjectToken, jose.RSA1_5, &rsaKey.PublicKey, ""))
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	// The nested JWT must still verify
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	resp = exchange(encryptTestToken(t, generateTestJWT(t, otherKey, kid, defaultSubjectClaims()), jose.RSA_OAEP, &rsaKey.PublicKey, ""))
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	// Oversized tokens are rejected before they are decrypted, and so is an
+	// oversized JWT inside a compressed JWE
+	resp = exchange("eyJhbGciOiJSU0EtT0FFUC0yNTYifQ.a.b.c." + strings.Repeat("a", maxSubjectTokenSize))
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "byte limit")
+
+	claims := defaultSubjectClaims()
+	claims["padding"] = strings.Repeat("a", maxSubjectTokenSize)
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.RSA_OAEP_256, Key: &rsaKey.PublicKey}, &jose.EncrypterOptions{Compression: jose.DEFLATE})
+	require.NoError(t, err)
+	encrypted, err := encrypter.Encrypt([]byte(generateTestJWT(t, privateKey, kid, claims)))
+	require.NoError(t, err)
+	compressed, err := encrypted.CompactSerialize()
+	require.NoError(t, err)
+	require.Less(t, len(compressed), maxSubjectTokenSize)
+	resp = exchange(compressed)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+	require.Contains(t, resp.Error().Error(), "byte limit")
+
+	resp = writeConfig(map[string]any{"subject_decryption_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))})
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+	resp = exchange(encryptTestToken(t, subjectToken, jose.ECDH_ES_A256KW, &ecKey.PublicKey, ""))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	resp = writeConfig(map[string]any{"subject_decryption_transit_key": "transit/signer"})
+	require.True(t, resp.IsError(), "a key and a transit key cannot both be set")
+	resp = writeConfig(map[string]any{"subject_decryption_key": "not a key"})
+	require.True(t, resp.IsError())
+}
+
+// TestTokenExchange_EncryptedSubjectTokenTransit tests decryption of JWE
+// subject tokens with a transit key
+func TestTokenExchange_EncryptedSubjectTokenTransit(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	server, transit := createMockTransitServer(t)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]any{
+			"api_addr":                       server.URL,
+			"transit_token":                  testTransitToken,
+			"subject_decryption_transit_key": "transit/signer",
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "config write failed: %v", resp.Error())
+
+	exchange := func(token string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": token},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	firstVersion := &transit.versions[0].PublicKey
+	resp = exchange(encryptTestToken(t, subjectToken, jose.RSA_OAEP_256, firstVersion, ""))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	// A numeric kid selects an older version after rotation
+	transit.addVersion()
+	resp = exchange(encryptTestToken(t, subjectToken, jose.RSA_OAEP_256, firstVersion, "1"))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	transit.failing.Store(true)
+	resp = exchange(encryptTestToken(t, subjectToken, jose.RSA_OAEP_256, firstVersion, "1"))
+	requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+}
@@ -0,0 +1,41 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// subjectTokenHash returns the sub_tkn#S256 fingerprint of a subject token:
+// the base64url SHA-256 hash of the token exactly as it was presented
+func subjectTokenHash(subjectToken string) string {
+	sum := sha256.Sum256([]byte(subjectToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// addSubjectFingerprint adds the orig_jti and sub_tkn#S256 claims when the
+// role requests them, so the token can be tied back to the exact subject
+// token it was derived from. Refreshes use the hash recorded by the original
+// exchange. orig_jti is omitted for subject tokens without a jti, and
+// sub_tkn#S256 for simulated exchanges, which have no token.
+func (b *Backend) addSubjectFingerprint(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.IncludeSubjectFingerprint {
+		return nil, nil
+	}
+
+	if ex.actorClaims == nil {
+		ex.actorClaims = map[string]any{}
+	}
+	if jti, ok := ex.subjectClaims["jti"].(string); ok && jti != "" {
+		ex.actorClaims["orig_jti"] = jti
+	}
+	switch {
+	case ex.subjectToken != "":
+		ex.actorClaims["sub_tkn#S256"] = subjectTokenHash(ex.subjectToken)
+	case ex.subjectTokenHash != "":
+		ex.actorClaims["sub_tkn#S256"] = ex.subjectTokenHash
+	}
+	return nil, nil
+}
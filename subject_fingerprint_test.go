@@ -0,0 +1,52 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_SubjectFingerprint tests that the subject token's jti and
+// hash are issued when the role requests them
+func TestTokenExchange_SubjectFingerprint(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"include_subject_fingerprint": true})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{Operation: logical.ReadOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+	require.Equal(t, true, resp.Data["include_subject_fingerprint"])
+
+	exchange := func(subjectToken string) map[string]any {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": subjectToken},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+		return parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	}
+
+	subjectClaims := defaultSubjectClaims()
+	subjectClaims["jti"] = "upstream-jti-1"
+	subjectToken := generateTestJWT(t, privateKey, kid, subjectClaims)
+	claims := exchange(subjectToken)
+	require.Equal(t, "upstream-jti-1", claims["orig_jti"])
+	require.Equal(t, subjectTokenHash(subjectToken), claims["sub_tkn#S256"])
+	require.Len(t, claims["sub_tkn#S256"], 43)
+
+	subjectToken = generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	claims = exchange(subjectToken)
+	require.NotContains(t, claims, "orig_jti")
+	require.Equal(t, subjectTokenHash(subjectToken), claims["sub_tkn#S256"])
+
+	resp = writeProfileRole(t, b, storage, "", nil)
+	require.False(t, resp.IsError())
+	claims = exchange(generateTestJWT(t, privateKey, kid, subjectClaims))
+	require.NotContains(t, claims, "orig_jti")
+	require.NotContains(t, claims, "sub_tkn#S256")
+}
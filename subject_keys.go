@@ -0,0 +1,92 @@
+package tokenexchange
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// hasStaticSubjectKeys reports whether subject tokens are validated against
+// keys held in the config rather than fetched from subject_jwks_uri
+func (c *Config) hasStaticSubjectKeys() bool {
+	return c.SubjectJWKS != "" || len(c.SubjectPublicKeys) > 0
+}
+
+// staticSubjectKeySet builds the key set from the inline subject_jwks document
+// or subject_public_keys. Keys from PEM have no key ID and are tried in turn
+// against tokens whose kid is not otherwise found.
+func (c *Config) staticSubjectKeySet() (*jose.JSONWebKeySet, error) {
+	keySet := &jose.JSONWebKeySet{}
+
+	if c.SubjectJWKS != "" {
+		if err := json.Unmarshal([]byte(c.SubjectJWKS), keySet); err != nil {
+			return nil, fmt.Errorf("failed to parse subject_jwks: %w", err)
+		}
+		for _, key := range keySet.Keys {
+			if !key.IsPublic() {
+				return nil, fmt.Errorf("subject_jwks key %q is not a public key", key.KeyID)
+			}
+		}
+	}
+
+	for i, publicKeyPEM := range c.SubjectPublicKeys {
+		publicKey, err := parseSubjectPublicKey(publicKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subject_public_keys[%d]: %w", i, err)
+		}
+		keySet.Keys = append(keySet.Keys, jose.JSONWebKey{Key: publicKey, Use: "sig"})
+	}
+
+	if len(keySet.Keys) == 0 {
+		return nil, fmt.Errorf("no static subject keys configured")
+	}
+
+	return keySet, nil
+}
+
+// parseSubjectPublicKey parses a PEM-encoded RSA, ECDSA or Ed25519 public key
+// or the public key of a PEM-encoded certificate
+func parseSubjectPublicKey(publicKeyPEM string) (any, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(publicKeyPEM)))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+
+	var publicKey any
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = parsed
+	case "RSA PUBLIC KEY":
+		parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = parsed
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = cert.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
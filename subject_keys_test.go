@@ -0,0 +1,167 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// writeStaticSubjectConfig writes a config that validates subject tokens with static keys
+func writeStaticSubjectConfig(t *testing.T, b *Backend, storage logical.Storage, data map[string]any) *logical.Response {
+	data["issuer"] = "https://vault.example.com"
+	data["allowed_subject_token_algorithms"] = "RS256,ES256"
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      data,
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// encodePKIXPublicKeyPEM PEM-encodes a public key as PKIX
+func encodePKIXPublicKeyPEM(t *testing.T, publicKey any) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// TestTokenExchange_StaticSubjectKeys tests validating subject tokens without a JWKS fetch
+func TestTokenExchange_StaticSubjectKeys(t *testing.T) {
+	rsaKey, _ := generateTestKeyPair(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	inlineJWKS, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &rsaKey.PublicKey, KeyID: "inline-kid", Use: "sig"},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		config    map[string]any
+		algorithm jose.SignatureAlgorithm
+		key       any
+		kid       string
+		wantErr   bool
+	}{
+		{
+			name:      "inline jwks",
+			config:    map[string]any{"subject_jwks": string(inlineJWKS)},
+			algorithm: jose.RS256, key: rsaKey, kid: "inline-kid",
+		},
+		{
+			name:      "inline jwks unknown kid",
+			config:    map[string]any{"subject_jwks": string(inlineJWKS)},
+			algorithm: jose.RS256, key: rsaKey, kid: "other-kid",
+			wantErr: true,
+		},
+		{
+			name:      "pem keys with any kid",
+			config:    map[string]any{"subject_public_keys": []string{encodePKIXPublicKeyPEM(t, &ecKey.PublicKey), encodePKIXPublicKeyPEM(t, &rsaKey.PublicKey)}},
+			algorithm: jose.RS256, key: rsaKey, kid: "idp-kid",
+		},
+		{
+			name:      "pem ec key without kid",
+			config:    map[string]any{"subject_public_keys": []string{encodePKIXPublicKeyPEM(t, &ecKey.PublicKey)}},
+			algorithm: jose.ES256, key: ecKey,
+		},
+		{
+			name:      "pem key mismatch",
+			config:    map[string]any{"subject_public_keys": []string{encodePKIXPublicKeyPEM(t, &ecKey.PublicKey)}},
+			algorithm: jose.RS256, key: rsaKey,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			setupTestExchange(t, b, storage, nil)
+
+			resp := writeStaticSubjectConfig(t, b, storage, tt.config)
+			require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+			opts := (&jose.SignerOptions{}).WithType("JWT")
+			if tt.kid != "" {
+				opts = opts.WithHeader("kid", tt.kid)
+			}
+			signer, err := jose.NewSigner(jose.SigningKey{Algorithm: tt.algorithm, Key: tt.key}, opts)
+			require.NoError(t, err)
+			subjectToken, err := jwt.Signed(signer).Claims(defaultSubjectClaims()).Serialize()
+			require.NoError(t, err)
+
+			resp = exchangeSubjectToken(t, b, storage, subjectToken)
+			if tt.wantErr {
+				requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+				return
+			}
+			require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+			// No fetch happens for static keys
+			jwksFetch := b.stats.snapshot()["jwks_fetch"].(map[string]any)
+			require.Equal(t, uint64(0), jwksFetch["total"])
+		})
+	}
+}
+
+// TestPathConfig_StaticSubjectKeysValidation tests config validation of static subject keys
+func TestPathConfig_StaticSubjectKeysValidation(t *testing.T) {
+	rsaKey, _ := generateTestKeyPair(t)
+
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr string
+	}{
+		{
+			name:    "combined with uri",
+			config:  map[string]any{"subject_jwks_uri": "https://idp.example.com/jwks", "subject_public_keys": []string{encodePKIXPublicKeyPEM(t, &rsaKey.PublicKey)}},
+			wantErr: "cannot be combined",
+		},
+		{
+			name:    "invalid pem",
+			config:  map[string]any{"subject_public_keys": []string{"not a key"}},
+			wantErr: "failed to parse subject_public_keys[0]",
+		},
+		{
+			name:    "invalid jwks",
+			config:  map[string]any{"subject_jwks": "{"},
+			wantErr: "failed to parse subject_jwks",
+		},
+		{
+			name:    "private key in jwks",
+			config:  map[string]any{"subject_jwks": mustMarshalJWKS(t, jose.JSONWebKey{Key: rsaKey, KeyID: "private"})},
+			wantErr: `subject_jwks key "private" is not a public key`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+
+			resp := writeStaticSubjectConfig(t, b, storage, tt.config)
+			require.NotNil(t, resp)
+			require.True(t, resp.IsError())
+			require.Contains(t, resp.Error().Error(), tt.wantErr)
+		})
+	}
+}
+
+// mustMarshalJWKS encodes keys as a JWKS document
+func mustMarshalJWKS(t *testing.T, keys ...jose.JSONWebKey) string {
+	encoded, err := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	require.NoError(t, err)
+	return string(encoded)
+}
@@ -0,0 +1,97 @@
+package tokenexchange
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// maxSubjectTokenSize bounds the size of a subject token, and of the JWT an
+// encrypted one carries, so oversized tokens are rejected before any parsing,
+// decryption or key lookups
+const maxSubjectTokenSize = 64 << 10
+
+// checkSubjectTokenSize rejects subject tokens larger than maxSubjectTokenSize
+func checkSubjectTokenSize(token string) error {
+	if len(token) > maxSubjectTokenSize {
+		return fmt.Errorf("subject token is %d bytes, larger than the %d byte limit", len(token), maxSubjectTokenSize)
+	}
+	return nil
+}
+
+// checkSubjectTokenHeader rejects subject JWTs that are too large, unsigned,
+// or carry critical header parameters. No extension is understood for
+// subject tokens, so any crit header must be rejected (RFC 7515 Section
+// 4.1.11).
+func checkSubjectTokenHeader(token string) error {
+	if err := checkSubjectTokenSize(token); err != nil {
+		return err
+	}
+
+	encoded, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("subject token is not a compact JWS")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode subject token header: %w", err)
+	}
+	header := map[string]any{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return fmt.Errorf("failed to decode subject token header: %w", err)
+	}
+
+	if alg, _ := header["alg"].(string); alg == "" || strings.EqualFold(alg, "none") {
+		return fmt.Errorf("unsigned subject tokens are not accepted")
+	}
+	if _, ok := header["crit"]; ok {
+		return fmt.Errorf("subject token has critical header parameters %v, which are not supported", header["crit"])
+	}
+
+	return nil
+}
+
+// keyAllowsAlgorithm reports whether a token signed with alg may be verified
+// with key. A key that declares an alg only verifies that alg; otherwise the
+// alg must suit the key type, so a key is never used with an algorithm its
+// issuer did not intend.
+func keyAllowsAlgorithm(key jose.JSONWebKey, alg string) error {
+	if key.Algorithm != "" {
+		if key.Algorithm != alg {
+			return fmt.Errorf("token alg %s does not match the key's alg %s", alg, key.Algorithm)
+		}
+		return nil
+	}
+
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS") {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if expected, ok := ecdsaAlgorithms[k.Curve]; ok && expected == alg {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if alg == string(jose.EdDSA) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported subject key type %T", key.Key)
+	}
+	return fmt.Errorf("token alg %s cannot be used with a %T key", alg, key.Key)
+}
+
+// ecdsaAlgorithms maps each curve to the only JWS algorithm that uses it
+var ecdsaAlgorithms = map[elliptic.Curve]string{
+	elliptic.P256(): string(jose.ES256),
+	elliptic.P384(): string(jose.ES384),
+	elliptic.P521(): string(jose.ES512),
+}
@@ -0,0 +1,123 @@
+package tokenexchange
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// unsignedTestJWT returns a token with the given header and no signature
+func unsignedTestJWT(header string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-123"}`)) + "."
+}
+
+func TestCheckSubjectTokenHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "signed", token: unsignedTestJWT(`{"alg":"RS256","kid":"k"}`)},
+		{name: "alg none", token: unsignedTestJWT(`{"alg":"none"}`), wantErr: "unsigned"},
+		{name: "alg None", token: unsignedTestJWT(`{"alg":"None"}`), wantErr: "unsigned"},
+		{name: "missing alg", token: unsignedTestJWT(`{"kid":"k"}`), wantErr: "unsigned"},
+		{name: "crit", token: unsignedTestJWT(`{"alg":"RS256","crit":["exp"],"exp":1}`), wantErr: "critical header"},
+		{name: "crit b64", token: unsignedTestJWT(`{"alg":"RS256","crit":["b64"],"b64":false}`), wantErr: "critical header"},
+		{name: "not a JWS", token: "opaque", wantErr: "not a compact JWS"},
+		{name: "too large", token: unsignedTestJWT(`{"alg":"RS256"}`) + strings.Repeat("a", maxSubjectTokenSize), wantErr: "byte limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSubjectTokenHeader(tt.token)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestKeyAllowsAlgorithm(t *testing.T) {
+	rsaKey, _ := generateTestKeyPair(t)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		key     jose.JSONWebKey
+		alg     jose.SignatureAlgorithm
+		allowed bool
+	}{
+		{name: "declared alg", key: jose.JSONWebKey{Key: &rsaKey.PublicKey, Algorithm: "RS256"}, alg: jose.RS256, allowed: true},
+		{name: "other alg than declared", key: jose.JSONWebKey{Key: &rsaKey.PublicKey, Algorithm: "RS256"}, alg: jose.PS256},
+		{name: "rsa pss", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: jose.PS384, allowed: true},
+		{name: "rsa with ecdsa alg", key: jose.JSONWebKey{Key: &rsaKey.PublicKey}, alg: jose.ES256},
+		{name: "p256", key: jose.JSONWebKey{Key: &p256.PublicKey}, alg: jose.ES256, allowed: true},
+		{name: "p256 with ES384", key: jose.JSONWebKey{Key: &p256.PublicKey}, alg: jose.ES384},
+		{name: "ed25519", key: jose.JSONWebKey{Key: edPublic}, alg: jose.EdDSA, allowed: true},
+		{name: "ed25519 with RS256", key: jose.JSONWebKey{Key: edPublic}, alg: jose.RS256},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keyAllowsAlgorithm(tt.key, string(tt.alg))
+			if tt.allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestTokenExchange_SubjectTokenHardening tests that crafted subject tokens
+// are rejected before they are exchanged
+func TestTokenExchange_SubjectTokenHardening(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+	jwksServer := createMockJWKSServer(t, &privateKey.PublicKey, kid)
+	t.Cleanup(jwksServer.Close)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"subject_jwks_uri": jwksServer.URL, "allowed_subject_token_algorithms": "RS256,PS256"}))
+
+	sign := func(alg jose.SignatureAlgorithm, opts *jose.SignerOptions) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: privateKey}, opts.WithType("JWT").WithHeader("kid", kid))
+		require.NoError(t, err)
+		token, err := jwt.Signed(signer).Claims(defaultSubjectClaims()).Serialize()
+		require.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "alg none", token: unsignedTestJWT(`{"alg":"none","kid":"` + kid + `"}`), wantErr: "unsigned"},
+		{name: "crit", token: sign(jose.RS256, (&jose.SignerOptions{}).WithCritical("exp").WithHeader("exp", 1)), wantErr: "critical header"},
+		{name: "alg confusion", token: sign(jose.PS256, &jose.SignerOptions{}), wantErr: "does not match the key's alg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := exchangeSubjectToken(t, b, storage, tt.token)
+			requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+			require.Contains(t, resp.Error().Error(), tt.wantErr)
+		})
+	}
+
+	resp := exchangeSubjectToken(t, b, storage, sign(jose.RS256, &jose.SignerOptions{}))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+}
@@ -0,0 +1,190 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// SubjectTokenValidator verifies subject tokens of one format and returns
+// their claims. An exchange uses the first registered validator that accepts
+// the subject_token_type and is configured.
+type SubjectTokenValidator interface {
+	// Name uniquely identifies the validator
+	Name() string
+
+	// TokenTypes lists the subject_token_type values the validator accepts
+	TokenTypes() []string
+
+	// Configured returns an error naming the missing settings when the config
+	// does not enable the validator
+	Configured(config *Config) error
+
+	// Validate verifies the token and returns its claims. Errors wrapping
+	// errJWKSUnavailable and the other unavailability errors fail the exchange
+	// as retryable.
+	Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error)
+}
+
+// RegisterSubjectTokenValidator adds a validator, ahead of those already
+// registered, so new token formats can be accepted without changing the
+// exchange. It must be called before the backend serves requests.
+func (b *Backend) RegisterSubjectTokenValidator(validator SubjectTokenValidator) error {
+	for _, registered := range b.subjectValidators {
+		if registered.Name() == validator.Name() {
+			return fmt.Errorf("subject token validator %q is already registered", validator.Name())
+		}
+	}
+
+	b.subjectValidators = append([]SubjectTokenValidator{validator}, b.subjectValidators...)
+	return nil
+}
+
+// defaultSubjectTokenValidators returns the built-in validators. A config
+// sets at most one JWT key source, so their order does not matter.
+func defaultSubjectTokenValidators(b *Backend) []SubjectTokenValidator {
+	return []SubjectTokenValidator{
+		&staticKeysValidator{b: b},
+		&oidcDiscoveryValidator{b: b},
+		&jwksValidator{b: b},
+		&introspectionValidator{},
+		&samlValidator{},
+		&vaultTokenValidator{b: b},
+	}
+}
+
+// subjectTokenValidator selects the validator for subject tokens of tokenType
+func (b *Backend) subjectTokenValidator(config *Config, tokenType string) (SubjectTokenValidator, error) {
+	var missing []string
+	for _, validator := range b.subjectValidators {
+		if !acceptsTokenType(validator, tokenType) {
+			continue
+		}
+		err := validator.Configured(config)
+		if err == nil {
+			return validator, nil
+		}
+		missing = append(missing, err.Error())
+	}
+
+	if len(missing) == 0 {
+		return nil, fmt.Errorf("unsupported subject_token_type %q", tokenType)
+	}
+	return nil, fmt.Errorf("subject_token_type %q requires %s in the config", tokenType, strings.Join(missing, ", or "))
+}
+
+// acceptsTokenType reports whether the validator accepts tokenType
+func acceptsTokenType(validator SubjectTokenValidator, tokenType string) bool {
+	for _, accepted := range validator.TokenTypes() {
+		if accepted == tokenType {
+			return true
+		}
+	}
+	return false
+}
+
+// staticKeysValidator verifies JWTs with subject_jwks or subject_public_keys
+type staticKeysValidator struct {
+	b *Backend
+}
+
+func (v *staticKeysValidator) Name() string { return "static_keys" }
+
+func (v *staticKeysValidator) TokenTypes() []string { return []string{tokenTypeJWT} }
+
+func (v *staticKeysValidator) Configured(config *Config) error {
+	if !config.hasStaticSubjectKeys() {
+		return fmt.Errorf("subject_jwks or subject_public_keys")
+	}
+	return nil
+}
+
+func (v *staticKeysValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	parsedToken, err := v.b.parseSubjectJWT(ctx, config, token)
+	if err != nil {
+		return nil, err
+	}
+	return verifyWithStaticKeys(config, parsedToken)
+}
+
+// jwksValidator verifies JWTs with the JWKS fetched from subject_jwks_uri
+type jwksValidator struct {
+	b *Backend
+}
+
+func (v *jwksValidator) Name() string { return "jwks" }
+
+func (v *jwksValidator) TokenTypes() []string { return []string{tokenTypeJWT} }
+
+func (v *jwksValidator) Configured(config *Config) error {
+	if config.SubjectJWKSURI == "" {
+		return fmt.Errorf("subject_jwks_uri")
+	}
+	return nil
+}
+
+func (v *jwksValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	parsedToken, err := v.b.parseSubjectJWT(ctx, config, token)
+	if err != nil {
+		return nil, err
+	}
+	return v.b.verifyWithSubjectJWKS(ctx, storage, config, parsedToken)
+}
+
+// introspectionValidator validates opaque access tokens at introspection_url
+type introspectionValidator struct{}
+
+func (v *introspectionValidator) Name() string { return "introspection" }
+
+func (v *introspectionValidator) TokenTypes() []string { return []string{tokenTypeAccessToken} }
+
+func (v *introspectionValidator) Configured(config *Config) error {
+	if config.IntrospectionURL == "" {
+		return fmt.Errorf("introspection_url")
+	}
+	return nil
+}
+
+func (v *introspectionValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	return introspectSubjectToken(ctx, config, token)
+}
+
+// samlValidator verifies SAML 2.0 assertions signed by the configured IdP
+type samlValidator struct{}
+
+func (v *samlValidator) Name() string { return "saml" }
+
+func (v *samlValidator) TokenTypes() []string { return []string{tokenTypeSAML2} }
+
+func (v *samlValidator) Configured(config *Config) error {
+	if !config.hasSAMLIDP() {
+		return fmt.Errorf("saml_idp_metadata or saml_idp_certificates")
+	}
+	return nil
+}
+
+func (v *samlValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	return validateSAMLAssertion(config, token)
+}
+
+// vaultTokenValidator validates Vault tokens by looking them up at api_addr
+type vaultTokenValidator struct {
+	b *Backend
+}
+
+func (v *vaultTokenValidator) Name() string { return "vault_token" }
+
+func (v *vaultTokenValidator) TokenTypes() []string { return []string{tokenTypeVaultToken} }
+
+func (v *vaultTokenValidator) Configured(config *Config) error {
+	if config.APIAddr == "" {
+		return fmt.Errorf("api_addr")
+	}
+	return nil
+}
+
+func (v *vaultTokenValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	return v.b.validateVaultToken(ctx, config, token)
+}
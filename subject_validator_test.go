@@ -0,0 +1,148 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// testTokenType is the subject_token_type of testValidator
+const testTokenType = "urn:example:token-type:test"
+
+// testValidator accepts "valid" as a subject token for user-456
+type testValidator struct{}
+
+func (v *testValidator) Name() string { return "test" }
+
+func (v *testValidator) TokenTypes() []string { return []string{testTokenType} }
+
+func (v *testValidator) Configured(config *Config) error { return nil }
+
+func (v *testValidator) Validate(ctx context.Context, storage logical.Storage, config *Config, token string) (map[string]any, error) {
+	if token != "valid" {
+		return nil, fmt.Errorf("unknown token")
+	}
+	return map[string]any{"sub": "user-456", "exp": float64(time.Now().Add(time.Hour).Unix())}, nil
+}
+
+// TestSubjectTokenValidator_Register tests that registered validators accept
+// new subject token types
+func TestSubjectTokenValidator_Register(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	setupTestExchange(t, b, storage, nil)
+
+	exchange := func(token, tokenType string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      map[string]any{"subject_token": token, "subject_token_type": tokenType},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := exchange("valid", testTokenType)
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "unsupported subject_token_type")
+
+	require.NoError(t, b.RegisterSubjectTokenValidator(&testValidator{}))
+	require.Error(t, b.RegisterSubjectTokenValidator(&testValidator{}))
+
+	resp = exchange("valid", testTokenType)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, "user-456", parseIssuedToken(t, b, storage, resp.Data["token"].(string))["sub"])
+
+	resp = exchange("invalid", testTokenType)
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	// Built-in validators report the settings they need
+	resp = exchange("token", tokenTypeSAML2)
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "requires saml_idp_metadata or saml_idp_certificates in the config")
+}
+
+// TestSubjectTokenValidator_OIDCDiscovery tests validation of subject tokens
+// with the JWKS found through the issuer's discovery document
+func TestSubjectTokenValidator_OIDCDiscovery(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"issuer": issuer, "jwks_uri": issuer + "/keys"}))
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &privateKey.PublicKey, KeyID: kid, Use: "sig"}}}))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	issuer = server.URL
+
+	writeConfig := func(data map[string]any) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "config", Storage: storage, Data: data})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := writeConfig(map[string]any{"subject_oidc_issuer": issuer, "subject_jwks_uri": issuer + "/keys"})
+	require.True(t, resp.IsError(), "subject_oidc_issuer cannot be combined with subject_jwks_uri")
+	resp = writeConfig(map[string]any{"subject_oidc_issuer": "not a url"})
+	require.True(t, resp.IsError())
+
+	resp = writeConfig(map[string]any{"subject_oidc_issuer": issuer})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	claims := defaultSubjectClaims()
+	claims["iss"] = issuer
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	// Tokens from other issuers are not verified with the discovered keys
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidSubjectToken, false)
+
+	resp = readSubjectJWKSStatus(t, b, storage)
+	require.False(t, resp.IsError(), "status read failed: %v", resp)
+	require.Equal(t, issuer+"/keys", resp.Data["jwks_uri"])
+
+	// A discovery document for another issuer is rejected
+	issuer = "https://other.example.com"
+	resp = writeConfig(map[string]any{"subject_oidc_issuer": server.URL})
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+	claims["iss"] = server.URL
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, claims)
+	requireExchangeError(t, resp, ErrCodeTemporarilyUnavailable, true)
+}
+
+// TestSubjectTokenValidator_NoKeySource tests that JWT exchanges fail when the
+// config names no subject key source
+func TestSubjectTokenValidator_NoKeySource(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]any{"subject_jwks_uri": ""},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "config write failed: %v", resp)
+
+	resp = exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	require.Contains(t, resp.Error().Error(), "subject_jwks_uri")
+}
@@ -0,0 +1,80 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tenantNamePattern matches tenant names. They appear in URL paths and
+// issuer URLs, so they are restricted to URL-safe characters.
+var tenantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9_-]{0,62}[a-zA-Z0-9])?$`)
+
+// tenantField returns the schema of the tenant parameter of roles and keys
+func tenantField(resource string) *framework.FieldSchema {
+	return &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Tenant the " + resource + " belongs to. A tenant's roles only sign with its keys, and their tokens are issued by the tenant issuer <issuer>/tenant/<tenant> and verified with jwks/<tenant>. Empty for the mount's shared issuer",
+	}
+}
+
+// validateTenant checks a tenant name. Empty is the shared tenant.
+func validateTenant(tenant string) error {
+	if tenant != "" && !tenantNamePattern.MatchString(tenant) {
+		return fmt.Errorf("tenant must be 1 to 64 letters, digits, '-' or '_', starting and ending with a letter or digit")
+	}
+	return nil
+}
+
+// tenantIssuer returns the iss of tokens issued for tenant
+func tenantIssuer(config *Config, tenant string) string {
+	if tenant == "" {
+		return config.Issuer
+	}
+	return strings.TrimSuffix(config.Issuer, "/") + "/tenant/" + tenant
+}
+
+// roleIssuer returns the iss of tokens issued by the role
+func roleIssuer(config *Config, role *Role) string {
+	return tenantIssuer(config, role.Tenant)
+}
+
+// checkRoleTenant checks that every key the role signs with belongs to the
+// role's tenant, so a tenant's JWKS never verifies another tenant's tokens.
+// It returns an error response for an invalid role.
+func (b *Backend) checkRoleTenant(ctx context.Context, storage logical.Storage, role *Role, defaultKey string) (*logical.Response, error) {
+	for _, name := range roleKeyNames(role, defaultKey) {
+		key, err := b.getKey(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || key.Tenant == role.Tenant {
+			continue
+		}
+		if role.Tenant == "" {
+			return logical.ErrorResponse("key %q belongs to tenant %q and cannot sign for a role without a tenant", name, key.Tenant), nil
+		}
+		return logical.ErrorResponse("key %q does not belong to tenant %q", name, role.Tenant), nil
+	}
+	return nil, nil
+}
+
+// filterByTenant returns the names whose entry belongs to tenant, as
+// reported by tenantOf. tenantOf returns ok false for deleted entries.
+func filterByTenant(names []string, tenant string, tenantOf func(name string) (string, bool, error)) ([]string, error) {
+	var filtered []string
+	for _, name := range names {
+		entryTenant, ok, err := tenantOf(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok && entryTenant == tenant {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, nil
+}
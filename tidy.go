@@ -0,0 +1,208 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultTidySafetyBuffer is how long expired state is kept before tidy
+// deletes it when tidy_safety_buffer is unset
+const defaultTidySafetyBuffer = 72 * time.Hour
+
+// tidySafetyBuffer returns tidy_safety_buffer, or the default when unset
+func (c *Config) tidySafetyBuffer() time.Duration {
+	if c == nil || c.TidySafetyBuffer <= 0 {
+		return defaultTidySafetyBuffer
+	}
+	return c.TidySafetyBuffer
+}
+
+// defaultTidyInterval is how often the periodic function tidies when
+// tidy_interval is unset
+const defaultTidyInterval = 12 * time.Hour
+
+// tidyInterval returns tidy_interval, or the default when unset
+func (c *Config) tidyInterval() time.Duration {
+	if c == nil || c.TidyInterval <= 0 {
+		return defaultTidyInterval
+	}
+	return c.TidyInterval
+}
+
+// tidyStatusStoragePath holds when tidy last finished, so every node of the
+// cluster, and the node after a restart, waits out tidy_interval from there
+const tidyStatusStoragePath = "tidy/status"
+
+// tidyStatus records the last completed tidy
+type tidyStatus struct {
+	LastRun time.Time `json:"last_run"`
+}
+
+// tidyDue reports whether tidy last finished more than interval ago, or has
+// never run
+func tidyDue(ctx context.Context, storage logical.Storage, interval time.Duration) (bool, error) {
+	entry, err := storage.Get(ctx, tidyStatusStoragePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read tidy status: %w", err)
+	}
+	if entry == nil {
+		return true, nil
+	}
+
+	status := &tidyStatus{}
+	if err := entry.DecodeJSON(status); err != nil {
+		return false, fmt.Errorf("failed to decode tidy status: %w", err)
+	}
+	return time.Since(status.LastRun) >= interval, nil
+}
+
+// putTidyStatus records that tidy finished at lastRun
+func putTidyStatus(ctx context.Context, storage logical.Storage, lastRun time.Time) error {
+	entry, err := logical.StorageEntryJSON(tidyStatusStoragePath, &tidyStatus{LastRun: lastRun})
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write tidy status: %w", err)
+	}
+	return nil
+}
+
+// tidyResult counts what a tidy run deleted
+type tidyResult struct {
+	ReplayRecords      int
+	ReferenceTokens    int
+	RefreshTokens      int
+	KeyVersions        int
+	IssuanceRecords    int
+	IssuanceLogEntries int
+	CacheEntries       int
+	UsageCounters      int
+}
+
+// responseData formats the result for the tidy endpoint
+func (r *tidyResult) responseData(safetyBuffer time.Duration) map[string]any {
+	return map[string]any{
+		"safety_buffer":                safetyBuffer.String(),
+		"replay_records_deleted":       r.ReplayRecords,
+		"reference_tokens_deleted":     r.ReferenceTokens,
+		"refresh_tokens_deleted":       r.RefreshTokens,
+		"key_versions_deleted":         r.KeyVersions,
+		"issuance_records_deleted":     r.IssuanceRecords,
+		"issuance_log_entries_deleted": r.IssuanceLogEntries,
+		"cache_entries_deleted":        r.CacheEntries,
+		"usage_counters_deleted":       r.UsageCounters,
+	}
+}
+
+// tidy deletes expired state: replay records, reference and refresh tokens
+// and rotated key versions that expired more than safetyBuffer ago, issuance
+// records and issuance log entries past their retention, issuance log entries
+// beyond its bound, per-entity usage counters unused within their retention
+// and expired cache entries. Only one tidy runs at a time; tidy returns nil
+// when another is already running. A completed tidy is recorded so the
+// periodic function waits tidy_interval before the next.
+func (b *Backend) tidy(ctx context.Context, storage logical.Storage, safetyBuffer time.Duration) (*tidyResult, error) {
+	if !b.tidyLock.TryLock() {
+		return nil, nil
+	}
+	defer b.tidyLock.Unlock()
+
+	result := &tidyResult{}
+	cutoff := time.Now().Add(-safetyBuffer)
+
+	var err error
+	if result.ReplayRecords, err = b.tidyReplayEntries(ctx, storage, cutoff); err != nil {
+		return result, err
+	}
+	if result.ReferenceTokens, err = b.tidyReferenceTokens(ctx, storage, cutoff); err != nil {
+		return result, err
+	}
+	if result.RefreshTokens, err = b.tidyRefreshEntries(ctx, storage, cutoff); err != nil {
+		return result, err
+	}
+	if result.KeyVersions, err = b.tidyKeyVersions(ctx, storage, cutoff); err != nil {
+		return result, err
+	}
+	if result.IssuanceRecords, err = b.tidyIssuanceRecords(ctx, storage); err != nil {
+		return result, err
+	}
+	if result.IssuanceLogEntries, err = b.tidyIssuanceLog(ctx, storage); err != nil {
+		return result, err
+	}
+	if result.UsageCounters, err = b.tidyUsage(ctx, storage); err != nil {
+		return result, err
+	}
+	result.CacheEntries = b.tidyCaches(time.Now())
+
+	return result, putTidyStatus(ctx, storage, time.Now())
+}
+
+// tidyKeyVersions deletes rotated key versions whose verification window
+// ended before cutoff. They are no longer published in the JWKS.
+func (b *Backend) tidyKeyVersions(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	keyNames, err := storage.List(ctx, keyStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	deleted := 0
+	for _, name := range keyNames {
+		key, err := b.getKey(ctx, storage, name)
+		if err != nil {
+			return deleted, err
+		}
+		if key == nil {
+			continue
+		}
+
+		retained := key.verificationVersions(cutoff)
+		if len(retained) == len(key.PreviousVersions) {
+			continue
+		}
+
+		removed := len(key.PreviousVersions) - len(retained)
+		key.PreviousVersions = retained
+		if err := b.putKey(ctx, storage, key); err != nil {
+			return deleted, err
+		}
+		deleted += removed
+	}
+
+	return deleted, nil
+}
+
+// tidyCaches drops cache entries that expired before now. Expired entries
+// are never served, so this only frees memory.
+func (b *Backend) tidyCaches(now time.Time) int {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	deleted := 0
+	for uri, entry := range b.jwksCache {
+		if !now.Before(entry.expiresAt) {
+			delete(b.jwksCache, uri)
+			deleted++
+		}
+	}
+	for uri, entry := range b.encryptionJWKSCache {
+		if !now.Before(entry.expiresAt) {
+			delete(b.encryptionJWKSCache, uri)
+			deleted++
+		}
+	}
+	for lookup, entry := range b.directoryCache {
+		if !now.Before(entry.expiresAt) {
+			delete(b.directoryCache, lookup)
+			deleted++
+		}
+	}
+
+	return deleted
+}
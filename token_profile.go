@@ -0,0 +1,262 @@
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Supported token_profile values. A profile shapes issued tokens for a
+// relying party with fixed requirements; the default profile issues plain
+// RFC 8693 delegation tokens.
+const (
+	TokenProfileDefault = ""
+	TokenProfileAzure   = "azure"
+	TokenProfileAWS     = "aws"
+	TokenProfileGCP     = "gcp"
+)
+
+// issuerLookupTimeout bounds resolving the issuer host of a profile that
+// requires a public issuer
+const issuerLookupTimeout = 5 * time.Second
+
+// lookupIssuerHost resolves the issuer host. Tests replace it to avoid DNS.
+var lookupIssuerHost = net.DefaultResolver.LookupIPAddr
+
+// errTokenProfileClaims marks tokens whose claims the role's token profile
+// cannot carry
+var errTokenProfileClaims = errors.New("claims not accepted by token profile")
+
+// tokenProfile describes what a relying party requires of the tokens it
+// accepts
+type tokenProfile struct {
+	// audience is the default aud, overridden by token_profile_audience
+	audience string
+
+	// algorithms lists the signing algorithms the relying party verifies
+	algorithms []string
+
+	// scalarClaims drops object-valued claims the relying party cannot use,
+	// apart from cnf
+	scalarClaims bool
+
+	// maxSubjectLength is the longest sub in bytes the relying party maps,
+	// or 0 for no limit
+	maxSubjectLength int
+
+	// publicIssuer requires the issuer host to resolve to public addresses,
+	// because the relying party fetches discovery from the internet
+	publicIssuer bool
+}
+
+// tokenProfiles holds the supported profiles by token_profile value.
+//
+// Cloud providers match federated credentials on an exact issuer, subject
+// and audience, and discover the issuer's keys at
+// <issuer>/.well-known/openid-configuration.
+var tokenProfiles = map[string]tokenProfile{
+	// Azure AD federated identity credentials
+	TokenProfileAzure: {
+		audience:   "api://AzureADTokenExchange",
+		algorithms: []string{AlgorithmRS256},
+	},
+	// AWS STS AssumeRoleWithWebIdentity
+	TokenProfileAWS: {
+		audience:     "sts.amazonaws.com",
+		algorithms:   []string{AlgorithmRS256, AlgorithmRS384, AlgorithmRS512},
+		scalarClaims: true,
+	},
+	// GCP workload identity pool OIDC providers. The audience is the pool
+	// provider's resource name, so token_profile_audience is required.
+	TokenProfileGCP: {
+		algorithms:       []string{AlgorithmRS256, AlgorithmRS384, AlgorithmRS512},
+		maxSubjectLength: 127,
+		publicIssuer:     true,
+	},
+}
+
+// profileAudience returns the aud of tokens issued under the role's profile
+func profileAudience(role *Role) string {
+	if role.TokenProfileAudience != "" {
+		return role.TokenProfileAudience
+	}
+	return tokenProfiles[role.TokenProfile].audience
+}
+
+// applyTokenProfile reshapes the claims of a token issued by the role.
+// actorSubject is the act.sub of the token.
+//
+// The subject a cloud provider matches must be stable, so it is the actor:
+// the agent's workload identity. The delegating subject moves to
+// original_sub.
+func applyTokenProfile(role *Role, claims map[string]any, actorSubject string) {
+	profile, ok := tokenProfiles[role.TokenProfile]
+	if !ok {
+		return
+	}
+
+	claims["original_sub"] = claims["sub"]
+	claims["sub"] = actorSubject
+	claims["aud"] = profileAudience(role)
+
+	if profile.scalarClaims {
+		for name, value := range claims {
+			if _, isObject := value.(map[string]any); isObject && name != "cnf" {
+				delete(claims, name)
+			}
+		}
+	}
+}
+
+// checkProfileClaims reports whether the role's token profile accepts the
+// shaped claims of a token
+func checkProfileClaims(role *Role, claims map[string]any) error {
+	profile, ok := tokenProfiles[role.TokenProfile]
+	if !ok {
+		return nil
+	}
+	if sub, _ := claims["sub"].(string); profile.maxSubjectLength > 0 && len(sub) > profile.maxSubjectLength {
+		return fmt.Errorf("%w: token_profile %q limits sub to %d bytes, the actor subject has %d", errTokenProfileClaims, role.TokenProfile, profile.maxSubjectLength, len(sub))
+	}
+	return nil
+}
+
+// checkProfileAlgorithm reports whether the role's token profile accepts
+// tokens signed with algorithm
+func checkProfileAlgorithm(role *Role, algorithm string) error {
+	profile, ok := tokenProfiles[role.TokenProfile]
+	if !ok || slices.Contains(profile.algorithms, algorithm) {
+		return nil
+	}
+	return fmt.Errorf("token_profile %q requires keys signing with %s, not %s", role.TokenProfile, strings.Join(profile.algorithms, ", "), algorithm)
+}
+
+// validateTokenProfile checks that the role's other options and keys are
+// compatible with its token profile. It returns an error response for an
+// invalid role.
+func (b *Backend) validateTokenProfile(ctx context.Context, storage logical.Storage, role *Role, config *Config) (*logical.Response, error) {
+	if role.TokenProfile == TokenProfileDefault {
+		if role.TokenProfileAudience != "" {
+			return logical.ErrorResponse("token_profile_audience requires a token_profile"), nil
+		}
+		return nil, nil
+	}
+	profile, ok := tokenProfiles[role.TokenProfile]
+	if !ok {
+		return logical.ErrorResponse("token_profile must be empty or one of %s", strings.Join(slices.Sorted(maps.Keys(tokenProfiles)), ", ")), nil
+	}
+	if profileAudience(role) == "" {
+		return logical.ErrorResponse("token_profile %q requires token_profile_audience", role.TokenProfile), nil
+	}
+
+	// The relying party verifies a plain JWS with the audience it requires
+	switch {
+	case role.DetachedPayload:
+		return logical.ErrorResponse("token_profile cannot be combined with detached_payload"), nil
+	case role.EncryptionKey != "" || role.EncryptionJWKSURI != "":
+		return logical.ErrorResponse("token_profile cannot be combined with token encryption"), nil
+	case role.UpstreamSTS != nil:
+		return logical.ErrorResponse("token_profile cannot be combined with upstream_sts_url"), nil
+	case len(role.AudienceKeys) > 0:
+		return logical.ErrorResponse("token_profile fixes the audience and cannot be combined with audience_keys"), nil
+	}
+
+	// The relying party discovers the issuer's keys at
+	// <issuer>/.well-known/openid-configuration
+	var issuer *url.URL
+	if config != nil {
+		issuer, _ = url.Parse(roleIssuer(config, role))
+	}
+	if issuer == nil || issuer.Scheme != "https" || issuer.Host == "" {
+		return logical.ErrorResponse("token_profile %q requires the config's issuer to be an https URL", role.TokenProfile), nil
+	}
+	if profile.publicIssuer {
+		if err := checkPublicHost(ctx, issuer.Hostname()); err != nil {
+			return logical.ErrorResponse("token_profile %q requires a publicly resolvable issuer: %v", role.TokenProfile, err), nil
+		}
+	}
+
+	for _, name := range roleKeyNames(role, config.DefaultKey) {
+		key, err := b.getKey(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+		if err := checkProfileAlgorithm(role, key.Algorithm); err != nil {
+			return logical.ErrorResponse("key %q: %v", name, err), nil
+		}
+	}
+	return nil, nil
+}
+
+// checkPublicHost checks that host resolves, and only to public addresses
+func checkPublicHost(ctx context.Context, host string) error {
+	var addresses []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = append(addresses, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, issuerLookupTimeout)
+		defer cancel()
+		resolved, err := lookupIssuerHost(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %q: %w", host, err)
+		}
+		for _, address := range resolved {
+			addresses = append(addresses, address.IP)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return fmt.Errorf("%q has no addresses", host)
+	}
+	for _, ip := range addresses {
+		if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			return fmt.Errorf("%q resolves to non-public address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// gcpAttributeMapping returns a workload identity pool provider attribute
+// mapping for tokens issued under the gcp profile
+func gcpAttributeMapping() map[string]string {
+	return map[string]string{
+		"google.subject":         "assertion.sub",
+		"attribute.original_sub": "assertion.original_sub",
+		"attribute.scope":        "assertion.scope",
+	}
+}
+
+// awsTrustPolicy returns an example IAM role trust policy that lets tokens
+// issued under the role's aws profile assume the IAM role. The account ID
+// and the agent's subject are placeholders.
+func awsTrustPolicy(role *Role, config *Config) map[string]any {
+	// IAM names an OIDC provider by its issuer URL without the scheme
+	provider := strings.TrimPrefix(roleIssuer(config, role), "https://")
+	return map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{
+			{
+				"Effect":    "Allow",
+				"Principal": map[string]any{"Federated": "arn:aws:iam::<ACCOUNT_ID>:oidc-provider/" + provider},
+				"Action":    "sts:AssumeRoleWithWebIdentity",
+				"Condition": map[string]any{
+					"StringEquals": map[string]any{
+						provider + ":aud": profileAudience(role),
+						provider + ":sub": "<ACTOR_SUBJECT>",
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,115 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// traceparentPattern matches a W3C Trace Context traceparent:
+// version-traceid-parentid-flags. Versions after 00 may append fields.
+var traceparentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// requestIDPattern restricts caller-supplied request IDs to characters that
+// are safe in logs and claims
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+// parseTraceparent returns the trace ID of a W3C traceparent
+func parseTraceparent(traceparent string) (string, error) {
+	match := traceparentPattern.FindStringSubmatch(traceparent)
+	if match == nil {
+		return "", fmt.Errorf("traceparent must be a W3C traceparent such as 00-<32 hex trace-id>-<16 hex parent-id>-<2 hex flags>")
+	}
+
+	version, traceID, parentID, extra := match[1], match[2], match[3], match[5]
+	switch {
+	case version == "ff":
+		return "", fmt.Errorf("traceparent version ff is invalid")
+	case version == "00" && extra != "":
+		return "", fmt.Errorf("traceparent version 00 has exactly four fields")
+	case strings.Trim(traceID, "0") == "":
+		return "", fmt.Errorf("traceparent trace-id must not be all zeros")
+	case strings.Trim(parentID, "0") == "":
+		return "", fmt.Errorf("traceparent parent-id must not be all zeros")
+	}
+	return traceID, nil
+}
+
+// resolveTrace reads the caller's request_id and traceparent, from the
+// traceparent field or header. The exchange's transaction ID is the trace ID,
+// or the request ID when no traceparent is sent.
+func (b *Backend) resolveTrace(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	ex.requestID = ex.data.Get("request_id").(string)
+	if ex.requestID != "" && !requestIDPattern.MatchString(ex.requestID) {
+		return exchangeError(ErrCodeInvalidRequest, "request_id must be 1 to 128 letters, digits or ._:/+=- characters"), nil
+	}
+
+	traceparent := ex.data.Get("traceparent").(string)
+	if traceparent == "" && ex.req.Headers != nil {
+		traceparent = http.Header(ex.req.Headers).Get("traceparent")
+	}
+	if traceparent != "" {
+		traceID, err := parseTraceparent(traceparent)
+		if err != nil {
+			return exchangeError(ErrCodeInvalidRequest, "%s", err), nil
+		}
+		ex.traceID = traceID
+	}
+
+	ex.txn = ex.traceID
+	if ex.txn == "" {
+		ex.txn = ex.requestID
+	}
+	return nil, nil
+}
+
+// addTxn adds the transaction ID as the txn claim when the role requests it.
+// It replaces any txn produced by the templates.
+func (b *Backend) addTxn(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if !ex.role.IncludeTxn || ex.txn == "" {
+		return nil, nil
+	}
+
+	if ex.actorClaims == nil {
+		ex.actorClaims = map[string]any{}
+	}
+	ex.actorClaims["txn"] = ex.txn
+	return nil, nil
+}
+
+// exchangeLogger returns the backend logger annotated with the exchange's
+// request and trace IDs
+func (b *Backend) exchangeLogger(ex *exchange) hclog.Logger {
+	logger := b.Logger()
+	if ex.requestID != "" {
+		logger = logger.With("request_id", ex.requestID)
+	}
+	if ex.traceID != "" {
+		logger = logger.With("trace_id", ex.traceID)
+	}
+	return logger
+}
+
+// logExchange logs the outcome of every exchange at debug level, so issued
+// tokens can be correlated with the caller's traces
+func (b *Backend) logExchange(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	logger := b.exchangeLogger(ex)
+	if !logger.IsDebug() {
+		return nil, nil
+	}
+
+	switch {
+	case ex.succeeded() && ex.issued != nil:
+		logger.Debug("issued token", "role", ex.roleName, "entity_id", ex.req.EntityID, "jti", ex.issued.JTI)
+	case ex.err != nil:
+		logger.Debug("token exchange failed", "role", ex.roleName, "entity_id", ex.req.EntityID, "error", ex.err)
+	case ex.resp != nil && ex.resp.IsError():
+		logger.Debug("token exchange failed", "role", ex.roleName, "entity_id", ex.req.EntityID, "error", ex.resp.Error())
+	}
+	return nil, nil
+}
@@ -0,0 +1,79 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseTraceparent tests extraction of the trace ID from W3C traceparents
+func TestParseTraceparent(t *testing.T) {
+	traceID, err := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+
+	traceID, err = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+
+	for _, invalid := range []string{
+		"",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := parseTraceparent(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+// TestTokenExchange_Txn tests that the trace ID, or else the request ID, is
+// issued as the txn claim
+func TestTokenExchange_Txn(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{"include_txn": true})
+
+	exchange := func(fields map[string]any) *logical.Response {
+		data := map[string]any{"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims())}
+		for k, v := range fields {
+			data[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := exchange(map[string]any{
+		"request_id":  "req-123",
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", claims["txn"])
+
+	resp = exchange(map[string]any{"request_id": "req-123"})
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, "req-123", claims["txn"])
+
+	resp = exchange(nil)
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.NotContains(t, claims, "txn")
+
+	resp = exchange(map[string]any{"traceparent": "not-a-traceparent"})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	resp = exchange(map[string]any{"request_id": "has spaces"})
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+}
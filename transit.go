@@ -0,0 +1,316 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Supported key_type values
+const (
+	KeyTypeInternal = "internal"
+	KeyTypeTransit  = "transit"
+)
+
+// defaultTransitTimeout bounds a request to the transit engine
+const defaultTransitTimeout = 10 * time.Second
+
+// maxTransitResponseSize bounds the transit response read into memory
+const maxTransitResponseSize = 1 << 20
+
+// errTransitUnavailable marks signing failures caused by the transit engine
+// being unreachable or failing
+var errTransitUnavailable = errors.New("transit engine unavailable")
+
+// transitClient calls a transit secrets engine through Vault's API at
+// api_addr. Plugins cannot reach other mounts through the SystemView, so it
+// authenticates with the configured transit_token.
+type transitClient struct {
+	httpClient *http.Client
+	addr       string
+	token      string
+	mount      string
+	key        string
+}
+
+// transitKey is the part of a transit key read used to publish its versions
+type transitKey struct {
+	Type          string         `json:"type"`
+	LatestVersion int            `json:"latest_version"`
+	Keys          map[string]any `json:"keys"`
+}
+
+// newTransitClient returns a client for the key's transit key. It shares the
+// api_addr HTTP client, which trusts api_ca_pem and does not follow redirects.
+func (b *Backend) newTransitClient(config *Config, key *Key) (*transitClient, error) {
+	if config == nil || config.APIAddr == "" {
+		return nil, fmt.Errorf("transit keys require api_addr in the config")
+	}
+	if config.TransitToken == "" {
+		return nil, fmt.Errorf("transit keys require transit_token in the config")
+	}
+	client, err := b.getVaultAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &transitClient{
+		httpClient: client,
+		addr:       config.APIAddr,
+		token:      config.TransitToken,
+		mount:      strings.Trim(key.TransitMount, "/"),
+		key:        key.TransitKey,
+	}, nil
+}
+
+// do sends a request to the transit mount and decodes the data of the
+// response into out
+func (c *transitClient) do(ctx context.Context, method, path string, body any, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTransitTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode transit request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+c.mount+"/"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create transit request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	req.Header.Set("X-Vault-Request", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTransitUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxTransitResponseSize))
+	if err != nil {
+		return fmt.Errorf("%w: unable to read transit response: %w", errTransitUnavailable, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("%w: transit request failed with status %d", errTransitUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("transit request to %s failed with status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return fmt.Errorf("failed to decode transit response: %w", err)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("failed to decode transit response: %w", err)
+	}
+	return nil
+}
+
+// readKey returns the transit key's latest version and its public key. Only
+// RSA transit keys are supported.
+func (c *transitClient) readKey(ctx context.Context) (int, *rsa.PublicKey, error) {
+	key := &transitKey{}
+	if err := c.do(ctx, http.MethodGet, "keys/"+url.PathEscape(c.key), nil, key); err != nil {
+		return 0, nil, err
+	}
+	if !strings.HasPrefix(key.Type, "rsa-") {
+		return 0, nil, fmt.Errorf("transit key %q has type %q: only RSA keys are supported", c.key, key.Type)
+	}
+
+	version, ok := key.Keys[strconv.Itoa(key.LatestVersion)].(map[string]any)
+	if !ok {
+		return 0, nil, fmt.Errorf("transit key %q has no version %d", c.key, key.LatestVersion)
+	}
+	publicKeyPEM, _ := version["public_key"].(string)
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return 0, nil, fmt.Errorf("transit key %q version %d has no public key", c.key, key.LatestVersion)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse transit public key: %w", err)
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return 0, nil, fmt.Errorf("transit key %q is not an RSA key", c.key)
+	}
+
+	return key.LatestVersion, publicKey, nil
+}
+
+// rotate creates a new version of the transit key
+func (c *transitClient) rotate(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "keys/"+url.PathEscape(c.key)+"/rotate", nil, nil)
+}
+
+// sign signs input with the given version of the transit key. Transit hashes
+// the input, so input is the JWS signing input itself.
+func (c *transitClient) sign(ctx context.Context, version int, algorithm jose.SignatureAlgorithm, input []byte) ([]byte, error) {
+	hash, padding, err := transitSignatureParams(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]any{
+		"input":               base64.StdEncoding.EncodeToString(input),
+		"key_version":         version,
+		"signature_algorithm": padding,
+	}
+	// JWS PS algorithms use a salt as long as the hash
+	if padding == "pss" {
+		body["salt_length"] = "hash"
+	}
+
+	result := struct {
+		Signature string `json:"signature"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "sign/"+url.PathEscape(c.key)+"/"+hash, body, &result); err != nil {
+		return nil, err
+	}
+
+	// Signatures have the form vault:v<version>:<base64 signature>
+	parts := strings.SplitN(result.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("unexpected transit signature format")
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transit signature: %w", err)
+	}
+	return signature, nil
+}
+
+// decrypt decrypts ciphertext with the given version of the transit key.
+// Transit decrypts with RSA-OAEP using SHA-256 for RSA keys.
+func (c *transitClient) decrypt(ctx context.Context, version int, ciphertext []byte) ([]byte, error) {
+	body := map[string]any{
+		"ciphertext": fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(ciphertext)),
+	}
+
+	result := struct {
+		Plaintext string `json:"plaintext"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "decrypt/"+url.PathEscape(c.key), body, &result); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transit plaintext: %w", err)
+	}
+	return plaintext, nil
+}
+
+// transitSignatureParams maps a JWS algorithm to transit's hash_algorithm
+// and signature_algorithm
+func transitSignatureParams(algorithm jose.SignatureAlgorithm) (string, string, error) {
+	switch algorithm {
+	case jose.RS256:
+		return "sha2-256", "pkcs1v15", nil
+	case jose.RS384:
+		return "sha2-384", "pkcs1v15", nil
+	case jose.RS512:
+		return "sha2-512", "pkcs1v15", nil
+	case jose.PS256:
+		return "sha2-256", "pss", nil
+	case jose.PS384:
+		return "sha2-384", "pss", nil
+	case jose.PS512:
+		return "sha2-512", "pss", nil
+	default:
+		return "", "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+}
+
+// transitSigner signs JWS payloads with a pinned version of a transit key. It
+// implements jose.OpaqueSigner so it can be used wherever a private key is.
+type transitSigner struct {
+	ctx       context.Context
+	client    *transitClient
+	key       *Key
+	algorithm jose.SignatureAlgorithm
+	publicKey *rsa.PublicKey
+}
+
+// Public returns the public key of the signing version
+func (s *transitSigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.publicKey, KeyID: s.key.KeyID, Algorithm: string(s.algorithm), Use: "sig"}
+}
+
+// Algs returns the key's algorithm
+func (s *transitSigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{s.algorithm}
+}
+
+// SignPayload signs the JWS signing input with transit
+func (s *transitSigner) SignPayload(payload []byte, algorithm jose.SignatureAlgorithm) ([]byte, error) {
+	if algorithm != s.algorithm {
+		return nil, fmt.Errorf("transit key %q signs with %s, not %s", s.key.Name, s.algorithm, algorithm)
+	}
+	return s.client.sign(s.ctx, s.key.Version, algorithm, payload)
+}
+
+// rotateTransitKey rotates the transit key backing key and moves key to the
+// new version. The previous version stays published for verification.
+func (b *Backend) rotateTransitKey(ctx context.Context, config *Config, key *Key, now time.Time) error {
+	client, err := b.newTransitClient(config, key)
+	if err != nil {
+		return err
+	}
+	if err := client.rotate(ctx); err != nil {
+		return fmt.Errorf("failed to rotate transit key: %w", err)
+	}
+	version, publicKey, err := client.readKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rotated transit key: %w", err)
+	}
+
+	key.adoptTransitVersion(config, version, publicKey, now)
+	return nil
+}
+
+// adoptTransitVersion moves k to a newer version of its transit key. The
+// previous version stays published for verification.
+func (k *Key) adoptTransitVersion(config *Config, version int, publicKey *rsa.PublicKey, now time.Time) {
+	previous := &KeyVersion{
+		Version:     k.Version,
+		KeyID:       k.KeyID,
+		PublicKey:   k.PublicKey,
+		CreatedAt:   k.RotatedAt,
+		ExpiresAt:   now.Add(k.verificationTTL(config)),
+		Certificate: k.Certificate,
+	}
+
+	k.PreviousVersions = append(k.verificationVersions(now), previous)
+	k.Version = version
+	k.KeyID = generateKeyID(k.Name, version)
+	k.PublicKey = encodePublicKeyPEM(publicKey)
+	k.RotatedAt = now
+}
@@ -0,0 +1,123 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UpstreamSTS configures an external RFC 8693 security token service that a
+// role's delegated token is exchanged against before being returned
+type UpstreamSTS struct {
+	// URL is the token endpoint of the external STS
+	URL string `json:"url"`
+
+	// ClientID and ClientSecret authenticate Vault to the STS using HTTP
+	// Basic authentication (client_secret_basic)
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+
+	// Audience and Scope are forwarded to the STS when set
+	Audience string   `json:"audience,omitempty"`
+	Scope    []string `json:"scope,omitempty"`
+}
+
+const (
+	// RFC 8693 grant and token type identifiers
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+
+	defaultUpstreamRequestTimeout = 10 * time.Second
+
+	// maxUpstreamResponseSize bounds the STS response read into memory
+	maxUpstreamResponseSize = 1 << 20
+)
+
+// upstreamHTTPClient calls external STSs. It does not follow redirects, which
+// would send the subject token to wherever the STS points.
+var upstreamHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// upstreamToken is a token returned by an external STS
+type upstreamToken struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope"`
+}
+
+// upstreamError is an RFC 6749 error response returned by an external STS
+type upstreamError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeUpstream exchanges subjectToken at the external STS and returns the
+// downstream token
+func exchangeUpstream(ctx context.Context, sts *UpstreamSTS, subjectToken string) (*upstreamToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", tokenExchangeGrantType)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", tokenTypeJWT)
+	if sts.Audience != "" {
+		form.Set("audience", sts.Audience)
+	}
+	if len(sts.Scope) > 0 {
+		form.Set("scope", strings.Join(sts.Scope, " "))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultUpstreamRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sts.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if sts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(sts.ClientID), url.QueryEscape(sts.ClientSecret))
+	}
+
+	resp, err := upstreamHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read upstream response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var stsErr upstreamError
+		if err := json.Unmarshal(body, &stsErr); err == nil && stsErr.Error != "" {
+			if stsErr.ErrorDescription != "" {
+				return nil, fmt.Errorf("upstream returned %s: %s", stsErr.Error, stsErr.ErrorDescription)
+			}
+			return nil, fmt.Errorf("upstream returned %s", stsErr.Error)
+		}
+		return nil, fmt.Errorf("upstream request failed with status %d", resp.StatusCode)
+	}
+
+	token := &upstreamToken{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("failed to decode upstream response: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("upstream response did not include an access_token")
+	}
+
+	return token, nil
+}
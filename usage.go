@@ -0,0 +1,485 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// usageStoragePrefix holds the issuance counters of each role at
+// usage/<role>, and those of each entity using it at usage/<role>/<entity>,
+// so an exchange only rewrites the counters it changed
+const usageStoragePrefix = "usage/"
+
+// usageDayFormat names the UTC day that daily counters and quotas apply to
+const usageDayFormat = "2006-01-02"
+
+// usageCount counts the tokens issued today and in total
+type usageCount struct {
+	Day   string `json:"day"`
+	Today uint64 `json:"today"`
+	Total uint64 `json:"total"`
+}
+
+// roleUsage holds the issuance counters of a role and of each entity using it
+type roleUsage struct {
+	Role     usageCount
+	Entities map[string]*usageCount
+
+	// dirty is set when the counters changed since they were last persisted,
+	// and dirtyEntities names the entities whose counters changed
+	dirty         bool
+	dirtyEntities map[string]struct{}
+}
+
+// storedRoleUsage is the usage/<role> entry. Entities is only read, from
+// entries written before each entity's counters had an entry of their own.
+type storedRoleUsage struct {
+	Role     usageCount             `json:"role"`
+	Entities map[string]*usageCount `json:"entities,omitempty"`
+}
+
+// usageEntityPath returns the storage path of an entity's counters for a role
+func usageEntityPath(role, entityID string) string {
+	return usageStoragePrefix + role + "/" + entityID
+}
+
+// usageTracker holds issuance counters in memory. Exchanges are write
+// requests served by the active node, so its counters are authoritative;
+// they are persisted periodically, and on every exchange for roles with a
+// quota. The tracker uses its own leaf lock.
+type usageTracker struct {
+	mu    sync.Mutex
+	roles map[string]*roleUsage
+}
+
+// newUsageTracker creates an empty usage tracker
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		roles: make(map[string]*roleUsage),
+	}
+}
+
+// usageDay returns the UTC day of t
+func usageDay(t time.Time) string {
+	return t.UTC().Format(usageDayFormat)
+}
+
+// today returns the count for day, which is zero once the day has passed
+func (c *usageCount) today(day string) uint64 {
+	if c.Day != day {
+		return 0
+	}
+	return c.Today
+}
+
+// add counts an issued token on day
+func (c *usageCount) add(day string) {
+	if c.Day != day {
+		c.Day = day
+		c.Today = 0
+	}
+	c.Today++
+	c.Total++
+}
+
+// remove uncounts a token counted on day that was not issued after all
+func (c *usageCount) remove(day string) {
+	if c.Day == day && c.Today > 0 {
+		c.Today--
+	}
+	if c.Total > 0 {
+		c.Total--
+	}
+}
+
+// copy returns a deep copy of the counters
+func (u *roleUsage) copy() *roleUsage {
+	entities := make(map[string]*usageCount, len(u.Entities))
+	for id, count := range u.Entities {
+		c := *count
+		entities[id] = &c
+	}
+	return &roleUsage{Role: u.Role, Entities: entities}
+}
+
+// markEntityDirty marks the counters of the role and of an entity changed
+func (u *roleUsage) markEntityDirty(entityID string) {
+	u.dirty = true
+	if u.dirtyEntities == nil {
+		u.dirtyEntities = map[string]struct{}{}
+	}
+	u.dirtyEntities[entityID] = struct{}{}
+}
+
+// loaded reports whether the counters of a role are in memory
+func (t *usageTracker) loaded(role string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.roles[role]
+	return ok
+}
+
+// load stores counters read from storage unless the role was loaded meanwhile
+func (t *usageTracker) load(role string, usage *roleUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.roles[role]; !ok {
+		t.roles[role] = usage
+	}
+}
+
+// reserve counts a token about to be issued by role to entityID on day,
+// unless the role already issued limit tokens that day. A limit of zero or
+// less is unlimited.
+func (t *usageTracker) reserve(role, entityID, day string, limit int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		// Dropped by an invalidation since it was loaded
+		usage = &roleUsage{Entities: map[string]*usageCount{}}
+		t.roles[role] = usage
+	}
+	if limit > 0 && usage.Role.today(day) >= uint64(limit) {
+		return false
+	}
+
+	usage.Role.add(day)
+	entity, ok := usage.Entities[entityID]
+	if !ok {
+		entity = &usageCount{}
+		usage.Entities[entityID] = entity
+	}
+	entity.add(day)
+	usage.markEntityDirty(entityID)
+	return true
+}
+
+// release uncounts a reserved token that was not issued
+func (t *usageTracker) release(role, entityID, day string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		return
+	}
+	usage.Role.remove(day)
+	if entity, ok := usage.Entities[entityID]; ok {
+		entity.remove(day)
+	}
+	usage.markEntityDirty(entityID)
+}
+
+// snapshot returns a copy of the counters of a role, or nil if not loaded
+func (t *usageTracker) snapshot(role string) *roleUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		return nil
+	}
+	return usage.copy()
+}
+
+// takeDirty returns copies of the counters changed since they were last
+// persisted, holding only the changed entities, and marks them clean
+func (t *usageTracker) takeDirty(roles ...string) map[string]*roleUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(roles) == 0 {
+		roles = make([]string, 0, len(t.roles))
+		for role := range t.roles {
+			roles = append(roles, role)
+		}
+	}
+
+	dirty := map[string]*roleUsage{}
+	for _, role := range roles {
+		usage, ok := t.roles[role]
+		if !ok || !usage.dirty {
+			continue
+		}
+		changed := &roleUsage{Role: usage.Role, Entities: make(map[string]*usageCount, len(usage.dirtyEntities))}
+		for id := range usage.dirtyEntities {
+			if count, ok := usage.Entities[id]; ok {
+				c := *count
+				changed.Entities[id] = &c
+			}
+		}
+		dirty[role] = changed
+		usage.dirty = false
+		usage.dirtyEntities = nil
+	}
+	return dirty
+}
+
+// markDirty marks counters whose write failed so the next flush retries them
+func (t *usageTracker) markDirty(role string, entityIDs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		return
+	}
+	usage.dirty = true
+	for _, id := range entityIDs {
+		usage.markEntityDirty(id)
+	}
+}
+
+// staleEntities returns the entities of a role that last issued a token
+// before cutoffDay and have nothing left to persist
+func (t *usageTracker) staleEntities(role, cutoffDay string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		return nil
+	}
+	var stale []string
+	for id, count := range usage.Entities {
+		if _, dirty := usage.dirtyEntities[id]; !dirty && count.Day < cutoffDay {
+			stale = append(stale, id)
+		}
+	}
+	return stale
+}
+
+// forget drops the counters of entities whose entries were deleted. An
+// entity that issued a token meanwhile is kept and marked changed, so the
+// next flush writes its entry again.
+func (t *usageTracker) forget(role, cutoffDay string, entityIDs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.roles[role]
+	if !ok {
+		return
+	}
+	for _, id := range entityIDs {
+		count, ok := usage.Entities[id]
+		if !ok {
+			continue
+		}
+		if count.Day < cutoffDay {
+			delete(usage.Entities, id)
+			continue
+		}
+		usage.markEntityDirty(id)
+	}
+}
+
+// drop forgets the counters of a role
+func (t *usageTracker) drop(role string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.roles, role)
+}
+
+// loadRoleUsage reads the persisted counters of a role into the tracker the
+// first time they are needed
+func (b *Backend) loadRoleUsage(ctx context.Context, storage logical.Storage, role string) error {
+	if b.usage.loaded(role) {
+		return nil
+	}
+
+	usage := &roleUsage{Entities: map[string]*usageCount{}}
+	entry, err := storage.Get(ctx, usageStoragePrefix+role)
+	if err != nil {
+		return fmt.Errorf("failed to read role usage: %w", err)
+	}
+	if entry != nil {
+		stored := &storedRoleUsage{}
+		if err := entry.DecodeJSON(stored); err != nil {
+			return fmt.Errorf("failed to decode role usage: %w", err)
+		}
+		usage.Role = stored.Role
+
+		// Counters stored in the role's entry move to their own entries on
+		// the next flush
+		for id, count := range stored.Entities {
+			usage.Entities[id] = count
+			usage.markEntityDirty(id)
+		}
+	}
+
+	entityIDs, err := storage.List(ctx, usageStoragePrefix+role+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list role usage: %w", err)
+	}
+	for _, id := range entityIDs {
+		entry, err := storage.Get(ctx, usageEntityPath(role, id))
+		if err != nil {
+			return fmt.Errorf("failed to read role usage: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+		count := &usageCount{}
+		if err := entry.DecodeJSON(count); err != nil {
+			return fmt.Errorf("failed to decode role usage: %w", err)
+		}
+		usage.Entities[id] = count
+	}
+
+	b.usage.load(role, usage)
+	return nil
+}
+
+// flushUsage persists the changed counters of the given roles, or of every
+// role when none are given
+func (b *Backend) flushUsage(ctx context.Context, storage logical.Storage, roles ...string) error {
+	var errs []string
+	for role, usage := range b.usage.takeDirty(roles...) {
+		var failed []string
+		for id, count := range usage.Entities {
+			if err := putUsage(ctx, storage, usageEntityPath(role, id), count); err != nil {
+				failed = append(failed, id)
+				errs = append(errs, fmt.Sprintf("%s/%s: %v", role, id, err))
+			}
+		}
+		err := putUsage(ctx, storage, usageStoragePrefix+role, &storedRoleUsage{Role: usage.Role})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", role, err))
+		}
+		if err != nil || len(failed) > 0 {
+			b.usage.markDirty(role, failed)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to persist role usage: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// putUsage writes a usage entry to storage
+func putUsage(ctx context.Context, storage logical.Storage, key string, value any) error {
+	entry, err := logical.StorageEntryJSON(key, value)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+// deleteRoleUsage deletes the persisted counters of a role
+func deleteRoleUsage(ctx context.Context, storage logical.Storage, role string) error {
+	entityIDs, err := storage.List(ctx, usageStoragePrefix+role+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list role usage: %w", err)
+	}
+	for _, id := range entityIDs {
+		if err := storage.Delete(ctx, usageEntityPath(role, id)); err != nil {
+			return fmt.Errorf("failed to delete role usage: %w", err)
+		}
+	}
+	if err := storage.Delete(ctx, usageStoragePrefix+role); err != nil {
+		return fmt.Errorf("failed to delete role usage: %w", err)
+	}
+	return nil
+}
+
+// tidyUsage deletes the counters of entities that have not exchanged with a
+// role within the usage retention, and returns how many were deleted. The
+// roles' own counters are kept.
+func (b *Backend) tidyUsage(ctx context.Context, storage logical.Storage) (int, error) {
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil {
+		return 0, nil
+	}
+	cutoffDay := usageDay(time.Now().Add(-config.recordRetention(recordTypeUsage)))
+
+	keys, err := storage.List(ctx, usageStoragePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list role usage: %w", err)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		// Loading moves counters still stored in the role's entry to their
+		// own entries, so they can be pruned like the others
+		if err := b.loadRoleUsage(ctx, storage, key); err != nil {
+			return deleted, err
+		}
+		if err := b.flushUsage(ctx, storage, key); err != nil {
+			return deleted, err
+		}
+
+		stale := b.usage.staleEntities(key, cutoffDay)
+		for _, id := range stale {
+			if err := storage.Delete(ctx, usageEntityPath(key, id)); err != nil {
+				return deleted, fmt.Errorf("failed to delete role usage: %w", err)
+			}
+			deleted++
+		}
+		b.usage.forget(key, cutoffDay, stale)
+	}
+	return deleted, nil
+}
+
+// secondsUntilNextDay returns the seconds until the next UTC day starts
+func secondsUntilNextDay(now time.Time) int64 {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int64(math.Ceil(next.Sub(now).Seconds()))
+}
+
+// enforceQuota counts the exchange against the role's issuance counters and
+// denies it once the role's max_tokens_per_day is used up. The quota error
+// makes Vault respond with HTTP 429.
+func (b *Backend) enforceQuota(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if err := b.loadRoleUsage(ctx, ex.req.Storage, ex.roleName); err != nil {
+		return nil, err
+	}
+
+	ex.usageDay = usageDay(ex.start)
+	if b.usage.reserve(ex.roleName, ex.callerID(), ex.usageDay, ex.role.MaxTokensPerDay) {
+		return nil, nil
+	}
+	ex.usageDay = ""
+
+	retrySeconds := secondsUntilNextDay(time.Now())
+	resp := exchangeError(ErrCodeRateLimited, "role %q has issued its %d tokens for today, retry in %ds", ex.roleName, ex.role.MaxTokensPerDay, retrySeconds)
+	resp.Data["data"].(map[string]any)["retry_after"] = retrySeconds
+	return resp, logical.ErrRateLimitQuotaExceeded
+}
+
+// recordUsage uncounts exchanges that failed after being counted, and
+// persists the counters of roles with a quota so the quota survives restarts
+func (b *Backend) recordUsage(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.usageDay == "" {
+		return nil, nil
+	}
+
+	if !ex.succeeded() {
+		b.usage.release(ex.roleName, ex.callerID(), ex.usageDay)
+	}
+	if ex.role.MaxTokensPerDay > 0 {
+		if err := b.flushUsage(ctx, ex.req.Storage, ex.roleName); err != nil {
+			b.exchangeLogger(ex).Warn("failed to persist role usage", "role", ex.roleName, "error", err)
+		}
+	}
+	return nil, nil
+}
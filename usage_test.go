@@ -0,0 +1,181 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUsageCount tests that daily counts reset on a new day and totals do not
+func TestUsageCount(t *testing.T) {
+	c := &usageCount{}
+	c.add("2026-10-17")
+	c.add("2026-10-17")
+	require.Equal(t, uint64(2), c.today("2026-10-17"))
+	require.Equal(t, uint64(0), c.today("2026-10-18"))
+
+	c.add("2026-10-18")
+	require.Equal(t, uint64(1), c.today("2026-10-18"))
+	require.Equal(t, uint64(3), c.Total)
+
+	// Releasing a token counted on an earlier day only changes the total
+	c.remove("2026-10-17")
+	require.Equal(t, uint64(1), c.today("2026-10-18"))
+	require.Equal(t, uint64(2), c.Total)
+
+	require.Equal(t, int64(3600), secondsUntilNextDay(time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)))
+}
+
+// readRoleStats reads the issuance counters of test-role
+func readRoleStats(t *testing.T, b *Backend, storage logical.Storage) map[string]any {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/test-role/stats",
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	return resp.Data
+}
+
+// TestTokenExchange_DailyQuota tests that a role stops issuing once its daily
+// quota is used up and that its counters are reported and persisted
+func TestTokenExchange_DailyQuota(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"max_tokens_per_day": 2,
+	})
+
+	// Exchanges that fail before they are authorized are not counted
+	resp := exchangeTestToken(t, b, storage, privateKey, "unknown-kid", defaultSubjectClaims())
+	require.True(t, resp.IsError())
+
+	for i := 0; i < 2; i++ {
+		resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+		require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	}
+
+	subjectToken := generateTestJWT(t, privateKey, kid, defaultSubjectClaims())
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "token/test-role",
+		Storage:   storage,
+		EntityID:  "other-entity",
+		Data:      map[string]any{"subject_token": subjectToken},
+	})
+	require.ErrorIs(t, err, logical.ErrRateLimitQuotaExceeded)
+	requireExchangeError(t, resp, ErrCodeRateLimited, true)
+	require.Contains(t, resp.Error().Error(), "issued its 2 tokens for today")
+	require.Greater(t, resp.Data["data"].(map[string]any)["retry_after"], int64(0))
+
+	expected := map[string]any{
+		"day":                 usageDay(time.Now()),
+		"tokens_issued_today": uint64(2),
+		"tokens_issued_total": uint64(2),
+		"max_tokens_per_day":  2,
+		"quota_remaining":     uint64(0),
+		"entities": map[string]any{
+			"test-entity": map[string]any{"tokens_issued_today": uint64(2), "tokens_issued_total": uint64(2)},
+		},
+	}
+	require.Equal(t, expected, readRoleStats(t, b, storage))
+
+	// Counters of roles with a quota are persisted on every exchange
+	b.usage.drop("test-role")
+	require.Equal(t, expected, readRoleStats(t, b, storage))
+
+	// Deleting the role resets its counters
+	_, err = b.HandleRequest(context.Background(), &logical.Request{Operation: logical.DeleteOperation, Path: "role/test-role", Storage: storage})
+	require.NoError(t, err)
+	entry, err := storage.Get(context.Background(), usageStoragePrefix+"test-role")
+	require.NoError(t, err)
+	require.Nil(t, entry)
+	entities, err := storage.List(context.Background(), usageStoragePrefix+"test-role/")
+	require.NoError(t, err)
+	require.Empty(t, entities)
+}
+
+// TestUsage_PeriodicFlush tests that counters of roles without a quota are
+// persisted by the periodic function
+func TestUsage_PeriodicFlush(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	entry, err := storage.Get(context.Background(), usageStoragePrefix+"test-role")
+	require.NoError(t, err)
+	require.Nil(t, entry)
+
+	require.NoError(t, b.periodic(context.Background(), &logical.Request{Storage: storage}))
+
+	b.usage.drop("test-role")
+	stats := readRoleStats(t, b, storage)
+	require.Equal(t, uint64(1), stats["tokens_issued_total"])
+	require.NotContains(t, stats, "quota_remaining")
+}
+
+// TestUsage_FlushedBeforeInvalidation tests that counters this node has not
+// persisted survive their storage entry being invalidated
+func TestUsage_FlushedBeforeInvalidation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, nil)
+
+	resp := exchangeTestToken(t, b, storage, privateKey, kid, defaultSubjectClaims())
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+
+	b.invalidate(context.Background(), usageStoragePrefix+"test-role")
+
+	entry, err := storage.Get(context.Background(), usageStoragePrefix+"test-role")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.Equal(t, uint64(1), readRoleStats(t, b, storage)["tokens_issued_total"])
+}
+
+// TestUsage_EntityEntries tests that each entity's counters have their own
+// storage entry, that counters stored in the role's entry move to their own,
+// and that tidy deletes counters of entities unused within the retention
+func TestUsage_EntityEntries(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	setupTestExchange(t, b, storage, nil)
+
+	now := time.Now()
+	entry, err := logical.StorageEntryJSON(usageStoragePrefix+"test-role", map[string]any{
+		"role": usageCount{Day: usageDay(now), Today: 2, Total: 5},
+		"entities": map[string]*usageCount{
+			"active-entity": {Day: usageDay(now), Today: 2, Total: 2},
+			"stale-entity":  {Day: usageDay(now.Add(-100 * 24 * time.Hour)), Total: 3},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, entry))
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "tidy", Storage: storage})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "tidy failed: %v", resp.Error())
+	require.Equal(t, 1, resp.Data["usage_counters_deleted"])
+
+	entities, err := storage.List(ctx, usageStoragePrefix+"test-role/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"active-entity"}, entities)
+
+	stored := &storedRoleUsage{}
+	entry, err = storage.Get(ctx, usageStoragePrefix+"test-role")
+	require.NoError(t, err)
+	require.NoError(t, entry.DecodeJSON(stored))
+	require.Equal(t, uint64(5), stored.Role.Total)
+	require.Empty(t, stored.Entities)
+
+	// A new node reads the entity's counters from their own entry
+	b.usage.drop("test-role")
+	stats := readRoleStats(t, b, storage)
+	require.Equal(t, uint64(5), stats["tokens_issued_total"])
+	require.Equal(t, map[string]any{
+		"active-entity": map[string]any{"tokens_issued_today": uint64(2), "tokens_issued_total": uint64(2)},
+	}, stats["entities"])
+}
@@ -0,0 +1,76 @@
+package tokenexchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxNotBeforeLeeway bounds not_before_leeway. The leeway only absorbs clock
+// skew between Vault and relying parties, so it never needs to be long.
+const maxNotBeforeLeeway = 10 * time.Minute
+
+// validateValidityWindow checks a role's not_before_leeway and
+// issuance_window
+func validateValidityWindow(leeway, window time.Duration) error {
+	if leeway < 0 || leeway > maxNotBeforeLeeway {
+		return fmt.Errorf("not_before_leeway must be between 0 and %s", maxNotBeforeLeeway)
+	}
+	if window < 0 {
+		return fmt.Errorf("issuance_window must not be negative")
+	}
+	return nil
+}
+
+// tokenValidFrom returns when a token issued at now starts its lifetime: the
+// requested not_before if it is in the future, otherwise now
+func tokenValidFrom(now, notBefore time.Time) time.Time {
+	if notBefore.After(now) {
+		return notBefore
+	}
+	return now
+}
+
+// tokenNotBefore returns the nbf of a token issued at now, and whether the
+// token carries one. Only roles with a not_before_leeway and future-dated
+// tokens do.
+func tokenNotBefore(role *Role, now, notBefore time.Time) (time.Time, bool) {
+	validFrom := tokenValidFrom(now, notBefore)
+	if role.NotBeforeLeeway == 0 && validFrom.Equal(now) {
+		return time.Time{}, false
+	}
+	return validFrom.Add(-role.NotBeforeLeeway), true
+}
+
+// resolveNotBefore reads the requested not_before. A future not_before must
+// fall within the role's issuance_window and before the delegation deadline.
+// Times already passed are treated as now.
+func (b *Backend) resolveNotBefore(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	raw := ex.data.Get("not_before").(string)
+	if raw == "" {
+		return nil, nil
+	}
+	notBefore, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return exchangeError(ErrCodeInvalidRequest, "not_before must be an RFC 3339 timestamp: %v", err), nil
+	}
+
+	now := time.Now()
+	if !notBefore.After(now) {
+		return nil, nil
+	}
+	if ex.role.IssuanceWindow == 0 {
+		return exchangeError(ErrCodeInvalidRequest, "role %q does not issue future-dated tokens", ex.roleName), nil
+	}
+	if notBefore.After(now.Add(ex.role.IssuanceWindow)) {
+		return exchangeError(ErrCodeInvalidRequest, "not_before must be within the role's issuance_window of %s", ex.role.IssuanceWindow), nil
+	}
+	if !ex.notAfter.IsZero() && !notBefore.Before(ex.notAfter) {
+		return exchangeError(ErrCodeInvalidRequest, "not_before must be before the delegation deadline %s", ex.notAfter.Format(time.RFC3339)), nil
+	}
+
+	ex.notBefore = notBefore
+	return nil, nil
+}
@@ -0,0 +1,72 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTokenExchange_ValidityWindow tests the nbf leeway and future-dated
+// tokens within the role's issuance window
+func TestTokenExchange_ValidityWindow(t *testing.T) {
+	b, storage := getTestBackend(t)
+	privateKey, kid := setupTestExchange(t, b, storage, map[string]any{
+		"ttl":               "1h",
+		"not_before_leeway": "30s",
+		"issuance_window":   "24h",
+	})
+
+	exchange := func(notBefore string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/test-role",
+			Storage:   storage,
+			EntityID:  "test-entity",
+			Data: map[string]any{
+				"subject_token": generateTestJWT(t, privateKey, kid, defaultSubjectClaims()),
+				"not_before":    notBefore,
+			},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+	claimTime := func(claims map[string]any, name string) int64 {
+		return int64(claims[name].(float64))
+	}
+
+	resp := exchange("")
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	claims := parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, claimTime(claims, "iat")-30, claimTime(claims, "nbf"))
+	require.Equal(t, claimTime(claims, "iat")+3600, claimTime(claims, "exp"))
+
+	start := time.Now().Add(6 * time.Hour).Truncate(time.Second)
+	resp = exchange(start.Format(time.RFC3339))
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.Equal(t, start.Add(-30*time.Second).Unix(), resp.Data["not_before"])
+	claims = parseIssuedToken(t, b, storage, resp.Data["token"].(string))
+	require.Equal(t, start.Add(-30*time.Second).Unix(), claimTime(claims, "nbf"))
+	require.Equal(t, start.Add(time.Hour).Unix(), claimTime(claims, "exp"))
+
+	resp = exchange(time.Now().Add(48 * time.Hour).Format(time.RFC3339))
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	resp = exchange("tomorrow")
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+
+	// Without an issuance window tokens cannot be future-dated, and without
+	// a leeway they carry no nbf
+	resp = writeProfileRole(t, b, storage, "", nil)
+	require.False(t, resp.IsError())
+	resp = exchange(start.Format(time.RFC3339))
+	requireExchangeError(t, resp, ErrCodeInvalidRequest, false)
+	resp = exchange("")
+	require.False(t, resp.IsError(), "exchange failed: %v", resp.Error())
+	require.NotContains(t, parseIssuedToken(t, b, storage, resp.Data["token"].(string)), "nbf")
+
+	resp = writeProfileRole(t, b, storage, "", map[string]any{"not_before_leeway": "1h"})
+	require.True(t, resp.IsError())
+}
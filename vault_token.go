@@ -0,0 +1,196 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tokenTypeVaultToken is the subject token type of Vault tokens. It is not
+// an RFC 8693 URN because Vault tokens have none.
+const tokenTypeVaultToken = "vault_token"
+
+// defaultVaultTokenLookupTimeout bounds a subject Vault token lookup
+const defaultVaultTokenLookupTimeout = 10 * time.Second
+
+// maxVaultAPIResponseSize bounds the token lookup responses read from api_addr
+const maxVaultAPIResponseSize = 1 << 20
+
+// errVaultTokenLookupUnavailable marks subject token validation failures
+// caused by Vault's API being unreachable rather than by the token itself
+var errVaultTokenLookupUnavailable = errors.New("vault token lookup unavailable")
+
+// vaultTokenLookup is the part of a token lookup-self response used to build
+// subject claims
+type vaultTokenLookup struct {
+	Data struct {
+		Accessor   string `json:"accessor"`
+		EntityID   string `json:"entity_id"`
+		IssueTime  string `json:"issue_time"`
+		ExpireTime string `json:"expire_time"`
+	} `json:"data"`
+}
+
+// vaultAPIHTTPClient builds the HTTP client used to call Vault's API at
+// api_addr, to look tokens up and to reach transit, trusting api_ca_pem in
+// place of the system roots when it is set. Redirects are not followed so
+// Vault tokens are never sent elsewhere.
+func (c *Config) vaultAPIHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.APICAPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.APICAPEM)) {
+			return nil, fmt.Errorf("api_ca_pem contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// getVaultAPIClient returns the api_addr HTTP client for the config, built
+// once per config version
+func (b *Backend) getVaultAPIClient(config *Config) (*http.Client, error) {
+	b.cacheLock.RLock()
+	client := b.vaultAPIClient
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := config.vaultAPIHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.vaultAPIClient = client
+	}
+	b.cacheLock.Unlock()
+
+	return client, nil
+}
+
+// lookupVaultToken validates a subject Vault token by looking it up with
+// itself at api_addr. The SystemView gives plugins no way to look up a token,
+// so the lookup goes through Vault's HTTP API, and the token must be allowed
+// to read auth/token/lookup-self, as the default policy allows.
+func lookupVaultToken(ctx context.Context, client *http.Client, config *Config, token string) (*vaultTokenLookup, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultVaultTokenLookupTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.APIAddr+"/v1/auth/token/lookup-self", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token lookup request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set("X-Vault-Request", "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errVaultTokenLookupUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultAPIResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read token lookup response: %w", errVaultTokenLookupUnavailable, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("token is invalid or cannot look itself up")
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: token lookup failed with status %d", errVaultTokenLookupUnavailable, resp.StatusCode)
+	}
+
+	lookup := &vaultTokenLookup{}
+	if err := json.Unmarshal(body, lookup); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode token lookup response: %w", errVaultTokenLookupUnavailable, err)
+	}
+
+	return lookup, nil
+}
+
+// vaultTokenClaims builds subject claims for a Vault token from its lookup
+// and its entity: sub is the entity ID, name, metadata and aliases describe
+// the entity, and jti is the token accessor
+func vaultTokenClaims(config *Config, lookup *vaultTokenLookup, entity *logical.Entity) (map[string]any, error) {
+	if lookup.Data.ExpireTime == "" {
+		return nil, fmt.Errorf("token does not expire")
+	}
+
+	claims := map[string]any{
+		"sub":      entity.ID,
+		"iss":      config.APIAddr,
+		"name":     entity.Name,
+		"metadata": entity.Metadata,
+		"aliases":  entityAliasesContext(entity),
+	}
+	if lookup.Data.Accessor != "" {
+		claims["jti"] = lookup.Data.Accessor
+	}
+
+	for claim, value := range map[string]string{"iat": lookup.Data.IssueTime, "exp": lookup.Data.ExpireTime} {
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token lookup time %q: %w", value, err)
+		}
+		claims[claim] = float64(t.Unix())
+	}
+
+	return claims, nil
+}
+
+// validateVaultToken looks up a subject Vault token and resolves its entity
+// through the SystemView
+func (b *Backend) validateVaultToken(ctx context.Context, config *Config, token string) (map[string]any, error) {
+	client, err := b.getVaultAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup, err := lookupVaultToken(ctx, client, config, token)
+	if err != nil {
+		return nil, err
+	}
+	if lookup.Data.EntityID == "" {
+		return nil, fmt.Errorf("token has no entity")
+	}
+
+	entity, err := b.identityView(ctx).EntityInfo(lookup.Data.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entity info: %w", err)
+	}
+	if entity == nil {
+		return nil, fmt.Errorf("entity %q not found", lookup.Data.EntityID)
+	}
+	if entity.Disabled {
+		return nil, fmt.Errorf("entity %q is disabled", entity.ID)
+	}
+
+	return vaultTokenClaims(config, lookup, entity)
+}
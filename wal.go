@@ -0,0 +1,139 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// walKindKeyRotate is the kind of WAL entries of key rotations. Key
+// creation needs no WAL: nothing outside storage changes, and the key is
+// written in a single storage entry, so a crash leaves the whole key or none.
+const walKindKeyRotate = "key_rotate"
+
+// walRollbackMinAge is how old a WAL entry must be before the periodic
+// rollback replays it. Key operations hold the key lock for seconds at
+// most, so older entries were left by a crash.
+const walRollbackMinAge = 5 * time.Minute
+
+// keyWAL records a key rotation in progress
+type keyWAL struct {
+	Name string `json:"name"`
+
+	// Version is the key's version before the rotation
+	Version int `json:"version"`
+}
+
+// beginKeyWAL writes a WAL entry before a key rotation starts. The entry is
+// deleted by endKeyWAL once the key is written.
+func beginKeyWAL(ctx context.Context, storage logical.Storage, kind string, wal *keyWAL) (string, error) {
+	id, err := framework.PutWAL(ctx, storage, kind, wal)
+	if err != nil {
+		return "", fmt.Errorf("failed to write WAL entry: %w", err)
+	}
+	return id, nil
+}
+
+// endKeyWAL deletes the WAL entry of a completed key rotation. A failed
+// delete is harmless: the replay finds the operation complete.
+func (b *Backend) endKeyWAL(ctx context.Context, storage logical.Storage, id string) {
+	if err := framework.DeleteWAL(ctx, storage, id); err != nil {
+		b.Logger().Warn("failed to delete WAL entry, it is replayed later", "id", id, "error", err)
+	}
+}
+
+// walRollback finishes or rolls back a key rotation interrupted by a crash.
+// Vault calls it for WAL entries older than walRollbackMinAge, and the
+// entries are also replayed when the plugin starts.
+func (b *Backend) walRollback(ctx context.Context, req *logical.Request, kind string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode WAL entry: %w", err)
+	}
+	wal := &keyWAL{}
+	if err := json.Unmarshal(encoded, wal); err != nil {
+		return fmt.Errorf("failed to decode WAL entry: %w", err)
+	}
+
+	if kind != walKindKeyRotate {
+		return fmt.Errorf("unknown WAL entry kind %q", kind)
+	}
+	return b.rollbackKeyRotation(ctx, req.Storage, wal)
+}
+
+// rollbackKeyRotation completes an interrupted rotation of a transit key
+// whose transit engine already rotated, so the key is not left behind the
+// transit key. Internal keys are rotated in memory and written at once, so
+// an interrupted rotation left the previous version in place and is rolled
+// back by dropping the entry.
+func (b *Backend) rollbackKeyRotation(ctx context.Context, storage logical.Storage, wal *keyWAL) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, err := b.getKey(ctx, storage, wal.Name)
+	if err != nil {
+		return err
+	}
+	if key == nil || key.Version != wal.Version || !key.isTransit() {
+		return nil
+	}
+
+	config, err := b.getConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	client, err := b.newTransitClient(config, key)
+	if err != nil {
+		return err
+	}
+	version, publicKey, err := client.readKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read transit key: %w", err)
+	}
+	if version <= key.Version {
+		return nil
+	}
+
+	now := time.Now()
+	certificate, generated := key.Certificate, key.CertificateGenerated
+	key.adoptTransitVersion(config, version, publicKey, now)
+	if err := key.rotateCertificate(certificate, generated, now); err != nil {
+		return err
+	}
+	if err := b.putKey(ctx, storage, key); err != nil {
+		return err
+	}
+	b.Logger().Info("completed interrupted key rotation", "key", key.Name, "key_id", key.KeyID)
+	return nil
+}
+
+// replayWAL finishes or rolls back every key rotation left in the WAL, so
+// a crash is recovered from when the plugin starts rather than after
+// walRollbackMinAge
+func (b *Backend) replayWAL(ctx context.Context, storage logical.Storage) error {
+	ids, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("failed to list WAL entries: %w", err)
+	}
+
+	for _, id := range ids {
+		entry, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			return fmt.Errorf("failed to read WAL entry: %w", err)
+		}
+		if entry == nil {
+			continue
+		}
+		if err := b.walRollback(ctx, &logical.Request{Storage: storage}, entry.Kind, entry.Data); err != nil {
+			return fmt.Errorf("failed to replay %s WAL entry: %w", entry.Kind, err)
+		}
+		if err := framework.DeleteWAL(ctx, storage, id); err != nil {
+			return fmt.Errorf("failed to delete WAL entry: %w", err)
+		}
+	}
+	return nil
+}
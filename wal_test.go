@@ -0,0 +1,62 @@
+package tokenexchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/require"
+)
+
+// TestKeyRotationWAL tests that rotations leave no WAL entry behind and that
+// replaying an interrupted rotation finishes or rolls it back
+func TestKeyRotationWAL(t *testing.T) {
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	server, transit := createMockTransitServer(t)
+	require.Nil(t, writeJWKSConfig(t, b, storage, map[string]any{"api_addr": server.URL, "transit_token": testTransitToken}))
+
+	createTestKey(t, b, storage, "internal-key")
+	resp := createTransitKey(t, b, storage, "transit-key", AlgorithmRS256)
+	require.False(t, resp.IsError(), "key creation failed: %v", resp.Error())
+
+	_, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.UpdateOperation, Path: "key/internal-key/rotate", Storage: storage})
+	require.NoError(t, err)
+	ids, err := framework.ListWAL(ctx, storage)
+	require.NoError(t, err)
+	require.Empty(t, ids, "completed rotations delete their WAL entry")
+
+	// A crash after the transit engine rotated, but before the key was written
+	transit.addVersion()
+	_, err = beginKeyWAL(ctx, storage, walKindKeyRotate, &keyWAL{Name: "transit-key", Version: 1})
+	require.NoError(t, err)
+
+	// A crash before an internal key was written
+	_, err = beginKeyWAL(ctx, storage, walKindKeyRotate, &keyWAL{Name: "internal-key", Version: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, b.replayWAL(ctx, storage))
+
+	key, err := b.getKey(ctx, storage, "transit-key")
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version, "the rotation is finished with the transit key's new version")
+	require.Equal(t, "transit-key-v2", key.KeyID)
+	require.Len(t, key.PreviousVersions, 1)
+
+	key, err = b.getKey(ctx, storage, "internal-key")
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version, "the rotation is rolled back")
+
+	ids, err = framework.ListWAL(ctx, storage)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	// Replaying a rotation that already completed changes nothing
+	_, err = beginKeyWAL(ctx, storage, walKindKeyRotate, &keyWAL{Name: "transit-key", Version: 1})
+	require.NoError(t, err)
+	require.NoError(t, b.replayWAL(ctx, storage))
+	key, err = b.getKey(ctx, storage, "transit-key")
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version)
+}
@@ -0,0 +1,218 @@
+package tokenexchange
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultAuthorizationWebhookTimeout bounds an authorization webhook call when
+// authorization_webhook_timeout is unset
+const defaultAuthorizationWebhookTimeout = 5 * time.Second
+
+// maxAuthorizationWebhookResponse bounds the size of a webhook response
+const maxAuthorizationWebhookResponse = 1 << 20
+
+// errAuthorizationWebhookUnavailable marks webhook failures caused by the
+// webhook rather than by its decision
+var errAuthorizationWebhookUnavailable = errors.New("authorization webhook unavailable")
+
+// authorizationWebhookRequest is the body sent to the authorization webhook.
+// The input wrapper matches the OPA data API.
+type authorizationWebhookRequest struct {
+	Input authorizationWebhookInput `json:"input"`
+}
+
+// authorizationWebhookInput describes the exchange to be authorized
+type authorizationWebhookInput struct {
+	Role    string         `json:"role"`
+	Scopes  []string       `json:"scopes"`
+	Subject map[string]any `json:"subject"`
+	Entity  map[string]any `json:"entity"`
+	Request map[string]any `json:"request"`
+}
+
+// authorizationWebhookDecision is a webhook's decision. Webhooks answer with
+// allow, or with an OPA result that is a bool or has allow.
+type authorizationWebhookDecision struct {
+	Allow  *bool           `json:"allow"`
+	Reason string          `json:"reason"`
+	Result json.RawMessage `json:"result"`
+}
+
+// authorizationWebhookTimeout returns the webhook timeout, defaulting for
+// configs written before authorization_webhook_timeout existed
+func (c *Config) authorizationWebhookTimeout() time.Duration {
+	if c.AuthorizationWebhookTimeout == 0 {
+		return defaultAuthorizationWebhookTimeout
+	}
+	return c.AuthorizationWebhookTimeout
+}
+
+// authorizationWebhookHTTPClient builds the HTTP client used to call the
+// authorization webhook, trusting authorization_webhook_ca_pem in place of
+// the system roots when it is set. Redirects are not followed so the webhook
+// token is never sent to another endpoint.
+func (c *Config) authorizationWebhookHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.AuthorizationWebhookCAPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.AuthorizationWebhookCAPEM)) {
+			return nil, fmt.Errorf("authorization_webhook_ca_pem contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// getAuthorizationWebhookClient returns the authorization webhook HTTP client
+// for the config, built once per config version
+func (b *Backend) getAuthorizationWebhookClient(config *Config) (*http.Client, error) {
+	b.cacheLock.RLock()
+	client := b.authorizationWebhookClient
+	generation := b.cacheGeneration
+	b.cacheLock.RUnlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := config.authorizationWebhookHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheLock.Lock()
+	if b.cacheGeneration == generation {
+		b.authorizationWebhookClient = client
+	}
+	b.cacheLock.Unlock()
+
+	return client, nil
+}
+
+// allowed returns whether the decision allows the exchange, and its reason
+func (d *authorizationWebhookDecision) allowed() (bool, string, error) {
+	if len(d.Result) > 0 {
+		var result bool
+		if err := json.Unmarshal(d.Result, &result); err == nil {
+			return result, d.Reason, nil
+		}
+		nested := &authorizationWebhookDecision{}
+		if err := json.Unmarshal(d.Result, nested); err != nil || nested.Allow == nil {
+			return false, "", fmt.Errorf("result must be a bool or an object with allow")
+		}
+		return *nested.Allow, nested.Reason, nil
+	}
+	if d.Allow == nil {
+		return false, "", fmt.Errorf("response has no allow or result")
+	}
+	return *d.Allow, d.Reason, nil
+}
+
+// callAuthorizationWebhook asks the configured webhook whether an exchange
+// may be issued. It returns the decision and the webhook's reason.
+func callAuthorizationWebhook(ctx context.Context, client *http.Client, config *Config, input *authorizationWebhookInput) (bool, string, error) {
+	body, err := json.Marshal(&authorizationWebhookRequest{Input: *input})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to encode webhook request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.authorizationWebhookTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.AuthorizationWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if config.AuthorizationWebhookToken != "" {
+		req.Header.Set("Authorization", "Bearer "+config.AuthorizationWebhookToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("%w: %w", errAuthorizationWebhookUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// Server errors may clear up, while other statuses are a refusal
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return false, "", fmt.Errorf("%w: webhook responded with status %d", errAuthorizationWebhookUnavailable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Sprintf("webhook responded with status %d", resp.StatusCode), nil
+	}
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxAuthorizationWebhookResponse))
+	if err != nil {
+		return false, "", fmt.Errorf("%w: unable to read webhook response: %w", errAuthorizationWebhookUnavailable, err)
+	}
+	decision := &authorizationWebhookDecision{}
+	if err := json.Unmarshal(respBody, decision); err != nil {
+		return false, "", fmt.Errorf("failed to decode webhook response: %w", err)
+	}
+	allowed, reason, err := decision.allowed()
+	if err != nil {
+		return false, "", fmt.Errorf("invalid webhook response: %w", err)
+	}
+	return allowed, reason, nil
+}
+
+// authorizeWebhook denies exchanges that the configured authorization
+// webhook does not allow
+func (b *Backend) authorizeWebhook(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	if ex.config.AuthorizationWebhookURL == "" {
+		return nil, nil
+	}
+
+	if resp, err := b.loadGroups(ctx, ex); resp != nil || err != nil {
+		return resp, err
+	}
+
+	client, err := b.getAuthorizationWebhookClient(ex.config)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, reason, err := callAuthorizationWebhook(ctx, client, ex.config, &authorizationWebhookInput{
+		Role:    ex.roleName,
+		Scopes:  ex.scopes,
+		Subject: ex.subjectClaims,
+		Entity:  policyEntity(ex.entity, ex.groups),
+		Request: policyRequest(ex),
+	})
+	if errors.Is(err, errAuthorizationWebhookUnavailable) {
+		return exchangeError(ErrCodeTemporarilyUnavailable, "failed to authorize exchange: %v", err), nil
+	}
+	if err != nil {
+		return exchangeError(ErrCodeServerError, "failed to authorize exchange: %v", err), nil
+	}
+	if !allowed {
+		if reason == "" {
+			reason = "no reason given"
+		}
+		return exchangeError(ErrCodeAccessDenied, "exchange with role %q denied by the authorization webhook: %s", ex.roleName, reason), nil
+	}
+	return nil, nil
+}
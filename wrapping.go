@@ -0,0 +1,24 @@
+package tokenexchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// resolveWrapTTL decides whether the exchange response is response-wrapped.
+// The role's wrap_ttl forces wrapping; a caller may ask for wrapping with
+// wrap_ttl, no longer than the role allows.
+func (b *Backend) resolveWrapTTL(ctx context.Context, ex *exchange) (*logical.Response, error) {
+	requested := time.Duration(ex.data.Get("wrap_ttl").(int)) * time.Second
+	if requested < 0 {
+		return exchangeError(ErrCodeInvalidRequest, "wrap_ttl must not be negative"), nil
+	}
+
+	ex.wrapTTL = ex.role.WrapTTL
+	if requested > 0 && (ex.wrapTTL == 0 || requested < ex.wrapTTL) {
+		ex.wrapTTL = requested
+	}
+	return nil, nil
+}